@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"flag"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"golang.org/x/net/http2"
+
+	"github.com/anyfld/vistra-operation-control-room/pkg/fdsim"
+)
+
+const (
+	defaultServerURL  = "http://localhost:8080"
+	unregisterTimeout = 5 * time.Second
+)
+
+func main() {
+	config := fdsim.DefaultConfig()
+	mode := string(config.Mode)
+
+	serverURL := flag.String("server", defaultServerURL, "Server URL")
+	flag.IntVar(&config.Cameras, "cameras", config.Cameras, "Number of virtual cameras")
+	flag.StringVar(&config.NamePrefix, "name", config.NamePrefix, "Camera name prefix")
+	flag.StringVar(&config.MasterMfID, "master-mf", config.MasterMfID, "Master MF ID")
+	flag.StringVar(&mode, "mode", mode, "Command source: polling, stream or both")
+	flag.DurationVar(&config.PollInterval, "poll-interval", config.PollInterval, "Polling interval")
+	flag.DurationVar(&config.StateInterval, "state-interval", config.StateInterval, "Camera state report interval")
+	flag.DurationVar(&config.TaskDuration, "task-duration", config.TaskDuration, "Duration of tasks without PTZ")
+	flag.Float64Var(&config.PanSpeedDps, "pan-speed", config.PanSpeedDps, "Max pan speed (deg/s)")
+	flag.Float64Var(&config.TiltSpeedDps, "tilt-speed", config.TiltSpeedDps, "Max tilt speed (deg/s)")
+	flag.Float64Var(&config.ZoomSpeedPerSec, "zoom-speed", config.ZoomSpeedPerSec, "Max zoom speed (x/s)")
+	flag.Float64Var(&config.Faults.DropRate, "drop-rate", 0, "Probability of dropping a command")
+	flag.Float64Var(&config.Faults.ErrorRate, "error-rate", 0, "Probability of failing a command")
+	flag.Float64Var(&config.Faults.SlowRate, "slow-rate", 0, "Probability of a slow move")
+	flag.Float64Var(&config.Faults.SlowFactor, "slow-factor", config.Faults.SlowFactor, "Speed factor of a slow move")
+	flag.Uint64Var(&config.Seed, "seed", 0, "Random seed for fault injection")
+	scriptPath := flag.String("script", "", "Run a YAML/JSON script and exit (non-zero on failure)")
+	flag.Parse()
+
+	config.Mode = fdsim.Mode(mode)
+
+	if err := run(*serverURL, config, *scriptPath); err != nil {
+		log.Printf("fdsim: %v", err)
+		os.Exit(1)
+	}
+}
+
+func run(serverURL string, config fdsim.Config, scriptPath string) error {
+	var script *fdsim.Script
+
+	if scriptPath != "" {
+		data, err := os.ReadFile(scriptPath)
+		if err != nil {
+			return err
+		}
+
+		script, err = fdsim.ParseScript(data)
+		if err != nil {
+			return err
+		}
+	}
+
+	sim, err := fdsim.New(config, newH2CClient(), serverURL)
+	if err != nil {
+		return err
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	if err := sim.Start(ctx); err != nil {
+		return err
+	}
+
+	defer func() {
+		stop()
+		sim.Wait()
+
+		unregisterCtx, cancel := context.WithTimeout(context.Background(), unregisterTimeout)
+		defer cancel()
+
+		sim.Unregister(unregisterCtx)
+	}()
+
+	for _, camera := range sim.Cameras() {
+		log.Printf("fdsim camera registered: camera_id=%s", camera.ID())
+	}
+
+	if script == nil {
+		<-ctx.Done()
+
+		return nil
+	}
+
+	err = sim.RunScript(ctx, script)
+	if errors.Is(err, context.Canceled) {
+		return nil
+	}
+
+	return err
+}
+
+// newH2CClient は制御コマンドの双方向ストリームのため、TLSなしのHTTP/2で接続するクライアントを返します。
+func newH2CClient() *http.Client {
+	return &http.Client{ //nolint:exhaustruct
+		Transport: &http2.Transport{ //nolint:exhaustruct
+			AllowHTTP: true,
+			DialTLSContext: func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+				var dialer net.Dialer
+
+				return dialer.DialContext(ctx, network, addr)
+			},
+		},
+	}
+}
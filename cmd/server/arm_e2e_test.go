@@ -0,0 +1,337 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"connectrpc.com/connect"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/types/known/structpb"
+
+	protov1 "github.com/anyfld/vistra-operation-control-room/gen/proto/v1"
+	"github.com/anyfld/vistra-operation-control-room/pkg/transport/handlers"
+	"github.com/anyfld/vistra-operation-control-room/pkg/transport/infrastructure"
+)
+
+type armFixture struct {
+	*keyframeFixture
+}
+
+// newArmFixture は2関節（±90度、最高 120度/秒）のアームを持つカメラを登録します。
+func newArmFixture(t *testing.T) *armFixture {
+	t.Helper()
+
+	camera := testCamera("arm-camera", &protov1.CameraCapabilities{SupportsPtz: true, SupportsArm: true})
+	camera.Metadata = map[string]string{
+		infrastructure.CameraMetadataArmJointLimits: "-90:90, -90:90",
+		infrastructure.CameraMetadataArmJointSpeeds: "120",
+	}
+
+	return &armFixture{keyframeFixture: newKeyframeFixture(t, camera)}
+}
+
+func (f *armFixture) sendArm(
+	ctx context.Context,
+	t *testing.T,
+	angles []float32,
+	speed float32,
+) *protov1.StreamControlCommandsResponse {
+	t.Helper()
+
+	resp, err := f.fd.StreamControlCommands(ctx, connect.NewRequest(&protov1.StreamControlCommandsRequest{
+		Message: &protov1.StreamControlCommandsRequest_Command{Command: &protov1.ControlCommand{
+			CameraId:      f.cameraID,
+			Type:          protov1.ControlCommandType_CONTROL_COMMAND_TYPE_ARM,
+			ArmParameters: &protov1.ArmParameters{JointAngles: angles, Speed: speed},
+		}},
+	}))
+	require.NoError(t, err)
+
+	return resp.Msg
+}
+
+func (f *armFixture) enqueueArmMove(
+	ctx context.Context,
+	t *testing.T,
+	fields map[string]any,
+) (*structpb.Struct, error) {
+	t.Helper()
+
+	return f.call(ctx, t, handlers.FDServiceEnqueueArmMoveProcedure, fields)
+}
+
+func isArmCommand(command *protov1.ControlCommand) bool {
+	return command.GetType() == protov1.ControlCommandType_CONTROL_COMMAND_TYPE_ARM
+}
+
+func TestArmCommand_ValidatesAgainstCapabilities(t *testing.T) {
+	t.Parallel()
+
+	fixture := newArmFixture(t)
+
+	ctx, cancel := context.WithTimeout(t.Context(), 5*time.Second)
+	defer cancel()
+
+	// 受け付けた命令はFDへ配信され、結果はFDの報告を待つ
+	require.Nil(t, fixture.sendArm(ctx, t, []float32{10, -20}, 0.5).GetResult())
+	require.Equal(t, []float32{10, -20}, fixture.nextCommand(t, isArmCommand).GetArmParameters().GetJointAngles())
+
+	// 関節の数が合わない
+	result := fixture.sendArm(ctx, t, []float32{10}, 0.5).GetResult()
+	require.False(t, result.GetSuccess())
+	require.Contains(t, result.GetErrorMessage(), "joint angles")
+
+	// 可動範囲外
+	require.NotNil(t, fixture.sendArm(ctx, t, []float32{10, 120}, 0.5).GetResult())
+
+	// 速度の範囲外
+	require.NotNil(t, fixture.sendArm(ctx, t, []float32{10, 20}, 1.5).GetResult())
+
+	// 安全停止は関節角度を省略できる
+	require.Nil(t, fixture.sendArm(ctx, t, nil, 0).GetResult())
+
+	_, err := fixture.enqueueArmMove(ctx, t, map[string]any{"joint_angles": []any{10.0, 20.0}, "speed": 0.0})
+	require.Equal(t, connect.CodeInvalidArgument, connect.CodeOf(err))
+
+	_, err = fixture.enqueueArmMove(ctx, t, map[string]any{
+		"joint_angles": []any{10.0, 20.0}, "speed": 0.5, "layer": "COMMAND_LAYER_ARM",
+	})
+	require.Equal(t, connect.CodeInvalidArgument, connect.CodeOf(err))
+
+	plain := fixture.repos.camera.RegisterCamera(&protov1.RegisterCameraRequest{
+		Name:         "plain-camera",
+		Mode:         protov1.CameraMode_CAMERA_MODE_AUTONOMOUS,
+		MasterMfId:   "mf-arm-1",
+		Capabilities: &protov1.CameraCapabilities{SupportsPtz: true},
+	})
+
+	_, err = fixture.enqueueArmMove(ctx, t, map[string]any{
+		"camera_id": plain.GetId(), "joint_angles": []any{10.0, 20.0}, "speed": 0.5,
+	})
+	require.Equal(t, connect.CodeFailedPrecondition, connect.CodeOf(err))
+}
+
+func TestArmMove_DispatchesInterpolatedWaypointsFromQueue(t *testing.T) {
+	t.Parallel()
+
+	fixture := newArmFixture(t)
+
+	ctx, cancel := context.WithTimeout(t.Context(), 5*time.Second)
+	defer cancel()
+
+	require.Nil(t, fixture.sendArm(ctx, t, []float32{0, 0}, 1).GetResult())
+	fixture.nextCommand(t, isArmCommand)
+
+	// 先に実行対象になるシネマティック命令の後ろに並べる
+	blockingID, accepted := fixture.repos.ptz.EnqueueCinematicCommand(
+		fixture.cameraID,
+		&protov1.CinematographyInstruction{CameraId: fixture.cameraID, ShotType: protov1.ShotType_SHOT_TYPE_WIDE},
+	)
+	require.True(t, accepted)
+
+	resp, err := fixture.enqueueArmMove(ctx, t, map[string]any{"joint_angles": []any{60.0, -30.0}, "speed": 1.0})
+	require.NoError(t, err)
+
+	// 60度を最高 120度/秒 で smoothstep 補間するため 750ms、100ms ごとに8点
+	taskID := resp.GetFields()["task_id"].GetStringValue()
+	require.InDelta(t, 750, resp.GetFields()["duration_ms"].GetNumberValue(), 0)
+
+	waypoints := resp.GetFields()["waypoints"].GetListValue().GetValues()
+	require.Len(t, waypoints, 8)
+
+	// 実行対象になるまでは配信しない
+	fixture.requireNoCommand(t, anyCommand, 150*time.Millisecond)
+
+	// 前のタスクの完了を報告すると、次のタスクとして実行対象になる
+	task := fixture.startTask(t, blockingID)
+	require.Equal(t, taskID, task.GetTaskId())
+	require.Nil(t, task.GetPtzCommand())
+
+	previous := float32(0)
+
+	for i := range waypoints {
+		command := fixture.nextCommand(t, isArmCommand)
+		require.True(t, strings.HasPrefix(command.GetCommandId(), taskID))
+
+		angles := command.GetArmParameters().GetJointAngles()
+		require.Greater(t, angles[0], previous)
+		require.InDelta(t, -angles[0]/2, angles[1], 0.01)
+
+		previous = angles[0]
+
+		if i == len(waypoints)-1 {
+			require.Equal(t, []float32{60, -30}, angles)
+		}
+	}
+
+	// 完了を報告すると次のタスクへ進み、安全停止は送らない
+	require.Nil(t, fixture.startTask(t, taskID))
+	fixture.requireNoCommand(t, anyCommand, 150*time.Millisecond)
+}
+
+func TestArmMove_ManualPTZInterruptsWithSafetyStop(t *testing.T) {
+	t.Parallel()
+
+	fixture := newArmFixture(t)
+
+	ctx, cancel := context.WithTimeout(t.Context(), 5*time.Second)
+	defer cancel()
+
+	require.Nil(t, fixture.sendArm(ctx, t, []float32{0, 0}, 1).GetResult())
+	fixture.nextCommand(t, isArmCommand)
+
+	resp, err := fixture.enqueueArmMove(ctx, t, map[string]any{"joint_angles": []any{90.0, 90.0}, "speed": 0.1})
+	require.NoError(t, err)
+
+	taskID := resp.GetFields()["task_id"].GetStringValue()
+
+	fixture.startTask(t, "")
+	first := fixture.nextCommand(t, isArmCommand)
+	require.Equal(t, taskID+"-1", first.GetCommandId())
+
+	_, accepted := fixture.repos.ptz.EnqueuePTZCommand(fixture.cameraID, &protov1.PTZCommand{
+		OperationType: protov1.PTZOperationType_PTZ_OPERATION_TYPE_RELATIVE_MOVE,
+		Command: &protov1.PTZCommand_RelativeMove{
+			RelativeMove: &protov1.RelativeMoveCommand{Translation: &protov1.PTZTranslation{PanDelta: 5}},
+		},
+	})
+	require.True(t, accepted)
+
+	// 残りのウェイポイントを破棄し、最後に配信した姿勢で停止させる
+	last := first
+	stop := fixture.nextCommand(t, isArmCommand)
+
+	for stop.GetCommandId() != taskID+"-stop" {
+		last, stop = stop, fixture.nextCommand(t, isArmCommand)
+	}
+
+	require.Zero(t, stop.GetArmParameters().GetSpeed())
+	require.Equal(t, last.GetArmParameters().GetJointAngles(), stop.GetArmParameters().GetJointAngles())
+	require.Less(t, last.GetArmParameters().GetJointAngles()[0], float32(90))
+}
+
+func TestArmMove_ManualLayerPreemptsCinematicQueue(t *testing.T) {
+	t.Parallel()
+
+	fixture := newArmFixture(t)
+
+	ctx, cancel := context.WithTimeout(t.Context(), 5*time.Second)
+	defer cancel()
+
+	_, accepted := fixture.repos.ptz.EnqueueCinematicCommand(fixture.cameraID, &protov1.CinematographyInstruction{
+		CameraId: fixture.cameraID,
+		ShotType: protov1.ShotType_SHOT_TYPE_WIDE,
+	})
+	require.True(t, accepted)
+
+	resp, err := fixture.enqueueArmMove(ctx, t, map[string]any{
+		"joint_angles": []any{30.0, 30.0}, "speed": 1.0, "layer": "COMMAND_LAYER_PTZ",
+	})
+	require.NoError(t, err)
+
+	status := fixture.repos.ptz.GetQueueStatus(fixture.cameraID)
+	require.Zero(t, status.GetCinematicQueueSize())
+	require.Equal(t, uint32(1), status.GetPtzQueueSize())
+
+	task := fixture.startTask(t, "")
+	require.Equal(t, resp.GetFields()["task_id"].GetStringValue(), task.GetTaskId())
+	require.Equal(t, protov1.CommandLayer_COMMAND_LAYER_PTZ, task.GetLayer())
+
+	// 姿勢が不明なため、目標の姿勢だけを送る
+	require.Equal(t, []float32{30, 30}, fixture.nextCommand(t, isArmCommand).GetArmParameters().GetJointAngles())
+}
+
+func TestArmMove_StreamResultOfLastWaypointFinishesTask(t *testing.T) {
+	t.Parallel()
+
+	fixture := newArmFixture(t)
+
+	ctx, cancel := context.WithTimeout(t.Context(), 5*time.Second)
+	defer cancel()
+
+	resp, err := fixture.enqueueArmMove(ctx, t, map[string]any{"joint_angles": []any{10.0, 10.0}, "speed": 1.0})
+	require.NoError(t, err)
+
+	taskID := resp.GetFields()["task_id"].GetStringValue()
+	waypoints := resp.GetFields()["waypoints"].GetListValue().GetValues()
+
+	require.Equal(t, taskID, fixture.startTask(t, "").GetTaskId())
+
+	// ストリームだけを使うFDはウェイポイントの結果を報告し、タスク自体の完了は報告しない
+	for range waypoints {
+		command := fixture.nextCommand(t, isArmCommand)
+
+		_, err := fixture.fd.StreamControlCommands(ctx, connect.NewRequest(&protov1.StreamControlCommandsRequest{
+			Message: &protov1.StreamControlCommandsRequest_Result{
+				Result: &protov1.ControlCommandResult{CommandId: command.GetCommandId(), Success: true},
+			},
+		}))
+		require.NoError(t, err)
+	}
+
+	require.Eventually(t, func() bool {
+		status := fixture.repos.ptz.GetQueueStatus(fixture.cameraID)
+
+		return status.GetPtzQueueSize() == 0 && status.GetCinematicQueueSize() == 0 &&
+			status.GetExecutingTask() == nil
+	}, 2*time.Second, 10*time.Millisecond)
+
+	fixture.requireNoCommand(t, anyCommand, 150*time.Millisecond)
+}
+
+func TestArmMove_WaypointTimeoutEndsTaskAndStopsRemainingWaypoints(t *testing.T) {
+	t.Parallel()
+
+	fixture := newArmFixture(t)
+
+	taskEvents := fixture.repos.ptz.SubscribeTaskEvents()
+	t.Cleanup(func() { fixture.repos.ptz.UnsubscribeTaskEvents(taskEvents) })
+
+	ctx, cancel := context.WithTimeout(t.Context(), 5*time.Second)
+	defer cancel()
+
+	require.Nil(t, fixture.sendArm(ctx, t, []float32{0, 0}, 1).GetResult())
+	fixture.nextCommand(t, isArmCommand)
+
+	// 100ms ごとに8点のウェイポイントのうち、最初の結果が 250ms でタイムアウトする
+	resp, err := fixture.enqueueArmMove(ctx, t, map[string]any{
+		"joint_angles": []any{60.0, -30.0},
+		"speed":        1.0,
+		"timeout_ms":   250,
+	})
+	require.NoError(t, err)
+
+	taskID := resp.GetFields()["task_id"].GetStringValue()
+	require.Len(t, resp.GetFields()["waypoints"].GetListValue().GetValues(), 8)
+
+	first := fixture.nextCommand(t, isArmCommand)
+	require.Equal(t, taskID+"-1", first.GetCommandId())
+	require.Equal(t, uint32(250), first.GetTimeoutMs())
+
+	select {
+	case event := <-taskEvents:
+		require.Equal(t, taskID, event.Task.GetTaskId())
+		require.Equal(t, infrastructure.TaskOutcomeTimedOut, event.Outcome)
+	case <-ctx.Done():
+		require.FailNow(t, "timed out waiting for the task to end")
+	}
+
+	// 残りのウェイポイントは送らず、最後に配信した姿勢で停止させる
+	sent := 1
+
+	for {
+		command := fixture.nextCommand(t, isArmCommand)
+		if command.GetCommandId() == taskID+"-stop" {
+			require.True(t, infrastructure.IsArmSafetyStop(command.GetArmParameters()))
+
+			break
+		}
+
+		sent++
+	}
+
+	require.Less(t, sent, 8)
+	fixture.requireNoCommand(t, anyCommand, 300*time.Millisecond)
+}
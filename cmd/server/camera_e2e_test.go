@@ -2,8 +2,6 @@ package main
 
 import (
 	"context"
-	"crypto/tls"
-	"net"
 	"net/http"
 	"net/http/httptest"
 	"testing"
@@ -11,12 +9,9 @@ import (
 
 	"connectrpc.com/connect"
 	"github.com/stretchr/testify/require"
-	"golang.org/x/net/http2"
-	"golang.org/x/net/http2/h2c"
 
 	protov1 "github.com/anyfld/vistra-operation-control-room/gen/proto/v1"
 	"github.com/anyfld/vistra-operation-control-room/gen/proto/v1/protov1connect"
-	"github.com/anyfld/vistra-operation-control-room/pkg/transport/infrastructure"
 )
 
 func newCameraTestServer(
@@ -24,26 +19,12 @@ func newCameraTestServer(
 ) (*httptest.Server, protov1connect.CameraServiceClient) {
 	t.Helper()
 
-	cameraRepo := infrastructure.NewCameraRepo()
 	mux := http.NewServeMux()
-	registerCameraService(mux, cameraRepo)
+	registerCameraService(mux, newTestRepositories())
 
-	handler := h2c.NewHandler(mux, &http2.Server{})
-	server := httptest.NewUnstartedServer(handler)
-	server.EnableHTTP2 = false
-	server.Start()
+	server := startH2CServer(t, mux)
 
-	transport := &http2.Transport{
-		AllowHTTP: true,
-		DialTLS: func(network, addr string, cfg *tls.Config) (net.Conn, error) {
-			return net.Dial(network, addr)
-		},
-	}
-
-	client := &http.Client{Transport: transport}
-	cameraClient := protov1connect.NewCameraServiceClient(client, server.URL)
-
-	return server, cameraClient
+	return server, protov1connect.NewCameraServiceClient(newH2CClient(), server.URL)
 }
 
 func TestRegisterAndGetCameraE2E(t *testing.T) {
@@ -0,0 +1,392 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"connectrpc.com/connect"
+	"github.com/stretchr/testify/require"
+
+	protov1 "github.com/anyfld/vistra-operation-control-room/gen/proto/v1"
+	"github.com/anyfld/vistra-operation-control-room/pkg/transport/infrastructure"
+)
+
+type commandPipelineFixture struct {
+	*e2eServer
+
+	stream *controlCommandStream
+}
+
+// newCommandPipelineFixture はカメラを登録し、制御コマンドを受け取るFDとして双方向ストリームを開きます。
+func newCommandPipelineFixture(ctx context.Context, t *testing.T) *commandPipelineFixture {
+	t.Helper()
+
+	server := newE2EServer(t, testCamera("pipeline-camera", &protov1.CameraCapabilities{
+		SupportsPtz: true,
+		PanMin:      -100,
+		PanMax:      100,
+		TiltMin:     -50,
+		TiltMax:     50,
+		ZoomMin:     1,
+		ZoomMax:     11,
+	}))
+
+	fixture := &commandPipelineFixture{e2eServer: server, stream: openControlCommandStream(ctx, server.url)}
+
+	require.NoError(t, fixture.stream.Send(&protov1.StreamControlCommandsRequest{
+		Message: &protov1.StreamControlCommandsRequest_Init{
+			Init: &protov1.StreamControlCommandsInit{CameraId: fixture.cameraID},
+		},
+	}))
+
+	connected, err := fixture.stream.Receive()
+	require.NoError(t, err)
+	require.True(t, connected.GetStatus().GetConnected())
+
+	return fixture
+}
+
+func (f *commandPipelineFixture) sendControlCommand(
+	ctx context.Context,
+	t *testing.T,
+	command *protov1.ControlCommand,
+) {
+	t.Helper()
+
+	command.CameraId = f.cameraID
+
+	resp, err := f.fd.StreamControlCommands(ctx, connect.NewRequest(&protov1.StreamControlCommandsRequest{
+		Message: &protov1.StreamControlCommandsRequest_Command{Command: command},
+	}))
+	require.NoError(t, err)
+	require.Nil(t, resp.Msg.GetResult())
+}
+
+func (f *commandPipelineFixture) poll(
+	ctx context.Context,
+	t *testing.T,
+	completedTaskID string,
+	executingTaskID string,
+) *protov1.PollingResponse {
+	t.Helper()
+
+	resp, err := f.clients.ptz.Polling(ctx, connect.NewRequest(&protov1.PollingRequest{
+		CameraId:        f.cameraID,
+		CompletedTaskId: completedTaskID,
+		ExecutingTaskId: executingTaskID,
+	}))
+	require.NoError(t, err)
+
+	return resp.Msg
+}
+
+// receive は双方向ストリームに配信された次のメッセージを返します。
+func (f *commandPipelineFixture) receive(t *testing.T) *protov1.StreamControlCommandsResponse {
+	t.Helper()
+
+	resp, err := f.stream.Receive()
+	require.NoError(t, err)
+
+	return resp
+}
+
+// receiveN は双方向ストリームに配信された次の n 件のメッセージを返します。
+// 制御コマンドと結果は別の経路で配信されるため、呼び出し側では順序を問わずに確認します。
+func (f *commandPipelineFixture) receiveN(t *testing.T, n int) []*protov1.StreamControlCommandsResponse {
+	t.Helper()
+
+	messages := make([]*protov1.StreamControlCommandsResponse, 0, n)
+	for range n {
+		messages = append(messages, f.receive(t))
+	}
+
+	return messages
+}
+
+// commandIDsOf はメッセージのうち、結果ではなく制御コマンドの配信のIDを返します。
+func commandIDsOf(messages []*protov1.StreamControlCommandsResponse) []string {
+	ids := make([]string, 0, len(messages))
+
+	for _, message := range messages {
+		if command := message.GetCommand(); command != nil && message.GetResult() == nil {
+			ids = append(ids, command.GetCommandId())
+		}
+	}
+
+	return ids
+}
+
+// resultOf はメッセージのうち commandID の結果を返します。
+func resultOf(messages []*protov1.StreamControlCommandsResponse, commandID string) *protov1.ControlCommandResult {
+	for _, message := range messages {
+		if message.GetResult().GetCommandId() == commandID {
+			return message.GetResult()
+		}
+	}
+
+	return nil
+}
+
+func TestCommandPipeline_DispatchesQueuedControlCommandsWhenTheirTasksStart(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithTimeout(t.Context(), 5*time.Second)
+	defer cancel()
+
+	fixture := newCommandPipelineFixture(ctx, t)
+
+	move := func(commandID string, pan float32, timeoutMs uint32) {
+		fixture.sendControlCommand(ctx, t, &protov1.ControlCommand{
+			CommandId:     commandID,
+			Type:          protov1.ControlCommandType_CONTROL_COMMAND_TYPE_PTZ_ABSOLUTE,
+			PtzParameters: &protov1.PTZParameters{Pan: pan, Tilt: 0, Zoom: 1},
+			TimeoutMs:     timeoutMs,
+		})
+	}
+
+	// キューの先頭のタスクだけがストリームのFDへ配信される
+	move("cmd-queued-1", 10, 0)
+	require.Equal(t, "cmd-queued-1", fixture.receive(t).GetCommand().GetCommandId())
+
+	move("cmd-queued-2", 20, 300)
+	move("cmd-queued-3", 30, 0)
+
+	// 待っている間はタイムアウトを数えない
+	time.Sleep(400 * time.Millisecond)
+
+	for _, commandID := range []string{"cmd-queued-2", "cmd-queued-3"} {
+		status := fixture.repos.fd.GetControlCommandStatus(commandID)
+		require.Equal(t, infrastructure.ControlCommandStatePending, status.State, commandID)
+		require.Zero(t, status.DispatchedAtMs, commandID)
+	}
+
+	// 先頭のタスクが完了すると、次のタスクの制御コマンドが配信される
+	reportedAtMs := time.Now().UnixMilli()
+
+	require.NoError(t, fixture.stream.Send(&protov1.StreamControlCommandsRequest{
+		Message: &protov1.StreamControlCommandsRequest_Result{
+			Result: &protov1.ControlCommandResult{CommandId: "cmd-queued-1", Success: true},
+		},
+	}))
+
+	messages := fixture.receiveN(t, 3)
+	require.Equal(t, []string{"cmd-queued-2"}, commandIDsOf(messages))
+	require.True(t, resultOf(messages, "cmd-queued-1").GetSuccess())
+
+	// タイムアウトは実行対象になった時点から数え、タイムアウトしたタスクの次のタスクへ進む
+	messages = fixture.receiveN(t, 2)
+	require.Equal(t, []string{"cmd-queued-3"}, commandIDsOf(messages))
+	require.False(t, resultOf(messages, "cmd-queued-2").GetSuccess())
+
+	timedOut := fixture.repos.fd.GetControlCommandStatus("cmd-queued-2")
+	require.Equal(t, infrastructure.ControlCommandStateTimedOut, timedOut.State)
+	require.GreaterOrEqual(t, timedOut.DispatchedAtMs, reportedAtMs)
+	require.GreaterOrEqual(t, timedOut.FinishedAtMs-timedOut.DispatchedAtMs, int64(290))
+
+	// 停止の制御コマンド（Layer 1）は実行中のタスクと待っているタスクを中断する
+	move("cmd-queued-4", 40, 0)
+	fixture.sendControlCommand(ctx, t, &protov1.ControlCommand{
+		CommandId: "cmd-queued-stop",
+		Type:      protov1.ControlCommandType_CONTROL_COMMAND_TYPE_PTZ_STOP,
+	})
+
+	messages = fixture.receiveN(t, 2)
+	require.Equal(t, []string{"cmd-queued-stop"}, commandIDsOf(messages))
+
+	interrupted := resultOf(messages, "cmd-queued-3")
+	require.False(t, interrupted.GetSuccess())
+	require.NotEmpty(t, interrupted.GetErrorMessage())
+
+	// 配信していない制御コマンドは失敗として終了し、ストリームのFDには何も届かない
+	require.Eventually(t, func() bool {
+		status := fixture.repos.fd.GetControlCommandStatus("cmd-queued-4")
+
+		return status.State == infrastructure.ControlCommandStateFailed && status.DispatchedAtMs == 0
+	}, time.Second, 10*time.Millisecond)
+
+	require.NoError(t, fixture.stream.Send(&protov1.StreamControlCommandsRequest{
+		Message: &protov1.StreamControlCommandsRequest_Result{
+			Result: &protov1.ControlCommandResult{CommandId: "cmd-queued-stop", Success: true},
+		},
+	}))
+
+	for _, message := range fixture.receiveN(t, 2) {
+		require.Equal(t, "cmd-queued-stop", message.GetResult().GetCommandId())
+	}
+
+	require.Nil(t, fixture.repos.ptz.GetQueueStatus(fixture.cameraID).GetExecutingTask())
+}
+
+func TestCommandPipeline_ControlCommandPreemptsCinematicTaskForPollers(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithTimeout(t.Context(), 5*time.Second)
+	defer cancel()
+
+	fixture := newCommandPipelineFixture(ctx, t)
+
+	instruction := &protov1.CinematographyInstruction{InstructionId: "instruction-pipeline-1", CameraId: fixture.cameraID}
+
+	cinematic, err := fixture.clients.ptz.SendCinematicCommand(ctx, connect.NewRequest(
+		&protov1.SendCinematicCommandRequest{CameraId: fixture.cameraID, Command: instruction},
+	))
+	require.NoError(t, err)
+
+	cinematicTaskID := cinematic.Msg.GetTaskId()
+	require.Equal(t, cinematicTaskID, fixture.poll(ctx, t, "", "").GetCurrentCommand().GetTaskId())
+
+	// FDService の制御コマンドは PTZ枠のタスクとなり、ポーリングするFDの実行中のシネマティック命令を中断させる
+	fixture.sendControlCommand(ctx, t, &protov1.ControlCommand{
+		CommandId:     "cmd-pipeline-1",
+		Type:          protov1.ControlCommandType_CONTROL_COMMAND_TYPE_PTZ_ABSOLUTE,
+		PtzParameters: &protov1.PTZParameters{Pan: 50, Tilt: -25, Zoom: 6, PanSpeed: 0.5},
+	})
+	require.Equal(t, "cmd-pipeline-1", fixture.receive(t).GetCommand().GetCommandId())
+
+	polled := fixture.poll(ctx, t, "", cinematicTaskID)
+	require.True(t, polled.GetInterrupt())
+	require.Equal(t, "cmd-pipeline-1", polled.GetCurrentCommand().GetTaskId())
+	require.Equal(t, protov1.CommandLayer_COMMAND_LAYER_PTZ, polled.GetCurrentCommand().GetLayer())
+
+	move := polled.GetCurrentCommand().GetPtzCommand().GetAbsoluteMove()
+	require.InDelta(t, 0.5, move.GetPosition().GetX(), 0.001)
+	require.InDelta(t, -0.5, move.GetPosition().GetY(), 0.001)
+	require.InDelta(t, 0.5, move.GetPosition().GetZ(), 0.001)
+	require.InDelta(t, 0.5, move.GetSpeed().GetPanSpeed(), 0.001)
+
+	// ポーリングで完了を報告すると、制御コマンドも成功として終了し、ストリームのFDに結果が届く
+	require.Nil(t, fixture.poll(ctx, t, "cmd-pipeline-1", "cmd-pipeline-1").GetCurrentCommand())
+
+	result := fixture.receive(t).GetResult()
+	require.Equal(t, "cmd-pipeline-1", result.GetCommandId())
+	require.True(t, result.GetSuccess())
+}
+
+func TestCommandPipeline_PTZServiceTaskReachesStreamAndStopInterruptsIt(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithTimeout(t.Context(), 5*time.Second)
+	defer cancel()
+
+	fixture := newCommandPipelineFixture(ctx, t)
+
+	sendPTZ := func(x float32) string {
+		resp, err := fixture.clients.ptz.SendPTZCommand(ctx, connect.NewRequest(&protov1.SendPTZCommandRequest{
+			CameraId: fixture.cameraID,
+			Command: &protov1.PTZCommand{
+				OperationType: protov1.PTZOperationType_PTZ_OPERATION_TYPE_ABSOLUTE_MOVE,
+				Command: &protov1.PTZCommand_AbsoluteMove{AbsoluteMove: &protov1.AbsoluteMoveCommand{
+					Position: &protov1.PTZPosition{X: x, Y: 0, Z: 0},
+				}},
+			},
+		}))
+		require.NoError(t, err)
+		require.True(t, resp.Msg.GetAccepted())
+
+		return resp.Msg.GetTaskId()
+	}
+
+	// PTZService のタスクは、タスクIDをコマンドIDとする制御コマンドとしてストリームのFDにも届く
+	firstTaskID := sendPTZ(0.5)
+
+	command := fixture.receive(t).GetCommand()
+	require.Equal(t, firstTaskID, command.GetCommandId())
+	require.Equal(t, protov1.ControlCommandType_CONTROL_COMMAND_TYPE_PTZ_ABSOLUTE, command.GetType())
+	require.InDelta(t, 50, command.GetPtzParameters().GetPan(), 0.001)
+	require.InDelta(t, 1, command.GetPtzParameters().GetZoom(), 0.001)
+
+	// ストリームのFDが結果を報告すると、ポーリングするFDのキューからもタスクが消える
+	require.NoError(t, fixture.stream.Send(&protov1.StreamControlCommandsRequest{
+		Message: &protov1.StreamControlCommandsRequest_Result{
+			Result: &protov1.ControlCommandResult{CommandId: firstTaskID, Success: true},
+		},
+	}))
+
+	// 応答と結果のイベントの両方が届く
+	for range 2 {
+		result := fixture.receive(t).GetResult()
+		require.Equal(t, firstTaskID, result.GetCommandId())
+		require.True(t, result.GetSuccess())
+	}
+
+	require.Nil(t, fixture.poll(ctx, t, "", "").GetCurrentCommand())
+
+	// 停止の制御コマンドは未完了のPTZ枠のタスクを中断し、その制御コマンドは失敗としてストリームのFDに届く
+	secondTaskID := sendPTZ(-0.5)
+	require.Equal(t, secondTaskID, fixture.receive(t).GetCommand().GetCommandId())
+	require.Equal(t, secondTaskID, fixture.poll(ctx, t, "", "").GetCurrentCommand().GetTaskId())
+
+	fixture.sendControlCommand(ctx, t, &protov1.ControlCommand{
+		CommandId: "cmd-pipeline-stop",
+		Type:      protov1.ControlCommandType_CONTROL_COMMAND_TYPE_PTZ_STOP,
+	})
+
+	var (
+		stopped     bool
+		interrupted *protov1.ControlCommandResult
+	)
+
+	// 停止の配信と中断の結果の配信は別の経路で行われるため、順序は問わない
+	for !stopped || interrupted == nil {
+		resp := fixture.receive(t)
+
+		switch {
+		case resp.GetCommand().GetCommandId() == "cmd-pipeline-stop":
+			stopped = true
+		case resp.GetResult().GetCommandId() == secondTaskID:
+			interrupted = resp.GetResult()
+		}
+	}
+
+	require.False(t, interrupted.GetSuccess())
+	require.NotEmpty(t, interrupted.GetErrorMessage())
+
+	polled := fixture.poll(ctx, t, "", secondTaskID)
+	require.True(t, polled.GetInterrupt())
+	require.Equal(t, "cmd-pipeline-stop", polled.GetCurrentCommand().GetTaskId())
+	require.Zero(t, polled.GetCurrentCommand().GetPtzCommand().GetContinuousMove().GetVelocity().GetPanVelocity())
+}
+
+func TestCommandPipeline_ListenerKeepsEventsPublishedBeforeTasksFinish(t *testing.T) {
+	t.Parallel()
+
+	repos := newTestRepositories()
+	listener := repos.events.Listen(infrastructure.EventTopicTask)
+	t.Cleanup(func() { repos.events.Unlisten(listener) })
+
+	// EventSubscription のバッファを超える数のタスクを、イベントを受け取る前に追加して終了させる
+	const taskCount = 1000
+
+	for i := range taskCount {
+		command := &protov1.ControlCommand{
+			CommandId: fmt.Sprintf("cmd-listener-%d", i),
+			CameraId:  "listener-camera",
+			Type:      protov1.ControlCommandType_CONTROL_COMMAND_TYPE_PTZ_STOP,
+		}
+		repos.ptz.EnqueueControlCommand(command.GetCameraId(), command, &protov1.PTZCommand{}, false)
+		require.True(t, repos.ptz.FinishTask(
+			command.GetCameraId(), command.GetCommandId(), infrastructure.TaskOutcomeCompleted, nil,
+		))
+	}
+
+	timeout := time.After(5 * time.Second)
+
+	for i := range taskCount {
+		for _, eventType := range []string{
+			infrastructure.EventTaskEnqueued, infrastructure.EventTaskStarted, infrastructure.EventTaskCompleted,
+		} {
+			select {
+			case event := <-listener.Events():
+				require.Equal(t, eventType, event.Type)
+				require.Equal(t, fmt.Sprintf("cmd-listener-%d", i), event.ResourceID)
+				// タスクは既に終了しているが、制御コマンドとの対応は配信した時点の状態で判定できる
+				require.True(t, event.IsControlCommandTask())
+				require.Equal(t, event.ResourceID, event.ControlCommand.GetCommandId())
+			case <-timeout:
+				require.FailNow(t, "timed out waiting for a task event")
+			}
+		}
+	}
+}
@@ -0,0 +1,392 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"connectrpc.com/connect"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/types/known/structpb"
+
+	protov1 "github.com/anyfld/vistra-operation-control-room/gen/proto/v1"
+	"github.com/anyfld/vistra-operation-control-room/pkg/transport/handlers"
+	"github.com/anyfld/vistra-operation-control-room/pkg/transport/infrastructure"
+)
+
+type controlCommandFixture struct {
+	*e2eServer
+
+	events *infrastructure.EventSubscription
+}
+
+func newControlCommandFixture(t *testing.T) *controlCommandFixture {
+	t.Helper()
+
+	server := newE2EServer(t, testCamera("command-camera", &protov1.CameraCapabilities{SupportsPtz: true}))
+
+	events := server.repos.events.Subscribe(infrastructure.EventTopicTask)
+	t.Cleanup(func() { server.repos.events.Unsubscribe(events) })
+
+	return &controlCommandFixture{e2eServer: server, events: events}
+}
+
+func (f *controlCommandFixture) send(
+	ctx context.Context,
+	t *testing.T,
+	commandID string,
+	timeoutMs uint32,
+) *protov1.StreamControlCommandsResponse {
+	t.Helper()
+
+	resp, err := f.fd.StreamControlCommands(ctx, connect.NewRequest(&protov1.StreamControlCommandsRequest{
+		Message: &protov1.StreamControlCommandsRequest_Command{Command: &protov1.ControlCommand{
+			CommandId:     commandID,
+			CameraId:      f.cameraID,
+			Type:          protov1.ControlCommandType_CONTROL_COMMAND_TYPE_PTZ_ABSOLUTE,
+			PtzParameters: &protov1.PTZParameters{Pan: 20, Tilt: 10, Zoom: 1},
+			TimeoutMs:     timeoutMs,
+		}},
+	}))
+	require.NoError(t, err)
+
+	return resp.Msg
+}
+
+// sendFocus はカメラのキューを通らずにすぐ配信されるFOCUSの制御コマンドを送ります。
+// 移動系の制御コマンドはタスクが実行対象になるまで配信されないため、配信の順序や通し番号の確認に使います。
+func (f *controlCommandFixture) sendFocus(ctx context.Context, t *testing.T, commandID string) {
+	t.Helper()
+
+	resp, err := f.fd.StreamControlCommands(ctx, connect.NewRequest(&protov1.StreamControlCommandsRequest{
+		Message: &protov1.StreamControlCommandsRequest_Command{Command: &protov1.ControlCommand{
+			CommandId:  commandID,
+			CameraId:   f.cameraID,
+			Type:       protov1.ControlCommandType_CONTROL_COMMAND_TYPE_FOCUS,
+			FocusValue: 0.5,
+		}},
+	}))
+	require.NoError(t, err)
+	require.Nil(t, resp.Msg.GetResult())
+}
+
+// poll はFDとして初期化メッセージを送り、配信された命令と結果を返します。
+func (f *controlCommandFixture) poll(ctx context.Context, t *testing.T) *protov1.StreamControlCommandsResponse {
+	t.Helper()
+
+	resp, err := f.fd.StreamControlCommands(ctx, connect.NewRequest(&protov1.StreamControlCommandsRequest{
+		Message: &protov1.StreamControlCommandsRequest_Init{
+			Init: &protov1.StreamControlCommandsInit{CameraId: f.cameraID},
+		},
+	}))
+	require.NoError(t, err)
+
+	return resp.Msg
+}
+
+func (f *controlCommandFixture) report(ctx context.Context, result *protov1.ControlCommandResult) error {
+	_, err := f.fd.StreamControlCommands(ctx, connect.NewRequest(&protov1.StreamControlCommandsRequest{
+		Message: &protov1.StreamControlCommandsRequest_Result{Result: result},
+	}))
+
+	return err
+}
+
+func (f *controlCommandFixture) status(
+	ctx context.Context,
+	t *testing.T,
+	commandID string,
+	wait bool,
+) (*structpb.Struct, error) {
+	t.Helper()
+
+	return f.call(ctx, t, handlers.FDServiceGetControlCommandStatusProcedure, map[string]any{
+		"command_id": commandID,
+		"wait":       wait,
+	})
+}
+
+// nextResultEvent は制御コマンドの結果のイベントを待ちます。
+// 移動系の制御コマンドは同じIDのタスクにもなるため、task.* のイベントは読み飛ばします。
+func (f *controlCommandFixture) nextResultEvent(t *testing.T, commandID string) *infrastructure.Event {
+	t.Helper()
+
+	timeout := time.After(3 * time.Second)
+
+	for {
+		select {
+		case event := <-f.events.Events():
+			switch event.Type {
+			case infrastructure.EventControlCommandCompleted,
+				infrastructure.EventControlCommandFailed,
+				infrastructure.EventControlCommandTimedOut:
+				if event.ResourceID == commandID {
+					return event
+				}
+			}
+		case <-timeout:
+			require.FailNow(t, "timed out waiting for a control command result event")
+		}
+	}
+}
+
+func TestControlCommand_StoresResultReportedByFD(t *testing.T) {
+	t.Parallel()
+
+	fixture := newControlCommandFixture(t)
+
+	ctx, cancel := context.WithTimeout(t.Context(), 5*time.Second)
+	defer cancel()
+
+	// FDが購読していない間は pending
+	resp := fixture.send(ctx, t, "cmd-lifecycle-1", 0)
+	require.Nil(t, resp.GetResult())
+	require.Equal(t, "cmd-lifecycle-1", resp.GetCommand().GetCommandId())
+
+	status, err := fixture.status(ctx, t, "cmd-lifecycle-1", false)
+	require.NoError(t, err)
+	require.Equal(t, "pending", status.GetFields()["state"].GetStringValue())
+	require.Nil(t, status.GetFields()["result"])
+
+	// FDが受け取ると dispatched
+	require.Equal(t, "cmd-lifecycle-1", fixture.poll(ctx, t).GetCommand().GetCommandId())
+
+	status, err = fixture.status(ctx, t, "cmd-lifecycle-1", false)
+	require.NoError(t, err)
+	require.Equal(t, "dispatched", status.GetFields()["state"].GetStringValue())
+	require.Positive(t, status.GetFields()["dispatched_at_ms"].GetNumberValue())
+
+	require.NoError(t, fixture.report(ctx, &protov1.ControlCommandResult{
+		CommandId:       "cmd-lifecycle-1",
+		Success:         true,
+		ResultingPtz:    &protov1.PTZParameters{Pan: 19.5, Tilt: 10, Zoom: 1},
+		ExecutionTimeMs: 240,
+	}))
+
+	event := fixture.nextResultEvent(t, "cmd-lifecycle-1")
+	require.Equal(t, infrastructure.EventControlCommandCompleted, event.Type)
+	require.Equal(t, fixture.cameraID, event.CameraID)
+
+	status, err = fixture.status(ctx, t, "cmd-lifecycle-1", false)
+	require.NoError(t, err)
+	require.Equal(t, "completed", status.GetFields()["state"].GetStringValue())
+
+	result := status.GetFields()["result"].GetStructValue().GetFields()
+	require.True(t, result["success"].GetBoolValue())
+	require.InDelta(t, 240, result["execution_time_ms"].GetNumberValue(), 0)
+	require.InDelta(t, 19.5, result["resulting_ptz"].GetStructValue().GetFields()["pan"].GetNumberValue(), 0.01)
+
+	// FDの次のポーリングでは結果も返る
+	require.True(t, fixture.poll(ctx, t).GetResult().GetSuccess())
+}
+
+func TestControlCommand_WaitReturnsFailureReportedByFD(t *testing.T) {
+	t.Parallel()
+
+	fixture := newControlCommandFixture(t)
+
+	ctx, cancel := context.WithTimeout(t.Context(), 5*time.Second)
+	defer cancel()
+
+	fixture.send(ctx, t, "cmd-failed-1", 0)
+	fixture.poll(ctx, t)
+
+	waited := make(chan *structpb.Struct, 1)
+
+	go func() {
+		status, err := fixture.status(ctx, t, "cmd-failed-1", true)
+		if err != nil {
+			close(waited)
+
+			return
+		}
+
+		waited <- status
+	}()
+
+	require.NoError(t, fixture.report(ctx, &protov1.ControlCommandResult{
+		CommandId:    "cmd-failed-1",
+		Success:      false,
+		ErrorMessage: "pan limit reached",
+	}))
+
+	status, ok := <-waited
+	require.True(t, ok)
+	require.Equal(t, "failed", status.GetFields()["state"].GetStringValue())
+	require.Equal(t,
+		"pan limit reached",
+		status.GetFields()["result"].GetStructValue().GetFields()["error_message"].GetStringValue(),
+	)
+
+	require.Equal(t, infrastructure.EventControlCommandFailed, fixture.nextResultEvent(t, "cmd-failed-1").Type)
+}
+
+func TestControlCommand_TimesOutWithoutResult(t *testing.T) {
+	t.Parallel()
+
+	fixture := newControlCommandFixture(t)
+
+	ctx, cancel := context.WithTimeout(t.Context(), 5*time.Second)
+	defer cancel()
+
+	fixture.send(ctx, t, "cmd-timeout-1", 100)
+	fixture.poll(ctx, t)
+
+	status, err := fixture.status(ctx, t, "cmd-timeout-1", true)
+	require.NoError(t, err)
+	require.Equal(t, "timed_out", status.GetFields()["state"].GetStringValue())
+	require.False(t, status.GetFields()["result"].GetStructValue().GetFields()["success"].GetBoolValue())
+
+	require.Equal(t, infrastructure.EventControlCommandTimedOut, fixture.nextResultEvent(t, "cmd-timeout-1").Type)
+
+	// タイムアウト後に届いた結果は無視する
+	require.NoError(t, fixture.report(ctx, &protov1.ControlCommandResult{CommandId: "cmd-timeout-1", Success: true}))
+
+	status, err = fixture.status(ctx, t, "cmd-timeout-1", false)
+	require.NoError(t, err)
+	require.Equal(t, "timed_out", status.GetFields()["state"].GetStringValue())
+}
+
+func TestControlCommand_UnknownCommand(t *testing.T) {
+	t.Parallel()
+
+	fixture := newControlCommandFixture(t)
+
+	ctx, cancel := context.WithTimeout(t.Context(), 5*time.Second)
+	defer cancel()
+
+	err := fixture.report(ctx, &protov1.ControlCommandResult{CommandId: "cmd-unknown", Success: true})
+	require.Equal(t, connect.CodeNotFound, connect.CodeOf(err))
+
+	_, err = fixture.status(ctx, t, "cmd-unknown", true)
+	require.Equal(t, connect.CodeNotFound, connect.CodeOf(err))
+}
+
+func (f *controlCommandFixture) list(
+	ctx context.Context,
+	t *testing.T,
+	fields map[string]any,
+) ([]*structpb.Value, error) {
+	t.Helper()
+
+	resp, err := f.call(ctx, t, handlers.FDServiceListControlCommandsProcedure, fields)
+	if err != nil {
+		return nil, err
+	}
+
+	return resp.GetFields()["commands"].GetListValue().GetValues(), nil
+}
+
+func commandIDs(commands []*structpb.Value) []string {
+	ids := make([]string, 0, len(commands))
+	for _, command := range commands {
+		ids = append(ids, command.GetStructValue().GetFields()["command_id"].GetStringValue())
+	}
+
+	return ids
+}
+
+func TestControlCommand_HistoryByCameraTimeAndType(t *testing.T) {
+	t.Parallel()
+
+	fixture := newControlCommandFixture(t)
+
+	ctx, cancel := context.WithTimeout(t.Context(), 5*time.Second)
+	defer cancel()
+
+	fixture.send(ctx, t, "cmd-history-1", 0)
+	fixture.send(ctx, t, "cmd-history-2", 0)
+
+	// 作成時刻の境界を分ける
+	time.Sleep(5 * time.Millisecond)
+
+	boundary := time.Now().UnixMilli()
+
+	time.Sleep(5 * time.Millisecond)
+
+	// プリセットがないため失敗する PRESET_GOTO
+	_, err := fixture.fd.StreamControlCommands(ctx, connect.NewRequest(&protov1.StreamControlCommandsRequest{
+		Message: &protov1.StreamControlCommandsRequest_Command{Command: &protov1.ControlCommand{
+			CommandId:    "cmd-history-3",
+			CameraId:     fixture.cameraID,
+			Type:         protov1.ControlCommandType_CONTROL_COMMAND_TYPE_PRESET_GOTO,
+			PresetNumber: 9,
+		}},
+	}))
+	require.NoError(t, err)
+
+	commands, err := fixture.list(ctx, t, map[string]any{"camera_id": fixture.cameraID})
+	require.NoError(t, err)
+	require.Equal(t, []string{"cmd-history-3", "cmd-history-2", "cmd-history-1"}, commandIDs(commands))
+
+	first := commands[0].GetStructValue().GetFields()
+	require.Equal(t, "CONTROL_COMMAND_TYPE_PRESET_GOTO", first["type"].GetStringValue())
+	require.Equal(t, "failed", first["state"].GetStringValue())
+	require.InDelta(t, 9, first["command"].GetStructValue().GetFields()["preset_number"].GetNumberValue(), 0)
+
+	commands, err = fixture.list(ctx, t, map[string]any{
+		"camera_id": fixture.cameraID,
+		"types":     []any{"CONTROL_COMMAND_TYPE_PTZ_ABSOLUTE"},
+		"limit":     1,
+	})
+	require.NoError(t, err)
+	require.Equal(t, []string{"cmd-history-2"}, commandIDs(commands))
+
+	commands, err = fixture.list(ctx, t, map[string]any{"camera_id": fixture.cameraID, "until_ms": boundary})
+	require.NoError(t, err)
+	require.Equal(t, []string{"cmd-history-2", "cmd-history-1"}, commandIDs(commands))
+
+	commands, err = fixture.list(ctx, t, map[string]any{"camera_id": fixture.cameraID, "since_ms": boundary})
+	require.NoError(t, err)
+	require.Equal(t, []string{"cmd-history-3"}, commandIDs(commands))
+
+	commands, err = fixture.list(ctx, t, map[string]any{"camera_id": "cam-other"})
+	require.NoError(t, err)
+	require.Empty(t, commands)
+
+	_, err = fixture.list(ctx, t, map[string]any{"types": []any{"CONTROL_COMMAND_TYPE_TELEPORT"}})
+	require.Equal(t, connect.CodeInvalidArgument, connect.CodeOf(err))
+
+	_, err = fixture.list(ctx, t, map[string]any{"since_ms": boundary, "until_ms": boundary})
+	require.Equal(t, connect.CodeInvalidArgument, connect.CodeOf(err))
+}
+
+func TestControlCommand_HistoryIsBounded(t *testing.T) {
+	t.Parallel()
+
+	fixture := newControlCommandFixture(t)
+
+	ctx, cancel := context.WithTimeout(t.Context(), 10*time.Second)
+	defer cancel()
+
+	// 結果を待っている制御コマンドは破棄しない
+	fixture.send(ctx, t, "cmd-bounded-pending", 0)
+
+	for i := range 300 {
+		_, err := fixture.fd.StreamControlCommands(ctx, connect.NewRequest(&protov1.StreamControlCommandsRequest{
+			Message: &protov1.StreamControlCommandsRequest_Command{Command: &protov1.ControlCommand{
+				CommandId:    fmt.Sprintf("cmd-bounded-%d", i),
+				CameraId:     fixture.cameraID,
+				Type:         protov1.ControlCommandType_CONTROL_COMMAND_TYPE_PRESET_GOTO,
+				PresetNumber: 9,
+			}},
+		}))
+		require.NoError(t, err)
+	}
+
+	commands, err := fixture.list(ctx, t, map[string]any{"camera_id": fixture.cameraID})
+	require.NoError(t, err)
+	require.Len(t, commands, 256)
+
+	ids := commandIDs(commands)
+	require.Equal(t, "cmd-bounded-299", ids[0])
+	require.Contains(t, ids, "cmd-bounded-pending")
+	require.NotContains(t, ids, "cmd-bounded-0")
+
+	_, err = fixture.status(ctx, t, "cmd-bounded-0", false)
+	require.Equal(t, connect.CodeNotFound, connect.CodeOf(err))
+
+	_, err = fixture.status(ctx, t, "cmd-bounded-pending", false)
+	require.NoError(t, err)
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"connectrpc.com/connect"
+	"github.com/stretchr/testify/require"
+
+	protov1 "github.com/anyfld/vistra-operation-control-room/gen/proto/v1"
+	"github.com/anyfld/vistra-operation-control-room/gen/proto/v1/protov1connect"
+	"github.com/anyfld/vistra-operation-control-room/pkg/transport/infrastructure"
+)
+
+type crTestClients struct {
+	camera protov1connect.CameraServiceClient
+	cr     protov1connect.CRServiceClient
+	md     protov1connect.MDServiceClient
+	ptz    protov1connect.PTZServiceClient
+}
+
+func newCRTestServer(t *testing.T) (*httptest.Server, crTestClients) {
+	t.Helper()
+
+	return newCRTestServerWithRepos(t, newTestRepositories())
+}
+
+func newCRTestServerWithRepos(t *testing.T, repos *repositories) (*httptest.Server, crTestClients) {
+	t.Helper()
+
+	mux := http.NewServeMux()
+	registerCameraService(mux, repos)
+	registerCRService(t.Context(), mux, repos)
+	registerPTZService(mux, repos)
+
+	return startCRTestServer(t, mux)
+}
+
+func startCRTestServer(t *testing.T, mux *http.ServeMux) (*httptest.Server, crTestClients) {
+	t.Helper()
+
+	server := startH2CServer(t, mux)
+	client := newH2CClient()
+
+	return server, crTestClients{
+		camera: protov1connect.NewCameraServiceClient(client, server.URL),
+		cr:     protov1connect.NewCRServiceClient(client, server.URL),
+		md:     protov1connect.NewMDServiceClient(client, server.URL),
+		ptz:    protov1connect.NewPTZServiceClient(client, server.URL),
+	}
+}
+
+func TestSendCinematographyInstruction_RoutesToFDAndStreamsResult(t *testing.T) {
+	t.Parallel()
+
+	server, clients := newCRTestServer(t)
+	defer server.Close()
+
+	ctx, cancel := context.WithTimeout(t.Context(), 5*time.Second)
+	defer cancel()
+
+	registerResp, err := clients.camera.RegisterCamera(ctx, connect.NewRequest(&protov1.RegisterCameraRequest{
+		Name:       "e2e-cr-camera",
+		Mode:       protov1.CameraMode_CAMERA_MODE_AUTONOMOUS,
+		MasterMfId: "mf-e2e-1",
+	}))
+	require.NoError(t, err)
+
+	cameraID := registerResp.Msg.GetCamera().GetId()
+
+	stream, err := clients.cr.StreamCinematographyResults(ctx, connect.NewRequest(
+		&protov1.StreamCinematographyResultsRequest{CameraIds: []string{cameraID}},
+	))
+	require.NoError(t, err)
+
+	defer func() {
+		_ = stream.Close()
+	}()
+
+	sendResp, err := clients.cr.SendCinematographyInstruction(ctx, connect.NewRequest(
+		&protov1.SendCinematographyInstructionRequest{
+			Instruction: &protov1.CinematographyInstruction{
+				CameraId: cameraID,
+				ShotType: protov1.ShotType_SHOT_TYPE_CLOSE_UP,
+			},
+		},
+	))
+	require.NoError(t, err)
+	require.True(t, sendResp.Msg.GetAccepted())
+	require.NotEmpty(t, sendResp.Msg.GetInstructionId())
+
+	pollResp, err := clients.ptz.Polling(ctx, connect.NewRequest(&protov1.PollingRequest{
+		CameraId: cameraID,
+	}))
+	require.NoError(t, err)
+
+	task := pollResp.Msg.GetCurrentCommand()
+	require.NotNil(t, task)
+	require.Equal(t, protov1.CommandLayer_COMMAND_LAYER_CINEMATIC, task.GetLayer())
+	require.Equal(t, sendResp.Msg.GetInstructionId(), task.GetCinematicCommand().GetInstructionId())
+
+	_, err = clients.ptz.Polling(ctx, connect.NewRequest(&protov1.PollingRequest{
+		CameraId:        cameraID,
+		CompletedTaskId: task.GetTaskId(),
+		CurrentPtz:      &protov1.PTZParameters{Pan: 12, Tilt: 3, Zoom: 2},
+	}))
+	require.NoError(t, err)
+
+	require.True(t, stream.Receive())
+
+	result := stream.Msg().GetResult()
+	require.Equal(t, sendResp.Msg.GetInstructionId(), result.GetInstructionId())
+	require.Equal(t, cameraID, result.GetCameraId())
+	require.True(t, result.GetSuccess())
+	require.InDelta(t, float64(12), float64(result.GetAppliedPtz().GetPan()), 0.01)
+}
+
+func TestSendCinematographyInstruction_UnknownCamera(t *testing.T) {
+	t.Parallel()
+
+	server, clients := newCRTestServer(t)
+	defer server.Close()
+
+	ctx, cancel := context.WithTimeout(t.Context(), 5*time.Second)
+	defer cancel()
+
+	_, err := clients.cr.SendCinematographyInstruction(ctx, connect.NewRequest(
+		&protov1.SendCinematographyInstructionRequest{
+			Instruction: &protov1.CinematographyInstruction{CameraId: "cam-missing"},
+		},
+	))
+	require.Error(t, err)
+	require.Equal(t, connect.CodeNotFound, connect.CodeOf(err))
+}
+
+func TestSendCinematographyInstruction_ReportsReasonPerOutcome(t *testing.T) {
+	t.Parallel()
+
+	repos := newTestRepositories()
+	server, clients := newCRTestServerWithRepos(t, repos)
+	defer server.Close()
+
+	ctx, cancel := context.WithTimeout(t.Context(), 5*time.Second)
+	defer cancel()
+
+	camera := repos.camera.RegisterCamera(&protov1.RegisterCameraRequest{
+		Name:       "e2e-cr-outcome-camera",
+		Mode:       protov1.CameraMode_CAMERA_MODE_AUTONOMOUS,
+		MasterMfId: "mf-e2e-1",
+	})
+
+	stream, err := clients.cr.StreamCinematographyResults(ctx, connect.NewRequest(
+		&protov1.StreamCinematographyResultsRequest{CameraIds: []string{camera.GetId()}},
+	))
+	require.NoError(t, err)
+
+	defer func() {
+		_ = stream.Close()
+	}()
+
+	send := func() string {
+		resp, err := clients.cr.SendCinematographyInstruction(ctx, connect.NewRequest(
+			&protov1.SendCinematographyInstructionRequest{
+				Instruction: &protov1.CinematographyInstruction{
+					CameraId: camera.GetId(),
+					ShotType: protov1.ShotType_SHOT_TYPE_WIDE,
+				},
+			},
+		))
+		require.NoError(t, err)
+		require.True(t, resp.Msg.GetAccepted())
+
+		return resp.Msg.GetInstructionId()
+	}
+
+	receive := func(instructionID string) *protov1.CinematographyResult {
+		require.True(t, stream.Receive())
+
+		result := stream.Msg().GetResult()
+		require.Equal(t, instructionID, result.GetInstructionId())
+		require.False(t, result.GetSuccess())
+
+		return result
+	}
+
+	// 結果の報告を待てなかったタスクは、中断ではなくタイムアウトとして報告する
+	timedOut := send()
+	require.Equal(t, uint32(1), repos.ptz.GetQueueStatus(camera.GetId()).GetCinematicQueueSize())
+
+	current, _, _ := repos.ptz.ProcessPolling(
+		camera.GetId(), "", "", nil,
+		protov1.DeviceStatus_DEVICE_STATUS_EXECUTING, protov1.CameraStatus_CAMERA_STATUS_ONLINE,
+	)
+	require.True(t, repos.ptz.FinishTask(
+		camera.GetId(), current.GetTaskId(), infrastructure.TaskOutcomeTimedOut, nil,
+	))
+	require.Contains(t, receive(timedOut).GetErrorMessage(), "timed out")
+
+	// PTZ命令で破棄されたタスクは、PTZ命令による中断として報告する
+	preempted := send()
+
+	_, accepted := repos.ptz.EnqueuePTZCommand(camera.GetId(), &protov1.PTZCommand{
+		OperationType: protov1.PTZOperationType_PTZ_OPERATION_TYPE_RELATIVE_MOVE,
+		Command: &protov1.PTZCommand_RelativeMove{
+			RelativeMove: &protov1.RelativeMoveCommand{Translation: &protov1.PTZTranslation{PanDelta: 0.1}},
+		},
+	})
+	require.True(t, accepted)
+	require.Equal(t, "interrupted by PTZ command", receive(preempted).GetErrorMessage())
+}
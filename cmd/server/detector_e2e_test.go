@@ -0,0 +1,209 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"math/rand/v2"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"connectrpc.com/connect"
+	"github.com/stretchr/testify/require"
+
+	protov1 "github.com/anyfld/vistra-operation-control-room/gen/proto/v1"
+	"github.com/anyfld/vistra-operation-control-room/pkg/transport/infrastructure"
+)
+
+const (
+	fixtureWidth  = 320
+	fixtureHeight = 180
+)
+
+// newFixtureFrame は灰色の背景に赤い矩形を描いたフレームを作成します。
+func newFixtureFrame() *image.NRGBA {
+	frame := image.NewNRGBA(image.Rect(0, 0, fixtureWidth, fixtureHeight))
+
+	for y := range fixtureHeight {
+		for x := range fixtureWidth {
+			c := color.NRGBA{R: 90, G: 90, B: 90, A: 255}
+			if x >= 200 && x < 260 && y >= 40 && y < 120 {
+				c = color.NRGBA{R: 230, G: 20, B: 20, A: 255}
+			}
+
+			frame.SetNRGBA(x, y, c)
+		}
+	}
+
+	return frame
+}
+
+// newTexturedFrame は 4x4 画素単位のランダムな模様のフレームを作成します。
+func newTexturedFrame() *image.Gray {
+	rng := rand.New(rand.NewPCG(1, 2)) //nolint:gosec
+	frame := image.NewGray(image.Rect(0, 0, fixtureWidth, fixtureHeight))
+
+	for by := 0; by < fixtureHeight; by += 4 {
+		for bx := 0; bx < fixtureWidth; bx += 4 {
+			level := uint8(rng.IntN(256))
+
+			for y := by; y < by+4; y++ {
+				for x := bx; x < bx+4; x++ {
+					frame.SetGray(x, y, color.Gray{Y: level})
+				}
+			}
+		}
+	}
+
+	return frame
+}
+
+func encodeFixture(t *testing.T, format protov1.ImageFormat, img image.Image) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+
+	switch format { //nolint:exhaustive
+	case protov1.ImageFormat_IMAGE_FORMAT_JPEG:
+		require.NoError(t, jpeg.Encode(&buf, img, &jpeg.Options{Quality: 95}))
+	default:
+		require.NoError(t, png.Encode(&buf, img))
+	}
+
+	return buf.Bytes()
+}
+
+func TestProcessImage_DetectsColorBlobInPNGAndRaw(t *testing.T) {
+	t.Parallel()
+
+	server, client := newFDTestServer(t)
+	defer server.Close()
+
+	ctx, cancel := context.WithTimeout(t.Context(), 5*time.Second)
+	defer cancel()
+
+	frame := newFixtureFrame()
+	subjects := []*protov1.Subject{
+		{Id: "red-marker", Type: infrastructure.SubjectTypeColorBlob, Label: "#ff0000"},
+		{Id: "blue-marker", Type: infrastructure.SubjectTypeColorBlob, Label: "#0000ff"},
+	}
+
+	raw := make([]byte, 0, fixtureWidth*fixtureHeight*3)
+	for i := 0; i < len(frame.Pix); i += 4 {
+		raw = append(raw, frame.Pix[i], frame.Pix[i+1], frame.Pix[i+2])
+	}
+
+	images := map[string]*protov1.ImageData{
+		"png": {
+			Format: protov1.ImageFormat_IMAGE_FORMAT_PNG,
+			Data:   encodeFixture(t, protov1.ImageFormat_IMAGE_FORMAT_PNG, frame),
+			Width:  fixtureWidth,
+			Height: fixtureHeight,
+		},
+		"raw": {
+			Format: protov1.ImageFormat_IMAGE_FORMAT_RAW,
+			Data:   raw,
+			Width:  fixtureWidth,
+			Height: fixtureHeight,
+		},
+	}
+
+	for name, img := range images {
+		resp, err := client.ProcessImage(ctx, connect.NewRequest(&protov1.ProcessImageRequest{
+			Image:          img,
+			TargetSubjects: subjects,
+		}))
+		require.NoError(t, err, name)
+
+		// 青い領域は存在しないため、赤だけが検出される
+		require.Len(t, resp.Msg.GetDetectedSubjects(), 1, name)
+
+		detected := resp.Msg.GetDetectedSubjects()[0]
+		require.Equal(t, "red-marker", detected.GetSubject().GetId(), name)
+		require.InDelta(t, 1.0, detected.GetConfidence(), 0.01, name)
+
+		box := detected.GetDetectedBox()
+		require.InDelta(t, 200.0/fixtureWidth, box.GetX(), 0.02, name)
+		require.InDelta(t, 40.0/fixtureHeight, box.GetY(), 0.02, name)
+		require.InDelta(t, 60.0/fixtureWidth, box.GetWidth(), 0.02, name)
+		require.InDelta(t, 80.0/fixtureHeight, box.GetHeight(), 0.02, name)
+	}
+}
+
+func TestProcessImage_MatchesReferenceTemplateInJPEG(t *testing.T) {
+	t.Parallel()
+
+	server, client := newFDTestServer(t)
+	defer server.Close()
+
+	ctx, cancel := context.WithTimeout(t.Context(), 5*time.Second)
+	defer cancel()
+
+	frame := newTexturedFrame()
+	reference := frame.SubImage(image.Rect(100, 48, 164, 112))
+	referencePNG := encodeFixture(t, protov1.ImageFormat_IMAGE_FORMAT_PNG, reference)
+
+	references := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		writer.Header().Set("Content-Type", "image/png")
+		_, _ = writer.Write(referencePNG)
+	}))
+	defer references.Close()
+
+	resp, err := client.ProcessImage(ctx, connect.NewRequest(&protov1.ProcessImageRequest{
+		Image: &protov1.ImageData{
+			Format: protov1.ImageFormat_IMAGE_FORMAT_JPEG,
+			Data:   encodeFixture(t, protov1.ImageFormat_IMAGE_FORMAT_JPEG, frame),
+		},
+		TargetSubjects: []*protov1.Subject{
+			{Id: "logo", Type: "object", ReferenceImageUrl: references.URL + "/logo.png"},
+		},
+	}))
+	require.NoError(t, err)
+	require.Len(t, resp.Msg.GetDetectedSubjects(), 1)
+
+	detected := resp.Msg.GetDetectedSubjects()[0]
+	require.Greater(t, detected.GetConfidence(), float32(0.9))
+
+	box := detected.GetDetectedBox()
+	require.InDelta(t, 100.0/fixtureWidth, box.GetX(), 0.02)
+	require.InDelta(t, 48.0/fixtureHeight, box.GetY(), 0.02)
+	require.InDelta(t, 64.0/fixtureWidth, box.GetWidth(), 0.02)
+	require.InDelta(t, 64.0/fixtureHeight, box.GetHeight(), 0.02)
+}
+
+func TestProcessImage_RejectsMismatchedDimensions(t *testing.T) {
+	t.Parallel()
+
+	server, client := newFDTestServer(t)
+	defer server.Close()
+
+	ctx, cancel := context.WithTimeout(t.Context(), 5*time.Second)
+	defer cancel()
+
+	frame := newFixtureFrame()
+
+	_, err := client.ProcessImage(ctx, connect.NewRequest(&protov1.ProcessImageRequest{
+		Image: &protov1.ImageData{
+			Format: protov1.ImageFormat_IMAGE_FORMAT_PNG,
+			Data:   encodeFixture(t, protov1.ImageFormat_IMAGE_FORMAT_PNG, frame),
+			Width:  640,
+			Height: 360,
+		},
+	}))
+	require.Equal(t, connect.CodeInvalidArgument, connect.CodeOf(err))
+
+	_, err = client.ProcessImage(ctx, connect.NewRequest(&protov1.ProcessImageRequest{
+		Image: &protov1.ImageData{
+			Format: protov1.ImageFormat_IMAGE_FORMAT_RAW,
+			Data:   frame.Pix[:100],
+			Width:  fixtureWidth,
+			Height: fixtureHeight,
+		},
+	}))
+	require.Equal(t, connect.CodeInvalidArgument, connect.CodeOf(err))
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	"connectrpc.com/connect"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/types/known/structpb"
+
+	protov1 "github.com/anyfld/vistra-operation-control-room/gen/proto/v1"
+	handlers "github.com/anyfld/vistra-operation-control-room/pkg/transport/handlers"
+	"github.com/anyfld/vistra-operation-control-room/pkg/transport/infrastructure"
+)
+
+// estimate は GetPTZEstimate で推定のパンと信頼度、移動中かどうかを返します。
+func (f *commandPipelineFixture) estimate(ctx context.Context, t *testing.T) (float64, float64, bool) {
+	t.Helper()
+
+	resp, err := f.getPTZEstimate(ctx, t)
+	require.NoError(t, err)
+
+	fields := resp.GetFields()
+
+	return fields["pan"].GetNumberValue(), fields["confidence"].GetNumberValue(), fields["moving"].GetBoolValue()
+}
+
+func (f *commandPipelineFixture) getPTZEstimate(ctx context.Context, t *testing.T) (*structpb.Struct, error) {
+	t.Helper()
+
+	return f.call(ctx, t, handlers.PTZEstimateServiceGetPTZEstimateProcedure, map[string]any{"camera_id": f.cameraID})
+}
+
+// framedPan は現在のPTZを指定せずに中央の被写体をフレーミングしたパンを返します。
+func (f *commandPipelineFixture) framedPan(ctx context.Context, t *testing.T) float32 {
+	t.Helper()
+
+	resp, err := f.fd.CalculateFraming(ctx, connect.NewRequest(&protov1.CalculateFramingRequest{
+		CameraId:       f.cameraID,
+		TargetShotType: protov1.ShotType_SHOT_TYPE_MEDIUM,
+		TargetSubjects: []*protov1.DetectedSubject{{
+			Subject:     &protov1.Subject{Id: "presenter", Type: "person"},
+			Confidence:  0.9,
+			DetectedBox: &protov1.BoundingBox{X: 0.45, Y: 0.3, Width: 0.1, Height: 0.6},
+		}},
+		TargetAngle: protov1.CameraAngle_CAMERA_ANGLE_EYE_LEVEL,
+	}))
+	require.NoError(t, err)
+	require.True(t, resp.Msg.GetSuccess(), resp.Msg.GetErrorMessage())
+
+	return resp.Msg.GetCalculatedPtz().GetPan()
+}
+
+func TestPTZEstimator_InterpolatesAbsoluteMoveUntilResult(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithTimeout(t.Context(), 5*time.Second)
+	defer cancel()
+
+	fixture := newCommandPipelineFixture(ctx, t)
+
+	// 状態の報告を受けるまでは推定できない
+	_, err := fixture.getPTZEstimate(ctx, t)
+	require.Equal(t, connect.CodeNotFound, connect.CodeOf(err))
+
+	// 報告した直後は報告したPTZがそのまま推定になる
+	reportedAtMs := time.Now().UnixMilli()
+
+	_, err = fixture.clients.ptz.Polling(ctx, connect.NewRequest(&protov1.PollingRequest{
+		CameraId:   fixture.cameraID,
+		CurrentPtz: &protov1.PTZParameters{Pan: 0, Tilt: 0, Zoom: 1},
+	}))
+	require.NoError(t, err)
+
+	resp, err := fixture.getPTZEstimate(ctx, t)
+	require.NoError(t, err)
+
+	observedAtMs := int64(resp.GetFields()["observed_at_ms"].GetNumberValue())
+	require.GreaterOrEqual(t, observedAtMs, reportedAtMs)
+	require.GreaterOrEqual(t, int64(resp.GetFields()["estimated_at_ms"].GetNumberValue()), observedAtMs)
+	require.InDelta(t, 1, resp.GetFields()["zoom"].GetNumberValue(), 0.001)
+
+	pan, confidence, moving := fixture.estimate(ctx, t)
+	require.InDelta(t, 0, pan, 0.001)
+	require.InDelta(t, 1, confidence, 0.01)
+	require.False(t, moving)
+
+	// 既定のパン速度 90度/秒 の 1割で 50度 へ向かうため、到達まで約5.5秒かかる
+	fixture.sendControlCommand(ctx, t, &protov1.ControlCommand{
+		CommandId:     "cmd-estimate-1",
+		Type:          protov1.ControlCommandType_CONTROL_COMMAND_TYPE_PTZ_ABSOLUTE,
+		PtzParameters: &protov1.PTZParameters{Pan: 50, Tilt: 0, Zoom: 1, PanSpeed: 0.1},
+	})
+	require.Equal(t, "cmd-estimate-1", fixture.receive(t).GetCommand().GetCommandId())
+
+	require.Eventually(t, func() bool {
+		pan, confidence, moving = fixture.estimate(ctx, t)
+
+		// 1度 進むまでに約110ms 推定で動かしているため、信頼度の低下が小数第3位に現れる
+		return moving && pan > 1
+	}, 2*time.Second, 20*time.Millisecond)
+	require.Less(t, pan, float64(50))
+	require.Less(t, confidence, float64(1))
+
+	// GetCamera もメタデータに推定を設定し、current_ptz は報告された値のままにする
+	cameraResp, err := fixture.clients.camera.GetCamera(ctx, connect.NewRequest(&protov1.GetCameraRequest{
+		CameraId: fixture.cameraID,
+	}))
+	require.NoError(t, err)
+
+	metadata := cameraResp.Msg.GetCamera().GetMetadata()
+	metadataPan, err := strconv.ParseFloat(metadata[infrastructure.CameraMetadataEstimatedPan], 64)
+	require.NoError(t, err)
+	require.Greater(t, metadataPan, pan-0.001)
+	require.Less(t, metadataPan, float64(50))
+	require.Equal(t, "true", metadata[infrastructure.CameraMetadataEstimatedMoving])
+	require.Equal(t, strconv.FormatInt(observedAtMs, 10), metadata[infrastructure.CameraMetadataEstimatedObservedAt])
+	require.Contains(t, metadata, infrastructure.CameraMetadataEstimatedConfidence)
+	require.InDelta(t, 0, cameraResp.Msg.GetCamera().GetCurrentPtz().GetPan(), 0.001)
+
+	// 現在のPTZを指定しないフレーミングは、移動中の推定の位置を基準にする
+	framed := fixture.framedPan(ctx, t)
+	require.Positive(t, framed)
+	require.Less(t, framed, float32(50))
+
+	// 完了が報告されると目標に到達したものとし、移動を終える
+	require.NoError(t, fixture.stream.Send(&protov1.StreamControlCommandsRequest{
+		Message: &protov1.StreamControlCommandsRequest_Result{
+			Result: &protov1.ControlCommandResult{CommandId: "cmd-estimate-1", Success: true},
+		},
+	}))
+
+	for range 2 {
+		require.Equal(t, "cmd-estimate-1", fixture.receive(t).GetResult().GetCommandId())
+	}
+
+	require.Eventually(t, func() bool {
+		pan, _, moving = fixture.estimate(ctx, t)
+
+		return !moving && pan == 50
+	}, 2*time.Second, 20*time.Millisecond)
+	require.InDelta(t, 50, fixture.framedPan(ctx, t), 0.001)
+}
+
+func TestPTZEstimator_IntegratesContinuousMoveUntilTimeout(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithTimeout(t.Context(), 5*time.Second)
+	defer cancel()
+
+	fixture := newCommandPipelineFixture(ctx, t)
+
+	_, err := fixture.clients.ptz.Polling(ctx, connect.NewRequest(&protov1.PollingRequest{
+		CameraId:   fixture.cameraID,
+		CurrentPtz: &protov1.PTZParameters{Pan: 10, Tilt: 0, Zoom: 1},
+	}))
+	require.NoError(t, err)
+
+	// 既定のパン速度 90度/秒 の 5割で左へ 200ms 動くため、約9度 左で止まる
+	fixture.sendControlCommand(ctx, t, &protov1.ControlCommand{
+		CommandId:     "cmd-estimate-continuous",
+		Type:          protov1.ControlCommandType_CONTROL_COMMAND_TYPE_PTZ_CONTINUOUS,
+		PtzParameters: &protov1.PTZParameters{PanSpeed: -0.5},
+		TimeoutMs:     200,
+	})
+	require.Equal(t, "cmd-estimate-continuous", fixture.receive(t).GetCommand().GetCommandId())
+
+	var (
+		pan        float64
+		confidence float64
+		moving     bool
+	)
+
+	require.Eventually(t, func() bool {
+		pan, confidence, moving = fixture.estimate(ctx, t)
+
+		return !moving && pan < 10
+	}, 2*time.Second, 20*time.Millisecond)
+	require.InDelta(t, 1, pan, 0.5)
+	// 推定で 200ms 動かした分だけ信頼度が下がる
+	require.Less(t, confidence, 0.97)
+
+	// 新しい報告で推定は報告の値に戻り、信頼度も回復する
+	_, err = fixture.clients.ptz.Polling(ctx, connect.NewRequest(&protov1.PollingRequest{
+		CameraId:   fixture.cameraID,
+		CurrentPtz: &protov1.PTZParameters{Pan: 2, Tilt: 0, Zoom: 1},
+	}))
+	require.NoError(t, err)
+
+	pan, confidence, _ = fixture.estimate(ctx, t)
+	require.InDelta(t, 2, pan, 0.001)
+	require.InDelta(t, 1, confidence, 0.01)
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"connectrpc.com/connect"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/types/known/structpb"
+
+	protov1 "github.com/anyfld/vistra-operation-control-room/gen/proto/v1"
+	"github.com/anyfld/vistra-operation-control-room/pkg/transport/handlers"
+	"github.com/anyfld/vistra-operation-control-room/pkg/transport/infrastructure"
+)
+
+func subscribeEvents(
+	ctx context.Context,
+	t *testing.T,
+	serverURL string,
+	request map[string]any,
+) *connect.ServerStreamForClient[structpb.Struct] {
+	t.Helper()
+
+	msg, err := structpb.NewStruct(request)
+	require.NoError(t, err)
+
+	client := connect.NewClient[structpb.Struct, structpb.Struct](
+		http.DefaultClient,
+		serverURL+handlers.EventServiceSubscribeEventsProcedure,
+	)
+
+	stream, err := client.CallServerStream(ctx, connect.NewRequest(msg))
+	require.NoError(t, err)
+
+	return stream
+}
+
+func receiveEvent(t *testing.T, stream *connect.ServerStreamForClient[structpb.Struct]) *structpb.Struct {
+	t.Helper()
+
+	require.True(t, stream.Receive(), "stream closed: %v", stream.Err())
+
+	return stream.Msg()
+}
+
+func TestSubscribeEvents_DeliversCameraAndTaskEvents(t *testing.T) {
+	t.Parallel()
+
+	server, clients := newRundownTestServer(t)
+	defer server.Close()
+
+	ctx, cancel := context.WithTimeout(t.Context(), 5*time.Second)
+	defer cancel()
+
+	stream := subscribeEvents(ctx, t, server.URL, map[string]any{"topics": []any{"camera", "task"}})
+	defer stream.Close()
+
+	registerResp, err := clients.camera.RegisterCamera(ctx, connect.NewRequest(&protov1.RegisterCameraRequest{
+		Name:       "events-camera",
+		Mode:       protov1.CameraMode_CAMERA_MODE_AUTONOMOUS,
+		MasterMfId: "mf-events-1",
+	}))
+	require.NoError(t, err)
+
+	cameraID := registerResp.Msg.GetCamera().GetId()
+
+	registered := receiveEvent(t, stream)
+	require.Equal(t, "camera", registered.GetFields()["topic"].GetStringValue())
+	require.Equal(t, "camera.registered", registered.GetFields()["type"].GetStringValue())
+	require.Equal(t, cameraID, registered.GetFields()["camera_id"].GetStringValue())
+	require.Equal(t, "events-camera",
+		registered.GetFields()["payload"].GetStructValue().GetFields()["name"].GetStringValue())
+
+	ptzResp, err := clients.ptz.SendPTZCommand(ctx, connect.NewRequest(&protov1.SendPTZCommandRequest{
+		CameraId: cameraID,
+		Command: &protov1.PTZCommand{
+			OperationType: protov1.PTZOperationType_PTZ_OPERATION_TYPE_ABSOLUTE_MOVE,
+			Command: &protov1.PTZCommand_AbsoluteMove{
+				AbsoluteMove: &protov1.AbsoluteMoveCommand{
+					Position: &protov1.PTZPosition{X: 0.3, Y: 0.1, Z: 1},
+				},
+			},
+		},
+	}))
+	require.NoError(t, err)
+	require.True(t, ptzResp.Msg.GetAccepted())
+
+	enqueued := receiveEvent(t, stream)
+	require.Equal(t, "task", enqueued.GetFields()["topic"].GetStringValue())
+	require.Equal(t, "task.enqueued", enqueued.GetFields()["type"].GetStringValue())
+	require.Equal(t, cameraID, enqueued.GetFields()["camera_id"].GetStringValue())
+	require.Greater(t,
+		enqueued.GetFields()["sequence"].GetNumberValue(),
+		registered.GetFields()["sequence"].GetNumberValue(),
+	)
+}
+
+func TestSubscribeEvents_FiltersByTopic(t *testing.T) {
+	t.Parallel()
+
+	server, clients := newRundownTestServer(t)
+	defer server.Close()
+
+	ctx, cancel := context.WithTimeout(t.Context(), 5*time.Second)
+	defer cancel()
+
+	stream := subscribeEvents(ctx, t, server.URL, map[string]any{"topics": []any{"output"}})
+	defer stream.Close()
+
+	_, err := clients.camera.RegisterCamera(ctx, connect.NewRequest(&protov1.RegisterCameraRequest{
+		Name:       "ignored-camera",
+		Mode:       protov1.CameraMode_CAMERA_MODE_AUTONOMOUS,
+		MasterMfId: "mf-events-2",
+	}))
+	require.NoError(t, err)
+
+	_, err = clients.md.ConfigureVideoOutput(ctx, connect.NewRequest(&protov1.ConfigureVideoOutputRequest{
+		Config: &protov1.VideoOutputConfig{Id: "program", Name: "Program"},
+	}))
+	require.NoError(t, err)
+
+	event := receiveEvent(t, stream)
+	require.Equal(t, "output", event.GetFields()["topic"].GetStringValue())
+	require.Equal(t, "output.configured", event.GetFields()["type"].GetStringValue())
+	require.Equal(t, "program", event.GetFields()["resource_id"].GetStringValue())
+}
+
+func TestSubscribeEvents_EndsWithErrorWhenSubscriberFallsBehind(t *testing.T) {
+	t.Parallel()
+
+	repos := newTestRepositories()
+
+	server, _ := startCRTestServer(t, setupHandlers(t.Context(), repos, nil))
+	defer server.Close()
+
+	ctx, cancel := context.WithTimeout(t.Context(), 10*time.Second)
+	defer cancel()
+
+	stream := subscribeEvents(ctx, t, server.URL, map[string]any{"topics": []any{"config"}})
+	defer stream.Close()
+
+	// 受信しない間にトランスポートのバッファと購読のバッファを溢れさせる
+	payload, err := structpb.NewStruct(map[string]any{"blob": strings.Repeat("x", 16*1024)})
+	require.NoError(t, err)
+
+	for range 1024 {
+		repos.events.Publish(infrastructure.EventTopicConfig, infrastructure.EventConfigPushed, "", "", payload)
+	}
+
+	// 溢れる前のイベントは欠けずに届き、その後はイベントを取りこぼしたことをエラーで通知する
+	previous := float64(0)
+
+	for stream.Receive() {
+		sequence := stream.Msg().GetFields()["sequence"].GetNumberValue()
+		if previous > 0 {
+			require.InDelta(t, previous+1, sequence, 0)
+		}
+
+		previous = sequence
+	}
+
+	require.Equal(t, connect.CodeResourceExhausted, connect.CodeOf(stream.Err()))
+	require.Less(t, previous, float64(1024))
+}
+
+func TestSubscribeEvents_RejectsUnknownTopic(t *testing.T) {
+	t.Parallel()
+
+	server, _ := newRundownTestServer(t)
+	defer server.Close()
+
+	ctx, cancel := context.WithTimeout(t.Context(), 5*time.Second)
+	defer cancel()
+
+	stream := subscribeEvents(ctx, t, server.URL, map[string]any{"topics": []any{"weather"}})
+	defer stream.Close()
+
+	require.False(t, stream.Receive())
+	require.Equal(t, connect.CodeInvalidArgument, connect.CodeOf(stream.Err()))
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"testing"
+	"time"
+
+	"connectrpc.com/connect"
+	"github.com/stretchr/testify/require"
+
+	protov1 "github.com/anyfld/vistra-operation-control-room/gen/proto/v1"
+	"github.com/anyfld/vistra-operation-control-room/pkg/transport/handlers"
+)
+
+type controlCommandStream = connect.BidiStreamForClient[
+	protov1.StreamControlCommandsRequest,
+	protov1.StreamControlCommandsResponse,
+]
+
+func openControlCommandStream(ctx context.Context, url string) *controlCommandStream {
+	client := connect.NewClient[protov1.StreamControlCommandsRequest, protov1.StreamControlCommandsResponse](
+		newH2CClient(), url+handlers.FDServiceControlCommandStreamProcedure,
+	)
+
+	return client.CallBidiStream(ctx)
+}
+
+func TestControlCommandStream_DeliversCommandsAndAcceptsResults(t *testing.T) {
+	t.Parallel()
+
+	fixture := newControlCommandFixture(t)
+
+	ctx, cancel := context.WithTimeout(t.Context(), 5*time.Second)
+	defer cancel()
+
+	stream := openControlCommandStream(ctx, fixture.url)
+
+	require.NoError(t, stream.Send(&protov1.StreamControlCommandsRequest{
+		Message: &protov1.StreamControlCommandsRequest_Init{
+			Init: &protov1.StreamControlCommandsInit{CameraId: fixture.cameraID},
+		},
+	}))
+
+	connected, err := stream.Receive()
+	require.NoError(t, err)
+	require.True(t, connected.GetStatus().GetConnected())
+
+	// init を送り直さなくても、続けて配信された制御コマンドを順にすべて受け取る
+	for i := range 5 {
+		fixture.sendFocus(ctx, t, fmt.Sprintf("cmd-stream-%d", i))
+	}
+
+	for i := range 5 {
+		resp, err := stream.Receive()
+		require.NoError(t, err)
+		require.Equal(t, fmt.Sprintf("cmd-stream-%d", i), resp.GetCommand().GetCommandId())
+		require.Nil(t, resp.GetResult())
+	}
+
+	status, err := fixture.status(ctx, t, "cmd-stream-4", false)
+	require.NoError(t, err)
+	require.Equal(t, "dispatched", status.GetFields()["state"].GetStringValue())
+
+	// 同じストリームで結果を報告すると、応答と結果のイベントの両方が届く
+	require.NoError(t, stream.Send(&protov1.StreamControlCommandsRequest{
+		Message: &protov1.StreamControlCommandsRequest_Result{Result: &protov1.ControlCommandResult{
+			CommandId: "cmd-stream-4",
+			Success:   true,
+		}},
+	}))
+
+	for range 2 {
+		resp, err := stream.Receive()
+		require.NoError(t, err)
+		require.Equal(t, "cmd-stream-4", resp.GetResult().GetCommandId())
+		require.True(t, resp.GetResult().GetSuccess())
+	}
+
+	status, err = fixture.status(ctx, t, "cmd-stream-4", false)
+	require.NoError(t, err)
+	require.Equal(t, "completed", status.GetFields()["state"].GetStringValue())
+
+	// 処理に失敗したメッセージはストリームを閉じずに Status で返す
+	require.NoError(t, stream.Send(&protov1.StreamControlCommandsRequest{
+		Message: &protov1.StreamControlCommandsRequest_Result{Result: &protov1.ControlCommandResult{
+			CommandId: "cmd-stream-unknown",
+		}},
+	}))
+
+	resp, err := stream.Receive()
+	require.NoError(t, err)
+	require.True(t, resp.GetStatus().GetConnected())
+	require.Contains(t, resp.GetStatus().GetMessage(), "control command not found")
+
+	require.NoError(t, stream.Send(&protov1.StreamControlCommandsRequest{
+		Message: &protov1.StreamControlCommandsRequest_State{State: &protov1.CameraState{
+			CameraId:   fixture.cameraID,
+			CurrentPtz: &protov1.PTZParameters{Pan: 3, Tilt: 2, Zoom: 1},
+			Status:     protov1.CameraStatus_CAMERA_STATUS_ONLINE,
+		}},
+	}))
+
+	resp, err = stream.Receive()
+	require.NoError(t, err)
+	require.Equal(t, "camera state updated", resp.GetStatus().GetMessage())
+
+	// 送信を終えるとサーバーもストリームを閉じる
+	require.NoError(t, stream.CloseRequest())
+
+	_, err = stream.Receive()
+	require.True(t, errors.Is(err, io.EOF), "unexpected error: %v", err)
+	require.NoError(t, stream.CloseResponse())
+}
+
+func TestControlCommandStream_RequiresInit(t *testing.T) {
+	t.Parallel()
+
+	fixture := newControlCommandFixture(t)
+
+	ctx, cancel := context.WithTimeout(t.Context(), 5*time.Second)
+	defer cancel()
+
+	stream := openControlCommandStream(ctx, fixture.url)
+
+	require.NoError(t, stream.Send(&protov1.StreamControlCommandsRequest{
+		Message: &protov1.StreamControlCommandsRequest_Result{Result: &protov1.ControlCommandResult{
+			CommandId: "cmd-stream-1",
+		}},
+	}))
+
+	_, err := stream.Receive()
+	require.Equal(t, connect.CodeInvalidArgument, connect.CodeOf(err))
+	require.NoError(t, stream.CloseResponse())
+}
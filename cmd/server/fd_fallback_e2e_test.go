@@ -0,0 +1,252 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"connectrpc.com/connect"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+
+	protov1 "github.com/anyfld/vistra-operation-control-room/gen/proto/v1"
+	"github.com/anyfld/vistra-operation-control-room/pkg/transport/handlers"
+)
+
+type fdFallbackPollResponse struct {
+	Sequence    uint64          `json:"sequence"`
+	Command     json.RawMessage `json:"command"`
+	Result      json.RawMessage `json:"result"`
+	TimestampMs int64           `json:"timestamp_ms"`
+}
+
+// fallbackRequest はFDとしてプレーンな HTTP/1.1 でリクエストを送り、ステータスコードと本文を返します。
+func fallbackRequest(
+	ctx context.Context,
+	t *testing.T,
+	method string,
+	url string,
+	body string,
+) (int, []byte) {
+	t.Helper()
+
+	req, err := http.NewRequestWithContext(ctx, method, url, strings.NewReader(body))
+	require.NoError(t, err)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+
+	data, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+
+	return resp.StatusCode, data
+}
+
+func fallbackCameraURL(serverURL string, cameraID string) string {
+	return serverURL + handlers.FDFallbackPathPrefix + "/cameras/" + cameraID
+}
+
+func pollFallback(
+	ctx context.Context,
+	t *testing.T,
+	cameraURL string,
+	query string,
+) (int, *fdFallbackPollResponse) {
+	t.Helper()
+
+	status, body := fallbackRequest(ctx, t, http.MethodGet, cameraURL+"/commands?"+query, "")
+	if status != http.StatusOK {
+		return status, nil
+	}
+
+	var resp fdFallbackPollResponse
+	require.NoError(t, json.Unmarshal(body, &resp))
+
+	return status, &resp
+}
+
+func unmarshalFallbackMessage[T any, M interface {
+	*T
+	proto.Message
+}](t *testing.T, data []byte) M {
+	t.Helper()
+
+	message := M(new(T))
+	require.NoError(t, protojson.Unmarshal(data, message))
+
+	return message
+}
+
+func TestFDFallback_ControlCommandRoundTripOverPlainJSON(t *testing.T) {
+	t.Parallel()
+
+	repos := newTestRepositories()
+	server, clients := startCRTestServer(t, setupHandlers(t.Context(), repos, nil))
+	defer server.Close()
+
+	ctx, cancel := context.WithTimeout(t.Context(), 10*time.Second)
+	defer cancel()
+
+	registerResp, err := clients.camera.RegisterCamera(ctx, connect.NewRequest(&protov1.RegisterCameraRequest{
+		Name:         "fallback-camera",
+		Mode:         protov1.CameraMode_CAMERA_MODE_AUTONOMOUS,
+		MasterMfId:   "mf-fallback-1",
+		Capabilities: &protov1.CameraCapabilities{SupportsPtz: true},
+	}))
+	require.NoError(t, err)
+
+	cameraID := registerResp.Msg.GetCamera().GetId()
+	cameraURL := fallbackCameraURL(server.URL, cameraID)
+
+	// camera_id は省略するとパスのカメラIDになる
+	status, _ := fallbackRequest(ctx, t, http.MethodPost, cameraURL+"/state",
+		`{"current_ptz":{"pan":5,"tilt":2,"zoom":1},"status":"CAMERA_STATUS_ONLINE"}`)
+	require.Equal(t, http.StatusNoContent, status)
+	require.InDelta(t, float64(5), float64(repos.fd.GetCameraState(cameraID).GetCurrentPtz().GetPan()), 0.01)
+
+	status, _ = fallbackRequest(ctx, t, http.MethodPost, cameraURL+"/state", `{"camera_id":"other-camera"}`)
+	require.Equal(t, http.StatusBadRequest, status)
+
+	// エラーは Connect のハンドラーと同じコードに対応するステータスで返す
+	status, _ = fallbackRequest(ctx, t, http.MethodPost, fallbackCameraURL(server.URL, "unknown-camera")+"/state",
+		`{"status":"CAMERA_STATUS_ONLINE"}`)
+	require.Equal(t, http.StatusNotFound, status)
+
+	// 操作卓からの制御コマンドは結果が報告されるまで応答を待つ
+	sendReq, err := http.NewRequestWithContext(ctx, http.MethodPost, cameraURL+"/commands", strings.NewReader(
+		`{"command_id":"cmd-fallback-1","type":"CONTROL_COMMAND_TYPE_PTZ_ABSOLUTE",`+
+			`"ptz_parameters":{"pan":20,"tilt":10,"zoom":1}}`,
+	))
+	require.NoError(t, err)
+
+	sent := make(chan *http.Response, 1)
+	sendErr := make(chan error, 1)
+
+	go func() {
+		resp, err := http.DefaultClient.Do(sendReq)
+		if err != nil {
+			sendErr <- err
+
+			return
+		}
+
+		sent <- resp
+	}()
+
+	status, polled := pollFallback(ctx, t, cameraURL, "timeout_ms=5000")
+	require.Equal(t, http.StatusOK, status)
+	require.Nil(t, polled.Result)
+
+	command := unmarshalFallbackMessage[protov1.ControlCommand](t, polled.Command)
+	require.Equal(t, "cmd-fallback-1", command.GetCommandId())
+	require.Equal(t, cameraID, command.GetCameraId())
+	require.InDelta(t, float64(20), float64(command.GetPtzParameters().GetPan()), 0.01)
+	require.Contains(t, string(polled.Command), `"ptz_parameters"`)
+
+	status, body := fallbackRequest(ctx, t, http.MethodPost, cameraURL+"/results",
+		`{"command_id":"cmd-fallback-1","success":true}`)
+	require.Equal(t, http.StatusOK, status)
+	require.True(t, unmarshalFallbackMessage[protov1.ControlCommandResult](t, body).GetSuccess())
+
+	select {
+	case err := <-sendErr:
+		require.NoError(t, err)
+	case resp := <-sent:
+		defer func() {
+			_ = resp.Body.Close()
+		}()
+
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+
+		data, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+
+		reported := unmarshalFallbackMessage[protov1.ControlCommandResult](t, data)
+		require.Equal(t, "cmd-fallback-1", reported.GetCommandId())
+		require.True(t, reported.GetSuccess())
+	case <-ctx.Done():
+		require.FailNow(t, "control command was not answered")
+	}
+
+	// 前回の sequence から再開すると、取りこぼしなく結果を受け取る
+	status, resumed := pollFallback(ctx, t, cameraURL, "timeout_ms=5000&after_sequence="+
+		strconv.FormatUint(polled.Sequence, 10))
+	require.Equal(t, http.StatusOK, status)
+	require.Equal(t, polled.Sequence+1, resumed.Sequence)
+	require.Equal(t, "cmd-fallback-1",
+		unmarshalFallbackMessage[protov1.ControlCommandResult](t, resumed.Result).GetCommandId())
+
+	status, _ = pollFallback(ctx, t, cameraURL, "timeout_ms=50&after_sequence="+
+		strconv.FormatUint(resumed.Sequence, 10))
+	require.Equal(t, http.StatusNoContent, status)
+
+	status, _ = pollFallback(ctx, t, cameraURL, "timeout_ms=50&after_sequence=1000")
+	require.Equal(t, http.StatusGone, status)
+
+	status, _ = fallbackRequest(ctx, t, http.MethodPost, cameraURL+"/results", `{"command_id":"cmd-unknown"}`)
+	require.Equal(t, http.StatusNotFound, status)
+
+	status, _ = fallbackRequest(ctx, t, http.MethodPost, cameraURL+"/results", `{"unknown_field":1}`)
+	require.Equal(t, http.StatusBadRequest, status)
+}
+
+func TestFDFallback_PTZPollingOverPlainJSON(t *testing.T) {
+	t.Parallel()
+
+	repos := newTestRepositories()
+	server, clients := startCRTestServer(t, setupHandlers(t.Context(), repos, nil))
+	defer server.Close()
+
+	ctx, cancel := context.WithTimeout(t.Context(), 5*time.Second)
+	defer cancel()
+
+	registerResp, err := clients.camera.RegisterCamera(ctx, connect.NewRequest(&protov1.RegisterCameraRequest{
+		Name:       "fallback-ptz-camera",
+		Mode:       protov1.CameraMode_CAMERA_MODE_AUTONOMOUS,
+		MasterMfId: "mf-fallback-2",
+	}))
+	require.NoError(t, err)
+
+	cameraID := registerResp.Msg.GetCamera().GetId()
+	cameraURL := fallbackCameraURL(server.URL, cameraID)
+
+	ptzResp, err := clients.ptz.SendPTZCommand(ctx, connect.NewRequest(&protov1.SendPTZCommandRequest{
+		CameraId: cameraID,
+		Command: &protov1.PTZCommand{
+			OperationType: protov1.PTZOperationType_PTZ_OPERATION_TYPE_ABSOLUTE_MOVE,
+			Command: &protov1.PTZCommand_AbsoluteMove{
+				AbsoluteMove: &protov1.AbsoluteMoveCommand{
+					Position: &protov1.PTZPosition{X: 0.3, Y: 0.1, Z: 1},
+				},
+			},
+		},
+	}))
+	require.NoError(t, err)
+	require.True(t, ptzResp.Msg.GetAccepted())
+
+	// 本文を省略してもパスのカメラIDでポーリングできる
+	status, body := fallbackRequest(ctx, t, http.MethodPost, cameraURL+"/ptz/polling", "")
+	require.Equal(t, http.StatusOK, status)
+	require.Contains(t, string(body), `"current_command"`)
+
+	polled := unmarshalFallbackMessage[protov1.PollingResponse](t, body)
+	task := polled.GetCurrentCommand()
+	require.NotNil(t, task)
+	require.InDelta(t, 0.3, float64(task.GetPtzCommand().GetAbsoluteMove().GetPosition().GetX()), 0.001)
+
+	status, body = fallbackRequest(ctx, t, http.MethodPost, cameraURL+"/ptz/polling",
+		`{"completed_task_id":"`+task.GetTaskId()+`","camera_status":"CAMERA_STATUS_ONLINE"}`)
+	require.Equal(t, http.StatusOK, status)
+	require.Nil(t, unmarshalFallbackMessage[protov1.PollingResponse](t, body).GetCurrentCommand())
+}
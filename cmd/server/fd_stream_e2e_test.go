@@ -12,25 +12,25 @@ import (
 	"connectrpc.com/connect"
 	"github.com/stretchr/testify/require"
 	"golang.org/x/net/http2"
-	"golang.org/x/net/http2/h2c"
 
 	protov1 "github.com/anyfld/vistra-operation-control-room/gen/proto/v1"
 	"github.com/anyfld/vistra-operation-control-room/gen/proto/v1/protov1connect"
-	"github.com/anyfld/vistra-operation-control-room/pkg/transport/infrastructure"
 )
 
 func newFDTestServer(t *testing.T) (*httptest.Server, protov1connect.FDServiceClient) {
 	t.Helper()
 
-	cameraRepo := infrastructure.NewCameraRepo()
 	mux := http.NewServeMux()
-	registerFDService(mux, cameraRepo)
+	fdHandler, _ := registerFDService(t.Context(), mux, newTestRepositories(), nil)
+	registerPatternMatchingAPI(mux, fdHandler, func(h http.Handler) http.Handler { return h })
 
-	handler := h2c.NewHandler(mux, &http2.Server{})
-	server := httptest.NewUnstartedServer(handler)
-	server.EnableHTTP2 = false
-	server.Start()
+	server := startH2CServer(t, mux)
 
+	return server, protov1connect.NewFDServiceClient(newH2CClient(), server.URL)
+}
+
+// newH2CClient は TLS を使わない HTTP/2 のクライアントを返します。双方向ストリームには HTTP/2 が必要です。
+func newH2CClient() *http.Client {
 	transport := &http2.Transport{
 		AllowHTTP: true,
 		DialTLS: func(network, addr string, cfg *tls.Config) (net.Conn, error) {
@@ -38,10 +38,7 @@ func newFDTestServer(t *testing.T) (*httptest.Server, protov1connect.FDServiceCl
 		},
 	}
 
-	client := &http.Client{Transport: transport}
-	fdClient := protov1connect.NewFDServiceClient(client, server.URL)
-
-	return server, fdClient
+	return &http.Client{Transport: transport}
 }
 
 func TestStreamControlCommands_PTZPubSub(t *testing.T) {
@@ -91,8 +88,13 @@ func TestStreamControlCommands_PTZPubSub(t *testing.T) {
 	require.NoError(t, err)
 	require.NotNil(t, commandResp)
 	require.NotNil(t, commandResp.Msg)
-	require.NotNil(t, commandResp.Msg.GetResult())
+	// 結果はFDが報告するまで設定されない
+	require.Nil(t, commandResp.Msg.GetResult())
 
+	commandID := commandResp.Msg.GetCommand().GetCommandId()
+	require.NotEmpty(t, commandID)
+
+	reported := false
 	deadline := time.Now().Add(2 * time.Second)
 
 	var gotResult *protov1.ControlCommandResult
@@ -118,6 +120,20 @@ func TestStreamControlCommands_PTZPubSub(t *testing.T) {
 			require.InDelta(t, float64(10), float64(cmd.GetPtzParameters().GetPan()), 0.01)
 			require.InDelta(t, float64(5), float64(cmd.GetPtzParameters().GetTilt()), 0.01)
 			require.InDelta(t, float64(2), float64(cmd.GetPtzParameters().GetZoom()), 0.01)
+
+			// FDとして実行結果を報告する
+			if !reported && pollResp.Msg.GetResult() == nil {
+				_, reportErr := client.StreamControlCommands(ctx, connect.NewRequest(&protov1.StreamControlCommandsRequest{
+					Message: &protov1.StreamControlCommandsRequest_Result{Result: &protov1.ControlCommandResult{
+						CommandId:       commandID,
+						Success:         true,
+						ExecutionTimeMs: 120,
+					}},
+				}))
+				require.NoError(t, reportErr)
+
+				reported = true
+			}
 		}
 
 		if res := pollResp.Msg.GetResult(); res != nil {
@@ -131,5 +147,6 @@ func TestStreamControlCommands_PTZPubSub(t *testing.T) {
 
 	require.NotNil(t, gotResult)
 	require.True(t, gotResult.GetSuccess())
-	require.NotEmpty(t, gotResult.GetCommandId())
+	require.Equal(t, commandID, gotResult.GetCommandId())
+	require.Equal(t, uint32(120), gotResult.GetExecutionTimeMs())
 }
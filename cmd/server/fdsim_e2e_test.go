@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"math"
+	"testing"
+	"time"
+
+	"connectrpc.com/connect"
+	"github.com/stretchr/testify/require"
+
+	protov1 "github.com/anyfld/vistra-operation-control-room/gen/proto/v1"
+	"github.com/anyfld/vistra-operation-control-room/pkg/fdsim"
+)
+
+// startFDSim はテスト用のサーバーに仮想カメラを登録し、CRのクライアントと共に返します。
+func startFDSim(ctx context.Context, t *testing.T, mode fdsim.Mode, cameras int) (*fdsim.Simulator, crTestClients) {
+	t.Helper()
+
+	repos := newTestRepositories()
+	server, clients := startCRTestServer(t, setupHandlers(t.Context(), repos, nil))
+	t.Cleanup(server.Close)
+
+	config := fdsim.DefaultConfig()
+	config.Cameras = cameras
+	config.Mode = mode
+	config.PollInterval = 50 * time.Millisecond
+	config.StateInterval = 100 * time.Millisecond
+	config.TickInterval = 10 * time.Millisecond
+
+	sim, err := fdsim.New(config, newH2CClient(), server.URL)
+	require.NoError(t, err)
+
+	simCtx, cancel := context.WithCancel(ctx)
+	require.NoError(t, sim.Start(simCtx))
+	t.Cleanup(func() {
+		cancel()
+		sim.Wait()
+	})
+
+	return sim, clients
+}
+
+func TestFDSim_ScriptMovesAndInjectsFaults(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithTimeout(t.Context(), 10*time.Second)
+	defer cancel()
+
+	sim, clients := startFDSim(ctx, t, fdsim.ModeBoth, 2)
+
+	script, err := fdsim.ParseScript([]byte(`
+name: smoke
+steps:
+  - name: absolute move
+    command:
+      type: CONTROL_COMMAND_TYPE_PTZ_ABSOLUTE
+      ptz_parameters: {pan: 45, tilt: 10, zoom: 2, pan_speed: 1, tilt_speed: 1, zoom_speed: 1}
+    expect: {pan: 45, tilt: 10, zoom: 2, outcome: completed, within: 3s}
+  - name: relative move on the second camera
+    camera: 1
+    command:
+      type: CONTROL_COMMAND_TYPE_PTZ_RELATIVE
+      ptz_parameters: {pan: -20, tilt: 5, pan_speed: 1, tilt_speed: 1}
+    expect: {pan: -20, tilt: 5, outcome: completed, within: 3s}
+  - name: injected error
+    faults: {error_rate: 1}
+    command:
+      type: CONTROL_COMMAND_TYPE_PTZ_ABSOLUTE
+      ptz_parameters: {pan: -45}
+    expect: {pan: 45, outcome: failed, within: 3s}
+  - name: injected drop
+    faults: {drop_rate: 1}
+    command:
+      type: CONTROL_COMMAND_TYPE_PTZ_ABSOLUTE
+      ptz_parameters: {pan: -45}
+    expect: {pan: 45, outcome: dropped, within: 3s}
+`))
+	require.NoError(t, err)
+	require.NoError(t, sim.RunScript(ctx, script))
+
+	// 仮想カメラが報告した状態がCRのカメラに反映される
+	cameraID := sim.Cameras()[0].ID()
+	require.Eventually(t, func() bool {
+		resp, err := clients.camera.GetCamera(ctx, connect.NewRequest(&protov1.GetCameraRequest{CameraId: cameraID}))
+
+		return err == nil && math.Abs(float64(resp.Msg.GetCamera().GetCurrentPtz().GetPan())-45) < 0.01
+	}, 3*time.Second, 20*time.Millisecond)
+
+	// 期待どおりにならない手順は失敗する
+	failing, err := fdsim.ParseScript([]byte(`
+steps:
+  - expect: {pan: 90, within: 100ms}
+`))
+	require.NoError(t, err)
+	require.ErrorIs(t, sim.RunScript(ctx, failing), fdsim.ErrScriptFailed)
+}
+
+func TestFDSim_PollingModeCompletesPTZTasksWithSpeedLimit(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithTimeout(t.Context(), 10*time.Second)
+	defer cancel()
+
+	sim, _ := startFDSim(ctx, t, fdsim.ModePolling, 1)
+	camera := sim.Cameras()[0]
+
+	// 既定のパン速度 90度/秒 の半分で 90度 動くため、到達まで約2秒かかる
+	script, err := fdsim.ParseScript([]byte(`
+steps:
+  - command:
+      command_id: cmd-fdsim-polling-1
+      type: CONTROL_COMMAND_TYPE_PTZ_ABSOLUTE
+      ptz_parameters: {pan: 90, pan_speed: 0.5}
+    wait: 500ms
+`))
+	require.NoError(t, err)
+
+	start := time.Now()
+	require.NoError(t, sim.RunScript(ctx, script))
+
+	pan := camera.Position().GetPan()
+	require.Greater(t, pan, float32(0))
+	require.Less(t, pan, float32(45))
+	require.True(t, camera.Moving())
+
+	require.Eventually(t, func() bool {
+		outcome, ok := camera.Outcome("cmd-fdsim-polling-1")
+
+		return ok && outcome == fdsim.OutcomeCompleted
+	}, 5*time.Second, 20*time.Millisecond)
+	require.GreaterOrEqual(t, time.Since(start), 1900*time.Millisecond)
+	require.InDelta(t, 90, camera.Position().GetPan(), 0.001)
+}
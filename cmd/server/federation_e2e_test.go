@@ -0,0 +1,337 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"connectrpc.com/connect"
+	"github.com/stretchr/testify/require"
+
+	protov1 "github.com/anyfld/vistra-operation-control-room/gen/proto/v1"
+	"github.com/anyfld/vistra-operation-control-room/gen/proto/v1/protov1connect"
+	"github.com/anyfld/vistra-operation-control-room/pkg/transport/infrastructure"
+)
+
+func TestFederation_ParentListsAndControlsChildCameras(t *testing.T) {
+	t.Parallel()
+
+	childServer, child := newCRTestServer(t)
+	defer childServer.Close()
+
+	federation := infrastructure.NewFederationRepo("parent", "", http.DefaultClient)
+	federation.AddPeer("hall-b", childServer.URL)
+
+	parentServer, parent := newCRTestServerWithRepos(t, newRepositories(federation))
+	defer parentServer.Close()
+
+	ctx, cancel := context.WithTimeout(t.Context(), 5*time.Second)
+	defer cancel()
+
+	localResp, err := parent.camera.RegisterCamera(ctx, connect.NewRequest(&protov1.RegisterCameraRequest{
+		Name: "hall-a-cam",
+	}))
+	require.NoError(t, err)
+
+	remoteResp, err := child.camera.RegisterCamera(ctx, connect.NewRequest(&protov1.RegisterCameraRequest{
+		Name: "hall-b-cam",
+	}))
+	require.NoError(t, err)
+
+	remoteID := remoteResp.Msg.GetCamera().GetId()
+
+	listResp, err := parent.cr.ListAllCameras(ctx, connect.NewRequest(&protov1.ListAllCamerasRequest{}))
+	require.NoError(t, err)
+	require.Len(t, listResp.Msg.GetCameras(), 2)
+
+	peerIDs := map[string]string{}
+	for _, camera := range listResp.Msg.GetCameras() {
+		peerIDs[camera.GetId()] = camera.GetMetadata()[infrastructure.FederationPeerMetadataKey]
+	}
+
+	require.Empty(t, peerIDs[localResp.Msg.GetCamera().GetId()])
+	require.Equal(t, "hall-b", peerIDs[remoteID])
+
+	childListResp, err := child.cr.ListAllCameras(ctx, connect.NewRequest(&protov1.ListAllCamerasRequest{}))
+	require.NoError(t, err)
+	require.Len(t, childListResp.Msg.GetCameras(), 1)
+
+	ptzResp, err := parent.ptz.SendPTZCommand(ctx, connect.NewRequest(&protov1.SendPTZCommandRequest{
+		CameraId: remoteID,
+		Command: &protov1.PTZCommand{
+			OperationType: protov1.PTZOperationType_PTZ_OPERATION_TYPE_ABSOLUTE_MOVE,
+			Command: &protov1.PTZCommand_AbsoluteMove{
+				AbsoluteMove: &protov1.AbsoluteMoveCommand{
+					Position: &protov1.PTZPosition{X: 0.5, Y: 0.1, Z: 1},
+				},
+			},
+		},
+	}))
+	require.NoError(t, err)
+	require.True(t, ptzResp.Msg.GetAccepted())
+
+	childQueue, err := child.ptz.GetQueueStatus(ctx, connect.NewRequest(&protov1.GetQueueStatusRequest{
+		CameraId: remoteID,
+	}))
+	require.NoError(t, err)
+	require.Equal(t, uint32(1), childQueue.Msg.GetCameraQueues()[0].GetPtzQueueSize())
+
+	parentQueue, err := parent.ptz.GetQueueStatus(ctx, connect.NewRequest(&protov1.GetQueueStatusRequest{
+		CameraId: remoteID,
+	}))
+	require.NoError(t, err)
+	require.Equal(t, uint32(0), parentQueue.Msg.GetCameraQueues()[0].GetPtzQueueSize())
+
+	instrResp, err := parent.cr.SendCinematographyInstruction(ctx, connect.NewRequest(
+		&protov1.SendCinematographyInstructionRequest{
+			Instruction: &protov1.CinematographyInstruction{CameraId: remoteID},
+		},
+	))
+	require.NoError(t, err)
+	require.True(t, instrResp.Msg.GetAccepted())
+
+	statusResp, err := parent.cr.GetSystemStatus(ctx, connect.NewRequest(&protov1.GetSystemStatusRequest{}))
+	require.NoError(t, err)
+	require.Equal(t, uint32(2), statusResp.Msg.GetStatus().GetOnlineCameraCount())
+	require.Equal(
+		t,
+		protov1.SystemHealthStatus_SYSTEM_HEALTH_STATUS_HEALTHY,
+		statusResp.Msg.GetStatus().GetHealth(),
+	)
+}
+
+func TestFederation_UnreachablePeerDegradesHealth(t *testing.T) {
+	t.Parallel()
+
+	federation := infrastructure.NewFederationRepo("parent", "", http.DefaultClient)
+	federation.AddPeer("hall-offline", "http://127.0.0.1:1")
+
+	server, clients := newCRTestServerWithRepos(t, newRepositories(federation))
+	defer server.Close()
+
+	ctx, cancel := context.WithTimeout(t.Context(), 5*time.Second)
+	defer cancel()
+
+	statusResp, err := clients.cr.GetSystemStatus(ctx, connect.NewRequest(&protov1.GetSystemStatusRequest{}))
+	require.NoError(t, err)
+	require.Equal(
+		t,
+		protov1.SystemHealthStatus_SYSTEM_HEALTH_STATUS_DEGRADED,
+		statusResp.Msg.GetStatus().GetHealth(),
+	)
+}
+
+func TestFederation_ReportsFailedAndSlowPeers(t *testing.T) {
+	t.Parallel()
+
+	childServer, child := newCRTestServer(t)
+	defer childServer.Close()
+
+	release := make(chan struct{})
+
+	slowServer := httptest.NewServer(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {
+		<-release
+	}))
+	defer slowServer.Close()
+	defer close(release)
+
+	federation := infrastructure.NewFederationRepo("parent", "", infrastructure.NewFederationHTTPClient(500))
+	federation.AddPeer("hall-b", childServer.URL)
+	federation.AddPeer("hall-offline", "http://127.0.0.1:1")
+	federation.AddPeer("hall-slow", slowServer.URL)
+
+	parentServer, parent := newCRTestServerWithRepos(t, newRepositories(federation))
+	defer parentServer.Close()
+
+	ctx, cancel := context.WithTimeout(t.Context(), 5*time.Second)
+	defer cancel()
+
+	_, err := child.camera.RegisterCamera(ctx, connect.NewRequest(&protov1.RegisterCameraRequest{Name: "hall-b-cam"}))
+	require.NoError(t, err)
+
+	// 応答しない子CRがあっても、子CRごとの待ち時間で打ち切って集約する
+	startedAt := time.Now()
+
+	statusResp, err := parent.cr.GetSystemStatus(ctx, connect.NewRequest(&protov1.GetSystemStatusRequest{}))
+	require.NoError(t, err)
+	require.Less(t, time.Since(startedAt), time.Second)
+	require.Equal(t, protov1.SystemHealthStatus_SYSTEM_HEALTH_STATUS_DEGRADED, statusResp.Msg.GetStatus().GetHealth())
+	require.Equal(t, uint32(1), statusResp.Msg.GetStatus().GetOnlineCameraCount())
+
+	listResp, err := parent.cr.ListAllCameras(ctx, connect.NewRequest(&protov1.ListAllCamerasRequest{}))
+	require.NoError(t, err)
+	require.Len(t, listResp.Msg.GetCameras(), 1)
+	require.ElementsMatch(t,
+		[]string{"hall-offline", "hall-slow"},
+		listResp.Header().Values(infrastructure.FederationFailedPeersHeader),
+	)
+}
+
+func TestFederation_RejectsForwardedRequestsWithoutSharedSecret(t *testing.T) {
+	t.Parallel()
+
+	federation := infrastructure.NewFederationRepo("hall-a", "s3cret", http.DefaultClient)
+	federation.AddPeer("hall-offline", "http://127.0.0.1:1")
+
+	server, clients := newCRTestServerWithRepos(t, newRepositories(federation))
+	defer server.Close()
+
+	ctx, cancel := context.WithTimeout(t.Context(), 5*time.Second)
+	defer cancel()
+
+	getHealth := func(origin, token string) (protov1.SystemHealthStatus, error) {
+		req := connect.NewRequest(&protov1.GetSystemStatusRequest{})
+		if origin != "" {
+			req.Header().Set(infrastructure.FederationHeader, origin)
+		}
+
+		if token != "" {
+			req.Header().Set(infrastructure.FederationTokenHeader, token)
+		}
+
+		resp, err := clients.cr.GetSystemStatus(ctx, req)
+		if err != nil {
+			return 0, err
+		}
+
+		return resp.Msg.GetStatus().GetHealth(), nil
+	}
+
+	// 転送を示すヘッダーを持ち、共有シークレットが一致しないリクエストは拒否する
+	_, err := getHealth("parent", "")
+	require.Equal(t, connect.CodePermissionDenied, connect.CodeOf(err))
+
+	_, err = getHealth("parent", "wrong")
+	require.Equal(t, connect.CodePermissionDenied, connect.CodeOf(err))
+
+	// 親CRから転送されたリクエストにはローカルの状態だけで応答する
+	health, err := getHealth("parent", "s3cret")
+	require.NoError(t, err)
+	require.Equal(t, protov1.SystemHealthStatus_SYSTEM_HEALTH_STATUS_HEALTHY, health)
+
+	// 外部のリクエストは子CRへ問い合わせる
+	health, err = getHealth("", "")
+	require.NoError(t, err)
+	require.Equal(t, protov1.SystemHealthStatus_SYSTEM_HEALTH_STATUS_DEGRADED, health)
+}
+
+func TestFederation_DoesNotForwardBetweenMutualPeersWithoutSharedSecret(t *testing.T) {
+	t.Parallel()
+
+	hallA := infrastructure.NewFederationRepo("hall-a", "", http.DefaultClient)
+	hallB := infrastructure.NewFederationRepo("hall-b", "", http.DefaultClient)
+
+	serverA, clientsA := newCRTestServerWithRepos(t, newRepositories(hallA))
+	defer serverA.Close()
+
+	serverB, clientsB := newCRTestServerWithRepos(t, newRepositories(hallB))
+	defer serverB.Close()
+
+	// 互いを子CRとして登録しても、転送されたリクエストは転送元へ戻さない
+	hallA.AddPeer("hall-b", serverB.URL)
+	hallB.AddPeer("hall-a", serverA.URL)
+
+	ctx, cancel := context.WithTimeout(t.Context(), 5*time.Second)
+	defer cancel()
+
+	_, err := clientsA.camera.RegisterCamera(ctx, connect.NewRequest(&protov1.RegisterCameraRequest{Name: "hall-a-cam"}))
+	require.NoError(t, err)
+	_, err = clientsB.camera.RegisterCamera(ctx, connect.NewRequest(&protov1.RegisterCameraRequest{Name: "hall-b-cam"}))
+	require.NoError(t, err)
+
+	for _, clients := range []crTestClients{clientsA, clientsB} {
+		listResp, err := clients.cr.ListAllCameras(ctx, connect.NewRequest(&protov1.ListAllCamerasRequest{}))
+		require.NoError(t, err)
+		require.Len(t, listResp.Msg.GetCameras(), 2)
+		require.Empty(t, listResp.Header().Values(infrastructure.FederationFailedPeersHeader))
+	}
+}
+
+func TestFederation_CachesMissingCameras(t *testing.T) {
+	t.Parallel()
+
+	childRepos := newRepositories(infrastructure.NewFederationRepo("hall-b", "", http.DefaultClient))
+	childMux := http.NewServeMux()
+	registerCRService(t.Context(), childMux, childRepos)
+
+	var listCalls atomic.Int64
+
+	childServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == protov1connect.CRServiceListAllCamerasProcedure {
+			listCalls.Add(1)
+		}
+
+		childMux.ServeHTTP(w, r)
+	}))
+	defer childServer.Close()
+
+	federation := infrastructure.NewFederationRepo("parent", "", http.DefaultClient)
+	federation.AddPeer("hall-b", childServer.URL)
+
+	parentServer, parent := newCRTestServerWithRepos(t, newRepositories(federation))
+	defer parentServer.Close()
+
+	ctx, cancel := context.WithTimeout(t.Context(), 5*time.Second)
+	defer cancel()
+
+	for range 3 {
+		resp, err := parent.cr.GetCameraStatus(ctx, connect.NewRequest(&protov1.GetCameraStatusRequest{
+			CameraId: "missing-camera",
+		}))
+		require.NoError(t, err)
+		require.Nil(t, resp.Msg.GetCamera())
+	}
+
+	// 存在しないことを確認したカメラは、しばらくの間は子CRへ問い合わせない
+	require.Equal(t, int64(1), listCalls.Load())
+}
+
+func TestFederation_DropsOwnersOfCamerasRemovedFromChild(t *testing.T) {
+	t.Parallel()
+
+	childRepos := newRepositories(infrastructure.NewFederationRepo("hall-b", "", http.DefaultClient))
+	childMux := http.NewServeMux()
+	registerCRService(t.Context(), childMux, childRepos)
+
+	var statusCalls atomic.Int64
+
+	childServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == protov1connect.CRServiceGetCameraStatusProcedure {
+			statusCalls.Add(1)
+		}
+
+		childMux.ServeHTTP(w, r)
+	}))
+	defer childServer.Close()
+
+	federation := infrastructure.NewFederationRepo("parent", "", http.DefaultClient)
+	federation.AddPeer("hall-b", childServer.URL)
+
+	parentServer, parent := newCRTestServerWithRepos(t, newRepositories(federation))
+	defer parentServer.Close()
+
+	ctx, cancel := context.WithTimeout(t.Context(), 5*time.Second)
+	defer cancel()
+
+	cameraID := childRepos.camera.RegisterCamera(&protov1.RegisterCameraRequest{Name: "hall-b-cam"}).GetId()
+
+	resp, err := parent.cr.GetCameraStatus(ctx, connect.NewRequest(&protov1.GetCameraStatusRequest{CameraId: cameraID}))
+	require.NoError(t, err)
+	require.Equal(t, "hall-b", resp.Msg.GetCamera().GetMetadata()[infrastructure.FederationPeerMetadataKey])
+	require.Equal(t, int64(1), statusCalls.Load())
+
+	// 子CRの一覧から消えたカメラは、一覧を再取得した時点で所有関係を破棄する
+	require.True(t, childRepos.camera.UnregisterCamera(cameraID))
+
+	listResp, err := parent.cr.ListAllCameras(ctx, connect.NewRequest(&protov1.ListAllCamerasRequest{}))
+	require.NoError(t, err)
+	require.Empty(t, listResp.Msg.GetCameras())
+
+	resp, err = parent.cr.GetCameraStatus(ctx, connect.NewRequest(&protov1.GetCameraStatusRequest{CameraId: cameraID}))
+	require.NoError(t, err)
+	require.Nil(t, resp.Msg.GetCamera())
+	require.Equal(t, int64(1), statusCalls.Load())
+}
@@ -0,0 +1,270 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"connectrpc.com/connect"
+	"github.com/stretchr/testify/require"
+
+	protov1 "github.com/anyfld/vistra-operation-control-room/gen/proto/v1"
+	"github.com/anyfld/vistra-operation-control-room/pkg/transport/handlers"
+	"github.com/anyfld/vistra-operation-control-room/pkg/transport/infrastructure"
+	"github.com/anyfld/vistra-operation-control-room/pkg/transport/usecase"
+)
+
+type focusFixture struct {
+	*keyframeFixture
+}
+
+// newFocusFixture はオートフォーカスに対応するカメラを登録します。
+func newFocusFixture(t *testing.T) *focusFixture {
+	t.Helper()
+
+	return &focusFixture{keyframeFixture: newKeyframeFixture(t, testCamera("focus-camera", &protov1.CameraCapabilities{
+		SupportsPtz:       true,
+		SupportsAutofocus: true,
+		PanMin:            -100,
+		PanMax:            100,
+		TiltMin:           -50,
+		TiltMax:           50,
+		ZoomMin:           1,
+		ZoomMax:           11,
+	}))}
+}
+
+// reportFocus はFDとしてフォーカス値を報告します。
+func (f *focusFixture) reportFocus(ctx context.Context, t *testing.T, focus float32) {
+	t.Helper()
+
+	_, err := f.fd.StreamControlCommands(ctx, connect.NewRequest(&protov1.StreamControlCommandsRequest{
+		Message: &protov1.StreamControlCommandsRequest_State{State: &protov1.CameraState{
+			CameraId:     f.cameraID,
+			CurrentFocus: focus,
+			Status:       protov1.CameraStatus_CAMERA_STATUS_ONLINE,
+		}},
+	}))
+	require.NoError(t, err)
+}
+
+func (f *focusFixture) sendFocus(ctx context.Context, t *testing.T, value float32) *protov1.ControlCommandResult {
+	t.Helper()
+
+	resp, err := f.fd.StreamControlCommands(ctx, connect.NewRequest(&protov1.StreamControlCommandsRequest{
+		Message: &protov1.StreamControlCommandsRequest_Command{Command: &protov1.ControlCommand{
+			CameraId:   f.cameraID,
+			Type:       protov1.ControlCommandType_CONTROL_COMMAND_TYPE_FOCUS,
+			FocusValue: value,
+		}},
+	}))
+	require.NoError(t, err)
+
+	return resp.Msg.GetResult()
+}
+
+func isFocusCommand(command *protov1.ControlCommand) bool {
+	return command.GetType() == protov1.ControlCommandType_CONTROL_COMMAND_TYPE_FOCUS
+}
+
+func TestFocusMode_SwitchesBetweenAutoAndManual(t *testing.T) {
+	t.Parallel()
+
+	fixture := newFocusFixture(t)
+
+	ctx, cancel := context.WithTimeout(t.Context(), 5*time.Second)
+	defer cancel()
+
+	state, err := fixture.call(ctx, t, handlers.FDServiceGetFocusStateProcedure, map[string]any{})
+	require.NoError(t, err)
+	require.Equal(t, "auto", state.GetFields()["mode"].GetStringValue())
+	require.False(t, state.GetFields()["has_current_focus"].GetBoolValue())
+
+	// 手動へ切り替えるには保つフォーカス値が必要
+	_, err = fixture.call(ctx, t, handlers.FDServiceSetFocusModeProcedure, map[string]any{"mode": "manual"})
+	require.Equal(t, connect.CodeFailedPrecondition, connect.CodeOf(err))
+
+	_, err = fixture.call(ctx, t, handlers.FDServiceSetFocusModeProcedure, map[string]any{"mode": "macro"})
+	require.Equal(t, connect.CodeInvalidArgument, connect.CodeOf(err))
+
+	fixture.reportFocus(ctx, t, 0.3)
+
+	status, err := fixture.call(ctx, t, handlers.FDServiceSetFocusModeProcedure, map[string]any{"mode": "manual"})
+	require.NoError(t, err)
+	require.Equal(t, "CONTROL_COMMAND_TYPE_FOCUS", status.GetFields()["type"].GetStringValue())
+	require.InDelta(t, 0.3, fixture.nextCommand(t, isFocusCommand).GetFocusValue(), 1e-6)
+
+	state, err = fixture.call(ctx, t, handlers.FDServiceGetFocusStateProcedure, map[string]any{})
+	require.NoError(t, err)
+	require.Equal(t, "manual", state.GetFields()["mode"].GetStringValue())
+	require.InDelta(t, 0.3, state.GetFields()["current_focus"].GetNumberValue(), 1e-6)
+
+	// FOCUSの制御コマンドでもモードが切り替わる
+	require.Nil(t, fixture.sendFocus(ctx, t, infrastructure.AutofocusValue))
+	require.Equal(t, infrastructure.AutofocusValue, fixture.nextCommand(t, isFocusCommand).GetFocusValue())
+
+	state, err = fixture.call(ctx, t, handlers.FDServiceGetFocusStateProcedure, map[string]any{})
+	require.NoError(t, err)
+	require.Equal(t, "auto", state.GetFields()["mode"].GetStringValue())
+
+	result := fixture.sendFocus(ctx, t, 1.5)
+	require.False(t, result.GetSuccess())
+	require.Contains(t, result.GetErrorMessage(), "0.0-1.0")
+
+	fixed := fixture.repos.camera.RegisterCamera(&protov1.RegisterCameraRequest{
+		Name:         "fixed-focus-camera",
+		Mode:         protov1.CameraMode_CAMERA_MODE_AUTONOMOUS,
+		MasterMfId:   "mf-focus-1",
+		Capabilities: &protov1.CameraCapabilities{SupportsPtz: true},
+	})
+
+	_, err = fixture.call(ctx, t, handlers.FDServiceSetFocusModeProcedure, map[string]any{
+		"camera_id": fixed.GetId(), "mode": "auto",
+	})
+	require.Equal(t, connect.CodeFailedPrecondition, connect.CodeOf(err))
+
+	state, err = fixture.call(ctx, t, handlers.FDServiceGetFocusStateProcedure, map[string]any{
+		"camera_id": fixed.GetId(),
+	})
+	require.NoError(t, err)
+	require.Equal(t, "manual", state.GetFields()["mode"].GetStringValue())
+}
+
+func TestRackFocus_InterpolatesAndCompletesTask(t *testing.T) {
+	t.Parallel()
+
+	fixture := newFocusFixture(t)
+
+	ctx, cancel := context.WithTimeout(t.Context(), 5*time.Second)
+	defer cancel()
+
+	fixture.reportFocus(ctx, t, 0.2)
+
+	_, err := fixture.call(ctx, t, handlers.FDServiceEnqueueFocusMoveProcedure, map[string]any{
+		"focus": 1.2, "duration_ms": 500,
+	})
+	require.Equal(t, connect.CodeInvalidArgument, connect.CodeOf(err))
+
+	// 先に実行対象になるシネマティック命令の後ろに並べる
+	blockingID, accepted := fixture.repos.ptz.EnqueueCinematicCommand(
+		fixture.cameraID,
+		&protov1.CinematographyInstruction{CameraId: fixture.cameraID, ShotType: protov1.ShotType_SHOT_TYPE_WIDE},
+	)
+	require.True(t, accepted)
+
+	resp, err := fixture.call(ctx, t, handlers.FDServiceEnqueueFocusMoveProcedure, map[string]any{
+		"focus": 0.8, "duration_ms": 500,
+	})
+	require.NoError(t, err)
+
+	taskID := resp.GetFields()["task_id"].GetStringValue()
+
+	// 実行対象になるまでは配信しない
+	fixture.requireNoCommand(t, isFocusCommand, 150*time.Millisecond)
+
+	task := fixture.startTask(t, blockingID)
+	require.Equal(t, taskID, task.GetTaskId())
+	require.Equal(t, protov1.CommandLayer_COMMAND_LAYER_CINEMATIC, task.GetLayer())
+	require.Nil(t, task.GetPtzCommand())
+
+	// 0.2 から 0.8 へ 100ms ごとの5ステップで加減速する
+	previous := float32(0.2)
+
+	for i := range 5 {
+		command := fixture.nextCommand(t, isFocusCommand)
+		require.Equal(t, taskID+"-"+string(rune('1'+i)), command.GetCommandId())
+		require.Greater(t, command.GetFocusValue(), previous)
+
+		previous = command.GetFocusValue()
+	}
+
+	require.InDelta(t, 0.8, previous, 1e-6)
+
+	// フォーカスだけのタスクはCRが完了させる
+	fixture.waitTaskEvent(t, infrastructure.EventTaskCompleted, taskID)
+	require.Nil(t, fixture.repos.ptz.GetQueueStatus(fixture.cameraID).GetExecutingTask())
+
+	rundown, err := usecase.ParseRundown([]byte(`
+cues:
+  - actions:
+      - focus_pull: {camera_id: cam-1, focus: 0.4, duration_ms: 800}
+`))
+	require.NoError(t, err)
+	require.Equal(t, &infrastructure.RundownFocusPull{CameraID: "cam-1", Focus: 0.4, DurationMs: 800},
+		rundown.Cues[0].Actions[0].FocusPull)
+
+	_, err = usecase.ParseRundown([]byte(`
+cues:
+  - actions:
+      - focus_pull: {camera_id: cam-1, focus: 2}
+`))
+	require.ErrorIs(t, err, infrastructure.ErrInvalidFocusCommand)
+}
+
+func TestAbsoluteMoveWithFocus_RunsAlongsideMove(t *testing.T) {
+	t.Parallel()
+
+	fixture := newFocusFixture(t)
+
+	ctx, cancel := context.WithTimeout(t.Context(), 5*time.Second)
+	defer cancel()
+
+	_, err := fixture.call(ctx, t, handlers.FDServiceEnqueueAbsoluteMoveProcedure, map[string]any{"focus": 0.5})
+	require.Equal(t, connect.CodeInvalidArgument, connect.CodeOf(err))
+
+	resp, err := fixture.call(ctx, t, handlers.FDServiceEnqueueAbsoluteMoveProcedure, map[string]any{
+		"absolute_move": map[string]any{"position": map[string]any{"x": 0.5, "y": 0, "z": 0}},
+		"focus":         0.1,
+	})
+	require.NoError(t, err)
+	require.Empty(t, resp.GetFields()["adjustment"].GetStringValue())
+
+	taskID := resp.GetFields()["task_id"].GetStringValue()
+
+	task := fixture.startTask(t, "")
+	require.Equal(t, taskID, task.GetTaskId())
+	require.Equal(t, protov1.CommandLayer_COMMAND_LAYER_PTZ, task.GetLayer())
+	require.InDelta(t, 0.5, task.GetPtzCommand().GetAbsoluteMove().GetPosition().GetX(), 1e-6)
+
+	// 開始時のフォーカス値が不明なため、目標だけを送る
+	command := fixture.nextCommand(t, isFocusCommand)
+	require.Equal(t, taskID+"-1", command.GetCommandId())
+	require.InDelta(t, 0.1, command.GetFocusValue(), 1e-6)
+
+	// 移動の完了はFDの報告を待つ
+	fixture.requireNoCommand(t, isFocusCommand, 150*time.Millisecond)
+	require.Equal(t, taskID, fixture.repos.ptz.GetQueueStatus(fixture.cameraID).GetExecutingTask().GetTaskId())
+}
+
+func TestRackFocus_ManualPTZInterruptsCinematicPull(t *testing.T) {
+	t.Parallel()
+
+	fixture := newFocusFixture(t)
+
+	ctx, cancel := context.WithTimeout(t.Context(), 5*time.Second)
+	defer cancel()
+
+	fixture.reportFocus(ctx, t, 0)
+
+	resp, err := fixture.call(ctx, t, handlers.FDServiceEnqueueFocusMoveProcedure, map[string]any{
+		"focus": 1, "duration_ms": 3000,
+	})
+	require.NoError(t, err)
+
+	taskID := resp.GetFields()["task_id"].GetStringValue()
+
+	fixture.startTask(t, "")
+	require.Equal(t, taskID+"-1", fixture.nextCommand(t, isFocusCommand).GetCommandId())
+
+	_, accepted := fixture.repos.ptz.EnqueuePTZCommand(fixture.cameraID, &protov1.PTZCommand{
+		OperationType: protov1.PTZOperationType_PTZ_OPERATION_TYPE_RELATIVE_MOVE,
+		Command: &protov1.PTZCommand_RelativeMove{
+			RelativeMove: &protov1.RelativeMoveCommand{Translation: &protov1.PTZTranslation{PanDelta: 5}},
+		},
+	})
+	require.True(t, accepted)
+
+	// 中断されたフォーカス送りは残りのステップを配信しない
+	fixture.waitTaskEvent(t, infrastructure.EventTaskInterrupted, taskID)
+	fixture.requireNoCommand(t, isFocusCommand, 300*time.Millisecond)
+}
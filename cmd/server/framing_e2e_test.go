@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"connectrpc.com/connect"
+	"github.com/stretchr/testify/require"
+
+	protov1 "github.com/anyfld/vistra-operation-control-room/gen/proto/v1"
+	"github.com/anyfld/vistra-operation-control-room/gen/proto/v1/protov1connect"
+	"github.com/anyfld/vistra-operation-control-room/pkg/transport/infrastructure"
+)
+
+func newFramingTestServer(t *testing.T, panMin, panMax float32) (protov1connect.FDServiceClient, string) {
+	t.Helper()
+
+	camera := testCamera("framing-camera", &protov1.CameraCapabilities{
+		SupportsPtz: true,
+		PanMin:      panMin,
+		PanMax:      panMax,
+		TiltMin:     -30,
+		TiltMax:     90,
+		ZoomMin:     1,
+		ZoomMax:     20,
+	})
+	camera.Metadata = map[string]string{
+		infrastructure.CameraMetadataHorizontalFOV: "60",
+		infrastructure.CameraMetadataPanSpeed:      "100",
+		infrastructure.CameraMetadataZoomSpeed:     "4",
+	}
+
+	server := newE2EServer(t, camera)
+
+	return server.fd, server.cameraID
+}
+
+func calculateFraming(
+	ctx context.Context,
+	t *testing.T,
+	client protov1connect.FDServiceClient,
+	cameraID string,
+	shotType protov1.ShotType,
+	box *protov1.BoundingBox,
+) *protov1.CalculateFramingResponse {
+	t.Helper()
+
+	subjects := make([]*protov1.DetectedSubject, 0, 1)
+	if box != nil {
+		subjects = append(subjects, &protov1.DetectedSubject{
+			Subject:     &protov1.Subject{Id: "presenter", Type: "person"},
+			Confidence:  0.9,
+			DetectedBox: box,
+		})
+	}
+
+	resp, err := client.CalculateFraming(ctx, connect.NewRequest(&protov1.CalculateFramingRequest{
+		CameraId:       cameraID,
+		CurrentPtz:     &protov1.PTZParameters{Pan: 0, Tilt: 0, Zoom: 1},
+		TargetShotType: shotType,
+		TargetSubjects: subjects,
+		TargetAngle:    protov1.CameraAngle_CAMERA_ANGLE_EYE_LEVEL,
+	}))
+	require.NoError(t, err)
+
+	return resp.Msg
+}
+
+func TestCalculateFraming_ZoomFollowsShotType(t *testing.T) {
+	t.Parallel()
+
+	client, cameraID := newFramingTestServer(t, -170, 170)
+
+	ctx, cancel := context.WithTimeout(t.Context(), 5*time.Second)
+	defer cancel()
+
+	centered := &protov1.BoundingBox{X: 0.45, Y: 0.3, Width: 0.1, Height: 0.6}
+
+	wide := calculateFraming(ctx, t, client, cameraID, protov1.ShotType_SHOT_TYPE_WIDE, centered)
+	require.True(t, wide.GetSuccess(), wide.GetErrorMessage())
+
+	medium := calculateFraming(ctx, t, client, cameraID, protov1.ShotType_SHOT_TYPE_MEDIUM, centered)
+	require.True(t, medium.GetSuccess(), medium.GetErrorMessage())
+
+	closeUp := calculateFraming(ctx, t, client, cameraID, protov1.ShotType_SHOT_TYPE_CLOSE_UP, centered)
+	require.True(t, closeUp.GetSuccess(), closeUp.GetErrorMessage())
+
+	// 被写体が画面の6割を占めているため、ワイドでは最も広角に引く
+	require.InDelta(t, 1.0, wide.GetCalculatedPtz().GetZoom(), 0.001)
+	require.Greater(t, medium.GetCalculatedPtz().GetZoom(), wide.GetCalculatedPtz().GetZoom())
+	require.Greater(t, closeUp.GetCalculatedPtz().GetZoom(), medium.GetCalculatedPtz().GetZoom())
+	require.LessOrEqual(t, closeUp.GetCalculatedPtz().GetZoom(), float32(20))
+
+	// 中央の被写体はパンせず、顔に寄るためにチルトを上げる
+	require.InDelta(t, 0, closeUp.GetCalculatedPtz().GetPan(), 0.001)
+	require.Greater(t, closeUp.GetCalculatedPtz().GetTilt(), medium.GetCalculatedPtz().GetTilt())
+	require.Positive(t, closeUp.GetCalculatedPtz().GetTilt())
+
+	// ズームが最も時間のかかる軸なので全速で動き、移動時間はズーム量から決まる
+	zoomSeconds := float64(closeUp.GetCalculatedPtz().GetZoom()-1) / 4
+	require.InDelta(t, zoomSeconds*1000, float64(closeUp.GetEstimatedMoveTimeMs()), 1)
+	require.InDelta(t, 1, closeUp.GetCalculatedPtz().GetZoomSpeed(), 0.001)
+	require.Less(t, closeUp.GetCalculatedPtz().GetTiltSpeed(), float32(1))
+	require.Greater(t, closeUp.GetEstimatedMoveTimeMs(), medium.GetEstimatedMoveTimeMs())
+}
+
+func TestCalculateFraming_RuleOfThirdsAndPanLimits(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithTimeout(t.Context(), 5*time.Second)
+	defer cancel()
+
+	rightSide := &protov1.BoundingBox{X: 0.7, Y: 0.3, Width: 0.1, Height: 0.6}
+
+	client, cameraID := newFramingTestServer(t, -170, 170)
+	resp := calculateFraming(ctx, t, client, cameraID, protov1.ShotType_SHOT_TYPE_MEDIUM_CLOSE_UP, rightSide)
+	require.True(t, resp.GetSuccess(), resp.GetErrorMessage())
+
+	// 被写体の中心は約16度右。右側の三分割線に置くため、パンは被写体の中心より手前で止まる
+	pan := resp.GetCalculatedPtz().GetPan()
+	require.Greater(t, pan, float32(10))
+	require.Less(t, pan, float32(16))
+
+	limitedClient, limitedCameraID := newFramingTestServer(t, -10, 10)
+	limited := calculateFraming(
+		ctx, t, limitedClient, limitedCameraID, protov1.ShotType_SHOT_TYPE_MEDIUM_CLOSE_UP, rightSide,
+	)
+	require.True(t, limited.GetSuccess(), limited.GetErrorMessage())
+	require.InDelta(t, 10, limited.GetCalculatedPtz().GetPan(), 0.001)
+}
+
+func TestCalculateFraming_RequiresSubjects(t *testing.T) {
+	t.Parallel()
+
+	client, cameraID := newFramingTestServer(t, -170, 170)
+
+	ctx, cancel := context.WithTimeout(t.Context(), 5*time.Second)
+	defer cancel()
+
+	resp := calculateFraming(ctx, t, client, cameraID, protov1.ShotType_SHOT_TYPE_CLOSE_UP, nil)
+	require.False(t, resp.GetSuccess())
+	require.Equal(t, infrastructure.ErrNoFramingSubjects.Error(), resp.GetErrorMessage())
+}
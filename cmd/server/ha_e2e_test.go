@@ -0,0 +1,429 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"connectrpc.com/connect"
+	"github.com/stretchr/testify/require"
+
+	protov1 "github.com/anyfld/vistra-operation-control-room/gen/proto/v1"
+	handlers "github.com/anyfld/vistra-operation-control-room/pkg/transport/handlers"
+	"github.com/anyfld/vistra-operation-control-room/pkg/transport/infrastructure"
+	"github.com/anyfld/vistra-operation-control-room/pkg/transport/usecase"
+)
+
+const (
+	haTestLeaseTTLMs        = 500
+	haTestRenewIntervalMs   = 50
+	haTestPersistIntervalMs = 50
+)
+
+type haTestNode struct {
+	server  *httptest.Server
+	clients crTestClients
+	ha      *usecase.HAUsecase
+}
+
+func newHATestNode(t *testing.T, store infrastructure.SharedStore, nodeID string, address string) *haTestNode {
+	t.Helper()
+
+	repos := newRepositories(infrastructure.NewFederationRepo(nodeID, "", http.DefaultClient))
+
+	ha := newHAUsecase(store, repos, nodeID, address, haIntervals{
+		leaseTTLMs:        haTestLeaseTTLMs,
+		renewIntervalMs:   haTestRenewIntervalMs,
+		persistIntervalMs: haTestPersistIntervalMs,
+	})
+	ha.Start(t.Context())
+
+	// 終了時の書き込みが一時ディレクトリの削除と重ならないよう、削除の前にリーダーを降りる
+	t.Cleanup(func() {
+		_ = ha.Resign()
+	})
+
+	server, clients := startCRTestServer(t, setupHandlers(t.Context(), repos, ha))
+
+	return &haTestNode{server: server, clients: clients, ha: ha}
+}
+
+func TestHA_StandbyRedirectsAndTakesOverQueuedTasks(t *testing.T) {
+	t.Parallel()
+
+	store, err := infrastructure.NewFileSharedStore(t.TempDir())
+	require.NoError(t, err)
+
+	active := newHATestNode(t, store, "cr-a", "http://cr-a.example:8080")
+	defer active.server.Close()
+
+	standby := newHATestNode(t, store, "cr-b", "http://cr-b.example:8080")
+	defer standby.server.Close()
+
+	require.True(t, active.ha.IsLeader())
+	require.False(t, standby.ha.IsLeader())
+
+	ctx, cancel := context.WithTimeout(t.Context(), 5*time.Second)
+	defer cancel()
+
+	registerResp, err := active.clients.camera.RegisterCamera(ctx, connect.NewRequest(&protov1.RegisterCameraRequest{
+		Name:       "ha-camera",
+		Mode:       protov1.CameraMode_CAMERA_MODE_AUTONOMOUS,
+		MasterMfId: "mf-ha-1",
+	}))
+	require.NoError(t, err)
+
+	cameraID := registerResp.Msg.GetCamera().GetId()
+
+	ptzReq := &protov1.SendPTZCommandRequest{
+		CameraId: cameraID,
+		Command: &protov1.PTZCommand{
+			OperationType: protov1.PTZOperationType_PTZ_OPERATION_TYPE_ABSOLUTE_MOVE,
+			Command: &protov1.PTZCommand_AbsoluteMove{
+				AbsoluteMove: &protov1.AbsoluteMoveCommand{
+					Position: &protov1.PTZPosition{X: 0.2, Y: 0.1, Z: 1},
+				},
+			},
+		},
+	}
+
+	ptzResp, err := active.clients.ptz.SendPTZCommand(ctx, connect.NewRequest(ptzReq))
+	require.NoError(t, err)
+
+	// スタンバイはリーダーのアドレスを返して拒否する
+	_, err = standby.clients.ptz.Polling(ctx, connect.NewRequest(&protov1.PollingRequest{CameraId: cameraID}))
+	require.Error(t, err)
+	require.Equal(t, connect.CodeUnavailable, connect.CodeOf(err))
+
+	var connectErr *connect.Error
+	require.True(t, errors.As(err, &connectErr))
+	require.Equal(t, "http://cr-a.example:8080", connectErr.Meta().Get(handlers.LeaderAddressHeader))
+	require.Equal(t, "cr-a", connectErr.Meta().Get(handlers.LeaderIDHeader))
+
+	require.NoError(t, active.ha.Resign())
+
+	require.Eventually(t, standby.ha.IsLeader, 3*time.Second, 20*time.Millisecond)
+
+	// フェイルオーバー後もキュー済みのPTZタスクが残っている
+	cameraResp, err := standby.clients.camera.GetCamera(ctx, connect.NewRequest(&protov1.GetCameraRequest{
+		CameraId: cameraID,
+	}))
+	require.NoError(t, err)
+	require.Equal(t, "ha-camera", cameraResp.Msg.GetCamera().GetName())
+
+	pollResp, err := standby.clients.ptz.Polling(ctx, connect.NewRequest(&protov1.PollingRequest{CameraId: cameraID}))
+	require.NoError(t, err)
+	require.Equal(t, ptzResp.Msg.GetTaskId(), pollResp.Msg.GetCurrentCommand().GetTaskId())
+
+	_, err = active.clients.ptz.GetQueueStatus(ctx, connect.NewRequest(&protov1.GetQueueStatusRequest{
+		CameraId: cameraID,
+	}))
+	require.Equal(t, connect.CodeUnavailable, connect.CodeOf(err))
+
+	require.Eventually(t, func() bool {
+		return active.ha.Leader().GetHolderID() == "cr-b"
+	}, 3*time.Second, 20*time.Millisecond)
+
+	leaderResp, err := http.Get(active.server.URL + "/ha/leader") //nolint:noctx
+	require.NoError(t, err)
+
+	defer func() {
+		_ = leaderResp.Body.Close()
+	}()
+
+	var status struct {
+		NodeID        string `json:"node_id"`
+		IsLeader      bool   `json:"is_leader"`
+		LeaderAddress string `json:"leader_address"`
+	}
+	require.NoError(t, json.NewDecoder(leaderResp.Body).Decode(&status))
+	require.Equal(t, "cr-a", status.NodeID)
+	require.False(t, status.IsLeader)
+	require.Equal(t, "http://cr-b.example:8080", status.LeaderAddress)
+}
+
+// countingStore は共有ストアへの状態の書き込み回数を数えます。
+type countingStore struct {
+	infrastructure.SharedStore
+
+	saves atomic.Int64
+}
+
+func (s *countingStore) SaveState(holderID string, key string, data []byte) error {
+	s.saves.Add(1)
+
+	return s.SharedStore.SaveState(holderID, key, data)
+}
+
+// crashableStore は crash の後、ノードが異常終了したものとして共有ストアへの書き込みとリースの更新を失敗させます。
+type crashableStore struct {
+	infrastructure.SharedStore
+
+	crashed atomic.Bool
+}
+
+var errNodeCrashed = errors.New("node crashed")
+
+func (s *crashableStore) AcquireLease(
+	holderID string,
+	address string,
+	ttl time.Duration,
+) (*infrastructure.Lease, error) {
+	if s.crashed.Load() {
+		return nil, errNodeCrashed
+	}
+
+	return s.SharedStore.AcquireLease(holderID, address, ttl)
+}
+
+func (s *crashableStore) ReleaseLease(holderID string) error {
+	if s.crashed.Load() {
+		return errNodeCrashed
+	}
+
+	return s.SharedStore.ReleaseLease(holderID)
+}
+
+func (s *crashableStore) SaveState(holderID string, key string, data []byte) error {
+	if s.crashed.Load() {
+		return errNodeCrashed
+	}
+
+	return s.SharedStore.SaveState(holderID, key, data)
+}
+
+func newHATestPTZRequest(cameraID string, pan float32) *protov1.SendPTZCommandRequest {
+	return &protov1.SendPTZCommandRequest{
+		CameraId: cameraID,
+		Command: &protov1.PTZCommand{
+			OperationType: protov1.PTZOperationType_PTZ_OPERATION_TYPE_ABSOLUTE_MOVE,
+			Command: &protov1.PTZCommand_AbsoluteMove{
+				AbsoluteMove: &protov1.AbsoluteMoveCommand{
+					Position: &protov1.PTZPosition{X: pan, Y: 0, Z: 1},
+				},
+			},
+		},
+	}
+}
+
+func TestHA_KeepsAcknowledgedTasksWhenLeaderCrashes(t *testing.T) {
+	t.Parallel()
+
+	fileStore, err := infrastructure.NewFileSharedStore(t.TempDir())
+	require.NoError(t, err)
+
+	activeStore := &crashableStore{SharedStore: fileStore, crashed: atomic.Bool{}}
+
+	active := newHATestNode(t, activeStore, "cr-a", "http://cr-a.example:8080")
+	defer active.server.Close()
+
+	standby := newHATestNode(t, fileStore, "cr-b", "http://cr-b.example:8080")
+	defer standby.server.Close()
+
+	ctx, cancel := context.WithTimeout(t.Context(), 5*time.Second)
+	defer cancel()
+
+	registerResp, err := active.clients.camera.RegisterCamera(ctx, connect.NewRequest(&protov1.RegisterCameraRequest{
+		Name:       "crash-camera",
+		Mode:       protov1.CameraMode_CAMERA_MODE_AUTONOMOUS,
+		MasterMfId: "mf-ha-crash",
+	}))
+	require.NoError(t, err)
+
+	cameraID := registerResp.Msg.GetCamera().GetId()
+
+	ptzResp, err := active.clients.ptz.SendPTZCommand(ctx, connect.NewRequest(newHATestPTZRequest(cameraID, 0.3)))
+	require.NoError(t, err)
+	require.True(t, ptzResp.Msg.GetAccepted())
+
+	// 応答を受け取った直後にリーダーが異常終了し、以降は共有ストアへ何も書き込めない
+	activeStore.crashed.Store(true)
+	active.server.Close()
+
+	require.Eventually(t, standby.ha.IsLeader, 3*time.Second, 20*time.Millisecond)
+
+	pollResp, err := standby.clients.ptz.Polling(ctx, connect.NewRequest(&protov1.PollingRequest{CameraId: cameraID}))
+	require.NoError(t, err)
+	require.Equal(t, ptzResp.Msg.GetTaskId(), pollResp.Msg.GetCurrentCommand().GetTaskId())
+}
+
+func TestHA_BatchesTaskProgressWrites(t *testing.T) {
+	t.Parallel()
+
+	fileStore, err := infrastructure.NewFileSharedStore(t.TempDir())
+	require.NoError(t, err)
+
+	store := &countingStore{SharedStore: fileStore, saves: atomic.Int64{}}
+
+	active := newHATestNode(t, store, "cr-a", "http://cr-a.example:8080")
+	defer active.server.Close()
+
+	ctx, cancel := context.WithTimeout(t.Context(), 5*time.Second)
+	defer cancel()
+
+	registerResp, err := active.clients.camera.RegisterCamera(ctx, connect.NewRequest(&protov1.RegisterCameraRequest{
+		Name:       "batched-camera",
+		Mode:       protov1.CameraMode_CAMERA_MODE_AUTONOMOUS,
+		MasterMfId: "mf-ha-batch",
+	}))
+	require.NoError(t, err)
+
+	cameraID := registerResp.Msg.GetCamera().GetId()
+
+	const tasks = 10
+
+	taskIDs := make([]string, 0, tasks)
+
+	// タスクの追加は応答する前に共有ストアへ書き込む
+	for i := range tasks {
+		ptzResp, err := active.clients.ptz.SendPTZCommand(
+			ctx, connect.NewRequest(newHATestPTZRequest(cameraID, float32(i)/tasks)),
+		)
+		require.NoError(t, err)
+
+		data, err := store.LoadState("ptz_queues")
+		require.NoError(t, err)
+		require.Contains(t, string(data), ptzResp.Msg.GetTaskId())
+
+		taskIDs = append(taskIDs, ptzResp.Msg.GetTaskId())
+	}
+
+	// ポーリングによる実行の進行は、間隔の間の変更をまとめて書き込む
+	savesBeforePolling := store.saves.Load()
+	completed := ""
+
+	for range tasks + 1 {
+		pollResp, err := active.clients.ptz.Polling(ctx, connect.NewRequest(&protov1.PollingRequest{
+			CameraId:        cameraID,
+			CompletedTaskId: completed,
+		}))
+		require.NoError(t, err)
+
+		completed = pollResp.Msg.GetCurrentCommand().GetTaskId()
+	}
+
+	require.Empty(t, completed)
+	require.Eventually(t, func() bool {
+		data, err := store.LoadState("ptz_queues")
+		if err != nil {
+			return false
+		}
+
+		for _, taskID := range taskIDs {
+			if strings.Contains(string(data), taskID) {
+				return false
+			}
+		}
+
+		return true
+	}, 3*time.Second, 20*time.Millisecond)
+	require.Less(t, store.saves.Load()-savesBeforePolling, int64(tasks))
+}
+
+func TestHA_EndsOpenStreamsOnDemotion(t *testing.T) {
+	t.Parallel()
+
+	store, err := infrastructure.NewFileSharedStore(t.TempDir())
+	require.NoError(t, err)
+
+	active := newHATestNode(t, store, "cr-a", "http://cr-a.example:8080")
+	defer active.server.Close()
+
+	ctx, cancel := context.WithTimeout(t.Context(), 5*time.Second)
+	defer cancel()
+
+	stream := subscribeEvents(ctx, t, active.server.URL, map[string]any{"topics": []any{"camera"}})
+	defer stream.Close()
+
+	_, err = active.clients.camera.RegisterCamera(ctx, connect.NewRequest(&protov1.RegisterCameraRequest{
+		Name:       "stream-camera",
+		Mode:       protov1.CameraMode_CAMERA_MODE_AUTONOMOUS,
+		MasterMfId: "mf-ha-stream",
+	}))
+	require.NoError(t, err)
+	receiveEvent(t, stream)
+
+	// 降格したノードは確立済みのストリームも終了させ、接続し直すよう通知する
+	require.NoError(t, active.ha.Resign())
+
+	for stream.Receive() {
+	}
+
+	require.Equal(t, connect.CodeUnavailable, connect.CodeOf(stream.Err()))
+}
+
+func TestHA_RunsLeaderOnlyWorkWhileLeader(t *testing.T) {
+	t.Parallel()
+
+	store, err := infrastructure.NewFileSharedStore(t.TempDir())
+	require.NoError(t, err)
+
+	active := newHATestNode(t, store, "cr-a", "http://cr-a.example:8080")
+	defer active.server.Close()
+
+	standby := newHATestNode(t, store, "cr-b", "http://cr-b.example:8080")
+	defer standby.server.Close()
+
+	runAs := func(node *haTestNode) chan context.Context {
+		started := make(chan context.Context, 2)
+		node.ha.RunAsLeader(t.Context(), func(ctx context.Context) { started <- ctx })
+
+		return started
+	}
+
+	activeRuns := runAs(active)
+	standbyRuns := runAs(standby)
+
+	// スタンバイではFDへの配信などを開始しない
+	activeCtx := <-activeRuns
+	require.Empty(t, standbyRuns)
+
+	require.NoError(t, active.ha.Resign())
+	require.Error(t, activeCtx.Err())
+
+	// 昇格したノードで開始する
+	select {
+	case ctx := <-standbyRuns:
+		require.NoError(t, ctx.Err())
+	case <-time.After(3 * time.Second):
+		require.FailNow(t, "leader-only work did not start on the promoted node")
+	}
+
+	require.Empty(t, activeRuns)
+}
+
+func TestFileSharedStore_BreaksStaleLock(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+
+	store, err := infrastructure.NewFileSharedStore(dir)
+	require.NoError(t, err)
+
+	// 異常終了したノードが残したロック
+	lockPath := filepath.Join(dir, "leader.lock")
+	require.NoError(t, os.WriteFile(lockPath, []byte("crashed-node"), 0o600))
+
+	stale := time.Now().Add(-time.Minute)
+	require.NoError(t, os.Chtimes(lockPath, stale, stale))
+
+	lease, err := store.AcquireLease("cr-a", "http://cr-a.example:8080", time.Second)
+	require.NoError(t, err)
+	require.Equal(t, "cr-a", lease.GetHolderID())
+
+	// 解放後はロックファイルも作業用のファイルも残らない
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+
+	for _, entry := range entries {
+		require.NotContains(t, entry.Name(), "leader.lock")
+	}
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"connectrpc.com/connect"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+	"google.golang.org/protobuf/types/known/structpb"
+
+	protov1 "github.com/anyfld/vistra-operation-control-room/gen/proto/v1"
+	"github.com/anyfld/vistra-operation-control-room/gen/proto/v1/protov1connect"
+	"github.com/anyfld/vistra-operation-control-room/pkg/transport/infrastructure"
+)
+
+// newTestRepositories はフェデレーションの親を持たないリポジトリを作成します。
+func newTestRepositories() *repositories {
+	return newRepositories(infrastructure.NewFederationRepo("test", "", http.DefaultClient))
+}
+
+// startH2CServer は mux を h2c で提供するサーバーを起動します。サーバーはテストの終了時に閉じます。
+func startH2CServer(t *testing.T, mux *http.ServeMux) *httptest.Server {
+	t.Helper()
+
+	server := httptest.NewUnstartedServer(h2c.NewHandler(mux, &http2.Server{}))
+	server.EnableHTTP2 = false
+	server.Start()
+	t.Cleanup(server.Close)
+
+	return server
+}
+
+// e2eServer は e2e テストで共有する準備です。サーバーとそのリポジトリ、クライアント、登録したカメラを持ちます。
+type e2eServer struct {
+	repos    *repositories
+	url      string
+	clients  crTestClients
+	fd       protov1connect.FDServiceClient
+	cameraID string
+}
+
+// newE2EServer は setupHandlers で全てのサービスを登録したサーバーを起動し、camera を登録します。
+// camera が nil の場合はカメラを登録しません。
+func newE2EServer(t *testing.T, camera *protov1.RegisterCameraRequest) *e2eServer {
+	t.Helper()
+
+	repos := newTestRepositories()
+
+	return startE2EServer(t, repos, setupHandlers(t.Context(), repos, nil), camera)
+}
+
+// startE2EServer は mux を提供するサーバーを起動し、repos に camera を登録します。
+// 全てのサービスが不要なテストや、サービスの組み立てを変えるテストで使います。
+func startE2EServer(
+	t *testing.T,
+	repos *repositories,
+	mux *http.ServeMux,
+	camera *protov1.RegisterCameraRequest,
+) *e2eServer {
+	t.Helper()
+
+	server, clients := startCRTestServer(t, mux)
+
+	s := &e2eServer{
+		repos:    repos,
+		url:      server.URL,
+		clients:  clients,
+		fd:       protov1connect.NewFDServiceClient(newH2CClient(), server.URL),
+		cameraID: "",
+	}
+	if camera != nil {
+		s.cameraID = s.registerCamera(camera)
+	}
+
+	return s
+}
+
+// testCamera は自律モードのカメラの登録リクエストを作成します。MF のIDは "mf-" に name を付けたものです。
+func testCamera(name string, capabilities *protov1.CameraCapabilities) *protov1.RegisterCameraRequest {
+	return &protov1.RegisterCameraRequest{
+		Name:         name,
+		Mode:         protov1.CameraMode_CAMERA_MODE_AUTONOMOUS,
+		MasterMfId:   "mf-" + name,
+		Capabilities: capabilities,
+	}
+}
+
+// registerCamera はカメラを登録し、そのIDを返します。
+func (s *e2eServer) registerCamera(camera *protov1.RegisterCameraRequest) string {
+	return s.repos.camera.RegisterCamera(camera).GetId()
+}
+
+// call はサーバーの Struct RPC を呼び出します。
+func (s *e2eServer) call(
+	ctx context.Context,
+	t *testing.T,
+	procedure string,
+	fields map[string]any,
+) (*structpb.Struct, error) {
+	t.Helper()
+
+	return callStruct(ctx, t, s.url, procedure, fields)
+}
+
+// callStruct は google.protobuf.Struct を入出力とする Struct RPC を呼び出します。
+func callStruct(
+	ctx context.Context,
+	t *testing.T,
+	url string,
+	procedure string,
+	fields map[string]any,
+) (*structpb.Struct, error) {
+	t.Helper()
+
+	req, err := structpb.NewStruct(fields)
+	require.NoError(t, err)
+
+	client := connect.NewClient[structpb.Struct, structpb.Struct](http.DefaultClient, url+procedure)
+
+	resp, err := client.CallUnary(ctx, connect.NewRequest(req))
+	if err != nil {
+		return nil, err
+	}
+
+	return resp.Msg, nil
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/types/known/structpb"
+
+	protov1 "github.com/anyfld/vistra-operation-control-room/gen/proto/v1"
+	"github.com/anyfld/vistra-operation-control-room/pkg/transport/infrastructure"
+)
+
+// keyframeFixture はタスクのキーフレームを配信するディスパッチャー（アーム・フォーカス・PTZの軌道）の
+// テストで共有する準備です。カメラを1台登録し、タスクのイベントを購読します。
+type keyframeFixture struct {
+	*e2eServer
+
+	events *infrastructure.EventSubscription
+}
+
+func newKeyframeFixture(t *testing.T, camera *protov1.RegisterCameraRequest) *keyframeFixture {
+	t.Helper()
+
+	server := newE2EServer(t, camera)
+
+	events := server.repos.events.Subscribe(infrastructure.EventTopicTask)
+	t.Cleanup(func() { server.repos.events.Unsubscribe(events) })
+
+	return &keyframeFixture{e2eServer: server, events: events}
+}
+
+// call は Struct RPC を呼び出します。camera_id を省略した場合は登録したカメラを使います。
+func (f *keyframeFixture) call(
+	ctx context.Context,
+	t *testing.T,
+	procedure string,
+	fields map[string]any,
+) (*structpb.Struct, error) {
+	t.Helper()
+
+	if _, ok := fields["camera_id"]; !ok {
+		fields["camera_id"] = f.cameraID
+	}
+
+	return f.e2eServer.call(ctx, t, procedure, fields)
+}
+
+// nextCommand はFDへ配信された制御コマンドのうち、match に一致するものを待ちます。
+func (f *keyframeFixture) nextCommand(
+	t *testing.T,
+	match func(*protov1.ControlCommand) bool,
+) *protov1.ControlCommand {
+	t.Helper()
+
+	timeout := time.After(3 * time.Second)
+
+	for {
+		select {
+		case event := <-f.events.Events():
+			command, ok := event.Payload.(*protov1.ControlCommand)
+			if event.Type == infrastructure.EventControlCommandDispatched && ok && match(command) {
+				return command
+			}
+		case <-timeout:
+			require.FailNow(t, "timed out waiting for a control command")
+		}
+	}
+}
+
+// requireNoCommand は wait の間、match に一致する制御コマンドが配信されないことを確認します。
+func (f *keyframeFixture) requireNoCommand(
+	t *testing.T,
+	match func(*protov1.ControlCommand) bool,
+	wait time.Duration,
+) {
+	t.Helper()
+
+	timeout := time.After(wait)
+
+	for {
+		select {
+		case event := <-f.events.Events():
+			command, ok := event.Payload.(*protov1.ControlCommand)
+			if event.Type == infrastructure.EventControlCommandDispatched && ok {
+				require.False(t, match(command), command.GetCommandId())
+			}
+		case <-timeout:
+			return
+		}
+	}
+}
+
+// waitTaskEvent はタスクのイベントを待ちます。
+func (f *keyframeFixture) waitTaskEvent(t *testing.T, eventType, taskID string) {
+	t.Helper()
+
+	timeout := time.After(3 * time.Second)
+
+	for {
+		select {
+		case event := <-f.events.Events():
+			if event.Type == eventType && event.ResourceID == taskID {
+				return
+			}
+		case <-timeout:
+			require.FailNow(t, "timed out waiting for "+eventType)
+		}
+	}
+}
+
+// startTask はFDとしてポーリングし、実行対象のタスクを返します。completedTaskID は完了を報告するタスクです。
+func (f *keyframeFixture) startTask(t *testing.T, completedTaskID string) *protov1.Task {
+	t.Helper()
+
+	current, _, _ := f.repos.ptz.ProcessPolling(
+		f.cameraID, completedTaskID, "", nil,
+		protov1.DeviceStatus_DEVICE_STATUS_EXECUTING, protov1.CameraStatus_CAMERA_STATUS_ONLINE,
+	)
+
+	return current
+}
+
+// anyCommand は全ての制御コマンドに一致します。
+func anyCommand(*protov1.ControlCommand) bool {
+	return true
+}
+
+// commandOfTask はタスクのキーフレームとして配信された制御コマンドに一致します。
+func commandOfTask(taskID string) func(*protov1.ControlCommand) bool {
+	return func(command *protov1.ControlCommand) bool {
+		return strings.HasPrefix(command.GetCommandId(), taskID+"-")
+	}
+}
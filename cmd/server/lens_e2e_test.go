@@ -0,0 +1,190 @@
+package main
+
+import (
+	"context"
+	"math"
+	"testing"
+	"time"
+
+	"connectrpc.com/connect"
+	"github.com/stretchr/testify/require"
+
+	protov1 "github.com/anyfld/vistra-operation-control-room/gen/proto/v1"
+	"github.com/anyfld/vistra-operation-control-room/pkg/transport/handlers"
+)
+
+type lensFixture struct {
+	*e2eServer
+}
+
+// newLensFixture はパン ±170度、チルト -30〜90度、ズーム 1〜20倍 のカメラを登録します。
+func newLensFixture(t *testing.T) *lensFixture {
+	t.Helper()
+
+	return &lensFixture{e2eServer: newE2EServer(t, testCamera("lens-camera", &protov1.CameraCapabilities{
+		SupportsPtz: true,
+		PanMin:      -170,
+		PanMax:      170,
+		TiltMin:     -30,
+		TiltMax:     90,
+		ZoomMin:     1,
+		ZoomMax:     20,
+	}))}
+}
+
+// imageToPTZ は current のPTZで撮影した画面上の位置を中心に置くパンとチルトを返します。
+func (f *lensFixture) imageToPTZ(ctx context.Context, t *testing.T, x, y, pan, zoom float64) (float64, float64) {
+	t.Helper()
+
+	resp, err := f.call(ctx, t, handlers.LensServiceImageToPTZProcedure, map[string]any{
+		"camera_id":   f.cameraID,
+		"x":           x,
+		"y":           y,
+		"current_ptz": map[string]any{"pan": pan, "tilt": 0, "zoom": zoom},
+	})
+	require.NoError(t, err)
+
+	ptz := resp.GetFields()["ptz"].GetStructValue().GetFields()
+
+	return ptz["pan"].GetNumberValue(), ptz["tilt"].GetNumberValue()
+}
+
+func halfFOVDeg(tangent float64) float64 {
+	return math.Atan(tangent) * 180 / math.Pi
+}
+
+func tangentOfHalf(fovDeg float64) float64 {
+	return math.Tan(fovDeg / 2 * math.Pi / 180)
+}
+
+func TestLensService_CalibrationRoundTripAndImageToPTZ(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithTimeout(t.Context(), 5*time.Second)
+	defer cancel()
+
+	fixture := newLensFixture(t)
+
+	_, err := fixture.call(ctx, t, handlers.LensServiceGetLensCalibrationProcedure, map[string]any{
+		"camera_id": fixture.cameraID,
+	})
+	require.Equal(t, connect.CodeNotFound, connect.CodeOf(err))
+
+	// ズームを上げて画角が広がる測定値は不正
+	_, err = fixture.call(ctx, t, handlers.LensServiceSetLensCalibrationProcedure, map[string]any{
+		"camera_id": fixture.cameraID,
+		"zoom_fov": []any{
+			map[string]any{"zoom": 1, "horizontal_fov_deg": 60},
+			map[string]any{"zoom": 10, "horizontal_fov_deg": 65},
+		},
+	})
+	require.Equal(t, connect.CodeInvalidArgument, connect.CodeOf(err))
+
+	_, err = fixture.call(ctx, t, handlers.LensServiceSetLensCalibrationProcedure, map[string]any{
+		"camera_id": "cam-missing",
+		"zoom_fov":  []any{map[string]any{"zoom": 1, "horizontal_fov_deg": 60}},
+	})
+	require.Equal(t, connect.CodeNotFound, connect.CodeOf(err))
+
+	saved, err := fixture.call(ctx, t, handlers.LensServiceSetLensCalibrationProcedure, map[string]any{
+		"camera_id": fixture.cameraID,
+		"zoom_fov": []any{
+			map[string]any{"zoom": 1, "horizontal_fov_deg": 60},
+			map[string]any{"zoom": 10, "horizontal_fov_deg": 8},
+		},
+		"sensor_aspect": 1.5,
+	})
+	require.NoError(t, err)
+	require.Positive(t, saved.GetFields()["updated_at_ms"].GetNumberValue())
+
+	fetched, err := fixture.call(ctx, t, handlers.LensServiceGetLensCalibrationProcedure, map[string]any{
+		"camera_id": fixture.cameraID,
+	})
+	require.NoError(t, err)
+	require.Equal(t, fixture.cameraID, fetched.GetFields()["camera_id"].GetStringValue())
+	require.InDelta(t, 1.5, fetched.GetFields()["sensor_aspect"].GetNumberValue(), 1e-9)
+	require.Len(t, fetched.GetFields()["zoom_fov"].GetListValue().GetValues(), 2)
+
+	// 測定点では測定した画角の半分だけ、画面の端が中心から離れている
+	pan, tilt := fixture.imageToPTZ(ctx, t, 1, 0, 10, 1)
+	require.InDelta(t, 40, pan, 0.01)
+	require.InDelta(t, halfFOVDeg(tangentOfHalf(60)/1.5), tilt, 0.01)
+
+	pan, _ = fixture.imageToPTZ(ctx, t, 0, 0.5, 10, 10)
+	require.InDelta(t, 6, pan, 0.01)
+
+	// 測定点の間は焦点距離を線形に補間する
+	focal := 1/tangentOfHalf(60) + (5.0-1)/(10-1)*(1/tangentOfHalf(8)-1/tangentOfHalf(60))
+	pan, _ = fixture.imageToPTZ(ctx, t, 1, 0.5, 10, 5)
+	require.InDelta(t, 10+halfFOVDeg(1/focal), pan, 0.01)
+
+	// 画面の中心は現在のPTZのまま
+	pan, tilt = fixture.imageToPTZ(ctx, t, 0.5, 0.5, 10, 5)
+	require.InDelta(t, 10, pan, 0.001)
+	require.InDelta(t, 0, tilt, 0.001)
+
+	// 現在のPTZを指定せず、カメラも報告していない場合は変換できない
+	_, err = fixture.call(ctx, t, handlers.LensServiceImageToPTZProcedure, map[string]any{
+		"camera_id": fixture.cameraID,
+		"x":         0.5,
+		"y":         0.5,
+	})
+	require.Equal(t, connect.CodeFailedPrecondition, connect.CodeOf(err))
+
+	// sensor_aspect を省略した場合は 16:9 として保存する
+	_, err = fixture.call(ctx, t, handlers.LensServiceSetLensCalibrationProcedure, map[string]any{
+		"camera_id": fixture.cameraID,
+		"zoom_fov":  []any{map[string]any{"zoom": 1, "horizontal_fov_deg": 60}},
+	})
+	require.NoError(t, err)
+
+	fetched, err = fixture.call(ctx, t, handlers.LensServiceGetLensCalibrationProcedure, map[string]any{
+		"camera_id": fixture.cameraID,
+	})
+	require.NoError(t, err)
+	require.InDelta(t, 16.0/9, fetched.GetFields()["sensor_aspect"].GetNumberValue(), 1e-9)
+}
+
+func TestLensCalibration_AppliesToFraming(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithTimeout(t.Context(), 5*time.Second)
+	defer cancel()
+
+	fixture := newLensFixture(t)
+
+	framedZoom := func() float32 {
+		resp, err := fixture.fd.CalculateFraming(ctx, connect.NewRequest(&protov1.CalculateFramingRequest{
+			CameraId:       fixture.cameraID,
+			CurrentPtz:     &protov1.PTZParameters{Pan: 0, Tilt: 0, Zoom: 1},
+			TargetShotType: protov1.ShotType_SHOT_TYPE_MEDIUM,
+			TargetSubjects: []*protov1.DetectedSubject{{
+				Subject:     &protov1.Subject{Id: "presenter", Type: "person"},
+				Confidence:  0.9,
+				DetectedBox: &protov1.BoundingBox{X: 0.45, Y: 0.3, Width: 0.1, Height: 0.6},
+			}},
+			TargetAngle: protov1.CameraAngle_CAMERA_ANGLE_EYE_LEVEL,
+		}))
+		require.NoError(t, err)
+		require.True(t, resp.Msg.GetSuccess(), resp.Msg.GetErrorMessage())
+
+		return resp.Msg.GetCalculatedPtz().GetZoom()
+	}
+
+	uncalibrated := framedZoom()
+
+	// 望遠側の画角がピンホールモデル（ズーム20倍で約3.1度）より広いレンズでは、
+	// 同じショットにするためにより大きなズーム倍率が必要になる
+	_, err := fixture.call(ctx, t, handlers.LensServiceSetLensCalibrationProcedure, map[string]any{
+		"camera_id": fixture.cameraID,
+		"zoom_fov": []any{
+			map[string]any{"zoom": 1, "horizontal_fov_deg": 60},
+			map[string]any{"zoom": 20, "horizontal_fov_deg": 6},
+		},
+	})
+	require.NoError(t, err)
+
+	calibrated := framedZoom()
+	require.Greater(t, calibrated, uncalibrated)
+	require.LessOrEqual(t, calibrated, float32(20))
+}
@@ -17,6 +17,7 @@ import (
 	protov1 "github.com/anyfld/vistra-operation-control-room/gen/proto/v1"
 	"github.com/anyfld/vistra-operation-control-room/gen/proto/v1/protov1connect"
 	"github.com/anyfld/vistra-operation-control-room/internal/middleware"
+	"github.com/anyfld/vistra-operation-control-room/pkg/config"
 	handlers "github.com/anyfld/vistra-operation-control-room/pkg/transport/handlers"
 	"github.com/anyfld/vistra-operation-control-room/pkg/transport/infrastructure"
 	"github.com/anyfld/vistra-operation-control-room/pkg/transport/usecase"
@@ -38,9 +39,53 @@ func (h *ExampleServiceHandler) Ping(
 	}), nil
 }
 
+type repositories struct {
+	events     *infrastructure.EventBus
+	camera     *infrastructure.CameraRepo
+	ptz        *infrastructure.PTZRepo
+	fd         *infrastructure.FDRepo
+	md         *infrastructure.MDRepo
+	rundown    *infrastructure.RundownRepo
+	presets    *infrastructure.PresetRepo
+	telemetry  *infrastructure.TelemetryRepo
+	estimator  *infrastructure.PTZEstimator
+	federation *infrastructure.FederationRepo
+}
+
+func newRepositories(federation *infrastructure.FederationRepo) *repositories {
+	events := infrastructure.NewEventBus()
+
+	return &repositories{
+		events:     events,
+		camera:     infrastructure.NewCameraRepo(events),
+		ptz:        infrastructure.NewPTZRepo(events),
+		fd:         infrastructure.NewFDRepo(events),
+		md:         infrastructure.NewMDRepo(events),
+		rundown:    infrastructure.NewRundownRepo(),
+		presets:    infrastructure.NewPresetRepo(),
+		telemetry:  infrastructure.NewTelemetryRepo(),
+		estimator:  infrastructure.NewPTZEstimator(),
+		federation: federation,
+	}
+}
+
 func main() {
-	cameraRepo := infrastructure.NewCameraRepo()
-	mux := setupHandlers(cameraRepo)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	federation, err := setupFederation()
+	if err != nil {
+		log.Fatalf("Federation config error: %v", err)
+	}
+
+	repos := newRepositories(federation)
+
+	ha, err := setupHA(ctx, repos)
+	if err != nil {
+		log.Fatalf("HA config error: %v", err)
+	}
+
+	mux := setupHandlers(ctx, repos, ha)
 	addr := getServerAddress()
 	server := createServer(addr, mux)
 
@@ -51,66 +96,404 @@ func main() {
 	if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
 		log.Fatalf("Server error: %v", err)
 	}
+
+	if ha != nil {
+		if err := ha.Resign(); err != nil {
+			log.Printf("HA resign error: %v", err)
+		}
+	}
+}
+
+func setupFederation() (*infrastructure.FederationRepo, error) {
+	cfg, err := config.LoadFederationConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	peers, err := cfg.ParsePeers()
+	if err != nil {
+		return nil, err
+	}
+
+	federation := infrastructure.NewFederationRepo(
+		cfg.OriginID,
+		cfg.SharedSecret,
+		infrastructure.NewFederationHTTPClient(cfg.RequestTimeout),
+	)
+
+	for _, peer := range peers {
+		federation.AddPeer(peer.ID, peer.BaseURL)
+		log.Printf("Federation peer registered: peer_id=%s base_url=%s", peer.ID, peer.BaseURL)
+	}
+
+	return federation, nil
+}
+
+// setupHA はHAが有効な場合にリーダー選出を開始します。無効な場合は nil を返します。
+func setupHA(ctx context.Context, repos *repositories) (*usecase.HAUsecase, error) {
+	cfg, err := config.LoadHAConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	if !cfg.Enabled {
+		return nil, nil
+	}
+
+	nodeID := cfg.NodeID
+	if nodeID == "" {
+		if nodeID, err = os.Hostname(); err != nil {
+			return nil, err
+		}
+	}
+
+	store, err := infrastructure.NewFileSharedStore(cfg.StateDir)
+	if err != nil {
+		return nil, err
+	}
+
+	ha := newHAUsecase(store, repos, nodeID, cfg.AdvertiseAddress, haIntervals{
+		leaseTTLMs:        cfg.LeaseTTLMs,
+		renewIntervalMs:   cfg.RenewIntervalMs,
+		persistIntervalMs: cfg.PersistIntervalMs,
+	})
+	ha.Start(ctx)
+
+	log.Printf("HA enabled: node_id=%s state_dir=%s leader=%t", nodeID, cfg.StateDir, ha.IsLeader())
+
+	return ha, nil
+}
+
+// haIntervals はリーダー選出と状態の書き込みの間隔です。
+type haIntervals struct {
+	leaseTTLMs        int
+	renewIntervalMs   int
+	persistIntervalMs int
+}
+
+func newHAUsecase(
+	store infrastructure.SharedStore,
+	repos *repositories,
+	nodeID string,
+	address string,
+	intervals haIntervals,
+) *usecase.HAUsecase {
+	elector := infrastructure.NewLeaderElector(
+		store,
+		nodeID,
+		address,
+		time.Duration(intervals.leaseTTLMs)*time.Millisecond,
+	)
+
+	return usecase.NewHAUsecase(
+		elector,
+		store,
+		repos.ptz,
+		repos.camera,
+		repos.presets,
+		time.Duration(intervals.renewIntervalMs)*time.Millisecond,
+		time.Duration(intervals.persistIntervalMs)*time.Millisecond,
+	)
+}
+
+// runAsLeader は start で開始する処理を、HAが有効な場合はこのノードがリーダーである間だけ動作させます。
+// HAが無効な場合は ctx が終了するまで動作させます。
+func runAsLeader(ctx context.Context, ha *usecase.HAUsecase, start func(ctx context.Context)) {
+	if ha == nil {
+		start(ctx)
+
+		return
+	}
+
+	ha.RunAsLeader(ctx, start)
 }
 
-func setupHandlers(cameraRepo *infrastructure.CameraRepo) *http.ServeMux {
+// setupONVIF は内蔵のONVIFドライバーが有効な場合に、ONVIFカメラの直接制御を開始します。
+// HAが有効な場合、スタンバイのノードはカメラを制御しません。
+func setupONVIF(ctx context.Context, repos *repositories, fdUC *usecase.FDUsecase, ha *usecase.HAUsecase) error {
+	cfg, err := config.LoadONVIFConfig()
+	if err != nil {
+		return err
+	}
+
+	if !cfg.Enabled {
+		return nil
+	}
+
+	runAsLeader(ctx, ha, func(ctx context.Context) {
+		startONVIFDriver(ctx, repos, fdUC, cfg)
+	})
+
+	log.Printf("ONVIF driver enabled: poll_interval_ms=%d", cfg.PollIntervalMs)
+
+	return nil
+}
+
+func startONVIFDriver(
+	ctx context.Context,
+	repos *repositories,
+	fdUC *usecase.FDUsecase,
+	cfg config.ONVIFConfig,
+) *usecase.ONVIFUsecase {
+	onvif := usecase.NewONVIFUsecase(
+		fdUC,
+		usecase.NewCameraUsecase(repos.camera, repos.estimator),
+		repos.camera,
+		repos.events,
+		&http.Client{Timeout: time.Duration(cfg.RequestTimeoutMs) * time.Millisecond}, //nolint:exhaustruct
+		time.Duration(cfg.PollIntervalMs)*time.Millisecond,
+		time.Duration(cfg.MoveTimeoutMs)*time.Millisecond,
+	)
+	onvif.Start(ctx)
+
+	return onvif
+}
+
+func setupHandlers(ctx context.Context, repos *repositories, ha *usecase.HAUsecase) *http.ServeMux {
 	mux := http.NewServeMux()
 
+	opts := make([]connect.HandlerOption, 0, 1)
+	requireLeader := func(h http.Handler) http.Handler { return h }
+
+	// スタンバイ中は全てのRPCをリーダーへリダイレクトさせる
+	if ha != nil {
+		opts = append(opts, connect.WithInterceptors(handlers.NewLeaderInterceptor(ha)))
+		requireLeader = func(h http.Handler) http.Handler { return handlers.RequireLeaderHTTP(ha, h) }
+
+		mux.Handle("/ha/leader", handlers.NewHAHandler(ha).LeaderStatusHTTP())
+	}
+
 	handler := &ExampleServiceHandler{}
-	path, httpHandler := protov1connect.NewExampleServiceHandler(handler)
+	path, httpHandler := protov1connect.NewExampleServiceHandler(handler, opts...)
 	mux.Handle(path, httpHandler)
 
-	registerMDService(mux)
-	registerCameraService(mux, cameraRepo)
-	registerCRService(mux)
-	registerFDService(mux, cameraRepo)
-	registerPTZService(mux)
+	mdUC := registerMDService(mux, repos, opts...)
+	registerCameraService(mux, repos, opts...)
+	crUC := registerCRService(ctx, mux, repos, opts...)
+	fdHandler, fdUC := registerFDService(ctx, mux, repos, ha, opts...)
+
+	if err := setupONVIF(ctx, repos, fdUC, ha); err != nil {
+		log.Fatalf("ONVIF config error: %v", err)
+	}
+
+	ptzUC := registerPTZService(mux, repos, opts...)
+	registerEventService(mux, repos, opts...)
+	registerPresetService(mux, repos, opts...)
+	registerTelemetryService(mux, repos, opts...)
+	registerLensService(mux, repos, opts...)
+	registerPTZLimitsService(mux, repos, opts...)
+	registerPTZEstimateService(mux, repos, opts...)
+	registerRundownAPI(mux, repos, crUC, ptzUC, mdUC, fdUC, requireLeader)
+	registerPatternMatchingAPI(mux, fdHandler, requireLeader)
+	registerFDFallbackAPI(mux, fdHandler, handlers.NewPTZHandler(ptzUC), requireLeader)
 
 	return mux
 }
 
-func registerMDService(mux *http.ServeMux) {
-	mdRepo := infrastructure.NewMDRepo()
+func registerMDService(mux *http.ServeMux, repos *repositories, opts ...connect.HandlerOption) *usecase.MDUsecase {
+	mdUC := usecase.NewMDUsecase(repos.md)
+	if path, h := protov1connect.NewMDServiceHandler(handlers.NewMDHandler(mdUC), opts...); path != "" {
+		mux.Handle(path, h)
+	}
+
+	return mdUC
+}
 
-	mdUC := usecase.NewMDUsecase(mdRepo)
-	if path, h := protov1connect.NewMDServiceHandler(handlers.NewMDHandler(mdUC)); path != "" {
+func registerCameraService(mux *http.ServeMux, repos *repositories, opts ...connect.HandlerOption) {
+	cameraUC := usecase.NewCameraUsecase(repos.camera, repos.estimator)
+	if path, h := protov1connect.NewCameraServiceHandler(handlers.NewCameraHandler(cameraUC), opts...); path != "" {
 		mux.Handle(path, h)
 	}
 }
 
-func registerCameraService(mux *http.ServeMux, cameraRepo *infrastructure.CameraRepo) {
-	cameraUC := usecase.NewCameraUsecase(cameraRepo)
-	if path, h := protov1connect.NewCameraServiceHandler(handlers.NewCameraHandler(cameraUC)); path != "" {
+func registerCRService(
+	ctx context.Context,
+	mux *http.ServeMux,
+	repos *repositories,
+	opts ...connect.HandlerOption,
+) *usecase.CRUsecase {
+	uc := usecase.New(
+		infrastructure.NewInMemoryRepo(repos.events), repos.ptz, repos.camera, repos.estimator, repos.federation,
+	)
+	uc.StartCollectingCinematographyResults(ctx)
+
+	if path, h := protov1connect.NewCRServiceHandler(
+		handlers.NewCRHandler(uc),
+		connect.WithHandlerOptions(opts...),
+		connect.WithInterceptors(infrastructure.NewFederationInterceptor(repos.federation)),
+	); path != "" {
 		mux.Handle(path, h)
 	}
+
+	return uc
 }
-func registerCRService(mux *http.ServeMux) {
-	uc := usecase.New(infrastructure.NewInMemoryRepo())
-	if path, h := protov1connect.NewCRServiceHandler(handlers.NewCRHandler(uc)); path != "" {
+
+// registerFDService はFDServiceを登録します。FDへ配信する処理は、HAが有効な場合はリーダーである間だけ動作させます。
+func registerFDService(
+	ctx context.Context,
+	mux *http.ServeMux,
+	repos *repositories,
+	ha *usecase.HAUsecase,
+	opts ...connect.HandlerOption,
+) (*handlers.FDHandler, *usecase.FDUsecase) {
+	images := infrastructure.NewImageLoader(nil)
+	fdUC := usecase.NewFDUsecase(
+		repos.fd,
+		repos.camera,
+		repos.ptz,
+		repos.telemetry,
+		repos.estimator,
+		repos.events,
+		usecase.NewPresetUsecase(repos.presets, repos.camera),
+		images,
+		infrastructure.NewReferenceDetector(images),
+	)
+	fdUC.StartPatternMatchingSupervisor(ctx)
+	fdUC.StartCommandPipeline(ctx)
+	runAsLeader(ctx, ha, func(ctx context.Context) {
+		fdUC.StartArmDispatcher(ctx)
+		fdUC.StartFocusDispatcher(ctx)
+		fdUC.StartTrajectoryDispatcher(ctx)
+		fdUC.StartPTZEstimation(ctx)
+	})
+
+	cameraUC := usecase.NewCameraUsecase(repos.camera, repos.estimator)
+	fdHandler := handlers.NewFDHandler(fdUC, cameraUC)
+
+	if path, h := protov1connect.NewFDServiceHandler(fdHandler, opts...); path != "" {
+		mux.Handle(path, h)
+	}
+
+	for path, h := range handlers.NewFDTrackingHandlers(fdHandler, opts...) {
+		mux.Handle(path, h)
+	}
+
+	for path, h := range handlers.NewFDArmHandlers(fdHandler, opts...) {
+		mux.Handle(path, h)
+	}
+
+	for path, h := range handlers.NewFDFocusHandlers(fdHandler, opts...) {
+		mux.Handle(path, h)
+	}
+
+	for path, h := range handlers.NewFDTrajectoryHandlers(fdHandler, opts...) {
+		mux.Handle(path, h)
+	}
+
+	for path, h := range handlers.NewFDControlCommandHandlers(fdHandler, opts...) {
+		mux.Handle(path, h)
+	}
+
+	for path, h := range handlers.NewFDStreamHandlers(fdHandler, opts...) {
+		mux.Handle(path, h)
+	}
+
+	return fdHandler, fdUC
+}
+
+// registerPatternMatchingAPI はFDがフレームを送信するHTTP APIを PatternMatchingPathPrefix 以下に登録します。
+func registerPatternMatchingAPI(mux *http.ServeMux, h *handlers.FDHandler, wrap func(http.Handler) http.Handler) {
+	prefix := handlers.PatternMatchingPathPrefix
+
+	mux.Handle("POST "+prefix+"/sessions/{id}/frames", wrap(h.PushPatternMatchingFrameHTTP()))
+}
+
+// registerFDFallbackAPI は Connect クライアントを持たないFD向けの HTTP/JSON API を FDFallbackPathPrefix 以下に登録します。
+func registerFDFallbackAPI(
+	mux *http.ServeMux,
+	fd *handlers.FDHandler,
+	ptz *handlers.PTZHandler,
+	wrap func(http.Handler) http.Handler,
+) {
+	prefix := handlers.FDFallbackPathPrefix + "/cameras/{id}"
+
+	mux.Handle("POST "+prefix+"/state", wrap(fd.ReportCameraStateHTTP()))
+	mux.Handle("POST "+prefix+"/commands", wrap(fd.SendControlCommandHTTP()))
+	mux.Handle("GET "+prefix+"/commands", wrap(fd.PollControlCommandsHTTP()))
+	mux.Handle("POST "+prefix+"/results", wrap(fd.ReportControlCommandResultHTTP()))
+	mux.Handle("POST "+prefix+"/ptz/polling", wrap(ptz.PollingHTTP()))
+}
+
+func registerPTZService(mux *http.ServeMux, repos *repositories, opts ...connect.HandlerOption) *usecase.PTZUsecase {
+	ptzUC := usecase.NewPTZUsecase(repos.ptz, repos.camera, repos.telemetry, repos.estimator, repos.federation)
+	if path, h := protov1connect.NewPTZServiceHandler(
+		handlers.NewPTZHandler(ptzUC),
+		connect.WithHandlerOptions(opts...),
+		connect.WithInterceptors(infrastructure.NewFederationInterceptor(repos.federation)),
+	); path != "" {
+		mux.Handle(path, h)
+	}
+
+	return ptzUC
+}
+
+func registerEventService(mux *http.ServeMux, repos *repositories, opts ...connect.HandlerOption) {
+	eventUC := usecase.NewEventUsecase(repos.events)
+	if path, h := handlers.NewEventServiceHandler(handlers.NewEventHandler(eventUC), opts...); path != "" {
 		mux.Handle(path, h)
 	}
 }
 
-func registerFDService(mux *http.ServeMux, cameraRepo *infrastructure.CameraRepo) {
-	fdRepo := infrastructure.NewFDRepo()
+func registerPresetService(mux *http.ServeMux, repos *repositories, opts ...connect.HandlerOption) {
+	presetUC := usecase.NewPresetUsecase(repos.presets, repos.camera)
+	if path, h := handlers.NewPresetServiceHandler(handlers.NewPresetHandler(presetUC), opts...); path != "" {
+		mux.Handle(path, h)
+	}
+}
 
-	fdUC := usecase.NewFDUsecase(fdRepo)
-	cameraUC := usecase.NewCameraUsecase(cameraRepo)
+func registerTelemetryService(mux *http.ServeMux, repos *repositories, opts ...connect.HandlerOption) {
+	telemetryUC := usecase.NewTelemetryUsecase(repos.telemetry)
+	if path, h := handlers.NewTelemetryServiceHandler(handlers.NewTelemetryHandler(telemetryUC), opts...); path != "" {
+		mux.Handle(path, h)
+	}
+}
 
-	if path, h := protov1connect.NewFDServiceHandler(handlers.NewFDHandler(fdUC, cameraUC)); path != "" {
+func registerLensService(mux *http.ServeMux, repos *repositories, opts ...connect.HandlerOption) {
+	lensUC := usecase.NewLensUsecase(repos.camera, repos.estimator)
+	if path, h := handlers.NewLensServiceHandler(handlers.NewLensHandler(lensUC), opts...); path != "" {
 		mux.Handle(path, h)
 	}
 }
 
-func registerPTZService(mux *http.ServeMux) {
-	ptzRepo := infrastructure.NewPTZRepo()
+func registerPTZLimitsService(mux *http.ServeMux, repos *repositories, opts ...connect.HandlerOption) {
+	limitsUC := usecase.NewPTZLimitsUsecase(repos.camera)
+	if path, h := handlers.NewPTZLimitsServiceHandler(handlers.NewPTZLimitsHandler(limitsUC), opts...); path != "" {
+		mux.Handle(path, h)
+	}
+}
 
-	ptzUC := usecase.NewPTZUsecase(ptzRepo)
-	if path, h := protov1connect.NewPTZServiceHandler(handlers.NewPTZHandler(ptzUC)); path != "" {
+func registerPTZEstimateService(mux *http.ServeMux, repos *repositories, opts ...connect.HandlerOption) {
+	estimateUC := usecase.NewPTZEstimateUsecase(repos.camera, repos.estimator)
+	if path, h := handlers.NewPTZEstimateServiceHandler(handlers.NewPTZEstimateHandler(estimateUC), opts...); path != "" {
 		mux.Handle(path, h)
 	}
 }
 
+// registerRundownAPI は台本APIを RundownPathPrefix 以下に登録します。
+// キューの操作はCR・PTZ・MD・FDのユースケースを経由するため、各RPCと同じ経路で実行されます。
+func registerRundownAPI(
+	mux *http.ServeMux,
+	repos *repositories,
+	crUC usecase.CRInteractor,
+	ptzUC usecase.PTZInteractor,
+	mdUC usecase.MDInteractor,
+	fdUC usecase.FDInteractor,
+	wrap func(http.Handler) http.Handler,
+) {
+	h := handlers.NewRundownHandler(usecase.NewRundownUsecase(repos.rundown, crUC, ptzUC, mdUC, fdUC))
+	prefix := handlers.RundownPathPrefix
+
+	mux.Handle("POST "+prefix, wrap(h.UploadRundownHTTP()))
+	mux.Handle("GET "+prefix, wrap(h.ListRundownsHTTP()))
+	mux.Handle("GET "+prefix+"/{id}", wrap(h.GetRundownHTTP()))
+	mux.Handle("DELETE "+prefix+"/{id}", wrap(h.DeleteRundownHTTP()))
+	mux.Handle("POST "+prefix+"/{id}/start", wrap(h.StartRundownHTTP()))
+	mux.Handle("POST "+prefix+"/{id}/stop", wrap(h.StopRundownHTTP()))
+	mux.Handle("POST "+prefix+"/{id}/next", wrap(h.NextCueHTTP()))
+	mux.Handle("POST "+prefix+"/{id}/cues/{cue}/go", wrap(h.GoToCueHTTP()))
+	mux.Handle("GET "+prefix+"/{id}/stream", wrap(h.StreamRundownHTTP()))
+}
+
 func getServerAddress() string {
 	addr := ":8080"
 	if port := os.Getenv("PORT"); port != "" {
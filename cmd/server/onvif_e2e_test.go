@@ -0,0 +1,505 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/xml"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"connectrpc.com/connect"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/types/known/structpb"
+
+	protov1 "github.com/anyfld/vistra-operation-control-room/gen/proto/v1"
+	"github.com/anyfld/vistra-operation-control-room/pkg/config"
+	"github.com/anyfld/vistra-operation-control-room/pkg/transport/handlers"
+	"github.com/anyfld/vistra-operation-control-room/pkg/transport/infrastructure"
+	"github.com/anyfld/vistra-operation-control-room/pkg/transport/usecase"
+)
+
+const (
+	onvifStubUsername = "admin"
+	onvifStubPassword = "secret"
+	onvifStubProfile  = "profile-main"
+)
+
+var (
+	onvifActionPattern = regexp.MustCompile(`action="[^"]*/(\w+)"`)
+	// onvifMovePattern は移動の操作の要素名です。操作によらず同じ構造体で読み取るために置き換えます。
+	onvifMovePattern = regexp.MustCompile(`<(/?)tptz:(AbsoluteMove|RelativeMove)\b`)
+)
+
+// onvifStubCall はSOAPスタブが受け取った操作です。
+type onvifStubCall struct {
+	action string
+	body   string
+}
+
+// onvifStub はONVIFのメディアサービスとPTZサービスのSOAPスタブです。
+// 移動の操作を受け取ると位置を目標に更新し、その後の GetStatus で1回だけ移動中を返します。
+type onvifStub struct {
+	server *httptest.Server
+
+	mu           sync.Mutex
+	calls        []onvifStubCall
+	x, y, z      float64
+	movingPolls  int
+	faultActions map[string]string
+}
+
+func newONVIFStub(t *testing.T) *onvifStub {
+	t.Helper()
+
+	stub := &onvifStub{faultActions: make(map[string]string)}
+	stub.server = httptest.NewServer(http.HandlerFunc(stub.serve))
+	t.Cleanup(stub.server.Close)
+
+	return stub
+}
+
+// connection はスタブへ接続するカメラの接続情報を返します。
+func (s *onvifStub) connection(t *testing.T, parameters map[string]string) *protov1.CameraConnection {
+	t.Helper()
+
+	host, port, err := net.SplitHostPort(strings.TrimPrefix(s.server.URL, "http://"))
+	require.NoError(t, err)
+
+	portNumber, err := strconv.ParseUint(port, 10, 32)
+	require.NoError(t, err)
+
+	return &protov1.CameraConnection{
+		Type:        protov1.ConnectionType_CONNECTION_TYPE_ONVIF,
+		Address:     host,
+		Port:        uint32(portNumber),
+		Credentials: &protov1.CameraCredentials{Username: onvifStubUsername, Password: onvifStubPassword},
+		Parameters:  parameters,
+	}
+}
+
+func (s *onvifStub) fault(action, reason string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.faultActions[action] = reason
+}
+
+// lastCall は最後に受け取った action の操作の本文を返します。
+func (s *onvifStub) lastCall(action string) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i := len(s.calls) - 1; i >= 0; i-- {
+		if s.calls[i].action == action {
+			return s.calls[i].body, true
+		}
+	}
+
+	return "", false
+}
+
+func (s *onvifStub) serve(w http.ResponseWriter, r *http.Request) {
+	data, _ := io.ReadAll(r.Body)
+	body := string(data)
+
+	match := onvifActionPattern.FindStringSubmatch(r.Header.Get("Content-Type"))
+	if match == nil {
+		writeONVIFFault(w, "missing soap action")
+
+		return
+	}
+
+	action := match[1]
+
+	if !onvifStubAuthorized(body) {
+		writeONVIFFault(w, "Sender not Authorized")
+
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.calls = append(s.calls, onvifStubCall{action: action, body: body})
+
+	if reason, ok := s.faultActions[action]; ok {
+		writeONVIFFault(w, reason)
+
+		return
+	}
+
+	switch action {
+	case "GetProfiles":
+		writeONVIFResponse(w, `<trt:GetProfilesResponse xmlns:trt="http://www.onvif.org/ver10/media/wsdl">`+
+			`<trt:Profiles token="`+onvifStubProfile+`" fixed="true"/></trt:GetProfilesResponse>`)
+	case "GetStatus":
+		moveStatus := "IDLE"
+		if s.movingPolls > 0 {
+			s.movingPolls--
+			moveStatus = "MOVING"
+		}
+
+		writeONVIFResponse(w, `<tptz:GetStatusResponse xmlns:tptz="http://www.onvif.org/ver20/ptz/wsdl" `+
+			`xmlns:tt="http://www.onvif.org/ver10/schema"><tptz:PTZStatus><tt:Position>`+
+			`<tt:PanTilt x="`+formatONVIFNumber(s.x)+`" y="`+formatONVIFNumber(s.y)+`"/>`+
+			`<tt:Zoom x="`+formatONVIFNumber(s.z)+`"/></tt:Position>`+
+			`<tt:MoveStatus><tt:PanTilt>`+moveStatus+`</tt:PanTilt><tt:Zoom>IDLE</tt:Zoom></tt:MoveStatus>`+
+			`<tt:Error>NO error</tt:Error></tptz:PTZStatus></tptz:GetStatusResponse>`)
+	case "AbsoluteMove":
+		s.x, s.y, s.z = onvifStubVector(body, "Position")
+		s.movingPolls = 1
+
+		writeONVIFResponse(w, `<tptz:AbsoluteMoveResponse xmlns:tptz="http://www.onvif.org/ver20/ptz/wsdl"/>`)
+	case "RelativeMove":
+		dx, dy, dz := onvifStubVector(body, "Translation")
+		s.x, s.y, s.z = s.x+dx, s.y+dy, s.z+dz
+		s.movingPolls = 1
+
+		writeONVIFResponse(w, `<tptz:RelativeMoveResponse xmlns:tptz="http://www.onvif.org/ver20/ptz/wsdl"/>`)
+	default:
+		if action == "GotoPreset" {
+			s.movingPolls = 1
+		}
+
+		writeONVIFResponse(w, `<tptz:`+action+`Response xmlns:tptz="http://www.onvif.org/ver20/ptz/wsdl"/>`)
+	}
+}
+
+// onvifStubAuthorized は WS-Security の UsernameToken の PasswordDigest を確認します。
+func onvifStubAuthorized(body string) bool {
+	var envelope struct {
+		Token struct {
+			Username string `xml:"Username"`
+			Password string `xml:"Password"`
+			Nonce    string `xml:"Nonce"`
+			Created  string `xml:"Created"`
+		} `xml:"Header>Security>UsernameToken"`
+	}
+
+	if xml.Unmarshal([]byte(body), &envelope) != nil || envelope.Token.Username != onvifStubUsername {
+		return false
+	}
+
+	nonce, err := base64.StdEncoding.DecodeString(envelope.Token.Nonce)
+	if err != nil {
+		return false
+	}
+
+	return envelope.Token.Password ==
+		infrastructure.ONVIFPasswordDigest(nonce, envelope.Token.Created, onvifStubPassword)
+}
+
+// onvifStubVector は操作の本文から PTZVector の要素を読み取ります。
+func onvifStubVector(body, name string) (float64, float64, float64) {
+	var envelope struct {
+		Operation struct {
+			Vector []struct {
+				XMLName xml.Name
+				PanTilt struct {
+					X float64 `xml:"x,attr"`
+					Y float64 `xml:"y,attr"`
+				} `xml:"PanTilt"`
+				Zoom struct {
+					X float64 `xml:"x,attr"`
+				} `xml:"Zoom"`
+			} `xml:",any"`
+		} `xml:"Body>Operation"`
+	}
+
+	_ = xml.Unmarshal([]byte(onvifMovePattern.ReplaceAllString(body, "<${1}tptz:Operation")), &envelope)
+
+	for _, vector := range envelope.Operation.Vector {
+		if vector.XMLName.Local == name {
+			return vector.PanTilt.X, vector.PanTilt.Y, vector.Zoom.X
+		}
+	}
+
+	return 0, 0, 0
+}
+
+func formatONVIFNumber(value float64) string {
+	return strconv.FormatFloat(value, 'f', -1, 64)
+}
+
+func writeONVIFResponse(w http.ResponseWriter, body string) {
+	w.Header().Set("Content-Type", "application/soap+xml; charset=utf-8")
+	_, _ = io.WriteString(w, `<?xml version="1.0" encoding="UTF-8"?>`+
+		`<s:Envelope xmlns:s="http://www.w3.org/2003/05/soap-envelope"><s:Body>`+body+`</s:Body></s:Envelope>`)
+}
+
+func writeONVIFFault(w http.ResponseWriter, reason string) {
+	w.Header().Set("Content-Type", "application/soap+xml; charset=utf-8")
+	w.WriteHeader(http.StatusInternalServerError)
+	_, _ = io.WriteString(w, `<?xml version="1.0" encoding="UTF-8"?>`+
+		`<s:Envelope xmlns:s="http://www.w3.org/2003/05/soap-envelope"><s:Body><s:Fault>`+
+		`<s:Code><s:Value>s:Sender</s:Value></s:Code>`+
+		`<s:Reason><s:Text xml:lang="en">`+reason+`</s:Text></s:Reason>`+
+		`</s:Fault></s:Body></s:Envelope>`)
+}
+
+type onvifFixture struct {
+	*e2eServer
+
+	driver *usecase.ONVIFUsecase
+	stub   *onvifStub
+}
+
+// newONVIFFixture は内蔵のONVIFドライバーを有効にしたCRと、ONVIFカメラのSOAPスタブを起動します。
+func newONVIFFixture(t *testing.T) *onvifFixture {
+	t.Helper()
+
+	repos := newTestRepositories()
+	mux := http.NewServeMux()
+	_, fdUC := registerFDService(t.Context(), mux, repos, nil)
+
+	driver := startONVIFDriver(t.Context(), repos, fdUC, config.ONVIFConfig{
+		Enabled:          true,
+		PollIntervalMs:   20,
+		RequestTimeoutMs: 1000,
+		MoveTimeoutMs:    1000,
+	})
+
+	return &onvifFixture{
+		e2eServer: startE2EServer(t, repos, mux, nil),
+		driver:    driver,
+		stub:      newONVIFStub(t),
+	}
+}
+
+// registerCamera はパン -100〜100度、チルト -50〜50度、ズーム 1〜11倍で、プリセットを8個持つONVIFカメラを
+// 登録し、ドライバーの開始を待ちます。
+func (f *onvifFixture) registerCamera(t *testing.T, parameters map[string]string) string {
+	t.Helper()
+
+	camera := testCamera("onvif-camera", &protov1.CameraCapabilities{
+		SupportsPtz: true,
+		PanMin:      -100,
+		PanMax:      100,
+		TiltMin:     -50,
+		TiltMax:     50,
+		ZoomMin:     1,
+		ZoomMax:     11,
+		PresetCount: 8,
+	})
+	camera.Connection = f.stub.connection(t, parameters)
+
+	cameraID := f.e2eServer.registerCamera(camera)
+
+	require.Eventually(t, func() bool { return f.driver.IsDriving(cameraID) }, 3*time.Second, 10*time.Millisecond)
+
+	return cameraID
+}
+
+func (f *onvifFixture) send(ctx context.Context, t *testing.T, command *protov1.ControlCommand) {
+	t.Helper()
+
+	_, err := f.fd.StreamControlCommands(ctx, connect.NewRequest(&protov1.StreamControlCommandsRequest{
+		Message: &protov1.StreamControlCommandsRequest_Command{Command: command},
+	}))
+	require.NoError(t, err)
+}
+
+// wait は制御コマンドが終了するまで待ち、その状態を返します。
+func (f *onvifFixture) wait(ctx context.Context, t *testing.T, commandID string) *structpb.Struct {
+	t.Helper()
+
+	resp, err := f.call(ctx, t, handlers.FDServiceGetControlCommandStatusProcedure, map[string]any{
+		"command_id": commandID,
+		"wait":       true,
+	})
+	require.NoError(t, err)
+
+	return resp
+}
+
+func TestONVIFDriver_ExecutesAbsoluteMoveAndReportsStatus(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithTimeout(t.Context(), 5*time.Second)
+	defer cancel()
+
+	fixture := newONVIFFixture(t)
+	cameraID := fixture.registerCamera(t, nil)
+
+	fixture.send(ctx, t, &protov1.ControlCommand{
+		CommandId: "onvif-absolute-1",
+		CameraId:  cameraID,
+		Type:      protov1.ControlCommandType_CONTROL_COMMAND_TYPE_PTZ_ABSOLUTE,
+		PtzParameters: &protov1.PTZParameters{
+			Pan: 50, Tilt: -25, Zoom: 6, PanSpeed: 0.5, TiltSpeed: 0.5, ZoomSpeed: 0.25,
+		},
+	})
+
+	status := fixture.wait(ctx, t, "onvif-absolute-1")
+	require.Equal(t, string(infrastructure.ControlCommandStateCompleted), status.GetFields()["state"].GetStringValue())
+
+	resulting := status.GetFields()["result"].GetStructValue().GetFields()["resulting_ptz"].GetStructValue().GetFields()
+	require.InDelta(t, 50, resulting["pan"].GetNumberValue(), 0.01)
+	require.InDelta(t, -25, resulting["tilt"].GetNumberValue(), 0.01)
+	require.InDelta(t, 6, resulting["zoom"].GetNumberValue(), 0.01)
+
+	// プロファイルはメディアサービスから取得し、角度は ONVIF の汎用空間に正規化して送る
+	_, ok := fixture.stub.lastCall("GetProfiles")
+	require.True(t, ok)
+
+	body, ok := fixture.stub.lastCall("AbsoluteMove")
+	require.True(t, ok)
+	require.Contains(t, body, "<tptz:ProfileToken>"+onvifStubProfile+"</tptz:ProfileToken>")
+	require.Contains(t, body, `<tptz:Position><tt:PanTilt x="0.5" y="-0.5"/><tt:Zoom x="0.5"/></tptz:Position>`)
+	require.Contains(t, body, `<tptz:Speed><tt:PanTilt x="0.5" y="0.5"/><tt:Zoom x="0.25"/></tptz:Speed>`)
+
+	// GetStatus の結果はFDが報告した状態と同じようにカメラの状態とPTZになる
+	require.Eventually(t, func() bool {
+		state := fixture.repos.fd.GetCameraState(cameraID)
+
+		return state != nil && !state.GetIsMoving() && state.GetStatus() == protov1.CameraStatus_CAMERA_STATUS_ONLINE
+	}, 3*time.Second, 10*time.Millisecond)
+
+	state := fixture.repos.fd.GetCameraState(cameraID)
+	require.False(t, state.GetHasError())
+	require.InDelta(t, 50, state.GetCurrentPtz().GetPan(), 0.01)
+	require.InDelta(t, 50, fixture.repos.camera.GetCamera(cameraID).GetCurrentPtz().GetPan(), 0.01)
+}
+
+func TestONVIFDriver_TranslatesRelativeContinuousAndPresetCommands(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithTimeout(t.Context(), 5*time.Second)
+	defer cancel()
+
+	fixture := newONVIFFixture(t)
+	cameraID := fixture.registerCamera(t, map[string]string{
+		infrastructure.ConnectionParameterONVIFProfileToken: "profile-sub",
+	})
+
+	steps := []struct {
+		command  *protov1.ControlCommand
+		action   string
+		contains []string
+	}{
+		{
+			command: &protov1.ControlCommand{
+				CommandId:     "onvif-relative-1",
+				Type:          protov1.ControlCommandType_CONTROL_COMMAND_TYPE_PTZ_RELATIVE,
+				PtzParameters: &protov1.PTZParameters{Pan: 20, Tilt: -10, Zoom: 1},
+			},
+			action: "RelativeMove",
+			contains: []string{
+				`<tptz:Translation><tt:PanTilt x="0.2" y="-0.2"/><tt:Zoom x="0.1"/></tptz:Translation>`,
+			},
+		},
+		{
+			command: &protov1.ControlCommand{
+				CommandId:     "onvif-continuous-1",
+				Type:          protov1.ControlCommandType_CONTROL_COMMAND_TYPE_PTZ_CONTINUOUS,
+				PtzParameters: &protov1.PTZParameters{PanSpeed: -0.5, TiltSpeed: 0.25},
+				TimeoutMs:     1500,
+			},
+			action: "ContinuousMove",
+			contains: []string{
+				`<tptz:Velocity><tt:PanTilt x="-0.5" y="0.25"/><tt:Zoom x="0"/></tptz:Velocity>`,
+				`<tptz:Timeout>PT1.5S</tptz:Timeout>`,
+			},
+		},
+		{
+			command: &protov1.ControlCommand{
+				CommandId: "onvif-stop-1",
+				Type:      protov1.ControlCommandType_CONTROL_COMMAND_TYPE_PTZ_STOP,
+			},
+			action:   "Stop",
+			contains: []string{`<tptz:PanTilt>true</tptz:PanTilt><tptz:Zoom>true</tptz:Zoom>`},
+		},
+		{
+			command: &protov1.ControlCommand{
+				CommandId:    "onvif-preset-set-1",
+				Type:         protov1.ControlCommandType_CONTROL_COMMAND_TYPE_PRESET_SET,
+				PresetNumber: 4,
+			},
+			action:   "SetPreset",
+			contains: []string{`<tptz:PresetToken>4</tptz:PresetToken>`},
+		},
+		{
+			command: &protov1.ControlCommand{
+				CommandId:    "onvif-preset-goto-1",
+				Type:         protov1.ControlCommandType_CONTROL_COMMAND_TYPE_PRESET_GOTO,
+				PresetNumber: 4,
+			},
+			action:   "GotoPreset",
+			contains: []string{`<tptz:PresetToken>4</tptz:PresetToken>`},
+		},
+	}
+
+	for _, step := range steps {
+		step.command.CameraId = cameraID
+		fixture.send(ctx, t, step.command)
+
+		status := fixture.wait(ctx, t, step.command.GetCommandId())
+		require.Equal(t,
+			string(infrastructure.ControlCommandStateCompleted), status.GetFields()["state"].GetStringValue(),
+			step.command.GetCommandId(),
+		)
+
+		body, ok := fixture.stub.lastCall(step.action)
+		require.True(t, ok, step.action)
+		require.Contains(t, body, "<tptz:ProfileToken>profile-sub</tptz:ProfileToken>")
+
+		for _, fragment := range step.contains {
+			require.Contains(t, body, fragment)
+		}
+	}
+
+	// 接続情報でプロファイルを指定した場合はメディアサービスに問い合わせない
+	_, ok := fixture.stub.lastCall("GetProfiles")
+	require.False(t, ok)
+}
+
+func TestONVIFDriver_ReportsFaultsAndStopsWhenUnregistered(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithTimeout(t.Context(), 5*time.Second)
+	defer cancel()
+
+	fixture := newONVIFFixture(t)
+	cameraID := fixture.registerCamera(t, nil)
+	fixture.stub.fault("AbsoluteMove", "Requested position out of bounds")
+
+	fixture.send(ctx, t, &protov1.ControlCommand{
+		CommandId:     "onvif-fault-1",
+		CameraId:      cameraID,
+		Type:          protov1.ControlCommandType_CONTROL_COMMAND_TYPE_PTZ_ABSOLUTE,
+		PtzParameters: &protov1.PTZParameters{Pan: 10, Tilt: 0, Zoom: 1},
+	})
+
+	status := fixture.wait(ctx, t, "onvif-fault-1")
+	require.Equal(t, string(infrastructure.ControlCommandStateFailed), status.GetFields()["state"].GetStringValue())
+	require.Contains(t,
+		status.GetFields()["result"].GetStructValue().GetFields()["error_message"].GetStringValue(),
+		"Requested position out of bounds",
+	)
+
+	// フォーカスはPTZサービスで扱えないため失敗として報告する
+	fixture.send(ctx, t, &protov1.ControlCommand{
+		CommandId:  "onvif-focus-1",
+		CameraId:   cameraID,
+		Type:       protov1.ControlCommandType_CONTROL_COMMAND_TYPE_FOCUS,
+		FocusValue: 0.5,
+	})
+
+	status = fixture.wait(ctx, t, "onvif-focus-1")
+	require.Equal(t, string(infrastructure.ControlCommandStateFailed), status.GetFields()["state"].GetStringValue())
+	require.Contains(t,
+		status.GetFields()["result"].GetStructValue().GetFields()["error_message"].GetStringValue(),
+		"not supported",
+	)
+
+	require.True(t, fixture.repos.camera.UnregisterCamera(cameraID))
+	require.Eventually(t, func() bool { return !fixture.driver.IsDriving(cameraID) }, 3*time.Second, 10*time.Millisecond)
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"connectrpc.com/connect"
+	"github.com/stretchr/testify/require"
+
+	protov1 "github.com/anyfld/vistra-operation-control-room/gen/proto/v1"
+	"github.com/anyfld/vistra-operation-control-room/pkg/transport/infrastructure"
+)
+
+type patternMatchingFixture struct {
+	*e2eServer
+}
+
+func newPatternMatchingFixture(t *testing.T, metadata map[string]string) *patternMatchingFixture {
+	t.Helper()
+
+	camera := testCamera("pattern-camera", nil)
+	camera.Metadata = metadata
+
+	return &patternMatchingFixture{e2eServer: newE2EServer(t, camera)}
+}
+
+func (f *patternMatchingFixture) start(ctx context.Context, t *testing.T) string {
+	t.Helper()
+
+	resp, err := f.fd.StartPatternMatching(ctx, connect.NewRequest(&protov1.StartPatternMatchingRequest{
+		CameraId: f.cameraID,
+		TargetSubjects: []*protov1.Subject{
+			{Id: "red-marker", Type: infrastructure.SubjectTypeColorBlob, Label: "#ff0000"},
+		},
+		IntervalMs: 50,
+	}))
+	require.NoError(t, err)
+	require.True(t, resp.Msg.GetSuccess())
+
+	return resp.Msg.GetSessionId()
+}
+
+// keepPushingFrames はストリームの購読が確立するまでの取りこぼしを避けるため、フレームを送り続けます。
+func (f *patternMatchingFixture) keepPushingFrames(ctx context.Context, t *testing.T, sessionID string) {
+	t.Helper()
+
+	frame := encodeFixture(t, protov1.ImageFormat_IMAGE_FORMAT_PNG, newFixtureFrame())
+	url := f.url + "/pattern-matching/sessions/" + sessionID + "/frames"
+
+	go func() {
+		ticker := time.NewTicker(20 * time.Millisecond)
+		defer ticker.Stop()
+
+		for {
+			req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(frame))
+			if err != nil {
+				return
+			}
+
+			req.Header.Set("Content-Type", "image/png")
+
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				return
+			}
+
+			_ = resp.Body.Close()
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+func requireRedMarker(t *testing.T, stream *connect.ServerStreamForClient[protov1.StreamPatternMatchResultsResponse]) {
+	t.Helper()
+
+	require.True(t, stream.Receive(), "stream closed: %v", stream.Err())
+
+	detected := stream.Msg().GetDetectedSubjects()
+	require.Len(t, detected, 1)
+	require.Equal(t, "red-marker", detected[0].GetSubject().GetId())
+	require.InDelta(t, 200.0/fixtureWidth, detected[0].GetDetectedBox().GetX(), 0.02)
+}
+
+// drainUntilClosed は配信済みの結果を読み捨て、ストリームが正常に終了したことを確認します。
+func drainUntilClosed(t *testing.T, stream *connect.ServerStreamForClient[protov1.StreamPatternMatchResultsResponse]) {
+	t.Helper()
+
+	for stream.Receive() {
+		require.NotEmpty(t, stream.Msg().GetSessionId())
+	}
+
+	require.NoError(t, stream.Err())
+}
+
+func TestPatternMatching_PushedFramesFanOutUntilStopped(t *testing.T) {
+	t.Parallel()
+
+	fixture := newPatternMatchingFixture(t, nil)
+
+	ctx, cancel := context.WithTimeout(t.Context(), 5*time.Second)
+	defer cancel()
+
+	sessionID := fixture.start(ctx, t)
+	request := &protov1.StreamPatternMatchResultsRequest{SessionId: sessionID}
+
+	first, err := fixture.fd.StreamPatternMatchResults(ctx, connect.NewRequest(request))
+	require.NoError(t, err)
+
+	second, err := fixture.fd.StreamPatternMatchResults(ctx, connect.NewRequest(request))
+	require.NoError(t, err)
+
+	fixture.keepPushingFrames(ctx, t, sessionID)
+
+	requireRedMarker(t, first)
+	requireRedMarker(t, second)
+	require.Equal(t, fixture.cameraID, first.Msg().GetCameraId())
+
+	stopResp, err := fixture.fd.StopPatternMatching(ctx, connect.NewRequest(&protov1.StopPatternMatchingRequest{
+		SessionId: sessionID,
+	}))
+	require.NoError(t, err)
+	require.True(t, stopResp.Msg.GetSuccess())
+
+	// 停止すると両方のストリームが正常に終了する
+	drainUntilClosed(t, first)
+	drainUntilClosed(t, second)
+}
+
+func TestPatternMatching_PullsFramesFromSnapshotURL(t *testing.T) {
+	t.Parallel()
+
+	frame := encodeFixture(t, protov1.ImageFormat_IMAGE_FORMAT_PNG, newFixtureFrame())
+	snapshots := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		writer.Header().Set("Content-Type", "image/png")
+		_, _ = writer.Write(frame)
+	}))
+	defer snapshots.Close()
+
+	fixture := newPatternMatchingFixture(t, map[string]string{
+		infrastructure.CameraMetadataSnapshotURL: snapshots.URL + "/snapshot.png",
+	})
+
+	ctx, cancel := context.WithTimeout(t.Context(), 5*time.Second)
+	defer cancel()
+
+	sessionID := fixture.start(ctx, t)
+
+	stream, err := fixture.fd.StreamPatternMatchResults(ctx, connect.NewRequest(
+		&protov1.StreamPatternMatchResultsRequest{SessionId: sessionID},
+	))
+	require.NoError(t, err)
+
+	requireRedMarker(t, stream)
+	requireRedMarker(t, stream)
+}
+
+func TestPatternMatching_ExpiresWhenCameraGoesOffline(t *testing.T) {
+	t.Parallel()
+
+	fixture := newPatternMatchingFixture(t, nil)
+
+	ctx, cancel := context.WithTimeout(t.Context(), 5*time.Second)
+	defer cancel()
+
+	sessionID := fixture.start(ctx, t)
+
+	stream, err := fixture.fd.StreamPatternMatchResults(ctx, connect.NewRequest(
+		&protov1.StreamPatternMatchResultsRequest{SessionId: sessionID},
+	))
+	require.NoError(t, err)
+
+	fixture.keepPushingFrames(ctx, t, sessionID)
+	requireRedMarker(t, stream)
+
+	fixture.repos.camera.UpdateCameraState(fixture.cameraID, nil, protov1.CameraStatus_CAMERA_STATUS_OFFLINE)
+
+	drainUntilClosed(t, stream)
+
+	_, err = fixture.fd.StopPatternMatching(ctx, connect.NewRequest(&protov1.StopPatternMatchingRequest{
+		SessionId: sessionID,
+	}))
+	require.NoError(t, err)
+
+	// オフラインのカメラでは新しいセッションを開始できない
+	_, err = fixture.fd.StartPatternMatching(ctx, connect.NewRequest(&protov1.StartPatternMatchingRequest{
+		CameraId: fixture.cameraID,
+	}))
+	require.Equal(t, connect.CodeFailedPrecondition, connect.CodeOf(err))
+}
+
+func TestPatternMatching_UnknownSession(t *testing.T) {
+	t.Parallel()
+
+	fixture := newPatternMatchingFixture(t, nil)
+
+	ctx, cancel := context.WithTimeout(t.Context(), 5*time.Second)
+	defer cancel()
+
+	stream, err := fixture.fd.StreamPatternMatchResults(ctx, connect.NewRequest(
+		&protov1.StreamPatternMatchResultsRequest{SessionId: "missing"},
+	))
+	require.NoError(t, err)
+	require.False(t, stream.Receive())
+	require.Equal(t, connect.CodeNotFound, connect.CodeOf(stream.Err()))
+
+	req, err := http.NewRequestWithContext(
+		ctx, http.MethodPost, fixture.url+"/pattern-matching/sessions/missing/frames", bytes.NewReader(nil),
+	)
+	require.NoError(t, err)
+
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	require.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
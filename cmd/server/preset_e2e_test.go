@@ -0,0 +1,308 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"connectrpc.com/connect"
+	"github.com/stretchr/testify/require"
+
+	protov1 "github.com/anyfld/vistra-operation-control-room/gen/proto/v1"
+	"github.com/anyfld/vistra-operation-control-room/pkg/transport/handlers"
+	"github.com/anyfld/vistra-operation-control-room/pkg/transport/infrastructure"
+)
+
+type presetFixture struct {
+	*e2eServer
+}
+
+// newPresetFixture はパン ±170度、チルト -30〜90度のカメラを、プリセット数と制限の設定とともに登録します。
+func newPresetFixture(t *testing.T, presetCount uint32, limits *infrastructure.PTZLimitConfig) *presetFixture {
+	t.Helper()
+
+	server := newE2EServer(t, testCamera("preset-camera", &protov1.CameraCapabilities{
+		SupportsPtz: true,
+		PanMin:      -170,
+		PanMax:      170,
+		TiltMin:     -30,
+		TiltMax:     90,
+		PresetCount: presetCount,
+	}))
+	if limits != nil {
+		require.NoError(t, limits.Validate(server.repos.camera.GetCapabilities(server.cameraID)))
+		server.repos.camera.SetPTZLimits(server.cameraID, limits)
+	}
+
+	return &presetFixture{e2eServer: server}
+}
+
+func (f *presetFixture) sendCommand(
+	ctx context.Context,
+	t *testing.T,
+	command *protov1.ControlCommand,
+) *protov1.ControlCommandResult {
+	t.Helper()
+
+	resp, err := f.fd.StreamControlCommands(ctx, connect.NewRequest(&protov1.StreamControlCommandsRequest{
+		Message: &protov1.StreamControlCommandsRequest_Command{Command: command},
+	}))
+	require.NoError(t, err)
+
+	return resp.Msg.GetResult()
+}
+
+// lastCommand はFDとして初期化メッセージを送り、最後に配信された命令を返します。
+func (f *presetFixture) lastCommand(ctx context.Context, t *testing.T) *protov1.ControlCommand {
+	t.Helper()
+
+	resp, err := f.fd.StreamControlCommands(ctx, connect.NewRequest(&protov1.StreamControlCommandsRequest{
+		Message: &protov1.StreamControlCommandsRequest_Init{
+			Init: &protov1.StreamControlCommandsInit{CameraId: f.cameraID},
+		},
+	}))
+	require.NoError(t, err)
+
+	return resp.Msg.GetCommand()
+}
+
+func TestPresetService_CRUD(t *testing.T) {
+	t.Parallel()
+
+	fixture := newPresetFixture(t, 0, nil)
+
+	ctx, cancel := context.WithTimeout(t.Context(), 5*time.Second)
+	defer cancel()
+
+	for _, number := range []float64{3, 1} {
+		resp, err := fixture.call(ctx, t, handlers.PresetServiceSetPresetProcedure, map[string]any{
+			"camera_id": fixture.cameraID,
+			"number":    number,
+			"ptz":       map[string]any{"pan": number * 10, "tilt": 5, "zoom": 2},
+			"focus":     0.5,
+		})
+		require.NoError(t, err)
+		require.Equal(t, fmt.Sprintf("Preset %d", int(number)), resp.GetFields()["name"].GetStringValue())
+	}
+
+	resp, err := fixture.call(ctx, t, handlers.PresetServiceSetPresetProcedure, map[string]any{
+		"camera_id": fixture.cameraID,
+		"number":    1,
+		"name":      "Podium",
+		"ptz":       map[string]any{"pan": 15, "tilt": 5, "zoom": 2},
+	})
+	require.NoError(t, err)
+	require.Equal(t, "Podium", resp.GetFields()["name"].GetStringValue())
+
+	resp, err = fixture.call(ctx, t, handlers.PresetServiceGetPresetProcedure, map[string]any{
+		"camera_id": fixture.cameraID,
+		"number":    1,
+	})
+	require.NoError(t, err)
+	require.InDelta(t, 15, resp.GetFields()["ptz"].GetStructValue().GetFields()["pan"].GetNumberValue(), 0.01)
+
+	resp, err = fixture.call(ctx, t, handlers.PresetServiceListPresetsProcedure, map[string]any{
+		"camera_id": fixture.cameraID,
+	})
+	require.NoError(t, err)
+
+	presets := resp.GetFields()["presets"].GetListValue().GetValues()
+	require.Len(t, presets, 2)
+	require.InDelta(t, 1, presets[0].GetStructValue().GetFields()["number"].GetNumberValue(), 0)
+	require.InDelta(t, 3, presets[1].GetStructValue().GetFields()["number"].GetNumberValue(), 0)
+
+	_, err = fixture.call(ctx, t, handlers.PresetServiceDeletePresetProcedure, map[string]any{
+		"camera_id": fixture.cameraID,
+		"number":    3,
+	})
+	require.NoError(t, err)
+
+	_, err = fixture.call(ctx, t, handlers.PresetServiceGetPresetProcedure, map[string]any{
+		"camera_id": fixture.cameraID,
+		"number":    3,
+	})
+	require.Equal(t, connect.CodeNotFound, connect.CodeOf(err))
+}
+
+func TestPresetService_RejectsInvalidRequests(t *testing.T) {
+	t.Parallel()
+
+	fixture := newPresetFixture(t, 8, nil)
+
+	ctx, cancel := context.WithTimeout(t.Context(), 5*time.Second)
+	defer cancel()
+
+	// 番号はカメラが報告したプリセット数まで
+	_, err := fixture.call(ctx, t, handlers.PresetServiceSetPresetProcedure, map[string]any{
+		"camera_id": fixture.cameraID,
+		"number":    9,
+		"ptz":       map[string]any{"pan": 10},
+	})
+	require.Equal(t, connect.CodeInvalidArgument, connect.CodeOf(err))
+
+	// PTZを省略した場合、カメラの現在のPTZが必要
+	_, err = fixture.call(ctx, t, handlers.PresetServiceSetPresetProcedure, map[string]any{
+		"camera_id": fixture.cameraID,
+		"number":    1,
+	})
+	require.Equal(t, connect.CodeInvalidArgument, connect.CodeOf(err))
+
+	_, err = fixture.call(ctx, t, handlers.PresetServiceListPresetsProcedure, map[string]any{
+		"camera_id": "unknown-camera",
+	})
+	require.Equal(t, connect.CodeNotFound, connect.CodeOf(err))
+}
+
+func TestPresetGoto_ResolvesToAbsoluteMoveWithoutHardwarePresets(t *testing.T) {
+	t.Parallel()
+
+	fixture := newPresetFixture(t, 0, nil)
+	fixture.repos.camera.UpdateCameraState(
+		fixture.cameraID,
+		&protov1.PTZParameters{Pan: 42, Tilt: -7, Zoom: 3},
+		protov1.CameraStatus_CAMERA_STATUS_ONLINE,
+	)
+
+	ctx, cancel := context.WithTimeout(t.Context(), 5*time.Second)
+	defer cancel()
+
+	// PTZを指定しない PRESET_SET はカメラの現在のPTZを保存する
+	result := fixture.sendCommand(ctx, t, &protov1.ControlCommand{
+		CameraId:     fixture.cameraID,
+		Type:         protov1.ControlCommandType_CONTROL_COMMAND_TYPE_PRESET_SET,
+		PresetNumber: 2,
+		FocusValue:   0.75,
+	})
+	require.True(t, result.GetSuccess())
+
+	preset := fixture.repos.presets.GetPreset(fixture.cameraID, 2)
+	require.NotNil(t, preset)
+	require.InDelta(t, 42, preset.PTZ.GetPan(), 0.01)
+	require.InDelta(t, 0.75, preset.Focus, 0.01)
+
+	result = fixture.sendCommand(ctx, t, &protov1.ControlCommand{
+		CameraId:     fixture.cameraID,
+		Type:         protov1.ControlCommandType_CONTROL_COMMAND_TYPE_PRESET_GOTO,
+		PresetNumber: 2,
+	})
+	// FDへ配信した命令の結果はFDの報告を待つ
+	require.Nil(t, result)
+
+	command := fixture.lastCommand(ctx, t)
+	require.Equal(t, protov1.ControlCommandType_CONTROL_COMMAND_TYPE_PTZ_ABSOLUTE, command.GetType())
+	require.InDelta(t, 42, command.GetPtzParameters().GetPan(), 0.01)
+	require.InDelta(t, -7, command.GetPtzParameters().GetTilt(), 0.01)
+	require.InDelta(t, 0.75, command.GetFocusValue(), 0.01)
+
+	// 存在しないプリセットへの移動は失敗する
+	result = fixture.sendCommand(ctx, t, &protov1.ControlCommand{
+		CameraId:     fixture.cameraID,
+		Type:         protov1.ControlCommandType_CONTROL_COMMAND_TYPE_PRESET_GOTO,
+		PresetNumber: 5,
+	})
+	require.False(t, result.GetSuccess())
+}
+
+func TestPresetGoto_ForwardsToHardwarePresets(t *testing.T) {
+	t.Parallel()
+
+	fixture := newPresetFixture(t, 16, nil)
+
+	ctx, cancel := context.WithTimeout(t.Context(), 5*time.Second)
+	defer cancel()
+
+	result := fixture.sendCommand(ctx, t, &protov1.ControlCommand{
+		CameraId:      fixture.cameraID,
+		Type:          protov1.ControlCommandType_CONTROL_COMMAND_TYPE_PRESET_SET,
+		PresetNumber:  4,
+		PtzParameters: &protov1.PTZParameters{Pan: 12, Tilt: 3, Zoom: 1},
+	})
+	require.Nil(t, result)
+	require.Equal(t, protov1.ControlCommandType_CONTROL_COMMAND_TYPE_PRESET_SET, fixture.lastCommand(ctx, t).GetType())
+
+	result = fixture.sendCommand(ctx, t, &protov1.ControlCommand{
+		CameraId:     fixture.cameraID,
+		Type:         protov1.ControlCommandType_CONTROL_COMMAND_TYPE_PRESET_GOTO,
+		PresetNumber: 4,
+	})
+	require.Nil(t, result)
+
+	command := fixture.lastCommand(ctx, t)
+	require.Equal(t, protov1.ControlCommandType_CONTROL_COMMAND_TYPE_PRESET_GOTO, command.GetType())
+	require.Equal(t, uint32(4), command.GetPresetNumber())
+}
+
+func TestPresetGoto_HardwarePresetsGoThroughQueueAndLimits(t *testing.T) {
+	t.Parallel()
+
+	fixture := newPresetFixture(t, 16, &infrastructure.PTZLimitConfig{
+		Pan:         &infrastructure.LimitRange{Min: -20, Max: 20},
+		Tilt:        nil,
+		Zoom:        nil,
+		NoGoZones:   nil,
+		Action:      infrastructure.PTZLimitActionReject,
+		UpdatedAtMs: 0,
+	})
+
+	ctx, cancel := context.WithTimeout(t.Context(), 5*time.Second)
+	defer cancel()
+
+	for number, pan := range map[uint32]float32{1: 10, 2: 40} {
+		fixture.repos.presets.SavePreset(&infrastructure.Preset{
+			CameraID:    fixture.cameraID,
+			Number:      number,
+			Name:        "",
+			PTZ:         &protov1.PTZParameters{Pan: pan, Tilt: 0, Zoom: 1},
+			Focus:       0,
+			UpdatedAtMs: 0,
+		})
+	}
+
+	_, ok := fixture.repos.ptz.EnqueueCinematicCommand(fixture.cameraID, &protov1.CinematographyInstruction{
+		CameraId:      fixture.cameraID,
+		PtzParameters: &protov1.PTZParameters{Pan: 0, Tilt: 0, Zoom: 1},
+	})
+	require.True(t, ok)
+
+	// ハードウェアのプリセットの呼び出しもPTZ枠のタスクとしてシネマティック命令を中断する
+	result := fixture.sendCommand(ctx, t, &protov1.ControlCommand{
+		CommandId:    "cmd-preset-hw-goto",
+		CameraId:     fixture.cameraID,
+		Type:         protov1.ControlCommandType_CONTROL_COMMAND_TYPE_PRESET_GOTO,
+		PresetNumber: 1,
+	})
+	require.Nil(t, result)
+
+	command := fixture.lastCommand(ctx, t)
+	require.Equal(t, protov1.ControlCommandType_CONTROL_COMMAND_TYPE_PRESET_GOTO, command.GetType())
+	require.Equal(t, "cmd-preset-hw-goto", command.GetCommandId())
+
+	queue := fixture.repos.ptz.GetQueueStatus(fixture.cameraID)
+	require.Equal(t, uint32(1), queue.GetPtzQueueSize())
+	require.Zero(t, queue.GetCinematicQueueSize())
+
+	status := fixture.repos.fd.GetControlCommandStatus("cmd-preset-hw-goto")
+	require.NotNil(t, status)
+	require.Equal(t, infrastructure.ControlCommandStateDispatched, status.State)
+
+	// ソフトリミットの外に保存したプリセットは、FDへ配信せずに拒否する
+	result = fixture.sendCommand(ctx, t, &protov1.ControlCommand{
+		CommandId:    "cmd-preset-hw-outside",
+		CameraId:     fixture.cameraID,
+		Type:         protov1.ControlCommandType_CONTROL_COMMAND_TYPE_PRESET_GOTO,
+		PresetNumber: 2,
+	})
+	require.False(t, result.GetSuccess())
+	require.Contains(t, result.GetErrorMessage(), "soft limit")
+
+	// サーバーに保存していないプリセットは移動先を確認できないため拒否する
+	result = fixture.sendCommand(ctx, t, &protov1.ControlCommand{
+		CommandId:    "cmd-preset-hw-unknown",
+		CameraId:     fixture.cameraID,
+		Type:         protov1.ControlCommandType_CONTROL_COMMAND_TYPE_PRESET_GOTO,
+		PresetNumber: 3,
+	})
+	require.False(t, result.GetSuccess())
+	require.Equal(t, uint32(1), fixture.repos.ptz.GetQueueStatus(fixture.cameraID).GetPtzQueueSize())
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"testing"
+	"time"
+
+	"connectrpc.com/connect"
+	"github.com/stretchr/testify/require"
+
+	protov1 "github.com/anyfld/vistra-operation-control-room/gen/proto/v1"
+	"github.com/anyfld/vistra-operation-control-room/pkg/transport/handlers"
+	"github.com/anyfld/vistra-operation-control-room/pkg/transport/infrastructure"
+)
+
+// pollAfter はFDとして通し番号を指定して init を送り、応答と応答の通し番号を返します。
+func (f *controlCommandFixture) pollAfter(
+	ctx context.Context,
+	t *testing.T,
+	afterSequence uint64,
+) (*protov1.StreamControlCommandsResponse, uint64, error) {
+	t.Helper()
+
+	req := connect.NewRequest(&protov1.StreamControlCommandsRequest{
+		Message: &protov1.StreamControlCommandsRequest_Init{
+			Init: &protov1.StreamControlCommandsInit{CameraId: f.cameraID},
+		},
+	})
+	req.Header().Set(handlers.PTZAfterSequenceHeader, strconv.FormatUint(afterSequence, 10))
+
+	resp, err := f.fd.StreamControlCommands(ctx, req)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	sequence, err := strconv.ParseUint(resp.Header().Get(handlers.PTZSequenceHeader), 10, 64)
+	require.NoError(t, err)
+
+	return resp.Msg, sequence, nil
+}
+
+func TestPTZSequence_UnaryPollingResumesWithoutLoss(t *testing.T) {
+	t.Parallel()
+
+	fixture := newControlCommandFixture(t)
+
+	ctx, cancel := context.WithTimeout(t.Context(), 5*time.Second)
+	defer cancel()
+
+	// ポーリングの合間に配信された制御コマンドも、通し番号を指定すれば順にすべて受け取れる
+	for i := 1; i <= 3; i++ {
+		fixture.sendFocus(ctx, t, fmt.Sprintf("cmd-seq-%d", i))
+	}
+
+	resp, sequence, err := fixture.pollAfter(ctx, t, 0)
+	require.NoError(t, err)
+	require.Equal(t, "cmd-seq-3", resp.GetCommand().GetCommandId())
+	require.Equal(t, uint64(3), sequence)
+
+	for i := uint64(2); i <= 3; i++ {
+		resp, sequence, err = fixture.pollAfter(ctx, t, i-1)
+		require.NoError(t, err)
+		require.Equal(t, fmt.Sprintf("cmd-seq-%d", i), resp.GetCommand().GetCommandId())
+		require.Equal(t, i, sequence)
+	}
+
+	// 新しいイベントがない場合は同じ通し番号を返す
+	resp, sequence, err = fixture.pollAfter(ctx, t, 3)
+	require.NoError(t, err)
+	require.Nil(t, resp.GetCommand())
+	require.Equal(t, uint64(3), sequence)
+
+	// 結果も通し番号付きで配信される
+	require.NoError(t, fixture.report(ctx, &protov1.ControlCommandResult{CommandId: "cmd-seq-2", Success: true}))
+
+	resp, sequence, err = fixture.pollAfter(ctx, t, 3)
+	require.NoError(t, err)
+	require.Equal(t, "cmd-seq-2", resp.GetResult().GetCommandId())
+	require.Equal(t, uint64(4), sequence)
+
+	// 最後に配信した制御コマンドは cmd-seq-3 のままで、別の制御コマンドの結果で置き換わらない
+	resp, _, err = fixture.pollAfter(ctx, t, 0)
+	require.NoError(t, err)
+	require.Equal(t, "cmd-seq-3", resp.GetCommand().GetCommandId())
+	require.Nil(t, resp.GetResult())
+}
+
+func TestPTZSequence_ReportsReplayGap(t *testing.T) {
+	t.Parallel()
+
+	fixture := newControlCommandFixture(t)
+
+	ctx, cancel := context.WithTimeout(t.Context(), 10*time.Second)
+	defer cancel()
+
+	for i := range 300 {
+		fixture.sendFocus(ctx, t, fmt.Sprintf("cmd-gap-%d", i))
+	}
+
+	// 再送用のバッファに残っていない通し番号からは再開できない
+	_, _, err := fixture.pollAfter(ctx, t, 1)
+	require.Equal(t, connect.CodeOutOfRange, connect.CodeOf(err))
+
+	_, _, err = fixture.pollAfter(ctx, t, 1000)
+	require.Equal(t, connect.CodeOutOfRange, connect.CodeOf(err))
+
+	resp, sequence, err := fixture.pollAfter(ctx, t, 299)
+	require.NoError(t, err)
+	require.Equal(t, "cmd-gap-299", resp.GetCommand().GetCommandId())
+	require.Equal(t, uint64(300), sequence)
+}
+
+func TestPTZSequence_StreamResumesFromSequence(t *testing.T) {
+	t.Parallel()
+
+	fixture := newControlCommandFixture(t)
+
+	ctx, cancel := context.WithTimeout(t.Context(), 5*time.Second)
+	defer cancel()
+
+	for i := 1; i <= 3; i++ {
+		fixture.sendFocus(ctx, t, fmt.Sprintf("cmd-resume-%d", i))
+	}
+
+	stream := openControlCommandStream(ctx, fixture.url)
+	stream.RequestHeader().Set(handlers.PTZAfterSequenceHeader, "1")
+
+	require.NoError(t, stream.Send(&protov1.StreamControlCommandsRequest{
+		Message: &protov1.StreamControlCommandsRequest_Init{
+			Init: &protov1.StreamControlCommandsInit{CameraId: fixture.cameraID},
+		},
+	}))
+
+	connected, err := stream.Receive()
+	require.NoError(t, err)
+	require.True(t, connected.GetStatus().GetConnected())
+	require.Equal(t, "3", stream.ResponseHeader().Get(handlers.PTZSequenceHeader))
+
+	fixture.sendFocus(ctx, t, "cmd-resume-4")
+
+	for i := 2; i <= 4; i++ {
+		resp, err := stream.Receive()
+		require.NoError(t, err)
+		require.Equal(t, fmt.Sprintf("cmd-resume-%d", i), resp.GetCommand().GetCommandId())
+	}
+
+	require.NoError(t, stream.CloseRequest())
+	require.NoError(t, stream.CloseResponse())
+}
+
+func TestPTZSequence_SlowSubscriberOverflowIsReported(t *testing.T) {
+	t.Parallel()
+
+	fixture := newControlCommandFixture(t)
+
+	ctx, cancel := context.WithTimeout(t.Context(), 10*time.Second)
+	defer cancel()
+
+	subscription, err := fixture.repos.fd.SubscribePTZCommands(fixture.cameraID, 0)
+	require.NoError(t, err)
+
+	// 受信しない購読者のバッファを溢れさせる
+	for i := 1; i <= 300; i++ {
+		fixture.sendFocus(ctx, t, fmt.Sprintf("cmd-overflow-%d", i))
+	}
+
+	require.True(t, subscription.Overflowed())
+
+	var last *infrastructure.PTZCommandEvent
+
+	for event := range subscription.Events() {
+		if last != nil {
+			require.Equal(t, last.Sequence+1, event.Sequence)
+		}
+
+		last = event
+	}
+
+	require.NotNil(t, last)
+	require.Less(t, last.Sequence, uint64(300))
+
+	// 最後に受信した通し番号から購読し直すと、残りを欠けることなく受け取れる
+	resumed, err := fixture.repos.fd.SubscribePTZCommands(fixture.cameraID, last.Sequence)
+	require.NoError(t, err)
+	t.Cleanup(func() { fixture.repos.fd.UnsubscribePTZCommands(resumed) })
+
+	for sequence := last.Sequence + 1; sequence <= 300; sequence++ {
+		event := <-resumed.Events()
+		require.Equal(t, sequence, event.Sequence)
+		require.Equal(t, fmt.Sprintf("cmd-overflow-%d", sequence), event.Command.GetCommandId())
+	}
+
+	require.False(t, resumed.Overflowed())
+}
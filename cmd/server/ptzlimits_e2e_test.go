@@ -0,0 +1,427 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"connectrpc.com/connect"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/types/known/structpb"
+
+	protov1 "github.com/anyfld/vistra-operation-control-room/gen/proto/v1"
+	"github.com/anyfld/vistra-operation-control-room/pkg/transport/handlers"
+	"github.com/anyfld/vistra-operation-control-room/pkg/transport/infrastructure"
+)
+
+type ptzLimitsFixture struct {
+	*e2eServer
+}
+
+func newPTZLimitsFixture(t *testing.T) *ptzLimitsFixture {
+	t.Helper()
+
+	return &ptzLimitsFixture{e2eServer: newE2EServer(t, nil)}
+}
+
+// registerCamera はパン ±170度、チルト -30〜90度、ズーム 1〜20倍 のカメラを登録し、制限を設定します。
+func (f *ptzLimitsFixture) registerCamera(ctx context.Context, t *testing.T, limits map[string]any) string {
+	t.Helper()
+
+	cameraID := f.e2eServer.registerCamera(testCamera("limited-camera", &protov1.CameraCapabilities{
+		SupportsPtz: true,
+		PanMin:      -170,
+		PanMax:      170,
+		TiltMin:     -30,
+		TiltMax:     90,
+		ZoomMin:     1,
+		ZoomMax:     20,
+	}))
+	limits["camera_id"] = cameraID
+
+	_, err := f.setLimits(ctx, t, limits)
+	require.NoError(t, err)
+
+	return cameraID
+}
+
+func (f *ptzLimitsFixture) setLimits(
+	ctx context.Context,
+	t *testing.T,
+	limits map[string]any,
+) (*structpb.Struct, error) {
+	t.Helper()
+
+	return f.call(ctx, t, handlers.PTZLimitsServiceSetPTZLimitsProcedure, limits)
+}
+
+func (f *ptzLimitsFixture) sendControlCommand(
+	ctx context.Context,
+	t *testing.T,
+	command *protov1.ControlCommand,
+) *protov1.StreamControlCommandsResponse {
+	t.Helper()
+
+	resp, err := f.fd.StreamControlCommands(ctx, connect.NewRequest(&protov1.StreamControlCommandsRequest{
+		Message: &protov1.StreamControlCommandsRequest_Command{Command: command},
+	}))
+	require.NoError(t, err)
+
+	return resp.Msg
+}
+
+func TestPTZLimits_ClipsToSoftLimitsAndNoGoZones(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithTimeout(t.Context(), 5*time.Second)
+	defer cancel()
+
+	fixture := newPTZLimitsFixture(t)
+	cameraID := fixture.registerCamera(ctx, t, map[string]any{
+		"pan":  map[string]any{"min": -90, "max": 90},
+		"zoom": map[string]any{"min": 1, "max": 8},
+		"no_go_zones": []any{map[string]any{"vertices": []any{
+			map[string]any{"pan": 60, "tilt": -10},
+			map[string]any{"pan": 80, "tilt": -10},
+			map[string]any{"pan": 80, "tilt": 20},
+			map[string]any{"pan": 60, "tilt": 20},
+		}}},
+	})
+
+	// ソフトリミットを超えるPTZ命令は補正して受け付け、理由を返す
+	ptzResp, err := fixture.clients.ptz.SendPTZCommand(ctx, connect.NewRequest(&protov1.SendPTZCommandRequest{
+		CameraId: cameraID,
+		Command: &protov1.PTZCommand{
+			OperationType: protov1.PTZOperationType_PTZ_OPERATION_TYPE_ABSOLUTE_MOVE,
+			Command: &protov1.PTZCommand_AbsoluteMove{AbsoluteMove: &protov1.AbsoluteMoveCommand{
+				Position: &protov1.PTZPosition{X: -120.0 / 170, Y: 0, Z: 1},
+			}},
+		},
+	}))
+	require.NoError(t, err)
+	require.True(t, ptzResp.Msg.GetAccepted())
+	require.Contains(t, ptzResp.Msg.GetErrorMessage(), "pan -120 clipped to soft limit -90")
+	require.Contains(t, ptzResp.Msg.GetErrorMessage(), "zoom 20 clipped to soft limit 8")
+
+	polled, err := fixture.clients.ptz.Polling(ctx, connect.NewRequest(&protov1.PollingRequest{
+		CameraId:   cameraID,
+		CurrentPtz: &protov1.PTZParameters{Pan: 0, Tilt: 0, Zoom: 1},
+	}))
+	require.NoError(t, err)
+
+	position := polled.Msg.GetCurrentCommand().GetPtzCommand().GetAbsoluteMove().GetPosition()
+	require.InDelta(t, -90.0/170, position.GetX(), 0.0001)
+	require.InDelta(t, 7.0/19, position.GetZ(), 0.0001)
+
+	// 禁止領域の内側を狙う制御コマンドは、最も近い境界の外側へ補正する
+	absolute := fixture.sendControlCommand(ctx, t, &protov1.ControlCommand{
+		CommandId:     "cmd-limits-zone",
+		CameraId:      cameraID,
+		Type:          protov1.ControlCommandType_CONTROL_COMMAND_TYPE_PTZ_ABSOLUTE,
+		PtzParameters: &protov1.PTZParameters{Pan: 65, Tilt: 5, Zoom: 2},
+	})
+	require.Nil(t, absolute.GetResult())
+	require.Contains(t, absolute.GetStatus().GetMessage(), "inside no-go zone 0")
+	require.InDelta(t, 59.99, absolute.GetCommand().GetPtzParameters().GetPan(), 0.001)
+	require.InDelta(t, 5, absolute.GetCommand().GetPtzParameters().GetTilt(), 0.001)
+
+	// 停止までの連続移動は、禁止領域に入る時刻で止まるようタイムアウトを設定する（45度/秒 で 60度 まで）。
+	// 実行中の移動が推定の位置を動かさないよう、静止しているカメラで確認する
+	stillCameraID := fixture.registerCamera(ctx, t, map[string]any{
+		"no_go_zones": []any{map[string]any{"vertices": []any{
+			map[string]any{"pan": 60, "tilt": -10},
+			map[string]any{"pan": 80, "tilt": -10},
+			map[string]any{"pan": 80, "tilt": 20},
+			map[string]any{"pan": 60, "tilt": 20},
+		}}},
+	})
+
+	_, err = fixture.clients.ptz.Polling(ctx, connect.NewRequest(&protov1.PollingRequest{
+		CameraId:   stillCameraID,
+		CurrentPtz: &protov1.PTZParameters{Pan: 0, Tilt: 0, Zoom: 1},
+	}))
+	require.NoError(t, err)
+
+	continuous := fixture.sendControlCommand(ctx, t, &protov1.ControlCommand{
+		CommandId:     "cmd-limits-continuous",
+		CameraId:      stillCameraID,
+		Type:          protov1.ControlCommandType_CONTROL_COMMAND_TYPE_PTZ_CONTINUOUS,
+		PtzParameters: &protov1.PTZParameters{PanSpeed: 0.5},
+	})
+	require.Nil(t, continuous.GetResult())
+	require.Contains(t, continuous.GetStatus().GetMessage(), "enters no-go zone 0")
+	require.InDelta(t, 1333, continuous.GetCommand().GetTimeoutMs(), 1)
+
+	// 制限の内側の命令はそのまま配信する
+	inside := fixture.sendControlCommand(ctx, t, &protov1.ControlCommand{
+		CommandId:     "cmd-limits-inside",
+		CameraId:      cameraID,
+		Type:          protov1.ControlCommandType_CONTROL_COMMAND_TYPE_PTZ_ABSOLUTE,
+		PtzParameters: &protov1.PTZParameters{Pan: 30, Tilt: 5, Zoom: 2},
+	})
+	require.Equal(t, "command pending", inside.GetStatus().GetMessage())
+	require.InDelta(t, 30, inside.GetCommand().GetPtzParameters().GetPan(), 0.001)
+}
+
+func TestPTZLimits_RejectsOffendingMovesWithReason(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithTimeout(t.Context(), 5*time.Second)
+	defer cancel()
+
+	fixture := newPTZLimitsFixture(t)
+	cameraID := fixture.registerCamera(ctx, t, map[string]any{
+		"tilt": map[string]any{"min": -10, "max": 45},
+		"no_go_zones": []any{map[string]any{"vertices": []any{
+			map[string]any{"pan": -100, "tilt": -30},
+			map[string]any{"pan": -80, "tilt": -30},
+			map[string]any{"pan": -80, "tilt": 90},
+			map[string]any{"pan": -100, "tilt": 90},
+		}}},
+		"action": infrastructure.PTZLimitActionReject,
+	})
+
+	// 現在の位置が分からない間は、相対移動を確認できないため拒否する
+	relative := fixture.sendControlCommand(ctx, t, &protov1.ControlCommand{
+		CommandId:     "cmd-limits-relative",
+		CameraId:      cameraID,
+		Type:          protov1.ControlCommandType_CONTROL_COMMAND_TYPE_PTZ_RELATIVE,
+		PtzParameters: &protov1.PTZParameters{Pan: 10},
+	})
+	require.False(t, relative.GetResult().GetSuccess())
+	require.Contains(t, relative.GetResult().GetErrorMessage(), "current position is unknown")
+
+	_, err := fixture.clients.ptz.Polling(ctx, connect.NewRequest(&protov1.PollingRequest{
+		CameraId:   cameraID,
+		CurrentPtz: &protov1.PTZParameters{Pan: -50, Tilt: 0, Zoom: 1},
+	}))
+	require.NoError(t, err)
+
+	// 禁止領域に入る相対移動は、FDへ配信せずに失敗として終了する
+	zone := fixture.sendControlCommand(ctx, t, &protov1.ControlCommand{
+		CommandId:     "cmd-limits-zone-reject",
+		CameraId:      cameraID,
+		Type:          protov1.ControlCommandType_CONTROL_COMMAND_TYPE_PTZ_RELATIVE,
+		PtzParameters: &protov1.PTZParameters{Pan: -40},
+	})
+	require.False(t, zone.GetResult().GetSuccess())
+	require.Contains(t, zone.GetResult().GetErrorMessage(), "inside no-go zone 0")
+
+	queue, err := fixture.clients.ptz.GetQueueStatus(ctx, connect.NewRequest(&protov1.GetQueueStatusRequest{
+		CameraId: cameraID,
+	}))
+	require.NoError(t, err)
+	require.Zero(t, queue.Msg.GetCameraQueues()[0].GetPtzQueueSize())
+
+	// PTZService の命令とシネマティック命令も同じ制限で拒否する
+	ptzResp, err := fixture.clients.ptz.SendPTZCommand(ctx, connect.NewRequest(&protov1.SendPTZCommandRequest{
+		CameraId: cameraID,
+		Command: &protov1.PTZCommand{
+			OperationType: protov1.PTZOperationType_PTZ_OPERATION_TYPE_ABSOLUTE_MOVE,
+			Command: &protov1.PTZCommand_AbsoluteMove{AbsoluteMove: &protov1.AbsoluteMoveCommand{
+				Position: &protov1.PTZPosition{X: 0, Y: 1, Z: 0},
+			}},
+		},
+	}))
+	require.NoError(t, err)
+	require.False(t, ptzResp.Msg.GetAccepted())
+	require.Contains(t, ptzResp.Msg.GetErrorMessage(), "tilt 90 clipped to soft limit 45")
+
+	instruction := &protov1.CinematographyInstruction{
+		CameraId:      cameraID,
+		PtzParameters: &protov1.PTZParameters{Pan: -90, Tilt: 0, Zoom: 1},
+	}
+
+	cinematic, err := fixture.clients.ptz.SendCinematicCommand(ctx, connect.NewRequest(
+		&protov1.SendCinematicCommandRequest{CameraId: cameraID, Command: instruction},
+	))
+	require.NoError(t, err)
+	require.False(t, cinematic.Msg.GetAccepted())
+	require.Contains(t, cinematic.Msg.GetErrorMessage(), "inside no-go zone 0")
+
+	_, err = fixture.clients.cr.SendCinematographyInstruction(ctx, connect.NewRequest(
+		&protov1.SendCinematographyInstructionRequest{Instruction: instruction},
+	))
+	require.Equal(t, connect.CodeFailedPrecondition, connect.CodeOf(err))
+
+	// 停止は常に配信する
+	stop := fixture.sendControlCommand(ctx, t, &protov1.ControlCommand{
+		CommandId: "cmd-limits-stop",
+		CameraId:  cameraID,
+		Type:      protov1.ControlCommandType_CONTROL_COMMAND_TYPE_PTZ_STOP,
+	})
+	require.Nil(t, stop.GetResult())
+}
+
+func TestPTZLimits_RejectsInvalidConfigWhenSet(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithTimeout(t.Context(), 5*time.Second)
+	defer cancel()
+
+	fixture := newPTZLimitsFixture(t)
+	cameraID := fixture.registerCamera(ctx, t, map[string]any{})
+
+	for name, limits := range map[string]map[string]any{
+		"inverted range":       {"pan": map[string]any{"min": 30, "max": -30}},
+		"outside camera range": {"tilt": map[string]any{"min": 100, "max": 120}},
+		"too few vertices": {"no_go_zones": []any{map[string]any{"vertices": []any{
+			map[string]any{"pan": 0, "tilt": 0},
+			map[string]any{"pan": 10, "tilt": 0},
+		}}}},
+		"unknown action": {"action": "ignore"},
+	} {
+		limits["camera_id"] = cameraID
+
+		_, err := fixture.setLimits(ctx, t, limits)
+		require.Equal(t, connect.CodeInvalidArgument, connect.CodeOf(err), name)
+	}
+
+	_, err := fixture.setLimits(ctx, t, map[string]any{"camera_id": "unknown-camera"})
+	require.Equal(t, connect.CodeNotFound, connect.CodeOf(err))
+}
+
+func TestPTZLimits_ChecksExpandedCinematicTargets(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithTimeout(t.Context(), 5*time.Second)
+	defer cancel()
+
+	fixture := newPTZLimitsFixture(t)
+	zone := []any{map[string]any{"vertices": []any{
+		map[string]any{"pan": -100, "tilt": -30},
+		map[string]any{"pan": -55, "tilt": -30},
+		map[string]any{"pan": -55, "tilt": 90},
+		map[string]any{"pan": -100, "tilt": 90},
+	}}}
+	clipCameraID := fixture.registerCamera(ctx, t, map[string]any{"no_go_zones": zone})
+	rejectCameraID := fixture.registerCamera(ctx, t, map[string]any{
+		"no_go_zones": zone,
+		"action":      infrastructure.PTZLimitActionReject,
+	})
+
+	// 画面の中央の被写体を撮るショットは、現在の位置（パン -80度）の禁止領域の内側へ展開される
+	for _, cameraID := range []string{clipCameraID, rejectCameraID} {
+		_, err := fixture.clients.ptz.Polling(ctx, connect.NewRequest(&protov1.PollingRequest{
+			CameraId:   cameraID,
+			CurrentPtz: &protov1.PTZParameters{Pan: -80, Tilt: 0, Zoom: 1},
+		}))
+		require.NoError(t, err)
+	}
+
+	instruction := func(cameraID string) *protov1.CinematographyInstruction {
+		return &protov1.CinematographyInstruction{
+			CameraId: cameraID,
+			ShotType: protov1.ShotType_SHOT_TYPE_MEDIUM,
+			Subjects: []*protov1.Subject{{
+				Id:          "speaker",
+				BoundingBox: &protov1.BoundingBox{X: 0.45, Y: 0.2, Width: 0.1, Height: 0.6},
+			}},
+		}
+	}
+
+	clipped, err := fixture.clients.ptz.SendCinematicCommand(ctx, connect.NewRequest(
+		&protov1.SendCinematicCommandRequest{CameraId: clipCameraID, Command: instruction(clipCameraID)},
+	))
+	require.NoError(t, err)
+	require.True(t, clipped.Msg.GetAccepted())
+	require.Contains(t, clipped.Msg.GetErrorMessage(), "inside no-go zone 0")
+
+	// 展開して補正した目標を ptz_parameters に設定し、FDはその位置へ移動する
+	polled, err := fixture.clients.ptz.Polling(ctx, connect.NewRequest(&protov1.PollingRequest{
+		CameraId:   clipCameraID,
+		CurrentPtz: &protov1.PTZParameters{Pan: -80, Tilt: 0, Zoom: 1},
+	}))
+	require.NoError(t, err)
+
+	target := polled.Msg.GetCurrentCommand().GetCinematicCommand().GetPtzParameters()
+	require.NotNil(t, target)
+	require.InDelta(t, -100.01, target.GetPan(), 0.001)
+
+	_, err = fixture.clients.cr.SendCinematographyInstruction(ctx, connect.NewRequest(
+		&protov1.SendCinematographyInstructionRequest{Instruction: instruction(rejectCameraID)},
+	))
+	require.Equal(t, connect.CodeFailedPrecondition, connect.CodeOf(err))
+}
+
+func TestPTZLimits_StopsMovesBeforeCrossingNoGoZones(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithTimeout(t.Context(), 5*time.Second)
+	defer cancel()
+
+	fixture := newPTZLimitsFixture(t)
+	zone := []any{map[string]any{"vertices": []any{
+		map[string]any{"pan": 20, "tilt": -10},
+		map[string]any{"pan": 40, "tilt": -10},
+		map[string]any{"pan": 40, "tilt": 10},
+		map[string]any{"pan": 20, "tilt": 10},
+	}}}
+	clipCameraID := fixture.registerCamera(ctx, t, map[string]any{"no_go_zones": zone})
+	rejectCameraID := fixture.registerCamera(ctx, t, map[string]any{
+		"no_go_zones": zone,
+		"action":      infrastructure.PTZLimitActionReject,
+	})
+
+	for _, cameraID := range []string{clipCameraID, rejectCameraID} {
+		_, err := fixture.clients.ptz.Polling(ctx, connect.NewRequest(&protov1.PollingRequest{
+			CameraId:   cameraID,
+			CurrentPtz: &protov1.PTZParameters{Pan: 0, Tilt: 0, Zoom: 1},
+		}))
+		require.NoError(t, err)
+	}
+
+	// 目標は禁止領域の外でも、経路が領域を通る絶対移動は領域に入る手前で止める
+	clipped := fixture.sendControlCommand(ctx, t, &protov1.ControlCommand{
+		CommandId:     "cmd-limits-path",
+		CameraId:      clipCameraID,
+		Type:          protov1.ControlCommandType_CONTROL_COMMAND_TYPE_PTZ_ABSOLUTE,
+		PtzParameters: &protov1.PTZParameters{Pan: 60, Tilt: 0, Zoom: 1},
+	})
+	require.Nil(t, clipped.GetResult())
+	require.Contains(t, clipped.GetStatus().GetMessage(), "crosses no-go zone 0")
+	require.InDelta(t, 19.99, clipped.GetCommand().GetPtzParameters().GetPan(), 0.001)
+
+	rejected := fixture.sendControlCommand(ctx, t, &protov1.ControlCommand{
+		CommandId:     "cmd-limits-path-reject",
+		CameraId:      rejectCameraID,
+		Type:          protov1.ControlCommandType_CONTROL_COMMAND_TYPE_PTZ_RELATIVE,
+		PtzParameters: &protov1.PTZParameters{Pan: 60},
+	})
+	require.False(t, rejected.GetResult().GetSuccess())
+	require.Contains(t, rejected.GetResult().GetErrorMessage(), "crosses no-go zone 0")
+
+	// 領域を避ける経路はそのまま配信する
+	around := fixture.sendControlCommand(ctx, t, &protov1.ControlCommand{
+		CommandId:     "cmd-limits-path-around",
+		CameraId:      rejectCameraID,
+		Type:          protov1.ControlCommandType_CONTROL_COMMAND_TYPE_PTZ_ABSOLUTE,
+		PtzParameters: &protov1.PTZParameters{Pan: 60, Tilt: 30, Zoom: 1},
+	})
+	require.Nil(t, around.GetResult())
+}
+
+func TestPTZLimits_RejectsMovesWithoutPTZParameters(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithTimeout(t.Context(), 5*time.Second)
+	defer cancel()
+
+	fixture := newPTZLimitsFixture(t)
+	// 省略した位置 (パン 0度) を補正する制限で、補正の前に拒否されることを確認する
+	cameraID := fixture.registerCamera(ctx, t, map[string]any{"pan": map[string]any{"min": 10, "max": 90}})
+
+	for _, commandType := range []protov1.ControlCommandType{
+		protov1.ControlCommandType_CONTROL_COMMAND_TYPE_PTZ_ABSOLUTE,
+		protov1.ControlCommandType_CONTROL_COMMAND_TYPE_PTZ_RELATIVE,
+	} {
+		_, err := fixture.fd.StreamControlCommands(ctx, connect.NewRequest(&protov1.StreamControlCommandsRequest{
+			Message: &protov1.StreamControlCommandsRequest_Command{Command: &protov1.ControlCommand{
+				CommandId: "cmd-limits-no-params",
+				CameraId:  cameraID,
+				Type:      commandType,
+			}},
+		}))
+		require.Equal(t, connect.CodeInvalidArgument, connect.CodeOf(err), commandType.String())
+	}
+}
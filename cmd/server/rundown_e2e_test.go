@@ -0,0 +1,296 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"connectrpc.com/connect"
+	"github.com/stretchr/testify/require"
+
+	protov1 "github.com/anyfld/vistra-operation-control-room/gen/proto/v1"
+)
+
+type rundownTestState struct {
+	RundownID  string `json:"rundown_id"`
+	Status     string `json:"status"`
+	CurrentCue *struct {
+		ID string `json:"id"`
+	} `json:"current_cue"`
+	NextCue *struct {
+		ID string `json:"id"`
+	} `json:"next_cue"`
+	LastResults []struct {
+		Action  string `json:"action"`
+		Success bool   `json:"success"`
+		Error   string `json:"error"`
+		TaskID  string `json:"task_id"`
+	} `json:"last_results"`
+}
+
+func newRundownTestServer(t *testing.T) (*httptest.Server, crTestClients) {
+	t.Helper()
+
+	repos := newTestRepositories()
+
+	return startCRTestServer(t, setupHandlers(t.Context(), repos, nil))
+}
+
+func setupRundownFixtures(ctx context.Context, t *testing.T, clients crTestClients) string {
+	t.Helper()
+
+	registerResp, err := clients.camera.RegisterCamera(ctx, connect.NewRequest(&protov1.RegisterCameraRequest{
+		Name:       "rundown-camera",
+		Mode:       protov1.CameraMode_CAMERA_MODE_AUTONOMOUS,
+		MasterMfId: "mf-rundown-1",
+	}))
+	require.NoError(t, err)
+
+	_, err = clients.md.ConfigureVideoOutput(ctx, connect.NewRequest(&protov1.ConfigureVideoOutputRequest{
+		Config: &protov1.VideoOutputConfig{Id: "program", Name: "Program"},
+	}))
+	require.NoError(t, err)
+
+	return registerResp.Msg.GetCamera().GetId()
+}
+
+func uploadRundown(ctx context.Context, t *testing.T, serverURL string, script string) string {
+	t.Helper()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, serverURL+"/rundowns", strings.NewReader(script))
+	require.NoError(t, err)
+
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+
+	var rundown struct {
+		ID string `json:"id"`
+	}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&rundown))
+
+	return rundown.ID
+}
+
+func postRundown(ctx context.Context, t *testing.T, url string) (int, *rundownTestState) {
+	t.Helper()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, nil)
+	require.NoError(t, err)
+
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+
+	if resp.StatusCode != http.StatusOK {
+		return resp.StatusCode, nil
+	}
+
+	state := new(rundownTestState)
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(state))
+
+	return resp.StatusCode, state
+}
+
+func TestRundown_ManualStepExecutesCuesAndStreamsState(t *testing.T) {
+	t.Parallel()
+
+	server, clients := newRundownTestServer(t)
+	defer server.Close()
+
+	ctx, cancel := context.WithTimeout(t.Context(), 5*time.Second)
+	defer cancel()
+
+	cameraID := setupRundownFixtures(ctx, t, clients)
+
+	script := fmt.Sprintf(`
+name: Evening news
+cues:
+  - id: 12
+    label: close-up on speaker, cut program to camera
+    actions:
+      - ptz:
+          camera_id: %[1]s
+          command:
+            operation_type: PTZ_OPERATION_TYPE_ABSOLUTE_MOVE
+            absolute_move:
+              position: {x: 0.3, y: 0.1, z: 2}
+      - switch_source: {output_id: program, new_source_camera_id: %[1]s}
+  - id: 13
+    label: wide shot
+    actions:
+      - cinematography: {camera_id: %[1]s, shot_type: SHOT_TYPE_WIDE}
+`, cameraID)
+
+	rundownID := uploadRundown(ctx, t, server.URL, script)
+
+	streamReq, err := http.NewRequestWithContext(ctx, http.MethodGet, server.URL+"/rundowns/"+rundownID+"/stream", nil)
+	require.NoError(t, err)
+
+	streamResp, err := http.DefaultClient.Do(streamReq)
+	require.NoError(t, err)
+
+	defer func() {
+		_ = streamResp.Body.Close()
+	}()
+
+	require.Equal(t, "text/event-stream", streamResp.Header.Get("Content-Type"))
+
+	events := bufio.NewScanner(streamResp.Body)
+	nextState := func() *rundownTestState {
+		for events.Scan() {
+			if data, ok := strings.CutPrefix(events.Text(), "data: "); ok {
+				state := new(rundownTestState)
+				require.NoError(t, json.Unmarshal([]byte(data), state))
+
+				return state
+			}
+		}
+
+		require.FailNow(t, "stream closed", events.Err())
+
+		return nil
+	}
+
+	initial := nextState()
+	require.Equal(t, "idle", initial.Status)
+	require.Nil(t, initial.CurrentCue)
+	require.Equal(t, "12", initial.NextCue.ID)
+
+	status, _ := postRundown(ctx, t, server.URL+"/rundowns/"+rundownID+"/next")
+	require.Equal(t, http.StatusConflict, status)
+
+	status, _ = postRundown(ctx, t, server.URL+"/rundowns/"+rundownID+"/start?mode=manual")
+	require.Equal(t, http.StatusOK, status)
+	require.Equal(t, "running", nextState().Status)
+
+	status, fired := postRundown(ctx, t, server.URL+"/rundowns/"+rundownID+"/next")
+	require.Equal(t, http.StatusOK, status)
+	require.Equal(t, "12", fired.CurrentCue.ID)
+	require.Equal(t, "13", fired.NextCue.ID)
+	require.Len(t, fired.LastResults, 2)
+	require.True(t, fired.LastResults[0].Success, fired.LastResults[0].Error)
+	require.True(t, fired.LastResults[1].Success, fired.LastResults[1].Error)
+
+	streamed := nextState()
+	require.Equal(t, "12", streamed.CurrentCue.ID)
+	require.Equal(t, "13", streamed.NextCue.ID)
+
+	// キューの操作は既存のPTZキューとMD出力を経由する
+	queueResp, err := clients.ptz.GetQueueStatus(ctx, connect.NewRequest(&protov1.GetQueueStatusRequest{
+		CameraId: cameraID,
+	}))
+	require.NoError(t, err)
+	require.Equal(t, uint32(1), queueResp.Msg.GetCameraQueues()[0].GetPtzQueueSize())
+
+	outputResp, err := clients.md.GetStreamingStatus(ctx, connect.NewRequest(&protov1.GetStreamingStatusRequest{
+		OutputId: "program",
+	}))
+	require.NoError(t, err)
+	require.Equal(t, cameraID, outputResp.Msg.GetOutputs()[0].GetCurrentSourceCameraId())
+
+	status, fired = postRundown(ctx, t, server.URL+"/rundowns/"+rundownID+"/next")
+	require.Equal(t, http.StatusOK, status)
+	require.Equal(t, "finished", fired.Status)
+	require.Nil(t, fired.NextCue)
+	require.True(t, fired.LastResults[0].Success, fired.LastResults[0].Error)
+	require.Equal(t, "cinematography", fired.LastResults[0].Action)
+
+	queueResp, err = clients.ptz.GetQueueStatus(ctx, connect.NewRequest(&protov1.GetQueueStatusRequest{
+		CameraId: cameraID,
+	}))
+	require.NoError(t, err)
+	require.Equal(t, uint32(1), queueResp.Msg.GetCameraQueues()[0].GetCinematicQueueSize())
+}
+
+func TestRundown_TimecodeModeFiresCuesOnSchedule(t *testing.T) {
+	t.Parallel()
+
+	server, clients := newRundownTestServer(t)
+	defer server.Close()
+
+	ctx, cancel := context.WithTimeout(t.Context(), 5*time.Second)
+	defer cancel()
+
+	cameraID := setupRundownFixtures(ctx, t, clients)
+
+	script := fmt.Sprintf(`{
+  "name": "timed",
+  "cues": [
+    {"id": "open", "timecode": "00:00:00", "actions": [
+      {"switch_source": {"output_id": "program", "new_source_camera_id": "%[1]s"}}
+    ]},
+    {"id": "push-in", "timecode_ms": 150, "actions": [
+      {"cinematography": {"camera_id": "%[1]s", "shot_type": "SHOT_TYPE_CLOSE_UP"}}
+    ]}
+  ]
+}`, cameraID)
+
+	rundownID := uploadRundown(ctx, t, server.URL, script)
+
+	status, _ := postRundown(ctx, t, server.URL+"/rundowns/"+rundownID+"/start?mode=timecode")
+	require.Equal(t, http.StatusOK, status)
+
+	require.Eventually(t, func() bool {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, server.URL+"/rundowns/"+rundownID, nil)
+		require.NoError(t, err)
+
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+
+		defer func() {
+			_ = resp.Body.Close()
+		}()
+
+		var detail struct {
+			State rundownTestState `json:"state"`
+		}
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&detail))
+
+		return detail.State.Status == "finished" && detail.State.CurrentCue.ID == "push-in"
+	}, 3*time.Second, 20*time.Millisecond)
+
+	queueResp, err := clients.ptz.GetQueueStatus(ctx, connect.NewRequest(&protov1.GetQueueStatusRequest{
+		CameraId: cameraID,
+	}))
+	require.NoError(t, err)
+	require.Equal(t, uint32(1), queueResp.Msg.GetCameraQueues()[0].GetCinematicQueueSize())
+}
+
+func TestRundown_RejectsInvalidScript(t *testing.T) {
+	t.Parallel()
+
+	server, _ := newRundownTestServer(t)
+	defer server.Close()
+
+	req, err := http.NewRequestWithContext(
+		t.Context(),
+		http.MethodPost,
+		server.URL+"/rundowns",
+		strings.NewReader("cues:\n  - id: 1\n    actions:\n      - {}\n"),
+	)
+	require.NoError(t, err)
+
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
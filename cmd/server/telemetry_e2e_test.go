@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"connectrpc.com/connect"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/types/known/structpb"
+
+	protov1 "github.com/anyfld/vistra-operation-control-room/gen/proto/v1"
+	"github.com/anyfld/vistra-operation-control-room/gen/proto/v1/protov1connect"
+	"github.com/anyfld/vistra-operation-control-room/pkg/transport/handlers"
+)
+
+func queryTelemetry(
+	ctx context.Context,
+	t *testing.T,
+	url string,
+	fields map[string]any,
+) ([]*structpb.Value, error) {
+	t.Helper()
+
+	resp, err := callStruct(ctx, t, url, handlers.TelemetryServiceQueryCameraTelemetryProcedure, fields)
+	if err != nil {
+		return nil, err
+	}
+
+	return resp.GetFields()["samples"].GetListValue().GetValues(), nil
+}
+
+func TestTelemetry_RecordsStateAndPollingAndDownsamples(t *testing.T) {
+	t.Parallel()
+
+	repos := newTestRepositories()
+	server, clients := startCRTestServer(t, setupHandlers(t.Context(), repos, nil))
+	defer server.Close()
+
+	fd := protov1connect.NewFDServiceClient(http.DefaultClient, server.URL)
+
+	ctx, cancel := context.WithTimeout(t.Context(), 5*time.Second)
+	defer cancel()
+
+	registerResp, err := clients.camera.RegisterCamera(ctx, connect.NewRequest(&protov1.RegisterCameraRequest{
+		Name:       "telemetry-camera",
+		Mode:       protov1.CameraMode_CAMERA_MODE_AUTONOMOUS,
+		MasterMfId: "mf-telemetry-1",
+	}))
+	require.NoError(t, err)
+
+	cameraID := registerResp.Msg.GetCamera().GetId()
+
+	_, err = clients.ptz.Polling(ctx, connect.NewRequest(&protov1.PollingRequest{
+		CameraId:     cameraID,
+		DeviceStatus: protov1.DeviceStatus_DEVICE_STATUS_EXECUTING,
+		CameraStatus: protov1.CameraStatus_CAMERA_STATUS_ONLINE,
+		CurrentPtz:   &protov1.PTZParameters{Pan: 1, Tilt: 0, Zoom: 1},
+		TimestampMs:  1000,
+	}))
+	require.NoError(t, err)
+
+	_, err = fd.StreamControlCommands(ctx, connect.NewRequest(&protov1.StreamControlCommandsRequest{
+		Message: &protov1.StreamControlCommandsRequest_State{State: &protov1.CameraState{
+			CameraId:     cameraID,
+			CurrentPtz:   &protov1.PTZParameters{Pan: 2, Tilt: 0, Zoom: 1},
+			IsMoving:     true,
+			CurrentFocus: 0.5,
+		}},
+	}))
+	require.NoError(t, err)
+
+	// 報告しない項目は直前のサンプルから引き継ぐ
+	_, err = clients.ptz.Polling(ctx, connect.NewRequest(&protov1.PollingRequest{
+		CameraId:   cameraID,
+		CurrentPtz: &protov1.PTZParameters{Pan: 3, Tilt: 0, Zoom: 1},
+	}))
+	require.NoError(t, err)
+
+	samples, err := queryTelemetry(ctx, t, server.URL, map[string]any{"camera_id": cameraID})
+	require.NoError(t, err)
+	require.Len(t, samples, 3)
+
+	first := samples[0].GetStructValue().GetFields()
+	require.Equal(t, "polling", first["source"].GetStringValue())
+	require.Equal(t, "DEVICE_STATUS_EXECUTING", first["device_status"].GetStringValue())
+	require.InDelta(t, 1000, first["device_timestamp_ms"].GetNumberValue(), 0)
+
+	second := samples[1].GetStructValue().GetFields()
+	require.Equal(t, "camera_state", second["source"].GetStringValue())
+	require.True(t, second["is_moving"].GetBoolValue())
+	require.Equal(t, "CAMERA_STATUS_ONLINE", second["camera_status"].GetStringValue())
+
+	last := samples[2].GetStructValue().GetFields()
+	require.InDelta(t, 3, last["ptz"].GetStructValue().GetFields()["pan"].GetNumberValue(), 0.001)
+	require.InDelta(t, 0.5, last["focus"].GetNumberValue(), 0.001)
+	require.True(t, last["is_moving"].GetBoolValue())
+	require.Equal(t, "DEVICE_STATUS_EXECUTING", last["device_status"].GetStringValue())
+
+	// 範囲を指定すると、その時刻以降のサンプルだけを返す
+	since := second["timestamp_ms"].GetNumberValue()
+
+	ranged, err := queryTelemetry(ctx, t, server.URL, map[string]any{"camera_id": cameraID, "since_ms": since})
+	require.NoError(t, err)
+	require.GreaterOrEqual(t, len(ranged), 2)
+
+	for _, sample := range ranged {
+		require.GreaterOrEqual(t, sample.GetStructValue().GetFields()["timestamp_ms"].GetNumberValue(), since)
+	}
+
+	// 1点にまとめると平均と、いずれかのサンプルで立っていたフラグを返す
+	downsampled, err := queryTelemetry(ctx, t, server.URL, map[string]any{"camera_id": cameraID, "max_points": 1})
+	require.NoError(t, err)
+	require.Len(t, downsampled, 1)
+
+	merged := downsampled[0].GetStructValue().GetFields()
+	require.InDelta(t, 3, merged["samples"].GetNumberValue(), 0)
+	require.InDelta(t, 2, merged["ptz"].GetStructValue().GetFields()["pan"].GetNumberValue(), 0.001)
+	require.True(t, merged["is_moving"].GetBoolValue())
+
+	_, err = queryTelemetry(ctx, t, server.URL, map[string]any{"since_ms": 0})
+	require.Equal(t, connect.CodeInvalidArgument, connect.CodeOf(err))
+
+	_, err = queryTelemetry(ctx, t, server.URL, map[string]any{"camera_id": cameraID, "since_ms": 10, "until_ms": 5})
+	require.Equal(t, connect.CodeInvalidArgument, connect.CodeOf(err))
+}
+
+func TestTelemetry_RingBufferKeepsNewestSamples(t *testing.T) {
+	t.Parallel()
+
+	repos := newTestRepositories()
+	server, _ := startCRTestServer(t, setupHandlers(t.Context(), repos, nil))
+	defer server.Close()
+
+	ctx, cancel := context.WithTimeout(t.Context(), 5*time.Second)
+	defer cancel()
+
+	const cameraID = "telemetry-ring-camera"
+
+	for i := range 5000 {
+		repos.telemetry.RecordPolling(&protov1.PollingRequest{
+			CameraId:   cameraID,
+			CurrentPtz: &protov1.PTZParameters{Pan: float32(i)},
+		}, int64(i))
+	}
+
+	samples, err := queryTelemetry(ctx, t, server.URL, map[string]any{"camera_id": cameraID})
+	require.NoError(t, err)
+	require.Len(t, samples, 4096)
+	require.InDelta(t, 904, samples[0].GetStructValue().GetFields()["timestamp_ms"].GetNumberValue(), 0)
+	require.InDelta(t, 4999,
+		samples[len(samples)-1].GetStructValue().GetFields()["ptz"].GetStructValue().GetFields()["pan"].GetNumberValue(),
+		0.001)
+
+	// 区間の幅を指定すると、区間ごとに1つのサンプルにまとめる
+	downsampled, err := queryTelemetry(ctx, t, server.URL, map[string]any{
+		"camera_id":   cameraID,
+		"since_ms":    1000,
+		"until_ms":    2000,
+		"interval_ms": 100,
+	})
+	require.NoError(t, err)
+	require.Len(t, downsampled, 10)
+
+	for i, sample := range downsampled {
+		fields := sample.GetStructValue().GetFields()
+		require.InDelta(t, float64(1000+i*100), fields["timestamp_ms"].GetNumberValue(), 0)
+		require.InDelta(t, 100, fields["samples"].GetNumberValue(), 0)
+		require.InDelta(t, float64(1000+i*100)+49.5,
+			fields["ptz"].GetStructValue().GetFields()["pan"].GetNumberValue(), 0.01)
+	}
+}
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"connectrpc.com/connect"
+	"github.com/stretchr/testify/require"
+
+	protov1 "github.com/anyfld/vistra-operation-control-room/gen/proto/v1"
+	"github.com/anyfld/vistra-operation-control-room/pkg/transport/handlers"
+	"github.com/anyfld/vistra-operation-control-room/pkg/transport/infrastructure"
+)
+
+// 固定フレームの赤い矩形の中心 (230/320, 80/180)
+const (
+	redMarkerCenterX = 230.0 / fixtureWidth
+	redMarkerCenterY = 80.0 / fixtureHeight
+)
+
+// startTracking は赤い矩形の追尾を開始し、フレームを送り続けます。
+func (f *patternMatchingFixture) startTracking(
+	ctx context.Context,
+	t *testing.T,
+	options map[string]any,
+) string {
+	t.Helper()
+
+	fields := map[string]any{
+		"camera_id":   f.cameraID,
+		"subject":     map[string]any{"id": "red-marker", "type": infrastructure.SubjectTypeColorBlob, "label": "#ff0000"},
+		"interval_ms": 50,
+	}
+	for key, value := range options {
+		fields[key] = value
+	}
+
+	resp, err := f.call(ctx, t, handlers.FDServiceStartTrackingProcedure, fields)
+	require.NoError(t, err)
+
+	f.keepPushingFrames(ctx, t, resp.GetFields()["session_id"].GetStringValue())
+
+	return resp.GetFields()["tracking_id"].GetStringValue()
+}
+
+func (f *patternMatchingFixture) requireTrackingState(
+	ctx context.Context,
+	t *testing.T,
+	trackingID string,
+	state infrastructure.TrackingState,
+) {
+	t.Helper()
+
+	require.Eventually(t, func() bool {
+		resp, err := f.call(ctx, t, handlers.FDServiceGetTrackingProcedure, map[string]any{
+			"tracking_id": trackingID,
+		})
+
+		return err == nil && resp.GetFields()["state"].GetStringValue() == string(state)
+	}, 3*time.Second, 20*time.Millisecond)
+}
+
+// pollTrackingTask はFDとしてポーリングし、追尾のタスクが実行対象になるまで待ちます。
+func (f *patternMatchingFixture) pollTrackingTask(t *testing.T) *protov1.Task {
+	t.Helper()
+
+	var task *protov1.Task
+
+	require.Eventually(t, func() bool {
+		current, _, _ := f.repos.ptz.ProcessPolling(
+			f.cameraID, "", "", nil,
+			protov1.DeviceStatus_DEVICE_STATUS_EXECUTING, protov1.CameraStatus_CAMERA_STATUS_ONLINE,
+		)
+		task = current
+
+		return current.GetLayer() == protov1.CommandLayer_COMMAND_LAYER_CINEMATIC && current.GetPtzCommand() != nil
+	}, 3*time.Second, 20*time.Millisecond)
+
+	return task
+}
+
+func TestTracking_ContinuousMoveTowardsSubject(t *testing.T) {
+	t.Parallel()
+
+	fixture := newPatternMatchingFixture(t, nil)
+
+	ctx, cancel := context.WithTimeout(t.Context(), 5*time.Second)
+	defer cancel()
+
+	trackingID := fixture.startTracking(ctx, t, nil)
+	fixture.requireTrackingState(ctx, t, trackingID, infrastructure.TrackingStateTracking)
+
+	// 被写体は画面の右上寄りにあるため、右上へ向かう
+	velocity := fixture.pollTrackingTask(t).GetPtzCommand().GetContinuousMove().GetVelocity()
+	require.Positive(t, velocity.GetPanVelocity())
+	require.Positive(t, velocity.GetTiltVelocity())
+
+	// 追尾の命令は最新の1件に置き換えられ、キューに溜まらない
+	time.Sleep(200 * time.Millisecond)
+	require.LessOrEqual(t, fixture.repos.ptz.GetQueueStatus(fixture.cameraID).GetCinematicQueueSize(), uint32(1))
+
+	resp, err := fixture.call(ctx, t, handlers.FDServiceStopTrackingProcedure, map[string]any{
+		"tracking_id": trackingID,
+	})
+	require.NoError(t, err)
+	require.True(t, resp.GetFields()["success"].GetBoolValue())
+
+	// 停止すると連続移動を止める命令を送る
+	require.Eventually(t, func() bool {
+		current, _, _ := fixture.repos.ptz.ProcessPolling(
+			fixture.cameraID, "", "", nil,
+			protov1.DeviceStatus_DEVICE_STATUS_EXECUTING, protov1.CameraStatus_CAMERA_STATUS_ONLINE,
+		)
+		velocity := current.GetPtzCommand().GetContinuousMove().GetVelocity()
+
+		return velocity != nil && velocity.GetPanVelocity() == 0 && velocity.GetTiltVelocity() == 0
+	}, 3*time.Second, 20*time.Millisecond)
+
+	_, err = fixture.call(ctx, t, handlers.FDServiceGetTrackingProcedure, map[string]any{
+		"tracking_id": trackingID,
+	})
+	require.Equal(t, connect.CodeNotFound, connect.CodeOf(err))
+}
+
+func TestTracking_AbsoluteMoveFromReportedPTZ(t *testing.T) {
+	t.Parallel()
+
+	fixture := newPatternMatchingFixture(t, nil)
+	fixture.repos.camera.UpdateCameraState(
+		fixture.cameraID,
+		&protov1.PTZParameters{Pan: 0, Tilt: 0, Zoom: 1},
+		protov1.CameraStatus_CAMERA_STATUS_ONLINE,
+	)
+
+	ctx, cancel := context.WithTimeout(t.Context(), 5*time.Second)
+	defer cancel()
+
+	fixture.startTracking(ctx, t, map[string]any{"mode": string(infrastructure.TrackingModeAbsolute)})
+
+	move := fixture.pollTrackingTask(t).GetPtzCommand().GetAbsoluteMove()
+	require.NotNil(t, move)
+	require.Positive(t, move.GetPosition().GetX())
+	require.Positive(t, move.GetPosition().GetY())
+	require.Less(t, move.GetPosition().GetX(), float32(0.1))
+}
+
+func TestTracking_HoldsInsideDeadZone(t *testing.T) {
+	t.Parallel()
+
+	fixture := newPatternMatchingFixture(t, nil)
+
+	ctx, cancel := context.WithTimeout(t.Context(), 5*time.Second)
+	defer cancel()
+
+	trackingID := fixture.startTracking(ctx, t, map[string]any{
+		"target_x":  redMarkerCenterX + 0.02,
+		"target_y":  redMarkerCenterY - 0.02,
+		"dead_zone": 0.05,
+	})
+	fixture.requireTrackingState(ctx, t, trackingID, infrastructure.TrackingStateHolding)
+
+	time.Sleep(200 * time.Millisecond)
+	require.Zero(t, fixture.repos.ptz.GetQueueStatus(fixture.cameraID).GetCinematicQueueSize())
+}
+
+func TestTracking_ManualPTZOverridesTracking(t *testing.T) {
+	t.Parallel()
+
+	fixture := newPatternMatchingFixture(t, nil)
+
+	ctx, cancel := context.WithTimeout(t.Context(), 5*time.Second)
+	defer cancel()
+
+	trackingID := fixture.startTracking(ctx, t, map[string]any{"manual_hold_ms": 200})
+	fixture.pollTrackingTask(t)
+
+	manualID, accepted := fixture.repos.ptz.EnqueuePTZCommand(fixture.cameraID, &protov1.PTZCommand{
+		OperationType: protov1.PTZOperationType_PTZ_OPERATION_TYPE_RELATIVE_MOVE,
+		Command: &protov1.PTZCommand_RelativeMove{
+			RelativeMove: &protov1.RelativeMoveCommand{Translation: &protov1.PTZTranslation{PanDelta: -10}},
+		},
+	})
+	require.True(t, accepted)
+
+	// 手動の命令が実行中の追尾を中断する
+	current, _, interrupt := fixture.repos.ptz.ProcessPolling(
+		fixture.cameraID, "", "", nil,
+		protov1.DeviceStatus_DEVICE_STATUS_EXECUTING, protov1.CameraStatus_CAMERA_STATUS_ONLINE,
+	)
+	require.Equal(t, manualID, current.GetTaskId())
+	require.True(t, interrupt)
+	fixture.requireTrackingState(ctx, t, trackingID, infrastructure.TrackingStateOverridden)
+
+	// 手動の命令が完了するまで追尾の命令は出ない
+	time.Sleep(400 * time.Millisecond)
+	require.Zero(t, fixture.repos.ptz.GetQueueStatus(fixture.cameraID).GetCinematicQueueSize())
+
+	fixture.repos.ptz.ProcessPolling(
+		fixture.cameraID, manualID, "", nil,
+		protov1.DeviceStatus_DEVICE_STATUS_IDLE, protov1.CameraStatus_CAMERA_STATUS_ONLINE,
+	)
+
+	fixture.requireTrackingState(ctx, t, trackingID, infrastructure.TrackingStateTracking)
+	fixture.pollTrackingTask(t)
+}
+
+func TestTracking_StopsCameraWhenSubjectIsLost(t *testing.T) {
+	t.Parallel()
+
+	fixture := newPatternMatchingFixture(t, nil)
+
+	ctx, cancel := context.WithTimeout(t.Context(), 5*time.Second)
+	defer cancel()
+
+	pushCtx, stopPushing := context.WithCancel(ctx)
+	trackingID := fixture.startTracking(pushCtx, t, map[string]any{"lost_timeout_ms": 300})
+	fixture.pollTrackingTask(t)
+
+	stopPushing()
+	fixture.requireTrackingState(ctx, t, trackingID, infrastructure.TrackingStateLost)
+
+	velocity := fixture.pollTrackingTask(t).GetPtzCommand().GetContinuousMove().GetVelocity()
+	require.Zero(t, velocity.GetPanVelocity())
+	require.Zero(t, velocity.GetTiltVelocity())
+}
+
+func TestTracking_RejectsInvalidRequests(t *testing.T) {
+	t.Parallel()
+
+	fixture := newPatternMatchingFixture(t, nil)
+
+	ctx, cancel := context.WithTimeout(t.Context(), 5*time.Second)
+	defer cancel()
+
+	_, err := fixture.call(ctx, t, handlers.FDServiceStartTrackingProcedure, map[string]any{
+		"camera_id": fixture.cameraID,
+	})
+	require.Equal(t, connect.CodeInvalidArgument, connect.CodeOf(err))
+
+	_, err = fixture.call(ctx, t, handlers.FDServiceStartTrackingProcedure, map[string]any{
+		"camera_id": fixture.cameraID,
+		"subject":   map[string]any{"id": "red-marker"},
+		"mode":      "teleport",
+	})
+	require.Equal(t, connect.CodeInvalidArgument, connect.CodeOf(err))
+
+	fixture.startTracking(ctx, t, nil)
+
+	// 1台のカメラで追尾できる被写体は1つだけ
+	_, err = fixture.call(ctx, t, handlers.FDServiceStartTrackingProcedure, map[string]any{
+		"camera_id": fixture.cameraID,
+		"subject":   map[string]any{"id": "red-marker"},
+	})
+	require.Equal(t, connect.CodeAlreadyExists, connect.CodeOf(err))
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"connectrpc.com/connect"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/types/known/structpb"
+
+	protov1 "github.com/anyfld/vistra-operation-control-room/gen/proto/v1"
+	"github.com/anyfld/vistra-operation-control-room/pkg/transport/handlers"
+	"github.com/anyfld/vistra-operation-control-room/pkg/transport/infrastructure"
+)
+
+type trajectoryFixture struct {
+	*keyframeFixture
+}
+
+// newTrajectoryFixture はパンの最高速度が 100度/秒で、ソフトリミットが -60〜60度のカメラを登録します。
+func newTrajectoryFixture(t *testing.T) *trajectoryFixture {
+	t.Helper()
+
+	camera := testCamera("trajectory-camera", &protov1.CameraCapabilities{
+		SupportsPtz: true,
+		PanMin:      -100,
+		PanMax:      100,
+		TiltMin:     -50,
+		TiltMax:     50,
+		ZoomMin:     1,
+		ZoomMax:     11,
+	})
+	camera.Metadata = map[string]string{
+		infrastructure.CameraMetadataPanSpeed:  "100",
+		infrastructure.CameraMetadataTiltSpeed: "50",
+		infrastructure.CameraMetadataZoomSpeed: "5",
+	}
+
+	fixture := newKeyframeFixture(t, camera)
+	fixture.repos.camera.SetPTZLimits(fixture.cameraID, &infrastructure.PTZLimitConfig{
+		Pan:         &infrastructure.LimitRange{Min: -60, Max: 60},
+		Tilt:        nil,
+		Zoom:        nil,
+		NoGoZones:   nil,
+		Action:      infrastructure.PTZLimitActionClip,
+		UpdatedAtMs: 0,
+	})
+
+	return &trajectoryFixture{keyframeFixture: fixture}
+}
+
+// plan は軌道のキーフレームを返します。
+func (f *trajectoryFixture) plan(ctx context.Context, t *testing.T, fields map[string]any) []map[string]float64 {
+	t.Helper()
+
+	resp, err := f.call(ctx, t, handlers.FDServicePlanPTZTrajectoryProcedure, fields)
+	require.NoError(t, err)
+
+	return keyframesOf(resp)
+}
+
+func keyframesOf(resp *structpb.Struct) []map[string]float64 {
+	values := resp.GetFields()["trajectory"].GetStructValue().GetFields()["keyframes"].GetListValue().GetValues()
+	keyframes := make([]map[string]float64, 0, len(values))
+
+	for _, value := range values {
+		keyframe := make(map[string]float64)
+		for key, field := range value.GetStructValue().GetFields() {
+			keyframe[key] = field.GetNumberValue()
+		}
+
+		keyframes = append(keyframes, keyframe)
+	}
+
+	return keyframes
+}
+
+func TestPTZTrajectory_PlansEasedKeyframes(t *testing.T) {
+	t.Parallel()
+
+	fixture := newTrajectoryFixture(t)
+
+	ctx, cancel := context.WithTimeout(t.Context(), 5*time.Second)
+	defer cancel()
+
+	from := map[string]any{"pan": 0, "tilt": 0, "zoom": 1}
+	to := map[string]any{"pan": 40, "tilt": 10, "zoom": 3}
+
+	// S字の曲線は中央で最も速く、両端でゆっくり動く
+	keyframes := fixture.plan(ctx, t, map[string]any{"from": from, "to": to, "duration_ms": 1000})
+	require.Len(t, keyframes, 10)
+
+	for i, keyframe := range keyframes {
+		require.InDelta(t, float64((i+1)*100), keyframe["offset_ms"], 0)
+
+		if i > 0 {
+			require.Greater(t, keyframe["pan"], keyframes[i-1]["pan"])
+		}
+	}
+
+	require.Less(t, keyframes[0]["pan_speed"], keyframes[4]["pan_speed"])
+	require.Less(t, keyframes[9]["pan_speed"], keyframes[5]["pan_speed"])
+	require.InDelta(t, keyframes[0]["pan_speed"], keyframes[9]["pan_speed"], 1e-3)
+	require.InDelta(t, 40, keyframes[9]["pan"], 1e-6)
+	require.InDelta(t, 10, keyframes[9]["tilt"], 1e-6)
+	require.InDelta(t, 3, keyframes[9]["zoom"], 1e-6)
+
+	// 一定の速度では 40度を 1秒で移動するため、最高速度 100度/秒の 0.4 倍になる
+	keyframes = fixture.plan(ctx, t, map[string]any{"from": from, "to": to, "duration_ms": 1000, "curve": "linear"})
+	for _, keyframe := range keyframes {
+		require.InDelta(t, 0.4, keyframe["pan_speed"], 1e-3)
+	}
+
+	keyframes = fixture.plan(ctx, t, map[string]any{"from": from, "to": to, "duration_ms": 1000, "curve": "ease_in"})
+	require.Less(t, keyframes[0]["pan_speed"], keyframes[9]["pan_speed"])
+
+	// y が 1 を超える制御点は目標を行き過ぎて戻る
+	keyframes = fixture.plan(ctx, t, map[string]any{
+		"from": from, "to": to, "duration_ms": 1000, "curve": "bezier",
+		"bezier": map[string]any{"x1": 0.3, "y1": 0, "x2": 0.5, "y2": 1.6},
+	})
+	overshoot := 0.0
+	for _, keyframe := range keyframes {
+		overshoot = max(overshoot, keyframe["pan"])
+	}
+
+	require.Greater(t, overshoot, 40.0)
+	require.InDelta(t, 40, keyframes[len(keyframes)-1]["pan"], 1e-6)
+
+	for name, fields := range map[string]map[string]any{
+		"unknown curve":  {"from": from, "to": to, "duration_ms": 1000, "curve": "bounce"},
+		"missing bezier": {"from": from, "to": to, "duration_ms": 1000, "curve": "bezier"},
+		"bezier x": {
+			"from": from, "to": to, "duration_ms": 1000, "curve": "bezier", "bezier": map[string]any{"x1": 1.5},
+		},
+		"unknown mode":     {"from": from, "to": to, "duration_ms": 1000, "mode": "continuous"},
+		"missing duration": {"from": from, "to": to},
+		"missing target":   {"from": from, "duration_ms": 1000},
+		"soft limit":       {"from": from, "to": map[string]any{"pan": 80}, "duration_ms": 2000},
+	} {
+		_, err := fixture.call(ctx, t, handlers.FDServicePlanPTZTrajectoryProcedure, fields)
+		require.Equal(t, connect.CodeInvalidArgument, connect.CodeOf(err), name)
+	}
+
+	// 最高速度を超える場合は必要な時間を示す
+	_, err := fixture.call(ctx, t, handlers.FDServicePlanPTZTrajectoryProcedure, map[string]any{
+		"from": from, "to": map[string]any{"pan": 50}, "duration_ms": 200, "curve": "linear",
+	})
+	require.Equal(t, connect.CodeInvalidArgument, connect.CodeOf(err))
+	require.Contains(t, err.Error(), "duration_ms must be at least 500")
+
+	// 開始位置を省略する場合はカメラのPTZが必要
+	_, err = fixture.call(ctx, t, handlers.FDServicePlanPTZTrajectoryProcedure, map[string]any{
+		"to": to, "duration_ms": 1000,
+	})
+	require.Equal(t, connect.CodeFailedPrecondition, connect.CodeOf(err))
+}
+
+func TestPTZTrajectory_DispatchesKeyframesAndCompletes(t *testing.T) {
+	t.Parallel()
+
+	fixture := newTrajectoryFixture(t)
+
+	ctx, cancel := context.WithTimeout(t.Context(), 5*time.Second)
+	defer cancel()
+
+	_, err := fixture.fd.StreamControlCommands(ctx, connect.NewRequest(&protov1.StreamControlCommandsRequest{
+		Message: &protov1.StreamControlCommandsRequest_State{State: &protov1.CameraState{
+			CameraId:   fixture.cameraID,
+			CurrentPtz: &protov1.PTZParameters{Pan: 10, Tilt: 0, Zoom: 1},
+			Status:     protov1.CameraStatus_CAMERA_STATUS_ONLINE,
+		}},
+	}))
+	require.NoError(t, err)
+
+	// 開始位置を省略し、報告されたPTZから相対移動で動かす
+	resp, err := fixture.call(ctx, t, handlers.FDServiceEnqueuePTZTrajectoryProcedure, map[string]any{
+		"to": map[string]any{"pan": 30}, "duration_ms": 400, "curve": "linear", "mode": "relative",
+		"layer": "COMMAND_LAYER_PTZ",
+	})
+	require.NoError(t, err)
+
+	taskID := resp.GetFields()["task_id"].GetStringValue()
+	require.Len(t, keyframesOf(resp), 4)
+
+	task := fixture.startTask(t, "")
+	require.Equal(t, taskID, task.GetTaskId())
+	require.Equal(t, protov1.CommandLayer_COMMAND_LAYER_PTZ, task.GetLayer())
+	require.Nil(t, task.GetPtzCommand())
+
+	startedAt := time.Now()
+
+	for i := range 4 {
+		command := fixture.nextCommand(t, commandOfTask(taskID))
+		require.Equal(t, taskID+"-"+string(rune('1'+i)), command.GetCommandId())
+		require.Equal(t, protov1.ControlCommandType_CONTROL_COMMAND_TYPE_PTZ_RELATIVE, command.GetType())
+		require.InDelta(t, 5, command.GetPtzParameters().GetPan(), 1e-3)
+		require.InDelta(t, 0.5, command.GetPtzParameters().GetPanSpeed(), 1e-3)
+	}
+
+	// 軌道のタスクは最後のキーフレームの到達予定時刻にCRが完了させる
+	fixture.waitTaskEvent(t, infrastructure.EventTaskCompleted, taskID)
+	require.GreaterOrEqual(t, time.Since(startedAt), 300*time.Millisecond)
+	require.Nil(t, fixture.repos.ptz.GetQueueStatus(fixture.cameraID).GetExecutingTask())
+}
+
+func TestPTZTrajectory_ManualPTZInterruptsCinematicTrajectory(t *testing.T) {
+	t.Parallel()
+
+	fixture := newTrajectoryFixture(t)
+
+	ctx, cancel := context.WithTimeout(t.Context(), 5*time.Second)
+	defer cancel()
+
+	resp, err := fixture.call(ctx, t, handlers.FDServiceEnqueuePTZTrajectoryProcedure, map[string]any{
+		"from": map[string]any{"pan": -40, "tilt": 0, "zoom": 1},
+		"to":   map[string]any{"pan": 40, "tilt": 0, "zoom": 1}, "duration_ms": 3000,
+	})
+	require.NoError(t, err)
+
+	taskID := resp.GetFields()["task_id"].GetStringValue()
+
+	fixture.startTask(t, "")
+
+	command := fixture.nextCommand(t, commandOfTask(taskID))
+	require.Equal(t, taskID+"-1", command.GetCommandId())
+	require.Equal(t, protov1.ControlCommandType_CONTROL_COMMAND_TYPE_PTZ_ABSOLUTE, command.GetType())
+	require.Greater(t, command.GetPtzParameters().GetPan(), float32(-40))
+
+	_, accepted := fixture.repos.ptz.EnqueuePTZCommand(fixture.cameraID, &protov1.PTZCommand{
+		OperationType: protov1.PTZOperationType_PTZ_OPERATION_TYPE_RELATIVE_MOVE,
+		Command: &protov1.PTZCommand_RelativeMove{
+			RelativeMove: &protov1.RelativeMoveCommand{Translation: &protov1.PTZTranslation{PanDelta: 0.1}},
+		},
+	})
+	require.True(t, accepted)
+
+	// 中断された軌道は残りのキーフレームを配信しない
+	fixture.waitTaskEvent(t, infrastructure.EventTaskInterrupted, taskID)
+	fixture.requireNoCommand(t, commandOfTask(taskID), 300*time.Millisecond)
+}
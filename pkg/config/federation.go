@@ -0,0 +1,60 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/kelseyhightower/envconfig"
+)
+
+var ErrInvalidFederationPeer = errors.New("invalid federation peer")
+
+// FederationConfig は子CRとのピアリング設定です。
+// Peers は "hall-a=http://10.0.0.2:8080" 形式のエントリをカンマ区切りで指定します。
+// SharedSecret は親CRと子CRで同じ値を設定します。親CRから転送されたリクエストの認証に使い、
+// 空の場合は認証せずに、転送を示すヘッダーを持つリクエストを全て転送されたリクエストとして扱います。
+type FederationConfig struct {
+	Peers          []string
+	OriginID       string `default:"cr"   split_words:"true"`
+	RequestTimeout int    `default:"3000" split_words:"true"`
+	SharedSecret   string `split_words:"true"`
+}
+
+type FederationPeerConfig struct {
+	ID      string
+	BaseURL string
+}
+
+func LoadFederationConfig() (FederationConfig, error) {
+	var cfg FederationConfig
+	err := envconfig.Process("cr_federation", &cfg)
+
+	return cfg, err
+}
+
+func (c FederationConfig) ParsePeers() ([]FederationPeerConfig, error) {
+	peers := make([]FederationPeerConfig, 0, len(c.Peers))
+
+	for _, entry := range c.Peers {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		id, baseURL, ok := strings.Cut(entry, "=")
+		id = strings.TrimSpace(id)
+		baseURL = strings.TrimSpace(baseURL)
+
+		if !ok || id == "" || baseURL == "" {
+			return nil, fmt.Errorf("%w: %q", ErrInvalidFederationPeer, entry)
+		}
+
+		peers = append(peers, FederationPeerConfig{
+			ID:      id,
+			BaseURL: strings.TrimRight(baseURL, "/"),
+		})
+	}
+
+	return peers, nil
+}
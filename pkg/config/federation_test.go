@@ -0,0 +1,39 @@
+package config_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/anyfld/vistra-operation-control-room/pkg/config"
+)
+
+func TestLoadFederationConfig_EnvVars(t *testing.T) {
+	t.Setenv("CR_FEDERATION_PEERS", "hall-a=http://10.0.0.2:8080/, hall-b=http://10.0.0.3:8080")
+	t.Setenv("CR_FEDERATION_ORIGIN_ID", "parent")
+	t.Setenv("CR_FEDERATION_REQUEST_TIMEOUT", "1500")
+	t.Setenv("CR_FEDERATION_SHARED_SECRET", "s3cret")
+
+	cfg, err := config.LoadFederationConfig()
+	require.NoError(t, err)
+	assert.Equal(t, "parent", cfg.OriginID)
+	assert.Equal(t, 1500, cfg.RequestTimeout)
+	assert.Equal(t, "s3cret", cfg.SharedSecret)
+
+	peers, err := cfg.ParsePeers()
+	require.NoError(t, err)
+	assert.Equal(t, []config.FederationPeerConfig{
+		{ID: "hall-a", BaseURL: "http://10.0.0.2:8080"},
+		{ID: "hall-b", BaseURL: "http://10.0.0.3:8080"},
+	}, peers)
+}
+
+func TestFederationConfig_ParsePeersInvalid(t *testing.T) {
+	t.Parallel()
+
+	cfg := config.FederationConfig{Peers: []string{"http://10.0.0.2:8080"}, OriginID: "", RequestTimeout: 0, SharedSecret: ""}
+
+	_, err := cfg.ParsePeers()
+	require.ErrorIs(t, err, config.ErrInvalidFederationPeer)
+}
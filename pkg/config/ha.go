@@ -0,0 +1,26 @@
+package config
+
+import (
+	"github.com/kelseyhightower/envconfig"
+)
+
+// HAConfig はアクティブ/スタンバイ構成の設定です。
+// 同じ StateDir を共有する複数のCRのうち、リースを保持した1台がリーダーになります。
+type HAConfig struct {
+	Enabled          bool
+	NodeID           string `split_words:"true"`
+	AdvertiseAddress string `split_words:"true"`
+	StateDir         string `default:"/var/lib/vistra-cr" split_words:"true"`
+	LeaseTTLMs       int    `default:"5000"               envconfig:"LEASE_TTL_MS"`
+	RenewIntervalMs  int    `default:"1000"               envconfig:"RENEW_INTERVAL_MS"`
+	// PersistIntervalMs の間のタスクの実行の進行（ポーリングや結果の報告）は、まとめて1回で共有ストアへ書き込みます。
+	// タスクの追加や割り込み、カメラ登録とプリセットの変更は、RPCが応答する前に書き込みます。
+	PersistIntervalMs int `default:"200" envconfig:"PERSIST_INTERVAL_MS"`
+}
+
+func LoadHAConfig() (HAConfig, error) {
+	var cfg HAConfig
+	err := envconfig.Process("cr_ha", &cfg)
+
+	return cfg, err
+}
@@ -0,0 +1,48 @@
+package config_test
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/anyfld/vistra-operation-control-room/pkg/config"
+)
+
+func TestLoadHAConfig_EnvVars(t *testing.T) {
+	t.Setenv("CR_HA_ENABLED", "true")
+	t.Setenv("CR_HA_NODE_ID", "cr-a")
+	t.Setenv("CR_HA_ADVERTISE_ADDRESS", "http://10.0.0.10:8080")
+	t.Setenv("CR_HA_STATE_DIR", "/mnt/shared/cr")
+	t.Setenv("CR_HA_LEASE_TTL_MS", "3000")
+	t.Setenv("CR_HA_RENEW_INTERVAL_MS", "500")
+	t.Setenv("CR_HA_PERSIST_INTERVAL_MS", "50")
+
+	cfg, err := config.LoadHAConfig()
+	require.NoError(t, err)
+	assert.True(t, cfg.Enabled)
+	assert.Equal(t, "cr-a", cfg.NodeID)
+	assert.Equal(t, "http://10.0.0.10:8080", cfg.AdvertiseAddress)
+	assert.Equal(t, "/mnt/shared/cr", cfg.StateDir)
+	assert.Equal(t, 3000, cfg.LeaseTTLMs)
+	assert.Equal(t, 500, cfg.RenewIntervalMs)
+	assert.Equal(t, 50, cfg.PersistIntervalMs)
+}
+
+func TestLoadHAConfig_Defaults(t *testing.T) {
+	t.Parallel()
+	require.NoError(t, os.Unsetenv("CR_HA_ENABLED"))
+	require.NoError(t, os.Unsetenv("CR_HA_STATE_DIR"))
+	require.NoError(t, os.Unsetenv("CR_HA_LEASE_TTL_MS"))
+	require.NoError(t, os.Unsetenv("CR_HA_RENEW_INTERVAL_MS"))
+	require.NoError(t, os.Unsetenv("CR_HA_PERSIST_INTERVAL_MS"))
+
+	cfg, err := config.LoadHAConfig()
+	require.NoError(t, err)
+	assert.False(t, cfg.Enabled)
+	assert.Equal(t, "/var/lib/vistra-cr", cfg.StateDir)
+	assert.Equal(t, 5000, cfg.LeaseTTLMs)
+	assert.Equal(t, 1000, cfg.RenewIntervalMs)
+	assert.Equal(t, 200, cfg.PersistIntervalMs)
+}
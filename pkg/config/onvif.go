@@ -0,0 +1,24 @@
+package config
+
+import (
+	"github.com/kelseyhightower/envconfig"
+)
+
+// ONVIFConfig は内蔵のONVIFドライバーの設定です。
+// 有効な場合、接続方式が ONVIF のカメラはFDを介さずCRから直接PTZを制御します。
+type ONVIFConfig struct {
+	Enabled bool
+	// PollIntervalMs は GetStatus でカメラの状態を取得する間隔です。
+	PollIntervalMs int `default:"500"   envconfig:"POLL_INTERVAL_MS"`
+	// RequestTimeoutMs はSOAPリクエスト1件のタイムアウトです。
+	RequestTimeoutMs int `default:"3000"  envconfig:"REQUEST_TIMEOUT_MS"`
+	// MoveTimeoutMs は移動命令の完了（GetStatus が停止を返すまで）を待つ上限です。
+	MoveTimeoutMs int `default:"30000" envconfig:"MOVE_TIMEOUT_MS"`
+}
+
+func LoadONVIFConfig() (ONVIFConfig, error) {
+	var cfg ONVIFConfig
+	err := envconfig.Process("cr_onvif", &cfg)
+
+	return cfg, err
+}
@@ -0,0 +1,40 @@
+package config_test
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/anyfld/vistra-operation-control-room/pkg/config"
+)
+
+func TestLoadONVIFConfig_EnvVars(t *testing.T) {
+	t.Setenv("CR_ONVIF_ENABLED", "true")
+	t.Setenv("CR_ONVIF_POLL_INTERVAL_MS", "250")
+	t.Setenv("CR_ONVIF_REQUEST_TIMEOUT_MS", "1500")
+	t.Setenv("CR_ONVIF_MOVE_TIMEOUT_MS", "10000")
+
+	cfg, err := config.LoadONVIFConfig()
+	require.NoError(t, err)
+	assert.True(t, cfg.Enabled)
+	assert.Equal(t, 250, cfg.PollIntervalMs)
+	assert.Equal(t, 1500, cfg.RequestTimeoutMs)
+	assert.Equal(t, 10000, cfg.MoveTimeoutMs)
+}
+
+func TestLoadONVIFConfig_Defaults(t *testing.T) {
+	t.Parallel()
+	require.NoError(t, os.Unsetenv("CR_ONVIF_ENABLED"))
+	require.NoError(t, os.Unsetenv("CR_ONVIF_POLL_INTERVAL_MS"))
+	require.NoError(t, os.Unsetenv("CR_ONVIF_REQUEST_TIMEOUT_MS"))
+	require.NoError(t, os.Unsetenv("CR_ONVIF_MOVE_TIMEOUT_MS"))
+
+	cfg, err := config.LoadONVIFConfig()
+	require.NoError(t, err)
+	assert.False(t, cfg.Enabled)
+	assert.Equal(t, 500, cfg.PollIntervalMs)
+	assert.Equal(t, 3000, cfg.RequestTimeoutMs)
+	assert.Equal(t, 30000, cfg.MoveTimeoutMs)
+}
@@ -0,0 +1,525 @@
+package fdsim
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+
+	"connectrpc.com/connect"
+
+	protov1 "github.com/anyfld/vistra-operation-control-room/gen/proto/v1"
+	"github.com/anyfld/vistra-operation-control-room/pkg/transport/infrastructure"
+)
+
+// Outcome は仮想カメラが命令をどう終えたかです。
+type Outcome string
+
+const (
+	OutcomeCompleted Outcome = "completed"
+	OutcomeFailed    Outcome = "failed"
+	// OutcomeDropped は障害の注入で命令を無視したことを表します。
+	OutcomeDropped Outcome = "dropped"
+	// OutcomeInterrupted は完了前に中断・置き換えられたことを表します。
+	OutcomeInterrupted Outcome = "interrupted"
+)
+
+const (
+	faultErrorMessage      = "simulated fault"
+	streamReconnectDelay   = 500 * time.Millisecond
+	supersededErrorMessage = "superseded by "
+)
+
+// source は命令を受け取った経路です。完了はこの経路で報告します。
+type source int
+
+const (
+	sourcePolling source = iota
+	sourceStream
+)
+
+type execution struct {
+	id     string
+	source source
+	// motion が nil の場合は until まで実行したものとみなします。
+	motion *motion
+	until  time.Time
+	// reported は完了を既に報告したかどうかです（停止まで続く連続移動は開始時に報告します）。
+	reported bool
+}
+
+// Camera は1台の仮想カメラです。
+type Camera struct {
+	sim    *Simulator
+	id     string
+	optics *infrastructure.CameraOptics
+
+	mu              sync.Mutex
+	position        vector
+	focus           float32
+	autofocus       bool
+	lastTick        time.Time
+	executing       *execution
+	completedTaskID string
+	outcomes        map[string]Outcome
+
+	pollNow  chan struct{}
+	streamMu sync.Mutex
+	stream   *connect.BidiStreamForClient[protov1.StreamControlCommandsRequest, protov1.StreamControlCommandsResponse]
+}
+
+func newCamera(sim *Simulator, id string) *Camera {
+	optics := infrastructure.NewCameraOptics(sim.config.Capabilities, sim.config.metadata())
+
+	return &Camera{
+		sim:             sim,
+		id:              id,
+		optics:          optics,
+		mu:              sync.Mutex{},
+		position:        clampVector(optics, vector{pan: 0, tilt: 0, zoom: optics.ZoomMin}),
+		focus:           0,
+		autofocus:       sim.config.Capabilities.GetSupportsAutofocus(),
+		lastTick:        time.Now(),
+		executing:       nil,
+		completedTaskID: "",
+		outcomes:        make(map[string]Outcome),
+		pollNow:         make(chan struct{}, 1),
+		streamMu:        sync.Mutex{},
+		stream:          nil,
+	}
+}
+
+// ID はCRが割り当てたカメラIDです。
+func (c *Camera) ID() string {
+	return c.id
+}
+
+// Position はカメラの現在のPTZです。
+func (c *Camera) Position() *protov1.PTZParameters {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.position.parameters()
+}
+
+// Moving は命令を実行中かどうかです。
+func (c *Camera) Moving() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.executing != nil && c.executing.motion != nil
+}
+
+// Focus はカメラのフォーカス値と、オートフォーカスかどうかを返します。
+func (c *Camera) Focus() (float32, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.focus, c.autofocus
+}
+
+// Outcome は命令をどう終えたかを返します。実行中または受け取っていない場合は false を返します。
+func (c *Camera) Outcome(commandID string) (Outcome, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	outcome, ok := c.outcomes[commandID]
+
+	return outcome, ok
+}
+
+func (c *Camera) start(ctx context.Context) {
+	loops := []func(context.Context){c.tickLoop, c.stateLoop}
+	if c.sim.polls() {
+		loops = append(loops, c.pollLoop)
+	}
+
+	if c.sim.streams() {
+		loops = append(loops, c.streamLoop)
+	}
+
+	for _, loop := range loops {
+		c.sim.wg.Add(1)
+
+		go func() {
+			defer c.sim.wg.Done()
+
+			loop(ctx)
+		}()
+	}
+}
+
+// execute は命令の実行を始めます。同じIDの命令は一度だけ実行します。
+// command が nil の場合は TaskDuration の間実行したものとみなします。
+func (c *Camera) execute(ctx context.Context, id string, src source, command *protov1.ControlCommand) {
+	if command.GetType() == protov1.ControlCommandType_CONTROL_COMMAND_TYPE_FOCUS {
+		c.applyFocus(ctx, id, src, command.GetFocusValue())
+
+		return
+	}
+
+	fault, slow := c.sim.fault()
+	now := time.Now()
+
+	c.mu.Lock()
+
+	if _, done := c.outcomes[id]; done || (c.executing != nil && c.executing.id == id) {
+		c.mu.Unlock()
+
+		return
+	}
+
+	superseded := c.executing
+	if superseded != nil {
+		c.outcomes[superseded.id] = OutcomeInterrupted
+		c.executing = nil
+	}
+
+	if fault != "" {
+		c.outcomes[id] = fault
+		c.mu.Unlock()
+
+		c.reportSuperseded(ctx, superseded, id)
+
+		if fault == OutcomeFailed {
+			c.report(ctx, id, src, false, faultErrorMessage)
+		}
+
+		return
+	}
+
+	run := &execution{id: id, source: src, motion: nil, until: now.Add(c.sim.config.TaskDuration), reported: false}
+
+	if command != nil {
+		run.motion = newMotion(command, c.position, c.optics, slow, now)
+		run.until = now
+		// 停止まで続く連続移動は開始した時点で完了を報告し、移動は次の命令まで続ける
+		run.reported = run.motion != nil && run.motion.target == nil && run.motion.until.IsZero()
+	}
+
+	c.executing = run
+	c.mu.Unlock()
+
+	c.reportSuperseded(ctx, superseded, id)
+
+	if run.reported {
+		c.report(ctx, id, src, true, "")
+	}
+}
+
+// applyFocus はFOCUS命令を反映します。フォーカスはPTZと独立した軸のため、実行中の命令は置き換えずに即座に完了します。
+// フォーカス値が infrastructure.AutofocusValue の場合はオートフォーカスに切り替えます。
+func (c *Camera) applyFocus(ctx context.Context, id string, src source, value float32) {
+	c.mu.Lock()
+
+	if _, done := c.outcomes[id]; done {
+		c.mu.Unlock()
+
+		return
+	}
+
+	c.outcomes[id] = OutcomeCompleted
+	c.autofocus = value == infrastructure.AutofocusValue
+
+	if !c.autofocus {
+		c.focus = value
+	}
+
+	c.mu.Unlock()
+
+	c.report(ctx, id, src, true, "")
+}
+
+// reportSuperseded はストリームで受け取った命令が次の命令に置き換えられたことを失敗として報告します。
+// ポーリングの命令はCRのキューが中断を管理するため報告しません。
+func (c *Camera) reportSuperseded(ctx context.Context, superseded *execution, nextID string) {
+	if superseded == nil || superseded.reported || superseded.source != sourceStream {
+		return
+	}
+
+	c.report(ctx, superseded.id, sourceStream, false, supersededErrorMessage+nextID)
+}
+
+// abandon はポーリングで中断を指示された命令を、報告せずに止めます。
+func (c *Camera) abandon(nextID string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.executing != nil && c.executing.id != nextID {
+		c.outcomes[c.executing.id] = OutcomeInterrupted
+		c.executing = nil
+	}
+}
+
+// tickLoop は位置を進め、移動を終えた命令の完了を報告します。
+func (c *Camera) tickLoop(ctx context.Context) {
+	ticker := time.NewTicker(c.sim.config.TickInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			if finished := c.tick(now); finished != nil && !finished.reported {
+				c.report(ctx, finished.id, finished.source, true, "")
+			}
+		}
+	}
+}
+
+func (c *Camera) tick(now time.Time) *execution {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elapsed := now.Sub(c.lastTick)
+	c.lastTick = now
+
+	run := c.executing
+	if run == nil {
+		return nil
+	}
+
+	done := !now.Before(run.until)
+	if run.motion != nil {
+		c.position, done = run.motion.step(c.position, elapsed, now, c.optics)
+	}
+
+	if !done {
+		return nil
+	}
+
+	c.executing = nil
+	c.outcomes[run.id] = OutcomeCompleted
+
+	return run
+}
+
+// report は命令の結果を報告します。ポーリングの命令の完了は次のポーリングで報告し、
+// それ以外は制御コマンドの結果として報告します。
+func (c *Camera) report(ctx context.Context, id string, src source, success bool, errorMessage string) {
+	if success && src == sourcePolling {
+		c.mu.Lock()
+		c.completedTaskID = id
+		c.mu.Unlock()
+
+		select {
+		case c.pollNow <- struct{}{}:
+		default:
+		}
+
+		return
+	}
+
+	c.mu.Lock()
+	result := &protov1.ControlCommandResult{
+		CommandId:       id,
+		Success:         success,
+		ErrorMessage:    errorMessage,
+		ResultingPtz:    c.position.parameters(),
+		ExecutionTimeMs: 0,
+	}
+	c.mu.Unlock()
+
+	request := &protov1.StreamControlCommandsRequest{
+		Message: &protov1.StreamControlCommandsRequest_Result{Result: result},
+	}
+
+	c.streamMu.Lock()
+	stream := c.stream
+	c.streamMu.Unlock()
+
+	if stream != nil {
+		c.streamMu.Lock()
+		err := stream.Send(request)
+		c.streamMu.Unlock()
+
+		if err == nil {
+			return
+		}
+	}
+
+	if _, err := c.sim.fdClient.StreamControlCommands(ctx, connect.NewRequest(request)); err != nil {
+		log.Printf("fdsim report result failed: camera_id=%s command_id=%s err=%v", c.id, id, err)
+	}
+}
+
+// pollLoop は PTZService.Polling で命令を受け取ります。
+func (c *Camera) pollLoop(ctx context.Context) {
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+		case <-c.pollNow:
+		}
+
+		c.poll(ctx)
+		timer.Reset(c.sim.config.PollInterval)
+	}
+}
+
+func (c *Camera) poll(ctx context.Context) {
+	c.mu.Lock()
+
+	completed := c.completedTaskID
+	c.completedTaskID = ""
+	executing := ""
+	deviceStatus := protov1.DeviceStatus_DEVICE_STATUS_IDLE
+
+	if c.executing != nil {
+		executing = c.executing.id
+		deviceStatus = protov1.DeviceStatus_DEVICE_STATUS_EXECUTING
+	}
+
+	request := &protov1.PollingRequest{
+		CameraId:        c.id,
+		DeviceStatus:    deviceStatus,
+		CameraStatus:    protov1.CameraStatus_CAMERA_STATUS_ONLINE,
+		CompletedTaskId: completed,
+		ExecutingTaskId: executing,
+		CurrentPtz:      c.position.parameters(),
+		TimestampMs:     time.Now().UnixMilli(),
+	}
+	c.mu.Unlock()
+
+	resp, err := c.sim.ptzClient.Polling(ctx, connect.NewRequest(request))
+	if err != nil {
+		if ctx.Err() == nil {
+			log.Printf("fdsim polling failed: camera_id=%s err=%v", c.id, err)
+		}
+
+		// 完了の報告は次のポーリングで送り直す
+		c.mu.Lock()
+		if c.completedTaskID == "" {
+			c.completedTaskID = completed
+		}
+		c.mu.Unlock()
+
+		return
+	}
+
+	task := resp.Msg.GetCurrentCommand()
+	if task == nil {
+		return
+	}
+
+	if resp.Msg.GetInterrupt() {
+		c.abandon(task.GetTaskId())
+	}
+
+	var command *protov1.ControlCommand
+	if ptz := task.GetPtzCommand(); ptz != nil {
+		command = c.optics.ControlCommandFromPTZ(task.GetTaskId(), c.id, ptz)
+	}
+
+	c.execute(ctx, task.GetTaskId(), sourcePolling, command)
+}
+
+// streamLoop は制御コマンドの双方向ストリームで命令を受け取ります。切断された場合は接続し直します。
+func (c *Camera) streamLoop(ctx context.Context) {
+	for ctx.Err() == nil {
+		if err := c.receiveStream(ctx); err != nil && ctx.Err() == nil {
+			log.Printf("fdsim control command stream disconnected: camera_id=%s err=%v", c.id, err)
+		}
+
+		select {
+		case <-ctx.Done():
+		case <-time.After(streamReconnectDelay):
+		}
+	}
+}
+
+func (c *Camera) receiveStream(ctx context.Context) error {
+	stream := c.sim.streamClient.CallBidiStream(ctx)
+
+	err := stream.Send(&protov1.StreamControlCommandsRequest{
+		Message: &protov1.StreamControlCommandsRequest_Init{
+			Init: &protov1.StreamControlCommandsInit{CameraId: c.id},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("send init: %w", err)
+	}
+
+	c.streamMu.Lock()
+	c.stream = stream
+	c.streamMu.Unlock()
+
+	// ctx の終了だけでは受信が終わらないため、送信を閉じてCRにストリームを終了させる
+	stopClose := context.AfterFunc(ctx, func() {
+		c.streamMu.Lock()
+		defer c.streamMu.Unlock()
+
+		_ = stream.CloseRequest()
+	})
+
+	defer func() {
+		stopClose()
+
+		c.streamMu.Lock()
+		c.stream = nil
+		c.streamMu.Unlock()
+
+		_ = stream.CloseRequest()
+		_ = stream.CloseResponse()
+	}()
+
+	for {
+		resp, err := stream.Receive()
+		if err != nil {
+			return err
+		}
+
+		// 送った結果への応答と結果のイベントは Result を持つため、新しい制御コマンドだけを実行する
+		if command := resp.GetCommand(); command != nil && resp.GetResult() == nil {
+			go c.execute(ctx, command.GetCommandId(), sourceStream, command)
+		}
+	}
+}
+
+// stateLoop はカメラの状態を一定の間隔で報告します。
+func (c *Camera) stateLoop(ctx context.Context) {
+	ticker := time.NewTicker(c.sim.config.StateInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			c.reportState(ctx)
+		}
+	}
+}
+
+func (c *Camera) reportState(ctx context.Context) {
+	c.mu.Lock()
+	state := &protov1.CameraState{
+		CameraId:     c.id,
+		CurrentPtz:   c.position.parameters(),
+		IsMoving:     c.executing != nil && c.executing.motion != nil,
+		HasError:     false,
+		ErrorMessage: "",
+		CurrentFocus: c.focus,
+		UpdatedAtMs:  time.Now().UnixMilli(),
+		Status:       protov1.CameraStatus_CAMERA_STATUS_ONLINE,
+	}
+	c.mu.Unlock()
+
+	_, err := c.sim.fdClient.StreamControlCommands(ctx, connect.NewRequest(&protov1.StreamControlCommandsRequest{
+		Message: &protov1.StreamControlCommandsRequest_State{State: state},
+	}))
+	if err != nil && ctx.Err() == nil {
+		log.Printf("fdsim report state failed: camera_id=%s err=%v", c.id, err)
+	}
+}
+
+func formatFloat(value float64) string {
+	return strconv.FormatFloat(value, 'f', -1, 64)
+}
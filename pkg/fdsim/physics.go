@@ -0,0 +1,143 @@
+package fdsim
+
+import (
+	"math"
+	"time"
+
+	protov1 "github.com/anyfld/vistra-operation-control-room/gen/proto/v1"
+	"github.com/anyfld/vistra-operation-control-room/pkg/transport/infrastructure"
+)
+
+type vector struct {
+	pan, tilt, zoom float64
+}
+
+func vectorOf(ptz *protov1.PTZParameters) vector {
+	return vector{pan: float64(ptz.GetPan()), tilt: float64(ptz.GetTilt()), zoom: float64(ptz.GetZoom())}
+}
+
+func (v vector) parameters() *protov1.PTZParameters {
+	return &protov1.PTZParameters{ //nolint:exhaustruct
+		Pan:  float32(v.pan),
+		Tilt: float32(v.tilt),
+		Zoom: float32(v.zoom),
+	}
+}
+
+// motion は実行中の移動です。target がある場合は軸ごとの速さで目標へ向かい、ない場合は符号付きの速度で until まで動きます。
+type motion struct {
+	target   *vector
+	velocity vector
+	until    time.Time
+}
+
+// newMotion は移動系の制御コマンドから移動を作成します。速度 (0.0-1.0) は最高速度に対する割合で、
+// 絶対・相対移動で 0 の軸は最高速度で動きます。slow は速度に掛ける係数です（遅延の障害）。
+// 停止や移動以外の制御コマンドの場合は nil を返します。
+func newMotion(
+	command *protov1.ControlCommand,
+	position vector,
+	optics *infrastructure.CameraOptics,
+	slow float64,
+	now time.Time,
+) *motion {
+	params := command.GetPtzParameters()
+	speed := func(value float32, fallback, maxSpeed float64) float64 {
+		if value == 0 {
+			return fallback * maxSpeed * slow
+		}
+
+		return math.Max(-1, math.Min(1, float64(value))) * maxSpeed * slow
+	}
+	velocity := func(fallback float64) vector {
+		return vector{
+			pan:  speed(params.GetPanSpeed(), fallback, optics.PanSpeedDps),
+			tilt: speed(params.GetTiltSpeed(), fallback, optics.TiltSpeedDps),
+			zoom: speed(params.GetZoomSpeed(), fallback, optics.ZoomSpeedPerSec),
+		}
+	}
+
+	switch command.GetType() { //nolint:exhaustive
+	case protov1.ControlCommandType_CONTROL_COMMAND_TYPE_PTZ_ABSOLUTE:
+		target := vectorOf(params)
+		if params.GetZoom() <= 0 {
+			target.zoom = position.zoom
+		}
+
+		target = clampVector(optics, target)
+
+		return &motion{target: &target, velocity: velocity(1), until: time.Time{}}
+	case protov1.ControlCommandType_CONTROL_COMMAND_TYPE_PTZ_RELATIVE:
+		target := clampVector(optics, vector{
+			pan:  position.pan + float64(params.GetPan()),
+			tilt: position.tilt + float64(params.GetTilt()),
+			zoom: position.zoom + float64(params.GetZoom()),
+		})
+
+		return &motion{target: &target, velocity: velocity(1), until: time.Time{}}
+	case protov1.ControlCommandType_CONTROL_COMMAND_TYPE_PTZ_CONTINUOUS:
+		until := time.Time{}
+		if command.GetTimeoutMs() > 0 {
+			until = now.Add(time.Duration(command.GetTimeoutMs()) * time.Millisecond)
+		}
+
+		return &motion{target: nil, velocity: velocity(0), until: until}
+	default:
+		return nil
+	}
+}
+
+// step は position から elapsed だけ進めた位置と、移動を終えたかどうかを返します。
+// 停止まで続く連続移動は、可動範囲の端に達しても終わりません。
+func (m *motion) step(
+	position vector,
+	elapsed time.Duration,
+	now time.Time,
+	optics *infrastructure.CameraOptics,
+) (vector, bool) {
+	if m.target == nil {
+		if !m.until.IsZero() && now.After(m.until) {
+			elapsed -= now.Sub(m.until)
+		}
+
+		seconds := math.Max(elapsed.Seconds(), 0)
+		next := clampVector(optics, vector{
+			pan:  position.pan + m.velocity.pan*seconds,
+			tilt: position.tilt + m.velocity.tilt*seconds,
+			zoom: position.zoom + m.velocity.zoom*seconds,
+		})
+
+		return next, !m.until.IsZero() && !now.Before(m.until)
+	}
+
+	seconds := elapsed.Seconds()
+	next := vector{
+		pan:  approach(position.pan, m.target.pan, m.velocity.pan, seconds),
+		tilt: approach(position.tilt, m.target.tilt, m.velocity.tilt, seconds),
+		zoom: approach(position.zoom, m.target.zoom, m.velocity.zoom, seconds),
+	}
+
+	return next, next == *m.target
+}
+
+// approach は from から to へ speed で seconds だけ進んだ位置を返します。速度が 0 の軸は動きません。
+func approach(from, to, speed, seconds float64) float64 {
+	step := math.Abs(speed) * seconds
+	if math.Abs(to-from) <= step {
+		return to
+	}
+
+	if speed == 0 {
+		return from
+	}
+
+	return from + math.Copysign(step, to-from)
+}
+
+func clampVector(optics *infrastructure.CameraOptics, position vector) vector {
+	return vector{
+		pan:  math.Max(optics.PanMin, math.Min(optics.PanMax, position.pan)),
+		tilt: math.Max(optics.TiltMin, math.Min(optics.TiltMax, position.tilt)),
+		zoom: math.Max(optics.ZoomMin, math.Min(optics.ZoomMax, position.zoom)),
+	}
+}
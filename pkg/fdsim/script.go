@@ -0,0 +1,342 @@
+package fdsim
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"time"
+
+	"connectrpc.com/connect"
+	"google.golang.org/protobuf/encoding/protojson"
+	"gopkg.in/yaml.v3"
+
+	protov1 "github.com/anyfld/vistra-operation-control-room/gen/proto/v1"
+)
+
+const (
+	defaultExpectTolerance = 0.5
+	defaultExpectWithin    = 5 * time.Second
+	expectPollInterval     = 20 * time.Millisecond
+)
+
+// Script は仮想カメラへの命令の送信と結果の確認を順に実行する手順です。
+type Script struct {
+	Name  string
+	Steps []*ScriptStep
+}
+
+// ScriptStep は手順の1段階です。設定された項目を faults, command, wait, expect の順に実行します。
+type ScriptStep struct {
+	Name string
+	// Camera は対象のカメラの登録順の番号 (0始まり) です。
+	Camera int
+	// Command は FDService.StreamControlCommands で送る制御コマンドです。camera_id は対象のカメラに置き換えます。
+	Command *protov1.ControlCommand
+	Wait    time.Duration
+	Expect  *Expectation
+	Faults  *Faults
+}
+
+// Expectation は Within の間に満たされるべき状態です。
+type Expectation struct {
+	// Pan, Tilt, Zoom は期待する位置で、Tolerance 以内の差を許容します。
+	Pan       *float64
+	Tilt      *float64
+	Zoom      *float64
+	Tolerance float64
+	// Outcome は CommandID（空の場合は直前に送った制御コマンド）の期待する結果です。
+	CommandID string
+	Outcome   Outcome
+	Within    time.Duration
+}
+
+// scriptDocument はスクリプトのYAML/JSON表現です。制御コマンドは protojson 形式で記述します。
+type scriptDocument struct {
+	Name  string               `json:"name"`
+	Steps []scriptStepDocument `json:"steps"`
+}
+
+type scriptStepDocument struct {
+	Name    string               `json:"name"`
+	Camera  int                  `json:"camera"`
+	Command json.RawMessage      `json:"command"`
+	Wait    string               `json:"wait"`
+	Expect  *expectationDocument `json:"expect"`
+	Faults  *Faults              `json:"faults"`
+}
+
+type expectationDocument struct {
+	Pan       *float64 `json:"pan"`
+	Tilt      *float64 `json:"tilt"`
+	Zoom      *float64 `json:"zoom"`
+	Tolerance *float64 `json:"tolerance"`
+	CommandID string   `json:"command_id"`
+	Outcome   Outcome  `json:"outcome"`
+	Within    string   `json:"within"`
+}
+
+// ParseScript はYAMLまたはJSONのスクリプトを解析します。
+func ParseScript(data []byte) (*Script, error) {
+	var raw any
+	if err := yaml.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidScript, err)
+	}
+
+	// 制御コマンドを protojson で解析するため、一度JSONへ変換する
+	jsonData, err := json.Marshal(raw)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidScript, err)
+	}
+
+	var doc scriptDocument
+	if err := json.Unmarshal(jsonData, &doc); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidScript, err)
+	}
+
+	if len(doc.Steps) == 0 {
+		return nil, fmt.Errorf("%w: at least one step is required", ErrInvalidScript)
+	}
+
+	script := &Script{Name: doc.Name, Steps: make([]*ScriptStep, 0, len(doc.Steps))}
+
+	for i, stepDoc := range doc.Steps {
+		step, err := parseScriptStep(stepDoc)
+		if err != nil {
+			return nil, fmt.Errorf("%w: step %d: %w", ErrInvalidScript, i+1, err)
+		}
+
+		script.Steps = append(script.Steps, step)
+	}
+
+	return script, nil
+}
+
+func parseScriptStep(doc scriptStepDocument) (*ScriptStep, error) {
+	step := &ScriptStep{
+		Name:    doc.Name,
+		Camera:  doc.Camera,
+		Command: nil,
+		Wait:    0,
+		Expect:  nil,
+		Faults:  doc.Faults,
+	}
+
+	if doc.Camera < 0 {
+		return nil, fmt.Errorf("camera must not be negative: %d", doc.Camera)
+	}
+
+	if len(doc.Command) > 0 && string(doc.Command) != "null" {
+		var command protov1.ControlCommand
+		if err := protojson.Unmarshal(doc.Command, &command); err != nil {
+			return nil, fmt.Errorf("command: %w", err)
+		}
+
+		step.Command = &command
+	}
+
+	if doc.Wait != "" {
+		wait, err := time.ParseDuration(doc.Wait)
+		if err != nil {
+			return nil, fmt.Errorf("wait: %w", err)
+		}
+
+		step.Wait = wait
+	}
+
+	if doc.Expect != nil {
+		expect, err := parseExpectation(doc.Expect)
+		if err != nil {
+			return nil, fmt.Errorf("expect: %w", err)
+		}
+
+		step.Expect = expect
+	}
+
+	if step.Faults != nil {
+		if err := validateFaults(*step.Faults); err != nil {
+			return nil, err
+		}
+	}
+
+	return step, nil
+}
+
+func parseExpectation(doc *expectationDocument) (*Expectation, error) {
+	expect := &Expectation{
+		Pan:       doc.Pan,
+		Tilt:      doc.Tilt,
+		Zoom:      doc.Zoom,
+		Tolerance: defaultExpectTolerance,
+		CommandID: doc.CommandID,
+		Outcome:   doc.Outcome,
+		Within:    defaultExpectWithin,
+	}
+
+	if doc.Tolerance != nil {
+		expect.Tolerance = *doc.Tolerance
+	}
+
+	if doc.Within != "" {
+		within, err := time.ParseDuration(doc.Within)
+		if err != nil {
+			return nil, fmt.Errorf("within: %w", err)
+		}
+
+		expect.Within = within
+	}
+
+	switch expect.Outcome {
+	case "", OutcomeCompleted, OutcomeFailed, OutcomeDropped, OutcomeInterrupted:
+	default:
+		return nil, fmt.Errorf("unknown outcome %q", expect.Outcome)
+	}
+
+	if expect.Pan == nil && expect.Tilt == nil && expect.Zoom == nil && expect.Outcome == "" {
+		return nil, errors.New("pan, tilt, zoom or outcome is required")
+	}
+
+	return expect, nil
+}
+
+// RunScript はスクリプトを実行します。期待した状態にならなかった場合は ErrScriptFailed を返します。
+// Start でカメラを登録した後に呼び出します。
+func (s *Simulator) RunScript(ctx context.Context, script *Script) error {
+	lastCommandID := ""
+
+	for i, step := range script.Steps {
+		label := fmt.Sprintf("step %d", i+1)
+		if step.Name != "" {
+			label += " (" + step.Name + ")"
+		}
+
+		if step.Camera >= len(s.cameras) {
+			return fmt.Errorf("%w: %s: camera %d is not registered", ErrScriptFailed, label, step.Camera)
+		}
+
+		camera := s.cameras[step.Camera]
+
+		if step.Faults != nil {
+			if err := s.SetFaults(*step.Faults); err != nil {
+				return fmt.Errorf("%w: %s: %w", ErrScriptFailed, label, err)
+			}
+		}
+
+		if step.Command != nil {
+			commandID, err := s.sendCommand(ctx, camera, step.Command)
+			if err != nil {
+				return fmt.Errorf("%w: %s: %w", ErrScriptFailed, label, err)
+			}
+
+			lastCommandID = commandID
+		}
+
+		if step.Wait > 0 {
+			if err := sleep(ctx, step.Wait); err != nil {
+				return err
+			}
+		}
+
+		if step.Expect != nil {
+			if err := camera.await(ctx, step.Expect, lastCommandID); err != nil {
+				return fmt.Errorf("%w: %s: %w", ErrScriptFailed, label, err)
+			}
+		}
+
+		log.Printf("fdsim script %s passed", label)
+	}
+
+	return nil
+}
+
+// sendCommand は制御コマンドを送り、CRが割り当てた制御コマンドIDを返します。
+func (s *Simulator) sendCommand(ctx context.Context, camera *Camera, command *protov1.ControlCommand) (string, error) {
+	command.CameraId = camera.id
+
+	resp, err := s.fdClient.StreamControlCommands(ctx, connect.NewRequest(&protov1.StreamControlCommandsRequest{
+		Message: &protov1.StreamControlCommandsRequest_Command{Command: command},
+	}))
+	if err != nil {
+		return "", fmt.Errorf("send command: %w", err)
+	}
+
+	if result := resp.Msg.GetResult(); result != nil && !result.GetSuccess() {
+		return "", fmt.Errorf("command %s was not accepted: %s", result.GetCommandId(), result.GetErrorMessage())
+	}
+
+	if commandID := resp.Msg.GetCommand().GetCommandId(); commandID != "" {
+		return commandID, nil
+	}
+
+	return command.GetCommandId(), nil
+}
+
+// await は期待した状態になるまで待ちます。
+func (c *Camera) await(ctx context.Context, expect *Expectation, lastCommandID string) error {
+	commandID := expect.CommandID
+	if commandID == "" {
+		commandID = lastCommandID
+	}
+
+	deadline := time.Now().Add(expect.Within)
+
+	for {
+		mismatch := c.mismatch(expect, commandID)
+		if mismatch == "" {
+			return nil
+		}
+
+		if time.Now().After(deadline) {
+			return fmt.Errorf("not satisfied within %s: %s", expect.Within, mismatch)
+		}
+
+		if err := sleep(ctx, expectPollInterval); err != nil {
+			return err
+		}
+	}
+}
+
+// mismatch は期待と異なる点を返します。期待どおりの場合は空文字列を返します。
+func (c *Camera) mismatch(expect *Expectation, commandID string) string {
+	if expect.Outcome != "" {
+		outcome, ok := c.Outcome(commandID)
+		if !ok {
+			return fmt.Sprintf("command %q has no outcome yet", commandID)
+		}
+
+		if outcome != expect.Outcome {
+			return fmt.Sprintf("command %q outcome is %s, want %s", commandID, outcome, expect.Outcome)
+		}
+	}
+
+	position := vectorOf(c.Position())
+	for _, axis := range []struct {
+		name   string
+		want   *float64
+		actual float64
+	}{
+		{name: "pan", want: expect.Pan, actual: position.pan},
+		{name: "tilt", want: expect.Tilt, actual: position.tilt},
+		{name: "zoom", want: expect.Zoom, actual: position.zoom},
+	} {
+		if axis.want != nil && math.Abs(axis.actual-*axis.want) > expect.Tolerance {
+			return fmt.Sprintf("%s is %.3f, want %.3f±%.3f", axis.name, axis.actual, *axis.want, expect.Tolerance)
+		}
+	}
+
+	return ""
+}
+
+func sleep(ctx context.Context, duration time.Duration) error {
+	timer := time.NewTimer(duration)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return fmt.Errorf("sleep: %w", ctx.Err())
+	case <-timer.C:
+		return nil
+	}
+}
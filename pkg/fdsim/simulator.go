@@ -0,0 +1,280 @@
+// Package fdsim は実機のカメラの代わりに CR へ接続する仮想のFD（カメラ）です。
+//
+// 指定した台数のカメラを登録し、PTZService.Polling と FDService の制御コマンドのストリームの一方または両方で
+// 命令を受け取ります。命令は速度の上限に従って位置を動かし、移動を終えると完了を報告します。
+// カメラの状態は一定の間隔で報告します。命令の破棄・失敗・遅延の障害を確率で注入でき、
+// スクリプト（RunScript）で命令の送信と結果の確認を繰り返すことで、CIのE2Eテストに使えます。
+package fdsim
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"sync"
+	"time"
+
+	"connectrpc.com/connect"
+
+	protov1 "github.com/anyfld/vistra-operation-control-room/gen/proto/v1"
+	"github.com/anyfld/vistra-operation-control-room/gen/proto/v1/protov1connect"
+	"github.com/anyfld/vistra-operation-control-room/pkg/transport/handlers"
+	"github.com/anyfld/vistra-operation-control-room/pkg/transport/infrastructure"
+)
+
+// Mode は仮想カメラが命令を受け取る経路です。
+type Mode string
+
+const (
+	// ModePolling は PTZService.Polling で命令を受け取り、ポーリングで完了を報告します。
+	ModePolling Mode = "polling"
+	// ModeStream は制御コマンドの双方向ストリームで命令を受け取り、ストリームで結果を報告します。
+	ModeStream Mode = "stream"
+	// ModeBoth は両方の経路で命令を受け取り、先に届いた経路で報告します。
+	ModeBoth Mode = "both"
+)
+
+var (
+	ErrInvalidConfig = errors.New("invalid simulator config")
+	ErrInvalidScript = errors.New("invalid simulator script")
+	ErrScriptFailed  = errors.New("script failed")
+)
+
+// Faults は命令ごとに注入する障害の確率 (0.0-1.0) です。
+type Faults struct {
+	// DropRate は命令を無視し、完了も失敗も報告しない確率です。
+	DropRate float64 `json:"drop_rate"`
+	// ErrorRate は命令を実行せずに失敗を報告する確率です。
+	ErrorRate float64 `json:"error_rate"`
+	// SlowRate は命令を SlowFactor 倍の速度で実行する確率です。
+	SlowRate float64 `json:"slow_rate"`
+	// SlowFactor は遅延させる命令の速度の倍率です（0 の場合は 0.25）。
+	SlowFactor float64 `json:"slow_factor"`
+}
+
+const defaultSlowFactor = 0.25
+
+// Config は仮想カメラの構成です。
+type Config struct {
+	// Cameras は登録するカメラの台数です。
+	Cameras    int
+	NamePrefix string
+	MasterMfID string
+	Mode       Mode
+	// PollInterval は命令がない間のポーリングの間隔です。完了した場合は間隔を待たずにポーリングします。
+	PollInterval time.Duration
+	// StateInterval はカメラの状態を報告する間隔です。
+	StateInterval time.Duration
+	// TickInterval は位置を進める間隔です。
+	TickInterval time.Duration
+	// TaskDuration はPTZ命令を持たないタスク（シネマティック命令・アームの軌道）を実行したものとみなす時間です。
+	TaskDuration time.Duration
+	Capabilities *protov1.CameraCapabilities
+	// PanSpeedDps, TiltSpeedDps, ZoomSpeedPerSec は速度 1.0 のときの速さで、カメラのメタデータとしても登録します。
+	PanSpeedDps     float64
+	TiltSpeedDps    float64
+	ZoomSpeedPerSec float64
+	Faults          Faults
+	Seed            uint64
+}
+
+// DefaultConfig は1台のカメラを両方の経路で動かす構成を返します。
+func DefaultConfig() Config {
+	return Config{
+		Cameras:       1,
+		NamePrefix:    "fdsim",
+		MasterMfID:    "fdsim",
+		Mode:          ModeBoth,
+		PollInterval:  500 * time.Millisecond, //nolint:mnd
+		StateInterval: time.Second,
+		TickInterval:  50 * time.Millisecond, //nolint:mnd
+		TaskDuration:  time.Second,
+		Capabilities: &protov1.CameraCapabilities{ //nolint:exhaustruct
+			SupportsPtz: true,
+			PanMin:      -170, //nolint:mnd
+			PanMax:      170,  //nolint:mnd
+			TiltMin:     -30,  //nolint:mnd
+			TiltMax:     90,   //nolint:mnd
+			ZoomMin:     1,
+			ZoomMax:     20, //nolint:mnd
+		},
+		PanSpeedDps:     90, //nolint:mnd
+		TiltSpeedDps:    60, //nolint:mnd
+		ZoomSpeedPerSec: 4,  //nolint:mnd
+		Faults:          Faults{DropRate: 0, ErrorRate: 0, SlowRate: 0, SlowFactor: defaultSlowFactor},
+		Seed:            0,
+	}
+}
+
+func (c Config) validate() error {
+	switch {
+	case c.Cameras <= 0:
+		return fmt.Errorf("%w: cameras must be positive", ErrInvalidConfig)
+	case c.Mode != ModePolling && c.Mode != ModeStream && c.Mode != ModeBoth:
+		return fmt.Errorf("%w: unknown mode %q", ErrInvalidConfig, c.Mode)
+	case c.PollInterval <= 0 || c.StateInterval <= 0 || c.TickInterval <= 0:
+		return fmt.Errorf("%w: intervals must be positive", ErrInvalidConfig)
+	case c.PanSpeedDps <= 0 || c.TiltSpeedDps <= 0 || c.ZoomSpeedPerSec <= 0:
+		return fmt.Errorf("%w: speeds must be positive", ErrInvalidConfig)
+	}
+
+	return validateFaults(c.Faults)
+}
+
+// metadata はカメラの最高速度を CameraOptics が読み取るメタデータで返します。
+func (c Config) metadata() map[string]string {
+	return map[string]string{
+		infrastructure.CameraMetadataPanSpeed:  formatFloat(c.PanSpeedDps),
+		infrastructure.CameraMetadataTiltSpeed: formatFloat(c.TiltSpeedDps),
+		infrastructure.CameraMetadataZoomSpeed: formatFloat(c.ZoomSpeedPerSec),
+	}
+}
+
+func validateFaults(faults Faults) error {
+	for name, rate := range map[string]float64{
+		"drop_rate":  faults.DropRate,
+		"error_rate": faults.ErrorRate,
+		"slow_rate":  faults.SlowRate,
+	} {
+		if rate < 0 || rate > 1 {
+			return fmt.Errorf("%w: %s %v is not within 0.0-1.0", ErrInvalidConfig, name, rate)
+		}
+	}
+
+	if faults.SlowFactor < 0 {
+		return fmt.Errorf("%w: slow_factor must not be negative", ErrInvalidConfig)
+	}
+
+	return nil
+}
+
+// Simulator は仮想カメラの集まりです。
+type Simulator struct {
+	config  Config
+	cameras []*Camera
+
+	cameraClient protov1connect.CameraServiceClient
+	ptzClient    protov1connect.PTZServiceClient
+	fdClient     protov1connect.FDServiceClient
+	streamClient *connect.Client[protov1.StreamControlCommandsRequest, protov1.StreamControlCommandsResponse]
+
+	mu     sync.Mutex
+	faults Faults
+	rng    *rand.Rand
+	wg     sync.WaitGroup
+}
+
+// New はシミュレーターを作成します。制御コマンドのストリームは HTTP/2 を使うため、
+// ModeStream と ModeBoth では HTTP/2（h2c）に対応したクライアントを渡します。
+func New(config Config, httpClient connect.HTTPClient, serverURL string) (*Simulator, error) {
+	if err := config.validate(); err != nil {
+		return nil, err
+	}
+
+	return &Simulator{
+		config:       config,
+		cameras:      nil,
+		cameraClient: protov1connect.NewCameraServiceClient(httpClient, serverURL),
+		ptzClient:    protov1connect.NewPTZServiceClient(httpClient, serverURL),
+		fdClient:     protov1connect.NewFDServiceClient(httpClient, serverURL),
+		streamClient: connect.NewClient[protov1.StreamControlCommandsRequest, protov1.StreamControlCommandsResponse](
+			httpClient, serverURL+handlers.FDServiceControlCommandStreamProcedure,
+		),
+		mu:     sync.Mutex{},
+		faults: config.Faults,
+		rng:    rand.New(rand.NewPCG(config.Seed, config.Seed)), //nolint:gosec
+		wg:     sync.WaitGroup{},
+	}, nil
+}
+
+// Start はカメラを登録し、ctx が終了するまで動かします。登録に失敗した場合は登録済みのカメラを削除してエラーを返します。
+func (s *Simulator) Start(ctx context.Context) error {
+	metadata := s.config.metadata()
+
+	for i := range s.config.Cameras {
+		req := connect.NewRequest(&protov1.RegisterCameraRequest{ //nolint:exhaustruct
+			Name:         fmt.Sprintf("%s-%d", s.config.NamePrefix, i),
+			Mode:         protov1.CameraMode_CAMERA_MODE_AUTONOMOUS,
+			MasterMfId:   s.config.MasterMfID,
+			Capabilities: s.config.Capabilities,
+			Metadata:     metadata,
+		})
+
+		resp, err := s.cameraClient.RegisterCamera(ctx, req)
+		if err != nil {
+			s.Unregister(context.WithoutCancel(ctx))
+
+			return fmt.Errorf("register camera %d: %w", i, err)
+		}
+
+		s.cameras = append(s.cameras, newCamera(s, resp.Msg.GetCamera().GetId()))
+	}
+
+	for _, camera := range s.cameras {
+		camera.start(ctx)
+	}
+
+	return nil
+}
+
+// Wait は ctx の終了後、全てのカメラのゴルーチンが終わるまで待ちます。
+func (s *Simulator) Wait() {
+	s.wg.Wait()
+}
+
+// Unregister は登録したカメラを削除します。
+func (s *Simulator) Unregister(ctx context.Context) {
+	for _, camera := range s.cameras {
+		_, _ = s.cameraClient.UnregisterCamera(ctx, connect.NewRequest(&protov1.UnregisterCameraRequest{
+			CameraId: camera.id,
+		}))
+	}
+}
+
+// Cameras は登録した順の仮想カメラを返します。
+func (s *Simulator) Cameras() []*Camera {
+	return s.cameras
+}
+
+// SetFaults は以降に届く命令に注入する障害を変更します。
+func (s *Simulator) SetFaults(faults Faults) error {
+	if err := validateFaults(faults); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.faults = faults
+
+	return nil
+}
+
+// fault は命令に注入する障害を決めます。遅延しない場合の速度の倍率は 1 です。
+func (s *Simulator) fault() (Outcome, float64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch {
+	case s.rng.Float64() < s.faults.DropRate:
+		return OutcomeDropped, 1
+	case s.rng.Float64() < s.faults.ErrorRate:
+		return OutcomeFailed, 1
+	case s.rng.Float64() < s.faults.SlowRate:
+		if s.faults.SlowFactor > 0 {
+			return "", s.faults.SlowFactor
+		}
+
+		return "", defaultSlowFactor
+	default:
+		return "", 1
+	}
+}
+
+func (s *Simulator) polls() bool {
+	return s.config.Mode == ModePolling || s.config.Mode == ModeBoth
+}
+
+func (s *Simulator) streams() bool {
+	return s.config.Mode == ModeStream || s.config.Mode == ModeBoth
+}
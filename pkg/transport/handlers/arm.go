@@ -0,0 +1,118 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"connectrpc.com/connect"
+	"google.golang.org/protobuf/types/known/structpb"
+
+	protov1 "github.com/anyfld/vistra-operation-control-room/gen/proto/v1"
+	"github.com/anyfld/vistra-operation-control-room/pkg/transport/infrastructure"
+	"github.com/anyfld/vistra-operation-control-room/pkg/transport/usecase"
+)
+
+// アームの移動をキューに追加する FDService の Struct RPC です（structservice.go）。
+const (
+	// FDServiceEnqueueArmMoveProcedure は EnqueueArmMove のパスです。
+	//
+	// リクエスト: {"camera_id", "joint_angles": [度, ...], "speed": 0.0-1.0, "timeout_ms",
+	// "layer": "COMMAND_LAYER_PTZ"|"COMMAND_LAYER_CINEMATIC"}（layer は省略時シネマティック枠）
+	// timeout_ms は各ウェイポイントの結果を待つ時間で、省略時は制御コマンドの既定のタイムアウトです。
+	// レスポンス: {"task_id", "duration_ms", "waypoints": [{"joint_angles", "offset_ms"}, ...]}
+	FDServiceEnqueueArmMoveProcedure = "/v1.FDService/EnqueueArmMove"
+)
+
+var errUnknownCommandLayer = errors.New("unknown command layer")
+
+// NewFDArmHandlers はアームのプロシージャのパスとハンドラーを返します。
+func NewFDArmHandlers(h *FDHandler, opts ...connect.HandlerOption) map[string]http.Handler {
+	return map[string]http.Handler{
+		FDServiceEnqueueArmMoveProcedure: connect.NewUnaryHandler(
+			FDServiceEnqueueArmMoveProcedure,
+			h.EnqueueArmMove,
+			opts...,
+		),
+	}
+}
+
+// EnqueueArmMove はアームの移動をPTZキューに追加します。
+func (h *FDHandler) EnqueueArmMove(
+	ctx context.Context,
+	req *connect.Request[structpb.Struct],
+) (*connect.Response[structpb.Struct], error) {
+	fields := req.Msg.GetFields()
+
+	layer, err := parseCommandLayer(fields["layer"].GetStringValue())
+	if err != nil {
+		return nil, err
+	}
+
+	angles := make([]float32, 0, len(fields["joint_angles"].GetListValue().GetValues()))
+	for _, value := range fields["joint_angles"].GetListValue().GetValues() {
+		angles = append(angles, float32(value.GetNumberValue()))
+	}
+
+	taskID, trajectory, err := h.uc.EnqueueArmMove(
+		ctx,
+		fields["camera_id"].GetStringValue(),
+		&protov1.ArmParameters{
+			JointAngles: angles,
+			Speed:       float32(fields["speed"].GetNumberValue()),
+		},
+		uint32(fields["timeout_ms"].GetNumberValue()),
+		layer,
+	)
+	if err != nil {
+		return nil, armError(err)
+	}
+
+	waypoints := make([]*structpb.Value, 0, len(trajectory.Waypoints))
+	for _, waypoint := range trajectory.Waypoints {
+		jointAngles := make([]*structpb.Value, 0, len(waypoint.JointAngles))
+		for _, angle := range waypoint.JointAngles {
+			jointAngles = append(jointAngles, structpb.NewNumberValue(float64(angle)))
+		}
+
+		waypoints = append(waypoints, structpb.NewStructValue(&structpb.Struct{Fields: map[string]*structpb.Value{
+			"joint_angles": structpb.NewListValue(&structpb.ListValue{Values: jointAngles}),
+			"offset_ms":    structpb.NewNumberValue(float64(waypoint.OffsetMs)),
+		}}))
+	}
+
+	return connect.NewResponse(&structpb.Struct{Fields: map[string]*structpb.Value{
+		"task_id":     structpb.NewStringValue(taskID),
+		"duration_ms": structpb.NewNumberValue(float64(trajectory.DurationMs)),
+		"waypoints":   structpb.NewListValue(&structpb.ListValue{Values: waypoints}),
+	}}), nil
+}
+
+// parseCommandLayer はタスクを追加する枠の名前を解析します。省略時はシネマティック枠です。
+func parseCommandLayer(name string) (protov1.CommandLayer, error) {
+	if name == "" {
+		return protov1.CommandLayer_COMMAND_LAYER_CINEMATIC, nil
+	}
+
+	value, ok := protov1.CommandLayer_value[name]
+	if !ok {
+		return 0, connect.NewError(connect.CodeInvalidArgument, fmt.Errorf("%w: %s", errUnknownCommandLayer, name))
+	}
+
+	return protov1.CommandLayer(value), nil
+}
+
+func armError(err error) error {
+	switch {
+	case errors.Is(err, usecase.ErrCameraNotFound):
+		return connect.NewError(connect.CodeNotFound, err)
+	case errors.Is(err, infrastructure.ErrArmNotSupported),
+		errors.Is(err, infrastructure.ErrInvalidArmCapabilities):
+		return connect.NewError(connect.CodeFailedPrecondition, err)
+	case errors.Is(err, infrastructure.ErrInvalidArmCommand):
+		return connect.NewError(connect.CodeInvalidArgument, err)
+	default:
+		return err
+	}
+}
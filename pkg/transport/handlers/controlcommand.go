@@ -0,0 +1,190 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"connectrpc.com/connect"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/structpb"
+
+	protov1 "github.com/anyfld/vistra-operation-control-room/gen/proto/v1"
+	"github.com/anyfld/vistra-operation-control-room/pkg/transport/infrastructure"
+	"github.com/anyfld/vistra-operation-control-room/pkg/transport/usecase"
+)
+
+// 制御コマンドの状態と履歴を取得する FDService の Struct RPC です（structservice.go）。
+const (
+	// FDServiceGetControlCommandStatusProcedure は GetControlCommandStatus のパスです。
+	//
+	// リクエスト: {"command_id", "wait": bool}（wait が true の場合は終了状態になるまで待ちます）
+	// レスポンス: {"command_id", "camera_id", "type", "state": "pending"|"dispatched"|"completed"|"failed"|"timed_out",
+	// "command": ControlCommand, "result": ControlCommandResult, "created_at_ms", "dispatched_at_ms", "finished_at_ms"}
+	// （result は終了状態の場合のみ）
+	FDServiceGetControlCommandStatusProcedure = "/v1.FDService/GetControlCommandStatus"
+	// FDServiceListControlCommandsProcedure は ListControlCommands のパスです。
+	//
+	// リクエスト: {"camera_id", "since_ms", "until_ms", "types": ["CONTROL_COMMAND_TYPE_PTZ_ABSOLUTE", ...], "limit"}
+	// （すべて省略可。since_ms 以上 until_ms 未満に作成された制御コマンドを返します）
+	// レスポンス: {"commands": [GetControlCommandStatus のレスポンス, ...]}（新しい順）
+	FDServiceListControlCommandsProcedure = "/v1.FDService/ListControlCommands"
+)
+
+var (
+	errUnknownControlCommandType = errors.New("unknown control command type")
+	errInvalidTimeRange          = errors.New("until_ms must be greater than since_ms")
+)
+
+// NewFDControlCommandHandlers は制御コマンドのプロシージャのパスとハンドラーを返します。
+func NewFDControlCommandHandlers(h *FDHandler, opts ...connect.HandlerOption) map[string]http.Handler {
+	return map[string]http.Handler{
+		FDServiceGetControlCommandStatusProcedure: connect.NewUnaryHandler(
+			FDServiceGetControlCommandStatusProcedure,
+			h.GetControlCommandStatus,
+			opts...,
+		),
+		FDServiceListControlCommandsProcedure: connect.NewUnaryHandler(
+			FDServiceListControlCommandsProcedure,
+			h.ListControlCommands,
+			opts...,
+		),
+	}
+}
+
+// GetControlCommandStatus は制御コマンドの状態を返します。
+func (h *FDHandler) GetControlCommandStatus(
+	ctx context.Context,
+	req *connect.Request[structpb.Struct],
+) (*connect.Response[structpb.Struct], error) {
+	fields := req.Msg.GetFields()
+
+	status, err := h.uc.GetControlCommandStatus(
+		ctx,
+		fields["command_id"].GetStringValue(),
+		fields["wait"].GetBoolValue(),
+	)
+	if err != nil {
+		return nil, controlCommandError(err)
+	}
+
+	result, err := newControlCommandStatusStruct(status)
+	if err != nil {
+		return nil, connect.NewError(connect.CodeInternal, err)
+	}
+
+	return connect.NewResponse(result), nil
+}
+
+// ListControlCommands は制御コマンドの履歴を返します。
+func (h *FDHandler) ListControlCommands(
+	ctx context.Context,
+	req *connect.Request[structpb.Struct],
+) (*connect.Response[structpb.Struct], error) {
+	fields := req.Msg.GetFields()
+
+	filter := infrastructure.ControlCommandFilter{
+		CameraID: fields["camera_id"].GetStringValue(),
+		SinceMs:  int64(fields["since_ms"].GetNumberValue()),
+		UntilMs:  int64(fields["until_ms"].GetNumberValue()),
+		Types:    nil,
+		Limit:    int(fields["limit"].GetNumberValue()),
+	}
+
+	if filter.UntilMs > 0 && filter.UntilMs <= filter.SinceMs {
+		return nil, connect.NewError(connect.CodeInvalidArgument, errInvalidTimeRange)
+	}
+
+	for _, value := range fields["types"].GetListValue().GetValues() {
+		commandType, ok := protov1.ControlCommandType_value[value.GetStringValue()]
+		if !ok {
+			return nil, connect.NewError(
+				connect.CodeInvalidArgument,
+				fmt.Errorf("%w: %s", errUnknownControlCommandType, value.GetStringValue()),
+			)
+		}
+
+		filter.Types = append(filter.Types, protov1.ControlCommandType(commandType))
+	}
+
+	statuses, err := h.uc.ListControlCommands(ctx, filter)
+	if err != nil {
+		return nil, controlCommandError(err)
+	}
+
+	commands := make([]*structpb.Value, 0, len(statuses))
+
+	for _, status := range statuses {
+		command, err := newControlCommandStatusStruct(status)
+		if err != nil {
+			return nil, connect.NewError(connect.CodeInternal, err)
+		}
+
+		commands = append(commands, structpb.NewStructValue(command))
+	}
+
+	return connect.NewResponse(&structpb.Struct{Fields: map[string]*structpb.Value{
+		"commands": structpb.NewListValue(&structpb.ListValue{Values: commands}),
+	}}), nil
+}
+
+func newControlCommandStatusStruct(status *infrastructure.ControlCommandStatus) (*structpb.Struct, error) {
+	command, err := protoToStructValue(status.Command)
+	if err != nil {
+		return nil, err
+	}
+
+	fields := map[string]*structpb.Value{
+		"command_id":       structpb.NewStringValue(status.Command.GetCommandId()),
+		"camera_id":        structpb.NewStringValue(status.Command.GetCameraId()),
+		"type":             structpb.NewStringValue(status.Command.GetType().String()),
+		"state":            structpb.NewStringValue(string(status.State)),
+		"command":          command,
+		"created_at_ms":    structpb.NewNumberValue(float64(status.CreatedAtMs)),
+		"dispatched_at_ms": structpb.NewNumberValue(float64(status.DispatchedAtMs)),
+		"finished_at_ms":   structpb.NewNumberValue(float64(status.FinishedAtMs)),
+	}
+
+	if status.Result != nil {
+		result, err := protoToStructValue(status.Result)
+		if err != nil {
+			return nil, err
+		}
+
+		fields["result"] = result
+	}
+
+	return &structpb.Struct{Fields: fields}, nil
+}
+
+// protoToStructValue はメッセージを protojson のフィールド名（proto 名）の Struct に変換します。
+func protoToStructValue(message proto.Message) (*structpb.Value, error) {
+	data, err := protojson.MarshalOptions{UseProtoNames: true}.Marshal(message) //nolint:exhaustruct
+	if err != nil {
+		return nil, err
+	}
+
+	value := new(structpb.Struct)
+	if err := protojson.Unmarshal(data, value); err != nil {
+		return nil, err
+	}
+
+	return structpb.NewStructValue(value), nil
+}
+
+func controlCommandError(err error) error {
+	switch {
+	case errors.Is(err, infrastructure.ErrControlCommandNotFound):
+		return connect.NewError(connect.CodeNotFound, err)
+	case errors.Is(err, usecase.ErrPTZParametersRequired):
+		return connect.NewError(connect.CodeInvalidArgument, err)
+	case errors.Is(err, context.DeadlineExceeded):
+		return connect.NewError(connect.CodeDeadlineExceeded, err)
+	case errors.Is(err, context.Canceled):
+		return connect.NewError(connect.CodeCanceled, err)
+	default:
+		return err
+	}
+}
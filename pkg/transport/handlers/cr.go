@@ -2,17 +2,19 @@ package handlers
 
 import (
 	"context"
+	"errors"
 	"math"
+	"slices"
 	"time"
 
 	"connectrpc.com/connect"
 	protov1 "github.com/anyfld/vistra-operation-control-room/gen/proto/v1"
+	"github.com/anyfld/vistra-operation-control-room/pkg/transport/infrastructure"
 	"github.com/anyfld/vistra-operation-control-room/pkg/transport/usecase"
 )
 
 const (
 	systemStatusInterval = 500 * time.Millisecond
-	cineResultInterval   = 200 * time.Millisecond
 )
 
 type CRHandler struct {
@@ -91,24 +93,11 @@ func (h *CRHandler) GetSystemStatus(
 	ctx context.Context,
 	req *connect.Request[protov1.GetSystemStatusRequest],
 ) (*connect.Response[protov1.GetSystemStatusResponse], error) {
-	mfs, err := h.uc.ListMasterMFs(ctx)
-	if err != nil {
-		return nil, err
-	}
-
-	cams, err := h.uc.ListAllCameras(ctx)
+	status, err := h.uc.GetSystemStatus(ctx)
 	if err != nil {
 		return nil, err
 	}
 
-	status := &protov1.SystemStatus{
-		Health:              protov1.SystemHealthStatus_SYSTEM_HEALTH_STATUS_HEALTHY,
-		OnlineMasterMfCount: safeUint32(len(mfs)),
-		OnlineCameraCount:   safeUint32(len(cams)),
-		ActiveStreamCount:   0,
-		UpdatedAtMs:         time.Now().UnixMilli(),
-	}
-
 	return connect.NewResponse(&protov1.GetSystemStatusResponse{Status: status}), nil
 }
 
@@ -124,24 +113,13 @@ func (h *CRHandler) StreamSystemStatus(
 		default:
 		}
 
-		mfs, err := h.uc.ListMasterMFs(ctx)
-		if err != nil {
-			return err
-		}
-
-		cams, err := h.uc.ListAllCameras(ctx)
+		status, err := h.uc.GetSystemStatus(ctx)
 		if err != nil {
 			return err
 		}
 
 		if err := stream.Send(&protov1.StreamSystemStatusResponse{
-			Status: &protov1.SystemStatus{
-				Health:              protov1.SystemHealthStatus_SYSTEM_HEALTH_STATUS_HEALTHY,
-				OnlineMasterMfCount: safeUint32(len(mfs)),
-				OnlineCameraCount:   safeUint32(len(cams)),
-				ActiveStreamCount:   0,
-				UpdatedAtMs:         time.Now().UnixMilli(),
-			},
+			Status:      status,
 			TimestampMs: time.Now().UnixMilli(),
 		}); err != nil {
 			return err
@@ -157,16 +135,23 @@ func (h *CRHandler) ListAllCameras(
 	ctx context.Context,
 	req *connect.Request[protov1.ListAllCamerasRequest],
 ) (*connect.Response[protov1.ListAllCamerasResponse], error) {
-	cams, err := h.uc.ListAllCameras(ctx)
+	cams, failedPeerIDs, err := h.uc.ListAllCameras(ctx)
 	if err != nil {
 		return nil, err
 	}
 
-	return connect.NewResponse(&protov1.ListAllCamerasResponse{
+	resp := connect.NewResponse(&protov1.ListAllCamerasResponse{
 		Cameras:       cams,
 		NextPageToken: "",
 		TotalCount:    safeUint32(len(cams)),
-	}), nil
+	})
+
+	// 取得できなかった子CRのカメラは一覧に含まれないため、一部が欠けていることをヘッダーで知らせる
+	for _, peerID := range failedPeerIDs {
+		resp.Header().Add(infrastructure.FederationFailedPeersHeader, peerID)
+	}
+
+	return resp, nil
 }
 
 func (h *CRHandler) GetCameraStatus(
@@ -206,6 +191,15 @@ func (h *CRHandler) SendCinematographyInstruction(
 ) (*connect.Response[protov1.SendCinematographyInstructionResponse], error) {
 	res, err := h.uc.SendCinematographyInstruction(ctx, req.Msg)
 	if err != nil {
+		switch {
+		case errors.Is(err, usecase.ErrInstructionRequired), errors.Is(err, usecase.ErrCameraIDRequired):
+			return nil, connect.NewError(connect.CodeInvalidArgument, err)
+		case errors.Is(err, usecase.ErrCameraNotFound):
+			return nil, connect.NewError(connect.CodeNotFound, err)
+		case errors.Is(err, infrastructure.ErrPTZLimitViolation):
+			return nil, connect.NewError(connect.CodeFailedPrecondition, err)
+		}
+
 		return nil, err
 	}
 
@@ -217,29 +211,43 @@ func (h *CRHandler) StreamCinematographyResults(
 	req *connect.Request[protov1.StreamCinematographyResultsRequest],
 	stream *connect.ServerStream[protov1.StreamCinematographyResultsResponse],
 ) error {
-	for range 3 {
-		select {
-		case <-ctx.Done():
-			return ctx.Err()
-		default:
-		}
+	cameraIDs := req.Msg.GetCameraIds()
 
-		if err := stream.Send(&protov1.StreamCinematographyResultsResponse{
-			Result: &protov1.CinematographyResult{
-				InstructionId: "instr-stream",
-				CameraId:      "cam-1",
-				Success:       true,
-				ErrorMessage:  "",
-				AppliedPtz:    nil,
-				CompletedAtMs: time.Now().UnixMilli(),
-			},
-			TimestampMs: time.Now().UnixMilli(),
-		}); err != nil {
-			return err
+	resultCh, err := h.uc.SubscribeCinematographyResults(ctx)
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		if unsubscribeErr := h.uc.UnsubscribeCinematographyResults(ctx, resultCh); unsubscribeErr != nil {
+			_ = unsubscribeErr
 		}
+	}()
 
-		time.Sleep(cineResultInterval)
+	// 購読開始をクライアントに通知するため、先にヘッダーを送信する
+	if err := stream.Send(nil); err != nil {
+		return err
 	}
 
-	return nil
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case result, ok := <-resultCh:
+			if !ok {
+				return nil
+			}
+
+			if len(cameraIDs) > 0 && !slices.Contains(cameraIDs, result.GetCameraId()) {
+				continue
+			}
+
+			if err := stream.Send(&protov1.StreamCinematographyResultsResponse{
+				Result:      result,
+				TimestampMs: time.Now().UnixMilli(),
+			}); err != nil {
+				return err
+			}
+		}
+	}
 }
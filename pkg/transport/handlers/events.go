@@ -0,0 +1,146 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"slices"
+
+	"connectrpc.com/connect"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/types/known/structpb"
+
+	"github.com/anyfld/vistra-operation-control-room/pkg/transport/infrastructure"
+	"github.com/anyfld/vistra-operation-control-room/pkg/transport/usecase"
+)
+
+// EventService は Struct RPC のサービスです（structservice.go）。
+const (
+	// EventServiceName は EventService の完全修飾名です。
+	EventServiceName = "v1.EventService"
+	// EventServiceSubscribeEventsProcedure は SubscribeEvents のパスです。
+	//
+	// リクエスト: {"topics": ["camera", ...], "camera_ids": [...], "types": [...]}（いずれも省略可）
+	// レスポンス: {"sequence", "topic", "type", "resource_id", "camera_id", "timestamp_ms", "payload"}
+	//
+	// 受信が追いつかずイベントを配信できなくなった場合は、RESOURCE_EXHAUSTED でストリームを終了します。
+	EventServiceSubscribeEventsProcedure = "/v1.EventService/SubscribeEvents"
+)
+
+var errEventSubscriptionOverflowed = errors.New(
+	"event subscription overflowed; events after the last received sequence were lost, resubscribe to continue",
+)
+
+type EventHandler struct {
+	uc usecase.EventInteractor
+}
+
+func NewEventHandler(uc usecase.EventInteractor) *EventHandler {
+	return &EventHandler{uc: uc}
+}
+
+// NewEventServiceHandler は EventService のパスとハンドラーを返します。
+func NewEventServiceHandler(h *EventHandler, opts ...connect.HandlerOption) (string, http.Handler) {
+	return newStructServiceHandler(EventServiceName, map[string]http.Handler{
+		EventServiceSubscribeEventsProcedure: connect.NewServerStreamHandler(
+			EventServiceSubscribeEventsProcedure, h.SubscribeEvents, opts...,
+		),
+	})
+}
+
+// SubscribeEvents はトピック・カメラ・種別で絞り込んだイベントを配信します。
+func (h *EventHandler) SubscribeEvents(
+	ctx context.Context,
+	req *connect.Request[structpb.Struct],
+	stream *connect.ServerStream[structpb.Struct],
+) error {
+	fields := req.Msg.GetFields()
+	cameraIDs := structStringList(fields["camera_ids"])
+	eventTypes := structStringList(fields["types"])
+
+	subscription, err := h.uc.SubscribeEvents(ctx, structStringList(fields["topics"]))
+	if err != nil {
+		if errors.Is(err, usecase.ErrInvalidEventTopic) {
+			return connect.NewError(connect.CodeInvalidArgument, err)
+		}
+
+		return err
+	}
+
+	defer h.uc.UnsubscribeEvents(ctx, subscription)
+
+	// 購読開始をクライアントに通知するため、先にヘッダーを送信する
+	if err := stream.Send(nil); err != nil {
+		return err
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case event, ok := <-subscription.Events():
+			if !ok {
+				if subscription.Overflowed() {
+					return connect.NewError(connect.CodeResourceExhausted, errEventSubscriptionOverflowed)
+				}
+
+				return nil
+			}
+
+			if len(cameraIDs) > 0 && !slices.Contains(cameraIDs, event.CameraID) {
+				continue
+			}
+
+			if len(eventTypes) > 0 && !slices.Contains(eventTypes, event.Type) {
+				continue
+			}
+
+			msg, err := newEventStruct(event)
+			if err != nil {
+				return connect.NewError(connect.CodeInternal, err)
+			}
+
+			if err := stream.Send(msg); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func newEventStruct(event *infrastructure.Event) (*structpb.Struct, error) {
+	payload := new(structpb.Struct)
+
+	if event.Payload != nil {
+		data, err := protojson.Marshal(event.Payload)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := protojson.Unmarshal(data, payload); err != nil {
+			return nil, err
+		}
+	}
+
+	return &structpb.Struct{Fields: map[string]*structpb.Value{
+		"sequence":     structpb.NewNumberValue(float64(event.Sequence)),
+		"topic":        structpb.NewStringValue(string(event.Topic)),
+		"type":         structpb.NewStringValue(event.Type),
+		"resource_id":  structpb.NewStringValue(event.ResourceID),
+		"camera_id":    structpb.NewStringValue(event.CameraID),
+		"timestamp_ms": structpb.NewNumberValue(float64(event.TimestampMs)),
+		"payload":      structpb.NewStructValue(payload),
+	}}, nil
+}
+
+func structStringList(value *structpb.Value) []string {
+	list := value.GetListValue().GetValues()
+	result := make([]string, 0, len(list))
+
+	for _, item := range list {
+		if s := item.GetStringValue(); s != "" {
+			result = append(result, s)
+		}
+	}
+
+	return result
+}
@@ -1,9 +1,15 @@
 package handlers
 
 import (
+	"cmp"
 	"context"
 	"errors"
+	"fmt"
+	"io"
 	"log"
+	"net/http"
+	"strconv"
+	"strings"
 	"time"
 
 	"connectrpc.com/connect"
@@ -13,12 +19,11 @@ import (
 )
 
 const (
-	fdPollingIntervalMs   = 500
-	fdDefaultConfidence   = 0.85
-	fdDefaultBoundingBoxX = 0.2
-	fdDefaultBoundingBoxY = 0.2
-	fdDefaultBoundingBoxW = 0.3
-	fdDefaultBoundingBoxH = 0.3
+	fdPollingIntervalMs = 500
+	// PatternMatchingPathPrefix はFDがパターンマッチング用のフレームを送信するHTTP APIのパスです。
+	PatternMatchingPathPrefix = "/pattern-matching"
+
+	maxFrameBodyBytes = 32 << 20
 )
 
 type FDHandler struct {
@@ -88,6 +93,12 @@ func (h *FDHandler) ProcessImage(
 ) (*connect.Response[protov1.ProcessImageResponse], error) {
 	detected, processingTime, err := h.uc.ProcessImage(ctx, req.Msg)
 	if err != nil {
+		if errors.Is(err, infrastructure.ErrInvalidImage) ||
+			errors.Is(err, infrastructure.ErrImageSizeMismatch) ||
+			errors.Is(err, infrastructure.ErrInvalidSubject) {
+			return nil, connect.NewError(connect.CodeInvalidArgument, err)
+		}
+
 		return nil, err
 	}
 
@@ -103,7 +114,7 @@ func (h *FDHandler) StartPatternMatching(
 ) (*connect.Response[protov1.StartPatternMatchingResponse], error) {
 	sessionID, err := h.uc.StartPatternMatching(ctx, req.Msg)
 	if err != nil {
-		return nil, err
+		return nil, patternMatchingError(err)
 	}
 
 	return connect.NewResponse(&protov1.StartPatternMatchingResponse{
@@ -126,6 +137,8 @@ func (h *FDHandler) StopPatternMatching(
 	}), nil
 }
 
+// StreamPatternMatchResults はセッションの検出結果を配信します。
+// セッションが終了する（停止・期限切れ）とストリームも終了します。
 func (h *FDHandler) StreamPatternMatchResults(
 	ctx context.Context,
 	req *connect.Request[protov1.StreamPatternMatchResultsRequest],
@@ -133,52 +146,120 @@ func (h *FDHandler) StreamPatternMatchResults(
 ) error {
 	sessionID := req.Msg.GetSessionId()
 
-	sessID, cameraID, targetSubjects, intervalMs, err := h.uc.GetPatternMatchingSession(ctx, sessionID)
+	resultCh, err := h.uc.SubscribePatternMatchResults(ctx, sessionID)
 	if err != nil {
-		return err
+		return patternMatchingError(err)
 	}
 
-	if sessID == "" {
-		return connect.NewError(
-			connect.CodeNotFound,
-			errors.New("pattern matching session not found"),
-		)
+	defer h.uc.UnsubscribePatternMatchResults(ctx, sessionID, resultCh)
+
+	// 購読開始をクライアントに通知するため、先にヘッダーを送信する
+	if err := stream.Send(nil); err != nil {
+		return err
 	}
 
-	for range 5 {
+	for {
 		select {
 		case <-ctx.Done():
-			return ctx.Err()
-		default:
+			return nil
+		case result, ok := <-resultCh:
+			if !ok {
+				return nil
+			}
+
+			if err := stream.Send(result); err != nil {
+				return err
+			}
 		}
+	}
+}
+
+// PushPatternMatchingFrameHTTP は POST /pattern-matching/sessions/{id}/frames でFDからフレームを受け取ります。
+// フォーマットは Content-Type（image/jpeg, image/png, application/octet-stream は RAW）で指定し、
+// RAW の場合はクエリの width と height が必要です。
+func (h *FDHandler) PushPatternMatchingFrameHTTP() http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		data, err := io.ReadAll(http.MaxBytesReader(writer, request.Body, maxFrameBodyBytes))
+		if err != nil {
+			http.Error(writer, "failed to read request body", http.StatusBadRequest)
 
-		detected := []*protov1.DetectedSubject{}
-		for _, subject := range targetSubjects {
-			detected = append(detected, &protov1.DetectedSubject{
-				Subject:    subject,
-				Confidence: fdDefaultConfidence,
-				DetectedBox: &protov1.BoundingBox{
-					X:      fdDefaultBoundingBoxX,
-					Y:      fdDefaultBoundingBoxY,
-					Width:  fdDefaultBoundingBoxW,
-					Height: fdDefaultBoundingBoxH,
-				},
-			})
+			return
 		}
 
-		if err := stream.Send(&protov1.StreamPatternMatchResultsResponse{
-			SessionId:        sessionID,
-			CameraId:         cameraID,
-			DetectedSubjects: detected,
-			TimestampMs:      time.Now().UnixMilli(),
-		}); err != nil {
-			return err
+		query := request.URL.Query()
+
+		width, widthErr := parseOptionalUint32(query.Get("width"))
+		height, heightErr := parseOptionalUint32(query.Get("height"))
+		timestampMs, timestampErr := strconv.ParseInt(cmp.Or(query.Get("timestamp_ms"), "0"), 10, 64)
+
+		if err := errors.Join(widthErr, heightErr, timestampErr); err != nil {
+			http.Error(writer, err.Error(), http.StatusBadRequest)
+
+			return
+		}
+
+		frame := &protov1.ImageData{
+			Format:      imageFormatFromContentType(request.Header.Get("Content-Type")),
+			Data:        data,
+			Url:         "",
+			Width:       width,
+			Height:      height,
+			TimestampMs: timestampMs,
 		}
 
-		time.Sleep(time.Duration(intervalMs) * time.Millisecond)
+		if err := h.uc.PushPatternMatchingFrame(request.Context(), request.PathValue("id"), frame); err != nil {
+			if errors.Is(err, infrastructure.ErrPatternMatchingSessionNotFound) {
+				http.Error(writer, err.Error(), http.StatusNotFound)
+
+				return
+			}
+
+			http.Error(writer, err.Error(), http.StatusInternalServerError)
+
+			return
+		}
+
+		writer.WriteHeader(http.StatusAccepted)
+	})
+}
+
+func patternMatchingError(err error) error {
+	switch {
+	case errors.Is(err, infrastructure.ErrPatternMatchingSessionNotFound):
+		return connect.NewError(connect.CodeNotFound, err)
+	case errors.Is(err, usecase.ErrCameraOffline):
+		return connect.NewError(connect.CodeFailedPrecondition, err)
+	default:
+		return err
 	}
+}
 
-	return nil
+func imageFormatFromContentType(contentType string) protov1.ImageFormat {
+	mediaType, _, _ := strings.Cut(contentType, ";")
+
+	switch strings.TrimSpace(mediaType) {
+	case "image/jpeg":
+		return protov1.ImageFormat_IMAGE_FORMAT_JPEG
+	case "image/png":
+		return protov1.ImageFormat_IMAGE_FORMAT_PNG
+	case "application/octet-stream":
+		return protov1.ImageFormat_IMAGE_FORMAT_RAW
+	default:
+		return protov1.ImageFormat_IMAGE_FORMAT_UNSPECIFIED
+	}
+}
+
+func parseOptionalUint32(value string) (uint32, error) {
+	if value == "" {
+		return 0, nil
+	}
+
+	parsed, err := strconv.ParseUint(value, 10, 32)
+	if err != nil {
+		return 0, fmt.Errorf("invalid size %q: %w", value, err)
+	}
+
+	return uint32(parsed), nil
 }
 
 func (h *FDHandler) CalculateFraming(
@@ -210,15 +291,15 @@ func (h *FDHandler) StreamControlCommands(
 	}
 
 	if init := message.GetInit(); init != nil {
-		return h.handleInitMessage(ctx, init)
+		return h.handleInitMessage(ctx, init, req.Header())
 	}
 
 	if command := message.GetCommand(); command != nil {
 		return h.handleCommandMessage(ctx, command)
 	}
 
-	if message.GetResult() != nil {
-		return h.handleResultMessage()
+	if result := message.GetResult(); result != nil {
+		return h.handleResultMessage(ctx, result)
 	}
 
 	if state := message.GetState(); state != nil {
@@ -238,6 +319,7 @@ func (h *FDHandler) StreamControlCommands(
 func (h *FDHandler) handleInitMessage(
 	ctx context.Context,
 	init *protov1.StreamControlCommandsInit,
+	header http.Header,
 ) (*connect.Response[protov1.StreamControlCommandsResponse], error) {
 	cameraID := init.GetCameraId()
 	if cameraID == "" {
@@ -251,42 +333,65 @@ func (h *FDHandler) handleInitMessage(
 		)
 	}
 
+	afterSequence, err := parsePTZSequence(header.Get(PTZAfterSequenceHeader))
+	if err != nil {
+		return nil, connect.NewError(connect.CodeInvalidArgument, err)
+	}
+
 	log.Printf(
-		"fd stream control commands init: camera_id=%s",
+		"fd stream control commands init: camera_id=%s after_sequence=%d",
 		cameraID,
+		afterSequence,
 	)
 
-	commandCh, err := h.uc.SubscribePTZCommands(ctx, cameraID)
+	subscription, err := h.uc.SubscribePTZCommands(ctx, cameraID, afterSequence)
 	if err != nil {
-		return nil, err
+		return nil, ptzSubscriptionError(err)
 	}
 
 	defer func() {
-		if unsubscribeErr := h.uc.UnsubscribePTZCommands(ctx, cameraID, commandCh); unsubscribeErr != nil {
+		if unsubscribeErr := h.uc.UnsubscribePTZCommands(ctx, subscription); unsubscribeErr != nil {
 			_ = unsubscribeErr
 		}
 	}()
 
-	return h.waitForPTZEvent(ctx, cameraID, commandCh)
+	return h.waitForPTZEvent(ctx, cameraID, subscription)
 }
 
+// waitForPTZEvent は次のイベントを1件待ちます。応答の PTZSequenceHeader には、次の呼び出しで
+// PTZAfterSequenceHeader に指定する通し番号を設定します。
 func (h *FDHandler) waitForPTZEvent(
 	ctx context.Context,
 	cameraID string,
-	commandCh <-chan *infrastructure.PTZCommandEvent,
+	subscription *infrastructure.PTZSubscription,
 ) (*connect.Response[protov1.StreamControlCommandsResponse], error) {
+	var (
+		resp     *connect.Response[protov1.StreamControlCommandsResponse]
+		err      error
+		sequence = subscription.StartSequence()
+	)
+
 	select {
 	case <-ctx.Done():
 		return nil, ctx.Err()
-	case event, ok := <-commandCh:
+	case event, ok := <-subscription.Events():
 		if !ok || event == nil {
 			return h.handleSubscriptionClosed(cameraID)
 		}
 
-		return h.handlePTZEvent(cameraID, event)
+		sequence = event.Sequence
+		resp, err = h.handlePTZEvent(cameraID, event)
 	case <-time.After(fdPollingIntervalMs * time.Millisecond):
-		return h.handleNoEvents(cameraID)
+		resp, err = h.handleNoEvents(cameraID)
 	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	resp.Header().Set(PTZSequenceHeader, strconv.FormatUint(sequence, 10))
+
+	return resp, nil
 }
 
 func (h *FDHandler) handleSubscriptionClosed(
@@ -405,38 +510,55 @@ func (h *FDHandler) handleCommandMessage(
 		command.GetCommandId(),
 	)
 
-	result, err := h.uc.SendControlCommand(ctx, command)
+	status, err := h.uc.SendControlCommand(ctx, command)
 	if err != nil {
-		return nil, err
+		return nil, controlCommandError(err)
 	}
 
-	if result == nil {
-		return connect.NewResponse(&protov1.StreamControlCommandsResponse{ //nolint:exhaustruct
-			// Command, Result, Status, TimestampMs are optional for empty response
-		}), nil
+	message := "command " + string(status.State)
+	if status.Adjustment != "" {
+		message += " (adjusted: " + status.Adjustment + ")"
 	}
 
-	return connect.NewResponse(&protov1.StreamControlCommandsResponse{ //nolint:exhaustruct
-		Result: result,
+	// Result はサーバー側で処理を終えた制御コマンドの場合のみ設定される。FDの結果は後から報告される
+	return connect.NewResponse(&protov1.StreamControlCommandsResponse{
+		Command: status.Command,
+		Result:  status.Result,
 		Status: &protov1.StreamControlCommandsStatus{
 			Connected: true,
-			Message:   "command accepted",
+			Message:   message,
 		},
 		TimestampMs: time.Now().UnixMilli(),
-		// Command is optional
 	}), nil
 }
 
-func (h *FDHandler) handleResultMessage() (*connect.Response[protov1.StreamControlCommandsResponse], error) {
-	log.Printf("fd stream control commands result received")
+func (h *FDHandler) handleResultMessage(
+	ctx context.Context,
+	result *protov1.ControlCommandResult,
+) (*connect.Response[protov1.StreamControlCommandsResponse], error) {
+	log.Printf(
+		"fd stream control commands result: command_id=%s success=%t",
+		result.GetCommandId(),
+		result.GetSuccess(),
+	)
 
-	return connect.NewResponse(&protov1.StreamControlCommandsResponse{ //nolint:exhaustruct
+	status, err := h.uc.ReportControlCommandResult(ctx, result)
+	if err != nil {
+		if errors.Is(err, infrastructure.ErrControlCommandNotFound) {
+			return nil, connect.NewError(connect.CodeNotFound, err)
+		}
+
+		return nil, err
+	}
+
+	return connect.NewResponse(&protov1.StreamControlCommandsResponse{
+		Command: status.Command,
+		Result:  status.Result,
 		Status: &protov1.StreamControlCommandsStatus{
 			Connected: true,
-			Message:   "command result received",
+			Message:   "command result " + string(status.State),
 		},
 		TimestampMs: time.Now().UnixMilli(),
-		// Command, Result are optional
 	}), nil
 }
 
@@ -1,18 +1,48 @@
 package handlers
 
 import (
-	"encoding/json"
+	"cmp"
+	"errors"
 	"io"
 	"net/http"
 	"strconv"
 	"time"
 
+	"connectrpc.com/connect"
 	protov1 "github.com/anyfld/vistra-operation-control-room/gen/proto/v1"
 	"github.com/anyfld/vistra-operation-control-room/pkg/transport/infrastructure"
 	"github.com/anyfld/vistra-operation-control-room/pkg/transport/usecase"
 	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/structpb"
 )
 
+// Connect クライアントを持たないFD（マイコンなど）向けに、FDService と PTZService.Polling を
+// プレーンな HTTP/JSON で提供します。リクエストとレスポンスの本文は各メッセージの protojson で、
+// フィールド名は proto の名前（snake_case）を使います。エラーはテキストで返し、ステータスコードは
+// Connect のハンドラーが返すエラーコードを Connect プロトコルと同じ規則で変換したものです。
+const (
+	// FDFallbackPathPrefix はFD向けの HTTP/JSON API のパスです。以下を提供します。
+	//
+	//   POST {prefix}/cameras/{id}/state        CameraState を報告する（204）
+	//   POST {prefix}/cameras/{id}/commands     ControlCommand を送り、結果の ControlCommandResult を待つ
+	//   GET  {prefix}/cameras/{id}/commands     制御コマンドと結果をロングポーリングで受け取る（timeout_ms, after_sequence）
+	//   POST {prefix}/cameras/{id}/results      ControlCommandResult を報告する
+	//   POST {prefix}/cameras/{id}/ptz/polling  PollingRequest を送り、PollingResponse を受け取る
+	FDFallbackPathPrefix = "/fd"
+
+	maxFallbackBodyBytes = 1 << 20
+
+	// statusClientClosedRequest は Connect プロトコルが CANCELED に割り当てる HTTP ステータスです。
+	statusClientClosedRequest = 499
+)
+
+var errCameraIDMismatch = errors.New("camera_id does not match the path")
+
+var fallbackMarshalOptions = protojson.MarshalOptions{ //nolint:exhaustruct
+	UseProtoNames: true,
+}
+
 type FDFallbackHandler struct {
 	uc       usecase.FDInteractor
 	cameraUC usecase.CameraInteractor
@@ -25,6 +55,7 @@ func NewFDFallbackHandler(uc usecase.FDInteractor, cameraUC usecase.CameraIntera
 	}
 }
 
+// ReportCameraStateHTTP は POST /fd/cameras/{id}/state でFDからカメラの状態を受け取ります。
 func (h *FDHandler) ReportCameraStateHTTP() http.Handler {
 	fallback := NewFDFallbackHandler(h.uc, h.cameraUC)
 
@@ -35,34 +66,17 @@ func (h *FDHandler) ReportCameraStateHTTP() http.Handler {
 			return
 		}
 
-		defer func() {
-			if closeErr := request.Body.Close(); closeErr != nil {
-				_ = closeErr
-			}
-		}()
-
-		body, err := io.ReadAll(request.Body)
-		if err != nil {
-			http.Error(writer, "failed to read request body", http.StatusBadRequest)
-
-			return
-		}
-
 		state := &protov1.CameraState{} //nolint:exhaustruct
-		if err := protojson.Unmarshal(body, state); err != nil {
-			http.Error(writer, "invalid request body", http.StatusBadRequest)
-
+		if !readFallbackRequest(writer, request, state) {
 			return
 		}
 
-		if state.GetCameraId() == "" {
-			http.Error(writer, "camera_id is required", http.StatusBadRequest)
-
+		if !resolveFallbackCameraID(writer, request, &state.CameraId) {
 			return
 		}
 
 		if _, err := fallback.uc.ReportCameraState(request.Context(), state); err != nil {
-			http.Error(writer, "failed to report camera state", http.StatusInternalServerError)
+			writeFallbackError(writer, "failed to report camera state", err)
 
 			return
 		}
@@ -75,7 +89,7 @@ func (h *FDHandler) ReportCameraStateHTTP() http.Handler {
 				state.GetStatus(),
 			)
 			if err != nil {
-				http.Error(writer, "failed to update camera state", http.StatusInternalServerError)
+				writeFallbackError(writer, "failed to update camera state", err)
 
 				return
 			}
@@ -85,6 +99,8 @@ func (h *FDHandler) ReportCameraStateHTTP() http.Handler {
 	})
 }
 
+// SendControlCommandHTTP は POST /fd/cameras/{id}/commands で制御コマンドを送り、
+// FDが結果を報告するかタイムアウトするまで待って ControlCommandResult を返します。
 func (h *FDHandler) SendControlCommandHTTP() http.Handler {
 	fallback := NewFDFallbackHandler(h.uc, h.cameraUC)
 
@@ -95,62 +111,73 @@ func (h *FDHandler) SendControlCommandHTTP() http.Handler {
 			return
 		}
 
-		defer func() {
-			if closeErr := request.Body.Close(); closeErr != nil {
-				_ = closeErr
-			}
-		}()
+		command := &protov1.ControlCommand{} //nolint:exhaustruct
+		if !readFallbackRequest(writer, request, command) {
+			return
+		}
+
+		if !resolveFallbackCameraID(writer, request, &command.CameraId) {
+			return
+		}
 
-		body, err := io.ReadAll(request.Body)
+		status, err := fallback.uc.SendControlCommand(request.Context(), command)
 		if err != nil {
-			http.Error(writer, "failed to read request body", http.StatusBadRequest)
+			writeFallbackError(writer, "failed to send control command", err)
 
 			return
 		}
 
-		command := &protov1.ControlCommand{} //nolint:exhaustruct
-		if err := protojson.Unmarshal(body, command); err != nil {
-			http.Error(writer, "invalid request body", http.StatusBadRequest)
+		// FDが結果を報告するか、タイムアウトするまで待つ
+		status, err = fallback.uc.GetControlCommandStatus(request.Context(), status.Command.GetCommandId(), true)
+		if err != nil {
+			writeFallbackError(writer, "failed to wait for control command result", err)
 
 			return
 		}
 
-		if command.GetCameraId() == "" {
-			http.Error(writer, "camera_id is required", http.StatusBadRequest)
+		writeProtoJSON(writer, http.StatusOK, status.Result)
+	})
+}
+
+// ReportControlCommandResultHTTP は POST /fd/cameras/{id}/results でFDから制御コマンドの結果を受け取り、
+// 結果を反映した ControlCommandResult を返します。
+func (h *FDHandler) ReportControlCommandResultHTTP() http.Handler {
+	fallback := NewFDFallbackHandler(h.uc, h.cameraUC)
+
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		if request.Method != http.MethodPost {
+			http.Error(writer, "method not allowed", http.StatusMethodNotAllowed)
+
+			return
+		}
 
+		result := &protov1.ControlCommandResult{} //nolint:exhaustruct
+		if !readFallbackRequest(writer, request, result) {
 			return
 		}
 
-		result, err := fallback.uc.SendControlCommand(request.Context(), command)
-		if err != nil {
-			http.Error(writer, "failed to send control command", http.StatusInternalServerError)
+		if result.GetCommandId() == "" {
+			http.Error(writer, "command_id is required", http.StatusBadRequest)
 
 			return
 		}
 
-		writer.Header().Set("Content-Type", "application/json")
-
-		encoded, err := protojson.MarshalOptions{ //nolint:exhaustruct
-			UseProtoNames: true,
-		}.Marshal(result)
+		status, err := fallback.uc.ReportControlCommandResult(request.Context(), result)
 		if err != nil {
-			http.Error(writer, "failed to encode response", http.StatusInternalServerError)
+			writeFallbackError(writer, "failed to report control command result", err)
 
 			return
 		}
 
-		if _, err := writer.Write(encoded); err != nil {
-			http.Error(writer, "failed to write response", http.StatusInternalServerError)
-		}
+		writeProtoJSON(writer, http.StatusOK, status.Result)
 	})
 }
 
-type pollControlCommandsResponse struct {
-	Command     *protov1.ControlCommand       `json:"command,omitempty"`
-	Result      *protov1.ControlCommandResult `json:"result,omitempty"`
-	TimestampMs int64                         `json:"timestampMs,omitempty"`
-}
-
+// PollControlCommandsHTTP は GET /fd/cameras/{id}/commands で制御コマンドと結果を1件ずつ返します。
+// 応答は {"sequence", "command", "result", "timestamp_ms"} で、sequence は次のポーリングの after_sequence に
+// 指定する通し番号です。command と result は届いた方だけを設定します。
+// timeout_ms（省略時は30秒）の間に届かなければ 204 を返します。after_sequence には前回の応答の sequence を
+// 指定し、再送用のバッファから消えている場合は 410 を返します。
 func (h *FDHandler) PollControlCommandsHTTP() http.Handler {
 	fallback := NewFDFallbackHandler(h.uc, h.cameraUC)
 
@@ -159,26 +186,42 @@ func (h *FDHandler) PollControlCommandsHTTP() http.Handler {
 			return
 		}
 
-		cameraID := request.URL.Query().Get("camera_id")
+		cameraID := fallbackCameraID(request)
 		timeoutMs := h.parseTimeoutMs(request)
 
 		ctx := request.Context()
 
-		commandCh, err := fallback.uc.SubscribePTZCommands(ctx, cameraID)
+		afterSequence, err := parsePTZSequence(request.URL.Query().Get("after_sequence"))
+		if err != nil {
+			http.Error(writer, "invalid after_sequence", http.StatusBadRequest)
+
+			return
+		}
+
+		subscription, err := fallback.uc.SubscribePTZCommands(ctx, cameraID, afterSequence)
+		if errors.Is(err, infrastructure.ErrPTZReplayGap) {
+			// 取りこぼしたイベントを再送できないため、after_sequence を省略して購読し直してもらう
+			http.Error(writer, err.Error(), http.StatusGone)
+
+			return
+		}
+
 		if err != nil {
-			http.Error(writer, "failed to subscribe to commands", http.StatusInternalServerError)
+			writeFallbackError(writer, "failed to subscribe to commands", err)
 
 			return
 		}
 
 		defer func() {
-			unsubscribeErr := fallback.uc.UnsubscribePTZCommands(ctx, cameraID, commandCh)
+			unsubscribeErr := fallback.uc.UnsubscribePTZCommands(ctx, subscription)
 			if unsubscribeErr != nil {
 				_ = unsubscribeErr
 			}
 		}()
 
-		h.handlePollResponse(writer, request, commandCh, timeoutMs)
+		writer.Header().Set(PTZSequenceHeader, strconv.FormatUint(subscription.StartSequence(), 10))
+
+		h.handlePollResponse(writer, request, subscription.Events(), timeoutMs)
 	})
 }
 
@@ -192,8 +235,7 @@ func (h *FDHandler) validatePollRequest(
 		return false
 	}
 
-	cameraID := request.URL.Query().Get("camera_id")
-	if cameraID == "" {
+	if fallbackCameraID(request) == "" {
 		http.Error(writer, "camera_id is required", http.StatusBadRequest)
 
 		return false
@@ -251,15 +293,153 @@ func (h *FDHandler) writePollResponse(
 	writer http.ResponseWriter,
 	event *infrastructure.PTZCommandEvent,
 ) {
-	response := &pollControlCommandsResponse{
-		Command:     event.Command,
-		Result:      event.Result,
-		TimestampMs: event.TimestampMs,
+	response := &structpb.Struct{Fields: map[string]*structpb.Value{
+		"sequence":     structpb.NewNumberValue(float64(event.Sequence)),
+		"timestamp_ms": structpb.NewNumberValue(float64(event.TimestampMs)),
+	}}
+
+	for name, message := range map[string]proto.Message{"command": event.Command, "result": event.Result} {
+		if !message.ProtoReflect().IsValid() {
+			continue
+		}
+
+		value, err := protoStructValue(message)
+		if err != nil {
+			http.Error(writer, "failed to encode response", http.StatusInternalServerError)
+
+			return
+		}
+
+		response.Fields[name] = value
+	}
+
+	writer.Header().Set(PTZSequenceHeader, strconv.FormatUint(event.Sequence, 10))
+
+	writeProtoJSON(writer, http.StatusOK, response)
+}
+
+// fallbackCameraID はパスのカメラIDを返します。パスにない場合はクエリの camera_id を使います。
+func fallbackCameraID(request *http.Request) string {
+	return cmp.Or(request.PathValue("id"), request.URL.Query().Get("camera_id"))
+}
+
+// resolveFallbackCameraID は本文の camera_id をパスのカメラIDで補います。
+// 両方が指定されていて一致しない場合や、どちらもない場合は 400 を返して false を返します。
+func resolveFallbackCameraID(writer http.ResponseWriter, request *http.Request, cameraID *string) bool {
+	pathCameraID := fallbackCameraID(request)
+
+	switch {
+	case *cameraID == "" && pathCameraID == "":
+		http.Error(writer, "camera_id is required", http.StatusBadRequest)
+
+		return false
+	case *cameraID == "":
+		*cameraID = pathCameraID
+	case pathCameraID != "" && *cameraID != pathCameraID:
+		http.Error(writer, errCameraIDMismatch.Error(), http.StatusBadRequest)
+
+		return false
+	}
+
+	return true
+}
+
+// readFallbackRequest は本文を protojson として message に読み込みます。空の本文は空のメッセージとして扱います。
+// 読み込めない場合は 400 を返して false を返します。
+func readFallbackRequest(writer http.ResponseWriter, request *http.Request, message proto.Message) bool {
+	body, err := io.ReadAll(http.MaxBytesReader(writer, request.Body, maxFallbackBodyBytes))
+	if err != nil {
+		http.Error(writer, "failed to read request body", http.StatusBadRequest)
+
+		return false
+	}
+
+	if len(body) == 0 {
+		return true
+	}
+
+	if err := protojson.Unmarshal(body, message); err != nil {
+		http.Error(writer, "invalid request body: "+err.Error(), http.StatusBadRequest)
+
+		return false
+	}
+
+	return true
+}
+
+func writeProtoJSON(writer http.ResponseWriter, status int, message proto.Message) {
+	encoded, err := fallbackMarshalOptions.Marshal(message)
+	if err != nil {
+		http.Error(writer, "failed to encode response", http.StatusInternalServerError)
+
+		return
 	}
 
 	writer.Header().Set("Content-Type", "application/json")
+	writer.WriteHeader(status)
+
+	if _, err := writer.Write(encoded); err != nil {
+		_ = err
+	}
+}
+
+// protoStructValue は message を proto の名前をフィールド名とする google.protobuf.Struct の値に変換します。
+func protoStructValue(message proto.Message) (*structpb.Value, error) {
+	data, err := fallbackMarshalOptions.Marshal(message)
+	if err != nil {
+		return nil, err
+	}
+
+	value := new(structpb.Struct)
+	if err := protojson.Unmarshal(data, value); err != nil {
+		return nil, err
+	}
+
+	return structpb.NewStructValue(value), nil
+}
+
+// writeFallbackError は err を Connect のハンドラーと同じエラーコードに変換し、
+// Connect プロトコルがそのコードに割り当てる HTTP ステータスで返します。
+func writeFallbackError(writer http.ResponseWriter, message string, err error) {
+	err = fallbackError(err)
+
+	http.Error(writer, message+": "+err.Error(), fallbackHTTPStatus(connect.CodeOf(err)))
+}
+
+func fallbackError(err error) error {
+	if errors.Is(err, usecase.ErrCameraNotFound) {
+		return connect.NewError(connect.CodeNotFound, err)
+	}
+
+	return ptzSubscriptionError(controlCommandError(err))
+}
 
-	if err := json.NewEncoder(writer).Encode(response); err != nil {
-		http.Error(writer, "failed to write response", http.StatusInternalServerError)
+// fallbackHTTPStatus は Connect プロトコルの仕様に従って、エラーコードを HTTP ステータスに変換します。
+func fallbackHTTPStatus(code connect.Code) int {
+	switch code {
+	case connect.CodeCanceled:
+		return statusClientClosedRequest
+	case connect.CodeInvalidArgument, connect.CodeFailedPrecondition, connect.CodeOutOfRange:
+		return http.StatusBadRequest
+	case connect.CodeDeadlineExceeded:
+		return http.StatusGatewayTimeout
+	case connect.CodeNotFound:
+		return http.StatusNotFound
+	case connect.CodeAlreadyExists, connect.CodeAborted:
+		return http.StatusConflict
+	case connect.CodePermissionDenied:
+		return http.StatusForbidden
+	case connect.CodeResourceExhausted:
+		return http.StatusTooManyRequests
+	case connect.CodeUnimplemented:
+		return http.StatusNotImplemented
+	case connect.CodeUnavailable:
+		return http.StatusServiceUnavailable
+	case connect.CodeUnauthenticated:
+		return http.StatusUnauthorized
+	case connect.CodeUnknown, connect.CodeInternal, connect.CodeDataLoss:
+		return http.StatusInternalServerError
+	default:
+		return http.StatusInternalServerError
 	}
 }
@@ -0,0 +1,246 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"connectrpc.com/connect"
+
+	protov1 "github.com/anyfld/vistra-operation-control-room/gen/proto/v1"
+	"github.com/anyfld/vistra-operation-control-room/pkg/transport/infrastructure"
+)
+
+// 生成コードの StreamControlCommands は単項RPCのため、FDは init を定期的に送り直して制御コマンドを受け取る必要があり、
+// 呼び出しの間に配信された制御コマンドを取りこぼします。同じメッセージを使う双方向ストリームを
+// FDService のプロシージャとして手動で登録します。HTTP/2 が必要なため、HTTP/1.1 のクライアントは
+// 単項の StreamControlCommands を使います。
+const (
+	// FDServiceControlCommandStreamProcedure は ControlCommandStream のパスです。
+	//
+	// FDは最初に init を送ります。以降は同じストリームで制御コマンドと結果を受信し続け、
+	// result と state（操作卓からの command も可）を送ります。送ったメッセージには単項RPCと同じ応答を返します。
+	FDServiceControlCommandStreamProcedure = "/v1.FDService/ControlCommandStream"
+)
+
+// StreamControlCommands のメッセージは配信の通し番号を持たないため、ヘッダーで受け渡します。
+// 通し番号はカメラごとに 1 から振られ、欠けることなく 1 ずつ増えます。
+const (
+	// PTZAfterSequenceHeader は最後に受信したイベントの通し番号です。init の呼び出し（ストリームの場合は開始時）に
+	// 指定すると、その次のイベントから受信を再開します。省略すると最後に配信した制御コマンドを1件受信します。
+	PTZAfterSequenceHeader = "Vistra-After-Sequence"
+	// PTZSequenceHeader は応答のイベントの通し番号です。ストリームの場合は開始時点の通し番号で、届くイベントの
+	// 通し番号は PTZAfterSequenceHeader を指定した場合はその次から、省略した場合は最初の再送の1件を除いて
+	// この値の次から 1 ずつ増えます。
+	PTZSequenceHeader = "Vistra-Sequence"
+)
+
+var (
+	errStreamInitRequired        = errors.New("first message must be init with camera_id")
+	errPTZSubscriptionOverflowed = errors.New(
+		"ptz subscription overflowed; reconnect with " + PTZAfterSequenceHeader + " set to the last received sequence",
+	)
+)
+
+// NewFDStreamHandlers は双方向ストリームのプロシージャのパスとハンドラーを返します。
+func NewFDStreamHandlers(h *FDHandler, opts ...connect.HandlerOption) map[string]http.Handler {
+	return map[string]http.Handler{
+		FDServiceControlCommandStreamProcedure: connect.NewBidiStreamHandler(
+			FDServiceControlCommandStreamProcedure,
+			h.ControlCommandStream,
+			opts...,
+		),
+	}
+}
+
+// ControlCommandStream はFDとの双方向ストリームです。クライアントが送信を終えるか切断するまで購読を続けます。
+func (h *FDHandler) ControlCommandStream(
+	ctx context.Context,
+	stream *connect.BidiStream[protov1.StreamControlCommandsRequest, protov1.StreamControlCommandsResponse],
+) error {
+	first, err := stream.Receive()
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+
+		return err
+	}
+
+	cameraID := first.GetInit().GetCameraId()
+	if cameraID == "" {
+		return connect.NewError(connect.CodeInvalidArgument, errStreamInitRequired)
+	}
+
+	log.Printf("fd control command stream connected: camera_id=%s", cameraID)
+	defer log.Printf("fd control command stream closed: camera_id=%s", cameraID)
+
+	afterSequence, err := parsePTZSequence(stream.RequestHeader().Get(PTZAfterSequenceHeader))
+	if err != nil {
+		return connect.NewError(connect.CodeInvalidArgument, err)
+	}
+
+	subscription, err := h.uc.SubscribePTZCommands(ctx, cameraID, afterSequence)
+	if err != nil {
+		return ptzSubscriptionError(err)
+	}
+
+	// ctx はストリームの終了時に取り消されるため、購読の解除には取り消されないコンテキストを使う
+	unsubscribeCtx := context.WithoutCancel(ctx)
+
+	defer func() {
+		if unsubscribeErr := h.uc.UnsubscribePTZCommands(unsubscribeCtx, subscription); unsubscribeErr != nil {
+			log.Printf("fd control command stream unsubscribe failed: camera_id=%s error=%v", cameraID, unsubscribeErr)
+		}
+	}()
+
+	stream.ResponseHeader().Set(PTZSequenceHeader, strconv.FormatUint(subscription.StartSequence(), 10))
+
+	if err := stream.Send(newStreamStatusResponse(true, "stream connected for camera: "+cameraID)); err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// Send は同時に呼び出せないため、受信したメッセージへの応答もこのループから送る
+	responses := make(chan *protov1.StreamControlCommandsResponse)
+	received := make(chan error, 1)
+
+	go func() {
+		received <- h.receiveControlCommandStream(ctx, cameraID, stream, responses)
+	}()
+
+	for {
+		var response *protov1.StreamControlCommandsResponse
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case err := <-received:
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+
+			return err
+		case response = <-responses:
+		case event, ok := <-subscription.Events():
+			if !ok && subscription.Overflowed() {
+				return connect.NewError(connect.CodeResourceExhausted, errPTZSubscriptionOverflowed)
+			}
+
+			if !ok || event == nil {
+				return stream.Send(newStreamStatusResponse(false, "subscription closed for camera: "+cameraID))
+			}
+
+			resp, err := h.handlePTZEvent(cameraID, event)
+			if err != nil {
+				return err
+			}
+
+			response = resp.Msg
+		}
+
+		if err := stream.Send(response); err != nil {
+			return err
+		}
+	}
+}
+
+// receiveControlCommandStream はクライアントから受信したメッセージを処理し、応答を responses に渡します。
+// メッセージの処理に失敗してもストリームは閉じず、エラーを Status で返します。
+func (h *FDHandler) receiveControlCommandStream(
+	ctx context.Context,
+	cameraID string,
+	stream *connect.BidiStream[protov1.StreamControlCommandsRequest, protov1.StreamControlCommandsResponse],
+	responses chan<- *protov1.StreamControlCommandsResponse,
+) error {
+	for {
+		message, err := stream.Receive()
+		if err != nil {
+			return err
+		}
+
+		response := h.handleStreamMessage(ctx, cameraID, message)
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case responses <- response:
+		}
+	}
+}
+
+func (h *FDHandler) handleStreamMessage(
+	ctx context.Context,
+	cameraID string,
+	message *protov1.StreamControlCommandsRequest,
+) *protov1.StreamControlCommandsResponse {
+	var (
+		resp *connect.Response[protov1.StreamControlCommandsResponse]
+		err  error
+	)
+
+	switch {
+	case message.GetInit() != nil:
+		return newStreamStatusResponse(true, "stream already initialized for camera: "+cameraID)
+	case message.GetCommand() != nil:
+		command := message.GetCommand()
+		if command.GetCameraId() == "" {
+			command.CameraId = cameraID
+		}
+
+		resp, err = h.handleCommandMessage(ctx, command)
+	case message.GetResult() != nil:
+		resp, err = h.handleResultMessage(ctx, message.GetResult())
+	case message.GetState() != nil:
+		resp, err = h.handleStateMessage(ctx, message.GetState())
+	default:
+		return newStreamStatusResponse(true, "no operation")
+	}
+
+	if err != nil {
+		log.Printf("fd control command stream message failed: camera_id=%s error=%v", cameraID, err)
+
+		return newStreamStatusResponse(true, err.Error())
+	}
+
+	return resp.Msg
+}
+
+func newStreamStatusResponse(connected bool, message string) *protov1.StreamControlCommandsResponse {
+	return &protov1.StreamControlCommandsResponse{ //nolint:exhaustruct
+		Status: &protov1.StreamControlCommandsStatus{
+			Connected: connected,
+			Message:   message,
+		},
+		TimestampMs: time.Now().UnixMilli(),
+		// Command, Result are optional
+	}
+}
+
+func parsePTZSequence(value string) (uint64, error) {
+	if value == "" {
+		return 0, nil
+	}
+
+	sequence, err := strconv.ParseUint(value, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s: %w", PTZAfterSequenceHeader, err)
+	}
+
+	return sequence, nil
+}
+
+func ptzSubscriptionError(err error) error {
+	if errors.Is(err, infrastructure.ErrPTZReplayGap) {
+		return connect.NewError(connect.CodeOutOfRange, err)
+	}
+
+	return err
+}
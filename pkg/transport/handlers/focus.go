@@ -0,0 +1,197 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"connectrpc.com/connect"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/types/known/structpb"
+
+	protov1 "github.com/anyfld/vistra-operation-control-room/gen/proto/v1"
+	"github.com/anyfld/vistra-operation-control-room/pkg/transport/infrastructure"
+	"github.com/anyfld/vistra-operation-control-room/pkg/transport/usecase"
+)
+
+// フォーカスを扱う FDService の Struct RPC です（structservice.go）。フォーカス値は 0.0-1.0 です。
+const (
+	// FDServiceSetFocusModeProcedure は SetFocusMode のパスです。
+	//
+	// リクエスト: {"camera_id", "mode": "auto"|"manual"}
+	// レスポンス: GetControlCommandStatus のレスポンス（配信したFOCUSの制御コマンド）
+	FDServiceSetFocusModeProcedure = "/v1.FDService/SetFocusMode"
+	// FDServiceGetFocusStateProcedure は GetFocusState のパスです。
+	//
+	// リクエスト: {"camera_id"}
+	// レスポンス: {"camera_id", "mode", "current_focus", "has_current_focus", "supports_autofocus"}
+	FDServiceGetFocusStateProcedure = "/v1.FDService/GetFocusState"
+	// FDServiceEnqueueFocusMoveProcedure は EnqueueFocusMove のパスです。
+	//
+	// リクエスト: {"camera_id", "focus", "duration_ms", "layer": "COMMAND_LAYER_PTZ"|"COMMAND_LAYER_CINEMATIC"}
+	// （duration_ms が正の場合はラックフォーカス、layer は省略時シネマティック枠）
+	// レスポンス: {"task_id"}
+	FDServiceEnqueueFocusMoveProcedure = "/v1.FDService/EnqueueFocusMove"
+	// FDServiceEnqueueAbsoluteMoveProcedure は EnqueueAbsoluteMove のパスです。
+	//
+	// リクエスト: {"camera_id", "absolute_move": AbsoluteMoveCommand, "focus", "focus_duration_ms"}
+	// （focus を省略するとフォーカスを動かしません）
+	// レスポンス: {"task_id", "adjustment"}（adjustment はカメラの制限で補正した理由）
+	FDServiceEnqueueAbsoluteMoveProcedure = "/v1.FDService/EnqueueAbsoluteMove"
+)
+
+var errAbsoluteMoveRequired = errors.New("absolute_move is required")
+
+// NewFDFocusHandlers はフォーカスのプロシージャのパスとハンドラーを返します。
+func NewFDFocusHandlers(h *FDHandler, opts ...connect.HandlerOption) map[string]http.Handler {
+	return map[string]http.Handler{
+		FDServiceSetFocusModeProcedure: connect.NewUnaryHandler(
+			FDServiceSetFocusModeProcedure,
+			h.SetFocusMode,
+			opts...,
+		),
+		FDServiceGetFocusStateProcedure: connect.NewUnaryHandler(
+			FDServiceGetFocusStateProcedure,
+			h.GetFocusState,
+			opts...,
+		),
+		FDServiceEnqueueFocusMoveProcedure: connect.NewUnaryHandler(
+			FDServiceEnqueueFocusMoveProcedure,
+			h.EnqueueFocusMove,
+			opts...,
+		),
+		FDServiceEnqueueAbsoluteMoveProcedure: connect.NewUnaryHandler(
+			FDServiceEnqueueAbsoluteMoveProcedure,
+			h.EnqueueAbsoluteMove,
+			opts...,
+		),
+	}
+}
+
+// SetFocusMode はカメラのフォーカスをオートフォーカスまたは手動フォーカスに切り替えます。
+func (h *FDHandler) SetFocusMode(
+	ctx context.Context,
+	req *connect.Request[structpb.Struct],
+) (*connect.Response[structpb.Struct], error) {
+	fields := req.Msg.GetFields()
+
+	status, err := h.uc.SetFocusMode(
+		ctx,
+		fields["camera_id"].GetStringValue(),
+		infrastructure.FocusMode(fields["mode"].GetStringValue()),
+	)
+	if err != nil {
+		return nil, focusError(err)
+	}
+
+	msg, err := newControlCommandStatusStruct(status)
+	if err != nil {
+		return nil, connect.NewError(connect.CodeInternal, err)
+	}
+
+	return connect.NewResponse(msg), nil
+}
+
+// GetFocusState はカメラのフォーカスの状態を返します。
+func (h *FDHandler) GetFocusState(
+	ctx context.Context,
+	req *connect.Request[structpb.Struct],
+) (*connect.Response[structpb.Struct], error) {
+	state, err := h.uc.GetFocusState(ctx, req.Msg.GetFields()["camera_id"].GetStringValue())
+	if err != nil {
+		return nil, focusError(err)
+	}
+
+	return connect.NewResponse(&structpb.Struct{Fields: map[string]*structpb.Value{
+		"camera_id":          structpb.NewStringValue(state.CameraID),
+		"mode":               structpb.NewStringValue(string(state.Mode)),
+		"current_focus":      structpb.NewNumberValue(float64(state.CurrentFocus)),
+		"has_current_focus":  structpb.NewBoolValue(state.HasCurrentFocus),
+		"supports_autofocus": structpb.NewBoolValue(state.SupportsAutofocus),
+	}}), nil
+}
+
+// EnqueueFocusMove はフォーカスの移動（ラックフォーカス）をPTZキューに追加します。
+func (h *FDHandler) EnqueueFocusMove(
+	ctx context.Context,
+	req *connect.Request[structpb.Struct],
+) (*connect.Response[structpb.Struct], error) {
+	fields := req.Msg.GetFields()
+
+	layer, err := parseCommandLayer(fields["layer"].GetStringValue())
+	if err != nil {
+		return nil, err
+	}
+
+	taskID, err := h.uc.EnqueueFocusMove(
+		ctx,
+		fields["camera_id"].GetStringValue(),
+		&infrastructure.FocusMove{
+			Target:     float32(fields["focus"].GetNumberValue()),
+			DurationMs: uint32(fields["duration_ms"].GetNumberValue()),
+		},
+		layer,
+	)
+	if err != nil {
+		return nil, focusError(err)
+	}
+
+	return connect.NewResponse(&structpb.Struct{Fields: map[string]*structpb.Value{
+		"task_id": structpb.NewStringValue(taskID),
+	}}), nil
+}
+
+// EnqueueAbsoluteMove はフォーカスの目標を持つ絶対移動をPTZキューに追加します。
+func (h *FDHandler) EnqueueAbsoluteMove(
+	ctx context.Context,
+	req *connect.Request[structpb.Struct],
+) (*connect.Response[structpb.Struct], error) {
+	fields := req.Msg.GetFields()
+
+	value := fields["absolute_move"].GetStructValue()
+	if value == nil {
+		return nil, connect.NewError(connect.CodeInvalidArgument, errAbsoluteMoveRequired)
+	}
+
+	data, err := protojson.Marshal(value)
+	if err != nil {
+		return nil, connect.NewError(connect.CodeInvalidArgument, err)
+	}
+
+	move := new(protov1.AbsoluteMoveCommand)
+	if err := protojson.Unmarshal(data, move); err != nil {
+		return nil, connect.NewError(connect.CodeInvalidArgument, err)
+	}
+
+	var focus *infrastructure.FocusMove
+
+	if target, ok := fields["focus"]; ok {
+		focus = &infrastructure.FocusMove{
+			Target:     float32(target.GetNumberValue()),
+			DurationMs: uint32(fields["focus_duration_ms"].GetNumberValue()),
+		}
+	}
+
+	taskID, adjustment, err := h.uc.EnqueueAbsoluteMove(ctx, fields["camera_id"].GetStringValue(), move, focus)
+	if err != nil {
+		return nil, focusError(err)
+	}
+
+	return connect.NewResponse(&structpb.Struct{Fields: map[string]*structpb.Value{
+		"task_id":    structpb.NewStringValue(taskID),
+		"adjustment": structpb.NewStringValue(adjustment),
+	}}), nil
+}
+
+func focusError(err error) error {
+	switch {
+	case errors.Is(err, usecase.ErrCameraNotFound):
+		return connect.NewError(connect.CodeNotFound, err)
+	case errors.Is(err, infrastructure.ErrAutofocusNotSupported), errors.Is(err, usecase.ErrCameraFocusUnknown):
+		return connect.NewError(connect.CodeFailedPrecondition, err)
+	case errors.Is(err, infrastructure.ErrInvalidFocusCommand), errors.Is(err, infrastructure.ErrPTZLimitViolation):
+		return connect.NewError(connect.CodeInvalidArgument, err)
+	default:
+		return err
+	}
+}
@@ -0,0 +1,155 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"connectrpc.com/connect"
+	"github.com/anyfld/vistra-operation-control-room/pkg/transport/usecase"
+)
+
+const (
+	// LeaderAddressHeader はスタンバイが返すエラーに付与されるリーダーのアドレスです。
+	// FDはこのアドレスへ接続し直します。
+	LeaderAddressHeader = "Vistra-Leader-Address"
+	// LeaderIDHeader はリーダーのノードIDです。
+	LeaderIDHeader = "Vistra-Leader-Id"
+	// LeaderTermHeader はリーダーのリース期間です。
+	LeaderTermHeader = "Vistra-Leader-Term"
+)
+
+var ErrNotLeader = errors.New("this control room instance is on standby")
+
+type HAHandler struct {
+	uc usecase.HAInteractor
+}
+
+func NewHAHandler(uc usecase.HAInteractor) *HAHandler {
+	return &HAHandler{uc: uc}
+}
+
+type leaderStatusResponse struct {
+	NodeID        string `json:"node_id"`
+	IsLeader      bool   `json:"is_leader"`
+	LeaderID      string `json:"leader_id"`
+	LeaderAddress string `json:"leader_address"`
+	Term          uint64 `json:"term"`
+}
+
+// LeaderStatusHTTP はこのノードの役割と現在のリーダーをJSONで返します。
+// スタンバイでも応答するため、ロードバランサーのヘルスチェックにも使えます。
+func (h *HAHandler) LeaderStatusHTTP() http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		if request.Method != http.MethodGet {
+			http.Error(writer, "method not allowed", http.StatusMethodNotAllowed)
+
+			return
+		}
+
+		leader := h.uc.Leader()
+
+		writer.Header().Set("Content-Type", "application/json")
+
+		if err := json.NewEncoder(writer).Encode(&leaderStatusResponse{
+			NodeID:        h.uc.NodeID(),
+			IsLeader:      h.uc.IsLeader(),
+			LeaderID:      leader.GetHolderID(),
+			LeaderAddress: leader.GetAddress(),
+			Term:          leader.GetTerm(),
+		}); err != nil {
+			_ = err
+		}
+	})
+}
+
+// RequireLeaderHTTP はスタンバイ時に 503 Service Unavailable を返すHTTPミドルウェアです。
+// Connect以外のHTTP APIに対して、NewLeaderInterceptor と同じヘッダーでリーダーを通知します。
+// 受け付けたリクエストのコンテキストは降格すると終了するため、SSE などの長時間の応答も降格時に終了します。
+func RequireLeaderHTTP(uc usecase.HAInteractor, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		if uc.IsLeader() {
+			ctx, cancel := uc.LeaderContext(request.Context())
+			defer cancel()
+
+			next.ServeHTTP(writer, request.WithContext(ctx))
+
+			return
+		}
+
+		if leader := uc.Leader(); leader != nil {
+			writer.Header().Set(LeaderAddressHeader, leader.GetAddress())
+			writer.Header().Set(LeaderIDHeader, leader.GetHolderID())
+			writer.Header().Set(LeaderTermHeader, strconv.FormatUint(leader.GetTerm(), 10))
+		}
+
+		http.Error(writer, ErrNotLeader.Error(), http.StatusServiceUnavailable)
+	})
+}
+
+type leaderInterceptor struct {
+	uc usecase.HAInteractor
+}
+
+// NewLeaderInterceptor はスタンバイ時に全てのRPCを CodeUnavailable で拒否する
+// インターセプタを作成します。エラーのメタデータにリーダーのアドレスを設定します。
+// 確立済みのストリームは降格した時点で終了させ、同じエラーでリーダーを通知します。
+func NewLeaderInterceptor(uc usecase.HAInteractor) connect.Interceptor {
+	return &leaderInterceptor{uc: uc}
+}
+
+func (i *leaderInterceptor) WrapUnary(next connect.UnaryFunc) connect.UnaryFunc {
+	return func(ctx context.Context, req connect.AnyRequest) (connect.AnyResponse, error) {
+		if err := i.checkLeader(); err != nil {
+			return nil, err
+		}
+
+		return next(ctx, req)
+	}
+}
+
+func (i *leaderInterceptor) WrapStreamingClient(next connect.StreamingClientFunc) connect.StreamingClientFunc {
+	return next
+}
+
+func (i *leaderInterceptor) WrapStreamingHandler(next connect.StreamingHandlerFunc) connect.StreamingHandlerFunc {
+	return func(ctx context.Context, conn connect.StreamingHandlerConn) error {
+		if err := i.checkLeader(); err != nil {
+			return err
+		}
+
+		leaderCtx, cancel := i.uc.LeaderContext(ctx)
+		defer cancel()
+
+		err := next(leaderCtx, conn)
+
+		// 降格で終了したストリームには、接続し直す先のリーダーを返す
+		if ctx.Err() == nil && leaderCtx.Err() != nil {
+			return i.notLeaderError()
+		}
+
+		return err
+	}
+}
+
+func (i *leaderInterceptor) checkLeader() error {
+	if i.uc.IsLeader() {
+		return nil
+	}
+
+	return i.notLeaderError()
+}
+
+func (i *leaderInterceptor) notLeaderError() error {
+	connectErr := connect.NewError(connect.CodeUnavailable, ErrNotLeader)
+
+	if leader := i.uc.Leader(); leader != nil {
+		connectErr.Meta().Set(LeaderAddressHeader, leader.GetAddress())
+		connectErr.Meta().Set(LeaderIDHeader, leader.GetHolderID())
+		connectErr.Meta().Set(LeaderTermHeader, strconv.FormatUint(leader.GetTerm(), 10))
+	}
+
+	return connectErr
+}
@@ -0,0 +1,176 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"connectrpc.com/connect"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/types/known/structpb"
+
+	protov1 "github.com/anyfld/vistra-operation-control-room/gen/proto/v1"
+	"github.com/anyfld/vistra-operation-control-room/pkg/transport/infrastructure"
+	"github.com/anyfld/vistra-operation-control-room/pkg/transport/usecase"
+)
+
+// LensService は Struct RPC のサービスです（structservice.go）。
+//
+// キャリブレーション: {"camera_id", "zoom_fov": [{"zoom", "horizontal_fov_deg"}, ...], "sensor_aspect", "updated_at_ms"}
+const (
+	// LensServiceName は LensService の完全修飾名です。
+	LensServiceName = "v1.LensService"
+	// LensServiceSetLensCalibrationProcedure は SetLensCalibration のパスです。
+	//
+	// リクエスト: {"camera_id", "zoom_fov", "sensor_aspect"}（zoom_fov はズーム倍率の昇順、sensor_aspect は省略時 16:9）
+	// レスポンス: キャリブレーション
+	LensServiceSetLensCalibrationProcedure = "/v1.LensService/SetLensCalibration"
+	// LensServiceGetLensCalibrationProcedure は GetLensCalibration のパスです。
+	//
+	// リクエスト: {"camera_id"}
+	// レスポンス: キャリブレーション
+	LensServiceGetLensCalibrationProcedure = "/v1.LensService/GetLensCalibration"
+	// LensServiceImageToPTZProcedure は ImageToPTZ のパスです。
+	//
+	// リクエスト: {"camera_id", "x", "y", "current_ptz": PTZParameters}（x, y は画面上の位置 0.0-1.0、current_ptz は省略可）
+	// レスポンス: {"ptz": PTZParameters}（位置を画面の中心に置く絶対PTZ）
+	LensServiceImageToPTZProcedure = "/v1.LensService/ImageToPTZ"
+)
+
+type LensHandler struct {
+	uc usecase.LensInteractor
+}
+
+func NewLensHandler(uc usecase.LensInteractor) *LensHandler {
+	return &LensHandler{uc: uc}
+}
+
+// NewLensServiceHandler は LensService のパスとハンドラーを返します。
+func NewLensServiceHandler(h *LensHandler, opts ...connect.HandlerOption) (string, http.Handler) {
+	procedures := map[string]http.Handler{
+		LensServiceSetLensCalibrationProcedure: connect.NewUnaryHandler(
+			LensServiceSetLensCalibrationProcedure, h.SetLensCalibration, opts...,
+		),
+		LensServiceGetLensCalibrationProcedure: connect.NewUnaryHandler(
+			LensServiceGetLensCalibrationProcedure, h.GetLensCalibration, opts...,
+		),
+		LensServiceImageToPTZProcedure: connect.NewUnaryHandler(
+			LensServiceImageToPTZProcedure, h.ImageToPTZ, opts...,
+		),
+	}
+
+	return newStructServiceHandler(LensServiceName, procedures)
+}
+
+// SetLensCalibration はカメラのレンズのキャリブレーションを保存します。
+func (h *LensHandler) SetLensCalibration(
+	ctx context.Context,
+	req *connect.Request[structpb.Struct],
+) (*connect.Response[structpb.Struct], error) {
+	data, err := protojson.Marshal(req.Msg)
+	if err != nil {
+		return nil, connect.NewError(connect.CodeInvalidArgument, err)
+	}
+
+	calibration := new(infrastructure.LensCalibration)
+	if err := json.Unmarshal(data, calibration); err != nil {
+		return nil, connect.NewError(connect.CodeInvalidArgument, err)
+	}
+
+	cameraID := req.Msg.GetFields()["camera_id"].GetStringValue()
+
+	calibration, err = h.uc.SetLensCalibration(ctx, cameraID, calibration)
+	if err != nil {
+		return nil, lensError(err)
+	}
+
+	return newLensCalibrationResponse(cameraID, calibration)
+}
+
+// GetLensCalibration はカメラのレンズのキャリブレーションを返します。
+func (h *LensHandler) GetLensCalibration(
+	ctx context.Context,
+	req *connect.Request[structpb.Struct],
+) (*connect.Response[structpb.Struct], error) {
+	cameraID := req.Msg.GetFields()["camera_id"].GetStringValue()
+
+	calibration, err := h.uc.GetLensCalibration(ctx, cameraID)
+	if err != nil {
+		return nil, lensError(err)
+	}
+
+	return newLensCalibrationResponse(cameraID, calibration)
+}
+
+// ImageToPTZ は画面上の位置を画面の中心に置く絶対PTZを返します。
+func (h *LensHandler) ImageToPTZ(
+	ctx context.Context,
+	req *connect.Request[structpb.Struct],
+) (*connect.Response[structpb.Struct], error) {
+	fields := req.Msg.GetFields()
+
+	var current *protov1.PTZParameters
+
+	if value := fields["current_ptz"].GetStructValue(); value != nil {
+		data, err := protojson.Marshal(value)
+		if err != nil {
+			return nil, connect.NewError(connect.CodeInvalidArgument, err)
+		}
+
+		current = new(protov1.PTZParameters)
+		if err := protojson.Unmarshal(data, current); err != nil {
+			return nil, connect.NewError(connect.CodeInvalidArgument, err)
+		}
+	}
+
+	ptz, err := h.uc.ImageToPTZ(
+		ctx,
+		fields["camera_id"].GetStringValue(),
+		fields["x"].GetNumberValue(),
+		fields["y"].GetNumberValue(),
+		current,
+	)
+	if err != nil {
+		return nil, lensError(err)
+	}
+
+	value, err := protoToStructValue(ptz)
+	if err != nil {
+		return nil, connect.NewError(connect.CodeInternal, err)
+	}
+
+	return connect.NewResponse(&structpb.Struct{Fields: map[string]*structpb.Value{"ptz": value}}), nil
+}
+
+func newLensCalibrationResponse(
+	cameraID string,
+	calibration *infrastructure.LensCalibration,
+) (*connect.Response[structpb.Struct], error) {
+	data, err := json.Marshal(calibration)
+	if err != nil {
+		return nil, connect.NewError(connect.CodeInternal, err)
+	}
+
+	msg := new(structpb.Struct)
+	if err := protojson.Unmarshal(data, msg); err != nil {
+		return nil, connect.NewError(connect.CodeInternal, err)
+	}
+
+	msg.Fields["camera_id"] = structpb.NewStringValue(cameraID)
+
+	return connect.NewResponse(msg), nil
+}
+
+func lensError(err error) error {
+	switch {
+	case errors.Is(err, usecase.ErrCameraNotFound), errors.Is(err, usecase.ErrLensCalibrationNotFound):
+		return connect.NewError(connect.CodeNotFound, err)
+	case errors.Is(err, infrastructure.ErrInvalidLensCalibration), errors.Is(err, usecase.ErrInvalidImagePoint):
+		return connect.NewError(connect.CodeInvalidArgument, err)
+	case errors.Is(err, usecase.ErrCameraPTZUnknown):
+		return connect.NewError(connect.CodeFailedPrecondition, err)
+	default:
+		return err
+	}
+}
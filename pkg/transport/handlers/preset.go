@@ -0,0 +1,219 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"connectrpc.com/connect"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/types/known/structpb"
+
+	protov1 "github.com/anyfld/vistra-operation-control-room/gen/proto/v1"
+	"github.com/anyfld/vistra-operation-control-room/pkg/transport/infrastructure"
+	"github.com/anyfld/vistra-operation-control-room/pkg/transport/usecase"
+)
+
+// PresetService は Struct RPC のサービスです（structservice.go）。
+//
+// プリセット: {"camera_id", "number", "name", "ptz": PTZParameters, "focus", "updated_at_ms"}
+const (
+	// PresetServiceName は PresetService の完全修飾名です。
+	PresetServiceName = "v1.PresetService"
+	// PresetServiceSetPresetProcedure は SetPreset のパスです。
+	//
+	// リクエスト: {"camera_id", "number", "name", "ptz", "focus"}（ptz を省略するとカメラの現在のPTZ）
+	// レスポンス: プリセット
+	PresetServiceSetPresetProcedure = "/v1.PresetService/SetPreset"
+	// PresetServiceGetPresetProcedure は GetPreset のパスです。
+	//
+	// リクエスト: {"camera_id", "number"}
+	// レスポンス: プリセット
+	PresetServiceGetPresetProcedure = "/v1.PresetService/GetPreset"
+	// PresetServiceListPresetsProcedure は ListPresets のパスです。
+	//
+	// リクエスト: {"camera_id"}
+	// レスポンス: {"presets": [プリセット, ...]}
+	PresetServiceListPresetsProcedure = "/v1.PresetService/ListPresets"
+	// PresetServiceDeletePresetProcedure は DeletePreset のパスです。
+	//
+	// リクエスト: {"camera_id", "number"}
+	// レスポンス: {"success"}
+	PresetServiceDeletePresetProcedure = "/v1.PresetService/DeletePreset"
+)
+
+type PresetHandler struct {
+	uc usecase.PresetInteractor
+}
+
+func NewPresetHandler(uc usecase.PresetInteractor) *PresetHandler {
+	return &PresetHandler{uc: uc}
+}
+
+// NewPresetServiceHandler は PresetService のパスとハンドラーを返します。
+func NewPresetServiceHandler(h *PresetHandler, opts ...connect.HandlerOption) (string, http.Handler) {
+	procedures := map[string]http.Handler{
+		PresetServiceSetPresetProcedure: connect.NewUnaryHandler(
+			PresetServiceSetPresetProcedure, h.SetPreset, opts...,
+		),
+		PresetServiceGetPresetProcedure: connect.NewUnaryHandler(
+			PresetServiceGetPresetProcedure, h.GetPreset, opts...,
+		),
+		PresetServiceListPresetsProcedure: connect.NewUnaryHandler(
+			PresetServiceListPresetsProcedure, h.ListPresets, opts...,
+		),
+		PresetServiceDeletePresetProcedure: connect.NewUnaryHandler(
+			PresetServiceDeletePresetProcedure, h.DeletePreset, opts...,
+		),
+	}
+
+	return newStructServiceHandler(PresetServiceName, procedures)
+}
+
+// SetPreset はプリセットを保存します。
+func (h *PresetHandler) SetPreset(
+	ctx context.Context,
+	req *connect.Request[structpb.Struct],
+) (*connect.Response[structpb.Struct], error) {
+	fields := req.Msg.GetFields()
+
+	var ptz *protov1.PTZParameters
+
+	if value := fields["ptz"].GetStructValue(); value != nil {
+		data, err := protojson.Marshal(value)
+		if err != nil {
+			return nil, connect.NewError(connect.CodeInvalidArgument, err)
+		}
+
+		ptz = new(protov1.PTZParameters)
+		if err := protojson.Unmarshal(data, ptz); err != nil {
+			return nil, connect.NewError(connect.CodeInvalidArgument, err)
+		}
+	}
+
+	preset, err := h.uc.SetPreset(ctx, &infrastructure.Preset{
+		CameraID:    fields["camera_id"].GetStringValue(),
+		Number:      uint32(fields["number"].GetNumberValue()),
+		Name:        fields["name"].GetStringValue(),
+		PTZ:         ptz,
+		Focus:       float32(fields["focus"].GetNumberValue()),
+		UpdatedAtMs: 0,
+	})
+	if err != nil {
+		return nil, presetError(err)
+	}
+
+	msg, err := newPresetStruct(preset)
+	if err != nil {
+		return nil, connect.NewError(connect.CodeInternal, err)
+	}
+
+	return connect.NewResponse(msg), nil
+}
+
+// GetPreset はプリセットを返します。
+func (h *PresetHandler) GetPreset(
+	ctx context.Context,
+	req *connect.Request[structpb.Struct],
+) (*connect.Response[structpb.Struct], error) {
+	fields := req.Msg.GetFields()
+
+	preset, err := h.uc.GetPreset(
+		ctx,
+		fields["camera_id"].GetStringValue(),
+		uint32(fields["number"].GetNumberValue()),
+	)
+	if err != nil {
+		return nil, presetError(err)
+	}
+
+	msg, err := newPresetStruct(preset)
+	if err != nil {
+		return nil, connect.NewError(connect.CodeInternal, err)
+	}
+
+	return connect.NewResponse(msg), nil
+}
+
+// ListPresets はカメラのプリセットを番号順に返します。
+func (h *PresetHandler) ListPresets(
+	ctx context.Context,
+	req *connect.Request[structpb.Struct],
+) (*connect.Response[structpb.Struct], error) {
+	presets, err := h.uc.ListPresets(ctx, req.Msg.GetFields()["camera_id"].GetStringValue())
+	if err != nil {
+		return nil, presetError(err)
+	}
+
+	values := make([]*structpb.Value, 0, len(presets))
+
+	for _, preset := range presets {
+		msg, err := newPresetStruct(preset)
+		if err != nil {
+			return nil, connect.NewError(connect.CodeInternal, err)
+		}
+
+		values = append(values, structpb.NewStructValue(msg))
+	}
+
+	return connect.NewResponse(&structpb.Struct{Fields: map[string]*structpb.Value{
+		"presets": structpb.NewListValue(&structpb.ListValue{Values: values}),
+	}}), nil
+}
+
+// DeletePreset はプリセットを削除します。
+func (h *PresetHandler) DeletePreset(
+	ctx context.Context,
+	req *connect.Request[structpb.Struct],
+) (*connect.Response[structpb.Struct], error) {
+	fields := req.Msg.GetFields()
+
+	if err := h.uc.DeletePreset(
+		ctx,
+		fields["camera_id"].GetStringValue(),
+		uint32(fields["number"].GetNumberValue()),
+	); err != nil {
+		return nil, presetError(err)
+	}
+
+	return connect.NewResponse(&structpb.Struct{Fields: map[string]*structpb.Value{
+		"success": structpb.NewBoolValue(true),
+	}}), nil
+}
+
+func newPresetStruct(preset *infrastructure.Preset) (*structpb.Struct, error) {
+	ptz := new(structpb.Struct)
+
+	if preset.PTZ != nil {
+		data, err := protojson.Marshal(preset.PTZ)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := protojson.Unmarshal(data, ptz); err != nil {
+			return nil, err
+		}
+	}
+
+	return &structpb.Struct{Fields: map[string]*structpb.Value{
+		"camera_id":     structpb.NewStringValue(preset.CameraID),
+		"number":        structpb.NewNumberValue(float64(preset.Number)),
+		"name":          structpb.NewStringValue(preset.Name),
+		"ptz":           structpb.NewStructValue(ptz),
+		"focus":         structpb.NewNumberValue(float64(preset.Focus)),
+		"updated_at_ms": structpb.NewNumberValue(float64(preset.UpdatedAtMs)),
+	}}, nil
+}
+
+func presetError(err error) error {
+	switch {
+	case errors.Is(err, usecase.ErrCameraNotFound), errors.Is(err, infrastructure.ErrPresetNotFound):
+		return connect.NewError(connect.CodeNotFound, err)
+	case errors.Is(err, usecase.ErrPresetOutOfRange), errors.Is(err, usecase.ErrPresetPTZRequired):
+		return connect.NewError(connect.CodeInvalidArgument, err)
+	case errors.Is(err, usecase.ErrPresetNotSupported):
+		return connect.NewError(connect.CodeFailedPrecondition, err)
+	default:
+		return err
+	}
+}
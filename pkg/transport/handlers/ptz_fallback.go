@@ -0,0 +1,31 @@
+package handlers
+
+import (
+	"net/http"
+
+	protov1 "github.com/anyfld/vistra-operation-control-room/gen/proto/v1"
+)
+
+// PollingHTTP は POST /fd/cameras/{id}/ptz/polling で Polling と同じ処理を HTTP/JSON で提供します。
+// 本文は protojson の PollingRequest で、camera_id は省略するとパスのカメラIDを使います。
+func (h *PTZHandler) PollingHTTP() http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		req := &protov1.PollingRequest{} //nolint:exhaustruct
+		if !readFallbackRequest(writer, request, req) {
+			return
+		}
+
+		if !resolveFallbackCameraID(writer, request, &req.CameraId) {
+			return
+		}
+
+		res, err := h.uc.Polling(request.Context(), req)
+		if err != nil {
+			writeFallbackError(writer, "failed to poll", err)
+
+			return
+		}
+
+		writeProtoJSON(writer, http.StatusOK, res)
+	})
+}
@@ -0,0 +1,75 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"connectrpc.com/connect"
+	"google.golang.org/protobuf/types/known/structpb"
+
+	"github.com/anyfld/vistra-operation-control-room/pkg/transport/usecase"
+)
+
+// PTZEstimateService は Struct RPC のサービスです（structservice.go）。
+const (
+	// PTZEstimateServiceName は PTZEstimateService の完全修飾名です。
+	PTZEstimateServiceName = "v1.PTZEstimateService"
+	// PTZEstimateServiceGetPTZEstimateProcedure は GetPTZEstimate のパスです。
+	//
+	// リクエスト: {"camera_id"}
+	// レスポンス: {"camera_id", "pan", "tilt", "zoom", "confidence", "moving", "observed_at_ms", "estimated_at_ms"}
+	PTZEstimateServiceGetPTZEstimateProcedure = "/v1.PTZEstimateService/GetPTZEstimate"
+)
+
+type PTZEstimateHandler struct {
+	uc usecase.PTZEstimateInteractor
+}
+
+func NewPTZEstimateHandler(uc usecase.PTZEstimateInteractor) *PTZEstimateHandler {
+	return &PTZEstimateHandler{uc: uc}
+}
+
+// NewPTZEstimateServiceHandler は PTZEstimateService のパスとハンドラーを返します。
+func NewPTZEstimateServiceHandler(h *PTZEstimateHandler, opts ...connect.HandlerOption) (string, http.Handler) {
+	procedures := map[string]http.Handler{
+		PTZEstimateServiceGetPTZEstimateProcedure: connect.NewUnaryHandler(
+			PTZEstimateServiceGetPTZEstimateProcedure, h.GetPTZEstimate, opts...,
+		),
+	}
+
+	return newStructServiceHandler(PTZEstimateServiceName, procedures)
+}
+
+// GetPTZEstimate はカメラの現在の推定のPTZを、信頼度と推定の基準にした報告の時刻と共に返します。
+func (h *PTZEstimateHandler) GetPTZEstimate(
+	ctx context.Context,
+	req *connect.Request[structpb.Struct],
+) (*connect.Response[structpb.Struct], error) {
+	cameraID := req.Msg.GetFields()["camera_id"].GetStringValue()
+
+	estimate, err := h.uc.GetPTZEstimate(ctx, cameraID)
+	if err != nil {
+		return nil, ptzEstimateError(err)
+	}
+
+	return connect.NewResponse(&structpb.Struct{Fields: map[string]*structpb.Value{
+		"camera_id":       structpb.NewStringValue(cameraID),
+		"pan":             structpb.NewNumberValue(float64(estimate.PTZ.GetPan())),
+		"tilt":            structpb.NewNumberValue(float64(estimate.PTZ.GetTilt())),
+		"zoom":            structpb.NewNumberValue(float64(estimate.PTZ.GetZoom())),
+		"confidence":      structpb.NewNumberValue(estimate.Confidence),
+		"moving":          structpb.NewBoolValue(estimate.Moving),
+		"observed_at_ms":  structpb.NewNumberValue(float64(estimate.ObservedAtMs)),
+		"estimated_at_ms": structpb.NewNumberValue(float64(estimate.EstimatedAtMs)),
+	}}), nil
+}
+
+func ptzEstimateError(err error) error {
+	switch {
+	case errors.Is(err, usecase.ErrCameraNotFound), errors.Is(err, usecase.ErrPTZEstimateNotFound):
+		return connect.NewError(connect.CodeNotFound, err)
+	default:
+		return err
+	}
+}
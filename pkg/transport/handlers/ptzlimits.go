@@ -0,0 +1,146 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"connectrpc.com/connect"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/types/known/structpb"
+
+	"github.com/anyfld/vistra-operation-control-room/pkg/transport/infrastructure"
+	"github.com/anyfld/vistra-operation-control-room/pkg/transport/usecase"
+)
+
+// PTZLimitsService は Struct RPC のサービスです（structservice.go）。
+//
+// 設定: {"camera_id", "pan": {"min", "max"}, "tilt": {...}, "zoom": {...},
+// "no_go_zones": [{"vertices": [{"pan", "tilt"}, ...]}, ...], "action": "clip" | "reject", "updated_at_ms"}
+const (
+	// PTZLimitsServiceName は PTZLimitsService の完全修飾名です。
+	PTZLimitsServiceName = "v1.PTZLimitsService"
+	// PTZLimitsServiceSetPTZLimitsProcedure は SetPTZLimits のパスです。
+	//
+	// リクエスト: 設定（省略した軸は能力情報の可動範囲のまま、action は省略時 clip）
+	// レスポンス: 設定
+	PTZLimitsServiceSetPTZLimitsProcedure = "/v1.PTZLimitsService/SetPTZLimits"
+	// PTZLimitsServiceGetPTZLimitsProcedure は GetPTZLimits のパスです。
+	//
+	// リクエスト: {"camera_id"}
+	// レスポンス: 設定
+	PTZLimitsServiceGetPTZLimitsProcedure = "/v1.PTZLimitsService/GetPTZLimits"
+	// PTZLimitsServiceDeletePTZLimitsProcedure は DeletePTZLimits のパスです。
+	//
+	// リクエスト: {"camera_id"}
+	// レスポンス: {}
+	PTZLimitsServiceDeletePTZLimitsProcedure = "/v1.PTZLimitsService/DeletePTZLimits"
+)
+
+type PTZLimitsHandler struct {
+	uc usecase.PTZLimitsInteractor
+}
+
+func NewPTZLimitsHandler(uc usecase.PTZLimitsInteractor) *PTZLimitsHandler {
+	return &PTZLimitsHandler{uc: uc}
+}
+
+// NewPTZLimitsServiceHandler は PTZLimitsService のパスとハンドラーを返します。
+func NewPTZLimitsServiceHandler(h *PTZLimitsHandler, opts ...connect.HandlerOption) (string, http.Handler) {
+	procedures := map[string]http.Handler{
+		PTZLimitsServiceSetPTZLimitsProcedure: connect.NewUnaryHandler(
+			PTZLimitsServiceSetPTZLimitsProcedure, h.SetPTZLimits, opts...,
+		),
+		PTZLimitsServiceGetPTZLimitsProcedure: connect.NewUnaryHandler(
+			PTZLimitsServiceGetPTZLimitsProcedure, h.GetPTZLimits, opts...,
+		),
+		PTZLimitsServiceDeletePTZLimitsProcedure: connect.NewUnaryHandler(
+			PTZLimitsServiceDeletePTZLimitsProcedure, h.DeletePTZLimits, opts...,
+		),
+	}
+
+	return newStructServiceHandler(PTZLimitsServiceName, procedures)
+}
+
+// SetPTZLimits はカメラのソフトリミットと禁止領域を保存します。不正な設定は保存せずに INVALID_ARGUMENT を返します。
+func (h *PTZLimitsHandler) SetPTZLimits(
+	ctx context.Context,
+	req *connect.Request[structpb.Struct],
+) (*connect.Response[structpb.Struct], error) {
+	data, err := protojson.Marshal(req.Msg)
+	if err != nil {
+		return nil, connect.NewError(connect.CodeInvalidArgument, err)
+	}
+
+	config := new(infrastructure.PTZLimitConfig)
+	if err := json.Unmarshal(data, config); err != nil {
+		return nil, connect.NewError(connect.CodeInvalidArgument, err)
+	}
+
+	cameraID := req.Msg.GetFields()["camera_id"].GetStringValue()
+
+	config, err = h.uc.SetPTZLimits(ctx, cameraID, config)
+	if err != nil {
+		return nil, ptzLimitsError(err)
+	}
+
+	return newPTZLimitsResponse(cameraID, config)
+}
+
+// GetPTZLimits はカメラのソフトリミットと禁止領域を返します。
+func (h *PTZLimitsHandler) GetPTZLimits(
+	ctx context.Context,
+	req *connect.Request[structpb.Struct],
+) (*connect.Response[structpb.Struct], error) {
+	cameraID := req.Msg.GetFields()["camera_id"].GetStringValue()
+
+	config, err := h.uc.GetPTZLimits(ctx, cameraID)
+	if err != nil {
+		return nil, ptzLimitsError(err)
+	}
+
+	return newPTZLimitsResponse(cameraID, config)
+}
+
+// DeletePTZLimits はカメラのソフトリミットと禁止領域を削除します。
+func (h *PTZLimitsHandler) DeletePTZLimits(
+	ctx context.Context,
+	req *connect.Request[structpb.Struct],
+) (*connect.Response[structpb.Struct], error) {
+	if err := h.uc.DeletePTZLimits(ctx, req.Msg.GetFields()["camera_id"].GetStringValue()); err != nil {
+		return nil, ptzLimitsError(err)
+	}
+
+	return connect.NewResponse(&structpb.Struct{Fields: map[string]*structpb.Value{}}), nil
+}
+
+func newPTZLimitsResponse(
+	cameraID string,
+	config *infrastructure.PTZLimitConfig,
+) (*connect.Response[structpb.Struct], error) {
+	data, err := json.Marshal(config)
+	if err != nil {
+		return nil, connect.NewError(connect.CodeInternal, err)
+	}
+
+	msg := new(structpb.Struct)
+	if err := protojson.Unmarshal(data, msg); err != nil {
+		return nil, connect.NewError(connect.CodeInternal, err)
+	}
+
+	msg.Fields["camera_id"] = structpb.NewStringValue(cameraID)
+
+	return connect.NewResponse(msg), nil
+}
+
+func ptzLimitsError(err error) error {
+	switch {
+	case errors.Is(err, usecase.ErrCameraNotFound), errors.Is(err, usecase.ErrPTZLimitsNotFound):
+		return connect.NewError(connect.CodeNotFound, err)
+	case errors.Is(err, infrastructure.ErrInvalidPTZLimits):
+		return connect.NewError(connect.CodeInvalidArgument, err)
+	default:
+		return err
+	}
+}
@@ -0,0 +1,392 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/anyfld/vistra-operation-control-room/pkg/transport/infrastructure"
+	"github.com/anyfld/vistra-operation-control-room/pkg/transport/usecase"
+)
+
+const (
+	// RundownPathPrefix は台本APIのパスです。
+	RundownPathPrefix = "/rundowns"
+
+	maxRundownBodyBytes = 1 << 20
+)
+
+type RundownHandler struct {
+	uc usecase.RundownInteractor
+}
+
+func NewRundownHandler(uc usecase.RundownInteractor) *RundownHandler {
+	return &RundownHandler{uc: uc}
+}
+
+type rundownActionView struct {
+	Cinematography json.RawMessage `json:"cinematography,omitempty"`
+	PTZ            json.RawMessage `json:"ptz,omitempty"`
+	SwitchSource   json.RawMessage `json:"switch_source,omitempty"`
+	FocusPull      json.RawMessage `json:"focus_pull,omitempty"`
+}
+
+type rundownCueView struct {
+	ID         string               `json:"id"`
+	Label      string               `json:"label,omitempty"`
+	TimecodeMs *int64               `json:"timecode_ms,omitempty"`
+	Actions    []*rundownActionView `json:"actions"`
+}
+
+type rundownView struct {
+	ID          string            `json:"id"`
+	Name        string            `json:"name"`
+	Cues        []*rundownCueView `json:"cues"`
+	CreatedAtMs int64             `json:"created_at_ms"`
+}
+
+type rundownActionResultView struct {
+	Action  string `json:"action"`
+	Success bool   `json:"success"`
+	Error   string `json:"error,omitempty"`
+	TaskID  string `json:"task_id,omitempty"`
+}
+
+type rundownStateView struct {
+	RundownID   string                     `json:"rundown_id"`
+	Status      string                     `json:"status"`
+	Mode        string                     `json:"mode"`
+	CurrentCue  *rundownCueView            `json:"current_cue"`
+	NextCue     *rundownCueView            `json:"next_cue"`
+	StartedAtMs int64                      `json:"started_at_ms"`
+	ElapsedMs   int64                      `json:"elapsed_ms"`
+	FiredAtMs   int64                      `json:"fired_at_ms"`
+	LastResults []*rundownActionResultView `json:"last_results"`
+	TimestampMs int64                      `json:"timestamp_ms"`
+}
+
+type rundownDetailView struct {
+	Rundown *rundownView      `json:"rundown"`
+	State   *rundownStateView `json:"state"`
+}
+
+// UploadRundownHTTP は POST /rundowns で YAML または JSON の台本を登録します。
+func (h *RundownHandler) UploadRundownHTTP() http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		body, err := io.ReadAll(http.MaxBytesReader(writer, request.Body, maxRundownBodyBytes))
+		if err != nil {
+			http.Error(writer, "failed to read request body", http.StatusBadRequest)
+
+			return
+		}
+
+		rundown, err := h.uc.UploadRundown(request.Context(), body)
+		if err != nil {
+			writeRundownError(writer, err)
+
+			return
+		}
+
+		writeJSON(writer, http.StatusCreated, newRundownView(rundown))
+	})
+}
+
+// ListRundownsHTTP は GET /rundowns で登録済みの台本を返します。
+func (h *RundownHandler) ListRundownsHTTP() http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		rundowns, err := h.uc.ListRundowns(request.Context())
+		if err != nil {
+			writeRundownError(writer, err)
+
+			return
+		}
+
+		views := make([]*rundownView, 0, len(rundowns))
+		for _, rundown := range rundowns {
+			views = append(views, newRundownView(rundown))
+		}
+
+		writeJSON(writer, http.StatusOK, map[string]any{"rundowns": views})
+	})
+}
+
+// GetRundownHTTP は GET /rundowns/{id} で台本と再生状態を返します。
+func (h *RundownHandler) GetRundownHTTP() http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		rundown, state, err := h.uc.GetRundown(request.Context(), request.PathValue("id"))
+		if err != nil {
+			writeRundownError(writer, err)
+
+			return
+		}
+
+		writeJSON(writer, http.StatusOK, &rundownDetailView{
+			Rundown: newRundownView(rundown),
+			State:   newRundownStateView(rundown, state),
+		})
+	})
+}
+
+// DeleteRundownHTTP は DELETE /rundowns/{id} で台本を削除します。
+func (h *RundownHandler) DeleteRundownHTTP() http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		if err := h.uc.DeleteRundown(request.Context(), request.PathValue("id")); err != nil {
+			writeRundownError(writer, err)
+
+			return
+		}
+
+		writer.WriteHeader(http.StatusNoContent)
+	})
+}
+
+// StartRundownHTTP は POST /rundowns/{id}/start?mode=manual|timecode で再生を開始します。
+func (h *RundownHandler) StartRundownHTTP() http.Handler {
+	return h.stateHandler(func(request *http.Request) (*infrastructure.RundownState, error) {
+		mode := infrastructure.RundownMode(request.URL.Query().Get("mode"))
+
+		return h.uc.StartRundown(request.Context(), request.PathValue("id"), mode)
+	})
+}
+
+// StopRundownHTTP は POST /rundowns/{id}/stop で再生を停止します。
+func (h *RundownHandler) StopRundownHTTP() http.Handler {
+	return h.stateHandler(func(request *http.Request) (*infrastructure.RundownState, error) {
+		return h.uc.StopRundown(request.Context(), request.PathValue("id"))
+	})
+}
+
+// NextCueHTTP は POST /rundowns/{id}/next で次のキューを実行します。
+func (h *RundownHandler) NextCueHTTP() http.Handler {
+	return h.stateHandler(func(request *http.Request) (*infrastructure.RundownState, error) {
+		return h.uc.NextCue(request.Context(), request.PathValue("id"))
+	})
+}
+
+// GoToCueHTTP は POST /rundowns/{id}/cues/{cue}/go で指定したキューを実行します。
+func (h *RundownHandler) GoToCueHTTP() http.Handler {
+	return h.stateHandler(func(request *http.Request) (*infrastructure.RundownState, error) {
+		return h.uc.GoToCue(request.Context(), request.PathValue("id"), request.PathValue("cue"))
+	})
+}
+
+// StreamRundownHTTP は GET /rundowns/{id}/stream で現在・次のキューを Server-Sent Events で配信します。
+// 接続直後に現在の状態を送信し、以降は状態が変化するたびに送信します。
+func (h *RundownHandler) StreamRundownHTTP() http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		ctx := request.Context()
+		rundownID := request.PathValue("id")
+
+		flusher, ok := writer.(http.Flusher)
+		if !ok {
+			http.Error(writer, "streaming not supported", http.StatusInternalServerError)
+
+			return
+		}
+
+		stateCh, err := h.uc.SubscribeRundownState(ctx, rundownID)
+		if err != nil {
+			writeRundownError(writer, err)
+
+			return
+		}
+
+		defer h.uc.UnsubscribeRundownState(ctx, rundownID, stateCh)
+
+		rundown, state, err := h.uc.GetRundown(ctx, rundownID)
+		if err != nil {
+			writeRundownError(writer, err)
+
+			return
+		}
+
+		writer.Header().Set("Content-Type", "text/event-stream")
+		writer.Header().Set("Cache-Control", "no-cache")
+		writer.WriteHeader(http.StatusOK)
+
+		for {
+			if err := writeServerSentEvent(writer, "state", newRundownStateView(rundown, state)); err != nil {
+				return
+			}
+
+			flusher.Flush()
+
+			select {
+			case <-ctx.Done():
+				return
+			case state, ok = <-stateCh:
+				if !ok {
+					return
+				}
+			}
+		}
+	})
+}
+
+func (h *RundownHandler) stateHandler(
+	run func(request *http.Request) (*infrastructure.RundownState, error),
+) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		state, err := run(request)
+		if err != nil {
+			writeRundownError(writer, err)
+
+			return
+		}
+
+		rundown, _, err := h.uc.GetRundown(request.Context(), state.RundownID)
+		if err != nil {
+			writeRundownError(writer, err)
+
+			return
+		}
+
+		writeJSON(writer, http.StatusOK, newRundownStateView(rundown, state))
+	})
+}
+
+func writeRundownError(writer http.ResponseWriter, err error) {
+	status := http.StatusInternalServerError
+
+	switch {
+	case errors.Is(err, usecase.ErrInvalidRundown), errors.Is(err, usecase.ErrInvalidRundownMode):
+		status = http.StatusBadRequest
+	case errors.Is(err, infrastructure.ErrRundownNotFound), errors.Is(err, usecase.ErrCueNotFound):
+		status = http.StatusNotFound
+	case errors.Is(err, usecase.ErrRundownNotRunning), errors.Is(err, usecase.ErrNoNextCue):
+		status = http.StatusConflict
+	}
+
+	http.Error(writer, err.Error(), status)
+}
+
+func writeJSON(writer http.ResponseWriter, status int, value any) {
+	writer.Header().Set("Content-Type", "application/json")
+	writer.WriteHeader(status)
+
+	if err := json.NewEncoder(writer).Encode(value); err != nil {
+		_ = err
+	}
+}
+
+func writeServerSentEvent(writer io.Writer, event string, value any) error {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(writer, "event: %s\ndata: %s\n\n", event, data)
+
+	return err
+}
+
+func newRundownView(rundown *infrastructure.Rundown) *rundownView {
+	cues := make([]*rundownCueView, 0, len(rundown.Cues))
+	for _, cue := range rundown.Cues {
+		cues = append(cues, newRundownCueView(cue))
+	}
+
+	return &rundownView{
+		ID:          rundown.ID,
+		Name:        rundown.Name,
+		Cues:        cues,
+		CreatedAtMs: rundown.CreatedAtMs,
+	}
+}
+
+func newRundownCueView(cue *infrastructure.RundownCue) *rundownCueView {
+	view := &rundownCueView{
+		ID:         cue.ID,
+		Label:      cue.Label,
+		TimecodeMs: nil,
+		Actions:    make([]*rundownActionView, 0, len(cue.Actions)),
+	}
+
+	if cue.HasTimecode {
+		timecodeMs := cue.TimecodeMs
+		view.TimecodeMs = &timecodeMs
+	}
+
+	for _, action := range cue.Actions {
+		view.Actions = append(view.Actions, &rundownActionView{
+			Cinematography: marshalOptionalProto(action.Cinematography),
+			PTZ:            marshalOptionalProto(action.PTZ),
+			SwitchSource:   marshalOptionalProto(action.SwitchSource),
+			FocusPull:      marshalOptionalJSON(action.FocusPull),
+		})
+	}
+
+	return view
+}
+
+func newRundownStateView(rundown *infrastructure.Rundown, state *infrastructure.RundownState) *rundownStateView {
+	now := time.Now().UnixMilli()
+
+	view := &rundownStateView{
+		RundownID:   state.RundownID,
+		Status:      string(state.Status),
+		Mode:        string(state.Mode),
+		CurrentCue:  nil,
+		NextCue:     nil,
+		StartedAtMs: state.StartedAtMs,
+		ElapsedMs:   0,
+		FiredAtMs:   state.FiredAtMs,
+		LastResults: make([]*rundownActionResultView, 0, len(state.LastResults)),
+		TimestampMs: now,
+	}
+
+	if state.Status == infrastructure.RundownStatusRunning {
+		view.ElapsedMs = now - state.StartedAtMs
+	}
+
+	if state.CurrentIndex >= 0 && state.CurrentIndex < len(rundown.Cues) {
+		view.CurrentCue = newRundownCueView(rundown.Cues[state.CurrentIndex])
+	}
+
+	if next := state.CurrentIndex + 1; next < len(rundown.Cues) {
+		view.NextCue = newRundownCueView(rundown.Cues[next])
+	}
+
+	for _, result := range state.LastResults {
+		view.LastResults = append(view.LastResults, &rundownActionResultView{
+			Action:  result.Action,
+			Success: result.Success,
+			Error:   result.Error,
+			TaskID:  result.TaskID,
+		})
+	}
+
+	return view
+}
+
+func marshalOptionalProto[M proto.Message](msg M) json.RawMessage {
+	if !msg.ProtoReflect().IsValid() {
+		return nil
+	}
+
+	data, err := protojson.Marshal(msg)
+	if err != nil {
+		return nil
+	}
+
+	return data
+}
+
+func marshalOptionalJSON[T any](value *T) json.RawMessage {
+	if value == nil {
+		return nil
+	}
+
+	data, err := json.Marshal(value)
+	if err != nil {
+		return nil
+	}
+
+	return data
+}
@@ -0,0 +1,25 @@
+package handlers
+
+import "net/http"
+
+// 生成コードを持たないRPC（Struct RPC）は、google.protobuf.Struct を入出力とする Connect のRPCとして手動で登録します。
+// proto の定義を変えずにRPCを追加でき、クライアントは Connect の任意のクライアントで Struct を送受信して呼び出せます。
+//
+//   - 新しいサービスは NewXServiceHandler でサービスのパスとハンドラーを返し、newStructServiceHandler で振り分けます。
+//   - 既存の FDService に追加するRPCは NewFDXHandlers でプロシージャのパスとハンドラーの対応を返し、
+//     生成コードの FDService と並べて登録します。
+
+// newStructServiceHandler はサービス service のパスと、procedures のプロシージャへ振り分けるハンドラーを返します。
+// procedures にないパスには 404 を返します。
+func newStructServiceHandler(service string, procedures map[string]http.Handler) (string, http.Handler) {
+	return "/" + service + "/", http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		procedure, ok := procedures[request.URL.Path]
+		if !ok {
+			http.NotFound(writer, request)
+
+			return
+		}
+
+		procedure.ServeHTTP(writer, request)
+	})
+}
@@ -0,0 +1,111 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"connectrpc.com/connect"
+	"google.golang.org/protobuf/types/known/structpb"
+
+	"github.com/anyfld/vistra-operation-control-room/pkg/transport/infrastructure"
+	"github.com/anyfld/vistra-operation-control-room/pkg/transport/usecase"
+)
+
+// TelemetryService は Struct RPC のサービスです（structservice.go）。
+//
+// サンプル: {"timestamp_ms", "device_timestamp_ms", "source": "camera_state"|"polling", "ptz": PTZParameters,
+// "focus", "is_moving", "has_error", "error_message", "device_status", "camera_status", "samples"}
+const (
+	// TelemetryServiceName は TelemetryService の完全修飾名です。
+	TelemetryServiceName = "v1.TelemetryService"
+	// TelemetryServiceQueryCameraTelemetryProcedure は QueryCameraTelemetry のパスです。
+	//
+	// リクエスト: {"camera_id", "since_ms", "until_ms", "interval_ms", "max_points"}
+	// （camera_id 以外は省略可。interval_ms または max_points を指定すると区間ごとにまとめます）
+	// レスポンス: {"samples": [サンプル, ...]}（古い順）
+	TelemetryServiceQueryCameraTelemetryProcedure = "/v1.TelemetryService/QueryCameraTelemetry"
+)
+
+type TelemetryHandler struct {
+	uc usecase.TelemetryInteractor
+}
+
+func NewTelemetryHandler(uc usecase.TelemetryInteractor) *TelemetryHandler {
+	return &TelemetryHandler{uc: uc}
+}
+
+// NewTelemetryServiceHandler は TelemetryService のパスとハンドラーを返します。
+func NewTelemetryServiceHandler(h *TelemetryHandler, opts ...connect.HandlerOption) (string, http.Handler) {
+	procedures := map[string]http.Handler{
+		TelemetryServiceQueryCameraTelemetryProcedure: connect.NewUnaryHandler(
+			TelemetryServiceQueryCameraTelemetryProcedure, h.QueryCameraTelemetry, opts...,
+		),
+	}
+
+	return newStructServiceHandler(TelemetryServiceName, procedures)
+}
+
+// QueryCameraTelemetry はカメラの状態の時系列を返します。
+func (h *TelemetryHandler) QueryCameraTelemetry(
+	ctx context.Context,
+	req *connect.Request[structpb.Struct],
+) (*connect.Response[structpb.Struct], error) {
+	fields := req.Msg.GetFields()
+
+	samples, err := h.uc.QueryCameraTelemetry(ctx, infrastructure.TelemetryQuery{
+		CameraID:   fields["camera_id"].GetStringValue(),
+		SinceMs:    int64(fields["since_ms"].GetNumberValue()),
+		UntilMs:    int64(fields["until_ms"].GetNumberValue()),
+		IntervalMs: int64(fields["interval_ms"].GetNumberValue()),
+		MaxPoints:  int(fields["max_points"].GetNumberValue()),
+	})
+	if err != nil {
+		if errors.Is(err, usecase.ErrInvalidTelemetryQuery) {
+			return nil, connect.NewError(connect.CodeInvalidArgument, err)
+		}
+
+		return nil, err
+	}
+
+	values := make([]*structpb.Value, 0, len(samples))
+
+	for _, sample := range samples {
+		msg, err := newTelemetrySampleStruct(sample)
+		if err != nil {
+			return nil, connect.NewError(connect.CodeInternal, err)
+		}
+
+		values = append(values, structpb.NewStructValue(msg))
+	}
+
+	return connect.NewResponse(&structpb.Struct{Fields: map[string]*structpb.Value{
+		"samples": structpb.NewListValue(&structpb.ListValue{Values: values}),
+	}}), nil
+}
+
+func newTelemetrySampleStruct(sample *infrastructure.TelemetrySample) (*structpb.Struct, error) {
+	fields := map[string]*structpb.Value{
+		"timestamp_ms":        structpb.NewNumberValue(float64(sample.TimestampMs)),
+		"device_timestamp_ms": structpb.NewNumberValue(float64(sample.DeviceTimestampMs)),
+		"source":              structpb.NewStringValue(string(sample.Source)),
+		"focus":               structpb.NewNumberValue(float64(sample.Focus)),
+		"is_moving":           structpb.NewBoolValue(sample.IsMoving),
+		"has_error":           structpb.NewBoolValue(sample.HasError),
+		"error_message":       structpb.NewStringValue(sample.ErrorMessage),
+		"device_status":       structpb.NewStringValue(sample.DeviceStatus.String()),
+		"camera_status":       structpb.NewStringValue(sample.CameraStatus.String()),
+		"samples":             structpb.NewNumberValue(float64(sample.Samples)),
+	}
+
+	if sample.PTZ != nil {
+		ptz, err := protoToStructValue(sample.PTZ)
+		if err != nil {
+			return nil, err
+		}
+
+		fields["ptz"] = ptz
+	}
+
+	return &structpb.Struct{Fields: fields}, nil
+}
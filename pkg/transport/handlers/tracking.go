@@ -0,0 +1,172 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"connectrpc.com/connect"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/types/known/structpb"
+
+	protov1 "github.com/anyfld/vistra-operation-control-room/gen/proto/v1"
+	"github.com/anyfld/vistra-operation-control-room/pkg/transport/infrastructure"
+)
+
+// 追尾を扱う FDService の Struct RPC です（structservice.go）。
+const (
+	// FDServiceStartTrackingProcedure は StartTracking のパスです。
+	//
+	// リクエスト: {"camera_id", "subject": Subject, "interval_ms", "mode": "continuous"|"absolute",
+	// "target_x", "target_y", "dead_zone", "lost_timeout_ms", "manual_hold_ms", "smoothing", "kp", "ki", "kd"}
+	// （camera_id と subject 以外は省略可）
+	// レスポンス: GetTracking と同じ
+	FDServiceStartTrackingProcedure = "/v1.FDService/StartTracking"
+	// FDServiceStopTrackingProcedure は StopTracking のパスです。
+	//
+	// リクエスト: {"tracking_id"}
+	// レスポンス: {"success"}
+	FDServiceStopTrackingProcedure = "/v1.FDService/StopTracking"
+	// FDServiceGetTrackingProcedure は GetTracking のパスです。
+	//
+	// リクエスト: {"tracking_id"}
+	// レスポンス: {"tracking_id", "camera_id", "session_id", "subject_id", "mode", "state",
+	// "last_task_id", "last_seen_ms", "updated_at_ms"}
+	FDServiceGetTrackingProcedure = "/v1.FDService/GetTracking"
+)
+
+// NewFDTrackingHandlers は追尾のプロシージャのパスとハンドラーを返します。
+// FDService のハンドラーより長いパスで登録するため、同じ ServeMux に登録できます。
+func NewFDTrackingHandlers(h *FDHandler, opts ...connect.HandlerOption) map[string]http.Handler {
+	return map[string]http.Handler{
+		FDServiceStartTrackingProcedure: connect.NewUnaryHandler(
+			FDServiceStartTrackingProcedure,
+			h.StartTracking,
+			opts...,
+		),
+		FDServiceStopTrackingProcedure: connect.NewUnaryHandler(
+			FDServiceStopTrackingProcedure,
+			h.StopTracking,
+			opts...,
+		),
+		FDServiceGetTrackingProcedure: connect.NewUnaryHandler(
+			FDServiceGetTrackingProcedure,
+			h.GetTracking,
+			opts...,
+		),
+	}
+}
+
+// StartTracking は被写体の追尾を開始します。
+func (h *FDHandler) StartTracking(
+	ctx context.Context,
+	req *connect.Request[structpb.Struct],
+) (*connect.Response[structpb.Struct], error) {
+	fields := req.Msg.GetFields()
+
+	subject := new(protov1.Subject)
+	if value := fields["subject"].GetStructValue(); value != nil {
+		data, err := protojson.Marshal(value)
+		if err != nil {
+			return nil, connect.NewError(connect.CodeInvalidArgument, err)
+		}
+
+		if err := protojson.Unmarshal(data, subject); err != nil {
+			return nil, connect.NewError(connect.CodeInvalidArgument, err)
+		}
+	}
+
+	config := infrastructure.TrackingConfig{
+		Mode:        infrastructure.TrackingMode(fields["mode"].GetStringValue()),
+		TargetX:     fields["target_x"].GetNumberValue(),
+		TargetY:     fields["target_y"].GetNumberValue(),
+		DeadZone:    fields["dead_zone"].GetNumberValue(),
+		LostTimeout: structMilliseconds(fields["lost_timeout_ms"]),
+		ManualHold:  structMilliseconds(fields["manual_hold_ms"]),
+		Smoothing:   fields["smoothing"].GetNumberValue(),
+		Kp:          fields["kp"].GetNumberValue(),
+		Ki:          fields["ki"].GetNumberValue(),
+		Kd:          fields["kd"].GetNumberValue(),
+	}
+
+	session, err := h.uc.StartTracking(
+		ctx,
+		fields["camera_id"].GetStringValue(),
+		subject,
+		uint32(fields["interval_ms"].GetNumberValue()),
+		config,
+	)
+	if err != nil {
+		return nil, trackingError(err)
+	}
+
+	return connect.NewResponse(newTrackingStruct(session)), nil
+}
+
+// StopTracking は追尾を終了します。
+func (h *FDHandler) StopTracking(
+	ctx context.Context,
+	req *connect.Request[structpb.Struct],
+) (*connect.Response[structpb.Struct], error) {
+	success, err := h.uc.StopTracking(ctx, req.Msg.GetFields()["tracking_id"].GetStringValue())
+	if err != nil {
+		return nil, trackingError(err)
+	}
+
+	return connect.NewResponse(&structpb.Struct{Fields: map[string]*structpb.Value{
+		"success": structpb.NewBoolValue(success),
+	}}), nil
+}
+
+// GetTracking は追尾の状態を返します。
+func (h *FDHandler) GetTracking(
+	ctx context.Context,
+	req *connect.Request[structpb.Struct],
+) (*connect.Response[structpb.Struct], error) {
+	session, err := h.uc.GetTracking(ctx, req.Msg.GetFields()["tracking_id"].GetStringValue())
+	if err != nil {
+		return nil, trackingError(err)
+	}
+
+	return connect.NewResponse(newTrackingStruct(session)), nil
+}
+
+func newTrackingStruct(session *infrastructure.TrackingSession) *structpb.Struct {
+	status := session.Status()
+
+	lastSeenMs := int64(0)
+	if !status.LastSeenAt.IsZero() {
+		lastSeenMs = status.LastSeenAt.UnixMilli()
+	}
+
+	return &structpb.Struct{Fields: map[string]*structpb.Value{
+		"tracking_id":   structpb.NewStringValue(session.TrackingID),
+		"camera_id":     structpb.NewStringValue(session.CameraID),
+		"session_id":    structpb.NewStringValue(session.SessionID),
+		"subject_id":    structpb.NewStringValue(session.Subject.GetId()),
+		"mode":          structpb.NewStringValue(string(session.Config.Mode)),
+		"state":         structpb.NewStringValue(string(status.State)),
+		"last_task_id":  structpb.NewStringValue(status.LastTaskID),
+		"last_seen_ms":  structpb.NewNumberValue(float64(lastSeenMs)),
+		"updated_at_ms": structpb.NewNumberValue(float64(status.UpdatedAtMs)),
+	}}
+}
+
+func trackingError(err error) error {
+	switch {
+	case errors.Is(err, infrastructure.ErrTrackingNotFound):
+		return connect.NewError(connect.CodeNotFound, err)
+	case errors.Is(err, infrastructure.ErrTrackingAlreadyActive):
+		return connect.NewError(connect.CodeAlreadyExists, err)
+	case errors.Is(err, infrastructure.ErrInvalidTrackingConfig),
+		errors.Is(err, infrastructure.ErrInvalidSubject):
+		return connect.NewError(connect.CodeInvalidArgument, err)
+	default:
+		return patternMatchingError(err)
+	}
+}
+
+func structMilliseconds(value *structpb.Value) time.Duration {
+	return time.Duration(value.GetNumberValue() * float64(time.Millisecond))
+}
@@ -0,0 +1,206 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"connectrpc.com/connect"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/types/known/structpb"
+
+	protov1 "github.com/anyfld/vistra-operation-control-room/gen/proto/v1"
+	"github.com/anyfld/vistra-operation-control-room/pkg/transport/infrastructure"
+	"github.com/anyfld/vistra-operation-control-room/pkg/transport/usecase"
+)
+
+// PTZの軌道を扱う FDService の Struct RPC です（structservice.go）。位置は PTZParameters と同じ角度とズーム倍率です。
+//
+// 軌道の指定: {"camera_id", "from": {"pan", "tilt", "zoom"}, "to": {"pan", "tilt", "zoom"}, "duration_ms",
+// "curve": "linear"|"ease_in"|"ease_out"|"ease_in_out"|"bezier", "bezier": {"x1", "y1", "x2", "y2"},
+// "mode": "absolute"|"relative", "interval_ms"}
+// （from を省略するとカメラの現在のPTZから、curve は省略時 ease_in_out、mode は省略時 absolute）
+//
+// 軌道: {"start", "keyframes": [{"pan", "tilt", "zoom", "pan_speed", "tilt_speed", "zoom_speed", "offset_ms"}, ...],
+// "curve", "bezier", "mode", "duration_ms"}
+const (
+	// FDServicePlanPTZTrajectoryProcedure は PlanPTZTrajectory のパスです。
+	//
+	// リクエスト: 軌道の指定
+	// レスポンス: {"trajectory"}（キューには追加しません）
+	FDServicePlanPTZTrajectoryProcedure = "/v1.FDService/PlanPTZTrajectory"
+	// FDServiceEnqueuePTZTrajectoryProcedure は EnqueuePTZTrajectory のパスです。
+	//
+	// リクエスト: 軌道の指定と "layer": "COMMAND_LAYER_PTZ"|"COMMAND_LAYER_CINEMATIC"（省略時シネマティック枠）
+	// レスポンス: {"task_id", "trajectory"}
+	FDServiceEnqueuePTZTrajectoryProcedure = "/v1.FDService/EnqueuePTZTrajectory"
+)
+
+var errTrajectoryTargetRequired = errors.New("to is required")
+
+// NewFDTrajectoryHandlers はPTZの軌道のプロシージャのパスとハンドラーを返します。
+func NewFDTrajectoryHandlers(h *FDHandler, opts ...connect.HandlerOption) map[string]http.Handler {
+	return map[string]http.Handler{
+		FDServicePlanPTZTrajectoryProcedure: connect.NewUnaryHandler(
+			FDServicePlanPTZTrajectoryProcedure,
+			h.PlanPTZTrajectory,
+			opts...,
+		),
+		FDServiceEnqueuePTZTrajectoryProcedure: connect.NewUnaryHandler(
+			FDServiceEnqueuePTZTrajectoryProcedure,
+			h.EnqueuePTZTrajectory,
+			opts...,
+		),
+	}
+}
+
+// PlanPTZTrajectory はPTZの軌道をキーフレームに分割して返します。
+func (h *FDHandler) PlanPTZTrajectory(
+	ctx context.Context,
+	req *connect.Request[structpb.Struct],
+) (*connect.Response[structpb.Struct], error) {
+	spec, err := parseTrajectorySpec(req.Msg.GetFields())
+	if err != nil {
+		return nil, err
+	}
+
+	trajectory, err := h.uc.PlanPTZTrajectory(ctx, req.Msg.GetFields()["camera_id"].GetStringValue(), spec)
+	if err != nil {
+		return nil, trajectoryError(err)
+	}
+
+	return connect.NewResponse(&structpb.Struct{Fields: map[string]*structpb.Value{
+		"trajectory": newTrajectoryValue(trajectory),
+	}}), nil
+}
+
+// EnqueuePTZTrajectory はPTZの軌道をPTZキューに追加します。
+func (h *FDHandler) EnqueuePTZTrajectory(
+	ctx context.Context,
+	req *connect.Request[structpb.Struct],
+) (*connect.Response[structpb.Struct], error) {
+	fields := req.Msg.GetFields()
+
+	layer, err := parseCommandLayer(fields["layer"].GetStringValue())
+	if err != nil {
+		return nil, err
+	}
+
+	spec, err := parseTrajectorySpec(fields)
+	if err != nil {
+		return nil, err
+	}
+
+	taskID, trajectory, err := h.uc.EnqueuePTZTrajectory(ctx, fields["camera_id"].GetStringValue(), spec, layer)
+	if err != nil {
+		return nil, trajectoryError(err)
+	}
+
+	return connect.NewResponse(&structpb.Struct{Fields: map[string]*structpb.Value{
+		"task_id":    structpb.NewStringValue(taskID),
+		"trajectory": newTrajectoryValue(trajectory),
+	}}), nil
+}
+
+func parseTrajectorySpec(fields map[string]*structpb.Value) (*infrastructure.PTZTrajectorySpec, error) {
+	from, err := parseOptionalPTZParameters(fields["from"])
+	if err != nil {
+		return nil, err
+	}
+
+	to, err := parseOptionalPTZParameters(fields["to"])
+	if err != nil {
+		return nil, err
+	}
+
+	if to == nil {
+		return nil, connect.NewError(connect.CodeInvalidArgument, errTrajectoryTargetRequired)
+	}
+
+	var bezier *infrastructure.TrajectoryBezier
+
+	if value := fields["bezier"].GetStructValue(); value != nil {
+		points := value.GetFields()
+		bezier = &infrastructure.TrajectoryBezier{
+			X1: points["x1"].GetNumberValue(),
+			Y1: points["y1"].GetNumberValue(),
+			X2: points["x2"].GetNumberValue(),
+			Y2: points["y2"].GetNumberValue(),
+		}
+	}
+
+	return &infrastructure.PTZTrajectorySpec{
+		From:       from,
+		To:         to,
+		DurationMs: uint32(fields["duration_ms"].GetNumberValue()),
+		Curve:      infrastructure.TrajectoryCurve(fields["curve"].GetStringValue()),
+		Bezier:     bezier,
+		Mode:       infrastructure.TrajectoryMoveMode(fields["mode"].GetStringValue()),
+		IntervalMs: uint32(fields["interval_ms"].GetNumberValue()),
+	}, nil
+}
+
+// parseOptionalPTZParameters は Struct の値を PTZParameters に変換します。値がない場合は nil を返します。
+func parseOptionalPTZParameters(value *structpb.Value) (*protov1.PTZParameters, error) {
+	if value.GetStructValue() == nil {
+		return nil, nil //nolint:nilnil
+	}
+
+	data, err := protojson.Marshal(value.GetStructValue())
+	if err != nil {
+		return nil, connect.NewError(connect.CodeInvalidArgument, err)
+	}
+
+	ptz := new(protov1.PTZParameters)
+	if err := protojson.Unmarshal(data, ptz); err != nil {
+		return nil, connect.NewError(connect.CodeInvalidArgument, err)
+	}
+
+	return ptz, nil
+}
+
+func newTrajectoryValue(trajectory *infrastructure.PTZTrajectory) *structpb.Value {
+	keyframes := make([]*structpb.Value, 0, len(trajectory.Keyframes))
+	for _, keyframe := range trajectory.Keyframes {
+		keyframes = append(keyframes, newKeyframeValue(keyframe))
+	}
+
+	return structpb.NewStructValue(&structpb.Struct{Fields: map[string]*structpb.Value{
+		"start":     newKeyframeValue(trajectory.Start),
+		"keyframes": structpb.NewListValue(&structpb.ListValue{Values: keyframes}),
+		"curve":     structpb.NewStringValue(string(trajectory.Curve)),
+		"bezier": structpb.NewStructValue(&structpb.Struct{Fields: map[string]*structpb.Value{
+			"x1": structpb.NewNumberValue(trajectory.Bezier.X1),
+			"y1": structpb.NewNumberValue(trajectory.Bezier.Y1),
+			"x2": structpb.NewNumberValue(trajectory.Bezier.X2),
+			"y2": structpb.NewNumberValue(trajectory.Bezier.Y2),
+		}}),
+		"mode":        structpb.NewStringValue(string(trajectory.Mode)),
+		"duration_ms": structpb.NewNumberValue(float64(trajectory.DurationMs)),
+	}})
+}
+
+func newKeyframeValue(keyframe infrastructure.PTZKeyframe) *structpb.Value {
+	return structpb.NewStructValue(&structpb.Struct{Fields: map[string]*structpb.Value{
+		"pan":        structpb.NewNumberValue(float64(keyframe.Pan)),
+		"tilt":       structpb.NewNumberValue(float64(keyframe.Tilt)),
+		"zoom":       structpb.NewNumberValue(float64(keyframe.Zoom)),
+		"pan_speed":  structpb.NewNumberValue(float64(keyframe.PanSpeed)),
+		"tilt_speed": structpb.NewNumberValue(float64(keyframe.TiltSpeed)),
+		"zoom_speed": structpb.NewNumberValue(float64(keyframe.ZoomSpeed)),
+		"offset_ms":  structpb.NewNumberValue(float64(keyframe.OffsetMs)),
+	}})
+}
+
+func trajectoryError(err error) error {
+	switch {
+	case errors.Is(err, usecase.ErrCameraNotFound):
+		return connect.NewError(connect.CodeNotFound, err)
+	case errors.Is(err, usecase.ErrCameraPTZUnknown):
+		return connect.NewError(connect.CodeFailedPrecondition, err)
+	case errors.Is(err, infrastructure.ErrInvalidTrajectory), errors.Is(err, infrastructure.ErrPTZLimitViolation):
+		return connect.NewError(connect.CodeInvalidArgument, err)
+	default:
+		return err
+	}
+}
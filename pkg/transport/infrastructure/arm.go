@@ -0,0 +1,270 @@
+package infrastructure
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	protov1 "github.com/anyfld/vistra-operation-control-room/gen/proto/v1"
+)
+
+// アームの関節構成は CameraCapabilities に含まれないため、Camera.Metadata で指定します。
+//
+//	arm_joint_limits_deg:    "-170:170,-90:90,-120:120"（関節ごとの可動範囲。関節の数もこの値で決まります）
+//	arm_max_joint_speed_dps: "90,60,60"（関節ごとの最高速度。1つだけ指定すると全関節に適用します）
+const (
+	CameraMetadataArmJointLimits = "arm_joint_limits_deg"
+	CameraMetadataArmJointSpeeds = "arm_max_joint_speed_dps"
+)
+
+const (
+	defaultArmJointSpeedDps = 60.0
+	// armWaypointIntervalMs は軌道のウェイポイントの間隔です。
+	armWaypointIntervalMs = 100
+	// maxArmWaypoints を超える場合はウェイポイントの間隔を広げます。
+	maxArmWaypoints = 100
+	// armPeakVelocityRatio は smoothstep 補間の最高速度と平均速度の比です。
+	// 最高速度が関節の上限を超えないよう、移動時間をこの比だけ延ばします。
+	armPeakVelocityRatio = 1.5
+)
+
+var (
+	ErrArmNotSupported        = errors.New("camera does not support arm")
+	ErrInvalidArmCapabilities = errors.New("invalid arm capabilities")
+	ErrInvalidArmCommand      = errors.New("invalid arm command")
+)
+
+// ArmJoint はアームの関節1つの可動範囲（度）と最高速度（度/秒）です。
+type ArmJoint struct {
+	MinDeg      float64
+	MaxDeg      float64
+	MaxSpeedDps float64
+}
+
+// ArmCapabilities はアームの関節構成です。
+type ArmCapabilities struct {
+	Joints []ArmJoint
+}
+
+// NewArmCapabilities は能力情報とメタデータからアームの関節構成を作成します。
+// SupportsArm が false の場合は ErrArmNotSupported を返します。
+func NewArmCapabilities(caps *protov1.CameraCapabilities, metadata map[string]string) (*ArmCapabilities, error) {
+	if !caps.GetSupportsArm() {
+		return nil, ErrArmNotSupported
+	}
+
+	limits := splitMetadataList(metadata[CameraMetadataArmJointLimits])
+	if len(limits) == 0 {
+		return nil, fmt.Errorf("%w: %s is required", ErrInvalidArmCapabilities, CameraMetadataArmJointLimits)
+	}
+
+	speeds := splitMetadataList(metadata[CameraMetadataArmJointSpeeds])
+	if len(speeds) > 1 && len(speeds) != len(limits) {
+		return nil, fmt.Errorf(
+			"%w: %d joint speeds for %d joints", ErrInvalidArmCapabilities, len(speeds), len(limits),
+		)
+	}
+
+	joints := make([]ArmJoint, 0, len(limits))
+
+	for i, limit := range limits {
+		minDeg, maxDeg, err := parseJointLimit(limit)
+		if err != nil {
+			return nil, fmt.Errorf("%w: joint %d: %w", ErrInvalidArmCapabilities, i, err)
+		}
+
+		speed := defaultArmJointSpeedDps
+
+		switch len(speeds) {
+		case 0:
+		case 1:
+			speed, err = parsePositiveFloat(speeds[0])
+		default:
+			speed, err = parsePositiveFloat(speeds[i])
+		}
+
+		if err != nil {
+			return nil, fmt.Errorf("%w: joint %d speed: %w", ErrInvalidArmCapabilities, i, err)
+		}
+
+		joints = append(joints, ArmJoint{MinDeg: minDeg, MaxDeg: maxDeg, MaxSpeedDps: speed})
+	}
+
+	return &ArmCapabilities{Joints: joints}, nil
+}
+
+// Validate はARM命令が関節の数・可動範囲・速度の範囲に収まっているか確認します。
+// 安全停止（NewArmSafetyStop）は関節角度を省略できます。
+func (c *ArmCapabilities) Validate(params *protov1.ArmParameters) error {
+	if params == nil {
+		return fmt.Errorf("%w: arm_parameters is required", ErrInvalidArmCommand)
+	}
+
+	speed := params.GetSpeed()
+	if math.IsNaN(float64(speed)) || speed < 0 || speed > 1 {
+		return fmt.Errorf("%w: speed %v is not within 0.0-1.0", ErrInvalidArmCommand, speed)
+	}
+
+	angles := params.GetJointAngles()
+	if IsArmSafetyStop(params) && len(angles) == 0 {
+		return nil
+	}
+
+	if len(angles) != len(c.Joints) {
+		return fmt.Errorf("%w: %d joint angles for %d joints", ErrInvalidArmCommand, len(angles), len(c.Joints))
+	}
+
+	for i, angle := range angles {
+		joint := c.Joints[i]
+		if math.IsNaN(float64(angle)) || float64(angle) < joint.MinDeg || float64(angle) > joint.MaxDeg {
+			return fmt.Errorf(
+				"%w: joint %d angle %v is not within %v-%v", ErrInvalidArmCommand, i, angle, joint.MinDeg, joint.MaxDeg,
+			)
+		}
+	}
+
+	return nil
+}
+
+// ArmWaypoint は軌道上の1点です。OffsetMs は軌道の開始からこの点に到達するまでの時間です。
+type ArmWaypoint struct {
+	JointAngles []float32 `json:"joint_angles"`
+	OffsetMs    uint32    `json:"offset_ms"`
+}
+
+// ArmTrajectory は現在の姿勢から目標の姿勢までの補間された軌道です。
+// 全関節が同時に動き始めて同時に止まるよう、最も時間のかかる関節に合わせて補間します。
+type ArmTrajectory struct {
+	Waypoints  []ArmWaypoint `json:"waypoints"`
+	Speed      float32       `json:"speed"`
+	DurationMs uint32        `json:"duration_ms"`
+	// TimeoutMs は各ウェイポイントの結果を待つ時間です。0 の場合は制御コマンドの既定のタイムアウトです。
+	TimeoutMs uint32 `json:"timeout_ms"`
+}
+
+// Target は軌道の最後のウェイポイントの関節角度を返します。
+func (t *ArmTrajectory) Target() []float32 {
+	if len(t.Waypoints) == 0 {
+		return nil
+	}
+
+	return t.Waypoints[len(t.Waypoints)-1].JointAngles
+}
+
+// PlanTrajectory は from から target までの軌道を計算します。
+// 各関節は smoothstep で加減速し、最高速度は MaxSpeedDps × Speed を超えません。
+// 現在の姿勢が不明な場合（from の関節の数が合わない場合）は、目標の姿勢だけの軌道を返します。
+func (c *ArmCapabilities) PlanTrajectory(from []float32, target *protov1.ArmParameters) *ArmTrajectory {
+	angles := target.GetJointAngles()
+	speed := target.GetSpeed()
+
+	single := &ArmTrajectory{
+		Waypoints:  []ArmWaypoint{{JointAngles: angles, OffsetMs: 0}},
+		Speed:      speed,
+		DurationMs: 0,
+		TimeoutMs:  0,
+	}
+
+	if len(from) != len(angles) || len(angles) != len(c.Joints) || speed <= 0 {
+		return single
+	}
+
+	durationSec := 0.0
+	for i, joint := range c.Joints {
+		delta := math.Abs(float64(angles[i] - from[i]))
+		durationSec = max(durationSec, armPeakVelocityRatio*delta/(joint.MaxSpeedDps*float64(speed)))
+	}
+
+	durationMs := math.Ceil(durationSec * millisPerSecond)
+	if durationMs == 0 {
+		return single
+	}
+
+	count := min(int(math.Ceil(durationMs/armWaypointIntervalMs)), maxArmWaypoints)
+	waypoints := make([]ArmWaypoint, 0, count)
+
+	for k := 1; k <= count; k++ {
+		progress := float64(k) / float64(count)
+		eased := progress * progress * (3 - 2*progress) //nolint:mnd
+
+		point := make([]float32, len(angles))
+		for i := range angles {
+			point[i] = from[i] + float32(eased)*(angles[i]-from[i])
+		}
+
+		waypoints = append(waypoints, ArmWaypoint{
+			JointAngles: point,
+			OffsetMs:    uint32(math.Round(durationMs * progress)),
+		})
+	}
+
+	// 丸め誤差で目標から外れないよう、最後の点は目標の姿勢そのものにする
+	waypoints[count-1].JointAngles = angles
+
+	return &ArmTrajectory{Waypoints: waypoints, Speed: speed, DurationMs: uint32(durationMs), TimeoutMs: 0}
+}
+
+// NewArmSafetyStop は安全停止のARM命令のパラメータを返します。
+// 速度 0 のARM命令は、FDが実行中の軌道を破棄して jointAngles（省略時は現在の姿勢）で停止することを意味します。
+func NewArmSafetyStop(jointAngles []float32) *protov1.ArmParameters {
+	return &protov1.ArmParameters{
+		JointAngles: jointAngles,
+		Speed:       0,
+	}
+}
+
+// IsArmSafetyStop はARM命令が安全停止かどうかを返します。
+func IsArmSafetyStop(params *protov1.ArmParameters) bool {
+	return params != nil && params.GetSpeed() == 0
+}
+
+func splitMetadataList(value string) []string {
+	if strings.TrimSpace(value) == "" {
+		return nil
+	}
+
+	items := strings.Split(value, ",")
+	for i, item := range items {
+		items[i] = strings.TrimSpace(item)
+	}
+
+	return items
+}
+
+func parseJointLimit(value string) (float64, float64, error) {
+	minText, maxText, ok := strings.Cut(value, ":")
+	if !ok {
+		return 0, 0, fmt.Errorf("limit %q must be <min>:<max>", value)
+	}
+
+	minDeg, err := strconv.ParseFloat(strings.TrimSpace(minText), 64)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	maxDeg, err := strconv.ParseFloat(strings.TrimSpace(maxText), 64)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	if maxDeg <= minDeg {
+		return 0, 0, fmt.Errorf("limit %q is empty", value)
+	}
+
+	return minDeg, maxDeg, nil
+}
+
+func parsePositiveFloat(value string) (float64, error) {
+	parsed, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return 0, err
+	}
+
+	if parsed <= 0 {
+		return 0, fmt.Errorf("%v must be positive", parsed)
+	}
+
+	return parsed, nil
+}
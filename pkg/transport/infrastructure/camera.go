@@ -1,10 +1,15 @@
 package infrastructure
 
 import (
+	"encoding/json"
 	"fmt"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+
 	protov1 "github.com/anyfld/vistra-operation-control-room/gen/proto/v1"
 )
 
@@ -13,21 +18,30 @@ type CameraRepo struct {
 	cameras          map[string]*protov1.Camera
 	connections      map[string]*protov1.CameraConnection
 	capabilities     map[string]*protov1.CameraCapabilities
+	calibrations     map[string]*LensCalibration
+	ptzLimits        map[string]*PTZLimitConfig
 	connectionStatus map[string]protov1.CameraStatus
+	onChange         atomic.Pointer[func()]
+	events           *EventBus
 }
 
-func NewCameraRepo() *CameraRepo {
+func NewCameraRepo(events *EventBus) *CameraRepo {
 	return &CameraRepo{
 		mu:               sync.RWMutex{},
 		cameras:          make(map[string]*protov1.Camera),
 		connections:      make(map[string]*protov1.CameraConnection),
 		capabilities:     make(map[string]*protov1.CameraCapabilities),
+		calibrations:     make(map[string]*LensCalibration),
+		ptzLimits:        make(map[string]*PTZLimitConfig),
 		connectionStatus: make(map[string]protov1.CameraStatus),
+		onChange:         atomic.Pointer[func()]{},
+		events:           events,
 	}
 }
 
 func (r *CameraRepo) RegisterCamera(req *protov1.RegisterCameraRequest) *protov1.Camera {
 	r.mu.Lock()
+	defer r.notifyChange()
 	defer r.mu.Unlock()
 
 	cameraID := fmt.Sprintf("cam-%d", time.Now().UnixNano())
@@ -55,27 +69,36 @@ func (r *CameraRepo) RegisterCamera(req *protov1.RegisterCameraRequest) *protov1
 
 	r.connectionStatus[cameraID] = protov1.CameraStatus_CAMERA_STATUS_ONLINE
 
+	r.events.Publish(EventTopicCamera, EventCameraRegistered, cameraID, cameraID, camera)
+
 	return camera
 }
 
 func (r *CameraRepo) UnregisterCamera(cameraID string) bool {
 	r.mu.Lock()
+	defer r.notifyChange()
 	defer r.mu.Unlock()
 
-	if _, ok := r.cameras[cameraID]; !ok {
+	camera, ok := r.cameras[cameraID]
+	if !ok {
 		return false
 	}
 
 	delete(r.cameras, cameraID)
 	delete(r.connections, cameraID)
 	delete(r.capabilities, cameraID)
+	delete(r.calibrations, cameraID)
+	delete(r.ptzLimits, cameraID)
 	delete(r.connectionStatus, cameraID)
 
+	r.events.Publish(EventTopicCamera, EventCameraUnregistered, cameraID, cameraID, camera)
+
 	return true
 }
 
 func (r *CameraRepo) UpdateCamera(cameraID string, req *protov1.UpdateCameraRequest) *protov1.Camera {
 	r.mu.Lock()
+	defer r.notifyChange()
 	defer r.mu.Unlock()
 
 	camera, ok := r.cameras[cameraID]
@@ -95,6 +118,8 @@ func (r *CameraRepo) UpdateCamera(cameraID string, req *protov1.UpdateCameraRequ
 		camera.Metadata = req.GetMetadata()
 	}
 
+	r.events.Publish(EventTopicCamera, EventCameraUpdated, cameraID, cameraID, camera)
+
 	return camera
 }
 
@@ -119,6 +144,80 @@ func (r *CameraRepo) GetCapabilities(cameraID string) *protov1.CameraCapabilitie
 	return r.capabilities[cameraID]
 }
 
+// SetLensCalibration はカメラのレンズのキャリブレーションを能力情報と共に保存します。
+// 設定は LensCalibration.Validate で検証してから保存してください。SensorAspect が 0 の場合は 16:9 とします。
+// カメラが登録されていない場合は false を返します。
+func (r *CameraRepo) SetLensCalibration(cameraID string, calibration *LensCalibration) bool {
+	r.mu.Lock()
+	defer r.notifyChange()
+	defer r.mu.Unlock()
+
+	camera, ok := r.cameras[cameraID]
+	if !ok {
+		return false
+	}
+
+	if calibration.SensorAspect == 0 {
+		calibration.SensorAspect = defaultAspectRatio
+	}
+
+	calibration.UpdatedAtMs = time.Now().UnixMilli()
+	r.calibrations[cameraID] = calibration
+
+	r.events.Publish(EventTopicCamera, EventCameraUpdated, cameraID, cameraID, camera)
+
+	return true
+}
+
+// GetLensCalibration はカメラのレンズのキャリブレーションを返します。保存されていない場合は nil を返します。
+func (r *CameraRepo) GetLensCalibration(cameraID string) *LensCalibration {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.calibrations[cameraID]
+}
+
+// SetPTZLimits はカメラのソフトリミットと禁止領域の設定を保存します。config が nil の場合は設定を削除します。
+// 設定は PTZLimitConfig.Validate で検証してから保存してください。カメラが登録されていない場合は false を返します。
+func (r *CameraRepo) SetPTZLimits(cameraID string, config *PTZLimitConfig) bool {
+	r.mu.Lock()
+	defer r.notifyChange()
+	defer r.mu.Unlock()
+
+	camera, ok := r.cameras[cameraID]
+	if !ok {
+		return false
+	}
+
+	if config == nil {
+		delete(r.ptzLimits, cameraID)
+	} else {
+		config.UpdatedAtMs = time.Now().UnixMilli()
+		r.ptzLimits[cameraID] = config
+	}
+
+	r.events.Publish(EventTopicCamera, EventCameraUpdated, cameraID, cameraID, camera)
+
+	return true
+}
+
+// GetPTZLimits はカメラのソフトリミットと禁止領域の設定を返します。設定されていない場合は nil を返します。
+func (r *CameraRepo) GetPTZLimits(cameraID string) *PTZLimitConfig {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.ptzLimits[cameraID]
+}
+
+// GetOptics はカメラの能力情報・メタデータ・レンズのキャリブレーションから光学特性を作成します。
+func (r *CameraRepo) GetOptics(cameraID string) *CameraOptics {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return NewCameraOptics(r.capabilities[cameraID], r.cameras[cameraID].GetMetadata()).
+		WithCalibration(r.calibrations[cameraID])
+}
+
 func (r *CameraRepo) ListCameras(
 	masterMfId string,
 	modeFilter []protov1.CameraMode,
@@ -140,6 +239,7 @@ func (r *CameraRepo) ListCameras(
 
 func (r *CameraRepo) SwitchCameraMode(cameraID string, mode protov1.CameraMode) bool {
 	r.mu.Lock()
+	defer r.notifyChange()
 	defer r.mu.Unlock()
 
 	camera, ok := r.cameras[cameraID]
@@ -149,6 +249,8 @@ func (r *CameraRepo) SwitchCameraMode(cameraID string, mode protov1.CameraMode)
 
 	camera.Mode = mode
 
+	r.events.Publish(EventTopicCamera, EventCameraModeChanged, cameraID, cameraID, camera)
+
 	return true
 }
 
@@ -162,16 +264,24 @@ func (r *CameraRepo) UpdateCameraState(cameraID string, ptz *protov1.PTZParamete
 	}
 
 	camera.LastSeenAtMs = time.Now().UnixMilli()
+	changed := false
 
 	if status != protov1.CameraStatus_CAMERA_STATUS_UNSPECIFIED {
+		changed = camera.GetStatus() != status
 		camera.Status = status
 		r.connectionStatus[cameraID] = status
 	}
 
 	if ptz != nil {
+		changed = changed || !proto.Equal(camera.GetCurrentPtz(), ptz)
 		camera.CurrentPtz = ptz
 	}
 
+	// 定期的な状態報告で変化がない場合は通知しない
+	if changed {
+		r.events.Publish(EventTopicCamera, EventCameraStateChanged, cameraID, cameraID, camera)
+	}
+
 	return true
 }
 
@@ -196,6 +306,127 @@ func (r *CameraRepo) GetAllConnectionStatuses() map[string]protov1.CameraStatus
 	return result
 }
 
+// cameraSnapshot は共有ストアに保存するカメラ登録情報です。
+type cameraSnapshot struct {
+	Camera       json.RawMessage  `json:"camera"`
+	Connection   json.RawMessage  `json:"connection,omitempty"`
+	Capabilities json.RawMessage  `json:"capabilities,omitempty"`
+	Calibration  *LensCalibration `json:"lens_calibration,omitempty"`
+	PTZLimits    *PTZLimitConfig  `json:"ptz_limits,omitempty"`
+}
+
+// SetChangeHook はカメラの登録情報が変化したときに呼び出される関数を設定します。
+// FDからの状態報告は頻度が高く、再接続時に再送されるため通知対象外です。
+func (r *CameraRepo) SetChangeHook(hook func()) {
+	r.onChange.Store(&hook)
+}
+
+// Snapshot はカメラの登録情報をシリアライズします。
+func (r *CameraRepo) Snapshot() ([]byte, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	snapshots := make([]*cameraSnapshot, 0, len(r.cameras))
+
+	for cameraID, camera := range r.cameras {
+		snapshot := new(cameraSnapshot)
+
+		var err error
+
+		if snapshot.Camera, err = protojson.Marshal(camera); err != nil {
+			return nil, fmt.Errorf("encode camera %s: %w", cameraID, err)
+		}
+
+		if conn, ok := r.connections[cameraID]; ok {
+			if snapshot.Connection, err = protojson.Marshal(conn); err != nil {
+				return nil, fmt.Errorf("encode camera connection %s: %w", cameraID, err)
+			}
+		}
+
+		if caps, ok := r.capabilities[cameraID]; ok {
+			if snapshot.Capabilities, err = protojson.Marshal(caps); err != nil {
+				return nil, fmt.Errorf("encode camera capabilities %s: %w", cameraID, err)
+			}
+		}
+
+		snapshot.Calibration = r.calibrations[cameraID]
+		snapshot.PTZLimits = r.ptzLimits[cameraID]
+
+		snapshots = append(snapshots, snapshot)
+	}
+
+	return json.Marshal(snapshots)
+}
+
+// Restore は Snapshot で保存した登録情報で現在の状態を置き換えます。
+func (r *CameraRepo) Restore(data []byte) error {
+	var snapshots []*cameraSnapshot
+	if err := json.Unmarshal(data, &snapshots); err != nil {
+		return fmt.Errorf("decode camera snapshot: %w", err)
+	}
+
+	cameras := make(map[string]*protov1.Camera, len(snapshots))
+	connections := make(map[string]*protov1.CameraConnection)
+	capabilities := make(map[string]*protov1.CameraCapabilities)
+	calibrations := make(map[string]*LensCalibration)
+	ptzLimits := make(map[string]*PTZLimitConfig)
+	connectionStatus := make(map[string]protov1.CameraStatus, len(snapshots))
+
+	for _, snapshot := range snapshots {
+		camera := new(protov1.Camera)
+		if err := protojson.Unmarshal(snapshot.Camera, camera); err != nil {
+			return fmt.Errorf("decode camera: %w", err)
+		}
+
+		cameraID := camera.GetId()
+		cameras[cameraID] = camera
+		connectionStatus[cameraID] = camera.GetStatus()
+
+		if len(snapshot.Connection) > 0 {
+			conn := new(protov1.CameraConnection)
+			if err := protojson.Unmarshal(snapshot.Connection, conn); err != nil {
+				return fmt.Errorf("decode camera connection: %w", err)
+			}
+
+			connections[cameraID] = conn
+		}
+
+		if len(snapshot.Capabilities) > 0 {
+			caps := new(protov1.CameraCapabilities)
+			if err := protojson.Unmarshal(snapshot.Capabilities, caps); err != nil {
+				return fmt.Errorf("decode camera capabilities: %w", err)
+			}
+
+			capabilities[cameraID] = caps
+		}
+
+		if snapshot.Calibration != nil {
+			calibrations[cameraID] = snapshot.Calibration
+		}
+
+		if snapshot.PTZLimits != nil {
+			ptzLimits[cameraID] = snapshot.PTZLimits
+		}
+	}
+
+	r.mu.Lock()
+	r.cameras = cameras
+	r.connections = connections
+	r.capabilities = capabilities
+	r.calibrations = calibrations
+	r.ptzLimits = ptzLimits
+	r.connectionStatus = connectionStatus
+	r.mu.Unlock()
+
+	return nil
+}
+
+func (r *CameraRepo) notifyChange() {
+	if hook := r.onChange.Load(); hook != nil {
+		(*hook)()
+	}
+}
+
 const (
 	heartbeatTimeoutSeconds = 10
 	millisecondsPerSecond   = 1000
@@ -218,6 +449,8 @@ func (r *CameraRepo) CheckAndUpdateDisconnectedCameras() {
 			if camera.GetStatus() != protov1.CameraStatus_CAMERA_STATUS_OFFLINE {
 				camera.Status = protov1.CameraStatus_CAMERA_STATUS_OFFLINE
 				r.connectionStatus[cameraID] = protov1.CameraStatus_CAMERA_STATUS_OFFLINE
+
+				r.events.Publish(EventTopicCamera, EventCameraStateChanged, cameraID, cameraID, camera)
 			}
 		}
 	}
@@ -0,0 +1,281 @@
+package infrastructure
+
+import (
+	"time"
+
+	protov1 "github.com/anyfld/vistra-operation-control-room/gen/proto/v1"
+)
+
+// PTZService のタスクと FDService の制御コマンドは、カメラのキュー（PTZRepo）を唯一の命令の流れとして共有します。
+//
+//   - 移動系の制御コマンドは、コマンドIDをタスクIDとする PTZ枠（Layer 1）のタスクとしてキューに追加します。
+//     PTZService.SendPTZCommand と同様にシネマティック枠を中断させ、ポーリングするFDにも届きます。
+//   - キューの先頭のタスクはポーリングを待たずに実行対象になります（task.started）。移動系の制御コマンドは
+//     タスクが実行対象になった時点でFDへ配信し、タイムアウトもそこから数えます。
+//   - PTZ命令を持つタスク（PTZService の命令と追尾）は、実行対象になった時点で、タスクIDをコマンドIDとする
+//     制御コマンドとして制御コマンドを購読するFDにも配信します。
+//   - どちらの経路で完了・失敗を報告しても、タスクと制御コマンドの両方が終了します。
+//     タスクが中断された場合、制御コマンドは失敗として終了し、その結果が購読するFDに届きます。
+//
+// 角度で表す制御コマンドと正規化座標で表すPTZ命令は、カメラの可動範囲（CameraOptics）で相互に変換します。
+// 速度はどちらも 0.0〜1.0 で表すため、そのまま対応させます。連続移動の制御コマンドは PTZParameters の速度を
+// 符号付きの速度（-1.0〜1.0）として扱い、TimeoutMs を連続移動のタイムアウトに対応させます。
+
+// PTZCommandFromControl は移動系の制御コマンドをPTZ命令に変換します。
+// PTZ命令で表せない制御コマンド（プリセット番号への移動、フォーカス、アームなど）の場合は nil を返します。
+func (o *CameraOptics) PTZCommandFromControl(command *protov1.ControlCommand) *protov1.PTZCommand {
+	params := command.GetPtzParameters()
+	speed := &protov1.PTZSpeed{
+		PanSpeed:  float32(clamp(float64(params.GetPanSpeed()), 0, 1)),
+		TiltSpeed: float32(clamp(float64(params.GetTiltSpeed()), 0, 1)),
+		ZoomSpeed: float32(clamp(float64(params.GetZoomSpeed()), 0, 1)),
+	}
+
+	switch command.GetType() { //nolint:exhaustive
+	case protov1.ControlCommandType_CONTROL_COMMAND_TYPE_PTZ_ABSOLUTE:
+		return &protov1.PTZCommand{
+			OperationType: protov1.PTZOperationType_PTZ_OPERATION_TYPE_ABSOLUTE_MOVE,
+			Command: &protov1.PTZCommand_AbsoluteMove{AbsoluteMove: &protov1.AbsoluteMoveCommand{
+				Position: o.NormalizedPosition(float64(params.GetPan()), float64(params.GetTilt()), float64(params.GetZoom())),
+				Speed:    speed,
+			}},
+		}
+	case protov1.ControlCommandType_CONTROL_COMMAND_TYPE_PTZ_RELATIVE:
+		return &protov1.PTZCommand{
+			OperationType: protov1.PTZOperationType_PTZ_OPERATION_TYPE_RELATIVE_MOVE,
+			Command: &protov1.PTZCommand_RelativeMove{RelativeMove: &protov1.RelativeMoveCommand{
+				Translation: &protov1.PTZTranslation{
+					PanDelta:  float32(scaleRange(float64(params.GetPan()), o.PanMin, o.PanMax) * 2),    //nolint:mnd
+					TiltDelta: float32(scaleRange(float64(params.GetTilt()), o.TiltMin, o.TiltMax) * 2), //nolint:mnd
+					ZoomDelta: float32(scaleRange(float64(params.GetZoom()), o.ZoomMin, o.ZoomMax)),
+				},
+				Speed: speed,
+			}},
+		}
+	case protov1.ControlCommandType_CONTROL_COMMAND_TYPE_PTZ_CONTINUOUS:
+		return newPTZVelocityCommand(
+			&protov1.PTZVelocity{
+				PanVelocity:  float32(clamp(float64(params.GetPanSpeed()), -1, 1)),
+				TiltVelocity: float32(clamp(float64(params.GetTiltSpeed()), -1, 1)),
+				ZoomVelocity: float32(clamp(float64(params.GetZoomSpeed()), -1, 1)),
+			},
+			command.GetTimeoutMs(),
+		)
+	case protov1.ControlCommandType_CONTROL_COMMAND_TYPE_PTZ_STOP:
+		return newPTZVelocityCommand(&protov1.PTZVelocity{PanVelocity: 0, TiltVelocity: 0, ZoomVelocity: 0}, 0)
+	default:
+		return nil
+	}
+}
+
+// ControlCommandFromPTZ はPTZ命令を制御コマンドに変換します。変換できない場合は nil を返します。
+func (o *CameraOptics) ControlCommandFromPTZ(
+	commandID string,
+	cameraID string,
+	command *protov1.PTZCommand,
+) *protov1.ControlCommand {
+	control := &protov1.ControlCommand{
+		CommandId:     commandID,
+		CameraId:      cameraID,
+		Type:          protov1.ControlCommandType_CONTROL_COMMAND_TYPE_UNSPECIFIED,
+		PtzParameters: nil,
+		PresetNumber:  0,
+		FocusValue:    0,
+		ArmParameters: nil,
+		TimeoutMs:     0,
+	}
+
+	switch {
+	case command.GetAbsoluteMove() != nil:
+		position := command.GetAbsoluteMove().GetPosition()
+		speed := command.GetAbsoluteMove().GetSpeed()
+		control.Type = protov1.ControlCommandType_CONTROL_COMMAND_TYPE_PTZ_ABSOLUTE
+		control.PtzParameters = o.DenormalizedPTZ(position)
+		control.PtzParameters.PanSpeed = speed.GetPanSpeed()
+		control.PtzParameters.TiltSpeed = speed.GetTiltSpeed()
+		control.PtzParameters.ZoomSpeed = speed.GetZoomSpeed()
+	case command.GetRelativeMove() != nil:
+		translation := command.GetRelativeMove().GetTranslation()
+		speed := command.GetRelativeMove().GetSpeed()
+		control.Type = protov1.ControlCommandType_CONTROL_COMMAND_TYPE_PTZ_RELATIVE
+		control.PtzParameters = &protov1.PTZParameters{
+			Pan:       float32(float64(translation.GetPanDelta()) / 2 * (o.PanMax - o.PanMin)),    //nolint:mnd
+			Tilt:      float32(float64(translation.GetTiltDelta()) / 2 * (o.TiltMax - o.TiltMin)), //nolint:mnd
+			Zoom:      float32(float64(translation.GetZoomDelta()) * (o.ZoomMax - o.ZoomMin)),
+			PanSpeed:  speed.GetPanSpeed(),
+			TiltSpeed: speed.GetTiltSpeed(),
+			ZoomSpeed: speed.GetZoomSpeed(),
+		}
+	case command.GetContinuousMove() != nil:
+		velocity := command.GetContinuousMove().GetVelocity()
+		control.Type = protov1.ControlCommandType_CONTROL_COMMAND_TYPE_PTZ_CONTINUOUS
+		control.TimeoutMs = command.GetContinuousMove().GetTimeoutMs()
+		control.PtzParameters = &protov1.PTZParameters{ //nolint:exhaustruct
+			PanSpeed:  velocity.GetPanVelocity(),
+			TiltSpeed: velocity.GetTiltVelocity(),
+			ZoomSpeed: velocity.GetZoomVelocity(),
+		}
+	default:
+		return nil
+	}
+
+	return control
+}
+
+func newPTZVelocityCommand(velocity *protov1.PTZVelocity, timeoutMs uint32) *protov1.PTZCommand {
+	return &protov1.PTZCommand{
+		OperationType: protov1.PTZOperationType_PTZ_OPERATION_TYPE_CONTINUOUS_MOVE,
+		Command: &protov1.PTZCommand_ContinuousMove{ContinuousMove: &protov1.ContinuousMoveCommand{
+			Velocity:  velocity,
+			TimeoutMs: timeoutMs,
+		}},
+	}
+}
+
+// scaleRange は範囲の幅に対する value の割合を返します。
+func scaleRange(value, lower, upper float64) float64 {
+	if upper <= lower {
+		return 0
+	}
+
+	return value / (upper - lower)
+}
+
+// denormalizeRange は 0.0〜1.0 の割合を範囲の値に戻します。
+func denormalizeRange(ratio, lower, upper float64) float64 {
+	return lower + clamp(ratio, 0, 1)*(upper-lower)
+}
+
+// EnqueueControlCommand は制御コマンドを、コマンドIDをタスクIDとする PTZ枠（Layer 1）のタスクとして追加します。
+// EnqueuePTZCommand と同様にシネマティック枠を破棄して中断させます。
+// stop が true の場合は停止の命令として PTZ枠の未完了のタスクも中断し、実行中であれば中断フラグを設定します。
+// タスクのイベントには元の制御コマンド control が設定されます（Event.ControlCommand）。
+// タスクがキューの先頭になり、すぐに実行対象になった場合は true を返します。
+func (r *PTZRepo) EnqueueControlCommand(
+	cameraID string,
+	control *protov1.ControlCommand,
+	command *protov1.PTZCommand,
+	stop bool,
+) bool {
+	taskID := control.GetCommandId()
+
+	r.mu.Lock()
+
+	queue := r.getOrCreateCameraQueue(cameraID)
+
+	task := &protov1.Task{
+		TaskId:           taskID,
+		Layer:            protov1.CommandLayer_COMMAND_LAYER_PTZ,
+		Status:           protov1.TaskStatus_TASK_STATUS_PENDING,
+		PtzCommand:       command,
+		CinematicCommand: nil,
+		Interrupt:        false,
+		CreatedAtMs:      time.Now().UnixMilli(),
+	}
+
+	events := r.preemptCinematicQueue(queue)
+	if stop {
+		events = append(events, r.preemptPTZQueue(queue)...)
+	}
+
+	queue.PTZQueue = append(queue.PTZQueue, task)
+	r.controlCommandTasks[taskID] = control
+
+	r.publishTaskEvent(EventTaskEnqueued, cameraID, task)
+	started := r.startNextTask(queue) && queue.ExecutingTask == task
+
+	r.mu.Unlock()
+
+	r.publishTaskEvents(events)
+	r.notifyChange()
+
+	return started
+}
+
+// preemptPTZQueue は PTZ枠を全クリアし、破棄したタスクを中断として通知します。
+// 実行中のタスクが PTZ枠の場合は中断フラグを設定します。r.mu を保持して呼び出します。
+func (r *PTZRepo) preemptPTZQueue(queue *CameraQueue) []*TaskEvent {
+	events := make([]*TaskEvent, 0, len(queue.PTZQueue))
+	for _, cleared := range queue.PTZQueue {
+		cleared.Status = protov1.TaskStatus_TASK_STATUS_INTERRUPTED
+		events = append(events, newTaskEvent(queue.CameraID, cleared, TaskOutcomePreempted, nil))
+		r.publishTaskEvent(EventTaskInterrupted, queue.CameraID, cleared)
+		r.forgetTask(cleared.GetTaskId())
+	}
+
+	queue.PTZQueue = make([]*protov1.Task, 0)
+
+	if queue.ExecutingTask.GetLayer() == protov1.CommandLayer_COMMAND_LAYER_PTZ {
+		queue.Interrupt = true
+	}
+
+	return events
+}
+
+// TaskOutcomeOf は終了状態の制御コマンドに対応するタスクの終了の理由を返します。
+func TaskOutcomeOf(state ControlCommandState) TaskOutcome {
+	switch state {
+	case ControlCommandStateCompleted:
+		return TaskOutcomeCompleted
+	case ControlCommandStateTimedOut:
+		return TaskOutcomeTimedOut
+	case ControlCommandStatePending, ControlCommandStateDispatched, ControlCommandStateFailed:
+	}
+
+	return TaskOutcomeFailed
+}
+
+// FinishTask はポーリング以外の経路（制御コマンドの結果など）で報告されたタスクの終了を反映します。
+// outcome が TaskOutcomeCompleted の場合は完了、それ以外は中断としてキューから削除し、outcome を終了の理由として通知します。
+// 実行中のタスクが失敗した場合は中断フラグを設定し、ポーリングするFDにも動作を止めさせます。
+// 終了したタスクの次のタスクは、ポーリングを待たずに実行対象になります。
+// タスクがキューにない場合は false を返します。
+func (r *PTZRepo) FinishTask(
+	cameraID string,
+	taskID string,
+	outcome TaskOutcome,
+	currentPTZ *protov1.PTZParameters,
+) bool {
+	r.mu.Lock()
+
+	queue, ok := r.cameraQueues[cameraID]
+	if !ok {
+		r.mu.Unlock()
+
+		return false
+	}
+
+	task := r.dequeueCompletedPTZTask(queue, taskID)
+	if task == nil {
+		task = r.dequeueCompletedCinematicTask(queue, taskID)
+	}
+
+	if task == nil {
+		r.mu.Unlock()
+
+		return false
+	}
+
+	eventType := EventTaskCompleted
+
+	if outcome != TaskOutcomeCompleted {
+		task.Status = protov1.TaskStatus_TASK_STATUS_INTERRUPTED
+		eventType = EventTaskInterrupted
+
+		if queue.ExecutingTask.GetTaskId() == taskID {
+			queue.Interrupt = true
+		}
+	}
+
+	r.clearExecutingTaskIfCompleted(queue, taskID)
+	r.publishTaskEvent(eventType, cameraID, task)
+	r.forgetTask(taskID)
+	r.startNextTask(queue)
+
+	r.mu.Unlock()
+
+	r.publishTaskEvents([]*TaskEvent{newTaskEvent(cameraID, task, outcome, currentPTZ)})
+	r.notifyProgress()
+
+	return true
+}
@@ -0,0 +1,526 @@
+package infrastructure
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"maps"
+	"slices"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+
+	protov1 "github.com/anyfld/vistra-operation-control-room/gen/proto/v1"
+)
+
+const (
+	// defaultControlCommandTimeout は TimeoutMs が指定されていない制御コマンドのタイムアウトです。
+	defaultControlCommandTimeout = 30 * time.Second
+	// maxControlCommandHistory はカメラごとに保持する制御コマンドの数です。
+	// 超えた場合は終了状態の古い制御コマンドから破棄します。
+	maxControlCommandHistory = 256
+)
+
+var ErrControlCommandNotFound = errors.New("control command not found")
+
+// ControlCommandState は制御コマンドの状態です。
+//
+//	pending → dispatched → completed | failed | timed_out
+//
+// FDへ配信される前に失敗・タイムアウトすることもあります。
+type ControlCommandState string
+
+const (
+	// ControlCommandStatePending はFDへの配信を待っている状態です。
+	ControlCommandStatePending ControlCommandState = "pending"
+	// ControlCommandStateDispatched はFDへ配信し、結果を待っている状態です。
+	ControlCommandStateDispatched ControlCommandState = "dispatched"
+	// ControlCommandStateCompleted はFDが成功を報告した状態です。
+	ControlCommandStateCompleted ControlCommandState = "completed"
+	// ControlCommandStateFailed はFDが失敗を報告した、またはサーバーが拒否した状態です。
+	ControlCommandStateFailed ControlCommandState = "failed"
+	// ControlCommandStateTimedOut は TimeoutMs の間に結果が報告されなかった状態です。
+	ControlCommandStateTimedOut ControlCommandState = "timed_out"
+)
+
+// IsTerminal は状態がこれ以上変化しないかどうかを返します。
+func (s ControlCommandState) IsTerminal() bool {
+	return s == ControlCommandStateCompleted || s == ControlCommandStateFailed || s == ControlCommandStateTimedOut
+}
+
+// ControlCommandStatus は制御コマンドの状態のコピーです。Result は終了状態の場合のみ設定されます。
+type ControlCommandStatus struct {
+	Command        *protov1.ControlCommand
+	State          ControlCommandState
+	Result         *protov1.ControlCommandResult
+	CreatedAtMs    int64
+	DispatchedAtMs int64
+	FinishedAtMs   int64
+	// Adjustment はカメラの制限で制御コマンドを補正した理由です。配信した時点の状態にのみ設定されます。
+	Adjustment string
+}
+
+type controlCommandEntry struct {
+	status ControlCommandStatus
+	timer  *time.Timer
+	// sent はFDへ配信する制御コマンドかどうかです。サーバー側で処理した制御コマンドの結果はFDへ配信しません。
+	sent bool
+	// queued はカメラのキューにタスクを作成した制御コマンドかどうかです。
+	queued bool
+	// done は終了状態になったときに閉じられます。
+	done chan struct{}
+}
+
+// queuedCommand はキューにタスクを作成した制御コマンドの場合に制御コマンドを返し、それ以外は nil を返します。
+func (e *controlCommandEntry) queuedCommand() *protov1.ControlCommand {
+	if !e.queued {
+		return nil
+	}
+
+	return e.status.Command
+}
+
+func (e *controlCommandEntry) snapshot() *ControlCommandStatus {
+	status := e.status
+
+	return &status
+}
+
+// SendControlCommand は制御コマンドを pending として記録し、カメラの購読者（FD）へ配信します。
+// 購読者に届いた時点で dispatched になり、FDが ReportControlCommandResult で結果を報告するか、
+// TimeoutMs（未指定の場合は defaultControlCommandTimeout）が経過すると終了状態になります。
+func (r *FDRepo) SendControlCommand(command *protov1.ControlCommand) *ControlCommandStatus {
+	r.mu.Lock()
+
+	if command.GetCommandId() == "" {
+		command.CommandId = fmt.Sprintf("cmd-%d", time.Now().UnixNano())
+	}
+
+	return r.dispatchControlCommand(r.storeControlCommand(command, time.Now().UnixMilli()))
+}
+
+// QueueControlCommand はカメラのキューにタスクを作成する制御コマンドを、配信せずに pending として記録します。
+// タスクが実行対象になったときに DispatchQueuedControlCommand で配信し、タイムアウトもその時点から数えます。
+func (r *FDRepo) QueueControlCommand(command *protov1.ControlCommand) *ControlCommandStatus {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if command.GetCommandId() == "" {
+		command.CommandId = fmt.Sprintf("cmd-%d", time.Now().UnixNano())
+	}
+
+	entry := r.storeControlCommand(command, time.Now().UnixMilli())
+	entry.queued = true
+
+	return entry.snapshot()
+}
+
+// DispatchQueuedControlCommand は QueueControlCommand で記録した制御コマンドを SendControlCommand と同様に配信し、
+// control_command.* のイベントに制御コマンドを設定します。既に配信した、または終了した制御コマンドは配信せずに
+// 現在の状態を返します。記録がない場合（リーダーの交代でキューだけを引き継いだ場合など）は記録して配信します。
+func (r *FDRepo) DispatchQueuedControlCommand(command *protov1.ControlCommand) *ControlCommandStatus {
+	r.mu.Lock()
+
+	entry, ok := r.controlCommands[command.GetCommandId()]
+	if ok && (entry.sent || entry.status.State.IsTerminal()) {
+		status := entry.snapshot()
+
+		r.mu.Unlock()
+
+		return status
+	}
+
+	if !ok {
+		entry = r.storeControlCommand(command, time.Now().UnixMilli())
+		entry.queued = true
+	}
+
+	return r.dispatchControlCommand(entry)
+}
+
+// dispatchControlCommand は記録した制御コマンドのタイムアウトを開始し、カメラの購読者（FD）へ配信します。
+// r.mu を保持して呼び出し、r.mu は配信の前に解放されます。
+func (r *FDRepo) dispatchControlCommand(entry *controlCommandEntry) *ControlCommandStatus {
+	command := entry.status.Command
+	commandID := command.GetCommandId()
+
+	timeout := defaultControlCommandTimeout
+	if command.GetTimeoutMs() > 0 {
+		timeout = time.Duration(command.GetTimeoutMs()) * time.Millisecond
+	}
+
+	now := time.Now().UnixMilli()
+	entry.sent = true
+	entry.timer = time.AfterFunc(timeout, func() { r.timeoutControlCommand(commandID) })
+
+	cameraID := command.GetCameraId()
+	event := &PTZCommandEvent{
+		Sequence:    0,
+		Command:     command,
+		Result:      nil,
+		TimestampMs: now,
+	}
+
+	if angles := command.GetArmParameters().GetJointAngles(); cameraID != "" && len(angles) > 0 {
+		r.armPoses[cameraID] = slices.Clone(angles)
+	}
+
+	if command.GetType() == protov1.ControlCommandType_CONTROL_COMMAND_TYPE_FOCUS && cameraID != "" {
+		r.focusModes[cameraID] = FocusModeOf(command.GetFocusValue())
+	}
+
+	r.events.PublishControlCommandEvent(
+		EventTopicTask, EventControlCommandDispatched, commandID, cameraID, command, entry.queuedCommand(),
+	)
+
+	r.mu.Unlock()
+
+	if cameraID != "" && r.publishPTZCommand(cameraID, event) {
+		r.markControlCommandDispatched(commandID)
+	}
+
+	return r.GetControlCommandStatus(commandID)
+}
+
+// FinishControlCommand はFDへ配信せずにサーバー側で処理した制御コマンドを、結果とともに記録します。
+func (r *FDRepo) FinishControlCommand(
+	command *protov1.ControlCommand,
+	result *protov1.ControlCommandResult,
+) *ControlCommandStatus {
+	r.mu.Lock()
+
+	if command.GetCommandId() == "" {
+		command.CommandId = fmt.Sprintf("cmd-%d", time.Now().UnixNano())
+	}
+
+	result.CommandId = command.GetCommandId()
+
+	entry := r.storeControlCommand(command, time.Now().UnixMilli())
+	status := r.finishControlCommand(entry, result, resultState(result))
+
+	r.mu.Unlock()
+
+	r.publishControlCommandResult(status, false, nil)
+
+	return status
+}
+
+// ReportControlCommandResult はFDが報告した結果を記録します。
+// 既に終了状態の制御コマンドの結果は無視し、現在の状態を返します。
+func (r *FDRepo) ReportControlCommandResult(result *protov1.ControlCommandResult) (*ControlCommandStatus, error) {
+	r.mu.Lock()
+
+	entry, ok := r.controlCommands[result.GetCommandId()]
+	if !ok {
+		r.mu.Unlock()
+
+		return nil, fmt.Errorf("%w: %s", ErrControlCommandNotFound, result.GetCommandId())
+	}
+
+	if entry.status.State.IsTerminal() {
+		status := entry.snapshot()
+
+		r.mu.Unlock()
+
+		log.Printf(
+			"control command result ignored: command_id=%s state=%s",
+			result.GetCommandId(), status.State,
+		)
+
+		return status, nil
+	}
+
+	result, ok = proto.Clone(result).(*protov1.ControlCommandResult)
+	if !ok {
+		r.mu.Unlock()
+
+		return nil, errors.New("failed to clone control command result")
+	}
+
+	if result.GetExecutionTimeMs() == 0 && entry.status.DispatchedAtMs > 0 {
+		result.ExecutionTimeMs = uint32(max(time.Now().UnixMilli()-entry.status.DispatchedAtMs, 0))
+	}
+
+	status := r.finishControlCommand(entry, result, resultState(result))
+	sent := entry.sent
+	queued := entry.queuedCommand()
+
+	r.mu.Unlock()
+
+	r.publishControlCommandResult(status, sent, queued)
+
+	return status, nil
+}
+
+// GetControlCommand はIDで制御コマンドを返します。存在しない場合は nil を返します。
+func (r *FDRepo) GetControlCommand(commandID string) *protov1.ControlCommand {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	entry, ok := r.controlCommands[commandID]
+	if !ok {
+		return nil
+	}
+
+	return entry.status.Command
+}
+
+// GetControlCommandStatus は制御コマンドの状態を返します。存在しない場合は nil を返します。
+func (r *FDRepo) GetControlCommandStatus(commandID string) *ControlCommandStatus {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	entry, ok := r.controlCommands[commandID]
+	if !ok {
+		return nil
+	}
+
+	return entry.snapshot()
+}
+
+// ControlCommandFilter は制御コマンドの履歴の検索条件です。ゼロ値の項目は条件に含めません。
+type ControlCommandFilter struct {
+	CameraID string
+	// SinceMs 以上 UntilMs 未満に作成された制御コマンドを返します。
+	SinceMs int64
+	UntilMs int64
+	Types   []protov1.ControlCommandType
+	Limit   int
+}
+
+func (f ControlCommandFilter) matches(status *ControlCommandStatus) bool {
+	if f.SinceMs > 0 && status.CreatedAtMs < f.SinceMs {
+		return false
+	}
+
+	if f.UntilMs > 0 && status.CreatedAtMs >= f.UntilMs {
+		return false
+	}
+
+	return len(f.Types) == 0 || slices.Contains(f.Types, status.Command.GetType())
+}
+
+// ListControlCommands は条件に一致する制御コマンドを新しい順に返します。
+func (r *FDRepo) ListControlCommands(filter ControlCommandFilter) []*ControlCommandStatus {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	cameraIDs := []string{filter.CameraID}
+	if filter.CameraID == "" {
+		cameraIDs = slices.Collect(maps.Keys(r.controlCommandHistory))
+	}
+
+	statuses := make([]*ControlCommandStatus, 0)
+
+	for _, cameraID := range cameraIDs {
+		// 同じミリ秒に作成された制御コマンドも新しい順になるよう、履歴を逆順にたどる
+		history := r.controlCommandHistory[cameraID]
+		for i := len(history) - 1; i >= 0; i-- {
+			commandID := history[i]
+			if status := r.controlCommands[commandID].snapshot(); filter.matches(status) {
+				statuses = append(statuses, status)
+			}
+		}
+	}
+
+	slices.SortStableFunc(statuses, func(a, b *ControlCommandStatus) int {
+		return cmp.Compare(b.CreatedAtMs, a.CreatedAtMs)
+	})
+
+	if filter.Limit > 0 && len(statuses) > filter.Limit {
+		statuses = statuses[:filter.Limit]
+	}
+
+	return statuses
+}
+
+// WaitControlCommand は制御コマンドが終了状態になるまで待ちます。
+func (r *FDRepo) WaitControlCommand(ctx context.Context, commandID string) (*ControlCommandStatus, error) {
+	r.mu.RLock()
+	entry, ok := r.controlCommands[commandID]
+	r.mu.RUnlock()
+
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrControlCommandNotFound, commandID)
+	}
+
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-entry.done:
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return entry.snapshot(), nil
+}
+
+// storeControlCommand は制御コマンドを記録します。r.mu を保持して呼び出します。
+func (r *FDRepo) storeControlCommand(command *protov1.ControlCommand, createdAtMs int64) *controlCommandEntry {
+	// 同じIDで再送された場合は、前の制御コマンドを失敗として終了させる
+	if previous, ok := r.controlCommands[command.GetCommandId()]; ok && !previous.status.State.IsTerminal() {
+		r.finishControlCommand(previous, &protov1.ControlCommandResult{
+			CommandId:       command.GetCommandId(),
+			Success:         false,
+			ErrorMessage:    "superseded by a command with the same id",
+			ResultingPtz:    nil,
+			ExecutionTimeMs: 0,
+		}, ControlCommandStateFailed)
+	}
+
+	if previous, ok := r.controlCommands[command.GetCommandId()]; ok {
+		cameraID := previous.status.Command.GetCameraId()
+		r.controlCommandHistory[cameraID] = slices.DeleteFunc(
+			r.controlCommandHistory[cameraID],
+			func(id string) bool { return id == command.GetCommandId() },
+		)
+	}
+
+	entry := &controlCommandEntry{
+		status: ControlCommandStatus{
+			Command:        command,
+			State:          ControlCommandStatePending,
+			Result:         nil,
+			CreatedAtMs:    createdAtMs,
+			DispatchedAtMs: 0,
+			FinishedAtMs:   0,
+			Adjustment:     "",
+		},
+		timer:  nil,
+		sent:   false,
+		queued: false,
+		done:   make(chan struct{}),
+	}
+
+	r.controlCommands[command.GetCommandId()] = entry
+	r.controlCommandHistory[command.GetCameraId()] = append(
+		r.controlCommandHistory[command.GetCameraId()],
+		command.GetCommandId(),
+	)
+	r.trimControlCommandHistory(command.GetCameraId())
+
+	return entry
+}
+
+// trimControlCommandHistory はカメラの制御コマンドが maxControlCommandHistory を超えた分を、
+// 終了状態の古いものから破棄します。結果を待っている制御コマンドはタイムアウトまで保持します。
+// r.mu を保持して呼び出します。
+func (r *FDRepo) trimControlCommandHistory(cameraID string) {
+	history := r.controlCommandHistory[cameraID]
+
+	for excess := len(history) - maxControlCommandHistory; excess > 0; excess-- {
+		index := slices.IndexFunc(history, func(id string) bool {
+			return r.controlCommands[id].status.State.IsTerminal()
+		})
+		if index < 0 {
+			break
+		}
+
+		delete(r.controlCommands, history[index])
+		history = slices.Delete(history, index, index+1)
+	}
+
+	r.controlCommandHistory[cameraID] = history
+}
+
+// finishControlCommand は制御コマンドを終了状態にします。r.mu を保持して呼び出します。
+func (r *FDRepo) finishControlCommand(
+	entry *controlCommandEntry,
+	result *protov1.ControlCommandResult,
+	state ControlCommandState,
+) *ControlCommandStatus {
+	if entry.timer != nil {
+		entry.timer.Stop()
+	}
+
+	entry.status.State = state
+	entry.status.Result = result
+	entry.status.FinishedAtMs = time.Now().UnixMilli()
+	close(entry.done)
+
+	return entry.snapshot()
+}
+
+// markControlCommandDispatched は pending の制御コマンドを dispatched にします。
+func (r *FDRepo) markControlCommandDispatched(commandID string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	entry, ok := r.controlCommands[commandID]
+	if !ok || entry.status.State != ControlCommandStatePending {
+		return
+	}
+
+	entry.status.State = ControlCommandStateDispatched
+	entry.status.DispatchedAtMs = time.Now().UnixMilli()
+}
+
+func (r *FDRepo) timeoutControlCommand(commandID string) {
+	r.mu.Lock()
+
+	entry, ok := r.controlCommands[commandID]
+	if !ok || entry.status.State.IsTerminal() {
+		r.mu.Unlock()
+
+		return
+	}
+
+	status := r.finishControlCommand(entry, &protov1.ControlCommandResult{
+		CommandId:       commandID,
+		Success:         false,
+		ErrorMessage:    "timed out waiting for the result from fd",
+		ResultingPtz:    nil,
+		ExecutionTimeMs: 0,
+	}, ControlCommandStateTimedOut)
+	sent := entry.sent
+	queued := entry.queuedCommand()
+
+	r.mu.Unlock()
+
+	r.publishControlCommandResult(status, sent, queued)
+}
+
+// publishControlCommandResult は結果をイベントバスへ配信します。sent が true の場合はカメラの購読者にも配信します。
+// queued はキューにタスクを作成した制御コマンドの場合に、イベントに設定する制御コマンドです。
+func (r *FDRepo) publishControlCommandResult(
+	status *ControlCommandStatus,
+	sent bool,
+	queued *protov1.ControlCommand,
+) {
+	eventType := EventControlCommandCompleted
+
+	switch status.State {
+	case ControlCommandStateFailed:
+		eventType = EventControlCommandFailed
+	case ControlCommandStateTimedOut:
+		eventType = EventControlCommandTimedOut
+	case ControlCommandStatePending, ControlCommandStateDispatched, ControlCommandStateCompleted:
+	}
+
+	cameraID := status.Command.GetCameraId()
+	r.events.PublishControlCommandEvent(
+		EventTopicTask, eventType, status.Command.GetCommandId(), cameraID, status.Result, queued,
+	)
+
+	if cameraID == "" || !sent {
+		return
+	}
+
+	r.publishPTZCommand(cameraID, &PTZCommandEvent{
+		Sequence:    0,
+		Command:     status.Command,
+		Result:      status.Result,
+		TimestampMs: status.FinishedAtMs,
+	})
+}
+
+func resultState(result *protov1.ControlCommandResult) ControlCommandState {
+	if result.GetSuccess() {
+		return ControlCommandStateCompleted
+	}
+
+	return ControlCommandStateFailed
+}
@@ -11,16 +11,22 @@ import (
 type InMemoryRepo struct {
 	mu                   sync.RWMutex
 	masterMfs            map[string]*protov1.MasterMF
-	cameras              map[string]*protov1.Camera
 	currentConfiguration *protov1.Configuration
+	pendingInstructions  map[string]*protov1.CinematographyInstruction
+	resultSubscribers    []*mailbox[*protov1.CinematographyResult]
+	resultSubscribersMu  sync.RWMutex
+	events               *EventBus
 }
 
-func NewInMemoryRepo() *InMemoryRepo {
+func NewInMemoryRepo(events *EventBus) *InMemoryRepo {
 	return &InMemoryRepo{
 		mu:                   sync.RWMutex{},
 		masterMfs:            make(map[string]*protov1.MasterMF),
-		cameras:              make(map[string]*protov1.Camera),
 		currentConfiguration: nil,
+		pendingInstructions:  make(map[string]*protov1.CinematographyInstruction),
+		resultSubscribers:    make([]*mailbox[*protov1.CinematographyResult], 0),
+		resultSubscribersMu:  sync.RWMutex{},
+		events:               events,
 	}
 }
 
@@ -41,6 +47,8 @@ func (r *InMemoryRepo) RegisterMasterMF(req *protov1.RegisterMasterMFRequest) *p
 	}
 	r.masterMfs[masterID] = masterMF
 
+	r.events.Publish(EventTopicMF, EventMFRegistered, masterID, "", masterMF)
+
 	return masterMF
 }
 
@@ -48,12 +56,15 @@ func (r *InMemoryRepo) UnregisterMasterMF(masterID string) bool {
 	r.mu.Lock()
 	defer r.mu.Unlock()
 
-	if _, ok := r.masterMfs[masterID]; !ok {
+	masterMF, ok := r.masterMfs[masterID]
+	if !ok {
 		return false
 	}
 
 	delete(r.masterMfs, masterID)
 
+	r.events.Publish(EventTopicMF, EventMFUnregistered, masterID, "", masterMF)
+
 	return true
 }
 
@@ -80,55 +91,109 @@ func (r *InMemoryRepo) GetMasterMF(id string) *protov1.MasterMF {
 	return nil
 }
 
-func (r *InMemoryRepo) ListAllCameras() []*protov1.Camera {
-	r.mu.RLock()
-	defer r.mu.RUnlock()
+func (r *InMemoryRepo) PushConfiguration(cfg *protov1.Configuration, targetMasterMfIds []string) (bool, []string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
 
-	out := make([]*protov1.Camera, 0, len(r.cameras))
-	for _, v := range r.cameras {
-		out = append(out, v)
+	r.currentConfiguration = &protov1.Configuration{
+		Id:          cfg.GetId(),
+		Version:     cfg.GetVersion(),
+		ConfigJson:  cfg.GetConfigJson(),
+		CreatedAtMs: time.Now().UnixMilli(),
 	}
 
-	return out
+	r.events.Publish(EventTopicConfig, EventConfigPushed, cfg.GetId(), "", r.currentConfiguration)
+
+	return true, nil
 }
 
-func (r *InMemoryRepo) GetCamera(id string) *protov1.Camera {
+func (r *InMemoryRepo) GetConfiguration(masterMfId string) *protov1.Configuration {
 	r.mu.RLock()
 	defer r.mu.RUnlock()
 
-	if v, ok := r.cameras[id]; ok {
-		return v
+	return r.currentConfiguration
+}
+
+func (r *InMemoryRepo) AssignInstructionID(instruction *protov1.CinematographyInstruction) string {
+	if instruction.GetInstructionId() == "" {
+		instruction.InstructionId = fmt.Sprintf("instr-%d", time.Now().UnixNano())
 	}
 
-	return nil
+	return instruction.GetInstructionId()
 }
 
-func (r *InMemoryRepo) PushConfiguration(cfg *protov1.Configuration, targetMasterMfIds []string) (bool, []string) {
+func (r *InMemoryRepo) TrackCinematographyTask(taskID string, instruction *protov1.CinematographyInstruction) {
 	r.mu.Lock()
 	defer r.mu.Unlock()
 
-	r.currentConfiguration = &protov1.Configuration{
-		Id:          cfg.GetId(),
-		Version:     cfg.GetVersion(),
-		ConfigJson:  cfg.GetConfigJson(),
-		CreatedAtMs: time.Now().UnixMilli(),
+	r.pendingInstructions[taskID] = instruction
+}
+
+func (r *InMemoryRepo) CompleteCinematographyTask(
+	taskID string,
+	success bool,
+	errorMessage string,
+	appliedPtz *protov1.PTZParameters,
+) (*protov1.CinematographyResult, bool) {
+	r.mu.Lock()
+
+	instruction, ok := r.pendingInstructions[taskID]
+	if !ok {
+		r.mu.Unlock()
+
+		return nil, false
 	}
 
-	return true, nil
+	delete(r.pendingInstructions, taskID)
+
+	result := &protov1.CinematographyResult{
+		InstructionId: instruction.GetInstructionId(),
+		CameraId:      instruction.GetCameraId(),
+		Success:       success,
+		ErrorMessage:  errorMessage,
+		AppliedPtz:    appliedPtz,
+		CompletedAtMs: time.Now().UnixMilli(),
+	}
+
+	r.mu.Unlock()
+
+	r.publishCinematographyResult(result)
+
+	return result, true
 }
 
-func (r *InMemoryRepo) GetConfiguration(masterMfId string) *protov1.Configuration {
-	r.mu.RLock()
-	defer r.mu.RUnlock()
+// SubscribeCinematographyResults はシネマティックの結果を購読します。
+// 結果は受信が遅れても破棄せず、購読を解除するまで保持します。
+func (r *InMemoryRepo) SubscribeCinematographyResults() <-chan *protov1.CinematographyResult {
+	subscriber := newMailbox[*protov1.CinematographyResult]()
 
-	return r.currentConfiguration
+	r.resultSubscribersMu.Lock()
+	r.resultSubscribers = append(r.resultSubscribers, subscriber)
+	r.resultSubscribersMu.Unlock()
+
+	return subscriber.ch
+}
+
+func (r *InMemoryRepo) UnsubscribeCinematographyResults(resultCh <-chan *protov1.CinematographyResult) {
+	r.resultSubscribersMu.Lock()
+	defer r.resultSubscribersMu.Unlock()
+
+	for i, subscriber := range r.resultSubscribers {
+		if subscriber.ch == resultCh {
+			r.resultSubscribers = append(r.resultSubscribers[:i], r.resultSubscribers[i+1:]...)
+
+			subscriber.close()
+
+			return
+		}
+	}
 }
 
-func (r *InMemoryRepo) SendCinematographyInstruction(
-	req *protov1.SendCinematographyInstructionRequest,
-) *protov1.SendCinematographyInstructionResponse {
-	return &protov1.SendCinematographyInstructionResponse{
-		Accepted:      true,
-		InstructionId: fmt.Sprintf("instr-%d", time.Now().UnixNano()),
+func (r *InMemoryRepo) publishCinematographyResult(result *protov1.CinematographyResult) {
+	r.resultSubscribersMu.RLock()
+	defer r.resultSubscribersMu.RUnlock()
+
+	for _, subscriber := range r.resultSubscribers {
+		subscriber.push(result)
 	}
 }
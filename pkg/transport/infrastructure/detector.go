@@ -0,0 +1,402 @@
+package infrastructure
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"strconv"
+	"strings"
+
+	protov1 "github.com/anyfld/vistra-operation-control-room/gen/proto/v1"
+)
+
+// SubjectTypeColorBlob の被写体は Label に "#rrggbb" 形式の色を指定し、その色の領域として検出します。
+const SubjectTypeColorBlob = "color_blob"
+
+const (
+	// detectionWidth は検出時に縮小するフレームの最大幅（画素）です。
+	detectionWidth = 160
+	// maxSamplesPerAxis は縮小時に1画素あたり平均する元画像の標本数（1軸あたり）です。
+	maxSamplesPerAxis = 4
+	minTemplateSize   = 6
+	// minWindowVariance は相関を計算する窓の1画素あたりの最小の分散です。平坦な領域は誤検出しやすいため除きます。
+	minWindowVariance = 1.0
+
+	defaultMatchThreshold = 0.7
+	// colorBlobTolerance はRGB空間での色の許容距離です。
+	colorBlobTolerance = 60.0
+	// minBlobFraction は色領域として扱う最小の面積（フレームに対する割合）です。
+	minBlobFraction = 0.001
+	hexColorLength  = 6
+	colorChannelMax = 0xff
+)
+
+var ErrInvalidSubject = errors.New("invalid subject")
+
+//nolint:gochecknoglobals,mnd
+var templateScales = []float64{0.1, 0.15, 0.2, 0.3, 0.4, 0.5, 0.6}
+
+// ReferenceDetector は純粋なGoで実装した被写体検出器です。
+//
+// ReferenceImageUrl を持つ被写体は、参照画像をテンプレートとした正規化相互相関で検出します。
+// Subject.BoundingBox が指定されている場合はその大きさのテンプレートだけを、
+// 指定されていない場合は複数の大きさを試します。
+// Type が SubjectTypeColorBlob の被写体は、Label の色に近い画素の最大の連結領域として検出します。
+// どちらにも当てはまらない被写体と、しきい値に満たない被写体は結果に含めません。
+type ReferenceDetector struct {
+	loader    *ImageLoader
+	threshold float64
+}
+
+// NewReferenceDetector は新しいReferenceDetectorを作成します。
+func NewReferenceDetector(loader *ImageLoader) *ReferenceDetector {
+	return &ReferenceDetector{
+		loader:    loader,
+		threshold: defaultMatchThreshold,
+	}
+}
+
+// Detect はフレームから被写体を検出します。
+func (d *ReferenceDetector) Detect(
+	ctx context.Context,
+	frame image.Image,
+	subjects []*protov1.Subject,
+) ([]*protov1.DetectedSubject, error) {
+	plane := newPixelPlane(frame, detectionWidth)
+	detected := make([]*protov1.DetectedSubject, 0, len(subjects))
+
+	for _, subject := range subjects {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		var (
+			box        *protov1.BoundingBox
+			confidence float64
+		)
+
+		switch {
+		case subject.GetReferenceImageUrl() != "":
+			reference, err := d.loader.LoadReference(ctx, subject.GetReferenceImageUrl())
+			if err != nil {
+				return nil, fmt.Errorf("subject %s: %w", subject.GetId(), err)
+			}
+
+			box, confidence = matchTemplate(plane, reference, subject.GetBoundingBox())
+		case subject.GetType() == SubjectTypeColorBlob:
+			target, err := parseHexColor(subject.GetLabel())
+			if err != nil {
+				return nil, fmt.Errorf("subject %s: %w", subject.GetId(), err)
+			}
+
+			box, confidence = findColorBlob(plane, target)
+		default:
+			continue
+		}
+
+		if box == nil || confidence < d.threshold {
+			continue
+		}
+
+		detected = append(detected, &protov1.DetectedSubject{
+			Subject:     subject,
+			Confidence:  float32(confidence),
+			DetectedBox: box,
+		})
+	}
+
+	return detected, nil
+}
+
+// pixelPlane は縮小したRGBと輝度の画素です。
+type pixelPlane struct {
+	width, height int
+	red           []float64
+	green         []float64
+	blue          []float64
+	luma          []float64
+}
+
+// newPixelPlane は幅が maxWidth 以下になるよう画像を平均縮小します。
+func newPixelPlane(img image.Image, maxWidth int) *pixelPlane {
+	bounds := img.Bounds()
+	scale := max(1, float64(bounds.Dx())/float64(maxWidth))
+	width := max(1, int(float64(bounds.Dx())/scale))
+	height := max(1, int(float64(bounds.Dy())/scale))
+
+	plane := &pixelPlane{
+		width:  width,
+		height: height,
+		red:    make([]float64, width*height),
+		green:  make([]float64, width*height),
+		blue:   make([]float64, width*height),
+		luma:   make([]float64, width*height),
+	}
+
+	for y := range height {
+		y0 := bounds.Min.Y + int(float64(y)*scale)
+		y1 := max(y0+1, bounds.Min.Y+int(float64(y+1)*scale))
+		yStep := max(1, (y1-y0)/maxSamplesPerAxis)
+
+		for x := range width {
+			x0 := bounds.Min.X + int(float64(x)*scale)
+			x1 := max(x0+1, bounds.Min.X+int(float64(x+1)*scale))
+			xStep := max(1, (x1-x0)/maxSamplesPerAxis)
+
+			var r, g, b, n float64
+
+			for sy := y0; sy < y1; sy += yStep {
+				for sx := x0; sx < x1; sx += xStep {
+					c := color.NRGBAModel.Convert(img.At(sx, sy)).(color.NRGBA) //nolint:forcetypeassert
+					r += float64(c.R)
+					g += float64(c.G)
+					b += float64(c.B)
+					n++
+				}
+			}
+
+			i := y*width + x
+			plane.red[i], plane.green[i], plane.blue[i] = r/n, g/n, b/n
+			plane.luma[i] = 0.299*plane.red[i] + 0.587*plane.green[i] + 0.114*plane.blue[i] //nolint:mnd
+		}
+	}
+
+	return plane
+}
+
+// integral は輝度の総和と二乗和の積分画像で、任意の矩形の平均・分散を定数時間で求めます。
+type integral struct {
+	stride int
+	sum    []float64
+	sumSq  []float64
+}
+
+func newIntegral(plane *pixelPlane) *integral {
+	stride := plane.width + 1
+	ii := &integral{
+		stride: stride,
+		sum:    make([]float64, stride*(plane.height+1)),
+		sumSq:  make([]float64, stride*(plane.height+1)),
+	}
+
+	for y := range plane.height {
+		var rowSum, rowSumSq float64
+
+		for x := range plane.width {
+			v := plane.luma[y*plane.width+x]
+			rowSum += v
+			rowSumSq += v * v
+			ii.sum[(y+1)*stride+x+1] = ii.sum[y*stride+x+1] + rowSum
+			ii.sumSq[(y+1)*stride+x+1] = ii.sumSq[y*stride+x+1] + rowSumSq
+		}
+	}
+
+	return ii
+}
+
+func (ii *integral) rect(values []float64, x, y, w, h int) float64 {
+	return values[(y+h)*ii.stride+x+w] - values[y*ii.stride+x+w] - values[(y+h)*ii.stride+x] + values[y*ii.stride+x]
+}
+
+// matchTemplate は参照画像と最も相関の高い位置と、その正規化相互相関 (-1.0-1.0) を返します。
+func matchTemplate(
+	plane *pixelPlane,
+	reference image.Image,
+	hint *protov1.BoundingBox,
+) (*protov1.BoundingBox, float64) {
+	refBounds := reference.Bounds()
+	aspect := float64(refBounds.Dy()) / float64(refBounds.Dx())
+
+	widths := make([]int, 0, len(templateScales))
+	if hint.GetWidth() > 0 {
+		widths = append(widths, int(float64(hint.GetWidth())*float64(plane.width)))
+	} else {
+		for _, scale := range templateScales {
+			widths = append(widths, int(scale*float64(plane.width)))
+		}
+	}
+
+	ii := newIntegral(plane)
+
+	var (
+		bestBox   *protov1.BoundingBox
+		bestScore = math.Inf(-1)
+	)
+
+	for _, width := range widths {
+		height := int(float64(width) * aspect)
+		if width < minTemplateSize || height < minTemplateSize || width > plane.width || height > plane.height {
+			continue
+		}
+
+		template := newPixelPlane(reference, width)
+		box, score := matchTemplateAtScale(plane, ii, template)
+
+		if box != nil && score > bestScore {
+			bestBox, bestScore = box, score
+		}
+	}
+
+	return bestBox, bestScore
+}
+
+func matchTemplateAtScale(plane *pixelPlane, ii *integral, template *pixelPlane) (*protov1.BoundingBox, float64) {
+	tw, th := template.width, template.height
+	n := float64(tw * th)
+
+	// テンプレートを平均 0 にしておくと、相関の分子からフレーム側の平均を引く必要がなくなる
+	var mean float64
+	for _, v := range template.luma {
+		mean += v
+	}
+
+	mean /= n
+
+	centered := make([]float64, len(template.luma))
+
+	var norm float64
+
+	for i, v := range template.luma {
+		centered[i] = v - mean
+		norm += centered[i] * centered[i]
+	}
+
+	if norm == 0 {
+		return nil, 0
+	}
+
+	norm = math.Sqrt(norm)
+
+	var (
+		bestX, bestY int
+		bestScore    = math.Inf(-1)
+	)
+
+	for y := 0; y+th <= plane.height; y++ {
+		for x := 0; x+tw <= plane.width; x++ {
+			sum := ii.rect(ii.sum, x, y, tw, th)
+
+			variance := ii.rect(ii.sumSq, x, y, tw, th) - sum*sum/n
+			if variance <= minWindowVariance*n {
+				continue
+			}
+
+			var dot float64
+
+			for ty := range th {
+				row := (y+ty)*plane.width + x
+				for tx := range tw {
+					dot += centered[ty*tw+tx] * plane.luma[row+tx]
+				}
+			}
+
+			if score := dot / (norm * math.Sqrt(variance)); score > bestScore {
+				bestX, bestY, bestScore = x, y, score
+			}
+		}
+	}
+
+	if math.IsInf(bestScore, -1) {
+		return nil, 0
+	}
+
+	return normalizedBox(plane, bestX, bestY, tw, th), bestScore
+}
+
+// findColorBlob は target に近い色の画素の最大の4連結領域を返します。
+// 信頼度は領域が外接矩形に占める割合です。
+func findColorBlob(plane *pixelPlane, target color.NRGBA) (*protov1.BoundingBox, float64) {
+	total := plane.width * plane.height
+	mask := make([]bool, total)
+
+	for i := range total {
+		dr := plane.red[i] - float64(target.R)
+		dg := plane.green[i] - float64(target.G)
+		db := plane.blue[i] - float64(target.B)
+		mask[i] = dr*dr+dg*dg+db*db <= colorBlobTolerance*colorBlobTolerance
+	}
+
+	var (
+		bestBox   *protov1.BoundingBox
+		bestCount int
+		bestFill  float64
+		queue     = make([]int, 0, total)
+	)
+
+	for start := range total {
+		if !mask[start] {
+			continue
+		}
+
+		mask[start] = false
+		queue = append(queue[:0], start)
+		minX, minY, maxX, maxY := plane.width, plane.height, 0, 0
+
+		for head := 0; head < len(queue); head++ {
+			i := queue[head]
+			x, y := i%plane.width, i/plane.width
+			minX, maxX = min(minX, x), max(maxX, x)
+			minY, maxY = min(minY, y), max(maxY, y)
+
+			for _, next := range [...]int{i - 1, i + 1, i - plane.width, i + plane.width} {
+				if next < 0 || next >= total || !mask[next] {
+					continue
+				}
+
+				// 左右の隣接は同じ行に限る
+				if (next == i-1 || next == i+1) && next/plane.width != y {
+					continue
+				}
+
+				mask[next] = false
+				queue = append(queue, next)
+			}
+		}
+
+		if len(queue) > bestCount {
+			w, h := maxX-minX+1, maxY-minY+1
+			bestCount = len(queue)
+			bestFill = float64(bestCount) / float64(w*h)
+			bestBox = normalizedBox(plane, minX, minY, w, h)
+		}
+	}
+
+	if float64(bestCount) < minBlobFraction*float64(total) {
+		return nil, 0
+	}
+
+	return bestBox, bestFill
+}
+
+func normalizedBox(plane *pixelPlane, x, y, w, h int) *protov1.BoundingBox {
+	return &protov1.BoundingBox{
+		X:      float32(x) / float32(plane.width),
+		Y:      float32(y) / float32(plane.height),
+		Width:  float32(w) / float32(plane.width),
+		Height: float32(h) / float32(plane.height),
+	}
+}
+
+func parseHexColor(value string) (color.NRGBA, error) {
+	var parsed color.NRGBA
+
+	hex := strings.TrimPrefix(strings.TrimSpace(value), "#")
+	if len(hex) != hexColorLength {
+		return parsed, fmt.Errorf("%w: color %q is not #rrggbb", ErrInvalidSubject, value)
+	}
+
+	rgb, err := strconv.ParseUint(hex, 16, 32)
+	if err != nil {
+		return parsed, fmt.Errorf("%w: color %q: %w", ErrInvalidSubject, value, err)
+	}
+
+	return color.NRGBA{
+		R: uint8(rgb >> 16 & colorChannelMax), //nolint:mnd
+		G: uint8(rgb >> 8 & colorChannelMax),  //nolint:mnd
+		B: uint8(rgb & colorChannelMax),
+		A: colorChannelMax,
+	}, nil
+}
@@ -0,0 +1,311 @@
+package infrastructure
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	protov1 "github.com/anyfld/vistra-operation-control-room/gen/proto/v1"
+)
+
+// GetCamera がカメラのメタデータに設定する推定値のキーです。
+const (
+	CameraMetadataEstimatedPan        = "estimated_pan_deg"
+	CameraMetadataEstimatedTilt       = "estimated_tilt_deg"
+	CameraMetadataEstimatedZoom       = "estimated_zoom"
+	CameraMetadataEstimatedConfidence = "estimated_ptz_confidence"
+	CameraMetadataEstimatedMoving     = "estimated_ptz_moving"
+	CameraMetadataEstimatedObservedAt = "estimated_ptz_observed_at_ms"
+)
+
+const (
+	// estimateIdleDecay は報告がない間に信頼度が 1/e になるまでの時間です。
+	// 静止していても、サーバーを通さずに動かされている可能性を表します。
+	estimateIdleDecay = 60 * time.Second
+	// estimateMotionDecay は推定で移動させた時間の合計に対して、信頼度が 1/e になるまでの時間です。
+	// 加減速や速度の誤差が積み重なることを表します。
+	estimateMotionDecay = 5 * time.Second
+)
+
+// PTZEstimate は推定したカメラの現在のPTZです。
+type PTZEstimate struct {
+	PTZ *protov1.PTZParameters
+	// Confidence は推定の信頼度 (0.0-1.0) です。報告した直後が 1.0 で、時間と推定した移動に応じて下がります。
+	Confidence float64
+	// Moving は推定上カメラが移動中かどうかです。
+	Moving bool
+	// ObservedAtMs は推定の基準にした最後の報告の時刻です。
+	ObservedAtMs int64
+	// EstimatedAtMs は推定した時刻です。
+	EstimatedAtMs int64
+}
+
+type ptzVector struct {
+	pan, tilt, zoom float64
+}
+
+// ptzMotion は実行中とみなしている移動です。
+// target がある場合は速度の大きさで目標へ向かい、ない場合は符号付きの速度で endMs（0 の場合は停止まで）まで動きます。
+type ptzMotion struct {
+	id       string
+	target   *ptzVector
+	velocity ptzVector
+	endMs    int64
+	optics   *CameraOptics
+}
+
+// ptzEstimatorState はカメラの推定の状態です。位置は base から baseAtMs 以降の motion で求めます。
+type ptzEstimatorState struct {
+	base         ptzVector
+	baseAtMs     int64
+	observedAtMs int64
+	// driftMs は最後の報告以降、推定で移動させた時間の合計です（現在の motion の分を除く）。
+	driftMs int64
+	motion  *ptzMotion
+}
+
+// PTZEstimator は状態の報告の合間のカメラのPTZを、実行中の命令から推定します（デッドレコニング）。
+// FDの報告（Observe）を基準に、連続移動は速度を積分し、絶対・相対移動は速度パラメータで目標への到達を補間します。
+type PTZEstimator struct {
+	mu      sync.Mutex
+	cameras map[string]*ptzEstimatorState
+}
+
+func NewPTZEstimator() *PTZEstimator {
+	return &PTZEstimator{
+		mu:      sync.Mutex{},
+		cameras: make(map[string]*ptzEstimatorState),
+	}
+}
+
+// Observe はFDが報告したPTZを推定の基準にします。実行中の移動は報告した位置から続けます。
+func (e *PTZEstimator) Observe(cameraID string, ptz *protov1.PTZParameters, atMs int64) {
+	if cameraID == "" || ptz == nil {
+		return
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	state := e.cameras[cameraID]
+	if state == nil {
+		state = &ptzEstimatorState{} //nolint:exhaustruct
+		e.cameras[cameraID] = state
+	}
+
+	// 古い報告で新しい推定を巻き戻さない
+	if atMs < state.observedAtMs {
+		return
+	}
+
+	state.base = ptzVector{pan: float64(ptz.GetPan()), tilt: float64(ptz.GetTilt()), zoom: float64(ptz.GetZoom())}
+	state.baseAtMs = atMs
+	state.observedAtMs = atMs
+	state.driftMs = 0
+}
+
+// StartMotion は制御コマンドを実行中の移動として推定に反映します。角度で表す移動系の制御コマンドのみを扱い、
+// 停止のコマンドは実行中の移動を止めます。同じIDの移動が既に実行中の場合は何もしません。
+// 報告を一度も受けていないカメラは基準の位置がないため推定しません。
+func (e *PTZEstimator) StartMotion(
+	cameraID string,
+	motionID string,
+	command *protov1.ControlCommand,
+	optics *CameraOptics,
+	atMs int64,
+) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	state := e.cameras[cameraID]
+	if state == nil || (state.motion != nil && state.motion.id == motionID) {
+		return
+	}
+
+	e.rebase(state, atMs)
+
+	params := command.GetPtzParameters()
+	motion := &ptzMotion{
+		id:       motionID,
+		target:   nil,
+		velocity: ptzVector{pan: 0, tilt: 0, zoom: 0},
+		endMs:    0,
+		optics:   optics,
+	}
+
+	switch command.GetType() { //nolint:exhaustive
+	case protov1.ControlCommandType_CONTROL_COMMAND_TYPE_PTZ_ABSOLUTE:
+		target := ptzVector{pan: float64(params.GetPan()), tilt: float64(params.GetTilt()), zoom: float64(params.GetZoom())}
+		// ズームの 0 は倍率の指定がないものとして扱う
+		if params.GetZoom() <= 0 {
+			target.zoom = state.base.zoom
+		}
+
+		motion.target = optics.clampVector(target)
+		motion.velocity = optics.axisVelocity(params, 1)
+	case protov1.ControlCommandType_CONTROL_COMMAND_TYPE_PTZ_RELATIVE:
+		motion.target = optics.clampVector(ptzVector{
+			pan:  state.base.pan + float64(params.GetPan()),
+			tilt: state.base.tilt + float64(params.GetTilt()),
+			zoom: state.base.zoom + float64(params.GetZoom()),
+		})
+		motion.velocity = optics.axisVelocity(params, 1)
+	case protov1.ControlCommandType_CONTROL_COMMAND_TYPE_PTZ_CONTINUOUS:
+		motion.velocity = optics.axisVelocity(params, 0)
+
+		if command.GetTimeoutMs() > 0 {
+			motion.endMs = atMs + int64(command.GetTimeoutMs())
+		}
+	case protov1.ControlCommandType_CONTROL_COMMAND_TYPE_PTZ_STOP:
+		state.motion = nil
+
+		return
+	default:
+		return
+	}
+
+	state.motion = motion
+}
+
+// StopMotion は移動の完了・中断を推定に反映します。目標のある移動が完了した場合は目標に到達したものとし、
+// 中断した場合はその時点の推定の位置で止まったものとします。resulting が指定された場合は報告として扱います。
+func (e *PTZEstimator) StopMotion(
+	cameraID string,
+	motionID string,
+	completed bool,
+	resulting *protov1.PTZParameters,
+	atMs int64,
+) {
+	e.mu.Lock()
+
+	if state := e.cameras[cameraID]; state != nil && state.motion != nil && state.motion.id == motionID {
+		e.rebase(state, atMs)
+
+		if completed && state.motion.target != nil {
+			state.base = *state.motion.target
+		}
+
+		state.motion = nil
+	}
+
+	e.mu.Unlock()
+
+	e.Observe(cameraID, resulting, atMs)
+}
+
+// Estimate は atMs 時点の推定のPTZを返します。報告を一度も受けていない場合は false を返します。
+func (e *PTZEstimator) Estimate(cameraID string, atMs int64) (*PTZEstimate, bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	state := e.cameras[cameraID]
+	if state == nil {
+		return nil, false
+	}
+
+	position, movingMs, moving := state.position(atMs)
+	idle := float64(max(atMs-state.observedAtMs, 0)) / float64(estimateIdleDecay.Milliseconds())
+	drift := float64(state.driftMs+movingMs) / float64(estimateMotionDecay.Milliseconds())
+
+	return &PTZEstimate{
+		PTZ: &protov1.PTZParameters{ //nolint:exhaustruct
+			Pan:  float32(position.pan),
+			Tilt: float32(position.tilt),
+			Zoom: float32(position.zoom),
+		},
+		Confidence:    math.Exp(-idle - drift),
+		Moving:        moving,
+		ObservedAtMs:  state.observedAtMs,
+		EstimatedAtMs: atMs,
+	}, true
+}
+
+// rebase は atMs 時点の推定の位置を新しい基準にします。e.mu を保持して呼び出します。
+func (e *PTZEstimator) rebase(state *ptzEstimatorState, atMs int64) {
+	if atMs < state.baseAtMs {
+		return
+	}
+
+	position, movingMs, _ := state.position(atMs)
+	state.base = position
+	state.baseAtMs = atMs
+	state.driftMs += movingMs
+}
+
+// position は atMs 時点の位置と、基準の時刻から移動していた時間、移動中かどうかを返します。
+func (s *ptzEstimatorState) position(atMs int64) (ptzVector, int64, bool) {
+	elapsedMs := max(atMs-s.baseAtMs, 0)
+	motion := s.motion
+
+	if motion == nil {
+		return s.base, 0, false
+	}
+
+	if motion.target == nil {
+		if motion.endMs > 0 {
+			elapsedMs = min(elapsedMs, max(motion.endMs-s.baseAtMs, 0))
+		}
+
+		seconds := float64(elapsedMs) / float64(time.Second.Milliseconds())
+		position := motion.optics.clampVector(ptzVector{
+			pan:  s.base.pan + motion.velocity.pan*seconds,
+			tilt: s.base.tilt + motion.velocity.tilt*seconds,
+			zoom: s.base.zoom + motion.velocity.zoom*seconds,
+		})
+		if motion.velocity.pan == 0 && motion.velocity.tilt == 0 && motion.velocity.zoom == 0 {
+			return *position, 0, false
+		}
+
+		return *position, elapsedMs, motion.endMs == 0 || atMs < motion.endMs
+	}
+
+	seconds := float64(elapsedMs) / float64(time.Second.Milliseconds())
+	pan, panMs := approach(s.base.pan, motion.target.pan, motion.velocity.pan, seconds)
+	tilt, tiltMs := approach(s.base.tilt, motion.target.tilt, motion.velocity.tilt, seconds)
+	zoom, zoomMs := approach(s.base.zoom, motion.target.zoom, motion.velocity.zoom, seconds)
+	arrivalMs := max(panMs, tiltMs, zoomMs)
+
+	return ptzVector{pan: pan, tilt: tilt, zoom: zoom}, min(elapsedMs, arrivalMs), elapsedMs < arrivalMs
+}
+
+// approach は from から to へ speed で seconds だけ進んだ位置と、到達までの時間を返します。
+func approach(from, to, speed, seconds float64) (float64, int64) {
+	distance := to - from
+	speed = math.Abs(speed)
+
+	if distance == 0 || speed == 0 {
+		return to, 0
+	}
+
+	arrivalMs := int64(math.Ceil(math.Abs(distance) / speed * float64(time.Second.Milliseconds())))
+	step := math.Min(math.Abs(distance), speed*seconds)
+
+	return from + math.Copysign(step, distance), arrivalMs
+}
+
+// axisVelocity は制御コマンドの速度 (0.0-1.0) を軸ごとの速度（度/秒、倍率/秒）に変換します。
+// 速度が 0 の軸は fallback を速度とみなします（絶対・相対移動では最高速度、連続移動では停止）。
+func (o *CameraOptics) axisVelocity(params *protov1.PTZParameters, fallback float64) ptzVector {
+	speed := func(value float32) float64 {
+		if value == 0 {
+			return fallback
+		}
+
+		return clamp(float64(value), -1, 1)
+	}
+
+	return ptzVector{
+		pan:  speed(params.GetPanSpeed()) * o.PanSpeedDps,
+		tilt: speed(params.GetTiltSpeed()) * o.TiltSpeedDps,
+		zoom: speed(params.GetZoomSpeed()) * o.ZoomSpeedPerSec,
+	}
+}
+
+// clampVector は位置を可動範囲に収めます。
+func (o *CameraOptics) clampVector(position ptzVector) *ptzVector {
+	return &ptzVector{
+		pan:  clamp(position.pan, o.PanMin, o.PanMax),
+		tilt: clamp(position.tilt, o.TiltMin, o.TiltMax),
+		zoom: clamp(position.zoom, o.ZoomMin, o.ZoomMax),
+	}
+}
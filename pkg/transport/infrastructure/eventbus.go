@@ -0,0 +1,253 @@
+package infrastructure
+
+import (
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+
+	protov1 "github.com/anyfld/vistra-operation-control-room/gen/proto/v1"
+)
+
+const eventChannelBufferSize = 256
+
+// EventTopic はイベントの分類です。
+type EventTopic string
+
+const (
+	EventTopicCamera EventTopic = "camera"
+	EventTopicMF     EventTopic = "mf"
+	EventTopicTask   EventTopic = "task"
+	EventTopicOutput EventTopic = "output"
+	EventTopicConfig EventTopic = "config"
+)
+
+// イベント種別。"<リソース>.<変化>" の形式です。
+const (
+	EventCameraRegistered   = "camera.registered"
+	EventCameraUnregistered = "camera.unregistered"
+	EventCameraUpdated      = "camera.updated"
+	EventCameraModeChanged  = "camera.mode_changed"
+	EventCameraStateChanged = "camera.state_changed"
+
+	EventMFRegistered   = "mf.registered"
+	EventMFUnregistered = "mf.unregistered"
+
+	EventTaskEnqueued              = "task.enqueued"
+	EventTaskStarted               = "task.started"
+	EventTaskCompleted             = "task.completed"
+	EventTaskInterrupted           = "task.interrupted"
+	EventControlCommandDispatched  = "control_command.dispatched"
+	EventControlCommandCompleted   = "control_command.completed"
+	EventControlCommandFailed      = "control_command.failed"
+	EventControlCommandTimedOut    = "control_command.timed_out"
+	EventCinematographyInstruction = "cinematography.received"
+
+	EventOutputConfigured       = "output.configured"
+	EventOutputStreamingStarted = "output.streaming_started"
+	EventOutputStreamingStopped = "output.streaming_stopped"
+	EventOutputSourceSwitched   = "output.source_switched"
+
+	EventConfigPushed = "config.pushed"
+)
+
+// AllEventTopics は購読可能な全トピックです。
+var AllEventTopics = []EventTopic{ //nolint:gochecknoglobals
+	EventTopicCamera,
+	EventTopicMF,
+	EventTopicTask,
+	EventTopicOutput,
+	EventTopicConfig,
+}
+
+// Event はリポジトリの状態変化を表すイベントです。
+// Payload は変化後のリソースのコピーで、購読者間で共有されるため変更してはいけません。
+type Event struct {
+	Sequence    uint64
+	Topic       EventTopic
+	Type        string
+	ResourceID  string
+	CameraID    string
+	Payload     proto.Message
+	TimestampMs int64
+	// ControlCommand は制御コマンドとキューのタスクが対応する場合に、配信した時点の制御コマンドです。
+	// task.* のイベントでは制御コマンドから作成したタスク、control_command.* のイベントではタスクを作成した
+	// 制御コマンドに設定されます。イベントを受け取った時点ではタスクが既に終了していることがあるため、
+	// 対応の有無はリポジトリに問い合わせずにこのフィールドで判定します。
+	ControlCommand *protov1.ControlCommand
+}
+
+// IsControlCommandTask は制御コマンドとキューのタスクが対応するイベントかどうかを返します。
+func (e *Event) IsControlCommandTask() bool {
+	return e.ControlCommand != nil
+}
+
+// EventSubscription はイベントの購読です。
+type EventSubscription struct {
+	ch     chan *Event
+	topics []EventTopic
+	// overflowed はバッファ溢れで購読を終了したかどうかです。
+	overflowed atomic.Bool
+}
+
+// Events はイベントを受信するチャネルを返します。購読解除またはバッファ溢れで閉じられます。
+func (s *EventSubscription) Events() <-chan *Event {
+	return s.ch
+}
+
+// Overflowed は受信が追いつかずに購読が終了したかどうかを返します。
+// true の場合、チャネルが閉じる前に受信したイベントより後のイベントは届いていません。
+func (s *EventSubscription) Overflowed() bool {
+	return s.overflowed.Load()
+}
+
+func (s *EventSubscription) matches(topic EventTopic) bool {
+	return len(s.topics) == 0 || slices.Contains(s.topics, topic)
+}
+
+// EventBus はコントロールルーム内のイベントを配信するバスです。
+// 配信はノンブロッキングで、Subscribe の購読者のうち受信が追いつかない購読者は、イベントを黙って
+// 取りこぼさないよう購読を終了させます（EventSubscription.Overflowed）。
+// Listen の購読者にはイベントを破棄せずに届けます。
+type EventBus struct {
+	mu          sync.RWMutex
+	sequence    uint64
+	subscribers map[*EventSubscription]struct{}
+	listeners   map[*EventListener]struct{}
+}
+
+// NewEventBus は新しいEventBusを作成します。
+func NewEventBus() *EventBus {
+	return &EventBus{
+		mu:          sync.RWMutex{},
+		sequence:    0,
+		subscribers: make(map[*EventSubscription]struct{}),
+		listeners:   make(map[*EventListener]struct{}),
+	}
+}
+
+// Publish はイベントを配信します。payload は呼び出し時点の状態がコピーされます。
+// バスが nil の場合は何もしません。
+func (b *EventBus) Publish(
+	topic EventTopic,
+	eventType string,
+	resourceID string,
+	cameraID string,
+	payload proto.Message,
+) {
+	b.PublishControlCommandEvent(topic, eventType, resourceID, cameraID, payload, nil)
+}
+
+// PublishControlCommandEvent は Publish と同様にイベントを配信します。command が nil でない場合は、
+// 制御コマンドとキューのタスクが対応するイベントとして Event.ControlCommand に command のコピーを設定します。
+func (b *EventBus) PublishControlCommandEvent(
+	topic EventTopic,
+	eventType string,
+	resourceID string,
+	cameraID string,
+	payload proto.Message,
+	command *protov1.ControlCommand,
+) {
+	if b == nil {
+		return
+	}
+
+	if payload != nil {
+		payload = proto.Clone(payload)
+	}
+
+	if command != nil {
+		command, _ = proto.Clone(command).(*protov1.ControlCommand)
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.sequence++
+
+	event := &Event{
+		Sequence:       b.sequence,
+		Topic:          topic,
+		Type:           eventType,
+		ResourceID:     resourceID,
+		CameraID:       cameraID,
+		Payload:        payload,
+		TimestampMs:    time.Now().UnixMilli(),
+		ControlCommand: command,
+	}
+
+	for listener := range b.listeners {
+		if listener.matches(topic) {
+			listener.push(event)
+		}
+	}
+
+	for subscription := range b.subscribers {
+		if !subscription.matches(topic) {
+			continue
+		}
+
+		select {
+		case subscription.ch <- event:
+		default:
+			subscription.overflowed.Store(true)
+			delete(b.subscribers, subscription)
+			close(subscription.ch)
+		}
+	}
+}
+
+// Subscribe は指定したトピックのイベントを購読します。トピックを省略すると全トピックを購読します。
+func (b *EventBus) Subscribe(topics ...EventTopic) *EventSubscription {
+	subscription := &EventSubscription{
+		ch:         make(chan *Event, eventChannelBufferSize),
+		topics:     slices.Clone(topics),
+		overflowed: atomic.Bool{},
+	}
+
+	b.mu.Lock()
+	b.subscribers[subscription] = struct{}{}
+	b.mu.Unlock()
+
+	return subscription
+}
+
+// Listen は指定したトピックのイベントを破棄せずに購読します。トピックを省略すると全トピックを購読します。
+// 受信していないイベントは Unlisten まで保持されるため、購読者は ctx の終了まで受信を続ける必要があります。
+func (b *EventBus) Listen(topics ...EventTopic) *EventListener {
+	listener := newEventListener(topics)
+
+	b.mu.Lock()
+	b.listeners[listener] = struct{}{}
+	b.mu.Unlock()
+
+	return listener
+}
+
+// Unlisten は Listen の購読を解除し、チャネルを閉じます。
+func (b *EventBus) Unlisten(listener *EventListener) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if _, ok := b.listeners[listener]; !ok {
+		return
+	}
+
+	delete(b.listeners, listener)
+	listener.close()
+}
+
+// Unsubscribe は購読を解除し、チャネルを閉じます。バッファ溢れで終了した購読では何もしません。
+func (b *EventBus) Unsubscribe(subscription *EventSubscription) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if _, ok := b.subscribers[subscription]; !ok {
+		return
+	}
+
+	delete(b.subscribers, subscription)
+	close(subscription.ch)
+}
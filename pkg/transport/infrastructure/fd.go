@@ -1,30 +1,26 @@
 package infrastructure
 
 import (
+	"errors"
 	"fmt"
+	"slices"
 	"sync"
 	"time"
 
 	protov1 "github.com/anyfld/vistra-operation-control-room/gen/proto/v1"
 )
 
-const (
-	defaultConfidence     = 0.85
-	defaultBoundingBoxX   = 0.2
-	defaultBoundingBoxY   = 0.2
-	defaultBoundingBoxW   = 0.3
-	defaultBoundingBoxH   = 0.3
-	defaultProcessingTime = 50
-	panAdjustment         = 10.0
-	tiltAdjustment        = 5.0
-	zoomAdjustment        = 0.5
-	ptzSpeed              = 0.5
-	estimatedMoveTimeMs   = 1000
-	executionTimeMs       = 100
-	ptzChannelBufferSize  = 100
-)
+const patternMatchChannelBufferSize = 16
+
+// CameraMetadataSnapshotURL はパターンマッチングでフレームを取得するカメラのスナップショットURLです。
+const CameraMetadataSnapshotURL = "snapshot_url"
+
+var ErrPatternMatchingSessionNotFound = errors.New("pattern matching session not found")
 
+// PTZCommandEvent はカメラの購読者に配信する制御コマンドまたはその結果です。
+// Sequence はカメラごとに 1 から順に振られる配信の通し番号です。
 type PTZCommandEvent struct {
+	Sequence    uint64
 	Command     *protov1.ControlCommand
 	Result      *protov1.ControlCommandResult
 	TimestampMs int64
@@ -33,89 +29,111 @@ type PTZCommandEvent struct {
 type FDRepo struct {
 	mu                         sync.RWMutex
 	patternMatchingSessions    map[string]*PatternMatchingSession
-	controlCommands            map[string]*protov1.ControlCommand
+	trackingSessions           map[string]*TrackingSession
+	controlCommands            map[string]*controlCommandEntry
+	controlCommandHistory      map[string][]string
 	cameraStates               map[string]*protov1.CameraState
 	cinematographyInstructions map[string]*protov1.CinematographyInstruction
+	armPoses                   map[string][]float32
+	focusModes                 map[string]FocusMode
+	ptzMu                      sync.Mutex
+	ptzSubscribers             map[string]map[*PTZSubscription]struct{}
+	ptzSequences               map[string]uint64
+	ptzReplay                  map[string][]*PTZCommandEvent
 	lastPTZEvents              map[string]*PTZCommandEvent
-	ptzSubscribers             map[string][]chan *PTZCommandEvent
-	ptzSubscribersMu           sync.RWMutex
+	events                     *EventBus
 }
 
+// PatternMatchingSession はフレームを取り込んで被写体を検出し続けるセッションです。
+// FDが送信したフレームがない場合は SourceURL から取得します。
 type PatternMatchingSession struct {
 	SessionID      string
 	CameraID       string
 	TargetSubjects []*protov1.Subject
 	IntervalMs     uint32
+	SourceURL      string
 	CreatedAt      time.Time
+
+	// frames は最新の1フレームだけを保持します。
+	frames      chan *protov1.ImageData
+	done        chan struct{}
+	subscribers map[chan *protov1.StreamPatternMatchResultsResponse]struct{}
+}
+
+// Frames はFDから送信された最新のフレームを受信するチャネルを返します。
+func (s *PatternMatchingSession) Frames() <-chan *protov1.ImageData {
+	return s.frames
+}
+
+// Done はセッションの終了時に閉じられるチャネルを返します。
+func (s *PatternMatchingSession) Done() <-chan struct{} {
+	return s.done
 }
 
-func NewFDRepo() *FDRepo {
+func NewFDRepo(events *EventBus) *FDRepo {
 	return &FDRepo{
 		mu:                         sync.RWMutex{},
 		patternMatchingSessions:    make(map[string]*PatternMatchingSession),
-		controlCommands:            make(map[string]*protov1.ControlCommand),
+		trackingSessions:           make(map[string]*TrackingSession),
+		controlCommands:            make(map[string]*controlCommandEntry),
+		controlCommandHistory:      make(map[string][]string),
 		cameraStates:               make(map[string]*protov1.CameraState),
 		cinematographyInstructions: make(map[string]*protov1.CinematographyInstruction),
+		armPoses:                   make(map[string][]float32),
+		focusModes:                 make(map[string]FocusMode),
+		ptzMu:                      sync.Mutex{},
+		ptzSubscribers:             make(map[string]map[*PTZSubscription]struct{}),
+		ptzSequences:               make(map[string]uint64),
+		ptzReplay:                  make(map[string][]*PTZCommandEvent),
 		lastPTZEvents:              make(map[string]*PTZCommandEvent),
-		ptzSubscribers:             make(map[string][]chan *PTZCommandEvent),
-		ptzSubscribersMu:           sync.RWMutex{},
+		events:                     events,
 	}
 }
 
-func (r *FDRepo) ProcessImage(
-	image *protov1.ImageData,
-	targetSubjects []*protov1.Subject,
-) ([]*protov1.DetectedSubject, uint32) {
-	r.mu.Lock()
-	defer r.mu.Unlock()
-
-	detected := make([]*protov1.DetectedSubject, 0, len(targetSubjects))
-
-	for _, target := range targetSubjects {
-		detected = append(detected, &protov1.DetectedSubject{
-			Subject:    target,
-			Confidence: defaultConfidence,
-			DetectedBox: &protov1.BoundingBox{
-				X:      defaultBoundingBoxX,
-				Y:      defaultBoundingBoxY,
-				Width:  defaultBoundingBoxW,
-				Height: defaultBoundingBoxH,
-			},
-		})
-	}
-
-	return detected, defaultProcessingTime
-}
-
 func (r *FDRepo) StartPatternMatching(
 	cameraID string,
 	targetSubjects []*protov1.Subject,
 	intervalMs uint32,
-) string {
+	sourceURL string,
+) *PatternMatchingSession {
 	r.mu.Lock()
 	defer r.mu.Unlock()
 
 	sessionID := fmt.Sprintf("session-%d", time.Now().UnixNano())
-	r.patternMatchingSessions[sessionID] = &PatternMatchingSession{
+	session := &PatternMatchingSession{
 		SessionID:      sessionID,
 		CameraID:       cameraID,
 		TargetSubjects: targetSubjects,
 		IntervalMs:     intervalMs,
+		SourceURL:      sourceURL,
 		CreatedAt:      time.Now(),
+		frames:         make(chan *protov1.ImageData, 1),
+		done:           make(chan struct{}),
+		subscribers:    make(map[chan *protov1.StreamPatternMatchResultsResponse]struct{}),
 	}
+	r.patternMatchingSessions[sessionID] = session
 
-	return sessionID
+	return session
 }
 
+// StopPatternMatching はセッションを終了し、結果を購読しているストリームを閉じます。
 func (r *FDRepo) StopPatternMatching(sessionID string) bool {
 	r.mu.Lock()
 	defer r.mu.Unlock()
 
-	if _, ok := r.patternMatchingSessions[sessionID]; !ok {
+	session, ok := r.patternMatchingSessions[sessionID]
+	if !ok {
 		return false
 	}
 
 	delete(r.patternMatchingSessions, sessionID)
+	close(session.done)
+
+	for ch := range session.subscribers {
+		close(ch)
+	}
+
+	session.subscribers = nil
 
 	return true
 }
@@ -127,132 +145,121 @@ func (r *FDRepo) GetPatternMatchingSession(sessionID string) *PatternMatchingSes
 	return r.patternMatchingSessions[sessionID]
 }
 
-func (r *FDRepo) CalculateFraming(
-	cameraID string,
-	currentPtz *protov1.PTZParameters,
-	targetShotType protov1.ShotType,
-	targetSubjects []*protov1.DetectedSubject,
-	targetAngle protov1.CameraAngle,
-) (*protov1.PTZParameters, uint32, bool, string) {
+// ListPatternMatchingSessions はカメラのセッションを返します。
+func (r *FDRepo) ListPatternMatchingSessions(cameraID string) []*PatternMatchingSession {
 	r.mu.RLock()
 	defer r.mu.RUnlock()
 
-	if currentPtz == nil {
-		currentPtz = &protov1.PTZParameters{
-			Pan:       0.0,
-			Tilt:      0.0,
-			Zoom:      1.0,
-			PanSpeed:  0.0,
-			TiltSpeed: 0.0,
-			ZoomSpeed: 0.0,
-		}
-	}
+	sessions := make([]*PatternMatchingSession, 0)
 
-	calculatedPtz := &protov1.PTZParameters{
-		Pan:       currentPtz.GetPan() + panAdjustment,
-		Tilt:      currentPtz.GetTilt() + tiltAdjustment,
-		Zoom:      currentPtz.GetZoom() + zoomAdjustment,
-		PanSpeed:  ptzSpeed,
-		TiltSpeed: ptzSpeed,
-		ZoomSpeed: ptzSpeed,
+	for _, session := range r.patternMatchingSessions {
+		if session.CameraID == cameraID {
+			sessions = append(sessions, session)
+		}
 	}
 
-	return calculatedPtz, estimatedMoveTimeMs, true, ""
+	return sessions
 }
 
-func (r *FDRepo) SendControlCommand(command *protov1.ControlCommand) *protov1.ControlCommandResult {
+// PushPatternMatchingFrame はセッションにフレームを渡します。
+// 処理されていない古いフレームは新しいフレームで置き換えます。
+func (r *FDRepo) PushPatternMatchingFrame(sessionID string, frame *protov1.ImageData) error {
 	r.mu.Lock()
+	defer r.mu.Unlock()
 
-	commandID := command.GetCommandId()
-	if commandID == "" {
-		commandID = fmt.Sprintf("cmd-%d", time.Now().UnixNano())
-		command.CommandId = commandID
+	session, ok := r.patternMatchingSessions[sessionID]
+	if !ok {
+		return ErrPatternMatchingSessionNotFound
 	}
 
-	r.controlCommands[commandID] = command
-
-	resultingPtz := command.GetPtzParameters()
-	if resultingPtz == nil {
-		resultingPtz = &protov1.PTZParameters{
-			Pan:       0.0,
-			Tilt:      0.0,
-			Zoom:      1.0,
-			PanSpeed:  0.0,
-			TiltSpeed: 0.0,
-			ZoomSpeed: 0.0,
-		}
+	select {
+	case <-session.frames:
+	default:
 	}
 
-	result := &protov1.ControlCommandResult{
-		CommandId:       commandID,
-		Success:         true,
-		ErrorMessage:    "",
-		ResultingPtz:    resultingPtz,
-		ExecutionTimeMs: executionTimeMs,
-	}
+	session.frames <- frame
 
-	cameraID := command.GetCameraId()
-	if cameraID != "" {
-		r.lastPTZEvents[cameraID] = &PTZCommandEvent{
-			Command:     command,
-			Result:      result,
-			TimestampMs: time.Now().UnixMilli(),
-		}
-	}
+	return nil
+}
 
-	r.mu.Unlock()
+// SubscribePatternMatchResults はセッションの検出結果を購読します。
+// セッションが終了するとチャネルは閉じられます。
+func (r *FDRepo) SubscribePatternMatchResults(
+	sessionID string,
+) (<-chan *protov1.StreamPatternMatchResultsResponse, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
 
-	if cameraID != "" {
-		r.publishPTZCommand(cameraID, r.lastPTZEvents[cameraID])
+	session, ok := r.patternMatchingSessions[sessionID]
+	if !ok {
+		return nil, ErrPatternMatchingSessionNotFound
 	}
 
-	return result
+	ch := make(chan *protov1.StreamPatternMatchResultsResponse, patternMatchChannelBufferSize)
+	session.subscribers[ch] = struct{}{}
+
+	return ch, nil
 }
 
-func (r *FDRepo) SubscribePTZCommands(cameraID string) <-chan *PTZCommandEvent {
-	commandCh := make(chan *PTZCommandEvent, ptzChannelBufferSize)
+func (r *FDRepo) UnsubscribePatternMatchResults(
+	sessionID string,
+	ch <-chan *protov1.StreamPatternMatchResultsResponse,
+) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
 
-	r.ptzSubscribersMu.Lock()
-	r.ptzSubscribers[cameraID] = append(r.ptzSubscribers[cameraID], commandCh)
-	r.ptzSubscribersMu.Unlock()
+	session, ok := r.patternMatchingSessions[sessionID]
+	if !ok {
+		return
+	}
 
-	r.mu.RLock()
-	if event, ok := r.lastPTZEvents[cameraID]; ok && event != nil {
-		select {
-		case commandCh <- event:
-		default:
+	for subscriber := range session.subscribers {
+		if subscriber == ch {
+			delete(session.subscribers, subscriber)
+			close(subscriber)
+
+			return
 		}
 	}
-	r.mu.RUnlock()
-
-	return commandCh
 }
 
-func (r *FDRepo) UnsubscribePTZCommands(cameraID string, commandCh <-chan *PTZCommandEvent) {
-	r.ptzSubscribersMu.Lock()
-	defer r.ptzSubscribersMu.Unlock()
-
-	subscribers := r.ptzSubscribers[cameraID]
-	for i, subscriber := range subscribers {
-		if subscriber == commandCh {
-			r.ptzSubscribers[cameraID] = append(subscribers[:i], subscribers[i+1:]...)
+// PublishPatternMatchResult は検出結果をセッションの全購読者に配信します。
+// 受信が追いつかない購読者への結果は破棄されます。
+func (r *FDRepo) PublishPatternMatchResult(result *protov1.StreamPatternMatchResultsResponse) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
 
-			close(subscriber)
+	session, ok := r.patternMatchingSessions[result.GetSessionId()]
+	if !ok {
+		return
+	}
 
-			break
+	for ch := range session.subscribers {
+		select {
+		case ch <- result:
+		default:
 		}
 	}
+}
 
-	if len(r.ptzSubscribers[cameraID]) == 0 {
-		delete(r.ptzSubscribers, cameraID)
-	}
+// GetArmPose はカメラに最後に配信したARM命令の関節角度を返します。
+// CameraState はアームの姿勢を含まないため、これを現在の姿勢として扱います。
+func (r *FDRepo) GetArmPose(cameraID string) []float32 {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return slices.Clone(r.armPoses[cameraID])
 }
 
-func (r *FDRepo) GetControlCommand(commandID string) *protov1.ControlCommand {
+// GetFocusMode はカメラに最後に配信したFOCUS命令によるフォーカスの制御方法を返します。
+// FOCUS命令を配信していない場合は false を返します。
+func (r *FDRepo) GetFocusMode(cameraID string) (FocusMode, bool) {
 	r.mu.RLock()
 	defer r.mu.RUnlock()
 
-	return r.controlCommands[commandID]
+	mode, ok := r.focusModes[cameraID]
+
+	return mode, ok
 }
 
 func (r *FDRepo) ReportCameraState(state *protov1.CameraState) bool {
@@ -325,16 +332,3 @@ func (r *FDRepo) GetCinematographyInstruction(sourceFilter []string) *protov1.Ci
 
 	return nil
 }
-
-func (r *FDRepo) publishPTZCommand(cameraID string, event *PTZCommandEvent) {
-	r.ptzSubscribersMu.RLock()
-	defer r.ptzSubscribersMu.RUnlock()
-
-	subscribers := r.ptzSubscribers[cameraID]
-	for _, commandCh := range subscribers {
-		select {
-		case commandCh <- event:
-		default:
-		}
-	}
-}
@@ -0,0 +1,421 @@
+package infrastructure
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"connectrpc.com/connect"
+	protov1 "github.com/anyfld/vistra-operation-control-room/gen/proto/v1"
+	"github.com/anyfld/vistra-operation-control-room/gen/proto/v1/protov1connect"
+)
+
+const (
+	// FederationHeader は親CRから転送されたリクエストであることを示すヘッダーです。
+	// 転送するリクエストには共有シークレットの有無にかかわらず必ず設定し、このヘッダーを持つリクエストは
+	// 再転送せず、ローカルの状態のみで応答します。
+	FederationHeader = "Vistra-Federation-Origin"
+	// FederationTokenHeader は親CRが転送時に設定する共有シークレットです。
+	// 共有シークレットを設定したCRは、FederationHeader を持ち、共有シークレットが一致しないリクエストを拒否します。
+	FederationTokenHeader = "Vistra-Federation-Token"
+	// FederationFailedPeersHeader はカメラ一覧を取得できなかった子CRのIDを返すレスポンスヘッダーです。
+	// 子CRごとに1つの値を設定します。
+	FederationFailedPeersHeader = "Vistra-Federation-Failed-Peers"
+	// FederationPeerMetadataKey は子CRのカメラに付与するメタデータのキーです。
+	FederationPeerMetadataKey = "federation_peer_id"
+
+	// federationStatusTimeout は子CRのシステム状態の取得を待つ時間です。
+	// StreamSystemStatus の配信間隔より短くし、応答しない子CRがあっても配信が遅れないようにします。
+	federationStatusTimeout = 400 * time.Millisecond
+	// missingCameraTTL は子CRのカメラ一覧に存在しなかったカメラを、再取得せずに存在しないとみなす時間です。
+	missingCameraTTL = 5 * time.Second
+	// cameraOwnerTTL は子CRのカメラ一覧で確認したカメラの所有者を、再取得せずに使う時間です。
+	// 経過後は一覧を再取得し、カメラの登録の解除や別の子CRへの移動を反映します。
+	cameraOwnerTTL = 30 * time.Second
+)
+
+var (
+	// ErrFederationLoop は転送されたリクエストの処理中に、さらに子CRへ転送しようとしたことを表します。
+	ErrFederationLoop = errors.New("request forwarded from a parent cr must not be forwarded again")
+	// ErrFederationUnauthenticated は転送されたリクエストの共有シークレットが一致しないことを表します。
+	ErrFederationUnauthenticated = errors.New("federation token does not match")
+)
+
+type federatedRequestKey struct{}
+
+// cameraOwner は子CRのカメラ一覧で確認したカメラの所有者と、その確認時刻です。
+type cameraOwner struct {
+	peerID    string
+	checkedAt time.Time
+}
+
+// FederationPeer はピアリング先の子CRです。
+type FederationPeer struct {
+	ID      string
+	BaseURL string
+	CR      protov1connect.CRServiceClient
+	PTZ     protov1connect.PTZServiceClient
+}
+
+// RemoteCamera は子CRが所有するカメラです。
+type RemoteCamera struct {
+	PeerID string
+	Camera *protov1.Camera
+}
+
+// PeerSystemStatus は子CRから取得したシステム状態です。取得に失敗した場合は Err を設定します。
+type PeerSystemStatus struct {
+	PeerID string
+	Status *protov1.SystemStatus
+	Err    error
+}
+
+// FederationRepo は子CRへの接続とカメラの所有関係を管理します。
+type FederationRepo struct {
+	mu           sync.RWMutex
+	originID     string
+	sharedSecret string
+	httpClient   connect.HTTPClient
+	peers        map[string]*FederationPeer
+	// cameraOwners は子CRのカメラ一覧で確認したカメラの所有者です。cameraOwnerTTL を過ぎたものは使いません。
+	cameraOwners map[string]cameraOwner
+	// missingCameras は全ての子CRのカメラ一覧に存在しなかったカメラと、その確認時刻です。
+	missingCameras map[string]time.Time
+}
+
+// NewFederationRepo は新しいFederationRepoを作成します。
+// originID は転送時に FederationHeader に設定される自身の識別子です。
+// sharedSecret は親CRと子CRで共有するシークレットで、空の場合は転送されたリクエストを認証しません。
+func NewFederationRepo(originID string, sharedSecret string, httpClient connect.HTTPClient) *FederationRepo {
+	return &FederationRepo{
+		mu:             sync.RWMutex{},
+		originID:       originID,
+		sharedSecret:   sharedSecret,
+		httpClient:     httpClient,
+		peers:          make(map[string]*FederationPeer),
+		cameraOwners:   make(map[string]cameraOwner),
+		missingCameras: make(map[string]time.Time),
+	}
+}
+
+// AddPeer は子CRを登録します。子CRへのリクエストには NewFederationInterceptor が FederationHeader を設定します。
+func (r *FederationRepo) AddPeer(peerID string, baseURL string) *FederationPeer {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	interceptors := connect.WithInterceptors(NewFederationInterceptor(r))
+
+	peer := &FederationPeer{
+		ID:      peerID,
+		BaseURL: baseURL,
+		CR:      protov1connect.NewCRServiceClient(r.httpClient, baseURL, interceptors),
+		PTZ:     protov1connect.NewPTZServiceClient(r.httpClient, baseURL, interceptors),
+	}
+	r.peers[peerID] = peer
+
+	return peer
+}
+
+// ListPeers は登録済みの子CRをID順に返します。
+func (r *FederationRepo) ListPeers() []*FederationPeer {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	peers := make([]*FederationPeer, 0, len(r.peers))
+	for _, peer := range r.peers {
+		peers = append(peers, peer)
+	}
+
+	sort.Slice(peers, func(i, j int) bool {
+		return peers[i].ID < peers[j].ID
+	})
+
+	return peers
+}
+
+// HasPeers は子CRが1つ以上登録されているかを返します。
+func (r *FederationRepo) HasPeers() bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return len(r.peers) > 0
+}
+
+// ListRemoteCameras は全ての子CRからカメラ一覧を取得し、所有関係を更新します。
+// 取得できた子CRの一覧から消えたカメラの所有関係は破棄します。取得に失敗した子CRのIDは failedPeerIDs として返します。
+func (r *FederationRepo) ListRemoteCameras(ctx context.Context) ([]*RemoteCamera, []string) {
+	peers := r.ListPeers()
+
+	results := make([][]*protov1.Camera, len(peers))
+	errs := make([]error, len(peers))
+
+	var wg sync.WaitGroup
+
+	for i, peer := range peers {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			res, err := peer.CR.ListAllCameras(
+				ctx,
+				connect.NewRequest(&protov1.ListAllCamerasRequest{}), //nolint:exhaustruct
+			)
+			if err != nil {
+				errs[i] = err
+
+				return
+			}
+
+			results[i] = res.Msg.GetCameras()
+		}()
+	}
+
+	wg.Wait()
+
+	remote := make([]*RemoteCamera, 0)
+	failedPeerIDs := make([]string, 0)
+	listedPeerIDs := make(map[string]bool)
+	owners := make(map[string]string)
+
+	for i, peer := range peers {
+		if errs[i] != nil {
+			failedPeerIDs = append(failedPeerIDs, peer.ID)
+
+			continue
+		}
+
+		listedPeerIDs[peer.ID] = true
+
+		for _, camera := range results[i] {
+			owners[camera.GetId()] = peer.ID
+			remote = append(remote, &RemoteCamera{PeerID: peer.ID, Camera: camera})
+		}
+	}
+
+	checkedAt := time.Now()
+
+	r.mu.Lock()
+	for cameraID, owner := range r.cameraOwners {
+		if _, ok := owners[cameraID]; !ok && listedPeerIDs[owner.peerID] {
+			delete(r.cameraOwners, cameraID)
+		}
+	}
+
+	for cameraID, peerID := range owners {
+		r.cameraOwners[cameraID] = cameraOwner{peerID: peerID, checkedAt: checkedAt}
+		delete(r.missingCameras, cameraID)
+	}
+	r.mu.Unlock()
+
+	return remote, failedPeerIDs
+}
+
+// FindCameraOwner はカメラを所有する子CRを返します。
+// キャッシュに存在しないか、cameraOwnerTTL を過ぎている場合は子CRのカメラ一覧を再取得します。全ての子CRの一覧に存在しなかったカメラは
+// missingCameraTTL の間は再取得せずに存在しないとみなし、存在しないカメラへの要求のたびに全ての子CRへ
+// 問い合わせないようにします。
+func (r *FederationRepo) FindCameraOwner(ctx context.Context, cameraID string) (*FederationPeer, bool) {
+	if peer, ok := r.cachedOwner(cameraID); ok {
+		return peer, true
+	}
+
+	if !r.HasPeers() || r.knownMissing(cameraID) {
+		return nil, false
+	}
+
+	_, failedPeerIDs := r.ListRemoteCameras(ctx)
+
+	peer, ok := r.cachedOwner(cameraID)
+	if !ok && len(failedPeerIDs) == 0 {
+		// 取得できなかった子CRが所有している可能性がある場合は記録しない
+		r.mu.Lock()
+		r.missingCameras[cameraID] = time.Now()
+		r.mu.Unlock()
+	}
+
+	return peer, ok
+}
+
+// ListPeerSystemStatuses は全ての子CRのシステム状態を並行して取得し、ID順に返します。
+// 子CRごとに federationStatusTimeout まで待ち、応答しない子CRは Err を設定して返します。
+func (r *FederationRepo) ListPeerSystemStatuses(ctx context.Context) []*PeerSystemStatus {
+	peers := r.ListPeers()
+	statuses := make([]*PeerSystemStatus, len(peers))
+
+	var wg sync.WaitGroup
+
+	for i, peer := range peers {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			peerCtx, cancel := context.WithTimeout(ctx, federationStatusTimeout)
+			defer cancel()
+
+			status, err := r.GetSystemStatus(peerCtx, peer)
+			statuses[i] = &PeerSystemStatus{PeerID: peer.ID, Status: status, Err: err}
+		}()
+	}
+
+	wg.Wait()
+
+	return statuses
+}
+
+// GetCamera は子CRからカメラの状態を取得します。
+func (r *FederationRepo) GetCamera(
+	ctx context.Context,
+	peer *FederationPeer,
+	cameraID string,
+) (*protov1.Camera, error) {
+	res, err := peer.CR.GetCameraStatus(
+		ctx,
+		connect.NewRequest(&protov1.GetCameraStatusRequest{CameraId: cameraID}),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return res.Msg.GetCamera(), nil
+}
+
+// GetSystemStatus は子CRのシステム状態を取得します。
+func (r *FederationRepo) GetSystemStatus(
+	ctx context.Context,
+	peer *FederationPeer,
+) (*protov1.SystemStatus, error) {
+	res, err := peer.CR.GetSystemStatus(ctx, connect.NewRequest(&protov1.GetSystemStatusRequest{}))
+	if err != nil {
+		return nil, err
+	}
+
+	return res.Msg.GetStatus(), nil
+}
+
+// SendCinematographyInstruction は子CRへ演出指示を転送します。
+func (r *FederationRepo) SendCinematographyInstruction(
+	ctx context.Context,
+	peer *FederationPeer,
+	req *protov1.SendCinematographyInstructionRequest,
+) (*protov1.SendCinematographyInstructionResponse, error) {
+	res, err := peer.CR.SendCinematographyInstruction(ctx, connect.NewRequest(req))
+	if err != nil {
+		return nil, err
+	}
+
+	return res.Msg, nil
+}
+
+// SendPTZCommand は子CRへPTZ枠命令を転送します。
+func (r *FederationRepo) SendPTZCommand(
+	ctx context.Context,
+	peer *FederationPeer,
+	req *protov1.SendPTZCommandRequest,
+) (*protov1.SendPTZCommandResponse, error) {
+	res, err := peer.PTZ.SendPTZCommand(ctx, connect.NewRequest(req))
+	if err != nil {
+		return nil, err
+	}
+
+	return res.Msg, nil
+}
+
+// SendCinematicCommand は子CRへシネマティック枠命令を転送します。
+func (r *FederationRepo) SendCinematicCommand(
+	ctx context.Context,
+	peer *FederationPeer,
+	req *protov1.SendCinematicCommandRequest,
+) (*protov1.SendCinematicCommandResponse, error) {
+	res, err := peer.PTZ.SendCinematicCommand(ctx, connect.NewRequest(req))
+	if err != nil {
+		return nil, err
+	}
+
+	return res.Msg, nil
+}
+
+func (r *FederationRepo) cachedOwner(cameraID string) (*FederationPeer, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	owner, ok := r.cameraOwners[cameraID]
+	if !ok || time.Since(owner.checkedAt) >= cameraOwnerTTL {
+		return nil, false
+	}
+
+	peer, ok := r.peers[owner.peerID]
+
+	return peer, ok
+}
+
+// knownMissing は missingCameraTTL 以内に子CRの一覧に存在しないことを確認したカメラかどうかを返します。
+func (r *FederationRepo) knownMissing(cameraID string) bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	checkedAt, ok := r.missingCameras[cameraID]
+
+	return ok && time.Since(checkedAt) < missingCameraTTL
+}
+
+// authenticates は FederationTokenHeader の値が共有シークレットと一致するかどうかを返します。
+// 共有シークレットが設定されていない場合は認証せず、常に true を返します。
+func (r *FederationRepo) authenticates(token string) bool {
+	return r.sharedSecret == "" || subtle.ConstantTimeCompare([]byte(token), []byte(r.sharedSecret)) == 1
+}
+
+// NewFederationInterceptor は子CRへの転送と、親CRから転送されたリクエストを扱うインターセプタを作成します。
+//
+//   - 子CRへ転送するリクエストには、共有シークレットの有無にかかわらず FederationHeader を設定し、共有シークレットが
+//     あれば FederationTokenHeader も設定します。転送されたリクエストの処理中に、さらに転送することは拒否します。
+//   - FederationHeader を持つリクエストは、転送されたリクエストとしてコンテキストに記録し、再転送しません。
+//     共有シークレットを設定している場合、FederationTokenHeader が一致しないリクエストは拒否します。
+func NewFederationInterceptor(repo *FederationRepo) connect.Interceptor {
+	return connect.UnaryInterceptorFunc(func(next connect.UnaryFunc) connect.UnaryFunc {
+		return func(ctx context.Context, req connect.AnyRequest) (connect.AnyResponse, error) {
+			if req.Spec().IsClient {
+				if IsFederatedRequest(ctx) {
+					return nil, connect.NewError(connect.CodeFailedPrecondition, ErrFederationLoop)
+				}
+
+				req.Header().Set(FederationHeader, repo.originID)
+
+				if repo.sharedSecret != "" {
+					req.Header().Set(FederationTokenHeader, repo.sharedSecret)
+				}
+
+				return next(ctx, req)
+			}
+
+			if req.Header().Get(FederationHeader) != "" {
+				if !repo.authenticates(req.Header().Get(FederationTokenHeader)) {
+					return nil, connect.NewError(connect.CodePermissionDenied, ErrFederationUnauthenticated)
+				}
+
+				ctx = context.WithValue(ctx, federatedRequestKey{}, true)
+			}
+
+			return next(ctx, req)
+		}
+	})
+}
+
+// IsFederatedRequest は親CRから転送されたリクエストかどうかを返します。
+func IsFederatedRequest(ctx context.Context) bool {
+	federated, ok := ctx.Value(federatedRequestKey{}).(bool)
+
+	return ok && federated
+}
+
+// NewFederationHTTPClient は子CRとの通信に使うHTTPクライアントを作成します。
+func NewFederationHTTPClient(timeoutMs int) *http.Client {
+	return &http.Client{ //nolint:exhaustruct
+		Timeout: time.Duration(timeoutMs) * time.Millisecond,
+	}
+}
@@ -0,0 +1,126 @@
+package infrastructure
+
+import (
+	"errors"
+	"fmt"
+	"math"
+)
+
+// フォーカス値は ControlCommand.FocusValue と同じ 0.0-1.0 です。
+// FOCUS の制御コマンドは、FocusValue が AutofocusValue の場合はオートフォーカスへの切り替えを、
+// 0.0-1.0 の場合は手動フォーカスへ切り替えてその値へ移動することを表します。
+const AutofocusValue float32 = -1
+
+const (
+	// focusStepIntervalMs はラックフォーカスのステップの間隔です。
+	focusStepIntervalMs = 100
+	// maxFocusSteps を超える場合はステップの間隔を広げます。
+	maxFocusSteps = 100
+	// maxFocusDurationMs はラックフォーカスの最長の時間です。
+	maxFocusDurationMs = 60000
+)
+
+var (
+	ErrAutofocusNotSupported = errors.New("camera does not support autofocus")
+	ErrInvalidFocusCommand   = errors.New("invalid focus command")
+)
+
+// FocusMode はカメラのフォーカスの制御方法です。
+type FocusMode string
+
+const (
+	// FocusModeAuto はカメラのオートフォーカスに任せます。
+	FocusModeAuto FocusMode = "auto"
+	// FocusModeManual はFOCUSの制御コマンドで指定したフォーカス値を保ちます。
+	FocusModeManual FocusMode = "manual"
+)
+
+// FocusModeOf はFOCUSの制御コマンドが切り替えるフォーカスの制御方法を返します。
+func FocusModeOf(value float32) FocusMode {
+	if value < 0 {
+		return FocusModeAuto
+	}
+
+	return FocusModeManual
+}
+
+// ValidateFocusValue はFOCUSの制御コマンドのフォーカス値を検証します。
+// オートフォーカスへの切り替えは supportsAutofocus が true の場合のみ受け付けます。
+func ValidateFocusValue(value float32, supportsAutofocus bool) error {
+	switch {
+	case value == AutofocusValue && !supportsAutofocus:
+		return ErrAutofocusNotSupported
+	case value == AutofocusValue:
+		return nil
+	case value < 0 || value > 1:
+		return fmt.Errorf("%w: focus value %v must be within 0.0-1.0", ErrInvalidFocusCommand, value)
+	}
+
+	return nil
+}
+
+// FocusMove はタスクと共に実行するフォーカスの移動です。
+// DurationMs が 0 の場合はタスクの開始時に Target へ移動し、正の場合は開始時のフォーカス値から
+// Target まで DurationMs かけて補間するラックフォーカスです。
+type FocusMove struct {
+	Target     float32 `json:"target"`
+	DurationMs uint32  `json:"duration_ms"`
+}
+
+// Validate はフォーカスの移動を検証します。
+func (m *FocusMove) Validate() error {
+	if m.Target < 0 || m.Target > 1 {
+		return fmt.Errorf("%w: focus %v must be within 0.0-1.0", ErrInvalidFocusCommand, m.Target)
+	}
+
+	if m.DurationMs > maxFocusDurationMs {
+		return fmt.Errorf("%w: duration_ms must be at most %d", ErrInvalidFocusCommand, maxFocusDurationMs)
+	}
+
+	return nil
+}
+
+// FocusStep はフォーカスの移動の1点です。OffsetMs は移動の開始からこの値に到達するまでの時間です。
+type FocusStep struct {
+	Value    float32 `json:"value"`
+	OffsetMs uint32  `json:"offset_ms"`
+}
+
+// Plan は from から Target までのフォーカスの移動をステップに分割します。
+// ラックフォーカスは smoothstep で加減速し、focusStepIntervalMs ごとのステップに分けます。
+// 開始時のフォーカス値が不明な場合（known が false の場合）は、Target だけのステップを返します。
+func (m *FocusMove) Plan(from float32, known bool) []FocusStep {
+	single := []FocusStep{{Value: m.Target, OffsetMs: m.DurationMs}}
+	if !known || m.DurationMs == 0 || from == m.Target {
+		return single
+	}
+
+	durationMs := float64(m.DurationMs)
+	count := min(int(math.Ceil(durationMs/focusStepIntervalMs)), maxFocusSteps)
+	steps := make([]FocusStep, 0, count)
+
+	for k := 1; k <= count; k++ {
+		progress := float64(k) / float64(count)
+		eased := progress * progress * (3 - 2*progress) //nolint:mnd
+
+		steps = append(steps, FocusStep{
+			Value:    from + float32(eased)*(m.Target-from),
+			OffsetMs: uint32(math.Round(durationMs * progress)),
+		})
+	}
+
+	// 丸め誤差で目標から外れないよう、最後の点は目標そのものにする
+	steps[count-1].Value = m.Target
+
+	return steps
+}
+
+// FocusState はカメラのフォーカスの状態です。
+// CurrentFocus はFDが最後に報告したフォーカス値で、HasCurrentFocus が false の場合は未報告です。
+type FocusState struct {
+	CameraID          string
+	Mode              FocusMode
+	CurrentFocus      float32
+	HasCurrentFocus   bool
+	SupportsAutofocus bool
+}
@@ -0,0 +1,384 @@
+package infrastructure
+
+import (
+	"errors"
+	"math"
+	"strconv"
+
+	protov1 "github.com/anyfld/vistra-operation-control-room/gen/proto/v1"
+)
+
+// カメラの光学特性は CameraCapabilities に含まれないため、Camera.Metadata で指定します。
+// 画角はズーム倍率 1.0（最も広角）での値です。
+const (
+	CameraMetadataHorizontalFOV = "horizontal_fov_deg"
+	CameraMetadataVerticalFOV   = "vertical_fov_deg"
+	CameraMetadataPanSpeed      = "max_pan_speed_dps"
+	CameraMetadataTiltSpeed     = "max_tilt_speed_dps"
+	CameraMetadataZoomSpeed     = "max_zoom_speed_per_sec"
+)
+
+const (
+	defaultHorizontalFOVDeg = 60.0
+	defaultAspectRatio      = 16.0 / 9.0
+	defaultPanSpeedDps      = 90.0
+	defaultTiltSpeedDps     = 60.0
+	defaultZoomSpeedPerSec  = 4.0
+	defaultZoomMax          = 10.0
+	halfTurnDeg             = 180.0
+	fullPanRangeDeg         = halfTurnDeg
+	fullTiltRangeDeg        = 90.0
+
+	// maxWidthFill は被写体の幅が画面の幅に占める最大の割合です。
+	maxWidthFill = 0.9
+	// eyeLineRatio は被写体（人物の全身）の上端から目線までの高さの割合です。
+	eyeLineRatio = 0.07
+	// offCenterThreshold を超えて中心から外れている被写体は、その側の三分割線に配置します。
+	offCenterThreshold = 0.05
+	thirdRatio         = 1.0 / 3.0
+	millisPerSecond    = 1000.0
+)
+
+var ErrNoFramingSubjects = errors.New("no target subjects with bounding boxes")
+
+// CameraOptics はフレーミング計算に使うカメラの画角・可動範囲・速度です。
+type CameraOptics struct {
+	HorizontalFOVDeg float64
+	VerticalFOVDeg   float64
+	PanMin           float64
+	PanMax           float64
+	TiltMin          float64
+	TiltMax          float64
+	ZoomMin          float64
+	ZoomMax          float64
+	PanSpeedDps      float64
+	TiltSpeedDps     float64
+	ZoomSpeedPerSec  float64
+	// Calibration はレンズのキャリブレーションです。nil の場合はピンホールモデルでズーム倍率から画角を求めます。
+	Calibration *LensCalibration
+}
+
+// NewCameraOptics は能力情報とメタデータから光学特性を作成します。
+// 指定されていない値には一般的なPTZカメラの既定値を使います。
+func NewCameraOptics(caps *protov1.CameraCapabilities, metadata map[string]string) *CameraOptics {
+	optics := &CameraOptics{
+		HorizontalFOVDeg: metadataFloat(metadata, CameraMetadataHorizontalFOV, defaultHorizontalFOVDeg),
+		VerticalFOVDeg:   0,
+		PanMin:           -fullPanRangeDeg,
+		PanMax:           fullPanRangeDeg,
+		TiltMin:          -fullTiltRangeDeg,
+		TiltMax:          fullTiltRangeDeg,
+		ZoomMin:          1,
+		ZoomMax:          defaultZoomMax,
+		PanSpeedDps:      metadataFloat(metadata, CameraMetadataPanSpeed, defaultPanSpeedDps),
+		TiltSpeedDps:     metadataFloat(metadata, CameraMetadataTiltSpeed, defaultTiltSpeedDps),
+		ZoomSpeedPerSec:  metadataFloat(metadata, CameraMetadataZoomSpeed, defaultZoomSpeedPerSec),
+		Calibration:      nil,
+	}
+
+	optics.VerticalFOVDeg = metadataFloat(
+		metadata,
+		CameraMetadataVerticalFOV,
+		fovFromTangent(tangentOf(optics.HorizontalFOVDeg)/defaultAspectRatio),
+	)
+
+	if caps.GetPanMax() > caps.GetPanMin() {
+		optics.PanMin, optics.PanMax = float64(caps.GetPanMin()), float64(caps.GetPanMax())
+	}
+
+	if caps.GetTiltMax() > caps.GetTiltMin() {
+		optics.TiltMin, optics.TiltMax = float64(caps.GetTiltMin()), float64(caps.GetTiltMax())
+	}
+
+	switch {
+	case caps.GetZoomMax() > caps.GetZoomMin():
+		optics.ZoomMin, optics.ZoomMax = float64(max(caps.GetZoomMin(), 1)), float64(caps.GetZoomMax())
+	case caps.GetZoomMax() > 0:
+		// 単焦点
+		optics.ZoomMin, optics.ZoomMax = float64(caps.GetZoomMax()), float64(caps.GetZoomMax())
+	}
+
+	return optics
+}
+
+// horizontalFOV はズーム倍率 zoom での水平画角（度）を返します。
+func (o *CameraOptics) horizontalFOV(zoom float64) float64 {
+	if o.Calibration != nil {
+		return fovFromTangent(o.Calibration.horizontalTangent(zoom))
+	}
+
+	return fovFromTangent(tangentOf(o.HorizontalFOVDeg) / zoom)
+}
+
+// verticalFOV はズーム倍率 zoom での垂直画角（度）を返します。
+func (o *CameraOptics) verticalFOV(zoom float64) float64 {
+	if o.Calibration != nil {
+		return fovFromTangent(o.Calibration.horizontalTangent(zoom) / o.aspect())
+	}
+
+	return fovFromTangent(tangentOf(o.VerticalFOVDeg) / zoom)
+}
+
+// shotFraming はショットの種類ごとの構図の基準です。
+type shotFraming struct {
+	// visibleFraction は被写体の上端から画面に収める高さの割合です（ミディアムなら腰から上）。
+	visibleFraction float64
+	// frameFill は収める部分が画面の高さに占める割合です。
+	frameFill float64
+	// headroom は画面上端から被写体の上端までの最小の余白（画面の高さ比）です。0 の場合は頭を切ってよい。
+	headroom float64
+}
+
+//nolint:gochecknoglobals,mnd
+var shotFramings = map[protov1.ShotType]shotFraming{
+	protov1.ShotType_SHOT_TYPE_EXTREME_WIDE:     {visibleFraction: 1.0, frameFill: 0.15, headroom: 0.1},
+	protov1.ShotType_SHOT_TYPE_WIDE:             {visibleFraction: 1.0, frameFill: 0.35, headroom: 0.1},
+	protov1.ShotType_SHOT_TYPE_FULL:             {visibleFraction: 1.0, frameFill: 0.8, headroom: 0.1},
+	protov1.ShotType_SHOT_TYPE_MEDIUM:           {visibleFraction: 0.55, frameFill: 0.85, headroom: 0.08},
+	protov1.ShotType_SHOT_TYPE_MEDIUM_CLOSE_UP:  {visibleFraction: 0.4, frameFill: 0.9, headroom: 0.06},
+	protov1.ShotType_SHOT_TYPE_CLOSE_UP:         {visibleFraction: 0.25, frameFill: 0.9, headroom: 0.04},
+	protov1.ShotType_SHOT_TYPE_EXTREME_CLOSE_UP: {visibleFraction: 0.12, frameFill: 1.0, headroom: 0},
+}
+
+// eyeLinePosition は目線を置く画面上端からの位置（画面の高さ比）です。
+// PTZカメラは高さもロールも変えられないため、アングルは目線の位置で表現します。
+// ダッチアングルはロールが必要なため、アイレベルと同じ構図になります。
+func eyeLinePosition(angle protov1.CameraAngle) float64 {
+	switch angle {
+	case protov1.CameraAngle_CAMERA_ANGLE_LOW:
+		// 被写体を画面の上寄りに置き、見上げる印象にする
+		return 0.25 //nolint:mnd
+	case protov1.CameraAngle_CAMERA_ANGLE_HIGH:
+		return 0.4 //nolint:mnd
+	case protov1.CameraAngle_CAMERA_ANGLE_BIRDS_EYE:
+		return 0.5 //nolint:mnd
+	case protov1.CameraAngle_CAMERA_ANGLE_UNSPECIFIED,
+		protov1.CameraAngle_CAMERA_ANGLE_EYE_LEVEL,
+		protov1.CameraAngle_CAMERA_ANGLE_DUTCH:
+		return thirdRatio
+	}
+
+	return thirdRatio
+}
+
+// subjectBounds は被写体の範囲をワールド座標の角度（度）で表したものです。
+type subjectBounds struct {
+	left, right, top, bottom float64
+	// centerX は現在の画面での被写体の中心のX座標 (0.0-1.0) です。
+	centerX float64
+	count   int
+}
+
+// SolveFraming は検出された被写体を指定したショット・アングルで撮るためのPTZを計算します。
+//
+// バウンディングボックスは current の画面上の座標として扱い、ピンホールモデルで角度に変換します。
+// ズームは被写体の高さ（または幅）がショットの基準を満たす倍率、チルトは目線が三分割線に乗り
+// ヘッドルームを確保できる角度、パンは単独の被写体なら三分割線、複数なら中央に置く角度です。
+// 速度は全軸が同時に到着するように設定し、最も時間のかかる軸から移動時間を見積もります。
+func SolveFraming(
+	optics *CameraOptics,
+	current *protov1.PTZParameters,
+	shotType protov1.ShotType,
+	subjects []*protov1.DetectedSubject,
+	angle protov1.CameraAngle,
+) (*protov1.PTZParameters, uint32, error) {
+	currentPan := float64(current.GetPan())
+	currentTilt := float64(current.GetTilt())
+	currentZoom := clamp(float64(current.GetZoom()), optics.ZoomMin, optics.ZoomMax)
+
+	bounds, ok := measureSubjects(optics, currentPan, currentTilt, currentZoom, subjects)
+	if !ok {
+		return nil, 0, ErrNoFramingSubjects
+	}
+
+	framing, hasShot := shotFramings[shotType]
+
+	targetZoom := currentZoom
+	if hasShot {
+		targetZoom = solveZoom(optics, bounds, framing)
+	}
+
+	hfov := optics.horizontalFOV(targetZoom)
+	vfov := optics.verticalFOV(targetZoom)
+
+	subjectHeight := bounds.top - bounds.bottom
+	eyeLine := bounds.top - eyeLineRatio*subjectHeight
+	targetTilt := eyeLine - frameOffset(vfov, eyeLinePosition(angle))
+
+	if hasShot && framing.headroom > 0 {
+		if maxTop := targetTilt + frameOffset(vfov, framing.headroom); bounds.top > maxTop {
+			targetTilt = bounds.top - frameOffset(vfov, framing.headroom)
+		}
+	}
+
+	targetPan := (bounds.left + bounds.right) / 2 //nolint:mnd
+
+	// 単独の被写体は現在寄っている側の三分割線に置き、視線の先に空間を残す
+	if bounds.count == 1 && (!hasShot || framing.visibleFraction < 1) {
+		switch {
+		case bounds.centerX < 0.5-offCenterThreshold:
+			targetPan += frameOffset(hfov, thirdRatio)
+		case bounds.centerX > 0.5+offCenterThreshold:
+			targetPan -= frameOffset(hfov, thirdRatio)
+		}
+	}
+
+	targetPan = clamp(normalizePan(targetPan), optics.PanMin, optics.PanMax)
+	targetTilt = clamp(targetTilt, optics.TiltMin, optics.TiltMax)
+
+	return planMove(optics, currentPan, currentTilt, currentZoom, targetPan, targetTilt, targetZoom)
+}
+
+func measureSubjects(
+	optics *CameraOptics,
+	pan, tilt, zoom float64,
+	subjects []*protov1.DetectedSubject,
+) (*subjectBounds, bool) {
+	var (
+		bounds           *subjectBounds
+		minX, maxX       float64
+		minY, maxY       float64
+		horizontalCenter float64
+	)
+
+	for _, subject := range subjects {
+		box := subject.GetDetectedBox()
+		if box == nil {
+			box = subject.GetSubject().GetBoundingBox()
+		}
+
+		if box.GetWidth() <= 0 || box.GetHeight() <= 0 {
+			continue
+		}
+
+		x0, y0 := float64(box.GetX()), float64(box.GetY())
+		x1, y1 := x0+float64(box.GetWidth()), y0+float64(box.GetHeight())
+
+		if bounds == nil {
+			// 角度は全ての被写体の範囲が確定してから求める
+			bounds = &subjectBounds{left: 0, right: 0, top: 0, bottom: 0, centerX: 0, count: 0}
+			minX, maxX, minY, maxY = x0, x1, y0, y1
+		}
+
+		minX, maxX = min(minX, x0), max(maxX, x1)
+		minY, maxY = min(minY, y0), max(maxY, y1)
+		horizontalCenter += (x0 + x1) / 2 //nolint:mnd
+		bounds.count++
+	}
+
+	if bounds == nil {
+		return nil, false
+	}
+
+	bounds.left, bounds.top = optics.ImageAngles(pan, tilt, zoom, minX, minY)
+	bounds.right, bounds.bottom = optics.ImageAngles(pan, tilt, zoom, maxX, maxY)
+	bounds.centerX = horizontalCenter / float64(bounds.count)
+
+	return bounds, true
+}
+
+// solveZoom は被写体がショットの基準の大きさになるズーム倍率を返します。
+func solveZoom(optics *CameraOptics, bounds *subjectBounds, framing shotFraming) float64 {
+	visibleHeight := (bounds.top - bounds.bottom) * framing.visibleFraction
+	requiredVFOV := visibleHeight / framing.frameFill
+
+	// 横に並んだ被写体が画面からはみ出さないよう、幅から必要な垂直画角も求める
+	requiredHFOV := (bounds.right - bounds.left) / maxWidthFill
+	widthVFOV := fovFromTangent(tangentOf(requiredHFOV) / optics.aspect())
+
+	targetVFOV := max(requiredVFOV, widthVFOV)
+	if targetVFOV <= 0 {
+		return optics.ZoomMax
+	}
+
+	return optics.zoomForVerticalFOV(targetVFOV)
+}
+
+// planMove は全軸が同時に到着する速度と移動時間を計算します。
+func planMove(
+	optics *CameraOptics,
+	currentPan, currentTilt, currentZoom float64,
+	targetPan, targetTilt, targetZoom float64,
+) (*protov1.PTZParameters, uint32, error) {
+	panDistance := math.Abs(targetPan - currentPan)
+	tiltDistance := math.Abs(targetTilt - currentTilt)
+	zoomDistance := math.Abs(targetZoom - currentZoom)
+
+	moveSeconds := max(
+		axisSeconds(panDistance, optics.PanSpeedDps),
+		axisSeconds(tiltDistance, optics.TiltSpeedDps),
+		axisSeconds(zoomDistance, optics.ZoomSpeedPerSec),
+	)
+
+	ptz := &protov1.PTZParameters{
+		Pan:       float32(targetPan),
+		Tilt:      float32(targetTilt),
+		Zoom:      float32(targetZoom),
+		PanSpeed:  0,
+		TiltSpeed: 0,
+		ZoomSpeed: 0,
+	}
+
+	if moveSeconds > 0 {
+		ptz.PanSpeed = float32(axisSpeed(panDistance, moveSeconds, optics.PanSpeedDps))
+		ptz.TiltSpeed = float32(axisSpeed(tiltDistance, moveSeconds, optics.TiltSpeedDps))
+		ptz.ZoomSpeed = float32(axisSpeed(zoomDistance, moveSeconds, optics.ZoomSpeedPerSec))
+	}
+
+	return ptz, uint32(math.Ceil(moveSeconds * millisPerSecond)), nil
+}
+
+func axisSeconds(distance, maxSpeed float64) float64 {
+	if distance == 0 || maxSpeed <= 0 {
+		return 0
+	}
+
+	return distance / maxSpeed
+}
+
+// axisSpeed は moveSeconds で distance を移動するための正規化速度 (0.0-1.0) です。
+func axisSpeed(distance, moveSeconds, maxSpeed float64) float64 {
+	if maxSpeed <= 0 {
+		return 1
+	}
+
+	return clamp(distance/moveSeconds/maxSpeed, 0, 1)
+}
+
+// frameOffset は画面上の位置 ratio (0.0-1.0) が画角 fov の中心から何度離れているかを返します。
+// 上端・左端側が正です。
+func frameOffset(fov, ratio float64) float64 {
+	return fovFromTangent((1-2*ratio)*tangentOf(fov)) / 2 //nolint:mnd
+}
+
+// tangentOf は画角 fov（度）の半分の正接を返します。
+func tangentOf(fov float64) float64 {
+	return math.Tan(fov / 2 * math.Pi / halfTurnDeg) //nolint:mnd
+}
+
+// fovFromTangent は半画角の正接から画角（度）を返します。
+func fovFromTangent(tangent float64) float64 {
+	return 2 * math.Atan(tangent) * halfTurnDeg / math.Pi //nolint:mnd
+}
+
+func normalizePan(pan float64) float64 {
+	pan = math.Mod(pan+halfTurnDeg, 2*halfTurnDeg) //nolint:mnd
+	if pan < 0 {
+		pan += 2 * halfTurnDeg //nolint:mnd
+	}
+
+	return pan - halfTurnDeg
+}
+
+func clamp(value, lower, upper float64) float64 {
+	return math.Max(lower, math.Min(upper, value))
+}
+
+func metadataFloat(metadata map[string]string, key string, fallback float64) float64 {
+	value, err := strconv.ParseFloat(metadata[key], 64)
+	if err != nil || value <= 0 {
+		return fallback
+	}
+
+	return value
+}
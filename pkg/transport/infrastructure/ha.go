@@ -0,0 +1,460 @@
+package infrastructure
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const (
+	leaseFileName      = "leader.lease"
+	lockFileName       = "leader.lock"
+	stateFileExtension = ".state.json"
+	lockRetryInterval  = 10 * time.Millisecond
+	lockTimeout        = 2 * time.Second
+	staleLockAge       = 10 * time.Second
+	lockTokenSize      = 16
+	stateDirPerm       = 0o750
+	stateFilePerm      = 0o600
+)
+
+var (
+	ErrLeaseNotHeld = errors.New("lease not held by this node")
+	ErrLockTimeout  = errors.New("timed out waiting for shared store lock")
+)
+
+// Lease はリーダーリースです。Term はリーダーが交代するたびに増加します。
+type Lease struct {
+	HolderID    string `json:"holder_id"`
+	Address     string `json:"address"`
+	Term        uint64 `json:"term"`
+	ExpiresAtMs int64  `json:"expires_at_ms"`
+}
+
+func (l *Lease) GetHolderID() string {
+	if l == nil {
+		return ""
+	}
+
+	return l.HolderID
+}
+
+func (l *Lease) GetAddress() string {
+	if l == nil {
+		return ""
+	}
+
+	return l.Address
+}
+
+func (l *Lease) GetTerm() uint64 {
+	if l == nil {
+		return 0
+	}
+
+	return l.Term
+}
+
+func (l *Lease) expired(now time.Time) bool {
+	return l == nil || now.UnixMilli() >= l.ExpiresAtMs
+}
+
+// SharedStore はCRインスタンス間で共有されるリースと状態の保存先です。
+type SharedStore interface {
+	// AcquireLease はリースの取得・更新を試み、現在有効なリースを返します。
+	// 他ノードが有効なリースを保持している場合はそのリースを返します。
+	AcquireLease(holderID string, address string, ttl time.Duration) (*Lease, error)
+	// GetLease は現在のリースを返します。リースが存在しない場合は nil を返します。
+	GetLease() (*Lease, error)
+	// ReleaseLease は holderID が保持しているリースを解放します。
+	ReleaseLease(holderID string) error
+	// SaveState は holderID が有効なリースを保持している場合のみ状態を保存します。
+	SaveState(holderID string, key string, data []byte) error
+	// LoadState は保存された状態を返します。存在しない場合は nil を返します。
+	LoadState(key string) ([]byte, error)
+}
+
+// FileSharedStore は共有ディレクトリ（NFS等）を使った SharedStore の実装です。
+// ディレクトリ内のロックファイルで排他制御を行います。ロックの取得・破棄はリンクとリネームだけで行うため、
+// NFS のようにロックの仕組みを持たないファイルシステムでも動作します。
+type FileSharedStore struct {
+	dir string
+}
+
+// NewFileSharedStore は新しいFileSharedStoreを作成します。
+func NewFileSharedStore(dir string) (*FileSharedStore, error) {
+	if err := os.MkdirAll(dir, stateDirPerm); err != nil {
+		return nil, fmt.Errorf("create state dir: %w", err)
+	}
+
+	return &FileSharedStore{dir: dir}, nil
+}
+
+func (s *FileSharedStore) AcquireLease(holderID string, address string, ttl time.Duration) (*Lease, error) {
+	var current *Lease
+
+	err := s.withLock(func() error {
+		lease, err := s.readLease()
+		if err != nil {
+			return err
+		}
+
+		now := time.Now()
+		if !lease.expired(now) && lease.HolderID != holderID {
+			current = lease
+
+			return nil
+		}
+
+		term := uint64(1)
+		if lease != nil {
+			term = lease.Term
+			if lease.HolderID != holderID {
+				term++
+			}
+		}
+
+		current = &Lease{
+			HolderID:    holderID,
+			Address:     address,
+			Term:        term,
+			ExpiresAtMs: now.Add(ttl).UnixMilli(),
+		}
+
+		return s.writeFile(leaseFileName, current)
+	})
+
+	return current, err
+}
+
+func (s *FileSharedStore) GetLease() (*Lease, error) {
+	return s.readLease()
+}
+
+func (s *FileSharedStore) ReleaseLease(holderID string) error {
+	return s.withLock(func() error {
+		lease, err := s.readLease()
+		if err != nil {
+			return err
+		}
+
+		if lease == nil || lease.HolderID != holderID {
+			return nil
+		}
+
+		lease.ExpiresAtMs = 0
+
+		return s.writeFile(leaseFileName, lease)
+	})
+}
+
+func (s *FileSharedStore) SaveState(holderID string, key string, data []byte) error {
+	return s.withLock(func() error {
+		lease, err := s.readLease()
+		if err != nil {
+			return err
+		}
+
+		if lease.expired(time.Now()) || lease.HolderID != holderID {
+			return ErrLeaseNotHeld
+		}
+
+		return s.writeRaw(key+stateFileExtension, data)
+	})
+}
+
+func (s *FileSharedStore) LoadState(key string) ([]byte, error) {
+	data, err := os.ReadFile(filepath.Join(s.dir, key+stateFileExtension))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+
+	return data, err
+}
+
+func (s *FileSharedStore) readLease() (*Lease, error) {
+	data, err := os.ReadFile(filepath.Join(s.dir, leaseFileName))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	lease := new(Lease)
+	if err := json.Unmarshal(data, lease); err != nil {
+		return nil, fmt.Errorf("decode lease: %w", err)
+	}
+
+	return lease, nil
+}
+
+func (s *FileSharedStore) writeFile(name string, value any) error {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+
+	return s.writeRaw(name, data)
+}
+
+// writeRaw は一時ファイルへの書き込みとリネームでアトミックにファイルを置き換えます。
+func (s *FileSharedStore) writeRaw(name string, data []byte) error {
+	tmp, err := os.CreateTemp(s.dir, name+".tmp-*")
+	if err != nil {
+		return err
+	}
+
+	if _, err := tmp.Write(data); err != nil {
+		_ = tmp.Close()
+		_ = os.Remove(tmp.Name())
+
+		return err
+	}
+
+	if err := tmp.Sync(); err != nil {
+		_ = tmp.Close()
+		_ = os.Remove(tmp.Name())
+
+		return err
+	}
+
+	if err := tmp.Close(); err != nil {
+		_ = os.Remove(tmp.Name())
+
+		return err
+	}
+
+	if err := os.Chmod(tmp.Name(), stateFilePerm); err != nil {
+		_ = os.Remove(tmp.Name())
+
+		return err
+	}
+
+	return os.Rename(tmp.Name(), filepath.Join(s.dir, name))
+}
+
+// withLock はロックファイルを取得して fn を実行します。
+// ロックファイルには取得したノードのトークンを書き込み、破棄や解放はリネームで自分だけのファイルにしてから
+// トークンを確かめて行います。stat と削除の間に他ノードが取得し直したロックを消さないためです。
+func (s *FileSharedStore) withLock(fn func() error) error {
+	token, err := newLockToken()
+	if err != nil {
+		return err
+	}
+
+	deadline := time.Now().Add(lockTimeout)
+
+	for {
+		acquired, err := s.tryLock(token)
+		if err != nil {
+			return err
+		}
+
+		if acquired {
+			break
+		}
+
+		// 異常終了したノードが残したロックは一定時間後に破棄する
+		if holder, modTime, err := s.readLock(); err == nil && time.Since(modTime) > staleLockAge {
+			if err := s.takeLock(holder, token+".stale"); err != nil {
+				return err
+			}
+
+			continue
+		}
+
+		if time.Now().After(deadline) {
+			return ErrLockTimeout
+		}
+
+		time.Sleep(lockRetryInterval)
+	}
+
+	defer func() {
+		_ = s.takeLock(token, token+".release")
+	}()
+
+	return fn()
+}
+
+// tryLock はトークンを書き込んだ一時ファイルをロックファイルへリンクし、ロックの取得を試みます。
+// リンクは既存のファイルを上書きしないため、トークンが空のロックファイルが見えることはありません。
+func (s *FileSharedStore) tryLock(token string) (bool, error) {
+	tmpPath := filepath.Join(s.dir, lockFileName+"."+token)
+	if err := os.WriteFile(tmpPath, []byte(token), stateFilePerm); err != nil {
+		return false, err
+	}
+
+	defer func() {
+		_ = os.Remove(tmpPath)
+	}()
+
+	err := os.Link(tmpPath, filepath.Join(s.dir, lockFileName))
+	if errors.Is(err, os.ErrExist) {
+		return false, nil
+	}
+
+	return err == nil, err
+}
+
+// readLock はロックファイルのトークンと更新時刻を返します。
+func (s *FileSharedStore) readLock() (string, time.Time, error) {
+	lockPath := filepath.Join(s.dir, lockFileName)
+
+	info, err := os.Stat(lockPath)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	data, err := os.ReadFile(lockPath)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	return string(data), info.ModTime(), nil
+}
+
+// takeLock は holder が保持するロックファイルを削除します。ロックファイルを suffix を付けた名前へリネームしてから
+// トークンを確かめ、holder 以外が取得し直していた場合はロックファイルを元に戻します。
+func (s *FileSharedStore) takeLock(holder string, suffix string) error {
+	lockPath := filepath.Join(s.dir, lockFileName)
+	takenPath := lockPath + "." + suffix
+
+	if err := os.Rename(lockPath, takenPath); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			// 他ノードが先に破棄した
+			return nil
+		}
+
+		return err
+	}
+
+	defer func() {
+		_ = os.Remove(takenPath)
+	}()
+
+	data, err := os.ReadFile(takenPath)
+	if err != nil || string(data) == holder {
+		return err
+	}
+
+	// 確認から取得までの間に他ノードが取得し直したロックだった。
+	// 戻す前に別のノードが取得していた場合、そのノードのロックを優先する
+	if err := os.Link(takenPath, lockPath); err != nil && !errors.Is(err, os.ErrExist) {
+		return err
+	}
+
+	return nil
+}
+
+func newLockToken() (string, error) {
+	token := make([]byte, lockTokenSize)
+	if _, err := rand.Read(token); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(token), nil
+}
+
+// LeaderElector はリースに基づくリーダー選出を行います。
+type LeaderElector struct {
+	mu      sync.RWMutex
+	store   SharedStore
+	nodeID  string
+	address string
+	ttl     time.Duration
+	current *Lease
+}
+
+// NewLeaderElector は新しいLeaderElectorを作成します。
+func NewLeaderElector(store SharedStore, nodeID string, address string, ttl time.Duration) *LeaderElector {
+	return &LeaderElector{
+		mu:      sync.RWMutex{},
+		store:   store,
+		nodeID:  nodeID,
+		address: address,
+		ttl:     ttl,
+		current: nil,
+	}
+}
+
+// TryAcquire はリースの取得・更新を試みます。
+// このノードが新たにリーダーになった場合 true を返します。
+func (e *LeaderElector) TryAcquire() (bool, error) {
+	wasLeader := e.IsLeader()
+
+	lease, err := e.store.AcquireLease(e.nodeID, e.address, e.ttl)
+	if err != nil {
+		// 共有ストアに到達できない間は、手元のリースが失効した時点で自動的に降格する
+		return false, err
+	}
+
+	e.mu.Lock()
+	e.current = lease
+	e.mu.Unlock()
+
+	return !wasLeader && e.IsLeader(), nil
+}
+
+// Observe はリースを取得せずに現在のリーダーを読み込みます。
+func (e *LeaderElector) Observe() error {
+	lease, err := e.store.GetLease()
+	if err != nil {
+		return err
+	}
+
+	e.mu.Lock()
+	e.current = lease
+	e.mu.Unlock()
+
+	return nil
+}
+
+// Release はこのノードが保持するリースを解放します。
+func (e *LeaderElector) Release() error {
+	e.mu.Lock()
+	e.current = nil
+	e.mu.Unlock()
+
+	return e.store.ReleaseLease(e.nodeID)
+}
+
+// TTL はリースの有効期間を返します。
+func (e *LeaderElector) TTL() time.Duration {
+	return e.ttl
+}
+
+// IsLeader はこのノードが有効なリースを保持しているかを返します。
+func (e *LeaderElector) IsLeader() bool {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	return e.current != nil && e.current.HolderID == e.nodeID && !e.current.expired(time.Now())
+}
+
+// Leader は最後に観測したリーダーのリースを返します。不明な場合は nil を返します。
+func (e *LeaderElector) Leader() *Lease {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	if e.current == nil || e.current.expired(time.Now()) {
+		return nil
+	}
+
+	lease := *e.current
+
+	return &lease
+}
+
+// NodeID はこのノードのIDを返します。
+func (e *LeaderElector) NodeID() string {
+	return e.nodeID
+}
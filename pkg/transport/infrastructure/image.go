@@ -0,0 +1,234 @@
+package infrastructure
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	protov1 "github.com/anyfld/vistra-operation-control-room/gen/proto/v1"
+)
+
+const (
+	maxImageBytes        = 32 << 20
+	maxCachedReferences  = 64
+	defaultImageTimeout  = 10 * time.Second
+	rawGrayBytesPerPixel = 1
+	rawRGBBytesPerPixel  = 3
+	rawRGBABytesPerPixel = 4
+	dataURLBase64Marker  = ";base64,"
+	dataURLSchemePrefix  = "data:"
+	httpURLSchemePrefix  = "http://"
+	httpsURLSchemePrefix = "https://"
+)
+
+var (
+	ErrInvalidImage      = errors.New("invalid image")
+	ErrImageSizeMismatch = errors.New("image size does not match width/height")
+)
+
+// ImageLoader は ImageData のデコードと参照画像の取得を行います。
+// 参照画像は URL ごとにキャッシュします。
+type ImageLoader struct {
+	client     *http.Client
+	mu         sync.Mutex
+	references map[string]image.Image
+}
+
+// NewImageLoader は新しいImageLoaderを作成します。client が nil の場合はタイムアウト付きのクライアントを使います。
+func NewImageLoader(client *http.Client) *ImageLoader {
+	if client == nil {
+		client = &http.Client{Timeout: defaultImageTimeout} //nolint:exhaustruct
+	}
+
+	return &ImageLoader{
+		client:     client,
+		mu:         sync.Mutex{},
+		references: make(map[string]image.Image),
+	}
+}
+
+// DecodeImageData は ImageData をデコードします。Data が空の場合は Url から取得します。
+// Width/Height が指定されている場合、デコードした画像の大きさと一致しなければエラーになります。
+// RAW は Width×Height のグレースケール・RGB・RGBA のいずれかで、1画素のバイト数はデータ長から判断します。
+func (l *ImageLoader) DecodeImageData(ctx context.Context, data *protov1.ImageData) (image.Image, error) {
+	if data == nil {
+		return nil, fmt.Errorf("%w: image is required", ErrInvalidImage)
+	}
+
+	raw := data.GetData()
+	if len(raw) == 0 && data.GetUrl() != "" {
+		fetched, err := l.fetch(ctx, data.GetUrl())
+		if err != nil {
+			return nil, err
+		}
+
+		raw = fetched
+	}
+
+	if len(raw) == 0 {
+		return nil, fmt.Errorf("%w: image data or url is required", ErrInvalidImage)
+	}
+
+	return DecodeImage(data.GetFormat(), raw, int(data.GetWidth()), int(data.GetHeight()))
+}
+
+// LoadReference は被写体の参照画像を取得してデコードします。
+func (l *ImageLoader) LoadReference(ctx context.Context, url string) (image.Image, error) {
+	l.mu.Lock()
+	cached, ok := l.references[url]
+	l.mu.Unlock()
+
+	if ok {
+		return cached, nil
+	}
+
+	raw, err := l.fetch(ctx, url)
+	if err != nil {
+		return nil, err
+	}
+
+	img, err := DecodeImage(protov1.ImageFormat_IMAGE_FORMAT_UNSPECIFIED, raw, 0, 0)
+	if err != nil {
+		return nil, fmt.Errorf("reference image %s: %w", url, err)
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if len(l.references) >= maxCachedReferences {
+		for key := range l.references {
+			delete(l.references, key)
+
+			break
+		}
+	}
+
+	l.references[url] = img
+
+	return img, nil
+}
+
+// fetch は http(s) と data URL から画像のバイト列を取得します。
+func (l *ImageLoader) fetch(ctx context.Context, url string) ([]byte, error) {
+	switch {
+	case strings.HasPrefix(url, dataURLSchemePrefix):
+		_, encoded, ok := strings.Cut(url, dataURLBase64Marker)
+		if !ok {
+			return nil, fmt.Errorf("%w: only base64 data urls are supported", ErrInvalidImage)
+		}
+
+		decoded, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrInvalidImage, err)
+		}
+
+		return decoded, nil
+	case strings.HasPrefix(url, httpURLSchemePrefix), strings.HasPrefix(url, httpsURLSchemePrefix):
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrInvalidImage, err)
+		}
+
+		resp, err := l.client.Do(req)
+		if err != nil {
+			return nil, fmt.Errorf("fetch image %s: %w", url, err)
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("fetch image %s: unexpected status %d", url, resp.StatusCode)
+		}
+
+		body, err := io.ReadAll(io.LimitReader(resp.Body, maxImageBytes+1))
+		if err != nil {
+			return nil, fmt.Errorf("fetch image %s: %w", url, err)
+		}
+
+		if len(body) > maxImageBytes {
+			return nil, fmt.Errorf("%w: image exceeds %d bytes", ErrInvalidImage, maxImageBytes)
+		}
+
+		return body, nil
+	default:
+		return nil, fmt.Errorf("%w: unsupported image url %q", ErrInvalidImage, url)
+	}
+}
+
+// DecodeImage は指定したフォーマットの画像をデコードします。
+// フォーマットが未指定の場合は JPEG と PNG を内容から判別します。
+func DecodeImage(format protov1.ImageFormat, data []byte, width, height int) (image.Image, error) {
+	var (
+		img image.Image
+		err error
+	)
+
+	switch format {
+	case protov1.ImageFormat_IMAGE_FORMAT_RAW:
+		return decodeRawImage(data, width, height)
+	case protov1.ImageFormat_IMAGE_FORMAT_JPEG:
+		img, err = jpeg.Decode(bytes.NewReader(data))
+	case protov1.ImageFormat_IMAGE_FORMAT_PNG:
+		img, err = png.Decode(bytes.NewReader(data))
+	case protov1.ImageFormat_IMAGE_FORMAT_UNSPECIFIED:
+		img, _, err = image.Decode(bytes.NewReader(data))
+	default:
+		return nil, fmt.Errorf("%w: unsupported format %s", ErrInvalidImage, format)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidImage, err)
+	}
+
+	bounds := img.Bounds()
+	if (width > 0 && bounds.Dx() != width) || (height > 0 && bounds.Dy() != height) {
+		return nil, fmt.Errorf(
+			"%w: decoded %dx%d, expected %dx%d",
+			ErrImageSizeMismatch, bounds.Dx(), bounds.Dy(), width, height,
+		)
+	}
+
+	return img, nil
+}
+
+func decodeRawImage(data []byte, width, height int) (image.Image, error) {
+	if width <= 0 || height <= 0 {
+		return nil, fmt.Errorf("%w: raw image requires width and height", ErrInvalidImage)
+	}
+
+	pixels := width * height
+	rect := image.Rect(0, 0, width, height)
+
+	switch len(data) {
+	case pixels * rawGrayBytesPerPixel:
+		return &image.Gray{Pix: data, Stride: width, Rect: rect}, nil
+	case pixels * rawRGBABytesPerPixel:
+		return &image.NRGBA{Pix: data, Stride: width * rawRGBABytesPerPixel, Rect: rect}, nil
+	case pixels * rawRGBBytesPerPixel:
+		img := image.NewNRGBA(rect)
+
+		for i := range pixels {
+			src := data[i*rawRGBBytesPerPixel:]
+			img.Pix[i*rawRGBABytesPerPixel] = src[0]
+			img.Pix[i*rawRGBABytesPerPixel+1] = src[1]
+			img.Pix[i*rawRGBABytesPerPixel+2] = src[2]
+			img.Pix[i*rawRGBABytesPerPixel+3] = colorChannelMax
+		}
+
+		return img, nil
+	default:
+		return nil, fmt.Errorf(
+			"%w: %d bytes is not a %dx%d gray, rgb or rgba image",
+			ErrImageSizeMismatch, len(data), width, height,
+		)
+	}
+}
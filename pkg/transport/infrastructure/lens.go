@@ -0,0 +1,185 @@
+package infrastructure
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+
+	protov1 "github.com/anyfld/vistra-operation-control-room/gen/proto/v1"
+)
+
+var ErrInvalidLensCalibration = errors.New("invalid lens calibration")
+
+const (
+	// lensSearchIterations はズーム倍率を画角から逆算する二分探索の回数です。
+	lensSearchIterations = 60
+)
+
+// ZoomFOVPoint はキャリブレーションで測定したズーム倍率と水平画角（度）の組です。
+type ZoomFOVPoint struct {
+	Zoom             float64 `json:"zoom"`
+	HorizontalFOVDeg float64 `json:"horizontal_fov_deg"`
+}
+
+// LensCalibration はカメラごとのレンズのキャリブレーションです。
+//
+// ZoomFOV はズーム倍率ごとに測定した水平画角で、ズーム倍率の昇順に並べます。
+// 測定点の間は焦点距離（半画角の正接の逆数）がズーム倍率に対して線形に変化するものとして補間し、
+// 範囲外は端の測定点からピンホールモデルで外挿します。
+// SensorAspect はセンサー（映像）の横縦比で、垂直画角は水平画角とこの比から求めます。
+type LensCalibration struct {
+	ZoomFOV      []ZoomFOVPoint `json:"zoom_fov"`
+	SensorAspect float64        `json:"sensor_aspect"`
+	UpdatedAtMs  int64          `json:"updated_at_ms"`
+}
+
+// Validate はキャリブレーションを検証します。c は変更しません。
+// SensorAspect の 0 は未指定で、CameraRepo.SetLensCalibration が保存時に 16:9 とします。
+func (c *LensCalibration) Validate() error {
+	if len(c.ZoomFOV) == 0 {
+		return fmt.Errorf("%w: at least one zoom_fov point is required", ErrInvalidLensCalibration)
+	}
+
+	for i, point := range c.ZoomFOV {
+		if point.Zoom <= 0 {
+			return fmt.Errorf("%w: zoom_fov[%d] zoom must be positive", ErrInvalidLensCalibration, i)
+		}
+
+		if point.HorizontalFOVDeg <= 0 || point.HorizontalFOVDeg >= halfTurnDeg {
+			return fmt.Errorf("%w: zoom_fov[%d] horizontal_fov_deg must be within (0, 180)", ErrInvalidLensCalibration, i)
+		}
+
+		if i == 0 {
+			continue
+		}
+
+		previous := c.ZoomFOV[i-1]
+		if point.Zoom <= previous.Zoom {
+			return fmt.Errorf("%w: zoom_fov must be sorted by zoom without duplicates", ErrInvalidLensCalibration)
+		}
+
+		if point.HorizontalFOVDeg > previous.HorizontalFOVDeg {
+			return fmt.Errorf("%w: zoom_fov[%d] field of view widens as zoom increases", ErrInvalidLensCalibration, i)
+		}
+	}
+
+	if c.SensorAspect < 0 {
+		return fmt.Errorf("%w: sensor_aspect must be positive", ErrInvalidLensCalibration)
+	}
+
+	return nil
+}
+
+// horizontalTangent はズーム倍率 zoom での水平の半画角の正接を返します。
+func (c *LensCalibration) horizontalTangent(zoom float64) float64 {
+	points := c.ZoomFOV
+	first, last := points[0], points[len(points)-1]
+
+	switch {
+	case zoom <= first.Zoom:
+		return tangentOf(first.HorizontalFOVDeg) * first.Zoom / zoom
+	case zoom >= last.Zoom:
+		return tangentOf(last.HorizontalFOVDeg) * last.Zoom / zoom
+	}
+
+	index := slices.IndexFunc(points, func(point ZoomFOVPoint) bool { return point.Zoom >= zoom })
+	lower, upper := points[index-1], points[index]
+
+	ratio := (zoom - lower.Zoom) / (upper.Zoom - lower.Zoom)
+	lowerFocal, upperFocal := 1/tangentOf(lower.HorizontalFOVDeg), 1/tangentOf(upper.HorizontalFOVDeg)
+
+	return 1 / (lowerFocal + ratio*(upperFocal-lowerFocal))
+}
+
+// WithCalibration はキャリブレーションを適用した光学特性を返します。nil の場合は元の光学特性を返します。
+// ズーム倍率 1.0 での画角（HorizontalFOVDeg, VerticalFOVDeg）もキャリブレーションから求めた値に置き換えます。
+func (o *CameraOptics) WithCalibration(calibration *LensCalibration) *CameraOptics {
+	if calibration == nil || len(calibration.ZoomFOV) == 0 {
+		return o
+	}
+
+	calibrated := *o
+	calibrated.Calibration = calibration
+	calibrated.HorizontalFOVDeg = fovFromTangent(calibration.horizontalTangent(1))
+	calibrated.VerticalFOVDeg = fovFromTangent(calibration.horizontalTangent(1) / calibrated.aspect())
+
+	return &calibrated
+}
+
+// aspect は映像の横縦比です。
+func (o *CameraOptics) aspect() float64 {
+	if o.Calibration != nil && o.Calibration.SensorAspect > 0 {
+		return o.Calibration.SensorAspect
+	}
+
+	return tangentOf(o.HorizontalFOVDeg) / tangentOf(o.VerticalFOVDeg)
+}
+
+// zoomForVerticalFOV は垂直画角が vfov（度）になるズーム倍率を返します（可動範囲に収めます）。
+func (o *CameraOptics) zoomForVerticalFOV(vfov float64) float64 {
+	if o.Calibration == nil {
+		return clamp(tangentOf(o.VerticalFOVDeg)/tangentOf(vfov), o.ZoomMin, o.ZoomMax)
+	}
+
+	// 画角はズーム倍率に対して単調に狭くなるため、二分探索で求める
+	lower, upper := o.ZoomMin, o.ZoomMax
+	if o.verticalFOV(lower) <= vfov {
+		return lower
+	}
+
+	if o.verticalFOV(upper) >= vfov {
+		return upper
+	}
+
+	for range lensSearchIterations {
+		middle := (lower + upper) / 2 //nolint:mnd
+		if o.verticalFOV(middle) > vfov {
+			lower = middle
+		} else {
+			upper = middle
+		}
+	}
+
+	return (lower + upper) / 2 //nolint:mnd
+}
+
+// ImageAngles は画面上の位置 (x, y)（左上が 0、右下が 1）を、PTZ (pan, tilt, zoom) のカメラから見た
+// パン・チルトの角度（度）に変換します。パンは右、チルトは上が正です。
+func (o *CameraOptics) ImageAngles(pan, tilt, zoom, x, y float64) (float64, float64) {
+	zoom = o.effectiveZoom(zoom)
+
+	// 画面座標は上端が 0 のため、上方向を正とするチルトでは符号が反転する
+	return pan - frameOffset(o.horizontalFOV(zoom), x), tilt + frameOffset(o.verticalFOV(zoom), y)
+}
+
+// ImageToPTZ は current のPTZで撮影した画面上の位置 (x, y) を画面の中心に置く絶対PTZを返します。
+// ズームは current のまま、パン・チルトは可動範囲に収めます。
+func (o *CameraOptics) ImageToPTZ(current *protov1.PTZParameters, x, y float64) *protov1.PTZParameters {
+	zoom := o.effectiveZoom(float64(current.GetZoom()))
+	pan, tilt := o.ImageAngles(float64(current.GetPan()), float64(current.GetTilt()), zoom, x, y)
+
+	return &protov1.PTZParameters{
+		Pan:       float32(clamp(normalizePan(pan), o.PanMin, o.PanMax)),
+		Tilt:      float32(clamp(tilt, o.TiltMin, o.TiltMax)),
+		Zoom:      float32(zoom),
+		PanSpeed:  0,
+		TiltSpeed: 0,
+		ZoomSpeed: 0,
+	}
+}
+
+// effectiveZoom は報告されていない（0 の）ズーム倍率を最も広角として、可動範囲に収めたズーム倍率を返します。
+func (o *CameraOptics) effectiveZoom(zoom float64) float64 {
+	if zoom <= 0 {
+		return max(o.ZoomMin, 1)
+	}
+
+	return clamp(zoom, o.ZoomMin, o.ZoomMax)
+}
+
+// FieldOfView はズーム倍率 zoom での水平・垂直の画角（度）を返します。
+func (o *CameraOptics) FieldOfView(zoom float64) (float64, float64) {
+	zoom = o.effectiveZoom(zoom)
+
+	return o.horizontalFOV(zoom), o.verticalFOV(zoom)
+}
@@ -0,0 +1,131 @@
+package infrastructure
+
+import (
+	"slices"
+	"sync"
+)
+
+// mailbox は受信が遅れても送信側をブロックせず、受信するまで値を保持して順に届けるチャネルです。
+// 取りこぼすとタスクや結果が終了しなくなる配信に使います。
+type mailbox[T any] struct {
+	mu      sync.Mutex
+	pending []T
+	closed  bool
+	// wake は pending に値が追加されたことを配信のゴルーチンに知らせます。
+	wake chan struct{}
+	done chan struct{}
+	ch   chan T
+}
+
+func newMailbox[T any]() *mailbox[T] {
+	m := &mailbox[T]{
+		mu:      sync.Mutex{},
+		pending: make([]T, 0),
+		closed:  false,
+		wake:    make(chan struct{}, 1),
+		done:    make(chan struct{}),
+		ch:      make(chan T),
+	}
+
+	go m.run()
+
+	return m
+}
+
+// push は値を追加します。ブロックしないため、リポジトリのロックを保持したまま呼び出せます。
+func (m *mailbox[T]) push(value T) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.closed {
+		return
+	}
+
+	m.pending = append(m.pending, value)
+
+	select {
+	case m.wake <- struct{}{}:
+	default:
+	}
+}
+
+// close は配信を終了し、チャネルを閉じます。受信していない値は破棄されます。
+func (m *mailbox[T]) close() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.closed {
+		return
+	}
+
+	m.closed = true
+	m.pending = nil
+	close(m.done)
+}
+
+// run は追加された値を順にチャネルへ送ります。
+func (m *mailbox[T]) run() {
+	defer close(m.ch)
+
+	for {
+		m.mu.Lock()
+
+		if len(m.pending) == 0 {
+			m.mu.Unlock()
+
+			select {
+			case <-m.done:
+				return
+			case <-m.wake:
+				continue
+			}
+		}
+
+		var zero T
+
+		value := m.pending[0]
+		m.pending[0] = zero
+		m.pending = m.pending[1:]
+
+		m.mu.Unlock()
+
+		select {
+		case <-m.done:
+			return
+		case m.ch <- value:
+		}
+	}
+}
+
+// EventListener はイベントを破棄せずに発生順に受け取る購読です（EventBus.Listen）。
+// EventSubscription と異なり受信が遅れても配信側をブロックせず、受信するまでイベントを保持します。
+// キューと制御コマンドの同期など、イベントを取りこぼすとタスクや制御コマンドが終了しなくなる
+// コントロールルーム内部の処理に使い、EventSubscription は外部への配信に使います。
+type EventListener struct {
+	topics  []EventTopic
+	mailbox *mailbox[*Event]
+}
+
+func newEventListener(topics []EventTopic) *EventListener {
+	return &EventListener{
+		topics:  slices.Clone(topics),
+		mailbox: newMailbox[*Event](),
+	}
+}
+
+// Events はイベントを受信するチャネルを返します。購読解除で閉じられます。
+func (l *EventListener) Events() <-chan *Event {
+	return l.mailbox.ch
+}
+
+func (l *EventListener) matches(topic EventTopic) bool {
+	return len(l.topics) == 0 || slices.Contains(l.topics, topic)
+}
+
+func (l *EventListener) push(event *Event) {
+	l.mailbox.push(event)
+}
+
+func (l *EventListener) close() {
+	l.mailbox.close()
+}
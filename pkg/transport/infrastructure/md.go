@@ -13,6 +13,7 @@ type MDRepo struct {
 	videoOutputs               map[string]*protov1.VideoOutput
 	cinematographyInstructions map[string]*protov1.CinematographyInstruction
 	llmRequests                map[string]*LLMRequest
+	events                     *EventBus
 }
 
 type LLMRequest struct {
@@ -22,12 +23,13 @@ type LLMRequest struct {
 	CreatedAt time.Time
 }
 
-func NewMDRepo() *MDRepo {
+func NewMDRepo(events *EventBus) *MDRepo {
 	return &MDRepo{
 		mu:                         sync.RWMutex{},
 		videoOutputs:               make(map[string]*protov1.VideoOutput),
 		cinematographyInstructions: make(map[string]*protov1.CinematographyInstruction),
 		llmRequests:                make(map[string]*LLMRequest),
+		events:                     events,
 	}
 }
 
@@ -51,6 +53,8 @@ func (r *MDRepo) ConfigureVideoOutput(config *protov1.VideoOutputConfig) *protov
 
 	r.videoOutputs[outputID] = output
 
+	r.events.Publish(EventTopicOutput, EventOutputConfigured, outputID, "", output)
+
 	return output
 }
 
@@ -123,6 +127,8 @@ func (r *MDRepo) StartStreaming(outputID string, sourceCameraID string) bool {
 	output.StreamingStartedAtMs = time.Now().UnixMilli()
 	output.ErrorMessage = ""
 
+	r.events.Publish(EventTopicOutput, EventOutputStreamingStarted, outputID, sourceCameraID, output)
+
 	return true
 }
 
@@ -139,6 +145,8 @@ func (r *MDRepo) StopStreaming(outputID string) bool {
 	output.CurrentSourceCameraId = ""
 	output.StreamingStartedAtMs = 0
 
+	r.events.Publish(EventTopicOutput, EventOutputStreamingStopped, outputID, "", output)
+
 	return true
 }
 
@@ -153,6 +161,8 @@ func (r *MDRepo) SwitchSource(outputID string, newSourceCameraID string) bool {
 
 	output.CurrentSourceCameraId = newSourceCameraID
 
+	r.events.Publish(EventTopicOutput, EventOutputSourceSwitched, outputID, newSourceCameraID, output)
+
 	return true
 }
 
@@ -171,6 +181,14 @@ func (r *MDRepo) ReceiveCinematographyInstruction(
 
 	r.cinematographyInstructions[instructionID] = instruction
 
+	r.events.Publish(
+		EventTopicTask,
+		EventCinematographyInstruction,
+		instructionID,
+		instruction.GetCameraId(),
+		instruction,
+	)
+
 	return &protov1.ReceiveCinematographyInstructionResponse{
 		Accepted:        true,
 		InstructionId:   instructionID,
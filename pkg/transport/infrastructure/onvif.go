@@ -0,0 +1,427 @@
+package infrastructure
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha1" //nolint:gosec // WS-Security の PasswordDigest は SHA-1 で定義されている
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	protov1 "github.com/anyfld/vistra-operation-control-room/gen/proto/v1"
+)
+
+// ONVIF のカメラは PTZ サービス（ver20）を SOAP 1.2 で呼び出して制御します。
+// 制御コマンドの角度と倍率は CameraOptics で正規化座標に変換し、ONVIF の汎用空間
+// （パン・チルト -1.0〜1.0、ズーム 0.0〜1.0、速度 0.0〜1.0）の値としてそのまま送ります。
+// 相対移動の移動量は可動範囲全体を 2.0（ズームは 1.0）とする汎用の移動量空間で表します。
+//
+// 接続先は CameraConnection の Address と Port から作り、Parameters で次の値を上書きできます。
+//   - onvif_ptz_path: PTZ サービスのパス（省略時 /onvif/ptz_service）
+//   - onvif_media_path: プロファイルの取得に使うメディアサービスのパス（省略時 /onvif/media_service）
+//   - onvif_profile_token: 制御するプロファイルのトークン（省略時はメディアサービスの最初のプロファイル）
+//
+// Credentials の Username がある場合は WS-Security の UsernameToken（PasswordDigest）で認証します。
+const (
+	ConnectionParameterONVIFPTZPath      = "onvif_ptz_path"
+	ConnectionParameterONVIFMediaPath    = "onvif_media_path"
+	ConnectionParameterONVIFProfileToken = "onvif_profile_token"
+
+	defaultONVIFPTZPath   = "/onvif/ptz_service"
+	defaultONVIFMediaPath = "/onvif/media_service"
+
+	onvifPTZNamespace      = "http://www.onvif.org/ver20/ptz/wsdl"
+	onvifMediaNamespace    = "http://www.onvif.org/ver10/media/wsdl"
+	onvifSchemaNamespace   = "http://www.onvif.org/ver10/schema"
+	soapEnvelopeNamespace  = "http://www.w3.org/2003/05/soap-envelope"
+	wsseNamespace          = "http://docs.oasis-open.org/wss/2004/01/oasis-200401-wss-wssecurity-secext-1.0.xsd"
+	wsuNamespace           = "http://docs.oasis-open.org/wss/2004/01/oasis-200401-wss-wssecurity-utility-1.0.xsd"
+	wssePasswordDigestType = "http://docs.oasis-open.org/wss/2004/01/oasis-200401-wss-username-token-profile-1.0" +
+		"#PasswordDigest"
+	wsseBase64EncodingType = "http://docs.oasis-open.org/wss/2004/01/oasis-200401-soap-message-security-1.0" +
+		"#Base64Binary"
+
+	// onvifNonceSize は UsernameToken の Nonce のバイト数です。
+	onvifNonceSize = 16
+	// onvifMaxResponseBytes はSOAPレスポンスとして読み込む上限です。
+	onvifMaxResponseBytes = 1 << 20
+	// onvifMoveStatusMoving は GetStatus の MoveStatus で移動中を表す値です。
+	onvifMoveStatusMoving = "MOVING"
+	// onvifNoError は GetStatus の Error でエラーがないことを表す値です（機種によって返されます）。
+	onvifNoError = "no error"
+)
+
+var (
+	// ErrInvalidONVIFConnection はカメラの接続情報からONVIFの接続先を作れないことを表します。
+	ErrInvalidONVIFConnection = errors.New("invalid onvif connection")
+	// ErrONVIFFault はカメラがSOAPのエラーを返したことを表します。
+	ErrONVIFFault = errors.New("onvif fault")
+	// ErrONVIFNoProfile はカメラにPTZを制御できるプロファイルがないことを表します。
+	ErrONVIFNoProfile = errors.New("onvif device has no media profile")
+	// ErrONVIFUnsupportedCommand はONVIFのPTZサービスで実行できない制御コマンドを表します。
+	ErrONVIFUnsupportedCommand = errors.New("control command is not supported by onvif ptz")
+)
+
+// ONVIFStatus は GetStatus で取得したPTZの状態です。
+type ONVIFStatus struct {
+	// Position は正規化座標の位置です。
+	Position *protov1.PTZPosition
+	// Moving はパン・チルトまたはズームが移動中かどうかです。
+	Moving bool
+	// Error はカメラが報告したエラーです。エラーがない場合は空です。
+	Error string
+}
+
+// ONVIFDevice は1台のONVIFカメラのPTZサービスへの接続です。
+type ONVIFDevice struct {
+	client   *http.Client
+	ptzURL   string
+	mediaURL string
+	username string
+	password string
+
+	mu           sync.Mutex
+	profileToken string
+}
+
+// NewONVIFDevice はカメラの接続情報からONVIFの接続を作成します。
+func NewONVIFDevice(client *http.Client, connection *protov1.CameraConnection) (*ONVIFDevice, error) {
+	base, err := onvifBaseURL(connection)
+	if err != nil {
+		return nil, err
+	}
+
+	parameters := connection.GetParameters()
+
+	return &ONVIFDevice{
+		client:       client,
+		ptzURL:       base + onvifPath(parameters[ConnectionParameterONVIFPTZPath], defaultONVIFPTZPath),
+		mediaURL:     base + onvifPath(parameters[ConnectionParameterONVIFMediaPath], defaultONVIFMediaPath),
+		username:     connection.GetCredentials().GetUsername(),
+		password:     connection.GetCredentials().GetPassword(),
+		mu:           sync.Mutex{},
+		profileToken: parameters[ConnectionParameterONVIFProfileToken],
+	}, nil
+}
+
+// onvifBaseURL は Address（ホスト名、または http:// から始まるURL）と Port から接続先を作ります。
+func onvifBaseURL(connection *protov1.CameraConnection) (string, error) {
+	address := strings.TrimSpace(connection.GetAddress())
+	if address == "" {
+		return "", fmt.Errorf("%w: address is required", ErrInvalidONVIFConnection)
+	}
+
+	if !strings.Contains(address, "://") {
+		address = "http://" + address
+	}
+
+	parsed, err := url.Parse(address)
+	if err != nil || parsed.Host == "" {
+		return "", fmt.Errorf("%w: address %q", ErrInvalidONVIFConnection, connection.GetAddress())
+	}
+
+	if port := connection.GetPort(); port != 0 && parsed.Port() == "" {
+		parsed.Host = net.JoinHostPort(parsed.Hostname(), strconv.FormatUint(uint64(port), 10))
+	}
+
+	return parsed.Scheme + "://" + parsed.Host, nil
+}
+
+func onvifPath(path, fallback string) string {
+	if path == "" {
+		path = fallback
+	}
+
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+
+	return path
+}
+
+// PTZURL はPTZサービスのURLを返します。
+func (d *ONVIFDevice) PTZURL() string {
+	return d.ptzURL
+}
+
+// ProfileToken は制御するプロファイルのトークンを返します。
+// 接続情報で指定されていない場合は、最初の呼び出しでメディアサービスの GetProfiles から取得します。
+func (d *ONVIFDevice) ProfileToken(ctx context.Context) (string, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.profileToken != "" {
+		return d.profileToken, nil
+	}
+
+	var response struct {
+		Profiles []struct {
+			Token string `xml:"token,attr"`
+		} `xml:"Body>GetProfilesResponse>Profiles"`
+	}
+
+	body := `<trt:GetProfiles xmlns:trt="` + onvifMediaNamespace + `"/>`
+	if err := d.call(ctx, d.mediaURL, onvifMediaNamespace, "GetProfiles", body, &response); err != nil {
+		return "", err
+	}
+
+	for _, profile := range response.Profiles {
+		if profile.Token != "" {
+			d.profileToken = profile.Token
+
+			return d.profileToken, nil
+		}
+	}
+
+	return "", ErrONVIFNoProfile
+}
+
+// ExecuteControlCommand は制御コマンドをPTZサービスの操作として送ります。
+// 移動の操作はカメラが受け付けた時点で返るため、移動の完了は GetStatus で確認します。
+// プリセットは番号をそのままプリセットのトークンとして扱います。
+func (d *ONVIFDevice) ExecuteControlCommand(
+	ctx context.Context,
+	optics *CameraOptics,
+	command *protov1.ControlCommand,
+) error {
+	token, err := d.ProfileToken(ctx)
+	if err != nil {
+		return err
+	}
+
+	params := command.GetPtzParameters()
+	profile := onvifElement("ProfileToken", token)
+
+	switch command.GetType() { //nolint:exhaustive
+	case protov1.ControlCommandType_CONTROL_COMMAND_TYPE_PTZ_STOP:
+		return d.callPTZ(ctx, "Stop", profile+onvifElement("PanTilt", "true")+onvifElement("Zoom", "true"))
+	case protov1.ControlCommandType_CONTROL_COMMAND_TYPE_PRESET_GOTO:
+		speed := &protov1.PTZSpeed{
+			PanSpeed:  float32(clamp(float64(params.GetPanSpeed()), 0, 1)),
+			TiltSpeed: float32(clamp(float64(params.GetTiltSpeed()), 0, 1)),
+			ZoomSpeed: float32(clamp(float64(params.GetZoomSpeed()), 0, 1)),
+		}
+
+		return d.callPTZ(ctx, "GotoPreset",
+			profile+onvifElement("PresetToken", onvifPresetToken(command))+onvifSpeed(speed))
+	case protov1.ControlCommandType_CONTROL_COMMAND_TYPE_PRESET_SET:
+		return d.callPTZ(ctx, "SetPreset", profile+
+			onvifElement("PresetName", "preset-"+onvifPresetToken(command))+
+			onvifElement("PresetToken", onvifPresetToken(command)))
+	}
+
+	ptz := optics.PTZCommandFromControl(command)
+
+	switch {
+	case ptz.GetAbsoluteMove() != nil:
+		position := ptz.GetAbsoluteMove().GetPosition()
+
+		return d.callPTZ(ctx, "AbsoluteMove", profile+
+			onvifVector("Position", position.GetX(), position.GetY(), position.GetZ())+
+			onvifSpeed(ptz.GetAbsoluteMove().GetSpeed()))
+	case ptz.GetRelativeMove() != nil:
+		translation := ptz.GetRelativeMove().GetTranslation()
+
+		return d.callPTZ(ctx, "RelativeMove", profile+
+			onvifVector("Translation", translation.GetPanDelta(), translation.GetTiltDelta(), translation.GetZoomDelta())+
+			onvifSpeed(ptz.GetRelativeMove().GetSpeed()))
+	case ptz.GetContinuousMove() != nil:
+		velocity := ptz.GetContinuousMove().GetVelocity()
+		body := profile + onvifVector(
+			"Velocity", velocity.GetPanVelocity(), velocity.GetTiltVelocity(), velocity.GetZoomVelocity(),
+		)
+
+		if timeoutMs := ptz.GetContinuousMove().GetTimeoutMs(); timeoutMs > 0 {
+			body += onvifElement("Timeout", "PT"+strconv.FormatFloat(float64(timeoutMs)/millisPerSecond, 'f', -1, 64)+"S")
+		}
+
+		return d.callPTZ(ctx, "ContinuousMove", body)
+	default:
+		return fmt.Errorf("%w: %s", ErrONVIFUnsupportedCommand, command.GetType())
+	}
+}
+
+// GetStatus はPTZの位置と移動状態を取得します。
+func (d *ONVIFDevice) GetStatus(ctx context.Context) (*ONVIFStatus, error) {
+	token, err := d.ProfileToken(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var response struct {
+		Status struct {
+			PanTilt struct {
+				X float32 `xml:"x,attr"`
+				Y float32 `xml:"y,attr"`
+			} `xml:"Position>PanTilt"`
+			Zoom struct {
+				X float32 `xml:"x,attr"`
+			} `xml:"Position>Zoom"`
+			MoveStatus struct {
+				PanTilt string `xml:"PanTilt"`
+				Zoom    string `xml:"Zoom"`
+			} `xml:"MoveStatus"`
+			Error string `xml:"Error"`
+		} `xml:"Body>GetStatusResponse>PTZStatus"`
+	}
+
+	body := `<tptz:GetStatus xmlns:tptz="` + onvifPTZNamespace + `">` + onvifElement("ProfileToken", token) +
+		`</tptz:GetStatus>`
+	if err := d.call(ctx, d.ptzURL, onvifPTZNamespace, "GetStatus", body, &response); err != nil {
+		return nil, err
+	}
+
+	status := response.Status
+	errorMessage := strings.TrimSpace(status.Error)
+
+	if strings.EqualFold(errorMessage, onvifNoError) {
+		errorMessage = ""
+	}
+
+	return &ONVIFStatus{
+		Position: &protov1.PTZPosition{X: status.PanTilt.X, Y: status.PanTilt.Y, Z: status.Zoom.X},
+		Moving: strings.EqualFold(status.MoveStatus.PanTilt, onvifMoveStatusMoving) ||
+			strings.EqualFold(status.MoveStatus.Zoom, onvifMoveStatusMoving),
+		Error: errorMessage,
+	}, nil
+}
+
+func (d *ONVIFDevice) callPTZ(ctx context.Context, action, elements string) error {
+	body := `<tptz:` + action + ` xmlns:tptz="` + onvifPTZNamespace + `" xmlns:tt="` + onvifSchemaNamespace + `">` +
+		elements + `</tptz:` + action + `>`
+
+	return d.call(ctx, d.ptzURL, onvifPTZNamespace, action, body, nil)
+}
+
+// call はSOAPリクエストを送り、レスポンスを response に読み込みます。
+// SOAPのエラーは HTTP のステータスに関わらず ErrONVIFFault として返します。
+func (d *ONVIFDevice) call(ctx context.Context, endpoint, namespace, action, body string, response any) error {
+	header, err := d.securityHeader()
+	if err != nil {
+		return err
+	}
+
+	envelope := `<?xml version="1.0" encoding="UTF-8"?><s:Envelope xmlns:s="` + soapEnvelopeNamespace + `">` +
+		header + `<s:Body>` + body + `</s:Body></s:Envelope>`
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, strings.NewReader(envelope))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", `application/soap+xml; charset=utf-8; action="`+namespace+"/"+action+`"`)
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("onvif %s: %w", action, err)
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, onvifMaxResponseBytes))
+	if err != nil {
+		return fmt.Errorf("onvif %s: %w", action, err)
+	}
+
+	var fault struct {
+		Fault *struct {
+			Reason      string `xml:"Reason>Text"`
+			FaultString string `xml:"faultstring"`
+		} `xml:"Body>Fault"`
+	}
+
+	if xml.Unmarshal(data, &fault) == nil && fault.Fault != nil {
+		reason := strings.TrimSpace(fault.Fault.Reason + fault.Fault.FaultString)
+
+		return fmt.Errorf("%w: %s: %s", ErrONVIFFault, action, reason)
+	}
+
+	if resp.StatusCode < http.StatusOK || resp.StatusCode >= http.StatusMultipleChoices {
+		return fmt.Errorf("onvif %s: unexpected status %s", action, resp.Status)
+	}
+
+	if response == nil {
+		return nil
+	}
+
+	if err := xml.NewDecoder(bytes.NewReader(data)).Decode(response); err != nil {
+		return fmt.Errorf("onvif %s: %w", action, err)
+	}
+
+	return nil
+}
+
+// securityHeader は WS-Security の UsernameToken のヘッダーを作成します。ユーザー名がない場合は空です。
+func (d *ONVIFDevice) securityHeader() (string, error) {
+	if d.username == "" {
+		return "", nil
+	}
+
+	nonce := make([]byte, onvifNonceSize)
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+
+	created := time.Now().UTC().Format("2006-01-02T15:04:05.000Z")
+
+	return `<s:Header><wsse:Security s:mustUnderstand="1" xmlns:wsse="` + wsseNamespace +
+		`" xmlns:wsu="` + wsuNamespace + `"><wsse:UsernameToken>` +
+		`<wsse:Username>` + xmlText(d.username) + `</wsse:Username>` +
+		`<wsse:Password Type="` + wssePasswordDigestType + `">` +
+		ONVIFPasswordDigest(nonce, created, d.password) + `</wsse:Password>` +
+		`<wsse:Nonce EncodingType="` + wsseBase64EncodingType + `">` +
+		base64.StdEncoding.EncodeToString(nonce) + `</wsse:Nonce>` +
+		`<wsu:Created>` + created + `</wsu:Created>` +
+		`</wsse:UsernameToken></wsse:Security></s:Header>`, nil
+}
+
+// ONVIFPasswordDigest は UsernameToken の PasswordDigest（Base64(SHA-1(nonce + created + password))）を返します。
+func ONVIFPasswordDigest(nonce []byte, created, password string) string {
+	digest := sha1.Sum(append(append(append([]byte{}, nonce...), created...), password...)) //nolint:gosec
+
+	return base64.StdEncoding.EncodeToString(digest[:])
+}
+
+func onvifPresetToken(command *protov1.ControlCommand) string {
+	return strconv.FormatUint(uint64(command.GetPresetNumber()), 10)
+}
+
+func onvifElement(name, value string) string {
+	return `<tptz:` + name + `>` + xmlText(value) + `</tptz:` + name + `>`
+}
+
+// onvifVector はパン・チルトとズームの組を PTZVector の要素として書き出します。
+func onvifVector(name string, x, y, z float32) string {
+	return `<tptz:` + name + `><tt:PanTilt x="` + onvifNumber(x) + `" y="` + onvifNumber(y) + `"/>` +
+		`<tt:Zoom x="` + onvifNumber(z) + `"/></tptz:` + name + `>`
+}
+
+// onvifSpeed は速度の要素を書き出します。全ての速度が 0 の場合はカメラの既定の速度を使うため省略します。
+func onvifSpeed(speed *protov1.PTZSpeed) string {
+	if speed.GetPanSpeed() == 0 && speed.GetTiltSpeed() == 0 && speed.GetZoomSpeed() == 0 {
+		return ""
+	}
+
+	return onvifVector("Speed", speed.GetPanSpeed(), speed.GetTiltSpeed(), speed.GetZoomSpeed())
+}
+
+func onvifNumber(value float32) string {
+	return strconv.FormatFloat(float64(value), 'f', -1, 32)
+}
+
+func xmlText(value string) string {
+	var builder strings.Builder
+
+	_ = xml.EscapeText(&builder, []byte(value))
+
+	return builder.String()
+}
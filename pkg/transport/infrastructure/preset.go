@@ -0,0 +1,219 @@
+package infrastructure
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+
+	protov1 "github.com/anyfld/vistra-operation-control-room/gen/proto/v1"
+)
+
+var ErrPresetNotFound = errors.New("preset not found")
+
+// Preset はカメラごとに保存したPTZとフォーカスの組です。
+type Preset struct {
+	CameraID    string
+	Number      uint32
+	Name        string
+	PTZ         *protov1.PTZParameters
+	Focus       float32
+	UpdatedAtMs int64
+}
+
+func (p *Preset) clone() *Preset {
+	cloned := *p
+	if ptz, ok := proto.Clone(p.PTZ).(*protov1.PTZParameters); ok {
+		cloned.PTZ = ptz
+	}
+
+	return &cloned
+}
+
+// PresetRepo はサーバー側で保持するカメラのプリセットを管理します。
+// ハードウェアのプリセットを持たないFDでも、保存したPTZへの移動でプリセットを再現できます。
+type PresetRepo struct {
+	mu       sync.RWMutex
+	presets  map[string]map[uint32]*Preset
+	onChange atomic.Pointer[func()]
+}
+
+func NewPresetRepo() *PresetRepo {
+	return &PresetRepo{
+		mu:       sync.RWMutex{},
+		presets:  make(map[string]map[uint32]*Preset),
+		onChange: atomic.Pointer[func()]{},
+	}
+}
+
+// SavePreset はプリセットを保存します。同じ番号のプリセットは上書きします。
+func (r *PresetRepo) SavePreset(preset *Preset) *Preset {
+	r.mu.Lock()
+	defer r.notifyChange()
+	defer r.mu.Unlock()
+
+	saved := preset.clone()
+	saved.UpdatedAtMs = time.Now().UnixMilli()
+
+	cameraPresets, ok := r.presets[saved.CameraID]
+	if !ok {
+		cameraPresets = make(map[uint32]*Preset)
+		r.presets[saved.CameraID] = cameraPresets
+	}
+
+	cameraPresets[saved.Number] = saved
+
+	return saved.clone()
+}
+
+func (r *PresetRepo) GetPreset(cameraID string, number uint32) *Preset {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	preset, ok := r.presets[cameraID][number]
+	if !ok {
+		return nil
+	}
+
+	return preset.clone()
+}
+
+// ListPresets はカメラのプリセットを番号順に返します。
+func (r *PresetRepo) ListPresets(cameraID string) []*Preset {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	presets := make([]*Preset, 0, len(r.presets[cameraID]))
+	for _, preset := range r.presets[cameraID] {
+		presets = append(presets, preset.clone())
+	}
+
+	slices.SortFunc(presets, func(a, b *Preset) int {
+		return int(a.Number) - int(b.Number)
+	})
+
+	return presets
+}
+
+func (r *PresetRepo) DeletePreset(cameraID string, number uint32) bool {
+	r.mu.Lock()
+
+	_, ok := r.presets[cameraID][number]
+	if ok {
+		delete(r.presets[cameraID], number)
+
+		if len(r.presets[cameraID]) == 0 {
+			delete(r.presets, cameraID)
+		}
+	}
+
+	r.mu.Unlock()
+
+	if ok {
+		r.notifyChange()
+	}
+
+	return ok
+}
+
+// presetSnapshot は共有ストアに保存するプリセットです。
+type presetSnapshot struct {
+	CameraID    string          `json:"camera_id"`
+	Number      uint32          `json:"number"`
+	Name        string          `json:"name"`
+	PTZ         json.RawMessage `json:"ptz,omitempty"`
+	Focus       float32         `json:"focus"`
+	UpdatedAtMs int64           `json:"updated_at_ms"`
+}
+
+// SetChangeHook はプリセットが変化したときに呼び出される関数を設定します。
+func (r *PresetRepo) SetChangeHook(hook func()) {
+	r.onChange.Store(&hook)
+}
+
+// Snapshot は全カメラのプリセットをシリアライズします。
+func (r *PresetRepo) Snapshot() ([]byte, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	snapshots := make([]*presetSnapshot, 0)
+
+	for _, cameraPresets := range r.presets {
+		for _, preset := range cameraPresets {
+			snapshot := &presetSnapshot{
+				CameraID:    preset.CameraID,
+				Number:      preset.Number,
+				Name:        preset.Name,
+				PTZ:         nil,
+				Focus:       preset.Focus,
+				UpdatedAtMs: preset.UpdatedAtMs,
+			}
+
+			if preset.PTZ != nil {
+				data, err := protojson.Marshal(preset.PTZ)
+				if err != nil {
+					return nil, fmt.Errorf("encode preset %s/%d: %w", preset.CameraID, preset.Number, err)
+				}
+
+				snapshot.PTZ = data
+			}
+
+			snapshots = append(snapshots, snapshot)
+		}
+	}
+
+	return json.Marshal(snapshots)
+}
+
+// Restore は Snapshot で保存したプリセットで現在の状態を置き換えます。
+func (r *PresetRepo) Restore(data []byte) error {
+	var snapshots []*presetSnapshot
+	if err := json.Unmarshal(data, &snapshots); err != nil {
+		return fmt.Errorf("decode preset snapshot: %w", err)
+	}
+
+	presets := make(map[string]map[uint32]*Preset)
+
+	for _, snapshot := range snapshots {
+		preset := &Preset{
+			CameraID:    snapshot.CameraID,
+			Number:      snapshot.Number,
+			Name:        snapshot.Name,
+			PTZ:         nil,
+			Focus:       snapshot.Focus,
+			UpdatedAtMs: snapshot.UpdatedAtMs,
+		}
+
+		if len(snapshot.PTZ) > 0 {
+			preset.PTZ = new(protov1.PTZParameters)
+			if err := protojson.Unmarshal(snapshot.PTZ, preset.PTZ); err != nil {
+				return fmt.Errorf("decode preset %s/%d: %w", snapshot.CameraID, snapshot.Number, err)
+			}
+		}
+
+		if presets[preset.CameraID] == nil {
+			presets[preset.CameraID] = make(map[uint32]*Preset)
+		}
+
+		presets[preset.CameraID][preset.Number] = preset
+	}
+
+	r.mu.Lock()
+	r.presets = presets
+	r.mu.Unlock()
+
+	return nil
+}
+
+// notifyChange は変更フックを呼び出します。
+func (r *PresetRepo) notifyChange() {
+	if hook := r.onChange.Load(); hook != nil {
+		(*hook)()
+	}
+}
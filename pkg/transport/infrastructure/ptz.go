@@ -1,11 +1,17 @@
 package infrastructure
 
 import (
+	"encoding/json"
 	"fmt"
+	"maps"
 	"math"
+	"slices"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"google.golang.org/protobuf/encoding/protojson"
+
 	protov1 "github.com/anyfld/vistra-operation-control-room/gen/proto/v1"
 )
 
@@ -19,17 +25,63 @@ type CameraQueue struct {
 	Interrupt       bool
 }
 
+// TaskOutcome はタスクが終了した理由です。
+type TaskOutcome string
+
+const (
+	// TaskOutcomeCompleted はFDが完了を報告した、またはサーバーが完了させた終了です。
+	TaskOutcomeCompleted TaskOutcome = "completed"
+	// TaskOutcomePreempted は上位の命令（PTZ命令や停止の命令）でキューから破棄・中断された終了です。
+	TaskOutcomePreempted TaskOutcome = "preempted"
+	// TaskOutcomeReplaced は追尾の新しい命令に置き換えられた終了です。
+	TaskOutcomeReplaced TaskOutcome = "replaced"
+	// TaskOutcomeFailed はFDが失敗を報告した終了です。
+	TaskOutcomeFailed TaskOutcome = "failed"
+	// TaskOutcomeTimedOut はFDが時間内に結果を報告しなかった終了です。
+	TaskOutcomeTimedOut TaskOutcome = "timed_out"
+)
+
+// TaskEvent はタスクの完了・中断を通知するイベントです。
+type TaskEvent struct {
+	CameraID    string
+	Task        *protov1.Task
+	Outcome     TaskOutcome
+	CurrentPTZ  *protov1.PTZParameters
+	TimestampMs int64
+}
+
 // PTZRepo はPTZサービスのキュー管理を行うリポジトリです。
 type PTZRepo struct {
-	mu           sync.RWMutex
-	cameraQueues map[string]*CameraQueue
+	mu              sync.RWMutex
+	cameraQueues    map[string]*CameraQueue
+	armTrajectories map[string]*ArmTrajectory
+	// focusMoves はタスクと共に実行するフォーカスの移動です。
+	focusMoves map[string]*FocusMove
+	// ptzTrajectories はキーフレームに分割したPTZの軌道です。
+	ptzTrajectories map[string]*PTZTrajectory
+	// controlCommandTasks は FDService の制御コマンドから作成したタスクの元の制御コマンドです。
+	controlCommandTasks map[string]*protov1.ControlCommand
+	taskSubscribers     []*mailbox[*TaskEvent]
+	taskSubscribersMu   sync.RWMutex
+	onChange            atomic.Pointer[func()]
+	onProgress          atomic.Pointer[func()]
+	events              *EventBus
 }
 
 // NewPTZRepo は新しいPTZRepoを作成します。
-func NewPTZRepo() *PTZRepo {
+func NewPTZRepo(events *EventBus) *PTZRepo {
 	return &PTZRepo{
-		mu:           sync.RWMutex{},
-		cameraQueues: make(map[string]*CameraQueue),
+		mu:                  sync.RWMutex{},
+		cameraQueues:        make(map[string]*CameraQueue),
+		armTrajectories:     make(map[string]*ArmTrajectory),
+		focusMoves:          make(map[string]*FocusMove),
+		ptzTrajectories:     make(map[string]*PTZTrajectory),
+		controlCommandTasks: make(map[string]*protov1.ControlCommand),
+		taskSubscribers:     make([]*mailbox[*TaskEvent], 0),
+		taskSubscribersMu:   sync.RWMutex{},
+		onChange:            atomic.Pointer[func()]{},
+		onProgress:          atomic.Pointer[func()]{},
+		events:              events,
 	}
 }
 
@@ -39,9 +91,18 @@ func NewPTZRepo() *PTZRepo {
 func (r *PTZRepo) EnqueuePTZCommand(
 	cameraID string,
 	command *protov1.PTZCommand,
+) (string, bool) {
+	return r.EnqueuePTZCommandWithFocus(cameraID, command, nil)
+}
+
+// EnqueuePTZCommandWithFocus は EnqueuePTZCommand と同様にPTZ命令をキューに追加し、
+// タスクが実行対象になったときに行うフォーカスの移動を設定します。focus が nil の場合はフォーカスを動かしません。
+func (r *PTZRepo) EnqueuePTZCommandWithFocus(
+	cameraID string,
+	command *protov1.PTZCommand,
+	focus *FocusMove,
 ) (string, bool) {
 	r.mu.Lock()
-	defer r.mu.Unlock()
 
 	queue := r.getOrCreateCameraQueue(cameraID)
 
@@ -56,19 +117,45 @@ func (r *PTZRepo) EnqueuePTZCommand(
 		CreatedAtMs:      time.Now().UnixMilli(),
 	}
 
-	// シネマティック枠を全クリア
+	events := r.preemptCinematicQueue(queue)
+
+	// PTZキューに追加
+	queue.PTZQueue = append(queue.PTZQueue, task)
+
+	if focus != nil {
+		r.focusMoves[taskID] = focus
+	}
+
+	r.publishTaskEvent(EventTaskEnqueued, cameraID, task)
+	r.startNextTask(queue)
+
+	r.mu.Unlock()
+
+	r.publishTaskEvents(events)
+	r.notifyChange()
+
+	return taskID, true
+}
+
+// preemptCinematicQueue はシネマティック枠を全クリアし、破棄したタスクを中断として通知します。
+// 実行中のタスクがシネマティックの場合は中断フラグを設定します。r.mu を保持して呼び出します。
+func (r *PTZRepo) preemptCinematicQueue(queue *CameraQueue) []*TaskEvent {
+	events := make([]*TaskEvent, 0, len(queue.CinematicQueue))
+	for _, cleared := range queue.CinematicQueue {
+		cleared.Status = protov1.TaskStatus_TASK_STATUS_INTERRUPTED
+		events = append(events, newTaskEvent(queue.CameraID, cleared, TaskOutcomePreempted, nil))
+		r.publishTaskEvent(EventTaskInterrupted, queue.CameraID, cleared)
+		r.forgetTask(cleared.GetTaskId())
+	}
+
 	queue.CinematicQueue = make([]*protov1.Task, 0)
 
-	// 実行中のタスクがシネマティックの場合、中断フラグを設定
 	if queue.ExecutingTask != nil &&
 		queue.ExecutingTask.GetLayer() == protov1.CommandLayer_COMMAND_LAYER_CINEMATIC {
 		queue.Interrupt = true
 	}
 
-	// PTZキューに追加
-	queue.PTZQueue = append(queue.PTZQueue, task)
-
-	return taskID, true
+	return events
 }
 
 // EnqueueCinematicCommand はシネマティック命令をキューに追加します。
@@ -78,6 +165,7 @@ func (r *PTZRepo) EnqueueCinematicCommand(
 	command *protov1.CinematographyInstruction,
 ) (string, bool) {
 	r.mu.Lock()
+	defer r.notifyChange()
 	defer r.mu.Unlock()
 
 	queue := r.getOrCreateCameraQueue(cameraID)
@@ -96,11 +184,191 @@ func (r *PTZRepo) EnqueueCinematicCommand(
 	// シネマティックキューに追加
 	queue.CinematicQueue = append(queue.CinematicQueue, task)
 
+	r.publishTaskEvent(EventTaskEnqueued, cameraID, task)
+	r.startNextTask(queue)
+
 	return taskID, true
 }
 
+// EnqueueTrackingCommand は追尾のPTZ命令をシネマティック枠（Layer 2）に追加します。
+// 手動のPTZ命令（Layer 1）が届くと他のシネマティック命令と同様に破棄・中断されます。
+// 追尾の命令は常に最新の1件だけが有効なため、未完了の追尾命令は置き換え、
+// 実行中であれば中断フラグを設定して新しい命令へ移行させます。
+func (r *PTZRepo) EnqueueTrackingCommand(
+	cameraID string,
+	command *protov1.PTZCommand,
+) (string, bool) {
+	r.mu.Lock()
+
+	queue := r.getOrCreateCameraQueue(cameraID)
+
+	taskID := fmt.Sprintf("track-task-%d", time.Now().UnixNano())
+	task := &protov1.Task{
+		TaskId:           taskID,
+		Layer:            protov1.CommandLayer_COMMAND_LAYER_CINEMATIC,
+		Status:           protov1.TaskStatus_TASK_STATUS_PENDING,
+		PtzCommand:       command,
+		CinematicCommand: nil,
+		Interrupt:        false,
+		CreatedAtMs:      time.Now().UnixMilli(),
+	}
+
+	events := make([]*TaskEvent, 0, 1)
+	queue.CinematicQueue = slices.DeleteFunc(queue.CinematicQueue, func(pending *protov1.Task) bool {
+		if !isTrackingTask(pending) {
+			return false
+		}
+
+		pending.Status = protov1.TaskStatus_TASK_STATUS_INTERRUPTED
+		events = append(events, newTaskEvent(cameraID, pending, TaskOutcomeReplaced, nil))
+
+		return true
+	})
+
+	if isTrackingTask(queue.ExecutingTask) {
+		queue.Interrupt = true
+	}
+
+	queue.CinematicQueue = append(queue.CinematicQueue, task)
+
+	for _, event := range events {
+		r.publishTaskEvent(EventTaskInterrupted, cameraID, event.Task)
+	}
+
+	r.publishTaskEvent(EventTaskEnqueued, cameraID, task)
+	r.startNextTask(queue)
+
+	r.mu.Unlock()
+
+	r.publishTaskEvents(events)
+	r.notifyChange()
+
+	return taskID, true
+}
+
+// EnqueueArmCommand はアームの軌道をタスクとしてキューに追加します。
+// layer が COMMAND_LAYER_PTZ の場合は EnqueuePTZCommand と同様にシネマティック枠を破棄して中断させ、
+// それ以外の場合はシネマティック枠に追加します。
+// タスクは PTZ命令もシネマティック命令も持たず、実行対象になると軌道のウェイポイントが
+// TaskID を持つARMの制御コマンドとしてFDに配信されます。FDは軌道の完了後にポーリングで TaskID を完了として報告します。
+func (r *PTZRepo) EnqueueArmCommand(
+	cameraID string,
+	layer protov1.CommandLayer,
+	trajectory *ArmTrajectory,
+) (string, bool) {
+	return r.enqueueDeviceTask(cameraID, layer, "arm-task", func(taskID string) {
+		r.armTrajectories[taskID] = trajectory
+	})
+}
+
+// EnqueueFocusCommand はフォーカスの移動だけを行うタスク（ラックフォーカス）をキューに追加します。
+// layer の扱いは EnqueueArmCommand と同じです。タスクは PTZ命令もシネマティック命令も持たず、
+// 実行対象になるとフォーカスの移動が TaskID を持つFOCUSの制御コマンドとしてFDに配信され、
+// 最後のステップの到達予定時刻に完了します。
+func (r *PTZRepo) EnqueueFocusCommand(
+	cameraID string,
+	layer protov1.CommandLayer,
+	focus *FocusMove,
+) (string, bool) {
+	return r.enqueueDeviceTask(cameraID, layer, "focus-task", func(taskID string) {
+		r.focusMoves[taskID] = focus
+	})
+}
+
+// EnqueueTrajectoryCommand はキーフレームに分割したPTZの軌道をタスクとしてキューに追加します。
+// layer の扱いは EnqueueArmCommand と同じです。タスクは PTZ命令もシネマティック命令も持たず、
+// 実行対象になるとキーフレームが TaskID を持つ絶対移動または相対移動の制御コマンドとしてFDに配信され、
+// 最後のキーフレームの到達予定時刻に完了します。
+func (r *PTZRepo) EnqueueTrajectoryCommand(
+	cameraID string,
+	layer protov1.CommandLayer,
+	trajectory *PTZTrajectory,
+) (string, bool) {
+	return r.enqueueDeviceTask(cameraID, layer, "trajectory-task", func(taskID string) {
+		r.ptzTrajectories[taskID] = trajectory
+	})
+}
+
+// enqueueDeviceTask は PTZ命令もシネマティック命令も持たないタスクをキューに追加します。
+// attach はロックを保持したまま呼び出され、タスクの内容をタスクIDに関連付けます。
+func (r *PTZRepo) enqueueDeviceTask(
+	cameraID string,
+	layer protov1.CommandLayer,
+	prefix string,
+	attach func(taskID string),
+) (string, bool) {
+	r.mu.Lock()
+
+	queue := r.getOrCreateCameraQueue(cameraID)
+
+	if layer != protov1.CommandLayer_COMMAND_LAYER_PTZ {
+		layer = protov1.CommandLayer_COMMAND_LAYER_CINEMATIC
+	}
+
+	taskID := fmt.Sprintf("%s-%d", prefix, time.Now().UnixNano())
+	task := &protov1.Task{
+		TaskId:           taskID,
+		Layer:            layer,
+		Status:           protov1.TaskStatus_TASK_STATUS_PENDING,
+		PtzCommand:       nil,
+		CinematicCommand: nil,
+		Interrupt:        false,
+		CreatedAtMs:      time.Now().UnixMilli(),
+	}
+
+	var events []*TaskEvent
+
+	if layer == protov1.CommandLayer_COMMAND_LAYER_PTZ {
+		events = r.preemptCinematicQueue(queue)
+		queue.PTZQueue = append(queue.PTZQueue, task)
+	} else {
+		queue.CinematicQueue = append(queue.CinematicQueue, task)
+	}
+
+	attach(taskID)
+
+	r.publishTaskEvent(EventTaskEnqueued, cameraID, task)
+	r.startNextTask(queue)
+
+	r.mu.Unlock()
+
+	r.publishTaskEvents(events)
+	r.notifyChange()
+
+	return taskID, true
+}
+
+// GetArmTrajectory はアームのタスクの軌道を返します。アームのタスクでない場合は nil を返します。
+func (r *PTZRepo) GetArmTrajectory(taskID string) *ArmTrajectory {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.armTrajectories[taskID]
+}
+
+// GetFocusMove はタスクと共に実行するフォーカスの移動を返します。設定されていない場合は nil を返します。
+func (r *PTZRepo) GetFocusMove(taskID string) *FocusMove {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.focusMoves[taskID]
+}
+
+// GetPTZTrajectory はPTZの軌道のタスクの軌道を返します。軌道のタスクでない場合は nil を返します。
+func (r *PTZRepo) GetPTZTrajectory(taskID string) *PTZTrajectory {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.ptzTrajectories[taskID]
+}
+
+// isTrackingTask は追尾のタスク（PTZ命令を持つシネマティック枠のタスク）かどうかを返します。
+func isTrackingTask(task *protov1.Task) bool {
+	return task.GetLayer() == protov1.CommandLayer_COMMAND_LAYER_CINEMATIC && task.GetPtzCommand() != nil
+}
+
 // ProcessPolling はFDからのポーリングを処理し、次の命令を返します。
-// completedTaskIdが設定されている場合、該当タスクをデキューします。
+// completedTaskIdが設定されている場合、該当タスクをデキューし、キューの次のタスクを実行対象にします。
 func (r *PTZRepo) ProcessPolling(
 	cameraID string,
 	completedTaskID string,
@@ -110,34 +378,81 @@ func (r *PTZRepo) ProcessPolling(
 	cameraStatus protov1.CameraStatus,
 ) (*protov1.Task, *protov1.Task, bool) {
 	r.mu.Lock()
-	defer r.mu.Unlock()
 
 	queue := r.getOrCreateCameraQueue(cameraID)
 	queue.LastPollingAtMs = time.Now().UnixMilli()
 
 	// 完了タスクの処理
+	var completed *protov1.Task
+
 	if completedTaskID != "" {
-		r.dequeueCompletedPTZTask(queue, completedTaskID)
-		r.dequeueCompletedCinematicTask(queue, completedTaskID)
+		if task := r.dequeueCompletedPTZTask(queue, completedTaskID); task != nil {
+			completed = task
+		}
+
+		if task := r.dequeueCompletedCinematicTask(queue, completedTaskID); task != nil {
+			completed = task
+		}
+
 		r.clearExecutingTaskIfCompleted(queue, completedTaskID)
+
+		if completed != nil {
+			r.publishTaskEvent(EventTaskCompleted, cameraID, completed)
+		}
+
+		r.forgetTask(completedTaskID)
 	}
 
 	// 中断フラグを取得してリセット
 	interrupt := queue.Interrupt
 	queue.Interrupt = false
 
-	// 次のタスクを取得
+	// 現在の実行タスクを更新し、次のタスクを取得
+	executingChanged := r.startNextTask(queue)
 	currentCommand, nextCommand := r.getNextTasks(queue)
 
-	// 現在の実行タスクを更新
-	if currentCommand != nil {
-		queue.ExecutingTask = currentCommand
-		currentCommand.Status = protov1.TaskStatus_TASK_STATUS_EXECUTING
+	r.mu.Unlock()
+
+	if completed != nil {
+		r.publishTaskEvents([]*TaskEvent{newTaskEvent(cameraID, completed, TaskOutcomeCompleted, currentPTZ)})
+	}
+
+	// ポーリングのたびに保存しないよう、キューが変化した場合のみ通知する
+	if completed != nil || executingChanged || interrupt {
+		r.notifyProgress()
 	}
 
 	return currentCommand, nextCommand, interrupt
 }
 
+// SubscribeTaskEvents はタスクの完了・中断イベントを購読します。
+// イベントは受信が遅れても破棄せず、購読を解除するまで保持します。
+func (r *PTZRepo) SubscribeTaskEvents() <-chan *TaskEvent {
+	subscriber := newMailbox[*TaskEvent]()
+
+	r.taskSubscribersMu.Lock()
+	r.taskSubscribers = append(r.taskSubscribers, subscriber)
+	r.taskSubscribersMu.Unlock()
+
+	return subscriber.ch
+}
+
+// UnsubscribeTaskEvents はタスクイベントの購読を解除します。
+func (r *PTZRepo) UnsubscribeTaskEvents(eventCh <-chan *TaskEvent) {
+	r.taskSubscribersMu.Lock()
+	defer r.taskSubscribersMu.Unlock()
+
+	for i, subscriber := range r.taskSubscribers {
+		if subscriber.ch == eventCh {
+			r.taskSubscribers = append(r.taskSubscribers[:i], r.taskSubscribers[i+1:]...)
+
+			subscriber.close()
+
+			return
+		}
+	}
+}
+
 // GetQueueStatus はカメラのキュー状態を取得します。
 func (r *PTZRepo) GetQueueStatus(cameraID string) *protov1.CameraQueueStatus {
 	r.mu.RLock()
@@ -183,6 +498,180 @@ func (r *PTZRepo) GetAllQueueStatuses() []*protov1.CameraQueueStatus {
 	return statuses
 }
 
+// ptzQueueSnapshot は共有ストアに保存するカメラキューの状態です。
+type ptzQueueSnapshot struct {
+	CameraID        string            `json:"camera_id"`
+	PTZQueue        []json.RawMessage `json:"ptz_queue"`
+	CinematicQueue  []json.RawMessage `json:"cinematic_queue"`
+	ExecutingTaskID string            `json:"executing_task_id,omitempty"`
+	LastPollingAtMs int64             `json:"last_polling_at_ms"`
+	Interrupt       bool              `json:"interrupt"`
+	// ArmTrajectories はキュー内のアームのタスクの軌道です。
+	ArmTrajectories map[string]*ArmTrajectory `json:"arm_trajectories,omitempty"`
+	// FocusMoves はキュー内のタスクと共に実行するフォーカスの移動です。
+	FocusMoves map[string]*FocusMove `json:"focus_moves,omitempty"`
+	// PTZTrajectories はキュー内のPTZの軌道のタスクの軌道です。
+	PTZTrajectories map[string]*PTZTrajectory `json:"ptz_trajectories,omitempty"`
+	// ControlCommands はキュー内の制御コマンドから作成したタスクの元の制御コマンドです。
+	ControlCommands map[string]json.RawMessage `json:"control_commands,omitempty"`
+}
+
+// SetChangeHook はタスクの追加や割り込みでキューが変化したときに呼び出される関数を設定します。
+// フックは変更を行った呼び出しの中で、ロック解放後に呼び出されるため、Snapshot を呼び出しても構いません。
+func (r *PTZRepo) SetChangeHook(hook func()) {
+	r.onChange.Store(&hook)
+}
+
+// SetProgressHook はポーリングや結果の報告でタスクの実行が進んだときに呼び出される関数を設定します。
+// 呼び出しの条件以外は SetChangeHook と同じです。
+func (r *PTZRepo) SetProgressHook(hook func()) {
+	r.onProgress.Store(&hook)
+}
+
+// Snapshot は全カメラのキュー状態をシリアライズします。
+func (r *PTZRepo) Snapshot() ([]byte, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	snapshots := make([]*ptzQueueSnapshot, 0, len(r.cameraQueues))
+
+	for _, queue := range r.cameraQueues {
+		ptzQueue, err := marshalTasks(queue.PTZQueue)
+		if err != nil {
+			return nil, err
+		}
+
+		cinematicQueue, err := marshalTasks(queue.CinematicQueue)
+		if err != nil {
+			return nil, err
+		}
+
+		armTrajectories := make(map[string]*ArmTrajectory)
+		focusMoves := make(map[string]*FocusMove)
+		ptzTrajectories := make(map[string]*PTZTrajectory)
+
+		controlCommands := make(map[string]json.RawMessage)
+
+		for _, task := range slices.Concat(queue.PTZQueue, queue.CinematicQueue) {
+			if trajectory, ok := r.armTrajectories[task.GetTaskId()]; ok {
+				armTrajectories[task.GetTaskId()] = trajectory
+			}
+
+			if focus, ok := r.focusMoves[task.GetTaskId()]; ok {
+				focusMoves[task.GetTaskId()] = focus
+			}
+
+			if trajectory, ok := r.ptzTrajectories[task.GetTaskId()]; ok {
+				ptzTrajectories[task.GetTaskId()] = trajectory
+			}
+
+			if command, ok := r.controlCommandTasks[task.GetTaskId()]; ok {
+				data, err := protojson.Marshal(command)
+				if err != nil {
+					return nil, fmt.Errorf("encode control command %s: %w", task.GetTaskId(), err)
+				}
+
+				controlCommands[task.GetTaskId()] = data
+			}
+		}
+
+		snapshots = append(snapshots, &ptzQueueSnapshot{
+			CameraID:        queue.CameraID,
+			PTZQueue:        ptzQueue,
+			CinematicQueue:  cinematicQueue,
+			ExecutingTaskID: queue.ExecutingTask.GetTaskId(),
+			LastPollingAtMs: queue.LastPollingAtMs,
+			Interrupt:       queue.Interrupt,
+			ArmTrajectories: armTrajectories,
+			FocusMoves:      focusMoves,
+			PTZTrajectories: ptzTrajectories,
+			ControlCommands: controlCommands,
+		})
+	}
+
+	return json.Marshal(snapshots)
+}
+
+// Restore は Snapshot で保存したキュー状態で現在の状態を置き換えます。
+func (r *PTZRepo) Restore(data []byte) error {
+	var snapshots []*ptzQueueSnapshot
+	if err := json.Unmarshal(data, &snapshots); err != nil {
+		return fmt.Errorf("decode ptz queue snapshot: %w", err)
+	}
+
+	cameraQueues := make(map[string]*CameraQueue, len(snapshots))
+	armTrajectories := make(map[string]*ArmTrajectory)
+	focusMoves := make(map[string]*FocusMove)
+	ptzTrajectories := make(map[string]*PTZTrajectory)
+	controlCommandTasks := make(map[string]*protov1.ControlCommand)
+
+	for _, snapshot := range snapshots {
+		ptzQueue, err := unmarshalTasks(snapshot.PTZQueue)
+		if err != nil {
+			return err
+		}
+
+		cinematicQueue, err := unmarshalTasks(snapshot.CinematicQueue)
+		if err != nil {
+			return err
+		}
+
+		queue := &CameraQueue{
+			CameraID:        snapshot.CameraID,
+			PTZQueue:        ptzQueue,
+			CinematicQueue:  cinematicQueue,
+			ExecutingTask:   nil,
+			LastPollingAtMs: snapshot.LastPollingAtMs,
+			Interrupt:       snapshot.Interrupt,
+		}
+
+		// 実行中タスクはキュー内の同一タスクを指すように復元する
+		for _, task := range slices.Concat(queue.PTZQueue, queue.CinematicQueue) {
+			if task.GetTaskId() == snapshot.ExecutingTaskID {
+				queue.ExecutingTask = task
+			}
+		}
+
+		cameraQueues[snapshot.CameraID] = queue
+		maps.Copy(armTrajectories, snapshot.ArmTrajectories)
+		maps.Copy(focusMoves, snapshot.FocusMoves)
+		maps.Copy(ptzTrajectories, snapshot.PTZTrajectories)
+
+		for taskID, raw := range snapshot.ControlCommands {
+			command := new(protov1.ControlCommand)
+			if err := protojson.Unmarshal(raw, command); err != nil {
+				return fmt.Errorf("decode control command %s: %w", taskID, err)
+			}
+
+			controlCommandTasks[taskID] = command
+		}
+	}
+
+	r.mu.Lock()
+	r.cameraQueues = cameraQueues
+	r.armTrajectories = armTrajectories
+	r.focusMoves = focusMoves
+	r.ptzTrajectories = ptzTrajectories
+	r.controlCommandTasks = controlCommandTasks
+	r.mu.Unlock()
+
+	return nil
+}
+
+// notifyChange は変更フックを呼び出します。
+func (r *PTZRepo) notifyChange() {
+	if hook := r.onChange.Load(); hook != nil {
+		(*hook)()
+	}
+}
+
+// notifyProgress は進行フックを呼び出します。
+func (r *PTZRepo) notifyProgress() {
+	if hook := r.onProgress.Load(); hook != nil {
+		(*hook)()
+	}
+}
+
 // getOrCreateCameraQueue はカメラキューを取得または作成します。
 func (r *PTZRepo) getOrCreateCameraQueue(cameraID string) *CameraQueue {
 	if queue, ok := r.cameraQueues[cameraID]; ok {
@@ -202,30 +691,34 @@ func (r *PTZRepo) getOrCreateCameraQueue(cameraID string) *CameraQueue {
 	return queue
 }
 
-// dequeueCompletedPTZTask はPTZキューから完了したタスクを削除します。
-func (r *PTZRepo) dequeueCompletedPTZTask(queue *CameraQueue, taskID string) {
+// dequeueCompletedPTZTask はPTZキューから完了したタスクを削除し、削除したタスクを返します。
+func (r *PTZRepo) dequeueCompletedPTZTask(queue *CameraQueue, taskID string) *protov1.Task {
 	for i, task := range queue.PTZQueue {
 		if task.GetTaskId() == taskID {
 			task.Status = protov1.TaskStatus_TASK_STATUS_COMPLETED
 
 			queue.PTZQueue = append(queue.PTZQueue[:i], queue.PTZQueue[i+1:]...)
 
-			return
+			return task
 		}
 	}
+
+	return nil
 }
 
-// dequeueCompletedCinematicTask はシネマティックキューから完了したタスクを削除します。
-func (r *PTZRepo) dequeueCompletedCinematicTask(queue *CameraQueue, taskID string) {
+// dequeueCompletedCinematicTask はシネマティックキューから完了したタスクを削除し、削除したタスクを返します。
+func (r *PTZRepo) dequeueCompletedCinematicTask(queue *CameraQueue, taskID string) *protov1.Task {
 	for i, task := range queue.CinematicQueue {
 		if task.GetTaskId() == taskID {
 			task.Status = protov1.TaskStatus_TASK_STATUS_COMPLETED
 
 			queue.CinematicQueue = append(queue.CinematicQueue[:i], queue.CinematicQueue[i+1:]...)
 
-			return
+			return task
 		}
 	}
+
+	return nil
 }
 
 // clearExecutingTaskIfCompleted は完了したタスクが実行中の場合、クリアします。
@@ -235,6 +728,24 @@ func (r *PTZRepo) clearExecutingTaskIfCompleted(queue *CameraQueue, taskID strin
 	}
 }
 
+// startNextTask はキューの先頭のタスクが実行中のタスクと異なる場合に、先頭のタスクを実行中にして
+// task.started を配信します。タスクはポーリングを待たずに、追加・終了でキューが変化した時点で実行対象になり、
+// 制御コマンドを購読するFDへの配信もこのイベントで始まります。実行対象が変わった場合は true を返します。
+// r.mu を保持して呼び出します。
+func (r *PTZRepo) startNextTask(queue *CameraQueue) bool {
+	current, _ := r.getNextTasks(queue)
+	if current == nil || queue.ExecutingTask == current {
+		return false
+	}
+
+	queue.ExecutingTask = current
+	current.Status = protov1.TaskStatus_TASK_STATUS_EXECUTING
+
+	r.publishTaskEvent(EventTaskStarted, queue.CameraID, current)
+
+	return true
+}
+
 // getNextTasks は次に実行すべきタスク（最大2件）を取得します。
 // PTZ枠が優先され、PTZ枠が空の場合のみシネマティック枠を実行します。
 func (r *PTZRepo) getNextTasks(queue *CameraQueue) (*protov1.Task, *protov1.Task) {
@@ -264,6 +775,86 @@ func (r *PTZRepo) getNextTasks(queue *CameraQueue) (*protov1.Task, *protov1.Task
 	return currentCommand, nextCommand
 }
 
+// publishTaskEvent はタスクのイベントをイベントバスへ配信します。制御コマンドから作成したタスクの場合は、
+// 元の制御コマンドをイベントに設定します。r.mu を保持し、forgetTask より前に呼び出します。
+func (r *PTZRepo) publishTaskEvent(eventType, cameraID string, task *protov1.Task) {
+	r.events.PublishControlCommandEvent(
+		EventTopicTask, eventType, task.GetTaskId(), cameraID, task, r.controlCommandTasks[task.GetTaskId()],
+	)
+}
+
+// forgetTask はキューから取り除いたタスクに関連付けた内容を削除します。r.mu を保持して呼び出します。
+func (r *PTZRepo) forgetTask(taskID string) {
+	delete(r.armTrajectories, taskID)
+	delete(r.focusMoves, taskID)
+	delete(r.ptzTrajectories, taskID)
+	delete(r.controlCommandTasks, taskID)
+}
+
+// publishTaskEvents はタスクイベントを全購読者に配信します。
+func (r *PTZRepo) publishTaskEvents(events []*TaskEvent) {
+	if len(events) == 0 {
+		return
+	}
+
+	r.taskSubscribersMu.RLock()
+	defer r.taskSubscribersMu.RUnlock()
+
+	for _, event := range events {
+		for _, subscriber := range r.taskSubscribers {
+			subscriber.push(event)
+		}
+	}
+}
+
+// newTaskEvent はタスクイベントを作成します。
+func newTaskEvent(
+	cameraID string,
+	task *protov1.Task,
+	outcome TaskOutcome,
+	currentPTZ *protov1.PTZParameters,
+) *TaskEvent {
+	return &TaskEvent{
+		CameraID:    cameraID,
+		Task:        task,
+		Outcome:     outcome,
+		CurrentPTZ:  currentPTZ,
+		TimestampMs: time.Now().UnixMilli(),
+	}
+}
+
+// marshalTasks はタスクをprotojsonでシリアライズします。
+func marshalTasks(tasks []*protov1.Task) ([]json.RawMessage, error) {
+	result := make([]json.RawMessage, 0, len(tasks))
+
+	for _, task := range tasks {
+		data, err := protojson.Marshal(task)
+		if err != nil {
+			return nil, fmt.Errorf("encode task %s: %w", task.GetTaskId(), err)
+		}
+
+		result = append(result, data)
+	}
+
+	return result, nil
+}
+
+// unmarshalTasks は marshalTasks でシリアライズしたタスクを復元します。
+func unmarshalTasks(data []json.RawMessage) ([]*protov1.Task, error) {
+	result := make([]*protov1.Task, 0, len(data))
+
+	for _, raw := range data {
+		task := new(protov1.Task)
+		if err := protojson.Unmarshal(raw, task); err != nil {
+			return nil, fmt.Errorf("decode task: %w", err)
+		}
+
+		result = append(result, task)
+	}
+
+	return result, nil
+}
+
 // safeIntToUint32 はintをuint32に安全に変換します。
 func safeIntToUint32(num int) uint32 {
 	if num < 0 {
@@ -0,0 +1,505 @@
+package infrastructure
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+	"strings"
+
+	"google.golang.org/protobuf/proto"
+
+	protov1 "github.com/anyfld/vistra-operation-control-room/gen/proto/v1"
+)
+
+const (
+	PTZLimitActionClip   = "clip"
+	PTZLimitActionReject = "reject"
+)
+
+const (
+	// noGoZoneMarginDeg は禁止領域の外へ補正した位置を境界から離す距離です。
+	// float32 に変換しても境界の上や内側に戻らないようにします。
+	noGoZoneMarginDeg = 0.01
+	// minNoGoZoneVertices は禁止領域の多角形に必要な頂点の数です。
+	minNoGoZoneVertices = 3
+)
+
+var (
+	ErrPTZLimitViolation = errors.New("ptz command violates camera limits")
+	ErrInvalidPTZLimits  = errors.New("invalid ptz limits")
+)
+
+// PTPoint はパン・チルト（度）の平面上の点です。
+type PTPoint struct {
+	Pan  float64 `json:"pan"`
+	Tilt float64 `json:"tilt"`
+}
+
+// NoGoZone はカメラを向けてはいけないパン・チルトの多角形の領域です。境界の上は領域に含みません。
+type NoGoZone struct {
+	Vertices []PTPoint `json:"vertices"`
+}
+
+// LimitRange は軸のソフトリミットの範囲です。
+type LimitRange struct {
+	Min float64 `json:"min"`
+	Max float64 `json:"max"`
+}
+
+// PTZLimitConfig はカメラごとに登録するソフトリミットと禁止領域の設定です（PTZLimitsService）。
+// 省略した軸は能力情報の可動範囲のままで、Action は制限を超える命令を補正する（clip、既定）か拒否する（reject）かです。
+type PTZLimitConfig struct {
+	Pan         *LimitRange `json:"pan,omitempty"`
+	Tilt        *LimitRange `json:"tilt,omitempty"`
+	Zoom        *LimitRange `json:"zoom,omitempty"`
+	NoGoZones   []NoGoZone  `json:"no_go_zones,omitempty"`
+	Action      string      `json:"action,omitempty"`
+	UpdatedAtMs int64       `json:"updated_at_ms"`
+}
+
+// Validate は設定を検証します。範囲は能力情報の可動範囲と重なる必要があります。
+func (c *PTZLimitConfig) Validate(caps *protov1.CameraCapabilities) error {
+	_, err := NewPTZLimits(NewCameraOptics(caps, nil), c)
+
+	return err
+}
+
+// PTZLimits はカメラのソフトリミットと禁止領域です。
+// ソフトリミットは能力情報の可動範囲と重なる範囲で、禁止領域の判定は命令の目標と、現在のPTZが分かる場合は
+// 現在の位置から目標までのパン・チルトの直線の経路（連続移動の場合は速度の方向の経路）に対して行います。
+type PTZLimits struct {
+	PanMin    float64
+	PanMax    float64
+	TiltMin   float64
+	TiltMax   float64
+	ZoomMin   float64
+	ZoomMax   float64
+	NoGoZones []NoGoZone
+	// Reject が true の場合は制限を超える命令を拒否し、false の場合は制限の内側に補正します。
+	Reject bool
+
+	optics *CameraOptics
+}
+
+// NewPTZLimits はカメラの光学特性と登録された設定から制限を作成します。
+// 設定がない場合は nil を返し、nil の PTZLimits は全ての命令をそのまま通します。
+func NewPTZLimits(optics *CameraOptics, config *PTZLimitConfig) (*PTZLimits, error) {
+	if config == nil {
+		return nil, nil //nolint:nilnil
+	}
+
+	limits := &PTZLimits{
+		PanMin:    optics.PanMin,
+		PanMax:    optics.PanMax,
+		TiltMin:   optics.TiltMin,
+		TiltMax:   optics.TiltMax,
+		ZoomMin:   optics.ZoomMin,
+		ZoomMax:   optics.ZoomMax,
+		NoGoZones: nil,
+		Reject:    false,
+		optics:    optics,
+	}
+
+	for _, axis := range []struct {
+		name     string
+		value    *LimitRange
+		min, max *float64
+	}{
+		{"pan", config.Pan, &limits.PanMin, &limits.PanMax},
+		{"tilt", config.Tilt, &limits.TiltMin, &limits.TiltMax},
+		{"zoom", config.Zoom, &limits.ZoomMin, &limits.ZoomMax},
+	} {
+		if err := narrowLimit(axis.value, axis.min, axis.max); err != nil {
+			return nil, fmt.Errorf("%w: %s: %w", ErrInvalidPTZLimits, axis.name, err)
+		}
+	}
+
+	for i, zone := range config.NoGoZones {
+		if len(zone.Vertices) < minNoGoZoneVertices {
+			return nil, fmt.Errorf(
+				"%w: no_go_zones[%d] has %d vertices, at least %d are required",
+				ErrInvalidPTZLimits, i, len(zone.Vertices), minNoGoZoneVertices,
+			)
+		}
+
+		for _, vertex := range zone.Vertices {
+			if math.IsNaN(vertex.Pan) || math.IsNaN(vertex.Tilt) || math.IsInf(vertex.Pan, 0) || math.IsInf(vertex.Tilt, 0) {
+				return nil, fmt.Errorf("%w: no_go_zones[%d] has a non-finite vertex", ErrInvalidPTZLimits, i)
+			}
+		}
+
+		limits.NoGoZones = append(limits.NoGoZones, NoGoZone{Vertices: slices.Clone(zone.Vertices)})
+	}
+
+	switch config.Action {
+	case "", PTZLimitActionClip:
+	case PTZLimitActionReject:
+		limits.Reject = true
+	default:
+		return nil, fmt.Errorf("%w: unknown action %q", ErrInvalidPTZLimits, config.Action)
+	}
+
+	return limits, nil
+}
+
+// CheckControlCommand は移動系の制御コマンドを制限で確認します。current はカメラの現在のPTZで、
+// 相対移動と連続移動の確認と、絶対移動の経路の確認に使います。制限の内側の命令はそのまま返し、補正した場合は補正した命令のコピーと理由を返します。
+// 拒否する場合や、現在のPTZが分からず相対移動・連続移動を確認できない場合は ErrPTZLimitViolation を返します。
+func (l *PTZLimits) CheckControlCommand(
+	command *protov1.ControlCommand,
+	current *protov1.PTZParameters,
+) (*protov1.ControlCommand, string, error) {
+	if l == nil {
+		return command, "", nil
+	}
+
+	params := command.GetPtzParameters()
+
+	switch command.GetType() { //nolint:exhaustive
+	case protov1.ControlCommandType_CONTROL_COMMAND_TYPE_PTZ_ABSOLUTE:
+		target := ptzVector{pan: float64(params.GetPan()), tilt: float64(params.GetTilt()), zoom: float64(params.GetZoom())}
+
+		var start *ptzVector
+		if current != nil {
+			start = &ptzVector{pan: float64(current.GetPan()), tilt: float64(current.GetTilt()), zoom: float64(current.GetZoom())}
+		}
+
+		clipped, reasons, err := l.checkTarget(start, target, params.GetZoom() > 0)
+		if err != nil || len(reasons) == 0 {
+			return command, "", err
+		}
+
+		return withPTZPosition(command, clipped), strings.Join(reasons, "; "), nil
+	case protov1.ControlCommandType_CONTROL_COMMAND_TYPE_PTZ_RELATIVE:
+		if current == nil {
+			return nil, "", fmt.Errorf("%w: current position is unknown", ErrPTZLimitViolation)
+		}
+
+		base := ptzVector{pan: float64(current.GetPan()), tilt: float64(current.GetTilt()), zoom: float64(current.GetZoom())}
+		target := ptzVector{
+			pan:  base.pan + float64(params.GetPan()),
+			tilt: base.tilt + float64(params.GetTilt()),
+			zoom: base.zoom + float64(params.GetZoom()),
+		}
+
+		clipped, reasons, err := l.checkTarget(&base, target, params.GetZoom() != 0)
+		if err != nil || len(reasons) == 0 {
+			return command, "", err
+		}
+
+		delta := ptzVector{pan: clipped.pan - base.pan, tilt: clipped.tilt - base.tilt, zoom: clipped.zoom - base.zoom}
+
+		return withPTZPosition(command, delta), strings.Join(reasons, "; "), nil
+	case protov1.ControlCommandType_CONTROL_COMMAND_TYPE_PTZ_CONTINUOUS:
+		return l.checkContinuous(command, current)
+	default:
+		return command, "", nil
+	}
+}
+
+// checkTarget は目標の位置を確認し、補正した位置と補正の理由を返します。checkZoom が false の場合はズームを確認しません。
+// start が nil でない場合は、start から目標までの経路が禁止領域を通らないことも確認します。
+func (l *PTZLimits) checkTarget(start *ptzVector, target ptzVector, checkZoom bool) (ptzVector, []string, error) {
+	reasons := make([]string, 0)
+	clipAxis := func(name string, value *float64, lower, upper float64) {
+		if *value < lower || *value > upper {
+			clipped := clamp(*value, lower, upper)
+			reasons = append(reasons, fmt.Sprintf("%s %g clipped to soft limit %g", name, *value, clipped))
+			*value = clipped
+		}
+	}
+
+	clipAxis("pan", &target.pan, l.PanMin, l.PanMax)
+	clipAxis("tilt", &target.tilt, l.TiltMin, l.TiltMax)
+
+	if checkZoom {
+		clipAxis("zoom", &target.zoom, l.ZoomMin, l.ZoomMax)
+	}
+
+	point := PTPoint{Pan: target.pan, Tilt: target.tilt}
+
+	for i, zone := range l.NoGoZones {
+		if !zone.Contains(point) {
+			continue
+		}
+
+		outside := zone.nearestOutside(point)
+		if !l.allows(outside) {
+			return target, nil, fmt.Errorf(
+				"%w: pan %g tilt %g is inside no-go zone %d and cannot be moved out of it",
+				ErrPTZLimitViolation, point.Pan, point.Tilt, i,
+			)
+		}
+
+		reasons = append(reasons, fmt.Sprintf(
+			"pan %g tilt %g is inside no-go zone %d, moved to pan %g tilt %g",
+			point.Pan, point.Tilt, i, outside.Pan, outside.Tilt,
+		))
+		target.pan, target.tilt = outside.Pan, outside.Tilt
+
+		break
+	}
+
+	if start != nil {
+		if stopped, reason := l.checkPath(*start, target); reason != "" {
+			reasons = append(reasons, reason)
+			target = stopped
+		}
+	}
+
+	if len(reasons) > 0 && l.Reject {
+		return target, nil, fmt.Errorf("%w: %s", ErrPTZLimitViolation, strings.Join(reasons, "; "))
+	}
+
+	return target, reasons, nil
+}
+
+// checkPath は start から target へのパン・チルトの直線の経路が禁止領域を通るかを確認します。
+// 通る場合は、最初に入る領域の境界から noGoZoneMarginDeg だけ手前で止めた位置と理由を返します。
+func (l *PTZLimits) checkPath(start, target ptzVector) (ptzVector, string) {
+	origin := PTPoint{Pan: start.pan, Tilt: start.tilt}
+	direction := PTPoint{Pan: target.pan - start.pan, Tilt: target.tilt - start.tilt}
+
+	length := math.Hypot(direction.Pan, direction.Tilt)
+	if length == 0 {
+		return target, ""
+	}
+
+	// direction は経路全体のため、entryTime の秒数は経路の割合になる
+	entry, zoneIndex := math.Inf(1), -1
+
+	for i, zone := range l.NoGoZones {
+		if ratio, ok := zone.entryTime(origin, direction); ok && ratio < entry {
+			entry, zoneIndex = ratio, i
+		}
+	}
+
+	if zoneIndex < 0 || entry >= 1 {
+		return target, ""
+	}
+
+	ratio := max(entry-noGoZoneMarginDeg/length, 0)
+	stopped := ptzVector{pan: start.pan + direction.Pan*ratio, tilt: start.tilt + direction.Tilt*ratio, zoom: target.zoom}
+
+	return stopped, fmt.Sprintf(
+		"path from pan %g tilt %g to pan %g tilt %g crosses no-go zone %d, stopped at pan %g tilt %g",
+		start.pan, start.tilt, target.pan, target.tilt, zoneIndex, stopped.pan, stopped.tilt,
+	)
+}
+
+// allows は点がパン・チルトのソフトリミットの内側にあり、どの禁止領域にも含まれないかどうかを返します。
+func (l *PTZLimits) allows(point PTPoint) bool {
+	if point.Pan < l.PanMin || point.Pan > l.PanMax || point.Tilt < l.TiltMin || point.Tilt > l.TiltMax {
+		return false
+	}
+
+	for _, zone := range l.NoGoZones {
+		if zone.Contains(point) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// checkContinuous は連続移動がタイムアウト（未指定の場合は停止まで）までに制限を超えるかを確認します。
+// 補正する場合は制限に達する時刻で止まるようタイムアウトを短くします。
+func (l *PTZLimits) checkContinuous(
+	command *protov1.ControlCommand,
+	current *protov1.PTZParameters,
+) (*protov1.ControlCommand, string, error) {
+	velocity := l.optics.axisVelocity(command.GetPtzParameters(), 0)
+	if velocity.pan == 0 && velocity.tilt == 0 && velocity.zoom == 0 {
+		return command, "", nil
+	}
+
+	if current == nil {
+		return nil, "", fmt.Errorf("%w: current position is unknown", ErrPTZLimitViolation)
+	}
+
+	start := ptzVector{pan: float64(current.GetPan()), tilt: float64(current.GetTilt()), zoom: float64(current.GetZoom())}
+
+	seconds, reason := l.firstViolation(start, velocity)
+	if reason == "" {
+		return command, "", nil
+	}
+
+	limitMs := uint32(min(math.Floor(seconds*1000), math.MaxUint32)) //nolint:mnd
+	if command.GetTimeoutMs() > 0 && command.GetTimeoutMs() <= limitMs {
+		return command, "", nil
+	}
+
+	if l.Reject || limitMs == 0 {
+		return nil, "", fmt.Errorf("%w: %s", ErrPTZLimitViolation, reason)
+	}
+
+	clipped, ok := proto.Clone(command).(*protov1.ControlCommand)
+	if !ok {
+		return command, "", nil
+	}
+
+	clipped.TimeoutMs = limitMs
+
+	return clipped, fmt.Sprintf("%s, timeout clipped to %d ms", reason, limitMs), nil
+}
+
+// firstViolation は start から velocity で動いたとき、最初に制限を超えるまでの秒数とその理由を返します。
+// 制限を超えない場合は理由が空になります。
+func (l *PTZLimits) firstViolation(start, velocity ptzVector) (float64, string) {
+	earliest := math.Inf(1)
+	reason := ""
+	consider := func(seconds float64, text string) {
+		if seconds < earliest {
+			earliest, reason = seconds, text
+		}
+	}
+
+	for _, axis := range []struct {
+		name         string
+		value, speed float64
+		lower, upper float64
+	}{
+		{"pan", start.pan, velocity.pan, l.PanMin, l.PanMax},
+		{"tilt", start.tilt, velocity.tilt, l.TiltMin, l.TiltMax},
+		{"zoom", start.zoom, velocity.zoom, l.ZoomMin, l.ZoomMax},
+	} {
+		// 既に範囲の外にいて更に外へ向かう場合は、動き始めた時点で超えている
+		switch {
+		case axis.speed > 0:
+			consider(max(axis.upper-axis.value, 0)/axis.speed, fmt.Sprintf("%s reaches soft limit %g", axis.name, axis.upper))
+		case axis.speed < 0:
+			consider(max(axis.value-axis.lower, 0)/-axis.speed, fmt.Sprintf("%s reaches soft limit %g", axis.name, axis.lower))
+		}
+	}
+
+	origin := PTPoint{Pan: start.pan, Tilt: start.tilt}
+	direction := PTPoint{Pan: velocity.pan, Tilt: velocity.tilt}
+
+	for i, zone := range l.NoGoZones {
+		if seconds, ok := zone.entryTime(origin, direction); ok {
+			consider(seconds, fmt.Sprintf("pan/tilt enters no-go zone %d", i))
+		}
+	}
+
+	return earliest, reason
+}
+
+// Contains は点が領域の内側にあるかどうかを返します（偶奇規則）。
+func (z NoGoZone) Contains(point PTPoint) bool {
+	inside := false
+
+	for i, a := range z.Vertices {
+		b := z.Vertices[(i+1)%len(z.Vertices)]
+		if (a.Tilt > point.Tilt) != (b.Tilt > point.Tilt) &&
+			point.Pan < a.Pan+(point.Tilt-a.Tilt)/(b.Tilt-a.Tilt)*(b.Pan-a.Pan) {
+			inside = !inside
+		}
+	}
+
+	return inside
+}
+
+// nearestOutside は内側の点から最も近い境界の点を求め、noGoZoneMarginDeg だけ外側へ出した点を返します。
+func (z NoGoZone) nearestOutside(point PTPoint) PTPoint {
+	nearest := point
+	best := math.Inf(1)
+
+	for i, a := range z.Vertices {
+		b := z.Vertices[(i+1)%len(z.Vertices)]
+		edge := PTPoint{Pan: b.Pan - a.Pan, Tilt: b.Tilt - a.Tilt}
+		ratio := clamp(
+			((point.Pan-a.Pan)*edge.Pan+(point.Tilt-a.Tilt)*edge.Tilt)/(edge.Pan*edge.Pan+edge.Tilt*edge.Tilt), 0, 1,
+		)
+		candidate := PTPoint{Pan: a.Pan + ratio*edge.Pan, Tilt: a.Tilt + ratio*edge.Tilt}
+
+		if distance := math.Hypot(candidate.Pan-point.Pan, candidate.Tilt-point.Tilt); distance < best {
+			nearest, best = candidate, distance
+		}
+	}
+
+	if best == 0 {
+		return nearest
+	}
+
+	scale := (best + noGoZoneMarginDeg) / best
+
+	return PTPoint{
+		Pan:  point.Pan + (nearest.Pan-point.Pan)*scale,
+		Tilt: point.Tilt + (nearest.Tilt-point.Tilt)*scale,
+	}
+}
+
+// entryTime は origin から direction（度/秒）で進んだとき、外側から領域に入るまでの秒数を返します。
+// 既に内側にいて外へ出る移動は、再び入る場合のみ対象になります。
+func (z NoGoZone) entryTime(origin, direction PTPoint) (float64, bool) {
+	earliest := math.Inf(1)
+
+	for i, a := range z.Vertices {
+		b := z.Vertices[(i+1)%len(z.Vertices)]
+		edge := PTPoint{Pan: b.Pan - a.Pan, Tilt: b.Tilt - a.Tilt}
+
+		denominator := direction.Pan*edge.Tilt - direction.Tilt*edge.Pan
+		if denominator == 0 {
+			continue
+		}
+
+		offset := PTPoint{Pan: a.Pan - origin.Pan, Tilt: a.Tilt - origin.Tilt}
+		seconds := (offset.Pan*edge.Tilt - offset.Tilt*edge.Pan) / denominator
+		ratio := (offset.Pan*direction.Tilt - offset.Tilt*direction.Pan) / denominator
+
+		if seconds < 0 || ratio < 0 || ratio > 1 || seconds >= earliest {
+			continue
+		}
+
+		// 交点の少し先が内側であれば、外から入る交点とする
+		speed := math.Hypot(direction.Pan, direction.Tilt)
+		after := seconds + noGoZoneMarginDeg/speed
+
+		if z.Contains(PTPoint{Pan: origin.Pan + direction.Pan*after, Tilt: origin.Tilt + direction.Tilt*after}) {
+			earliest = seconds
+		}
+	}
+
+	return earliest, !math.IsInf(earliest, 1)
+}
+
+func withPTZPosition(command *protov1.ControlCommand, position ptzVector) *protov1.ControlCommand {
+	clipped, ok := proto.Clone(command).(*protov1.ControlCommand)
+	if !ok {
+		return command
+	}
+
+	if clipped.PtzParameters == nil {
+		clipped.PtzParameters = new(protov1.PTZParameters)
+	}
+
+	clipped.PtzParameters.Pan = float32(position.pan)
+	clipped.PtzParameters.Tilt = float32(position.tilt)
+
+	if clipped.GetPtzParameters().GetZoom() != 0 {
+		clipped.PtzParameters.Zoom = float32(position.zoom)
+	}
+
+	return clipped
+}
+
+// narrowLimit は範囲 value で lower, upper を狭めます。value が nil の場合は何もしません。
+func narrowLimit(value *LimitRange, lower, upper *float64) error {
+	if value == nil {
+		return nil
+	}
+
+	if math.IsNaN(value.Min) || math.IsNaN(value.Max) || value.Max <= value.Min {
+		return fmt.Errorf("min %g must be less than max %g", value.Min, value.Max)
+	}
+
+	narrowedMin, narrowedMax := max(*lower, value.Min), min(*upper, value.Max)
+	if narrowedMax <= narrowedMin {
+		return fmt.Errorf("limit %g:%g is outside the camera range %g:%g", value.Min, value.Max, *lower, *upper)
+	}
+
+	*lower, *upper = narrowedMin, narrowedMax
+
+	return nil
+}
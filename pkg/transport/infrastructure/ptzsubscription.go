@@ -0,0 +1,184 @@
+package infrastructure
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sync/atomic"
+)
+
+const (
+	// ptzReplayBufferSize はカメラごとに再送用に保持するイベントの数です。
+	ptzReplayBufferSize = 256
+	// ptzChannelBufferSize は購読者のバッファです。再送用のイベントをすべて受け取れる大きさにします。
+	ptzChannelBufferSize = ptzReplayBufferSize
+)
+
+// ErrPTZReplayGap は再開を要求された通し番号の次のイベントが、再送用のバッファに残っていないことを表します。
+// 購読者は afterSequence を 0 にして購読し直し、最新の状態から受信を再開します。
+var ErrPTZReplayGap = errors.New("ptz events after the sequence are no longer available")
+
+// PTZSubscription はカメラのPTZ命令の購読です。イベントは通し番号の順に、欠けることなく届きます。
+// 受信が追いつかずバッファが溢れた場合は、イベントを破棄する代わりに購読を終了してチャネルを閉じます。
+type PTZSubscription struct {
+	cameraID string
+	ch       chan *PTZCommandEvent
+	// startSequence は購読を開始した時点でカメラに最後に配信したイベントの通し番号です。
+	startSequence uint64
+	// lastSequence はチャネルに送ったイベントの通し番号です。ptzMu を保持して読み書きします。
+	lastSequence uint64
+	overflowed   atomic.Bool
+}
+
+// CameraID は購読しているカメラのIDを返します。
+func (s *PTZSubscription) CameraID() string {
+	return s.cameraID
+}
+
+// StartSequence は購読を開始した時点でカメラに最後に配信したイベントの通し番号を返します。
+// 購読後に配信されるイベントの通し番号は、この値の次から 1 ずつ増えます。
+func (s *PTZSubscription) StartSequence() uint64 {
+	return s.startSequence
+}
+
+// Events はイベントを受信するチャネルを返します。購読解除またはバッファ溢れで閉じられます。
+func (s *PTZSubscription) Events() <-chan *PTZCommandEvent {
+	return s.ch
+}
+
+// Overflowed はバッファ溢れで購読が終了したかどうかを返します。
+// true の場合、購読者は最後に受信したイベントの通し番号から購読し直します。
+func (s *PTZSubscription) Overflowed() bool {
+	return s.overflowed.Load()
+}
+
+// SubscribePTZCommands はカメラのPTZ命令を購読します。
+// afterSequence が 0 の場合は最後に配信した制御コマンド（結果が報告されていればその結果）を1件再送します。
+// afterSequence を指定した場合は、その次の通し番号から再送用のバッファに残っているイベントをすべて再送します。
+// バッファから既に消えたイベントがある場合は ErrPTZReplayGap を返します。
+func (r *FDRepo) SubscribePTZCommands(cameraID string, afterSequence uint64) (*PTZSubscription, error) {
+	subscription := &PTZSubscription{
+		cameraID:      cameraID,
+		ch:            make(chan *PTZCommandEvent, ptzChannelBufferSize),
+		startSequence: 0,
+		lastSequence:  0,
+		overflowed:    atomic.Bool{},
+	}
+
+	r.ptzMu.Lock()
+
+	current := r.ptzSequences[cameraID]
+	replay := r.ptzReplay[cameraID]
+
+	var events []*PTZCommandEvent
+
+	switch {
+	case afterSequence == 0:
+		if last := r.lastPTZEvents[cameraID]; last != nil {
+			events = append(events, last)
+		}
+	case afterSequence > current:
+		r.ptzMu.Unlock()
+
+		return nil, fmt.Errorf("%w: sequence %d is ahead of %d", ErrPTZReplayGap, afterSequence, current)
+	case afterSequence < current && (len(replay) == 0 || replay[0].Sequence > afterSequence+1):
+		r.ptzMu.Unlock()
+
+		return nil, fmt.Errorf("%w: sequence %d", ErrPTZReplayGap, afterSequence)
+	default:
+		for _, event := range replay {
+			if event.Sequence > afterSequence {
+				events = append(events, event)
+			}
+		}
+	}
+
+	for _, event := range events {
+		subscription.ch <- event
+	}
+
+	subscription.startSequence = current
+	subscription.lastSequence = current
+
+	if r.ptzSubscribers[cameraID] == nil {
+		r.ptzSubscribers[cameraID] = make(map[*PTZSubscription]struct{})
+	}
+
+	r.ptzSubscribers[cameraID][subscription] = struct{}{}
+
+	r.ptzMu.Unlock()
+
+	for _, event := range events {
+		if event.Result == nil {
+			r.markControlCommandDispatched(event.Command.GetCommandId())
+		}
+	}
+
+	return subscription, nil
+}
+
+// UnsubscribePTZCommands は購読を解除し、チャネルを閉じます。
+func (r *FDRepo) UnsubscribePTZCommands(subscription *PTZSubscription) {
+	r.ptzMu.Lock()
+	defer r.ptzMu.Unlock()
+
+	r.removePTZSubscription(subscription)
+}
+
+// publishPTZCommand はイベントに通し番号を振って再送用のバッファに追加し、カメラの全購読者に配信します。
+// 1つ以上の購読者に届いたかどうかを返します。
+func (r *FDRepo) publishPTZCommand(cameraID string, event *PTZCommandEvent) bool {
+	r.ptzMu.Lock()
+	defer r.ptzMu.Unlock()
+
+	r.ptzSequences[cameraID]++
+	event.Sequence = r.ptzSequences[cameraID]
+
+	replay := append(r.ptzReplay[cameraID], event)
+	if len(replay) > ptzReplayBufferSize {
+		replay = replay[len(replay)-ptzReplayBufferSize:]
+	}
+
+	r.ptzReplay[cameraID] = replay
+
+	// 結果は、その制御コマンドが最後に配信したものである場合だけ置き換える
+	if last := r.lastPTZEvents[cameraID]; event.Result == nil ||
+		(last != nil && last.Command.GetCommandId() == event.Command.GetCommandId()) {
+		r.lastPTZEvents[cameraID] = event
+	}
+
+	delivered := false
+
+	for subscription := range r.ptzSubscribers[cameraID] {
+		select {
+		case subscription.ch <- event:
+			subscription.lastSequence = event.Sequence
+			delivered = true
+		default:
+			log.Printf(
+				"ptz subscriber overflowed: camera_id=%s last_sequence=%d dropped_sequence=%d",
+				cameraID, subscription.lastSequence, event.Sequence,
+			)
+
+			subscription.overflowed.Store(true)
+			r.removePTZSubscription(subscription)
+		}
+	}
+
+	return delivered
+}
+
+// removePTZSubscription は購読を解除します。r.ptzMu を保持して呼び出します。
+func (r *FDRepo) removePTZSubscription(subscription *PTZSubscription) {
+	subscribers := r.ptzSubscribers[subscription.cameraID]
+	if _, ok := subscribers[subscription]; !ok {
+		return
+	}
+
+	delete(subscribers, subscription)
+	close(subscription.ch)
+
+	if len(subscribers) == 0 {
+		delete(r.ptzSubscribers, subscription.cameraID)
+	}
+}
@@ -0,0 +1,266 @@
+package infrastructure
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	protov1 "github.com/anyfld/vistra-operation-control-room/gen/proto/v1"
+)
+
+const rundownStateChannelBufferSize = 16
+
+var ErrRundownNotFound = errors.New("rundown not found")
+
+// RundownMode はキューの進め方です。
+type RundownMode string
+
+const (
+	// RundownModeManual はオペレーターの操作でキューを進めます。
+	RundownModeManual RundownMode = "manual"
+	// RundownModeTimecode は開始からの経過時間に従ってキューを進めます。
+	// タイムコードを持たないキューは手動操作を待ちます。
+	RundownModeTimecode RundownMode = "timecode"
+)
+
+// RundownStatus は台本の再生状態です。
+type RundownStatus string
+
+const (
+	RundownStatusIdle     RundownStatus = "idle"
+	RundownStatusRunning  RundownStatus = "running"
+	RundownStatusFinished RundownStatus = "finished"
+	RundownStatusStopped  RundownStatus = "stopped"
+)
+
+// RundownAction はキューで実行する1つの操作です。いずれか1つのフィールドのみが設定されます。
+type RundownAction struct {
+	Cinematography *protov1.CinematographyInstruction
+	PTZ            *protov1.SendPTZCommandRequest
+	SwitchSource   *protov1.SwitchSourceRequest
+	FocusPull      *RundownFocusPull
+}
+
+// RundownFocusPull はカメラのシネマティック枠に追加するフォーカスの移動です。
+// 前のキューで追加したシネマティック命令の後に、DurationMs かけて Focus へ移動します。
+type RundownFocusPull struct {
+	CameraID   string  `json:"camera_id"`
+	Focus      float32 `json:"focus"`
+	DurationMs uint32  `json:"duration_ms"`
+}
+
+// RundownCue は台本の1キューです。
+type RundownCue struct {
+	ID          string
+	Label       string
+	HasTimecode bool
+	TimecodeMs  int64
+	Actions     []*RundownAction
+}
+
+// Rundown は順序付きのキューからなる台本です。
+type Rundown struct {
+	ID          string
+	Name        string
+	Cues        []*RundownCue
+	CreatedAtMs int64
+}
+
+// RundownActionResult はキューの操作の実行結果です。
+type RundownActionResult struct {
+	Action  string
+	Success bool
+	Error   string
+	// TaskID はPTZキューに積まれたタスクまたは演出指示のIDです。
+	TaskID string
+}
+
+// RundownState は台本の再生状態です。CurrentIndex は最後に実行したキューの位置で、
+// 未実行の場合は -1 です。
+type RundownState struct {
+	RundownID    string
+	Status       RundownStatus
+	Mode         RundownMode
+	CurrentIndex int
+	StartedAtMs  int64
+	FiredAtMs    int64
+	LastResults  []*RundownActionResult
+	UpdatedAtMs  int64
+}
+
+// RundownRepo は台本と再生状態を管理するリポジトリです。
+type RundownRepo struct {
+	mu          sync.RWMutex
+	rundowns    map[string]*Rundown
+	states      map[string]*RundownState
+	subscribers map[string][]chan *RundownState
+}
+
+// NewRundownRepo は新しいRundownRepoを作成します。
+func NewRundownRepo() *RundownRepo {
+	return &RundownRepo{
+		mu:          sync.RWMutex{},
+		rundowns:    make(map[string]*Rundown),
+		states:      make(map[string]*RundownState),
+		subscribers: make(map[string][]chan *RundownState),
+	}
+}
+
+// SaveRundown は台本を保存し、IDを割り当てます。
+func (r *RundownRepo) SaveRundown(rundown *Rundown) *Rundown {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	rundown.ID = fmt.Sprintf("rundown-%d", now.UnixNano())
+	rundown.CreatedAtMs = now.UnixMilli()
+
+	r.rundowns[rundown.ID] = rundown
+	r.states[rundown.ID] = &RundownState{
+		RundownID:    rundown.ID,
+		Status:       RundownStatusIdle,
+		Mode:         RundownModeManual,
+		CurrentIndex: -1,
+		StartedAtMs:  0,
+		FiredAtMs:    0,
+		LastResults:  nil,
+		UpdatedAtMs:  now.UnixMilli(),
+	}
+
+	return rundown
+}
+
+// GetRundown は台本を取得します。
+func (r *RundownRepo) GetRundown(rundownID string) (*Rundown, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	rundown, ok := r.rundowns[rundownID]
+
+	return rundown, ok
+}
+
+// ListRundowns は台本を作成順に返します。
+func (r *RundownRepo) ListRundowns() []*Rundown {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	rundowns := make([]*Rundown, 0, len(r.rundowns))
+	for _, rundown := range r.rundowns {
+		rundowns = append(rundowns, rundown)
+	}
+
+	sort.Slice(rundowns, func(i, j int) bool {
+		return rundowns[i].CreatedAtMs < rundowns[j].CreatedAtMs
+	})
+
+	return rundowns
+}
+
+// DeleteRundown は台本を削除し、購読者のチャネルを閉じます。
+func (r *RundownRepo) DeleteRundown(rundownID string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.rundowns[rundownID]; !ok {
+		return false
+	}
+
+	delete(r.rundowns, rundownID)
+	delete(r.states, rundownID)
+
+	for _, subscriber := range r.subscribers[rundownID] {
+		close(subscriber)
+	}
+
+	delete(r.subscribers, rundownID)
+
+	return true
+}
+
+// GetState は再生状態のコピーを返します。
+func (r *RundownRepo) GetState(rundownID string) (*RundownState, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	state, ok := r.states[rundownID]
+	if !ok {
+		return nil, false
+	}
+
+	return state.clone(), true
+}
+
+// UpdateState は再生状態を更新して購読者に配信します。
+// update がエラーを返した場合は状態を変更しません。
+func (r *RundownRepo) UpdateState(
+	rundownID string,
+	update func(state *RundownState) error,
+) (*RundownState, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	state, ok := r.states[rundownID]
+	if !ok {
+		return nil, ErrRundownNotFound
+	}
+
+	next := state.clone()
+	if err := update(next); err != nil {
+		return nil, err
+	}
+
+	next.UpdatedAtMs = time.Now().UnixMilli()
+	r.states[rundownID] = next
+
+	for _, subscriber := range r.subscribers[rundownID] {
+		select {
+		case subscriber <- next.clone():
+		default:
+		}
+	}
+
+	return next.clone(), nil
+}
+
+// SubscribeState は再生状態の変化を購読します。
+func (r *RundownRepo) SubscribeState(rundownID string) (<-chan *RundownState, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.rundowns[rundownID]; !ok {
+		return nil, ErrRundownNotFound
+	}
+
+	stateCh := make(chan *RundownState, rundownStateChannelBufferSize)
+	r.subscribers[rundownID] = append(r.subscribers[rundownID], stateCh)
+
+	return stateCh, nil
+}
+
+// UnsubscribeState は再生状態の購読を解除します。
+func (r *RundownRepo) UnsubscribeState(rundownID string, stateCh <-chan *RundownState) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	subscribers := r.subscribers[rundownID]
+	for i, subscriber := range subscribers {
+		if subscriber == stateCh {
+			r.subscribers[rundownID] = append(subscribers[:i], subscribers[i+1:]...)
+
+			close(subscriber)
+
+			return
+		}
+	}
+}
+
+func (s *RundownState) clone() *RundownState {
+	cloned := *s
+	cloned.LastResults = slices.Clone(s.LastResults)
+
+	return &cloned
+}
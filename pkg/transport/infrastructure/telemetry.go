@@ -0,0 +1,304 @@
+package infrastructure
+
+import (
+	"sort"
+	"sync"
+
+	"google.golang.org/protobuf/proto"
+
+	protov1 "github.com/anyfld/vistra-operation-control-room/gen/proto/v1"
+)
+
+// telemetryBufferSize はカメラごとに保持するサンプルの数です。古いものから上書きします。
+// FDが 500ms 間隔でポーリングする場合、約30分ぶんに相当します。
+const telemetryBufferSize = 4096
+
+// TelemetrySource はサンプルの記録元です。
+type TelemetrySource string
+
+const (
+	// TelemetrySourceCameraState は FDService の CameraState の報告です。
+	TelemetrySourceCameraState TelemetrySource = "camera_state"
+	// TelemetrySourcePolling は PTZService.Polling のリクエストです。
+	TelemetrySourcePolling TelemetrySource = "polling"
+)
+
+// TelemetrySample はある時点のカメラの状態です。
+// 記録元が報告しない項目は直前のサンプルから引き継ぐため、各サンプルはその時点の状態の全体を表します。
+type TelemetrySample struct {
+	// TimestampMs はサーバーが受信した時刻です。ダウンサンプリングした場合は区間の開始時刻です。
+	TimestampMs int64
+	// DeviceTimestampMs はFDが報告した時刻です（報告がない場合は 0）。
+	DeviceTimestampMs int64
+	Source            TelemetrySource
+	PTZ               *protov1.PTZParameters
+	Focus             float32
+	IsMoving          bool
+	HasError          bool
+	ErrorMessage      string
+	DeviceStatus      protov1.DeviceStatus
+	CameraStatus      protov1.CameraStatus
+	// Samples はこのサンプルにまとめた元のサンプルの数です。
+	Samples int
+}
+
+// TelemetryQuery は時系列の取得条件です。
+type TelemetryQuery struct {
+	CameraID string
+	// SinceMs 以上 UntilMs 未満の時刻のサンプルを返します。0 の場合は制限しません。
+	SinceMs int64
+	UntilMs int64
+	// IntervalMs が 0 より大きい場合は、この幅の区間ごとに1つのサンプルにまとめます。
+	IntervalMs int64
+	// MaxPoints が 0 より大きく、サンプルがこれを超える場合は、収まるように区間の幅を決めてまとめます。
+	MaxPoints int
+}
+
+// telemetrySeries はカメラのサンプルのリングバッファです。
+type telemetrySeries struct {
+	samples []*TelemetrySample
+	// next は次に書き込む位置です。バッファが一杯の場合は最も古いサンプルの位置でもあります。
+	next int
+}
+
+// TelemetryRepo はカメラごとの状態の時系列を保持します。
+type TelemetryRepo struct {
+	mu     sync.RWMutex
+	series map[string]*telemetrySeries
+	size   int
+}
+
+func NewTelemetryRepo() *TelemetryRepo {
+	return &TelemetryRepo{
+		mu:     sync.RWMutex{},
+		series: make(map[string]*telemetrySeries),
+		size:   telemetryBufferSize,
+	}
+}
+
+// RecordCameraState は CameraState をサンプルとして記録します。
+func (r *TelemetryRepo) RecordCameraState(state *protov1.CameraState, nowMs int64) {
+	r.record(state.GetCameraId(), nowMs, func(sample *TelemetrySample) {
+		sample.Source = TelemetrySourceCameraState
+		sample.DeviceTimestampMs = state.GetUpdatedAtMs()
+		sample.Focus = state.GetCurrentFocus()
+		sample.IsMoving = state.GetIsMoving()
+		sample.HasError = state.GetHasError()
+		sample.ErrorMessage = state.GetErrorMessage()
+
+		if state.GetCurrentPtz() != nil {
+			sample.PTZ = state.GetCurrentPtz()
+		}
+
+		if state.GetStatus() != protov1.CameraStatus_CAMERA_STATUS_UNSPECIFIED {
+			sample.CameraStatus = state.GetStatus()
+		}
+	})
+}
+
+// RecordPolling は PollingRequest をサンプルとして記録します。
+func (r *TelemetryRepo) RecordPolling(req *protov1.PollingRequest, nowMs int64) {
+	r.record(req.GetCameraId(), nowMs, func(sample *TelemetrySample) {
+		sample.Source = TelemetrySourcePolling
+		sample.DeviceTimestampMs = req.GetTimestampMs()
+
+		if req.GetCurrentPtz() != nil {
+			sample.PTZ = req.GetCurrentPtz()
+		}
+
+		if req.GetDeviceStatus() != protov1.DeviceStatus_DEVICE_STATUS_UNSPECIFIED {
+			sample.DeviceStatus = req.GetDeviceStatus()
+		}
+
+		if req.GetCameraStatus() != protov1.CameraStatus_CAMERA_STATUS_UNSPECIFIED {
+			sample.CameraStatus = req.GetCameraStatus()
+		}
+	})
+}
+
+// record は直前のサンプルを引き継いだサンプルに update を適用して追加します。
+func (r *TelemetryRepo) record(cameraID string, nowMs int64, update func(sample *TelemetrySample)) {
+	if cameraID == "" {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	series := r.series[cameraID]
+	if series == nil {
+		series = &telemetrySeries{samples: make([]*TelemetrySample, 0, r.size), next: 0}
+		r.series[cameraID] = series
+	}
+
+	sample := &TelemetrySample{} //nolint:exhaustruct
+	if last := series.last(); last != nil {
+		*sample = *last
+	}
+
+	update(sample)
+
+	if sample.PTZ != nil {
+		sample.PTZ = proto.CloneOf(sample.PTZ)
+	}
+
+	// 時刻の逆転を防ぎ、範囲の検索で二分探索できるようにする
+	if last := series.last(); last != nil && nowMs < last.TimestampMs {
+		nowMs = last.TimestampMs
+	}
+
+	sample.TimestampMs = nowMs
+	sample.Samples = 1
+
+	series.append(sample, r.size)
+}
+
+// QueryTelemetry は条件に合うサンプルを古い順に返します。
+func (r *TelemetryRepo) QueryTelemetry(query TelemetryQuery) []*TelemetrySample {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	series := r.series[query.CameraID]
+	if series == nil {
+		return []*TelemetrySample{}
+	}
+
+	ordered := series.ordered()
+
+	start := sort.Search(len(ordered), func(i int) bool { return ordered[i].TimestampMs >= query.SinceMs })
+	end := len(ordered)
+
+	if query.UntilMs > 0 {
+		end = sort.Search(len(ordered), func(i int) bool { return ordered[i].TimestampMs >= query.UntilMs })
+	}
+
+	if start >= end {
+		return []*TelemetrySample{}
+	}
+
+	samples := ordered[start:end]
+
+	// 区間は SinceMs（省略時は最初のサンプルの時刻）から数える
+	originMs := samples[0].TimestampMs
+	if query.SinceMs > 0 {
+		originMs = query.SinceMs
+	}
+
+	intervalMs := query.IntervalMs
+	if query.MaxPoints > 0 && len(samples) > query.MaxPoints {
+		span := samples[len(samples)-1].TimestampMs - originMs + 1
+		intervalMs = max(intervalMs, (span+int64(query.MaxPoints)-1)/int64(query.MaxPoints))
+	}
+
+	if intervalMs <= 0 {
+		result := make([]*TelemetrySample, 0, len(samples))
+		for _, sample := range samples {
+			copied := *sample
+			result = append(result, &copied)
+		}
+
+		return result
+	}
+
+	return downsampleTelemetry(samples, originMs, intervalMs)
+}
+
+// downsampleTelemetry はサンプルを originMs から intervalMs の幅の区間ごとにまとめます。
+// PTZ とフォーカスは平均、動作中とエラーはいずれかのサンプルで立っていれば立て、その他は区間の最後のサンプルの値です。
+func downsampleTelemetry(samples []*TelemetrySample, originMs int64, intervalMs int64) []*TelemetrySample {
+	var (
+		result []*TelemetrySample
+		bucket []*TelemetrySample
+	)
+
+	flush := func() {
+		if len(bucket) > 0 {
+			result = append(result, mergeTelemetry(bucket, originMs+(bucket[0].TimestampMs-originMs)/intervalMs*intervalMs))
+		}
+
+		bucket = bucket[:0]
+	}
+
+	for _, sample := range samples {
+		if len(bucket) > 0 && (sample.TimestampMs-originMs)/intervalMs != (bucket[0].TimestampMs-originMs)/intervalMs {
+			flush()
+		}
+
+		bucket = append(bucket, sample)
+	}
+
+	flush()
+
+	return result
+}
+
+func mergeTelemetry(bucket []*TelemetrySample, startMs int64) *TelemetrySample {
+	merged := *bucket[len(bucket)-1]
+	merged.TimestampMs = startMs
+	merged.Samples = 0
+	merged.IsMoving = false
+	merged.HasError = false
+
+	var (
+		pan, tilt, zoom, focus float64
+		withPTZ                int
+	)
+
+	for _, sample := range bucket {
+		merged.Samples += sample.Samples
+		merged.IsMoving = merged.IsMoving || sample.IsMoving
+
+		if sample.HasError {
+			merged.HasError = true
+			merged.ErrorMessage = sample.ErrorMessage
+		}
+
+		focus += float64(sample.Focus)
+
+		if sample.PTZ != nil {
+			pan += float64(sample.PTZ.GetPan())
+			tilt += float64(sample.PTZ.GetTilt())
+			zoom += float64(sample.PTZ.GetZoom())
+			withPTZ++
+		}
+	}
+
+	merged.Focus = float32(focus / float64(len(bucket)))
+	merged.PTZ = nil
+
+	if withPTZ > 0 {
+		merged.PTZ = &protov1.PTZParameters{ //nolint:exhaustruct
+			Pan:  float32(pan / float64(withPTZ)),
+			Tilt: float32(tilt / float64(withPTZ)),
+			Zoom: float32(zoom / float64(withPTZ)),
+		}
+	}
+
+	return &merged
+}
+
+func (s *telemetrySeries) last() *TelemetrySample {
+	if len(s.samples) == 0 {
+		return nil
+	}
+
+	return s.samples[(s.next-1+len(s.samples))%len(s.samples)]
+}
+
+func (s *telemetrySeries) append(sample *TelemetrySample, size int) {
+	if len(s.samples) < size {
+		s.samples = append(s.samples, sample)
+		s.next = len(s.samples) % size
+
+		return
+	}
+
+	s.samples[s.next] = sample
+	s.next = (s.next + 1) % size
+}
+
+// ordered はサンプルを古い順に並べた新しいスライスを返します。
+// バッファが一杯になるまでは next が末尾を指すため、そのまま先頭から並びます。
+func (s *telemetrySeries) ordered() []*TelemetrySample {
+	return append(append([]*TelemetrySample(nil), s.samples[s.next:]...), s.samples[:s.next]...)
+}
@@ -0,0 +1,491 @@
+package infrastructure
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	protov1 "github.com/anyfld/vistra-operation-control-room/gen/proto/v1"
+)
+
+// TrackingMode は追尾で発行するPTZ命令の種類です。
+type TrackingMode string
+
+const (
+	// TrackingModeContinuous は ContinuousMove で速度を更新し続けます。
+	TrackingModeContinuous TrackingMode = "continuous"
+	// TrackingModeAbsolute は検出のたびに AbsoluteMove で次の位置へ移動します。
+	TrackingModeAbsolute TrackingMode = "absolute"
+)
+
+// TrackingState は追尾セッションの状態です。
+type TrackingState string
+
+const (
+	// TrackingStateSearching は被写体をまだ一度も検出していない状態です。
+	TrackingStateSearching TrackingState = "searching"
+	// TrackingStateTracking は被写体を目標位置へ寄せている状態です。
+	TrackingStateTracking TrackingState = "tracking"
+	// TrackingStateHolding は被写体が不感帯の中にあり、カメラを止めている状態です。
+	TrackingStateHolding TrackingState = "holding"
+	// TrackingStateLost は被写体を見失い、カメラを止めて再検出を待っている状態です。
+	TrackingStateLost TrackingState = "lost"
+	// TrackingStateOverridden は手動のPTZ命令（Layer 1）が優先されている状態です。
+	TrackingStateOverridden TrackingState = "overridden"
+)
+
+const (
+	defaultTrackingTarget      = 0.5
+	defaultTrackingDeadZone    = 0.05
+	defaultTrackingLostTimeout = 1500 * time.Millisecond
+	defaultTrackingManualHold  = 3 * time.Second
+	defaultTrackingSmoothing   = 0.5
+
+	// 連続移動の既定ゲイン。出力は度/秒です。
+	defaultContinuousKp = 2.0
+	defaultContinuousKi = 0.2
+	defaultContinuousKd = 0.1
+	// 絶対移動の既定ゲイン。出力は次の位置までの移動量（度）です。
+	defaultAbsoluteKp = 0.6
+	defaultAbsoluteKi = 0.0
+	defaultAbsoluteKd = 0.0
+)
+
+var (
+	ErrTrackingNotFound      = errors.New("tracking session not found")
+	ErrTrackingAlreadyActive = errors.New("camera is already tracking a subject")
+	ErrInvalidTrackingConfig = errors.New("invalid tracking config")
+)
+
+// TrackingConfig は追尾の制御パラメータです。ゼロ値の項目には既定値を使います。
+type TrackingConfig struct {
+	Mode TrackingMode
+	// TargetX, TargetY は被写体の中心を置く画面上の位置 (0.0-1.0) です。
+	TargetX float64
+	TargetY float64
+	// DeadZone は目標位置からのずれ（画面比）がこの値以下の軸を動かしません。
+	DeadZone float64
+	// LostTimeout の間被写体を検出できなければ見失ったとみなします。
+	LostTimeout time.Duration
+	// ManualHold は手動のPTZ命令を受けてから追尾を再開するまでの時間です。
+	ManualHold time.Duration
+	// Smoothing は検出位置の指数移動平均の係数 (0.0-1.0] です。1.0 で平滑化しません。
+	Smoothing float64
+	Kp        float64
+	Ki        float64
+	Kd        float64
+}
+
+// WithDefaults は未指定の項目を既定値で埋めた設定を返します。
+func (c TrackingConfig) WithDefaults() TrackingConfig {
+	if c.Mode == "" {
+		c.Mode = TrackingModeContinuous
+	}
+
+	if c.TargetX == 0 {
+		c.TargetX = defaultTrackingTarget
+	}
+
+	if c.TargetY == 0 {
+		c.TargetY = defaultTrackingTarget
+	}
+
+	if c.DeadZone == 0 {
+		c.DeadZone = defaultTrackingDeadZone
+	}
+
+	if c.LostTimeout == 0 {
+		c.LostTimeout = defaultTrackingLostTimeout
+	}
+
+	if c.ManualHold == 0 {
+		c.ManualHold = defaultTrackingManualHold
+	}
+
+	if c.Smoothing == 0 {
+		c.Smoothing = defaultTrackingSmoothing
+	}
+
+	if c.Kp == 0 && c.Ki == 0 && c.Kd == 0 {
+		if c.Mode == TrackingModeAbsolute {
+			c.Kp, c.Ki, c.Kd = defaultAbsoluteKp, defaultAbsoluteKi, defaultAbsoluteKd
+		} else {
+			c.Kp, c.Ki, c.Kd = defaultContinuousKp, defaultContinuousKi, defaultContinuousKd
+		}
+	}
+
+	return c
+}
+
+// Validate は設定値の範囲を検証します。
+func (c TrackingConfig) Validate() error {
+	switch {
+	case c.Mode != TrackingModeContinuous && c.Mode != TrackingModeAbsolute:
+		return fmt.Errorf("%w: unknown mode %q", ErrInvalidTrackingConfig, c.Mode)
+	case c.TargetX < 0 || c.TargetX > 1 || c.TargetY < 0 || c.TargetY > 1:
+		return fmt.Errorf("%w: target must be within 0.0-1.0", ErrInvalidTrackingConfig)
+	case c.DeadZone < 0 || c.DeadZone >= defaultTrackingTarget:
+		return fmt.Errorf("%w: dead zone must be within 0.0-0.5", ErrInvalidTrackingConfig)
+	case c.Smoothing < 0 || c.Smoothing > 1:
+		return fmt.Errorf("%w: smoothing must be within 0.0-1.0", ErrInvalidTrackingConfig)
+	case c.Kp < 0 || c.Ki < 0 || c.Kd < 0:
+		return fmt.Errorf("%w: gains must not be negative", ErrInvalidTrackingConfig)
+	case c.LostTimeout < 0 || c.ManualHold < 0:
+		return fmt.Errorf("%w: durations must not be negative", ErrInvalidTrackingConfig)
+	default:
+		return nil
+	}
+}
+
+// pidAxis は1軸分のPID制御の状態です。
+type pidAxis struct {
+	integral  float64
+	prevError float64
+	hasPrev   bool
+}
+
+// update は誤差 err（度）から出力を計算します。積分は limit を超えないように制限します。
+func (a *pidAxis) update(err, seconds, kp, ki, kd, limit float64) float64 {
+	derivative := 0.0
+	if a.hasPrev && seconds > 0 {
+		derivative = (err - a.prevError) / seconds
+	}
+
+	if ki > 0 {
+		a.integral = clamp(a.integral+err*seconds, -limit/ki, limit/ki)
+	}
+
+	a.prevError = err
+	a.hasPrev = true
+
+	return clamp(kp*err+ki*a.integral+kd*derivative, -limit, limit)
+}
+
+func (a *pidAxis) reset() {
+	a.integral = 0
+	a.prevError = 0
+	a.hasPrev = false
+}
+
+// TrackingController は検出した被写体の位置から追尾用のPTZ命令を計算します。
+type TrackingController struct {
+	config    TrackingConfig
+	optics    *CameraOptics
+	pan       pidAxis
+	tilt      pidAxis
+	centerX   float64
+	centerY   float64
+	hasCenter bool
+	// moving は連続移動の速度を送信中かどうかです。
+	moving bool
+}
+
+// NewTrackingController は新しいTrackingControllerを作成します。config は既定値で埋めたものを渡します。
+func NewTrackingController(config TrackingConfig, optics *CameraOptics) *TrackingController {
+	return &TrackingController{
+		config:    config,
+		optics:    optics,
+		pan:       pidAxis{integral: 0, prevError: 0, hasPrev: false},
+		tilt:      pidAxis{integral: 0, prevError: 0, hasPrev: false},
+		centerX:   0,
+		centerY:   0,
+		hasCenter: false,
+		moving:    false,
+	}
+}
+
+// Step は検出した被写体の位置と前回の検出からの経過時間からPTZ命令を計算します。
+// 命令を送る必要がない場合は nil を返します。
+// 絶対移動は現在のPTZが必要で、current が nil の場合は命令を返しません。
+func (c *TrackingController) Step(
+	box *protov1.BoundingBox,
+	current *protov1.PTZParameters,
+	elapsed time.Duration,
+) (*protov1.PTZCommand, TrackingState) {
+	x := float64(box.GetX() + box.GetWidth()/2)  //nolint:mnd
+	y := float64(box.GetY() + box.GetHeight()/2) //nolint:mnd
+
+	if c.hasCenter {
+		c.centerX += c.config.Smoothing * (x - c.centerX)
+		c.centerY += c.config.Smoothing * (y - c.centerY)
+	} else {
+		c.centerX, c.centerY, c.hasCenter = x, y, true
+	}
+
+	// 画面上のずれを角度に変換する。パンは右、チルトは上が正
+	zoom := c.optics.effectiveZoom(float64(current.GetZoom()))
+	targetPan, targetTilt := c.optics.ImageAngles(0, 0, zoom, c.config.TargetX, c.config.TargetY)
+	centerPan, centerTilt := c.optics.ImageAngles(0, 0, zoom, c.centerX, c.centerY)
+	panError := centerPan - targetPan
+	tiltError := centerTilt - targetTilt
+
+	panInside := math.Abs(c.centerX-c.config.TargetX) <= c.config.DeadZone
+	tiltInside := math.Abs(c.centerY-c.config.TargetY) <= c.config.DeadZone
+
+	if panInside {
+		panError = 0
+
+		c.pan.reset()
+	}
+
+	if tiltInside {
+		tiltError = 0
+
+		c.tilt.reset()
+	}
+
+	if panInside && tiltInside {
+		return c.Halt(), TrackingStateHolding
+	}
+
+	seconds := elapsed.Seconds()
+
+	if c.config.Mode == TrackingModeAbsolute {
+		if current == nil {
+			return nil, TrackingStateTracking
+		}
+
+		return c.absoluteMove(current, zoom, panError, tiltError, seconds), TrackingStateTracking
+	}
+
+	panSpeed := c.pan.update(panError, seconds, c.config.Kp, c.config.Ki, c.config.Kd, c.optics.PanSpeedDps)
+	tiltSpeed := c.tilt.update(tiltError, seconds, c.config.Kp, c.config.Ki, c.config.Kd, c.optics.TiltSpeedDps)
+	c.moving = true
+
+	return newContinuousMove(
+		panSpeed/c.optics.PanSpeedDps,
+		tiltSpeed/c.optics.TiltSpeedDps,
+		c.config.LostTimeout,
+	), TrackingStateTracking
+}
+
+// Halt は制御の状態をリセットし、連続移動中であれば停止命令を返します。
+func (c *TrackingController) Halt() *protov1.PTZCommand {
+	c.pan.reset()
+	c.tilt.reset()
+
+	if !c.moving {
+		return nil
+	}
+
+	c.moving = false
+
+	return newContinuousMove(0, 0, c.config.LostTimeout)
+}
+
+// Reset は検出位置の平滑化を含む全ての状態を破棄します。カメラは既に止まっているものとします。
+func (c *TrackingController) Reset() {
+	c.pan.reset()
+	c.tilt.reset()
+	c.hasCenter = false
+	c.moving = false
+}
+
+// absoluteMove は次の検出までに到着する速度で、PID出力の分だけ移動する命令を作成します。
+func (c *TrackingController) absoluteMove(
+	current *protov1.PTZParameters,
+	zoom, panError, tiltError, seconds float64,
+) *protov1.PTZCommand {
+	panLimit := c.optics.PanMax - c.optics.PanMin
+	tiltLimit := c.optics.TiltMax - c.optics.TiltMin
+
+	panDelta := c.pan.update(panError, seconds, c.config.Kp, c.config.Ki, c.config.Kd, panLimit)
+	tiltDelta := c.tilt.update(tiltError, seconds, c.config.Kp, c.config.Ki, c.config.Kd, tiltLimit)
+
+	pan := clamp(float64(current.GetPan())+panDelta, c.optics.PanMin, c.optics.PanMax)
+	tilt := clamp(float64(current.GetTilt())+tiltDelta, c.optics.TiltMin, c.optics.TiltMax)
+
+	moveSeconds := max(
+		seconds,
+		axisSeconds(math.Abs(panDelta), c.optics.PanSpeedDps),
+		axisSeconds(math.Abs(tiltDelta), c.optics.TiltSpeedDps),
+	)
+
+	return &protov1.PTZCommand{
+		OperationType: protov1.PTZOperationType_PTZ_OPERATION_TYPE_ABSOLUTE_MOVE,
+		Command: &protov1.PTZCommand_AbsoluteMove{
+			AbsoluteMove: &protov1.AbsoluteMoveCommand{
+				Position: c.optics.NormalizedPosition(pan, tilt, zoom),
+				Speed: &protov1.PTZSpeed{
+					PanSpeed:  float32(axisSpeed(math.Abs(panDelta), moveSeconds, c.optics.PanSpeedDps)),
+					TiltSpeed: float32(axisSpeed(math.Abs(tiltDelta), moveSeconds, c.optics.TiltSpeedDps)),
+					ZoomSpeed: 0,
+				},
+			},
+		},
+	}
+}
+
+// NormalizedPosition は角度と倍率を AbsoluteMove の正規化座標に変換します。
+// パン・チルトは可動範囲を -1.0〜1.0、ズームは倍率の範囲を 0.0〜1.0 に対応させます。
+func (o *CameraOptics) NormalizedPosition(pan, tilt, zoom float64) *protov1.PTZPosition {
+	return &protov1.PTZPosition{
+		X: float32(normalizeRange(pan, o.PanMin, o.PanMax)*2 - 1),    //nolint:mnd
+		Y: float32(normalizeRange(tilt, o.TiltMin, o.TiltMax)*2 - 1), //nolint:mnd
+		Z: float32(normalizeRange(zoom, o.ZoomMin, o.ZoomMax)),
+	}
+}
+
+// DenormalizedPTZ は AbsoluteMove の正規化座標を角度と倍率に変換します。NormalizedPosition の逆変換です。
+func (o *CameraOptics) DenormalizedPTZ(position *protov1.PTZPosition) *protov1.PTZParameters {
+	return &protov1.PTZParameters{
+		Pan:       float32(denormalizeRange((float64(position.GetX())+1)/2, o.PanMin, o.PanMax)),   //nolint:mnd
+		Tilt:      float32(denormalizeRange((float64(position.GetY())+1)/2, o.TiltMin, o.TiltMax)), //nolint:mnd
+		Zoom:      float32(denormalizeRange(float64(position.GetZ()), o.ZoomMin, o.ZoomMax)),
+		PanSpeed:  0,
+		TiltSpeed: 0,
+		ZoomSpeed: 0,
+	}
+}
+
+func normalizeRange(value, lower, upper float64) float64 {
+	if upper <= lower {
+		return 0
+	}
+
+	return clamp((value-lower)/(upper-lower), 0, 1)
+}
+
+func newContinuousMove(panVelocity, tiltVelocity float64, timeout time.Duration) *protov1.PTZCommand {
+	return &protov1.PTZCommand{
+		OperationType: protov1.PTZOperationType_PTZ_OPERATION_TYPE_CONTINUOUS_MOVE,
+		Command: &protov1.PTZCommand_ContinuousMove{
+			ContinuousMove: &protov1.ContinuousMoveCommand{
+				Velocity: &protov1.PTZVelocity{
+					PanVelocity:  float32(clamp(panVelocity, -1, 1)),
+					TiltVelocity: float32(clamp(tiltVelocity, -1, 1)),
+					ZoomVelocity: 0,
+				},
+				// 追尾が止まった場合もFD側で停止するよう、見失う時間をタイムアウトにする
+				TimeoutMs: uint32(timeout.Milliseconds()), //nolint:gosec
+			},
+		},
+	}
+}
+
+// TrackingSession はパターンマッチングの結果でカメラを被写体に追従させるセッションです。
+type TrackingSession struct {
+	TrackingID string
+	CameraID   string
+	// SessionID は検出に使うパターンマッチングのセッションです。
+	SessionID string
+	Subject   *protov1.Subject
+	Config    TrackingConfig
+	CreatedAt time.Time
+
+	done chan struct{}
+
+	mu          sync.Mutex
+	state       TrackingState
+	lastTaskID  string
+	lastSeenAt  time.Time
+	updatedAtMs int64
+}
+
+// TrackingStatus は追尾セッションの現在の状態です。
+type TrackingStatus struct {
+	State       TrackingState
+	LastTaskID  string
+	LastSeenAt  time.Time
+	UpdatedAtMs int64
+}
+
+// Done はセッションの終了時に閉じられるチャネルを返します。
+func (s *TrackingSession) Done() <-chan struct{} {
+	return s.done
+}
+
+// Status は状態のコピーを返します。
+func (s *TrackingSession) Status() TrackingStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return TrackingStatus{
+		State:       s.state,
+		LastTaskID:  s.lastTaskID,
+		LastSeenAt:  s.lastSeenAt,
+		UpdatedAtMs: s.updatedAtMs,
+	}
+}
+
+// SetState は状態を更新します。taskID が空でなければ最後に発行したタスクとして記録します。
+func (s *TrackingSession) SetState(state TrackingState, taskID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.state = state
+	s.updatedAtMs = time.Now().UnixMilli()
+
+	if taskID != "" {
+		s.lastTaskID = taskID
+	}
+}
+
+// MarkSeen は被写体を検出した時刻を記録します。
+func (s *TrackingSession) MarkSeen(at time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.lastSeenAt = at
+}
+
+// StartTracking は追尾セッションを登録します。カメラごとに1つだけ追尾できます。
+func (r *FDRepo) StartTracking(
+	cameraID string,
+	sessionID string,
+	subject *protov1.Subject,
+	config TrackingConfig,
+) (*TrackingSession, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, session := range r.trackingSessions {
+		if session.CameraID == cameraID {
+			return nil, fmt.Errorf("%w: %s", ErrTrackingAlreadyActive, cameraID)
+		}
+	}
+
+	now := time.Now()
+	session := &TrackingSession{
+		TrackingID:  fmt.Sprintf("tracking-%d", now.UnixNano()),
+		CameraID:    cameraID,
+		SessionID:   sessionID,
+		Subject:     subject,
+		Config:      config,
+		CreatedAt:   now,
+		done:        make(chan struct{}),
+		mu:          sync.Mutex{},
+		state:       TrackingStateSearching,
+		lastTaskID:  "",
+		lastSeenAt:  time.Time{},
+		updatedAtMs: now.UnixMilli(),
+	}
+	r.trackingSessions[session.TrackingID] = session
+
+	return session, nil
+}
+
+// StopTracking は追尾セッションを終了します。
+func (r *FDRepo) StopTracking(trackingID string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	session, ok := r.trackingSessions[trackingID]
+	if !ok {
+		return false
+	}
+
+	delete(r.trackingSessions, trackingID)
+	close(session.done)
+
+	return true
+}
+
+func (r *FDRepo) GetTrackingSession(trackingID string) *TrackingSession {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.trackingSessions[trackingID]
+}
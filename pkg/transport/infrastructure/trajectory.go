@@ -0,0 +1,339 @@
+package infrastructure
+
+import (
+	"errors"
+	"fmt"
+	"math"
+
+	protov1 "github.com/anyfld/vistra-operation-control-room/gen/proto/v1"
+)
+
+// PTZParameters は軸ごとの速度しか持たず、TransitionConfig は種別と時間しか持たないため、
+// 加減速を伴う移動はCRが軌道をキーフレームに分割し、FDへ絶対移動または相対移動の連続として配信します。
+// 速度の段階が粗いカメラでも、キーフレームごとの速度を変えることで滑らかな移動になります。
+
+const (
+	// defaultTrajectoryIntervalMs はキーフレームの既定の間隔です。
+	defaultTrajectoryIntervalMs = 100
+	// minTrajectoryIntervalMs より短い間隔はFDが追従できないため受け付けません。
+	minTrajectoryIntervalMs = 20
+	// maxTrajectoryKeyframes を超える場合はキーフレームの間隔を広げます。
+	maxTrajectoryKeyframes = 200
+	// maxTrajectoryDurationMs は軌道の最長の時間です。
+	maxTrajectoryDurationMs = 120000
+	// bezierIterations はベジェ曲線の媒介変数を求める二分法の反復回数です。
+	bezierIterations = 30
+	// trajectorySpeedTolerance は丸め誤差として許す最高速度の超過の割合です。
+	trajectorySpeedTolerance = 1e-6
+)
+
+var ErrInvalidTrajectory = errors.New("invalid trajectory")
+
+// TrajectoryCurve は軌道の加減速の曲線です。
+type TrajectoryCurve string
+
+const (
+	// TrajectoryCurveLinear は一定の速度で移動します。
+	TrajectoryCurveLinear TrajectoryCurve = "linear"
+	// TrajectoryCurveEaseIn はゆっくり動き始めます。
+	TrajectoryCurveEaseIn TrajectoryCurve = "ease_in"
+	// TrajectoryCurveEaseOut はゆっくり止まります。
+	TrajectoryCurveEaseOut TrajectoryCurve = "ease_out"
+	// TrajectoryCurveEaseInOut はゆっくり動き始めてゆっくり止まる S字の曲線です（既定）。
+	TrajectoryCurveEaseInOut TrajectoryCurve = "ease_in_out"
+	// TrajectoryCurveBezier は TrajectoryBezier の制御点で指定した曲線です。
+	TrajectoryCurveBezier TrajectoryCurve = "bezier"
+)
+
+// TrajectoryMoveMode はキーフレームをFDへ配信する移動の種別です。
+type TrajectoryMoveMode string
+
+const (
+	// TrajectoryMoveAbsolute は各キーフレームの位置への絶対移動として配信します（既定）。
+	TrajectoryMoveAbsolute TrajectoryMoveMode = "absolute"
+	// TrajectoryMoveRelative は直前のキーフレームからの相対移動として配信します。
+	TrajectoryMoveRelative TrajectoryMoveMode = "relative"
+)
+
+// TrajectoryBezier は CSS の cubic-bezier と同じ、(0,0) と (1,1) を端点とする3次ベジェ曲線の制御点です。
+// X は時間の割合、Y は移動の割合で、X1 と X2 は 0.0-1.0 です。Y が範囲を超えると目標を行き過ぎて戻ります。
+type TrajectoryBezier struct {
+	X1 float64 `json:"x1"`
+	Y1 float64 `json:"y1"`
+	X2 float64 `json:"x2"`
+	Y2 float64 `json:"y2"`
+}
+
+// trajectoryCurveBeziers は名前付きの曲線の制御点で、CSS の timing function と同じです。
+//
+//nolint:gochecknoglobals,mnd
+var trajectoryCurveBeziers = map[TrajectoryCurve]TrajectoryBezier{
+	TrajectoryCurveLinear:    {X1: 0, Y1: 0, X2: 1, Y2: 1},
+	TrajectoryCurveEaseIn:    {X1: 0.42, Y1: 0, X2: 1, Y2: 1},
+	TrajectoryCurveEaseOut:   {X1: 0, Y1: 0, X2: 0.58, Y2: 1},
+	TrajectoryCurveEaseInOut: {X1: 0.42, Y1: 0, X2: 0.58, Y2: 1},
+}
+
+// Progress は時間の割合 t (0.0-1.0) での移動の割合を返します。
+func (b TrajectoryBezier) Progress(t float64) float64 {
+	t = clamp(t, 0, 1)
+
+	// x(s) は X1, X2 が 0.0-1.0 の場合に単調増加するため、二分法で x(s) = t となる s を求める
+	lower, upper := 0.0, 1.0
+	for range bezierIterations {
+		middle := (lower + upper) / 2 //nolint:mnd
+		if cubicBezier(middle, b.X1, b.X2) < t {
+			lower = middle
+		} else {
+			upper = middle
+		}
+	}
+
+	return cubicBezier((lower+upper)/2, b.Y1, b.Y2) //nolint:mnd
+}
+
+// cubicBezier は端点が 0 と 1 で制御点が p1, p2 の3次ベジェ曲線の s での値です。
+func cubicBezier(s, p1, p2 float64) float64 {
+	inverse := 1 - s
+
+	return 3*inverse*inverse*s*p1 + 3*inverse*s*s*p2 + s*s*s //nolint:mnd
+}
+
+// PTZTrajectorySpec は軌道の指定です。位置は制御コマンドと同じ角度とズーム倍率で表します。
+// To のズームが 0 の場合はズームを動かしません。
+type PTZTrajectorySpec struct {
+	From       *protov1.PTZParameters
+	To         *protov1.PTZParameters
+	DurationMs uint32
+	// Curve を省略すると TrajectoryCurveEaseInOut です。
+	Curve TrajectoryCurve
+	// Bezier は Curve が TrajectoryCurveBezier の場合の制御点です。
+	Bezier *TrajectoryBezier
+	// Mode を省略すると TrajectoryMoveAbsolute です。
+	Mode TrajectoryMoveMode
+	// IntervalMs はキーフレームの間隔です。省略すると defaultTrajectoryIntervalMs です。
+	IntervalMs uint32
+}
+
+// PTZKeyframe は軌道上の1点です。OffsetMs は軌道の開始からこの点に到達するまでの時間で、
+// 速度 (0.0-1.0) は直前の点からこの点まで OffsetMs の差で移動するための軸ごとの速度です。
+type PTZKeyframe struct {
+	Pan       float32 `json:"pan"`
+	Tilt      float32 `json:"tilt"`
+	Zoom      float32 `json:"zoom"`
+	PanSpeed  float32 `json:"pan_speed"`
+	TiltSpeed float32 `json:"tilt_speed"`
+	ZoomSpeed float32 `json:"zoom_speed"`
+	OffsetMs  uint32  `json:"offset_ms"`
+}
+
+// PTZTrajectory は開始位置から目標までの加減速を伴う軌道です。
+type PTZTrajectory struct {
+	Start      PTZKeyframe        `json:"start"`
+	Keyframes  []PTZKeyframe      `json:"keyframes"`
+	Curve      TrajectoryCurve    `json:"curve"`
+	Bezier     TrajectoryBezier   `json:"bezier"`
+	Mode       TrajectoryMoveMode `json:"mode"`
+	DurationMs uint32             `json:"duration_ms"`
+}
+
+// PlanPTZTrajectory は spec の軌道を曲線に沿ってキーフレームに分割します。
+// 全軸が同じ曲線で同時に動き始めて同時に止まり、どのキーフレームの間も軸の最高速度を超えません。
+// 最高速度を超える場合は、超えずに移動できる最短の時間を含めて ErrInvalidTrajectory を返します。
+func (o *CameraOptics) PlanPTZTrajectory(spec *PTZTrajectorySpec) (*PTZTrajectory, error) {
+	bezier, err := spec.validate()
+	if err != nil {
+		return nil, err
+	}
+
+	from := ptzVector{
+		pan:  float64(spec.From.GetPan()),
+		tilt: float64(spec.From.GetTilt()),
+		zoom: float64(spec.From.GetZoom()),
+	}
+
+	to := ptzVector{pan: float64(spec.To.GetPan()), tilt: float64(spec.To.GetTilt()), zoom: float64(spec.To.GetZoom())}
+	if to.zoom <= 0 {
+		to.zoom = from.zoom
+	}
+
+	intervalMs := float64(spec.IntervalMs)
+	if intervalMs == 0 {
+		intervalMs = defaultTrajectoryIntervalMs
+	}
+
+	durationMs := float64(spec.DurationMs)
+	count := min(int(math.Ceil(durationMs/intervalMs)), maxTrajectoryKeyframes)
+	segmentSeconds := durationMs / float64(count) / millisPerSecond
+
+	keyframes := make([]PTZKeyframe, 0, count)
+	previous := from
+	peakRatio := 0.0
+
+	for k := 1; k <= count; k++ {
+		progress := bezier.Progress(float64(k) / float64(count))
+		if k == count {
+			// 丸め誤差で目標から外れないよう、最後の点は目標そのものにする
+			progress = 1
+		}
+
+		point := ptzVector{
+			pan:  from.pan + progress*(to.pan-from.pan),
+			tilt: from.tilt + progress*(to.tilt-from.tilt),
+			zoom: from.zoom + progress*(to.zoom-from.zoom),
+		}
+
+		panDistance := math.Abs(point.pan - previous.pan)
+		tiltDistance := math.Abs(point.tilt - previous.tilt)
+		zoomDistance := math.Abs(point.zoom - previous.zoom)
+
+		peakRatio = max(
+			peakRatio,
+			axisSeconds(panDistance, o.PanSpeedDps)/segmentSeconds,
+			axisSeconds(tiltDistance, o.TiltSpeedDps)/segmentSeconds,
+			axisSeconds(zoomDistance, o.ZoomSpeedPerSec)/segmentSeconds,
+		)
+
+		keyframes = append(keyframes, PTZKeyframe{
+			Pan:       float32(point.pan),
+			Tilt:      float32(point.tilt),
+			Zoom:      float32(point.zoom),
+			PanSpeed:  float32(axisSpeed(panDistance, segmentSeconds, o.PanSpeedDps)),
+			TiltSpeed: float32(axisSpeed(tiltDistance, segmentSeconds, o.TiltSpeedDps)),
+			ZoomSpeed: float32(axisSpeed(zoomDistance, segmentSeconds, o.ZoomSpeedPerSec)),
+			OffsetMs:  uint32(math.Round(durationMs * float64(k) / float64(count))),
+		})
+
+		previous = point
+	}
+
+	if peakRatio > 1+trajectorySpeedTolerance {
+		return nil, fmt.Errorf(
+			"%w: the move exceeds the camera's maximum speed; duration_ms must be at least %d",
+			ErrInvalidTrajectory, uint32(math.Ceil(durationMs*peakRatio/(1+trajectorySpeedTolerance))),
+		)
+	}
+
+	return &PTZTrajectory{
+		Start: PTZKeyframe{
+			Pan:       float32(from.pan),
+			Tilt:      float32(from.tilt),
+			Zoom:      float32(from.zoom),
+			PanSpeed:  0,
+			TiltSpeed: 0,
+			ZoomSpeed: 0,
+			OffsetMs:  0,
+		},
+		Keyframes:  keyframes,
+		Curve:      spec.curve(),
+		Bezier:     bezier,
+		Mode:       spec.mode(),
+		DurationMs: spec.DurationMs,
+	}, nil
+}
+
+// validate は軌道の指定を検証し、使う曲線の制御点を返します。
+func (s *PTZTrajectorySpec) validate() (TrajectoryBezier, error) {
+	switch {
+	case s.From == nil:
+		return TrajectoryBezier{}, fmt.Errorf("%w: from is required", ErrInvalidTrajectory)
+	case s.To == nil:
+		return TrajectoryBezier{}, fmt.Errorf("%w: to is required", ErrInvalidTrajectory)
+	case s.DurationMs == 0 || s.DurationMs > maxTrajectoryDurationMs:
+		return TrajectoryBezier{}, fmt.Errorf(
+			"%w: duration_ms must be within 1-%d", ErrInvalidTrajectory, maxTrajectoryDurationMs,
+		)
+	case s.IntervalMs != 0 && s.IntervalMs < minTrajectoryIntervalMs:
+		return TrajectoryBezier{}, fmt.Errorf(
+			"%w: interval_ms must be at least %d", ErrInvalidTrajectory, minTrajectoryIntervalMs,
+		)
+	}
+
+	switch s.mode() {
+	case TrajectoryMoveAbsolute, TrajectoryMoveRelative:
+	default:
+		return TrajectoryBezier{}, fmt.Errorf("%w: unknown mode %q", ErrInvalidTrajectory, s.Mode)
+	}
+
+	if s.curve() != TrajectoryCurveBezier {
+		bezier, ok := trajectoryCurveBeziers[s.curve()]
+		if !ok {
+			return TrajectoryBezier{}, fmt.Errorf("%w: unknown curve %q", ErrInvalidTrajectory, s.Curve)
+		}
+
+		return bezier, nil
+	}
+
+	if s.Bezier == nil {
+		return TrajectoryBezier{}, fmt.Errorf("%w: bezier control points are required", ErrInvalidTrajectory)
+	}
+
+	for _, value := range []float64{s.Bezier.X1, s.Bezier.Y1, s.Bezier.X2, s.Bezier.Y2} {
+		if math.IsNaN(value) || math.IsInf(value, 0) {
+			return TrajectoryBezier{}, fmt.Errorf("%w: bezier control points must be finite", ErrInvalidTrajectory)
+		}
+	}
+
+	if s.Bezier.X1 < 0 || s.Bezier.X1 > 1 || s.Bezier.X2 < 0 || s.Bezier.X2 > 1 {
+		return TrajectoryBezier{}, fmt.Errorf("%w: bezier x1 and x2 must be within 0.0-1.0", ErrInvalidTrajectory)
+	}
+
+	return *s.Bezier, nil
+}
+
+func (s *PTZTrajectorySpec) curve() TrajectoryCurve {
+	if s.Curve == "" {
+		return TrajectoryCurveEaseInOut
+	}
+
+	return s.Curve
+}
+
+func (s *PTZTrajectorySpec) mode() TrajectoryMoveMode {
+	if s.Mode == "" {
+		return TrajectoryMoveAbsolute
+	}
+
+	return s.Mode
+}
+
+// ControlCommand は index 番目のキーフレームへの移動の制御コマンドを返します。
+// 相対移動の場合は直前のキーフレーム（最初のキーフレームは開始位置）からの移動量です。
+func (t *PTZTrajectory) ControlCommand(index int, commandID, cameraID string) *protov1.ControlCommand {
+	keyframe := t.Keyframes[index]
+	command := &protov1.ControlCommand{
+		CommandId:     commandID,
+		CameraId:      cameraID,
+		Type:          protov1.ControlCommandType_CONTROL_COMMAND_TYPE_PTZ_ABSOLUTE,
+		PtzParameters: keyframe.ptzParameters(),
+		PresetNumber:  0,
+		FocusValue:    0,
+		ArmParameters: nil,
+		TimeoutMs:     0,
+	}
+
+	if t.Mode == TrajectoryMoveRelative {
+		previous := t.Start
+		if index > 0 {
+			previous = t.Keyframes[index-1]
+		}
+
+		command.Type = protov1.ControlCommandType_CONTROL_COMMAND_TYPE_PTZ_RELATIVE
+		command.PtzParameters.Pan -= previous.Pan
+		command.PtzParameters.Tilt -= previous.Tilt
+		command.PtzParameters.Zoom -= previous.Zoom
+	}
+
+	return command
+}
+
+func (k PTZKeyframe) ptzParameters() *protov1.PTZParameters {
+	return &protov1.PTZParameters{
+		Pan:       k.Pan,
+		Tilt:      k.Tilt,
+		Zoom:      k.Zoom,
+		PanSpeed:  k.PanSpeed,
+		TiltSpeed: k.TiltSpeed,
+		ZoomSpeed: k.ZoomSpeed,
+	}
+}
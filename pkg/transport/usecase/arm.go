@@ -0,0 +1,127 @@
+package usecase
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	protov1 "github.com/anyfld/vistra-operation-control-room/gen/proto/v1"
+	"github.com/anyfld/vistra-operation-control-room/pkg/transport/infrastructure"
+)
+
+// EnqueueArmMove はアームの移動を検証して軌道を計算し、PTZキューのタスクとして追加します。
+// layer が COMMAND_LAYER_PTZ の場合は手動操作（Layer 1）として、それ以外はシネマティック枠（Layer 2）として扱います。
+func (u *FDUsecase) EnqueueArmMove(
+	ctx context.Context,
+	cameraID string,
+	params *protov1.ArmParameters,
+	timeoutMs uint32,
+	layer protov1.CommandLayer,
+) (string, *infrastructure.ArmTrajectory, error) {
+	arm, err := u.armCapabilities(cameraID)
+	if err != nil {
+		return "", nil, err
+	}
+
+	if err := arm.Validate(params); err != nil {
+		return "", nil, err
+	}
+
+	if infrastructure.IsArmSafetyStop(params) {
+		return "", nil, fmt.Errorf("%w: speed must be positive to enqueue a move", infrastructure.ErrInvalidArmCommand)
+	}
+
+	trajectory := arm.PlanTrajectory(u.repo.GetArmPose(cameraID), params)
+	trajectory.TimeoutMs = timeoutMs
+	taskID, _ := u.ptzRepo.EnqueueArmCommand(cameraID, layer, trajectory)
+
+	return taskID, trajectory, nil
+}
+
+// armCapabilities は登録済みのカメラのアームの関節構成を返します。
+func (u *FDUsecase) armCapabilities(cameraID string) (*infrastructure.ArmCapabilities, error) {
+	camera := u.cameraRepo.GetCamera(cameraID)
+	if camera == nil {
+		return nil, ErrCameraNotFound
+	}
+
+	return infrastructure.NewArmCapabilities(u.cameraRepo.GetCapabilities(cameraID), camera.GetMetadata())
+}
+
+// sendArm はARMの制御コマンドをアームの関節構成で検証してからFDへ配信します。
+func (u *FDUsecase) sendArm(
+	ctx context.Context,
+	command *protov1.ControlCommand,
+) (*infrastructure.ControlCommandStatus, error) {
+	arm, err := u.armCapabilities(command.GetCameraId())
+	if err != nil {
+		return u.rejectControlCommand(command, err), nil
+	}
+
+	if err := arm.Validate(command.GetArmParameters()); err != nil {
+		return u.rejectControlCommand(command, err), nil
+	}
+
+	return u.repo.SendControlCommand(command), nil
+}
+
+// StartArmDispatcher はアームのタスクが実行対象になったときに、軌道のウェイポイントを
+// ARMの制御コマンドとして順にFDへ配信します。ctx が終了するまで動作します。
+// 最後のウェイポイントの結果をFDが報告するとタスクを終了させ、タスクが中断された場合は残りのウェイポイントを破棄し、
+// 最後に配信した姿勢で停止する命令を送ります。ウェイポイントの結果がタイムアウトした場合も、タスクをタイムアウトで
+// 終了させて同じように停止させます。
+func (u *FDUsecase) StartArmDispatcher(ctx context.Context) {
+	u.startKeyframeDispatcher(ctx, keyframeDispatcher{
+		plan:         u.planArmKeyframes,
+		outlivesTask: false,
+		supersedes:   nil,
+	})
+}
+
+// planArmKeyframes は軌道のウェイポイントを、直前のウェイポイントへの到達予定時刻に送るキーフレームにします。
+func (u *FDUsecase) planArmKeyframes(cameraID, taskID string, _ *protov1.Task) *keyframePlan {
+	trajectory := u.ptzRepo.GetArmTrajectory(taskID)
+	if trajectory == nil {
+		return nil
+	}
+
+	keyframes := make([]keyframe, 0, len(trajectory.Waypoints))
+	for i, waypoint := range trajectory.Waypoints {
+		command := newArmControlCommand(
+			fmt.Sprintf("%s-%d", taskID, i+1),
+			cameraID,
+			&protov1.ArmParameters{JointAngles: waypoint.JointAngles, Speed: trajectory.Speed},
+		)
+		command.TimeoutMs = trajectory.TimeoutMs
+
+		keyframes = append(keyframes, keyframe{
+			command: command,
+			arrival: time.Duration(waypoint.OffsetMs) * time.Millisecond,
+		})
+	}
+
+	return &keyframePlan{
+		keyframes: keyframes,
+		finish:    keyframeFinishOnResult,
+		interrupted: func() {
+			u.repo.SendControlCommand(newArmControlCommand(
+				taskID+"-stop",
+				cameraID,
+				infrastructure.NewArmSafetyStop(u.repo.GetArmPose(cameraID)),
+			))
+		},
+	}
+}
+
+func newArmControlCommand(commandID, cameraID string, params *protov1.ArmParameters) *protov1.ControlCommand {
+	return &protov1.ControlCommand{
+		CommandId:     commandID,
+		CameraId:      cameraID,
+		Type:          protov1.ControlCommandType_CONTROL_COMMAND_TYPE_ARM,
+		PtzParameters: nil,
+		PresetNumber:  0,
+		FocusValue:    0,
+		ArmParameters: params,
+		TimeoutMs:     0,
+	}
+}
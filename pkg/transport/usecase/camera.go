@@ -3,6 +3,10 @@ package usecase
 import (
 	"context"
 	"errors"
+	"strconv"
+	"time"
+
+	"google.golang.org/protobuf/proto"
 
 	protov1 "github.com/anyfld/vistra-operation-control-room/gen/proto/v1"
 	"github.com/anyfld/vistra-operation-control-room/pkg/transport/infrastructure"
@@ -32,11 +36,12 @@ type CameraInteractor interface {
 var ErrCameraNotFound = errors.New("camera not found")
 
 type CameraUsecase struct {
-	repo *infrastructure.CameraRepo
+	repo      *infrastructure.CameraRepo
+	estimator *infrastructure.PTZEstimator
 }
 
-func NewCameraUsecase(repo *infrastructure.CameraRepo) *CameraUsecase {
-	return &CameraUsecase{repo: repo}
+func NewCameraUsecase(repo *infrastructure.CameraRepo, estimator *infrastructure.PTZEstimator) *CameraUsecase {
+	return &CameraUsecase{repo: repo, estimator: estimator}
 }
 
 func (u *CameraUsecase) RegisterCamera(
@@ -65,6 +70,8 @@ func (u *CameraUsecase) UpdateCamera(
 	return camera, nil
 }
 
+// GetCamera はカメラを返します。PTZを推定できる場合は、推定のPTZと信頼度、推定の基準にした報告の時刻をメタデータに設定します。
+// current_ptz は最後に報告されたPTZのままです。型付きの推定は PTZEstimateUsecase でも取得できます。
 func (u *CameraUsecase) GetCamera(
 	ctx context.Context,
 	cameraID string,
@@ -74,6 +81,10 @@ func (u *CameraUsecase) GetCamera(
 		return nil, nil, nil, nil
 	}
 
+	if estimate, ok := u.estimator.Estimate(cameraID, time.Now().UnixMilli()); ok {
+		camera = withPTZEstimate(camera, estimate)
+	}
+
 	connection := u.repo.GetConnection(cameraID)
 	capabilities := u.repo.GetCapabilities(cameraID)
 
@@ -110,6 +121,8 @@ func (u *CameraUsecase) UpdateCameraState(
 		return false, ErrCameraNotFound
 	}
 
+	u.estimator.Observe(cameraID, ptz, time.Now().UnixMilli())
+
 	return true, nil
 }
 
@@ -146,3 +159,29 @@ func (u *CameraUsecase) CheckAndUpdateDisconnectedCameras(ctx context.Context) e
 
 	return nil
 }
+
+func withPTZEstimate(camera *protov1.Camera, estimate *infrastructure.PTZEstimate) *protov1.Camera {
+	cloned, ok := proto.Clone(camera).(*protov1.Camera)
+	if !ok {
+		return camera
+	}
+
+	if cloned.Metadata == nil {
+		cloned.Metadata = make(map[string]string)
+	}
+
+	cloned.Metadata[infrastructure.CameraMetadataEstimatedPan] = formatFloat32(estimate.PTZ.GetPan())
+	cloned.Metadata[infrastructure.CameraMetadataEstimatedTilt] = formatFloat32(estimate.PTZ.GetTilt())
+	cloned.Metadata[infrastructure.CameraMetadataEstimatedZoom] = formatFloat32(estimate.PTZ.GetZoom())
+	cloned.Metadata[infrastructure.CameraMetadataEstimatedConfidence] = strconv.FormatFloat(
+		estimate.Confidence, 'f', 3, 64,
+	)
+	cloned.Metadata[infrastructure.CameraMetadataEstimatedMoving] = strconv.FormatBool(estimate.Moving)
+	cloned.Metadata[infrastructure.CameraMetadataEstimatedObservedAt] = strconv.FormatInt(estimate.ObservedAtMs, 10)
+
+	return cloned
+}
+
+func formatFloat32(value float32) string {
+	return strconv.FormatFloat(float64(value), 'f', -1, 32)
+}
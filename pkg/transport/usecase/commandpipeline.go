@@ -0,0 +1,152 @@
+package usecase
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	protov1 "github.com/anyfld/vistra-operation-control-room/gen/proto/v1"
+	"github.com/anyfld/vistra-operation-control-room/pkg/transport/infrastructure"
+)
+
+// interruptedCommandMessage は上位の命令で中断されたタスクの制御コマンドに設定するエラーメッセージです。
+const interruptedCommandMessage = "interrupted by a higher priority command"
+
+// dispatchControlCommand は制御コマンドをFDへ配信します。PTZ命令で表せる移動系の制御コマンドは、
+// カメラのキューへ PTZ枠（Layer 1）のタスクとして追加し、タスクが実行対象になった時点で配信します。
+// ポーリングするFDにも同じ命令がタスクとして届きます。
+// 移動系の制御コマンドはカメラの制限で確認し、補正した場合は補正した命令を配信して理由を Adjustment に設定し、
+// 拒否する場合は配信せずに失敗として記録します。
+func (u *FDUsecase) dispatchControlCommand(command *protov1.ControlCommand) *infrastructure.ControlCommandStatus {
+	return u.dispatchMove(command, command)
+}
+
+// dispatchMove は移動 move をカメラの制限で確認してキューのPTZ枠に追加し、制御コマンド sent をFDへ配信します。
+// sent と move が異なるのはハードウェアのプリセットの呼び出しで、FDへはプリセットの番号を送り、
+// 制限の確認とキューにはプリセットに保存した位置への絶対移動を使います。
+// 保存した位置を制限の内側へ補正した場合は、プリセットの番号ではなく補正した絶対移動を送ります。
+func (u *FDUsecase) dispatchMove(sent, move *protov1.ControlCommand) *infrastructure.ControlCommandStatus {
+	cameraID := sent.GetCameraId()
+	if cameraID == "" {
+		return u.repo.SendControlCommand(sent)
+	}
+
+	if sent.GetCommandId() == "" {
+		sent.CommandId = fmt.Sprintf("cmd-%d", time.Now().UnixNano())
+	}
+
+	move.CommandId = sent.GetCommandId()
+
+	checked, adjustment, err := u.limits.checkControlCommand(move)
+	if err != nil {
+		return u.rejectControlCommand(sent, err)
+	}
+
+	if sent == move || adjustment != "" {
+		sent = checked
+	}
+
+	var status *infrastructure.ControlCommandStatus
+
+	if ptz := u.cameraOptics(cameraID).PTZCommandFromControl(checked); ptz != nil {
+		// タスクの開始で配信できるよう、キューへ追加する前に記録する
+		status = u.repo.QueueControlCommand(sent)
+
+		stop := checked.GetType() == protov1.ControlCommandType_CONTROL_COMMAND_TYPE_PTZ_STOP
+		if u.ptzRepo.EnqueueControlCommand(cameraID, sent, ptz, stop) {
+			status = u.repo.DispatchQueuedControlCommand(sent)
+		}
+	} else {
+		status = u.repo.SendControlCommand(sent)
+	}
+
+	status.Adjustment = adjustment
+
+	return status
+}
+
+// StartCommandPipeline はカメラのキューと制御コマンドを同期させます。ctx が終了するまで動作します。
+// イベントは EventBus.Listen で破棄せずに受け取り、制御コマンドとタスクの対応はイベントを配信した時点の
+// Event.ControlCommand で判定します。
+//
+//   - 制御コマンドから作成したタスクが実行対象になると、記録しておいた制御コマンドをFDへ配信します。
+//     キューの後ろで待っている間は配信せず、タイムアウトも実行対象になった時点から数えます。
+//   - PTZ命令を持つタスクが実行対象になると、タスクIDをコマンドIDとする制御コマンドとしてFDへ配信します。
+//   - ポーリングでタスクの完了が報告されると、対応する制御コマンドを成功として終了します。
+//   - タスクが中断されると、対応する制御コマンドを失敗として終了し、その結果をFDへ配信します。
+//   - 制御コマンドから作成したタスクは、制御コマンドが失敗・タイムアウトするとキューから取り除きます。
+func (u *FDUsecase) StartCommandPipeline(ctx context.Context) {
+	listener := u.events.Listen(infrastructure.EventTopicTask)
+
+	go func() {
+		defer u.events.Unlisten(listener)
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case event, ok := <-listener.Events():
+				if !ok {
+					return
+				}
+
+				u.handlePipelineEvent(event)
+			}
+		}
+	}()
+}
+
+func (u *FDUsecase) handlePipelineEvent(event *infrastructure.Event) {
+	switch event.Type {
+	case infrastructure.EventTaskStarted:
+		if event.IsControlCommandTask() {
+			u.repo.DispatchQueuedControlCommand(event.ControlCommand)
+
+			return
+		}
+
+		task, ok := event.Payload.(*protov1.Task)
+		if !ok || task.GetPtzCommand() == nil {
+			return
+		}
+
+		command := u.cameraOptics(event.CameraID).ControlCommandFromPTZ(
+			event.ResourceID, event.CameraID, task.GetPtzCommand(),
+		)
+		if command != nil {
+			u.repo.SendControlCommand(command)
+		}
+	case infrastructure.EventTaskCompleted:
+		u.finishTaskCommand(event.ResourceID, true, "")
+	case infrastructure.EventTaskInterrupted:
+		u.finishTaskCommand(event.ResourceID, false, interruptedCommandMessage)
+	case infrastructure.EventControlCommandFailed:
+		if event.IsControlCommandTask() {
+			u.ptzRepo.FinishTask(event.CameraID, event.ResourceID, infrastructure.TaskOutcomeFailed, nil)
+		}
+	case infrastructure.EventControlCommandTimedOut:
+		if event.IsControlCommandTask() {
+			u.ptzRepo.FinishTask(event.CameraID, event.ResourceID, infrastructure.TaskOutcomeTimedOut, nil)
+		}
+	}
+}
+
+// finishTaskCommand はタスクに対応する制御コマンドが終了していなければ、結果を記録してFDへ配信します。
+func (u *FDUsecase) finishTaskCommand(taskID string, success bool, errorMessage string) {
+	status := u.repo.GetControlCommandStatus(taskID)
+	if status == nil || status.State.IsTerminal() {
+		return
+	}
+
+	_, _ = u.repo.ReportControlCommandResult(&protov1.ControlCommandResult{
+		CommandId:       taskID,
+		Success:         success,
+		ErrorMessage:    errorMessage,
+		ResultingPtz:    nil,
+		ExecutionTimeMs: 0,
+	})
+}
+
+func (u *FDUsecase) cameraOptics(cameraID string) *infrastructure.CameraOptics {
+	return u.cameraRepo.GetOptics(cameraID)
+}
@@ -2,9 +2,13 @@ package usecase
 
 import (
 	"context"
+	"errors"
+	"log"
+	"time"
 
 	protov1 "github.com/anyfld/vistra-operation-control-room/gen/proto/v1"
 	"github.com/anyfld/vistra-operation-control-room/pkg/transport/infrastructure"
+	"google.golang.org/protobuf/proto"
 )
 
 type CRInteractor interface {
@@ -12,21 +16,61 @@ type CRInteractor interface {
 	UnregisterMasterMF(ctx context.Context, id string) (bool, error)
 	ListMasterMFs(ctx context.Context) ([]*protov1.MasterMF, error)
 	GetMasterMF(ctx context.Context, id string) (*protov1.MasterMF, error)
-	ListAllCameras(ctx context.Context) ([]*protov1.Camera, error)
+	// ListAllCameras はローカルと子CRのカメラを返します。カメラ一覧を取得できなかった子CRのIDも返します。
+	ListAllCameras(ctx context.Context) ([]*protov1.Camera, []string, error)
 	GetCamera(ctx context.Context, id string) (*protov1.Camera, error)
+	GetSystemStatus(ctx context.Context) (*protov1.SystemStatus, error)
 	PushConfiguration(ctx context.Context, cfg *protov1.Configuration, targetMasterMfIds []string) (bool, []string)
 	GetConfiguration(ctx context.Context, masterMfId string) *protov1.Configuration
 	SendCinematographyInstruction(ctx context.Context,
 		req *protov1.SendCinematographyInstructionRequest,
 	) (*protov1.SendCinematographyInstructionResponse, error)
+	SubscribeCinematographyResults(ctx context.Context) (<-chan *protov1.CinematographyResult, error)
+	UnsubscribeCinematographyResults(ctx context.Context, ch <-chan *protov1.CinematographyResult) error
+}
+
+var (
+	ErrInstructionRequired = errors.New("instruction is required")
+	ErrCameraIDRequired    = errors.New("camera_id is required")
+)
+
+// cinematographyFailureMessages はシネマティックのタスクが完了せずに終了した理由ごとの、結果のエラーメッセージです。
+var cinematographyFailureMessages = map[infrastructure.TaskOutcome]string{ //nolint:gochecknoglobals
+	infrastructure.TaskOutcomePreempted: "interrupted by PTZ command",
+	infrastructure.TaskOutcomeReplaced:  "replaced by a newer tracking command",
+	infrastructure.TaskOutcomeFailed:    "failed on fd",
+	infrastructure.TaskOutcomeTimedOut:  "timed out waiting for the result from fd",
+}
+
+// healthSeverity はシステム状態を集約するときの重大度です。列挙値の番号の順序には依存しません。
+var healthSeverity = map[protov1.SystemHealthStatus]int{ //nolint:gochecknoglobals
+	protov1.SystemHealthStatus_SYSTEM_HEALTH_STATUS_HEALTHY:   0,
+	protov1.SystemHealthStatus_SYSTEM_HEALTH_STATUS_DEGRADED:  1,
+	protov1.SystemHealthStatus_SYSTEM_HEALTH_STATUS_UNHEALTHY: 2, //nolint:mnd
 }
 
 type CRUsecase struct {
-	repo *infrastructure.InMemoryRepo
+	repo           *infrastructure.InMemoryRepo
+	ptzRepo        *infrastructure.PTZRepo
+	cameraRepo     *infrastructure.CameraRepo
+	limits         ptzLimitChecker
+	federationRepo *infrastructure.FederationRepo
 }
 
-func New(repo *infrastructure.InMemoryRepo) *CRUsecase {
-	return &CRUsecase{repo: repo}
+func New(
+	repo *infrastructure.InMemoryRepo,
+	ptzRepo *infrastructure.PTZRepo,
+	cameraRepo *infrastructure.CameraRepo,
+	estimator *infrastructure.PTZEstimator,
+	federationRepo *infrastructure.FederationRepo,
+) *CRUsecase {
+	return &CRUsecase{
+		repo:           repo,
+		ptzRepo:        ptzRepo,
+		cameraRepo:     cameraRepo,
+		limits:         newPTZLimitChecker(cameraRepo, estimator),
+		federationRepo: federationRepo,
+	}
 }
 
 func (u *CRUsecase) RegisterMasterMF(
@@ -58,15 +102,80 @@ func (u *CRUsecase) GetMasterMF(
 
 func (u *CRUsecase) ListAllCameras(
 	ctx context.Context,
-) ([]*protov1.Camera, error) {
-	return u.repo.ListAllCameras(), nil
+) ([]*protov1.Camera, []string, error) {
+	cameras := u.cameraRepo.ListCameras("", nil, nil)
+
+	if !u.federationEnabled(ctx) {
+		return cameras, nil, nil
+	}
+
+	remote, failedPeerIDs := u.federationRepo.ListRemoteCameras(ctx)
+	for _, remoteCamera := range remote {
+		cameras = append(cameras, withFederationPeer(remoteCamera.Camera, remoteCamera.PeerID))
+	}
+
+	return cameras, failedPeerIDs, nil
 }
 
 func (u *CRUsecase) GetCamera(
 	ctx context.Context,
 	id string,
 ) (*protov1.Camera, error) {
-	return u.repo.GetCamera(id), nil
+	if camera := u.cameraRepo.GetCamera(id); camera != nil {
+		return camera, nil
+	}
+
+	if !u.federationEnabled(ctx) {
+		return nil, nil
+	}
+
+	peer, ok := u.federationRepo.FindCameraOwner(ctx, id)
+	if !ok {
+		return nil, nil
+	}
+
+	camera, err := u.federationRepo.GetCamera(ctx, peer, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if camera == nil {
+		return nil, nil
+	}
+
+	return withFederationPeer(camera, peer.ID), nil
+}
+
+// GetSystemStatus はローカルと全ての子CRの状態を集約したシステム状態を返します。
+// 子CRへは並行して問い合わせ、到達できない、または時間内に応答しない子CRがある場合、全体の状態は DEGRADED 以下になります。
+func (u *CRUsecase) GetSystemStatus(ctx context.Context) (*protov1.SystemStatus, error) {
+	status := &protov1.SystemStatus{
+		Health:              protov1.SystemHealthStatus_SYSTEM_HEALTH_STATUS_HEALTHY,
+		OnlineMasterMfCount: countOnlineMasterMFs(u.repo.ListMasterMFs()),
+		OnlineCameraCount:   countOnlineCameras(u.cameraRepo.ListCameras("", nil, nil)),
+		ActiveStreamCount:   0,
+		UpdatedAtMs:         time.Now().UnixMilli(),
+	}
+
+	if !u.federationEnabled(ctx) {
+		return status, nil
+	}
+
+	for _, peer := range u.federationRepo.ListPeerSystemStatuses(ctx) {
+		if peer.Err != nil {
+			status.Health = worseHealth(status.GetHealth(), protov1.SystemHealthStatus_SYSTEM_HEALTH_STATUS_DEGRADED)
+
+			continue
+		}
+
+		peerStatus := peer.Status
+		status.Health = worseHealth(status.GetHealth(), peerStatus.GetHealth())
+		status.OnlineMasterMfCount += peerStatus.GetOnlineMasterMfCount()
+		status.OnlineCameraCount += peerStatus.GetOnlineCameraCount()
+		status.ActiveStreamCount += peerStatus.GetActiveStreamCount()
+	}
+
+	return status, nil
 }
 
 func (u *CRUsecase) PushConfiguration(
@@ -88,5 +197,164 @@ func (u *CRUsecase) SendCinematographyInstruction(
 	ctx context.Context,
 	req *protov1.SendCinematographyInstructionRequest,
 ) (*protov1.SendCinematographyInstructionResponse, error) {
-	return u.repo.SendCinematographyInstruction(req), nil
+	instruction := req.GetInstruction()
+	if instruction == nil {
+		return nil, ErrInstructionRequired
+	}
+
+	cameraID := instruction.GetCameraId()
+	if cameraID == "" {
+		return nil, ErrCameraIDRequired
+	}
+
+	if u.cameraRepo.GetCamera(cameraID) == nil {
+		if !u.federationEnabled(ctx) {
+			return nil, ErrCameraNotFound
+		}
+
+		peer, ok := u.federationRepo.FindCameraOwner(ctx, cameraID)
+		if !ok {
+			return nil, ErrCameraNotFound
+		}
+
+		return u.federationRepo.SendCinematographyInstruction(ctx, peer, req)
+	}
+
+	instruction, adjustment, err := u.limits.checkInstruction(cameraID, instruction)
+	if err != nil {
+		return nil, err
+	}
+
+	if adjustment != "" {
+		log.Printf("cinematography instruction adjusted: camera_id=%s reason=%s", cameraID, adjustment)
+	}
+
+	instructionID := u.repo.AssignInstructionID(instruction)
+
+	taskID, accepted := u.ptzRepo.EnqueueCinematicCommand(cameraID, instruction)
+	if accepted {
+		u.repo.TrackCinematographyTask(taskID, instruction)
+	}
+
+	return &protov1.SendCinematographyInstructionResponse{
+		Accepted:      accepted,
+		InstructionId: instructionID,
+	}, nil
+}
+
+func (u *CRUsecase) SubscribeCinematographyResults(
+	ctx context.Context,
+) (<-chan *protov1.CinematographyResult, error) {
+	return u.repo.SubscribeCinematographyResults(), nil
+}
+
+func (u *CRUsecase) UnsubscribeCinematographyResults(
+	ctx context.Context,
+	ch <-chan *protov1.CinematographyResult,
+) error {
+	u.repo.UnsubscribeCinematographyResults(ch)
+
+	return nil
+}
+
+// StartCollectingCinematographyResults はFDからのシネマティックタスク完了報告を
+// CinematographyResult として収集するゴルーチンを開始します。ctx のキャンセルで停止します。
+func (u *CRUsecase) StartCollectingCinematographyResults(ctx context.Context) {
+	eventCh := u.ptzRepo.SubscribeTaskEvents()
+
+	go u.collectCinematographyResults(ctx, eventCh)
+}
+
+func (u *CRUsecase) collectCinematographyResults(ctx context.Context, eventCh <-chan *infrastructure.TaskEvent) {
+	defer u.ptzRepo.UnsubscribeTaskEvents(eventCh)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case event, ok := <-eventCh:
+			if !ok {
+				return
+			}
+
+			u.collectTaskEvent(event)
+		}
+	}
+}
+
+func (u *CRUsecase) collectTaskEvent(event *infrastructure.TaskEvent) {
+	task := event.Task
+	if task.GetLayer() != protov1.CommandLayer_COMMAND_LAYER_CINEMATIC {
+		return
+	}
+
+	success := event.Outcome == infrastructure.TaskOutcomeCompleted
+
+	errorMessage := ""
+	if !success {
+		errorMessage = cinematographyFailureMessages[event.Outcome]
+	}
+
+	u.repo.CompleteCinematographyTask(task.GetTaskId(), success, errorMessage, event.CurrentPTZ)
+}
+
+func (u *CRUsecase) federationEnabled(ctx context.Context) bool {
+	return u.federationRepo.HasPeers() && !infrastructure.IsFederatedRequest(ctx)
+}
+
+func withFederationPeer(camera *protov1.Camera, peerID string) *protov1.Camera {
+	cloned, ok := proto.Clone(camera).(*protov1.Camera)
+	if !ok {
+		return camera
+	}
+
+	if cloned.Metadata == nil {
+		cloned.Metadata = make(map[string]string)
+	}
+
+	cloned.Metadata[infrastructure.FederationPeerMetadataKey] = peerID
+
+	return cloned
+}
+
+func countOnlineMasterMFs(mfs []*protov1.MasterMF) uint32 {
+	var count uint32
+
+	for _, mf := range mfs {
+		if mf.GetStatus() == protov1.MasterMFStatus_MASTER_MF_STATUS_ONLINE {
+			count++
+		}
+	}
+
+	return count
+}
+
+func countOnlineCameras(cameras []*protov1.Camera) uint32 {
+	var count uint32
+
+	for _, camera := range cameras {
+		switch camera.GetStatus() {
+		case protov1.CameraStatus_CAMERA_STATUS_ONLINE, protov1.CameraStatus_CAMERA_STATUS_STREAMING:
+			count++
+		case protov1.CameraStatus_CAMERA_STATUS_UNSPECIFIED,
+			protov1.CameraStatus_CAMERA_STATUS_OFFLINE,
+			protov1.CameraStatus_CAMERA_STATUS_ERROR:
+		}
+	}
+
+	return count
+}
+
+// worseHealth は current と other のうち、healthSeverity の重大度が高い方を返します。
+// 重大度を持たない状態（UNSPECIFIED など）は状態が不明なため DEGRADED とみなします。
+func worseHealth(current, other protov1.SystemHealthStatus) protov1.SystemHealthStatus {
+	if _, ok := healthSeverity[other]; !ok {
+		other = protov1.SystemHealthStatus_SYSTEM_HEALTH_STATUS_DEGRADED
+	}
+
+	if healthSeverity[other] > healthSeverity[current] {
+		return other
+	}
+
+	return current
 }
@@ -0,0 +1,118 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	protov1 "github.com/anyfld/vistra-operation-control-room/gen/proto/v1"
+	"github.com/anyfld/vistra-operation-control-room/pkg/transport/infrastructure"
+)
+
+// StartPTZEstimation はカメラに配信した移動の命令を、PTZの推定に反映します。ctx が終了するまで動作します。
+// タスクは実行対象になった時点、制御コマンドはFDへ配信した時点で移動を開始したものとし、
+// 完了・中断・失敗・タイムアウトで移動を終えたものとします。
+func (u *FDUsecase) StartPTZEstimation(ctx context.Context) {
+	listener := u.events.Listen(infrastructure.EventTopicTask)
+
+	go func() {
+		defer u.events.Unlisten(listener)
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case event, ok := <-listener.Events():
+				if !ok {
+					return
+				}
+
+				u.handleEstimationEvent(event)
+			}
+		}
+	}()
+}
+
+func (u *FDUsecase) handleEstimationEvent(event *infrastructure.Event) {
+	switch event.Type {
+	case infrastructure.EventTaskStarted:
+		task, ok := event.Payload.(*protov1.Task)
+		if !ok || task.GetPtzCommand() == nil {
+			return
+		}
+
+		optics := u.cameraOptics(event.CameraID)
+		if command := optics.ControlCommandFromPTZ(
+			event.ResourceID, event.CameraID, task.GetPtzCommand(),
+		); command != nil {
+			u.estimator.StartMotion(event.CameraID, event.ResourceID, command, optics, event.TimestampMs)
+		}
+	case infrastructure.EventControlCommandDispatched:
+		if command, ok := event.Payload.(*protov1.ControlCommand); ok {
+			u.estimator.StartMotion(
+				event.CameraID, event.ResourceID, command, u.cameraOptics(event.CameraID), event.TimestampMs,
+			)
+		}
+	case infrastructure.EventTaskCompleted, infrastructure.EventTaskInterrupted:
+		u.estimator.StopMotion(
+			event.CameraID, event.ResourceID, event.Type == infrastructure.EventTaskCompleted, nil, event.TimestampMs,
+		)
+	case infrastructure.EventControlCommandCompleted,
+		infrastructure.EventControlCommandFailed,
+		infrastructure.EventControlCommandTimedOut:
+		result, _ := event.Payload.(*protov1.ControlCommandResult)
+		u.estimator.StopMotion(
+			event.CameraID,
+			event.ResourceID,
+			event.Type == infrastructure.EventControlCommandCompleted,
+			result.GetResultingPtz(),
+			event.TimestampMs,
+		)
+	}
+}
+
+// estimatedPTZ はカメラの推定のPTZを返します。推定できない場合は最後に報告されたPTZを返します。
+func (u *FDUsecase) estimatedPTZ(camera *protov1.Camera, atMs int64) *protov1.PTZParameters {
+	if estimate, ok := u.estimator.Estimate(camera.GetId(), atMs); ok {
+		return estimate.PTZ
+	}
+
+	return camera.GetCurrentPtz()
+}
+
+var ErrPTZEstimateNotFound = errors.New("ptz estimate not found")
+
+type PTZEstimateInteractor interface {
+	GetPTZEstimate(ctx context.Context, cameraID string) (*infrastructure.PTZEstimate, error)
+}
+
+// PTZEstimateUsecase はカメラの推定のPTZを、信頼度と推定の時刻と共に返します。
+type PTZEstimateUsecase struct {
+	cameraRepo *infrastructure.CameraRepo
+	estimator  *infrastructure.PTZEstimator
+}
+
+func NewPTZEstimateUsecase(
+	cameraRepo *infrastructure.CameraRepo,
+	estimator *infrastructure.PTZEstimator,
+) *PTZEstimateUsecase {
+	return &PTZEstimateUsecase{cameraRepo: cameraRepo, estimator: estimator}
+}
+
+// GetPTZEstimate は現在時刻の推定のPTZを返します。状態の報告を一度も受けていない場合は ErrPTZEstimateNotFound を返します。
+func (u *PTZEstimateUsecase) GetPTZEstimate(
+	ctx context.Context,
+	cameraID string,
+) (*infrastructure.PTZEstimate, error) {
+	if u.cameraRepo.GetCamera(cameraID) == nil {
+		return nil, fmt.Errorf("%w: %s", ErrCameraNotFound, cameraID)
+	}
+
+	estimate, ok := u.estimator.Estimate(cameraID, time.Now().UnixMilli())
+	if !ok {
+		return nil, fmt.Errorf("%w: camera %s", ErrPTZEstimateNotFound, cameraID)
+	}
+
+	return estimate, nil
+}
@@ -0,0 +1,48 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+
+	"github.com/anyfld/vistra-operation-control-room/pkg/transport/infrastructure"
+)
+
+type EventInteractor interface {
+	SubscribeEvents(ctx context.Context, topics []string) (*infrastructure.EventSubscription, error)
+	UnsubscribeEvents(ctx context.Context, subscription *infrastructure.EventSubscription)
+}
+
+var ErrInvalidEventTopic = errors.New("invalid event topic")
+
+type EventUsecase struct {
+	bus *infrastructure.EventBus
+}
+
+func NewEventUsecase(bus *infrastructure.EventBus) *EventUsecase {
+	return &EventUsecase{bus: bus}
+}
+
+// SubscribeEvents は指定したトピックのイベントを購読します。空の場合は全トピックを購読します。
+func (u *EventUsecase) SubscribeEvents(
+	ctx context.Context,
+	topics []string,
+) (*infrastructure.EventSubscription, error) {
+	eventTopics := make([]infrastructure.EventTopic, 0, len(topics))
+
+	for _, topic := range topics {
+		eventTopic := infrastructure.EventTopic(topic)
+		if !slices.Contains(infrastructure.AllEventTopics, eventTopic) {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidEventTopic, topic)
+		}
+
+		eventTopics = append(eventTopics, eventTopic)
+	}
+
+	return u.bus.Subscribe(eventTopics...), nil
+}
+
+func (u *EventUsecase) UnsubscribeEvents(ctx context.Context, subscription *infrastructure.EventSubscription) {
+	u.bus.Unsubscribe(subscription)
+}
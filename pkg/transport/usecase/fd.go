@@ -2,11 +2,17 @@ package usecase
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"image"
+	"time"
 
 	protov1 "github.com/anyfld/vistra-operation-control-room/gen/proto/v1"
 	"github.com/anyfld/vistra-operation-control-room/pkg/transport/infrastructure"
 )
 
+var ErrPTZParametersRequired = errors.New("ptz_parameters is required for absolute and relative moves")
+
 type FDInteractor interface { //nolint:interfacebloat
 	ExecuteCinematography(
 		ctx context.Context,
@@ -25,10 +31,61 @@ type FDInteractor interface { //nolint:interfacebloat
 		req *protov1.StartPatternMatchingRequest,
 	) (string, error)
 	StopPatternMatching(ctx context.Context, sessionID string) (bool, error)
-	GetPatternMatchingSession(
+	PushPatternMatchingFrame(ctx context.Context, sessionID string, frame *protov1.ImageData) error
+	SubscribePatternMatchResults(
+		ctx context.Context,
+		sessionID string,
+	) (<-chan *protov1.StreamPatternMatchResultsResponse, error)
+	UnsubscribePatternMatchResults(
 		ctx context.Context,
 		sessionID string,
-	) (string, string, []*protov1.Subject, uint32, error)
+		ch <-chan *protov1.StreamPatternMatchResultsResponse,
+	)
+	StartTracking(
+		ctx context.Context,
+		cameraID string,
+		subject *protov1.Subject,
+		intervalMs uint32,
+		config infrastructure.TrackingConfig,
+	) (*infrastructure.TrackingSession, error)
+	StopTracking(ctx context.Context, trackingID string) (bool, error)
+	GetTracking(ctx context.Context, trackingID string) (*infrastructure.TrackingSession, error)
+	EnqueueArmMove(
+		ctx context.Context,
+		cameraID string,
+		params *protov1.ArmParameters,
+		timeoutMs uint32,
+		layer protov1.CommandLayer,
+	) (string, *infrastructure.ArmTrajectory, error)
+	SetFocusMode(
+		ctx context.Context,
+		cameraID string,
+		mode infrastructure.FocusMode,
+	) (*infrastructure.ControlCommandStatus, error)
+	GetFocusState(ctx context.Context, cameraID string) (*infrastructure.FocusState, error)
+	EnqueueFocusMove(
+		ctx context.Context,
+		cameraID string,
+		focus *infrastructure.FocusMove,
+		layer protov1.CommandLayer,
+	) (string, error)
+	EnqueueAbsoluteMove(
+		ctx context.Context,
+		cameraID string,
+		move *protov1.AbsoluteMoveCommand,
+		focus *infrastructure.FocusMove,
+	) (string, string, error)
+	PlanPTZTrajectory(
+		ctx context.Context,
+		cameraID string,
+		spec *infrastructure.PTZTrajectorySpec,
+	) (*infrastructure.PTZTrajectory, error)
+	EnqueuePTZTrajectory(
+		ctx context.Context,
+		cameraID string,
+		spec *infrastructure.PTZTrajectorySpec,
+		layer protov1.CommandLayer,
+	) (string, *infrastructure.PTZTrajectory, error)
 	CalculateFraming(
 		ctx context.Context,
 		req *protov1.CalculateFramingRequest,
@@ -36,11 +93,24 @@ type FDInteractor interface { //nolint:interfacebloat
 	SendControlCommand(
 		ctx context.Context,
 		command *protov1.ControlCommand,
-	) (*protov1.ControlCommandResult, error)
+	) (*infrastructure.ControlCommandStatus, error)
+	ReportControlCommandResult(
+		ctx context.Context,
+		result *protov1.ControlCommandResult,
+	) (*infrastructure.ControlCommandStatus, error)
+	GetControlCommandStatus(
+		ctx context.Context,
+		commandID string,
+		wait bool,
+	) (*infrastructure.ControlCommandStatus, error)
 	GetControlCommand(
 		ctx context.Context,
-		cameraID string,
+		commandID string,
 	) (*protov1.ControlCommand, error)
+	ListControlCommands(
+		ctx context.Context,
+		filter infrastructure.ControlCommandFilter,
+	) ([]*infrastructure.ControlCommandStatus, error)
 	ReportCameraState(
 		ctx context.Context,
 		state *protov1.CameraState,
@@ -52,35 +122,72 @@ type FDInteractor interface { //nolint:interfacebloat
 	SubscribePTZCommands(
 		ctx context.Context,
 		cameraID string,
-	) (<-chan *infrastructure.PTZCommandEvent, error)
+		afterSequence uint64,
+	) (*infrastructure.PTZSubscription, error)
 	UnsubscribePTZCommands(
 		ctx context.Context,
-		cameraID string,
-		ch <-chan *infrastructure.PTZCommandEvent,
+		subscription *infrastructure.PTZSubscription,
 	) error
 }
 
+// Detector は画像から被写体を検出します。
+// 検出できなかった被写体は結果に含めません。バウンディングボックスは画像に対する割合 (0.0-1.0) です。
+type Detector interface {
+	Detect(ctx context.Context, frame image.Image, subjects []*protov1.Subject) ([]*protov1.DetectedSubject, error)
+}
+
 type FDUsecase struct {
-	repo *infrastructure.FDRepo
+	repo       *infrastructure.FDRepo
+	cameraRepo *infrastructure.CameraRepo
+	ptzRepo    *infrastructure.PTZRepo
+	telemetry  *infrastructure.TelemetryRepo
+	estimator  *infrastructure.PTZEstimator
+	limits     ptzLimitChecker
+	events     *infrastructure.EventBus
+	presets    PresetInteractor
+	images     *infrastructure.ImageLoader
+	detector   Detector
 }
 
-func NewFDUsecase(repo *infrastructure.FDRepo) *FDUsecase {
-	return &FDUsecase{repo: repo}
+func NewFDUsecase(
+	repo *infrastructure.FDRepo,
+	cameraRepo *infrastructure.CameraRepo,
+	ptzRepo *infrastructure.PTZRepo,
+	telemetry *infrastructure.TelemetryRepo,
+	estimator *infrastructure.PTZEstimator,
+	events *infrastructure.EventBus,
+	presets PresetInteractor,
+	images *infrastructure.ImageLoader,
+	detector Detector,
+) *FDUsecase {
+	return &FDUsecase{
+		repo:       repo,
+		cameraRepo: cameraRepo,
+		ptzRepo:    ptzRepo,
+		telemetry:  telemetry,
+		estimator:  estimator,
+		limits:     newPTZLimitChecker(cameraRepo, estimator),
+		events:     events,
+		presets:    presets,
+		images:     images,
+		detector:   detector,
+	}
 }
 
+// SubscribePTZCommands はカメラのPTZ命令を購読します。afterSequence を指定すると、その次の通し番号から再開します。
 func (u *FDUsecase) SubscribePTZCommands(
 	ctx context.Context,
 	cameraID string,
-) (<-chan *infrastructure.PTZCommandEvent, error) {
-	return u.repo.SubscribePTZCommands(cameraID), nil
+	afterSequence uint64,
+) (*infrastructure.PTZSubscription, error) {
+	return u.repo.SubscribePTZCommands(cameraID, afterSequence)
 }
 
 func (u *FDUsecase) UnsubscribePTZCommands(
 	ctx context.Context,
-	cameraID string,
-	ch <-chan *infrastructure.PTZCommandEvent,
+	subscription *infrastructure.PTZSubscription,
 ) error {
-	u.repo.UnsubscribePTZCommands(cameraID, ch)
+	u.repo.UnsubscribePTZCommands(subscription)
 
 	return nil
 }
@@ -99,81 +206,176 @@ func (u *FDUsecase) GetCinematographyInstruction(
 	return u.repo.GetCinematographyInstruction(sourceFilter), nil
 }
 
+// ProcessImage は画像をデコードして被写体を検出します。処理時間はデコードと検出にかかった時間です。
 func (u *FDUsecase) ProcessImage(
 	ctx context.Context,
 	req *protov1.ProcessImageRequest,
 ) ([]*protov1.DetectedSubject, uint32, error) {
-	detected, processingTime := u.repo.ProcessImage(
-		req.GetImage(),
-		req.GetTargetSubjects(),
-	)
+	startedAt := time.Now()
 
-	return detected, processingTime, nil
-}
-
-func (u *FDUsecase) StartPatternMatching(
-	ctx context.Context,
-	req *protov1.StartPatternMatchingRequest,
-) (string, error) {
-	return u.repo.StartPatternMatching(
-		req.GetCameraId(),
-		req.GetTargetSubjects(),
-		req.GetIntervalMs(),
-	), nil
-}
+	detected, err := u.detectFrame(ctx, req.GetImage(), req.GetTargetSubjects())
+	if err != nil {
+		return nil, 0, err
+	}
 
-func (u *FDUsecase) StopPatternMatching(
-	ctx context.Context,
-	sessionID string,
-) (bool, error) {
-	return u.repo.StopPatternMatching(sessionID), nil
+	return detected, uint32(time.Since(startedAt).Milliseconds()), nil
 }
 
-func (u *FDUsecase) GetPatternMatchingSession(
+func (u *FDUsecase) detectFrame(
 	ctx context.Context,
-	sessionID string,
-) (string, string, []*protov1.Subject, uint32, error) {
-	session := u.repo.GetPatternMatchingSession(sessionID)
-	if session == nil {
-		return "", "", nil, 0, nil
+	image *protov1.ImageData,
+	subjects []*protov1.Subject,
+) ([]*protov1.DetectedSubject, error) {
+	frame, err := u.images.DecodeImageData(ctx, image)
+	if err != nil {
+		return nil, err
 	}
 
-	return session.SessionID, session.CameraID, session.TargetSubjects, session.IntervalMs, nil
+	return u.detector.Detect(ctx, frame, subjects)
 }
 
+// CalculateFraming はカメラの能力情報とメタデータの光学特性を使い、被写体を指定のショットで撮るPTZを計算します。
+// 現在のPTZが指定されていない場合は、カメラの推定のPTZ（推定できない場合は最後に報告したPTZ）を使います。
 func (u *FDUsecase) CalculateFraming(
 	ctx context.Context,
 	req *protov1.CalculateFramingRequest,
 ) (*protov1.PTZParameters, uint32, bool, string, error) {
-	ptz, timeMs, success, errMsg := u.repo.CalculateFraming(
-		req.GetCameraId(),
-		req.GetCurrentPtz(),
+	camera := u.cameraRepo.GetCamera(req.GetCameraId())
+	optics := u.cameraRepo.GetOptics(req.GetCameraId())
+
+	currentPtz := req.GetCurrentPtz()
+	if currentPtz == nil {
+		currentPtz = u.estimatedPTZ(camera, time.Now().UnixMilli())
+	}
+
+	ptz, timeMs, err := infrastructure.SolveFraming(
+		optics,
+		currentPtz,
 		req.GetTargetShotType(),
 		req.GetTargetSubjects(),
 		req.GetTargetAngle(),
 	)
+	if err != nil {
+		return nil, 0, false, err.Error(), nil
+	}
 
-	return ptz, timeMs, success, errMsg, nil
+	return ptz, timeMs, true, "", nil
 }
 
+// SendControlCommand は制御コマンドをFDへ配信し、配信した時点の状態を返します。
+// プリセット系のコマンドはサーバー側のプリセットで解決し、ARMのコマンドはアームの関節構成で、
+// FOCUSのコマンドはフォーカス値とオートフォーカスへの対応で検証します。
+// 移動系のコマンドはカメラの制限で確認し、カメラのキューにも PTZ枠のタスクとして追加します（dispatchControlCommand）。
+// ptz_parameters のない絶対移動・相対移動は配信せずに ErrPTZParametersRequired を返します。
+// FDの結果は GetControlCommandStatus で待つか、イベントバスの control_command.* イベントで受け取れます。
 func (u *FDUsecase) SendControlCommand(
 	ctx context.Context,
 	command *protov1.ControlCommand,
-) (*protov1.ControlCommandResult, error) {
-	return u.repo.SendControlCommand(command), nil
+) (*infrastructure.ControlCommandStatus, error) {
+	switch command.GetType() { //nolint:exhaustive
+	case protov1.ControlCommandType_CONTROL_COMMAND_TYPE_PRESET_SET:
+		return u.sendPresetSet(ctx, command)
+	case protov1.ControlCommandType_CONTROL_COMMAND_TYPE_PRESET_GOTO:
+		return u.sendPresetGoto(ctx, command)
+	case protov1.ControlCommandType_CONTROL_COMMAND_TYPE_ARM:
+		return u.sendArm(ctx, command)
+	case protov1.ControlCommandType_CONTROL_COMMAND_TYPE_FOCUS:
+		return u.sendFocus(ctx, command)
+	case protov1.ControlCommandType_CONTROL_COMMAND_TYPE_PTZ_ABSOLUTE,
+		protov1.ControlCommandType_CONTROL_COMMAND_TYPE_PTZ_RELATIVE:
+		if command.GetPtzParameters() == nil {
+			return nil, fmt.Errorf("%w: command %s", ErrPTZParametersRequired, command.GetCommandId())
+		}
+
+		return u.dispatchControlCommand(command), nil
+	default:
+		return u.dispatchControlCommand(command), nil
+	}
+}
+
+// ReportControlCommandResult はFDが報告した制御コマンドの結果を記録します。
+// 同じIDのタスクがカメラのキューにあれば、報告された成否でタスクも終了させます。
+func (u *FDUsecase) ReportControlCommandResult(
+	ctx context.Context,
+	result *protov1.ControlCommandResult,
+) (*infrastructure.ControlCommandStatus, error) {
+	status, err := u.repo.ReportControlCommandResult(result)
+	if err != nil {
+		return nil, err
+	}
+
+	u.ptzRepo.FinishTask(
+		status.Command.GetCameraId(),
+		result.GetCommandId(),
+		infrastructure.TaskOutcomeOf(status.State),
+		result.GetResultingPtz(),
+	)
+
+	return status, nil
 }
 
+// GetControlCommandStatus は制御コマンドの状態を返します。wait が true の場合は終了状態になるまで待ちます。
+func (u *FDUsecase) GetControlCommandStatus(
+	ctx context.Context,
+	commandID string,
+	wait bool,
+) (*infrastructure.ControlCommandStatus, error) {
+	if wait {
+		return u.repo.WaitControlCommand(ctx, commandID)
+	}
+
+	status := u.repo.GetControlCommandStatus(commandID)
+	if status == nil {
+		return nil, fmt.Errorf("%w: %s", infrastructure.ErrControlCommandNotFound, commandID)
+	}
+
+	return status, nil
+}
+
+// GetControlCommand はIDで制御コマンドを返します。
 func (u *FDUsecase) GetControlCommand(
 	ctx context.Context,
-	cameraID string,
+	commandID string,
 ) (*protov1.ControlCommand, error) {
-	return u.repo.GetControlCommand(cameraID), nil
+	command := u.repo.GetControlCommand(commandID)
+	if command == nil {
+		return nil, fmt.Errorf("%w: %s", infrastructure.ErrControlCommandNotFound, commandID)
+	}
+
+	return command, nil
+}
+
+// ListControlCommands は制御コマンドの履歴を新しい順に返します。
+func (u *FDUsecase) ListControlCommands(
+	ctx context.Context,
+	filter infrastructure.ControlCommandFilter,
+) ([]*infrastructure.ControlCommandStatus, error) {
+	return u.repo.ListControlCommands(filter), nil
 }
 
+// rejectControlCommand はFDへ配信せずに失敗として記録します。
+func (u *FDUsecase) rejectControlCommand(
+	command *protov1.ControlCommand,
+	err error,
+) *infrastructure.ControlCommandStatus {
+	return u.repo.FinishControlCommand(command, &protov1.ControlCommandResult{
+		CommandId:       command.GetCommandId(),
+		Success:         false,
+		ErrorMessage:    err.Error(),
+		ResultingPtz:    nil,
+		ExecutionTimeMs: 0,
+	})
+}
+
+// ReportCameraState はカメラの最新の状態を更新し、時系列とPTZの推定に記録します。
 func (u *FDUsecase) ReportCameraState(
 	ctx context.Context,
 	state *protov1.CameraState,
 ) (bool, error) {
+	now := time.Now().UnixMilli()
+	u.telemetry.RecordCameraState(state, now)
+	u.estimator.Observe(state.GetCameraId(), state.GetCurrentPtz(), now)
+
 	return u.repo.ReportCameraState(state), nil
 }
 
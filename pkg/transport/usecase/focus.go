@@ -0,0 +1,215 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	protov1 "github.com/anyfld/vistra-operation-control-room/gen/proto/v1"
+	"github.com/anyfld/vistra-operation-control-room/pkg/transport/infrastructure"
+)
+
+var ErrCameraFocusUnknown = errors.New("camera focus is unknown")
+
+// SetFocusMode はカメラのフォーカスをオートフォーカスまたは手動フォーカスに切り替えるFOCUS命令をFDへ配信します。
+// 手動フォーカスへの切り替えでは、FDが最後に報告したフォーカス値を保ちます。
+func (u *FDUsecase) SetFocusMode(
+	ctx context.Context,
+	cameraID string,
+	mode infrastructure.FocusMode,
+) (*infrastructure.ControlCommandStatus, error) {
+	if u.cameraRepo.GetCamera(cameraID) == nil {
+		return nil, fmt.Errorf("%w: %s", ErrCameraNotFound, cameraID)
+	}
+
+	var value float32
+
+	switch mode {
+	case infrastructure.FocusModeAuto:
+		if !u.cameraRepo.GetCapabilities(cameraID).GetSupportsAutofocus() {
+			return nil, fmt.Errorf("%w: %s", infrastructure.ErrAutofocusNotSupported, cameraID)
+		}
+
+		value = infrastructure.AutofocusValue
+	case infrastructure.FocusModeManual:
+		state := u.repo.GetCameraState(cameraID)
+		if state == nil {
+			return nil, fmt.Errorf("%w: camera %s has not reported its focus", ErrCameraFocusUnknown, cameraID)
+		}
+
+		value = state.GetCurrentFocus()
+	default:
+		return nil, fmt.Errorf("%w: unknown focus mode %q", infrastructure.ErrInvalidFocusCommand, mode)
+	}
+
+	return u.repo.SendControlCommand(newFocusControlCommand("", cameraID, value)), nil
+}
+
+// GetFocusState はカメラのフォーカスの状態を返します。FOCUS命令を配信していない場合、
+// オートフォーカスに対応するカメラはオートフォーカス、それ以外は手動フォーカスとみなします。
+func (u *FDUsecase) GetFocusState(ctx context.Context, cameraID string) (*infrastructure.FocusState, error) {
+	if u.cameraRepo.GetCamera(cameraID) == nil {
+		return nil, fmt.Errorf("%w: %s", ErrCameraNotFound, cameraID)
+	}
+
+	supportsAutofocus := u.cameraRepo.GetCapabilities(cameraID).GetSupportsAutofocus()
+
+	mode, ok := u.repo.GetFocusMode(cameraID)
+	if !ok {
+		mode = infrastructure.FocusModeManual
+		if supportsAutofocus {
+			mode = infrastructure.FocusModeAuto
+		}
+	}
+
+	state := u.repo.GetCameraState(cameraID)
+
+	return &infrastructure.FocusState{
+		CameraID:          cameraID,
+		Mode:              mode,
+		CurrentFocus:      state.GetCurrentFocus(),
+		HasCurrentFocus:   state != nil,
+		SupportsAutofocus: supportsAutofocus,
+	}, nil
+}
+
+// EnqueueFocusMove はフォーカスの移動（ラックフォーカス）をPTZキューのタスクとして追加します。
+// layer の扱いは EnqueueArmMove と同じで、シネマティック枠に追加した移動は前のシネマティック命令の後に実行されます。
+func (u *FDUsecase) EnqueueFocusMove(
+	ctx context.Context,
+	cameraID string,
+	focus *infrastructure.FocusMove,
+	layer protov1.CommandLayer,
+) (string, error) {
+	if u.cameraRepo.GetCamera(cameraID) == nil {
+		return "", fmt.Errorf("%w: %s", ErrCameraNotFound, cameraID)
+	}
+
+	if err := focus.Validate(); err != nil {
+		return "", err
+	}
+
+	taskID, _ := u.ptzRepo.EnqueueFocusCommand(cameraID, layer, focus)
+
+	return taskID, nil
+}
+
+// EnqueueAbsoluteMove はフォーカスの目標を持つ絶対移動を PTZ枠（Layer 1）のタスクとして追加します。
+// 絶対移動は SendPTZCommand と同様にカメラの制限で確認し、補正した場合は理由を返します。
+// focus はタスクが実行対象になったときに開始し、移動の完了を待たずに DurationMs かけて目標へ移動します。
+func (u *FDUsecase) EnqueueAbsoluteMove(
+	ctx context.Context,
+	cameraID string,
+	move *protov1.AbsoluteMoveCommand,
+	focus *infrastructure.FocusMove,
+) (string, string, error) {
+	if u.cameraRepo.GetCamera(cameraID) == nil {
+		return "", "", fmt.Errorf("%w: %s", ErrCameraNotFound, cameraID)
+	}
+
+	if focus != nil {
+		if err := focus.Validate(); err != nil {
+			return "", "", err
+		}
+	}
+
+	command, adjustment, err := u.limits.checkPTZCommand(cameraID, &protov1.PTZCommand{
+		OperationType: protov1.PTZOperationType_PTZ_OPERATION_TYPE_ABSOLUTE_MOVE,
+		Command:       &protov1.PTZCommand_AbsoluteMove{AbsoluteMove: move},
+	})
+	if err != nil {
+		return "", "", err
+	}
+
+	taskID, _ := u.ptzRepo.EnqueuePTZCommandWithFocus(cameraID, command, focus)
+
+	return taskID, adjustment, nil
+}
+
+// sendFocus はFOCUSの制御コマンドを検証してからFDへ配信します。
+// オートフォーカスへの切り替えは、カメラがオートフォーカスに対応する場合のみ受け付けます。
+func (u *FDUsecase) sendFocus(
+	ctx context.Context,
+	command *protov1.ControlCommand,
+) (*infrastructure.ControlCommandStatus, error) {
+	cameraID := command.GetCameraId()
+	if u.cameraRepo.GetCamera(cameraID) == nil {
+		return u.rejectControlCommand(command, fmt.Errorf("%w: %s", ErrCameraNotFound, cameraID)), nil
+	}
+
+	supportsAutofocus := u.cameraRepo.GetCapabilities(cameraID).GetSupportsAutofocus()
+	if err := infrastructure.ValidateFocusValue(command.GetFocusValue(), supportsAutofocus); err != nil {
+		return u.rejectControlCommand(command, err), nil
+	}
+
+	return u.repo.SendControlCommand(command), nil
+}
+
+// StartFocusDispatcher はフォーカスの移動を持つタスクが実行対象になったときに、移動のステップを
+// FOCUSの制御コマンドとして順にFDへ配信します。ctx が終了するまで動作します。
+//
+//   - 移動はタスクが完了しても最後のステップまで続け、フォーカスだけのタスクは最後のステップの到達予定時刻に完了させます。
+//   - タスクが中断された場合、同じカメラで次のフォーカスの移動が始まった場合、または移動と関係のないFOCUS命令が
+//     配信された場合は、残りのステップを破棄します。
+func (u *FDUsecase) StartFocusDispatcher(ctx context.Context) {
+	u.startKeyframeDispatcher(ctx, keyframeDispatcher{
+		plan:         u.planFocusKeyframes,
+		outlivesTask: true,
+		supersedes:   supersedesFocusRun,
+	})
+}
+
+// planFocusKeyframes はFDが最後に報告したフォーカス値から目標までのステップを、
+// 直前のステップへの到達予定時刻に送るキーフレームにします。
+func (u *FDUsecase) planFocusKeyframes(cameraID, taskID string, task *protov1.Task) *keyframePlan {
+	focus := u.ptzRepo.GetFocusMove(taskID)
+	if focus == nil {
+		return nil
+	}
+
+	state := u.repo.GetCameraState(cameraID)
+	steps := focus.Plan(state.GetCurrentFocus(), state != nil)
+
+	keyframes := make([]keyframe, 0, len(steps))
+	for i, step := range steps {
+		keyframes = append(keyframes, keyframe{
+			command: newFocusControlCommand(fmt.Sprintf("%s-%d", taskID, i+1), cameraID, step.Value),
+			arrival: time.Duration(step.OffsetMs) * time.Millisecond,
+		})
+	}
+
+	// フォーカスだけのタスクは PTZ命令もシネマティック命令も持たない。PTZ命令を持つタスクの完了はFDの報告に任せる
+	finish := keyframeFinishNone
+	if task.GetPtzCommand() == nil && task.GetCinematicCommand() == nil {
+		finish = keyframeFinishAtArrival
+	}
+
+	return &keyframePlan{keyframes: keyframes, finish: finish, interrupted: nil}
+}
+
+// supersedesFocusRun は移動と関係のないFOCUS命令が配信されたかどうかを返します。
+func supersedesFocusRun(taskID string, event *infrastructure.Event) bool {
+	if event.Type != infrastructure.EventControlCommandDispatched {
+		return false
+	}
+
+	command, ok := event.Payload.(*protov1.ControlCommand)
+
+	return ok && command.GetType() == protov1.ControlCommandType_CONTROL_COMMAND_TYPE_FOCUS &&
+		!strings.HasPrefix(command.GetCommandId(), taskID+"-")
+}
+
+func newFocusControlCommand(commandID, cameraID string, value float32) *protov1.ControlCommand {
+	return &protov1.ControlCommand{
+		CommandId:     commandID,
+		CameraId:      cameraID,
+		Type:          protov1.ControlCommandType_CONTROL_COMMAND_TYPE_FOCUS,
+		PtzParameters: nil,
+		PresetNumber:  0,
+		FocusValue:    value,
+		ArmParameters: nil,
+		TimeoutMs:     0,
+	}
+}
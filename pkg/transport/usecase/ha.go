@@ -0,0 +1,385 @@
+package usecase
+
+import (
+	"context"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/anyfld/vistra-operation-control-room/pkg/transport/infrastructure"
+)
+
+const (
+	haPTZQueuesKey = "ptz_queues"
+	haCamerasKey   = "cameras"
+	haPresetsKey   = "presets"
+)
+
+type HAInteractor interface {
+	IsLeader() bool
+	LeaderContext(ctx context.Context) (context.Context, context.CancelFunc)
+	Leader() *infrastructure.Lease
+	NodeID() string
+}
+
+// HAUsecase はアクティブ/スタンバイ構成のリーダー選出と状態の共有を行います。
+// リーダーはキュー・カメラ登録・プリセットの変更を共有ストアへ書き込み、
+// スタンバイは昇格時に共有ストアから状態を復元します。
+// タスクの追加や割り込み、カメラ登録とプリセットの変更は、変更を行ったRPCが応答する前に書き込みます。
+// ポーリングや結果の報告によるタスクの実行の進行は頻度が高いため persistInterval の間の変更をまとめて書き込み、
+// リーダーが異常終了した場合は直前の persistInterval の間の進行が失われます（昇格したリーダーが同じタスクを再び配信します）。
+// Resign では書き込みを済ませてからリースを解放します。
+type HAUsecase struct {
+	elector         *infrastructure.LeaderElector
+	store           infrastructure.SharedStore
+	ptzRepo         *infrastructure.PTZRepo
+	cameraRepo      *infrastructure.CameraRepo
+	presetRepo      *infrastructure.PresetRepo
+	renewInterval   time.Duration
+	persistInterval time.Duration
+	persistMu       sync.Mutex
+	// dirty は共有ストアへの書き込みを待っている状態のキーと、そのスナップショットの取得です。
+	dirtyMu sync.Mutex
+	dirty   map[string]func() ([]byte, error)
+	// persistCh は dirty が空でなくなったことを書き込みのループへ通知します。
+	persistCh chan struct{}
+	// leaderMu は leaderRuns と leaderCancels、termCtx を保護します。
+	leaderMu      sync.Mutex
+	leaderRuns    []leaderRun
+	leaderCancels []context.CancelFunc
+	// termCtx はリーダーである間だけ有効なコンテキストで、降格すると termCancel で終了させます。
+	termCtx    context.Context //nolint:containedctx
+	termCancel context.CancelFunc
+	// restoredTerm は状態の復元が完了したリース期間です。
+	// 復元が終わるまではリーダーとしてリクエストを受け付けません。
+	restoredTerm atomic.Uint64
+	// holdOffUntilMs までは Resign 直後のため、リースを取得せず監視のみ行います。
+	holdOffUntilMs atomic.Int64
+}
+
+// leaderRun は RunAsLeader で登録した、リーダーである間だけ動作させる処理です。
+type leaderRun struct {
+	ctx   context.Context //nolint:containedctx
+	start func(ctx context.Context)
+}
+
+func NewHAUsecase(
+	elector *infrastructure.LeaderElector,
+	store infrastructure.SharedStore,
+	ptzRepo *infrastructure.PTZRepo,
+	cameraRepo *infrastructure.CameraRepo,
+	presetRepo *infrastructure.PresetRepo,
+	renewInterval time.Duration,
+	persistInterval time.Duration,
+) *HAUsecase {
+	return &HAUsecase{
+		elector:         elector,
+		store:           store,
+		ptzRepo:         ptzRepo,
+		cameraRepo:      cameraRepo,
+		presetRepo:      presetRepo,
+		renewInterval:   renewInterval,
+		persistInterval: persistInterval,
+		persistMu:       sync.Mutex{},
+		dirtyMu:         sync.Mutex{},
+		dirty:           make(map[string]func() ([]byte, error)),
+		persistCh:       make(chan struct{}, 1),
+		leaderMu:        sync.Mutex{},
+		leaderRuns:      nil,
+		leaderCancels:   nil,
+		termCtx:         nil,
+		termCancel:      nil,
+		restoredTerm:    atomic.Uint64{},
+		holdOffUntilMs:  atomic.Int64{},
+	}
+}
+
+// Start は変更フックを登録し、最初の選出を同期的に行った後、リース更新ループと書き込みのループを開始します。
+// ループは ctx が終了するまで続きます。
+func (u *HAUsecase) Start(ctx context.Context) {
+	u.ptzRepo.SetChangeHook(func() {
+		u.persist(haPTZQueuesKey, u.ptzRepo.Snapshot)
+	})
+	u.ptzRepo.SetProgressHook(func() {
+		u.markDirty(haPTZQueuesKey, u.ptzRepo.Snapshot)
+	})
+	u.cameraRepo.SetChangeHook(func() {
+		u.persist(haCamerasKey, u.cameraRepo.Snapshot)
+	})
+	u.presetRepo.SetChangeHook(func() {
+		u.persist(haPresetsKey, u.presetRepo.Snapshot)
+	})
+
+	u.elect()
+
+	go u.run(ctx)
+	go u.runPersist(ctx)
+}
+
+// Resign は書き込みを待っている状態を共有ストアへ書き込み、リースを解放してスタンバイへリーダーを引き継ぎます。
+// 他ノードが昇格できるよう、リース有効期間の間は再取得を行いません。
+func (u *HAUsecase) Resign() error {
+	u.flush()
+
+	u.holdOffUntilMs.Store(time.Now().Add(u.elector.TTL()).UnixMilli())
+	u.restoredTerm.Store(0)
+	u.stopLeaderRuns()
+
+	return u.elector.Release()
+}
+
+// RunAsLeader は start で開始する処理を、このノードがリーダーである間だけ動作させます。
+// リーダーに昇格するたびに start を呼び出し、渡した ctx は降格したとき、または ctx が終了したときに終了します。
+// スタンバイのノードでFDへの配信などを行わないために使います。
+func (u *HAUsecase) RunAsLeader(ctx context.Context, start func(ctx context.Context)) {
+	u.leaderMu.Lock()
+	defer u.leaderMu.Unlock()
+
+	run := leaderRun{ctx: ctx, start: start}
+	u.leaderRuns = append(u.leaderRuns, run)
+
+	if u.IsLeader() {
+		u.startLeaderRun(run)
+	}
+}
+
+// LeaderContext は ctx から派生し、このノードが降格したときにも終了するコンテキストを返します。
+// リーダーでない場合は終了済みのコンテキストを返します。確立済みのストリームを降格時に終了させるために使います。
+func (u *HAUsecase) LeaderContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	leaderCtx, cancel := context.WithCancel(ctx)
+
+	u.leaderMu.Lock()
+	termCtx := u.termCtx
+	u.leaderMu.Unlock()
+
+	if termCtx == nil {
+		cancel()
+
+		return leaderCtx, cancel
+	}
+
+	stop := context.AfterFunc(termCtx, cancel)
+
+	return leaderCtx, func() {
+		stop()
+		cancel()
+	}
+}
+
+func (u *HAUsecase) IsLeader() bool {
+	return u.elector.IsLeader() && u.restoredTerm.Load() == u.elector.Leader().GetTerm()
+}
+
+func (u *HAUsecase) Leader() *infrastructure.Lease {
+	return u.elector.Leader()
+}
+
+func (u *HAUsecase) NodeID() string {
+	return u.elector.NodeID()
+}
+
+func (u *HAUsecase) run(ctx context.Context) {
+	ticker := time.NewTicker(u.renewInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			u.elect()
+		}
+	}
+}
+
+func (u *HAUsecase) elect() {
+	if time.Now().UnixMilli() < u.holdOffUntilMs.Load() {
+		if err := u.elector.Observe(); err != nil {
+			log.Printf("HA: failed to read lease: node_id=%s err=%v", u.elector.NodeID(), err)
+		}
+
+		return
+	}
+
+	promoted, err := u.elector.TryAcquire()
+	if err != nil {
+		log.Printf("HA: lease renewal failed: node_id=%s err=%v", u.elector.NodeID(), err)
+	}
+
+	if !u.IsLeader() && !promoted {
+		u.stopLeaderRuns()
+	}
+
+	if !promoted {
+		return
+	}
+
+	// 前リーダーが書き込んだ状態を引き継ぐ
+	u.persistMu.Lock()
+	defer u.persistMu.Unlock()
+
+	u.restore(haCamerasKey, u.cameraRepo.Restore)
+	u.restore(haPTZQueuesKey, u.ptzRepo.Restore)
+	u.restore(haPresetsKey, u.presetRepo.Restore)
+
+	lease := u.elector.Leader()
+	u.restoredTerm.Store(lease.GetTerm())
+	log.Printf("HA: promoted to leader: node_id=%s term=%d", u.elector.NodeID(), lease.GetTerm())
+
+	u.startLeaderRuns()
+}
+
+// startLeaderRuns は RunAsLeader で登録した処理を開始します。既に動作している場合は何もしません。
+func (u *HAUsecase) startLeaderRuns() {
+	u.leaderMu.Lock()
+	defer u.leaderMu.Unlock()
+
+	if u.termCancel != nil {
+		return
+	}
+
+	u.termCtx, u.termCancel = context.WithCancel(context.Background())
+
+	for _, run := range u.leaderRuns {
+		u.startLeaderRun(run)
+	}
+}
+
+func (u *HAUsecase) startLeaderRun(run leaderRun) {
+	ctx, cancel := context.WithCancel(run.ctx)
+	u.leaderCancels = append(u.leaderCancels, cancel)
+
+	run.start(ctx)
+}
+
+// stopLeaderRuns は RunAsLeader で登録した処理と LeaderContext のコンテキストを終了させます。
+func (u *HAUsecase) stopLeaderRuns() {
+	u.leaderMu.Lock()
+	defer u.leaderMu.Unlock()
+
+	for _, cancel := range u.leaderCancels {
+		cancel()
+	}
+
+	u.leaderCancels = nil
+
+	if u.termCancel != nil {
+		u.termCancel()
+		u.termCtx, u.termCancel = nil, nil
+	}
+}
+
+func (u *HAUsecase) restore(key string, restoreFn func([]byte) error) {
+	data, err := u.store.LoadState(key)
+	if err != nil {
+		log.Printf("HA: failed to load state: key=%s err=%v", key, err)
+
+		return
+	}
+
+	if data == nil {
+		return
+	}
+
+	if err := restoreFn(data); err != nil {
+		log.Printf("HA: failed to restore state: key=%s err=%v", key, err)
+	}
+}
+
+// persist はリーダーである場合のみ、key の状態を共有ストアへ書き込んでから戻ります。
+// 書き込み待ちの同じキーは、この書き込みに含まれるため取り消します。
+func (u *HAUsecase) persist(key string, snapshotFn func() ([]byte, error)) {
+	if !u.IsLeader() {
+		return
+	}
+
+	u.persistMu.Lock()
+	defer u.persistMu.Unlock()
+
+	u.dirtyMu.Lock()
+	delete(u.dirty, key)
+	u.dirtyMu.Unlock()
+
+	u.save(key, snapshotFn)
+}
+
+// markDirty はリーダーである場合のみ、key の状態を共有ストアへの書き込み待ちにします。
+func (u *HAUsecase) markDirty(key string, snapshotFn func() ([]byte, error)) {
+	if !u.IsLeader() {
+		return
+	}
+
+	u.dirtyMu.Lock()
+	u.dirty[key] = snapshotFn
+	u.dirtyMu.Unlock()
+
+	select {
+	case u.persistCh <- struct{}{}:
+	default:
+	}
+}
+
+// runPersist は書き込み待ちの状態を persistInterval ごとにまとめて共有ストアへ書き込みます。
+// 変更が続いても書き込みは persistInterval に1回に抑えます。ctx が終了するまで動作します。
+func (u *HAUsecase) runPersist(ctx context.Context) {
+	timer := time.NewTimer(u.persistInterval)
+	timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			u.flush()
+
+			return
+		case <-u.persistCh:
+			timer.Reset(u.persistInterval)
+
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				u.flush()
+
+				return
+			case <-timer.C:
+				u.flush()
+			}
+		}
+	}
+}
+
+// flush はリーダーである場合のみ、書き込み待ちの状態を共有ストアへ書き込みます。
+// スナップショットの取得と書き込みを直列化し、古い状態で上書きしないようにします。
+func (u *HAUsecase) flush() {
+	u.persistMu.Lock()
+	defer u.persistMu.Unlock()
+
+	u.dirtyMu.Lock()
+	dirty := u.dirty
+	u.dirty = make(map[string]func() ([]byte, error))
+	u.dirtyMu.Unlock()
+
+	if len(dirty) == 0 || !u.IsLeader() {
+		return
+	}
+
+	for _, key := range []string{haCamerasKey, haPTZQueuesKey, haPresetsKey} {
+		if snapshotFn, ok := dirty[key]; ok {
+			u.save(key, snapshotFn)
+		}
+	}
+}
+
+// save は key の状態のスナップショットを共有ストアへ書き込みます。persistMu を保持して呼び出します。
+func (u *HAUsecase) save(key string, snapshotFn func() ([]byte, error)) {
+	data, err := snapshotFn()
+	if err != nil {
+		log.Printf("HA: failed to snapshot state: key=%s err=%v", key, err)
+
+		return
+	}
+
+	if err := u.store.SaveState(u.elector.NodeID(), key, data); err != nil {
+		log.Printf("HA: failed to save state: key=%s err=%v", key, err)
+	}
+}
@@ -0,0 +1,246 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	protov1 "github.com/anyfld/vistra-operation-control-room/gen/proto/v1"
+	"github.com/anyfld/vistra-operation-control-room/pkg/transport/infrastructure"
+)
+
+// keyframeFinish はキーフレームを配信し終えた後のタスクの終了のさせ方です。
+type keyframeFinish int
+
+const (
+	// keyframeFinishNone はタスクの終了をFDの報告に任せます。
+	keyframeFinishNone keyframeFinish = iota
+	// keyframeFinishAtArrival は最後のキーフレームの到達予定時刻にタスクを完了させます。
+	keyframeFinishAtArrival
+	// keyframeFinishOnResult は最後のキーフレームの結果をFDが報告したときに、その成否でタスクを終了させます。
+	keyframeFinishOnResult
+)
+
+// errKeyframeTimedOut は配信したキーフレームの結果が時間内に報告されなかったことを表します。
+var errKeyframeTimedOut = errors.New("keyframe timed out")
+
+// keyframe はタスクの実行中に順にFDへ配信する制御コマンドです。
+type keyframe struct {
+	command *protov1.ControlCommand
+	// arrival は配信を始めてからこのキーフレームに到達する予定の時間で、次のキーフレームはこの時刻に送ります。
+	arrival time.Duration
+}
+
+// keyframePlan は実行対象になったタスクで配信するキーフレームです。
+type keyframePlan struct {
+	keyframes []keyframe
+	finish    keyframeFinish
+	// interrupted はタスクが中断されて配信を止めた後に呼び出されます。nil の場合は何もしません。
+	interrupted func()
+}
+
+// keyframeDispatcher はタスクが実行対象になったときに、タスクのキーフレームを到達予定時刻に合わせて配信します。
+// アーム・フォーカス・PTZの軌道の配信が共有し、配信の内容は plan で決めます。
+type keyframeDispatcher struct {
+	// plan は実行対象になったタスクのキーフレームを返します。キーフレームを持たないタスクは nil を返します。
+	plan func(cameraID, taskID string, task *protov1.Task) *keyframePlan
+	// outlivesTask が true の場合、タスクの完了や別のタスクの開始では配信を止めず、最後のキーフレームまで続けます。
+	outlivesTask bool
+	// supersedes はイベントが配信中のキーフレームを不要にするかどうかを返します。nil の場合は判定しません。
+	supersedes func(taskID string, event *infrastructure.Event) bool
+}
+
+// keyframeRun は実行中のタスクのキーフレームの配信です。
+type keyframeRun struct {
+	taskID      string
+	interrupted func()
+	cancel      context.CancelFunc
+	done        chan struct{}
+}
+
+// startKeyframeDispatcher は ctx が終了するまで dispatcher を動作させます。
+// 中断を取りこぼして配信が続かないよう、イベントは EventBus.Listen で受け取ります。
+func (u *FDUsecase) startKeyframeDispatcher(ctx context.Context, dispatcher keyframeDispatcher) {
+	listener := u.events.Listen(infrastructure.EventTopicTask)
+
+	go func() {
+		defer u.events.Unlisten(listener)
+
+		runs := make(map[string]*keyframeRun)
+
+		for {
+			select {
+			case <-ctx.Done():
+				for _, run := range runs {
+					run.stop(false)
+				}
+
+				return
+			case event, ok := <-listener.Events():
+				if !ok {
+					return
+				}
+
+				u.handleKeyframeEvent(ctx, dispatcher, runs, event)
+			}
+		}
+	}()
+}
+
+func (u *FDUsecase) handleKeyframeEvent(
+	ctx context.Context,
+	dispatcher keyframeDispatcher,
+	runs map[string]*keyframeRun,
+	event *infrastructure.Event,
+) {
+	run := runs[event.CameraID]
+
+	switch event.Type {
+	case infrastructure.EventTaskStarted:
+		if run != nil && run.taskID == event.ResourceID {
+			return
+		}
+
+		task, _ := event.Payload.(*protov1.Task)
+		plan := dispatcher.plan(event.CameraID, event.ResourceID, task)
+
+		// 別のタスクが実行対象になった場合、前のタスクは完了または中断している
+		if run != nil && (plan != nil || !dispatcher.outlivesTask) {
+			run.stop(!dispatcher.outlivesTask)
+			delete(runs, event.CameraID)
+		}
+
+		if plan != nil {
+			runs[event.CameraID] = u.startKeyframeRun(ctx, event.CameraID, event.ResourceID, plan)
+		}
+	case infrastructure.EventTaskInterrupted, infrastructure.EventTaskCompleted:
+		if run == nil || run.taskID != event.ResourceID {
+			return
+		}
+
+		interrupted := event.Type == infrastructure.EventTaskInterrupted
+		if !interrupted && dispatcher.outlivesTask {
+			return
+		}
+
+		run.stop(interrupted)
+		delete(runs, event.CameraID)
+	default:
+		if run == nil || dispatcher.supersedes == nil || !dispatcher.supersedes(run.taskID, event) {
+			return
+		}
+
+		run.stop(false)
+		delete(runs, event.CameraID)
+	}
+}
+
+// startKeyframeRun はキーフレームを配信します。各キーフレームは、直前のキーフレームへの到達予定時刻に送ります。
+// 最後のキーフレームを送り、タスクを plan.finish のとおりに終了させるまでに、配信したキーフレームの結果が
+// タイムアウトした場合は、残りのキーフレームを送らずにタスクをタイムアウトで終了させます。
+func (u *FDUsecase) startKeyframeRun(
+	ctx context.Context,
+	cameraID string,
+	taskID string,
+	plan *keyframePlan,
+) *keyframeRun {
+	ctx, cancel := context.WithCancel(ctx)
+	run := &keyframeRun{taskID: taskID, interrupted: plan.interrupted, cancel: cancel, done: make(chan struct{})}
+
+	go func() {
+		defer close(run.done)
+
+		ctx, timeOut := context.WithCancelCause(ctx)
+		defer timeOut(context.Canceled)
+
+		u.sendKeyframes(ctx, cameraID, taskID, plan, timeOut)
+
+		if errors.Is(context.Cause(ctx), errKeyframeTimedOut) {
+			u.ptzRepo.FinishTask(cameraID, taskID, infrastructure.TaskOutcomeTimedOut, nil)
+		}
+	}()
+
+	return run
+}
+
+// sendKeyframes は plan のキーフレームを配信し、タスクを plan.finish のとおりに終了させます。
+// 配信したキーフレームの結果がタイムアウトした場合は errKeyframeTimedOut で ctx を終了させます。
+func (u *FDUsecase) sendKeyframes(
+	ctx context.Context,
+	cameraID string,
+	taskID string,
+	plan *keyframePlan,
+	timeOut context.CancelCauseFunc,
+) {
+	startedAt := time.Now()
+	previousArrival := time.Duration(0)
+
+	var last *infrastructure.ControlCommandStatus
+
+	for _, frame := range plan.keyframes {
+		if !waitUntil(ctx, startedAt.Add(previousArrival)) {
+			return
+		}
+
+		last = u.repo.SendControlCommand(frame.command)
+		previousArrival = frame.arrival
+
+		go u.watchKeyframeTimeout(ctx, frame.command.GetCommandId(), timeOut)
+	}
+
+	switch plan.finish {
+	case keyframeFinishNone:
+	case keyframeFinishAtArrival:
+		if waitUntil(ctx, startedAt.Add(previousArrival)) {
+			u.ptzRepo.FinishTask(cameraID, taskID, infrastructure.TaskOutcomeCompleted, nil)
+		}
+	case keyframeFinishOnResult:
+		if last == nil {
+			return
+		}
+
+		status, err := u.repo.WaitControlCommand(ctx, last.Command.GetCommandId())
+		if err != nil {
+			return
+		}
+
+		if status.State == infrastructure.ControlCommandStateTimedOut {
+			timeOut(errKeyframeTimedOut)
+
+			return
+		}
+
+		u.ptzRepo.FinishTask(cameraID, taskID, infrastructure.TaskOutcomeOf(status.State), nil)
+	}
+}
+
+// watchKeyframeTimeout はキーフレームの結果を待ち、タイムアウトした場合は errKeyframeTimedOut で timeOut を呼び出します。
+func (u *FDUsecase) watchKeyframeTimeout(ctx context.Context, commandID string, timeOut context.CancelCauseFunc) {
+	status, err := u.repo.WaitControlCommand(ctx, commandID)
+	if err == nil && status.State == infrastructure.ControlCommandStateTimedOut {
+		timeOut(errKeyframeTimedOut)
+	}
+}
+
+// stop は配信を止めます。interrupted が true の場合は、止めた後に keyframePlan.interrupted を呼び出します。
+func (r *keyframeRun) stop(interrupted bool) {
+	r.cancel()
+	<-r.done
+
+	if interrupted && r.interrupted != nil {
+		r.interrupted()
+	}
+}
+
+// waitUntil は at まで待ちます。ctx が先に終了した場合は false を返します。
+func waitUntil(ctx context.Context, at time.Time) bool {
+	timer := time.NewTimer(time.Until(at))
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
@@ -0,0 +1,108 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	protov1 "github.com/anyfld/vistra-operation-control-room/gen/proto/v1"
+	"github.com/anyfld/vistra-operation-control-room/pkg/transport/infrastructure"
+)
+
+var (
+	ErrLensCalibrationNotFound = errors.New("lens calibration not found")
+	ErrInvalidImagePoint       = errors.New("image point must be within 0.0-1.0")
+	ErrCameraPTZUnknown        = errors.New("camera ptz is unknown")
+)
+
+type LensInteractor interface {
+	SetLensCalibration(
+		ctx context.Context,
+		cameraID string,
+		calibration *infrastructure.LensCalibration,
+	) (*infrastructure.LensCalibration, error)
+	GetLensCalibration(ctx context.Context, cameraID string) (*infrastructure.LensCalibration, error)
+	ImageToPTZ(
+		ctx context.Context,
+		cameraID string,
+		x, y float64,
+		current *protov1.PTZParameters,
+	) (*protov1.PTZParameters, error)
+}
+
+// LensUsecase はカメラのレンズのキャリブレーションと、画面上の位置からPTZへの変換を扱います。
+// フレーミングと追尾も CameraRepo.GetOptics で同じキャリブレーションを使います。
+type LensUsecase struct {
+	cameraRepo *infrastructure.CameraRepo
+	estimator  *infrastructure.PTZEstimator
+}
+
+func NewLensUsecase(cameraRepo *infrastructure.CameraRepo, estimator *infrastructure.PTZEstimator) *LensUsecase {
+	return &LensUsecase{cameraRepo: cameraRepo, estimator: estimator}
+}
+
+// SetLensCalibration はキャリブレーションを検証して保存します。
+func (u *LensUsecase) SetLensCalibration(
+	ctx context.Context,
+	cameraID string,
+	calibration *infrastructure.LensCalibration,
+) (*infrastructure.LensCalibration, error) {
+	if err := calibration.Validate(); err != nil {
+		return nil, err
+	}
+
+	if !u.cameraRepo.SetLensCalibration(cameraID, calibration) {
+		return nil, fmt.Errorf("%w: %s", ErrCameraNotFound, cameraID)
+	}
+
+	return calibration, nil
+}
+
+func (u *LensUsecase) GetLensCalibration(
+	ctx context.Context,
+	cameraID string,
+) (*infrastructure.LensCalibration, error) {
+	if u.cameraRepo.GetCamera(cameraID) == nil {
+		return nil, fmt.Errorf("%w: %s", ErrCameraNotFound, cameraID)
+	}
+
+	calibration := u.cameraRepo.GetLensCalibration(cameraID)
+	if calibration == nil {
+		return nil, fmt.Errorf("%w: camera %s", ErrLensCalibrationNotFound, cameraID)
+	}
+
+	return calibration, nil
+}
+
+// ImageToPTZ は画面上の位置 (x, y) を画面の中心に置く絶対PTZを返します。
+// current が指定されていない場合は、カメラの推定のPTZ（推定できない場合は最後に報告したPTZ）で撮影したものとします。
+func (u *LensUsecase) ImageToPTZ(
+	ctx context.Context,
+	cameraID string,
+	x, y float64,
+	current *protov1.PTZParameters,
+) (*protov1.PTZParameters, error) {
+	camera := u.cameraRepo.GetCamera(cameraID)
+	if camera == nil {
+		return nil, fmt.Errorf("%w: %s", ErrCameraNotFound, cameraID)
+	}
+
+	if x < 0 || x > 1 || y < 0 || y > 1 {
+		return nil, fmt.Errorf("%w: (%v, %v)", ErrInvalidImagePoint, x, y)
+	}
+
+	if current == nil {
+		if estimate, ok := u.estimator.Estimate(cameraID, time.Now().UnixMilli()); ok {
+			current = estimate.PTZ
+		} else {
+			current = camera.GetCurrentPtz()
+		}
+	}
+
+	if current == nil {
+		return nil, fmt.Errorf("%w: camera %s has not reported its ptz", ErrCameraPTZUnknown, cameraID)
+	}
+
+	return u.cameraRepo.GetOptics(cameraID).ImageToPTZ(current, x, y), nil
+}
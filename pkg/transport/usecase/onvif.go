@@ -0,0 +1,361 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+
+	protov1 "github.com/anyfld/vistra-operation-control-room/gen/proto/v1"
+	"github.com/anyfld/vistra-operation-control-room/pkg/transport/infrastructure"
+)
+
+const onvifSupersededErrorMessage = "superseded by "
+
+type ONVIFInteractor interface {
+	Start(ctx context.Context)
+	IsDriving(cameraID string) bool
+}
+
+// ONVIFUsecase は接続方式が ONVIF のカメラを、FDの代わりにCRから直接制御する内蔵のドライバーです。
+// カメラごとにPTZ命令を購読して制御コマンドをONVIFのPTZサービスへ送り、GetStatus で取得した状態を
+// FDが双方向ストリームで送る状態と同じようにカメラの状態として報告します。
+// 絶対移動・相対移動・プリセットへの移動は GetStatus が停止を返した時点で完了とします。
+type ONVIFUsecase struct {
+	fd           FDInteractor
+	camera       CameraInteractor
+	cameraRepo   *infrastructure.CameraRepo
+	events       *infrastructure.EventBus
+	client       *http.Client
+	pollInterval time.Duration
+	moveTimeout  time.Duration
+
+	mu      sync.Mutex
+	drivers map[string]*onvifDriver
+}
+
+// onvifDriver は1台のカメラのドライバーです。
+type onvifDriver struct {
+	cameraID   string
+	connection *protov1.CameraConnection
+	device     *infrastructure.ONVIFDevice
+	cancel     context.CancelFunc
+	done       chan struct{}
+}
+
+// onvifMove は完了を待っている移動の制御コマンドです。
+type onvifMove struct {
+	commandID string
+	startedAt time.Time
+}
+
+func NewONVIFUsecase(
+	fd FDInteractor,
+	camera CameraInteractor,
+	cameraRepo *infrastructure.CameraRepo,
+	events *infrastructure.EventBus,
+	client *http.Client,
+	pollInterval time.Duration,
+	moveTimeout time.Duration,
+) *ONVIFUsecase {
+	return &ONVIFUsecase{
+		fd:           fd,
+		camera:       camera,
+		cameraRepo:   cameraRepo,
+		events:       events,
+		client:       client,
+		pollInterval: pollInterval,
+		moveTimeout:  moveTimeout,
+		mu:           sync.Mutex{},
+		drivers:      make(map[string]*onvifDriver),
+	}
+}
+
+// Start は登録済みのONVIFカメラの制御を開始し、カメラの登録・更新・削除に追従します。ctx が終了するまで動作します。
+func (u *ONVIFUsecase) Start(ctx context.Context) {
+	listener := u.events.Listen(infrastructure.EventTopicCamera)
+
+	for _, camera := range u.cameraRepo.ListCameras("", nil, nil) {
+		u.syncDriver(ctx, camera.GetId())
+	}
+
+	go func() {
+		defer u.events.Unlisten(listener)
+
+		for {
+			select {
+			case <-ctx.Done():
+				u.mu.Lock()
+				cameraIDs := make([]string, 0, len(u.drivers))
+
+				for cameraID := range u.drivers {
+					cameraIDs = append(cameraIDs, cameraID)
+				}
+				u.mu.Unlock()
+
+				for _, cameraID := range cameraIDs {
+					u.stopDriver(cameraID)
+				}
+
+				return
+			case event, ok := <-listener.Events():
+				if !ok {
+					return
+				}
+
+				switch event.Type {
+				case infrastructure.EventCameraRegistered, infrastructure.EventCameraUpdated:
+					u.syncDriver(ctx, event.CameraID)
+				case infrastructure.EventCameraUnregistered:
+					u.stopDriver(event.CameraID)
+				}
+			}
+		}
+	}()
+}
+
+// IsDriving はカメラをONVIFのドライバーで制御しているかどうかを返します。
+func (u *ONVIFUsecase) IsDriving(cameraID string) bool {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	return u.drivers[cameraID] != nil
+}
+
+// syncDriver はカメラの接続情報に合わせてドライバーを開始・再開・停止します。
+func (u *ONVIFUsecase) syncDriver(ctx context.Context, cameraID string) {
+	connection := u.cameraRepo.GetConnection(cameraID)
+	if u.cameraRepo.GetCamera(cameraID) == nil ||
+		connection.GetType() != protov1.ConnectionType_CONNECTION_TYPE_ONVIF {
+		u.stopDriver(cameraID)
+
+		return
+	}
+
+	u.mu.Lock()
+	current := u.drivers[cameraID]
+	u.mu.Unlock()
+
+	if current != nil && proto.Equal(current.connection, connection) {
+		return
+	}
+
+	u.stopDriver(cameraID)
+
+	device, err := infrastructure.NewONVIFDevice(u.client, connection)
+	if err != nil {
+		log.Printf("ONVIF driver not started: camera_id=%s err=%v", cameraID, err)
+
+		return
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	driver := &onvifDriver{
+		cameraID:   cameraID,
+		connection: connection,
+		device:     device,
+		cancel:     cancel,
+		done:       make(chan struct{}),
+	}
+
+	u.mu.Lock()
+	u.drivers[cameraID] = driver
+	u.mu.Unlock()
+
+	log.Printf("ONVIF driver started: camera_id=%s endpoint=%s", cameraID, device.PTZURL())
+
+	go u.runDriver(ctx, driver)
+}
+
+func (u *ONVIFUsecase) stopDriver(cameraID string) {
+	u.mu.Lock()
+	driver := u.drivers[cameraID]
+	delete(u.drivers, cameraID)
+	u.mu.Unlock()
+
+	if driver == nil {
+		return
+	}
+
+	driver.cancel()
+	<-driver.done
+}
+
+// runDriver はPTZ命令を購読し、購読が終了した場合は最後に受信したイベントの次から購読し直します。
+func (u *ONVIFUsecase) runDriver(ctx context.Context, driver *onvifDriver) {
+	defer close(driver.done)
+
+	var afterSequence uint64
+
+	for ctx.Err() == nil {
+		subscription, err := u.fd.SubscribePTZCommands(ctx, driver.cameraID, afterSequence)
+		if errors.Is(err, infrastructure.ErrPTZReplayGap) {
+			afterSequence = 0
+
+			continue
+		}
+
+		if err != nil {
+			log.Printf("ONVIF driver subscribe error: camera_id=%s err=%v", driver.cameraID, err)
+
+			return
+		}
+
+		afterSequence = u.drive(ctx, driver, subscription, afterSequence)
+
+		_ = u.fd.UnsubscribePTZCommands(ctx, subscription)
+	}
+}
+
+// drive は制御コマンドを実行し、一定間隔で状態を取得します。最後に受信したイベントの通し番号を返します。
+func (u *ONVIFUsecase) drive(
+	ctx context.Context,
+	driver *onvifDriver,
+	subscription *infrastructure.PTZSubscription,
+	lastSequence uint64,
+) uint64 {
+	ticker := time.NewTicker(u.pollInterval)
+	defer ticker.Stop()
+
+	var move *onvifMove
+
+	for {
+		select {
+		case <-ctx.Done():
+			return lastSequence
+		case event, ok := <-subscription.Events():
+			if !ok {
+				return lastSequence
+			}
+
+			lastSequence = event.Sequence
+
+			// 結果のイベントは中断などでCRが終了させた制御コマンドで、完了を待つ必要はない
+			if event.Result != nil {
+				if move != nil && move.commandID == event.Result.GetCommandId() {
+					move = nil
+				}
+
+				continue
+			}
+
+			move = u.execute(ctx, driver, event.Command, move)
+		case <-ticker.C:
+			move = u.poll(ctx, driver, move)
+		}
+	}
+}
+
+// execute は制御コマンドをカメラへ送ります。完了を待つ移動の場合はその移動を返します。
+// 完了を待っている移動があれば、新しい制御コマンドに置き換えられたものとして失敗を報告します。
+func (u *ONVIFUsecase) execute(
+	ctx context.Context,
+	driver *onvifDriver,
+	command *protov1.ControlCommand,
+	pending *onvifMove,
+) *onvifMove {
+	if pending != nil {
+		u.reportResult(ctx, pending, false, onvifSupersededErrorMessage+command.GetCommandId(), nil)
+	}
+
+	move := &onvifMove{commandID: command.GetCommandId(), startedAt: time.Now()}
+
+	err := driver.device.ExecuteControlCommand(ctx, u.cameraRepo.GetOptics(driver.cameraID), command)
+	if err != nil {
+		u.reportResult(ctx, move, false, err.Error(), nil)
+
+		return nil
+	}
+
+	switch command.GetType() { //nolint:exhaustive
+	case protov1.ControlCommandType_CONTROL_COMMAND_TYPE_PTZ_ABSOLUTE,
+		protov1.ControlCommandType_CONTROL_COMMAND_TYPE_PTZ_RELATIVE,
+		protov1.ControlCommandType_CONTROL_COMMAND_TYPE_PRESET_GOTO:
+		return move
+	default:
+		// 連続移動・停止・プリセットの登録はカメラが受け付けた時点で完了とする
+		u.reportResult(ctx, move, true, "", nil)
+
+		return nil
+	}
+}
+
+// poll は GetStatus でカメラの状態を取得して報告し、完了を待っている移動が停止したかを確認します。
+// 移動を送った直後はカメラが移動を始める前の停止を返すことがあるため、送ってから1周期経つまでは完了としません。
+func (u *ONVIFUsecase) poll(ctx context.Context, driver *onvifDriver, move *onvifMove) *onvifMove {
+	status, err := driver.device.GetStatus(ctx)
+	now := time.Now()
+
+	if err != nil {
+		u.reportState(ctx, &protov1.CameraState{
+			CameraId:     driver.cameraID,
+			CurrentPtz:   nil,
+			IsMoving:     false,
+			HasError:     true,
+			ErrorMessage: err.Error(),
+			CurrentFocus: 0,
+			UpdatedAtMs:  now.UnixMilli(),
+			Status:       protov1.CameraStatus_CAMERA_STATUS_ERROR,
+		})
+	} else {
+		ptz := u.cameraRepo.GetOptics(driver.cameraID).DenormalizedPTZ(status.Position)
+
+		u.reportState(ctx, &protov1.CameraState{
+			CameraId:     driver.cameraID,
+			CurrentPtz:   ptz,
+			IsMoving:     status.Moving,
+			HasError:     status.Error != "",
+			ErrorMessage: status.Error,
+			CurrentFocus: 0,
+			UpdatedAtMs:  now.UnixMilli(),
+			Status:       protov1.CameraStatus_CAMERA_STATUS_ONLINE,
+		})
+
+		if move != nil && !status.Moving && now.Sub(move.startedAt) >= u.pollInterval {
+			u.reportResult(ctx, move, true, "", ptz)
+
+			return nil
+		}
+	}
+
+	if move != nil && now.Sub(move.startedAt) >= u.moveTimeout {
+		u.reportResult(ctx, move, false, "move did not finish within "+u.moveTimeout.String(), nil)
+
+		return nil
+	}
+
+	return move
+}
+
+func (u *ONVIFUsecase) reportState(ctx context.Context, state *protov1.CameraState) {
+	if _, err := u.fd.ReportCameraState(ctx, state); err != nil {
+		log.Printf("ONVIF driver state error: camera_id=%s err=%v", state.GetCameraId(), err)
+
+		return
+	}
+
+	_, _ = u.camera.UpdateCameraState(ctx, state.GetCameraId(), state.GetCurrentPtz(), state.GetStatus())
+}
+
+func (u *ONVIFUsecase) reportResult(
+	ctx context.Context,
+	move *onvifMove,
+	success bool,
+	errorMessage string,
+	ptz *protov1.PTZParameters,
+) {
+	_, err := u.fd.ReportControlCommandResult(ctx, &protov1.ControlCommandResult{
+		CommandId:       move.commandID,
+		Success:         success,
+		ErrorMessage:    errorMessage,
+		ResultingPtz:    ptz,
+		ExecutionTimeMs: uint32(time.Since(move.startedAt).Milliseconds()), //nolint:gosec
+	})
+	if err != nil {
+		log.Printf("ONVIF driver report error: command_id=%s err=%v", move.commandID, err)
+	}
+}
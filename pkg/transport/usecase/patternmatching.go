@@ -0,0 +1,200 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	protov1 "github.com/anyfld/vistra-operation-control-room/gen/proto/v1"
+	"github.com/anyfld/vistra-operation-control-room/pkg/transport/infrastructure"
+)
+
+const (
+	defaultPatternMatchingIntervalMs = 500
+	minPatternMatchingIntervalMs     = 50
+)
+
+var ErrCameraOffline = errors.New("camera is offline")
+
+// StartPatternMatching はセッションを作成し、IntervalMs ごとにフレームを検出器にかけます。
+// フレームはFDが PushPatternMatchingFrame で送信したものを優先し、なければカメラのメタデータの
+// スナップショットURLから取得します。結果はセッションの全購読者に配信されます。
+func (u *FDUsecase) StartPatternMatching(
+	ctx context.Context,
+	req *protov1.StartPatternMatchingRequest,
+) (string, error) {
+	cameraID := req.GetCameraId()
+
+	status, ok := u.cameraRepo.GetConnectionStatus(cameraID)
+	if ok && status == protov1.CameraStatus_CAMERA_STATUS_OFFLINE {
+		return "", fmt.Errorf("%w: %s", ErrCameraOffline, cameraID)
+	}
+
+	intervalMs := req.GetIntervalMs()
+	if intervalMs == 0 {
+		intervalMs = defaultPatternMatchingIntervalMs
+	}
+
+	intervalMs = max(intervalMs, minPatternMatchingIntervalMs)
+	sourceURL := u.cameraRepo.GetCamera(cameraID).GetMetadata()[infrastructure.CameraMetadataSnapshotURL]
+
+	session := u.repo.StartPatternMatching(cameraID, req.GetTargetSubjects(), intervalMs, sourceURL)
+	go u.runPatternMatching(session)
+
+	return session.SessionID, nil
+}
+
+// StopPatternMatching はセッションを終了します。結果を配信中のストリームも終了します。
+func (u *FDUsecase) StopPatternMatching(
+	ctx context.Context,
+	sessionID string,
+) (bool, error) {
+	return u.repo.StopPatternMatching(sessionID), nil
+}
+
+func (u *FDUsecase) PushPatternMatchingFrame(
+	ctx context.Context,
+	sessionID string,
+	frame *protov1.ImageData,
+) error {
+	return u.repo.PushPatternMatchingFrame(sessionID, frame)
+}
+
+func (u *FDUsecase) SubscribePatternMatchResults(
+	ctx context.Context,
+	sessionID string,
+) (<-chan *protov1.StreamPatternMatchResultsResponse, error) {
+	return u.repo.SubscribePatternMatchResults(sessionID)
+}
+
+func (u *FDUsecase) UnsubscribePatternMatchResults(
+	ctx context.Context,
+	sessionID string,
+	ch <-chan *protov1.StreamPatternMatchResultsResponse,
+) {
+	u.repo.UnsubscribePatternMatchResults(sessionID, ch)
+}
+
+// StartPatternMatchingSupervisor はカメラがオフラインになった、または登録解除されたときに
+// そのカメラのセッションを終了させます。ctx が終了するまで動作します。
+func (u *FDUsecase) StartPatternMatchingSupervisor(ctx context.Context) {
+	listener := u.events.Listen(infrastructure.EventTopicCamera)
+
+	go func() {
+		defer u.events.Unlisten(listener)
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case event, ok := <-listener.Events():
+				if !ok {
+					return
+				}
+
+				if !isCameraGone(event) {
+					continue
+				}
+
+				for _, session := range u.repo.ListPatternMatchingSessions(event.CameraID) {
+					if u.repo.StopPatternMatching(session.SessionID) {
+						log.Printf(
+							"pattern matching session expired: session_id=%s camera_id=%s reason=%s",
+							session.SessionID, event.CameraID, event.Type,
+						)
+					}
+				}
+			}
+		}
+	}()
+}
+
+func isCameraGone(event *infrastructure.Event) bool {
+	switch event.Type {
+	case infrastructure.EventCameraUnregistered:
+		return true
+	case infrastructure.EventCameraStateChanged:
+		camera, ok := event.Payload.(*protov1.Camera)
+
+		return ok && camera.GetStatus() == protov1.CameraStatus_CAMERA_STATUS_OFFLINE
+	default:
+		return false
+	}
+}
+
+func (u *FDUsecase) runPatternMatching(session *infrastructure.PatternMatchingSession) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// 検出中にセッションが終了した場合も、参照画像の取得などを中断する
+	go func() {
+		select {
+		case <-session.Done():
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	ticker := time.NewTicker(time.Duration(session.IntervalMs) * time.Millisecond)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		frame := nextPatternMatchingFrame(session)
+		if frame == nil {
+			continue
+		}
+
+		detected, err := u.detectFrame(ctx, frame, session.TargetSubjects)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+
+			log.Printf("pattern matching failed: session_id=%s error=%v", session.SessionID, err)
+
+			continue
+		}
+
+		timestampMs := frame.GetTimestampMs()
+		if timestampMs == 0 {
+			timestampMs = time.Now().UnixMilli()
+		}
+
+		u.repo.PublishPatternMatchResult(&protov1.StreamPatternMatchResultsResponse{
+			SessionId:        session.SessionID,
+			CameraId:         session.CameraID,
+			DetectedSubjects: detected,
+			TimestampMs:      timestampMs,
+		})
+	}
+}
+
+// nextPatternMatchingFrame はFDから送信された最新のフレーム、なければスナップショットURLを返します。
+func nextPatternMatchingFrame(session *infrastructure.PatternMatchingSession) *protov1.ImageData {
+	select {
+	case frame := <-session.Frames():
+		return frame
+	default:
+	}
+
+	if session.SourceURL == "" {
+		return nil
+	}
+
+	return &protov1.ImageData{
+		Format:      protov1.ImageFormat_IMAGE_FORMAT_UNSPECIFIED,
+		Data:        nil,
+		Url:         session.SourceURL,
+		Width:       0,
+		Height:      0,
+		TimestampMs: 0,
+	}
+}
@@ -0,0 +1,215 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"google.golang.org/protobuf/proto"
+
+	protov1 "github.com/anyfld/vistra-operation-control-room/gen/proto/v1"
+	"github.com/anyfld/vistra-operation-control-room/pkg/transport/infrastructure"
+)
+
+// defaultPresetLimit はカメラがプリセット数を報告していない場合に保存できるプリセットの数です。
+const defaultPresetLimit = 256
+
+var (
+	ErrPresetOutOfRange   = errors.New("preset number is out of range")
+	ErrPresetPTZRequired  = errors.New("preset ptz is required")
+	ErrPresetNotSupported = errors.New("camera does not support ptz")
+)
+
+type PresetInteractor interface {
+	SetPreset(ctx context.Context, preset *infrastructure.Preset) (*infrastructure.Preset, error)
+	GetPreset(ctx context.Context, cameraID string, number uint32) (*infrastructure.Preset, error)
+	ListPresets(ctx context.Context, cameraID string) ([]*infrastructure.Preset, error)
+	DeletePreset(ctx context.Context, cameraID string, number uint32) error
+}
+
+// PresetUsecase はサーバー側のプリセットを管理します。
+// プリセット番号は 1 から CameraCapabilities.PresetCount まで（未報告の場合は defaultPresetLimit まで）です。
+type PresetUsecase struct {
+	repo       *infrastructure.PresetRepo
+	cameraRepo *infrastructure.CameraRepo
+}
+
+func NewPresetUsecase(repo *infrastructure.PresetRepo, cameraRepo *infrastructure.CameraRepo) *PresetUsecase {
+	return &PresetUsecase{
+		repo:       repo,
+		cameraRepo: cameraRepo,
+	}
+}
+
+// SetPreset はプリセットを保存します。PTZが指定されていない場合はカメラの現在のPTZを使います。
+// 名前が指定されていない場合は、既存のプリセットの名前または "Preset <番号>" を使います。
+func (u *PresetUsecase) SetPreset(
+	ctx context.Context,
+	preset *infrastructure.Preset,
+) (*infrastructure.Preset, error) {
+	camera, err := u.validatePresetNumber(preset.CameraID, preset.Number)
+	if err != nil {
+		return nil, err
+	}
+
+	if preset.PTZ == nil {
+		preset.PTZ = camera.GetCurrentPtz()
+	}
+
+	if preset.PTZ == nil {
+		return nil, fmt.Errorf("%w: camera %s has not reported its ptz", ErrPresetPTZRequired, preset.CameraID)
+	}
+
+	if preset.Name == "" {
+		preset.Name = fmt.Sprintf("Preset %d", preset.Number)
+
+		if existing := u.repo.GetPreset(preset.CameraID, preset.Number); existing != nil {
+			preset.Name = existing.Name
+		}
+	}
+
+	return u.repo.SavePreset(preset), nil
+}
+
+func (u *PresetUsecase) GetPreset(
+	ctx context.Context,
+	cameraID string,
+	number uint32,
+) (*infrastructure.Preset, error) {
+	preset := u.repo.GetPreset(cameraID, number)
+	if preset == nil {
+		return nil, fmt.Errorf("%w: camera %s preset %d", infrastructure.ErrPresetNotFound, cameraID, number)
+	}
+
+	return preset, nil
+}
+
+func (u *PresetUsecase) ListPresets(
+	ctx context.Context,
+	cameraID string,
+) ([]*infrastructure.Preset, error) {
+	if u.cameraRepo.GetCamera(cameraID) == nil {
+		return nil, ErrCameraNotFound
+	}
+
+	return u.repo.ListPresets(cameraID), nil
+}
+
+func (u *PresetUsecase) DeletePreset(
+	ctx context.Context,
+	cameraID string,
+	number uint32,
+) error {
+	if !u.repo.DeletePreset(cameraID, number) {
+		return fmt.Errorf("%w: camera %s preset %d", infrastructure.ErrPresetNotFound, cameraID, number)
+	}
+
+	return nil
+}
+
+// validatePresetNumber はカメラが登録済みで、番号が保存できる範囲にあることを確認します。
+func (u *PresetUsecase) validatePresetNumber(cameraID string, number uint32) (*protov1.Camera, error) {
+	camera := u.cameraRepo.GetCamera(cameraID)
+	if camera == nil {
+		return nil, ErrCameraNotFound
+	}
+
+	caps := u.cameraRepo.GetCapabilities(cameraID)
+	if caps != nil && !caps.GetSupportsPtz() {
+		return nil, fmt.Errorf("%w: %s", ErrPresetNotSupported, cameraID)
+	}
+
+	limit := presetLimit(caps)
+	if number == 0 || number > limit {
+		return nil, fmt.Errorf("%w: %d is not within 1-%d", ErrPresetOutOfRange, number, limit)
+	}
+
+	return camera, nil
+}
+
+// hasHardwarePresets はカメラ自身がプリセットを保存できるかどうかを返します。
+func hasHardwarePresets(caps *protov1.CameraCapabilities) bool {
+	return caps.GetPresetCount() > 0
+}
+
+func presetLimit(caps *protov1.CameraCapabilities) uint32 {
+	if hasHardwarePresets(caps) {
+		return caps.GetPresetCount()
+	}
+
+	return defaultPresetLimit
+}
+
+// sendPresetSet は PRESET_SET を処理します。PTZとフォーカスが指定されていない場合は、
+// FDが最後に報告した状態を保存します。カメラがプリセットを持つ場合は命令をFDにも送り、FDの結果を待ちます。
+func (u *FDUsecase) sendPresetSet(
+	ctx context.Context,
+	command *protov1.ControlCommand,
+) (*infrastructure.ControlCommandStatus, error) {
+	cameraID := command.GetCameraId()
+	state := u.repo.GetCameraState(cameraID)
+
+	ptz := command.GetPtzParameters()
+	if ptz == nil && u.cameraRepo.GetCamera(cameraID).GetCurrentPtz() == nil {
+		ptz = state.GetCurrentPtz()
+	}
+
+	focus := command.GetFocusValue()
+	if focus == 0 {
+		focus = state.GetCurrentFocus()
+	}
+
+	preset, err := u.presets.SetPreset(ctx, &infrastructure.Preset{
+		CameraID:    cameraID,
+		Number:      command.GetPresetNumber(),
+		Name:        "",
+		PTZ:         ptz,
+		Focus:       focus,
+		UpdatedAtMs: 0,
+	})
+	if err != nil {
+		return u.rejectControlCommand(command, err), nil
+	}
+
+	if !hasHardwarePresets(u.cameraRepo.GetCapabilities(cameraID)) {
+		return u.repo.FinishControlCommand(command, &protov1.ControlCommandResult{
+			CommandId:       command.GetCommandId(),
+			Success:         true,
+			ErrorMessage:    "",
+			ResultingPtz:    preset.PTZ,
+			ExecutionTimeMs: 0,
+		}), nil
+	}
+
+	return u.dispatchControlCommand(command), nil
+}
+
+// sendPresetGoto は PRESET_GOTO を処理します。保存したPTZとフォーカスへの絶対移動（PTZ_ABSOLUTE）として
+// 制限を確認し、キューのPTZ枠に追加します。プリセットを持たないFDには絶対移動に置き換えて送り、
+// プリセットを持つFDにはそのまま送ります。保存していないプリセットは移動先を確認できないため拒否します。
+func (u *FDUsecase) sendPresetGoto(
+	ctx context.Context,
+	command *protov1.ControlCommand,
+) (*infrastructure.ControlCommandStatus, error) {
+	cameraID := command.GetCameraId()
+
+	preset, err := u.presets.GetPreset(ctx, cameraID, command.GetPresetNumber())
+	if err != nil {
+		return u.rejectControlCommand(command, err), nil
+	}
+
+	resolved, ok := proto.Clone(command).(*protov1.ControlCommand)
+	if !ok {
+		return u.rejectControlCommand(command, errors.New("failed to clone control command")), nil
+	}
+
+	resolved.Type = protov1.ControlCommandType_CONTROL_COMMAND_TYPE_PTZ_ABSOLUTE
+	resolved.PtzParameters = preset.PTZ
+	resolved.FocusValue = preset.Focus
+
+	if hasHardwarePresets(u.cameraRepo.GetCapabilities(cameraID)) {
+		return u.dispatchMove(command, resolved), nil
+	}
+
+	return u.dispatchControlCommand(resolved), nil
+}
@@ -30,19 +30,41 @@ type PTZInteractor interface {
 
 // PTZUsecase はPTZサービスのユースケース実装です。
 type PTZUsecase struct {
-	repo *infrastructure.PTZRepo
+	repo           *infrastructure.PTZRepo
+	cameraRepo     *infrastructure.CameraRepo
+	telemetryRepo  *infrastructure.TelemetryRepo
+	estimator      *infrastructure.PTZEstimator
+	limits         ptzLimitChecker
+	federationRepo *infrastructure.FederationRepo
 }
 
 // NewPTZUsecase は新しいPTZUsecaseを作成します。
-func NewPTZUsecase(repo *infrastructure.PTZRepo) *PTZUsecase {
-	return &PTZUsecase{repo: repo}
+func NewPTZUsecase(
+	repo *infrastructure.PTZRepo,
+	cameraRepo *infrastructure.CameraRepo,
+	telemetryRepo *infrastructure.TelemetryRepo,
+	estimator *infrastructure.PTZEstimator,
+	federationRepo *infrastructure.FederationRepo,
+) *PTZUsecase {
+	return &PTZUsecase{
+		repo:           repo,
+		cameraRepo:     cameraRepo,
+		telemetryRepo:  telemetryRepo,
+		estimator:      estimator,
+		limits:         newPTZLimitChecker(cameraRepo, estimator),
+		federationRepo: federationRepo,
+	}
 }
 
-// Polling はFDからのポーリングリクエストを処理します。
+// Polling はFDからのポーリングリクエストを処理します。リクエストが含む状態は時系列とPTZの推定に記録します。
 func (u *PTZUsecase) Polling(
 	ctx context.Context,
 	req *protov1.PollingRequest,
 ) (*protov1.PollingResponse, error) {
+	now := time.Now().UnixMilli()
+	u.telemetryRepo.RecordPolling(req, now)
+	u.estimator.Observe(req.GetCameraId(), req.GetCurrentPtz(), now)
+
 	currentCommand, nextCommand, interrupt := u.repo.ProcessPolling(
 		req.GetCameraId(),
 		req.GetCompletedTaskId(),
@@ -61,6 +83,7 @@ func (u *PTZUsecase) Polling(
 }
 
 // SendPTZCommand はEPからのPTZ命令を受け付けます。
+// 命令はカメラの制限で確認し、補正した場合は受け付けた上で理由を error_message に設定します。
 func (u *PTZUsecase) SendPTZCommand(
 	ctx context.Context,
 	req *protov1.SendPTZCommandRequest,
@@ -84,16 +107,30 @@ func (u *PTZUsecase) SendPTZCommand(
 		}, nil
 	}
 
+	if peer, ok := u.findRemoteOwner(ctx, cameraID); ok {
+		return u.federationRepo.SendPTZCommand(ctx, peer, req)
+	}
+
+	command, adjustment, err := u.limits.checkPTZCommand(cameraID, command)
+	if err != nil {
+		return &protov1.SendPTZCommandResponse{
+			Accepted:     false,
+			TaskId:       "",
+			ErrorMessage: err.Error(),
+		}, nil
+	}
+
 	taskID, accepted := u.repo.EnqueuePTZCommand(cameraID, command)
 
 	return &protov1.SendPTZCommandResponse{
 		Accepted:     accepted,
 		TaskId:       taskID,
-		ErrorMessage: "",
+		ErrorMessage: adjustment,
 	}, nil
 }
 
 // SendCinematicCommand はEPからのシネマティック命令を受け付けます。
+// 目標のPTZを持つ命令はカメラの制限で確認し、補正した場合は受け付けた上で理由を error_message に設定します。
 func (u *PTZUsecase) SendCinematicCommand(
 	ctx context.Context,
 	req *protov1.SendCinematicCommandRequest,
@@ -117,12 +154,25 @@ func (u *PTZUsecase) SendCinematicCommand(
 		}, nil
 	}
 
+	if peer, ok := u.findRemoteOwner(ctx, cameraID); ok {
+		return u.federationRepo.SendCinematicCommand(ctx, peer, req)
+	}
+
+	command, adjustment, err := u.limits.checkInstruction(cameraID, command)
+	if err != nil {
+		return &protov1.SendCinematicCommandResponse{
+			Accepted:     false,
+			TaskId:       "",
+			ErrorMessage: err.Error(),
+		}, nil
+	}
+
 	taskID, accepted := u.repo.EnqueueCinematicCommand(cameraID, command)
 
 	return &protov1.SendCinematicCommandResponse{
 		Accepted:     accepted,
 		TaskId:       taskID,
-		ErrorMessage: "",
+		ErrorMessage: adjustment,
 	}, nil
 }
 
@@ -146,3 +196,20 @@ func (u *PTZUsecase) GetQueueStatus(
 		CameraQueues: statuses,
 	}, nil
 }
+
+// findRemoteOwner はカメラが子CRに所有されている場合、その子CRを返します。
+// ローカルに登録されたカメラや、親CRから転送されたリクエストは常にローカルで処理します。
+func (u *PTZUsecase) findRemoteOwner(
+	ctx context.Context,
+	cameraID string,
+) (*infrastructure.FederationPeer, bool) {
+	if !u.federationRepo.HasPeers() || infrastructure.IsFederatedRequest(ctx) {
+		return nil, false
+	}
+
+	if u.cameraRepo.GetCamera(cameraID) != nil {
+		return nil, false
+	}
+
+	return u.federationRepo.FindCameraOwner(ctx, cameraID)
+}
@@ -0,0 +1,255 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+
+	protov1 "github.com/anyfld/vistra-operation-control-room/gen/proto/v1"
+	"github.com/anyfld/vistra-operation-control-room/pkg/transport/infrastructure"
+)
+
+// ptzLimitChecker はカメラのソフトリミットと禁止領域で、FDへ届く前の移動の命令を確認します。
+// 相対移動と連続移動は、推定のPTZ（推定できない場合は最後に報告されたPTZ）を現在の位置として確認します。
+// 補正した場合は補正した命令と理由を、拒否する場合は infrastructure.ErrPTZLimitViolation を返します。
+type ptzLimitChecker struct {
+	cameraRepo *infrastructure.CameraRepo
+	estimator  *infrastructure.PTZEstimator
+}
+
+func newPTZLimitChecker(
+	cameraRepo *infrastructure.CameraRepo,
+	estimator *infrastructure.PTZEstimator,
+) ptzLimitChecker {
+	return ptzLimitChecker{cameraRepo: cameraRepo, estimator: estimator}
+}
+
+// checkControlCommand は移動系の制御コマンドを確認します。
+func (c ptzLimitChecker) checkControlCommand(
+	command *protov1.ControlCommand,
+) (*protov1.ControlCommand, string, error) {
+	limits, err := c.limits(command.GetCameraId())
+	if err != nil || limits == nil {
+		return command, "", err
+	}
+
+	return limits.CheckControlCommand(command, c.currentPTZ(command.GetCameraId()))
+}
+
+// checkPTZCommand はPTZ命令を、角度で表した制御コマンドに変換して確認します。
+func (c ptzLimitChecker) checkPTZCommand(
+	cameraID string,
+	command *protov1.PTZCommand,
+) (*protov1.PTZCommand, string, error) {
+	limits, err := c.limits(cameraID)
+	if err != nil || limits == nil {
+		return command, "", err
+	}
+
+	optics := c.optics(cameraID)
+
+	control := optics.ControlCommandFromPTZ("", cameraID, command)
+	if control == nil {
+		return command, "", nil
+	}
+
+	checked, reason, err := limits.CheckControlCommand(control, c.currentPTZ(cameraID))
+	if err != nil || reason == "" {
+		return command, "", err
+	}
+
+	return optics.PTZCommandFromControl(checked), reason, nil
+}
+
+// checkInstruction はシネマティック命令の目標のPTZを、カメラの絶対移動として確認します。
+// 目標は ptz_parameters、指定がなければショットの種類と被写体のバウンディングボックスから SolveFraming で展開したPTZです。
+// 制限を設定したカメラでは、FDが確認した目標と異なる位置へ展開しないよう、展開した目標を ptz_parameters に設定します。
+// 被写体の位置が分からず展開できない命令は、目標を確認できないためそのまま返します。
+func (c ptzLimitChecker) checkInstruction(
+	cameraID string,
+	instruction *protov1.CinematographyInstruction,
+) (*protov1.CinematographyInstruction, string, error) {
+	limits, err := c.limits(cameraID)
+	if err != nil || limits == nil {
+		return instruction, "", err
+	}
+
+	params := instruction.GetPtzParameters()
+	if params == nil {
+		params = c.expandInstruction(cameraID, instruction)
+		if params == nil {
+			return instruction, "", nil
+		}
+	}
+
+	checked, reason, err := limits.CheckControlCommand(&protov1.ControlCommand{
+		CommandId:     instruction.GetInstructionId(),
+		CameraId:      cameraID,
+		Type:          protov1.ControlCommandType_CONTROL_COMMAND_TYPE_PTZ_ABSOLUTE,
+		PtzParameters: params,
+		PresetNumber:  0,
+		FocusValue:    0,
+		ArmParameters: nil,
+		TimeoutMs:     0,
+	}, nil)
+	if err != nil {
+		return instruction, "", err
+	}
+
+	if reason == "" && instruction.GetPtzParameters() != nil {
+		return instruction, "", nil
+	}
+
+	clipped, ok := proto.Clone(instruction).(*protov1.CinematographyInstruction)
+	if !ok {
+		return instruction, "", nil
+	}
+
+	clipped.PtzParameters = checked.GetPtzParameters()
+
+	return clipped, reason, nil
+}
+
+// expandInstruction はショットの種類と被写体から、シネマティック命令の目標のPTZを求めます。
+// バウンディングボックスは現在の位置の画面の座標のため、現在の位置が分からない場合は nil を返します。
+func (c ptzLimitChecker) expandInstruction(
+	cameraID string,
+	instruction *protov1.CinematographyInstruction,
+) *protov1.PTZParameters {
+	current := c.currentPTZ(cameraID)
+	if current == nil || len(instruction.GetSubjects()) == 0 {
+		return nil
+	}
+
+	subjects := make([]*protov1.DetectedSubject, 0, len(instruction.GetSubjects()))
+	for _, subject := range instruction.GetSubjects() {
+		subjects = append(subjects, &protov1.DetectedSubject{Subject: subject, Confidence: 0, DetectedBox: nil})
+	}
+
+	ptz, _, err := infrastructure.SolveFraming(
+		c.optics(cameraID), current, instruction.GetShotType(), subjects, instruction.GetCameraAngle(),
+	)
+	if err != nil {
+		return nil
+	}
+
+	return ptz
+}
+
+// checkTrajectory はPTZの軌道の全てのキーフレームの位置を、カメラの絶対移動として確認します。
+// 軌道の形を保つため、補正が必要なキーフレームがある場合も infrastructure.ErrPTZLimitViolation を返します。
+func (c ptzLimitChecker) checkTrajectory(cameraID string, trajectory *infrastructure.PTZTrajectory) error {
+	limits, err := c.limits(cameraID)
+	if err != nil || limits == nil {
+		return err
+	}
+
+	absolute := *trajectory
+	absolute.Mode = infrastructure.TrajectoryMoveAbsolute
+
+	for i := range trajectory.Keyframes {
+		_, reason, err := limits.CheckControlCommand(absolute.ControlCommand(i, "", cameraID), nil)
+		if err != nil {
+			return fmt.Errorf("keyframe %d: %w", i+1, err)
+		}
+
+		if reason != "" {
+			return fmt.Errorf("%w: keyframe %d: %s", infrastructure.ErrPTZLimitViolation, i+1, reason)
+		}
+	}
+
+	return nil
+}
+
+// limits はカメラの制限を返します。登録した設定が能力情報と矛盾する場合は、全ての移動を拒否するためにエラーを返します。
+func (c ptzLimitChecker) limits(cameraID string) (*infrastructure.PTZLimits, error) {
+	limits, err := infrastructure.NewPTZLimits(c.optics(cameraID), c.cameraRepo.GetPTZLimits(cameraID))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", infrastructure.ErrPTZLimitViolation, err)
+	}
+
+	return limits, nil
+}
+
+func (c ptzLimitChecker) optics(cameraID string) *infrastructure.CameraOptics {
+	return c.cameraRepo.GetOptics(cameraID)
+}
+
+func (c ptzLimitChecker) currentPTZ(cameraID string) *protov1.PTZParameters {
+	if estimate, ok := c.estimator.Estimate(cameraID, time.Now().UnixMilli()); ok {
+		return estimate.PTZ
+	}
+
+	return c.cameraRepo.GetCamera(cameraID).GetCurrentPtz()
+}
+
+var ErrPTZLimitsNotFound = errors.New("ptz limits not found")
+
+type PTZLimitsInteractor interface {
+	SetPTZLimits(
+		ctx context.Context,
+		cameraID string,
+		config *infrastructure.PTZLimitConfig,
+	) (*infrastructure.PTZLimitConfig, error)
+	GetPTZLimits(ctx context.Context, cameraID string) (*infrastructure.PTZLimitConfig, error)
+	DeletePTZLimits(ctx context.Context, cameraID string) error
+}
+
+// PTZLimitsUsecase はカメラのソフトリミットと禁止領域の設定を扱います。
+// 設定は保存する時点で能力情報と照らして検証し、移動の命令は ptzLimitChecker が保存した設定で確認します。
+type PTZLimitsUsecase struct {
+	cameraRepo *infrastructure.CameraRepo
+}
+
+func NewPTZLimitsUsecase(cameraRepo *infrastructure.CameraRepo) *PTZLimitsUsecase {
+	return &PTZLimitsUsecase{cameraRepo: cameraRepo}
+}
+
+// SetPTZLimits は設定を検証して保存します。
+func (u *PTZLimitsUsecase) SetPTZLimits(
+	ctx context.Context,
+	cameraID string,
+	config *infrastructure.PTZLimitConfig,
+) (*infrastructure.PTZLimitConfig, error) {
+	if u.cameraRepo.GetCamera(cameraID) == nil {
+		return nil, fmt.Errorf("%w: %s", ErrCameraNotFound, cameraID)
+	}
+
+	if err := config.Validate(u.cameraRepo.GetCapabilities(cameraID)); err != nil {
+		return nil, err
+	}
+
+	if !u.cameraRepo.SetPTZLimits(cameraID, config) {
+		return nil, fmt.Errorf("%w: %s", ErrCameraNotFound, cameraID)
+	}
+
+	return config, nil
+}
+
+func (u *PTZLimitsUsecase) GetPTZLimits(
+	ctx context.Context,
+	cameraID string,
+) (*infrastructure.PTZLimitConfig, error) {
+	if u.cameraRepo.GetCamera(cameraID) == nil {
+		return nil, fmt.Errorf("%w: %s", ErrCameraNotFound, cameraID)
+	}
+
+	config := u.cameraRepo.GetPTZLimits(cameraID)
+	if config == nil {
+		return nil, fmt.Errorf("%w: camera %s", ErrPTZLimitsNotFound, cameraID)
+	}
+
+	return config, nil
+}
+
+// DeletePTZLimits は設定を削除し、カメラの移動を能力情報の可動範囲だけで扱うようにします。
+func (u *PTZLimitsUsecase) DeletePTZLimits(ctx context.Context, cameraID string) error {
+	if !u.cameraRepo.SetPTZLimits(cameraID, nil) {
+		return fmt.Errorf("%w: %s", ErrCameraNotFound, cameraID)
+	}
+
+	return nil
+}
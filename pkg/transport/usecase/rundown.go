@@ -0,0 +1,725 @@
+package usecase
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"gopkg.in/yaml.v3"
+
+	protov1 "github.com/anyfld/vistra-operation-control-room/gen/proto/v1"
+	"github.com/anyfld/vistra-operation-control-room/pkg/transport/infrastructure"
+)
+
+const (
+	rundownActionCinematography = "cinematography"
+	rundownActionPTZ            = "ptz"
+	rundownActionSwitchSource   = "switch_source"
+	rundownActionFocusPull      = "focus_pull"
+)
+
+type RundownInteractor interface {
+	UploadRundown(ctx context.Context, data []byte) (*infrastructure.Rundown, error)
+	ListRundowns(ctx context.Context) ([]*infrastructure.Rundown, error)
+	GetRundown(ctx context.Context, rundownID string) (*infrastructure.Rundown, *infrastructure.RundownState, error)
+	DeleteRundown(ctx context.Context, rundownID string) error
+	StartRundown(
+		ctx context.Context,
+		rundownID string,
+		mode infrastructure.RundownMode,
+	) (*infrastructure.RundownState, error)
+	StopRundown(ctx context.Context, rundownID string) (*infrastructure.RundownState, error)
+	NextCue(ctx context.Context, rundownID string) (*infrastructure.RundownState, error)
+	GoToCue(ctx context.Context, rundownID string, cueID string) (*infrastructure.RundownState, error)
+	SubscribeRundownState(ctx context.Context, rundownID string) (<-chan *infrastructure.RundownState, error)
+	UnsubscribeRundownState(ctx context.Context, rundownID string, ch <-chan *infrastructure.RundownState)
+}
+
+var (
+	ErrInvalidRundown     = errors.New("invalid rundown")
+	ErrInvalidRundownMode = errors.New("invalid rundown mode")
+	ErrRundownNotRunning  = errors.New("rundown is not running")
+	ErrNoNextCue          = errors.New("no next cue")
+	ErrCueNotFound        = errors.New("cue not found")
+)
+
+// rundownDocument はアップロードされる台本のYAML/JSON表現です。
+// 操作は既存のRPCメッセージ（protojson形式）で記述します。
+type rundownDocument struct {
+	Name string               `json:"name"`
+	Cues []rundownCueDocument `json:"cues"`
+}
+
+type rundownCueDocument struct {
+	ID         any                     `json:"id"`
+	Label      string                  `json:"label"`
+	Timecode   string                  `json:"timecode"`
+	TimecodeMs *int64                  `json:"timecode_ms"`
+	Actions    []rundownActionDocument `json:"actions"`
+}
+
+type rundownActionDocument struct {
+	Cinematography json.RawMessage `json:"cinematography"`
+	PTZ            json.RawMessage `json:"ptz"`
+	SwitchSource   json.RawMessage `json:"switch_source"`
+	FocusPull      json.RawMessage `json:"focus_pull"`
+}
+
+// cueSelector は再生状態から次に実行するキューの位置を選びます。
+type cueSelector func(rundown *infrastructure.Rundown, state *infrastructure.RundownState) (int, error)
+
+// rundownPlayer はタイムコード再生中の台本のゴルーチンを制御します。
+type rundownPlayer struct {
+	cancel context.CancelFunc
+	kick   chan struct{}
+}
+
+// RundownUsecase は台本の登録とキューの実行を行います。
+// キューの操作は既存のCR（演出指示）、PTZキュー、MD出力、FD（フォーカス）のユースケースを経由して実行します。
+type RundownUsecase struct {
+	repo      *infrastructure.RundownRepo
+	crUC      CRInteractor
+	ptzUC     PTZInteractor
+	mdUC      MDInteractor
+	fdUC      FDInteractor
+	fireMu    sync.Mutex
+	playersMu sync.Mutex
+	players   map[string]*rundownPlayer
+}
+
+func NewRundownUsecase(
+	repo *infrastructure.RundownRepo,
+	crUC CRInteractor,
+	ptzUC PTZInteractor,
+	mdUC MDInteractor,
+	fdUC FDInteractor,
+) *RundownUsecase {
+	return &RundownUsecase{
+		repo:      repo,
+		crUC:      crUC,
+		ptzUC:     ptzUC,
+		mdUC:      mdUC,
+		fdUC:      fdUC,
+		fireMu:    sync.Mutex{},
+		playersMu: sync.Mutex{},
+		players:   make(map[string]*rundownPlayer),
+	}
+}
+
+// UploadRundown はYAMLまたはJSONの台本を解析して保存します。
+func (u *RundownUsecase) UploadRundown(ctx context.Context, data []byte) (*infrastructure.Rundown, error) {
+	rundown, err := ParseRundown(data)
+	if err != nil {
+		return nil, err
+	}
+
+	return u.repo.SaveRundown(rundown), nil
+}
+
+func (u *RundownUsecase) ListRundowns(ctx context.Context) ([]*infrastructure.Rundown, error) {
+	return u.repo.ListRundowns(), nil
+}
+
+func (u *RundownUsecase) GetRundown(
+	ctx context.Context,
+	rundownID string,
+) (*infrastructure.Rundown, *infrastructure.RundownState, error) {
+	rundown, ok := u.repo.GetRundown(rundownID)
+	if !ok {
+		return nil, nil, infrastructure.ErrRundownNotFound
+	}
+
+	state, ok := u.repo.GetState(rundownID)
+	if !ok {
+		return nil, nil, infrastructure.ErrRundownNotFound
+	}
+
+	return rundown, state, nil
+}
+
+func (u *RundownUsecase) DeleteRundown(ctx context.Context, rundownID string) error {
+	u.stopPlayer(rundownID)
+
+	if !u.repo.DeleteRundown(rundownID) {
+		return infrastructure.ErrRundownNotFound
+	}
+
+	return nil
+}
+
+// StartRundown は台本の再生を先頭から開始します。最初のキューは実行しません。
+// タイムコードモードでは、タイムコードを持つキューを経過時間に従って自動で実行します。
+func (u *RundownUsecase) StartRundown(
+	ctx context.Context,
+	rundownID string,
+	mode infrastructure.RundownMode,
+) (*infrastructure.RundownState, error) {
+	if mode == "" {
+		mode = infrastructure.RundownModeManual
+	}
+
+	if mode != infrastructure.RundownModeManual && mode != infrastructure.RundownModeTimecode {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidRundownMode, mode)
+	}
+
+	rundown, ok := u.repo.GetRundown(rundownID)
+	if !ok {
+		return nil, infrastructure.ErrRundownNotFound
+	}
+
+	u.stopPlayer(rundownID)
+
+	state, err := u.repo.UpdateState(rundownID, func(state *infrastructure.RundownState) error {
+		state.Status = infrastructure.RundownStatusRunning
+		state.Mode = mode
+		state.CurrentIndex = -1
+		state.StartedAtMs = time.Now().UnixMilli()
+		state.FiredAtMs = 0
+		state.LastResults = nil
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if mode == infrastructure.RundownModeTimecode {
+		u.startPlayer(ctx, rundown)
+	}
+
+	return state, nil
+}
+
+func (u *RundownUsecase) StopRundown(ctx context.Context, rundownID string) (*infrastructure.RundownState, error) {
+	u.stopPlayer(rundownID)
+
+	return u.repo.UpdateState(rundownID, func(state *infrastructure.RundownState) error {
+		state.Status = infrastructure.RundownStatusStopped
+
+		return nil
+	})
+}
+
+// NextCue は次のキューを実行します。
+func (u *RundownUsecase) NextCue(ctx context.Context, rundownID string) (*infrastructure.RundownState, error) {
+	state, err := u.fire(ctx, rundownID, true, selectNextCue)
+	if err != nil {
+		return nil, err
+	}
+
+	u.kickPlayer(rundownID)
+
+	return state, nil
+}
+
+// GoToCue は指定したキューへ移動して実行します。
+// タイムコードモードでは、以降のキューが移動先のタイムコードを基準に実行されるよう開始時刻を調整します。
+func (u *RundownUsecase) GoToCue(
+	ctx context.Context,
+	rundownID string,
+	cueID string,
+) (*infrastructure.RundownState, error) {
+	state, err := u.fire(ctx, rundownID, true, selectCueByID(cueID))
+	if err != nil {
+		return nil, err
+	}
+
+	u.kickPlayer(rundownID)
+
+	return state, nil
+}
+
+func selectNextCue(rundown *infrastructure.Rundown, state *infrastructure.RundownState) (int, error) {
+	next := state.CurrentIndex + 1
+	if next >= len(rundown.Cues) {
+		return 0, ErrNoNextCue
+	}
+
+	return next, nil
+}
+
+func selectCueByID(cueID string) cueSelector {
+	return func(rundown *infrastructure.Rundown, _ *infrastructure.RundownState) (int, error) {
+		for i, cue := range rundown.Cues {
+			if cue.ID == cueID {
+				return i, nil
+			}
+		}
+
+		return 0, fmt.Errorf("%w: %s", ErrCueNotFound, cueID)
+	}
+}
+
+// selectExpectedCue は再生位置が expected の直前のままである場合のみ expected を選びます。
+// 待機中に手動操作で位置が変わった場合は ErrNoNextCue を返します。
+func selectExpectedCue(expected int) cueSelector {
+	return func(_ *infrastructure.Rundown, state *infrastructure.RundownState) (int, error) {
+		if state.CurrentIndex+1 != expected {
+			return 0, ErrNoNextCue
+		}
+
+		return expected, nil
+	}
+}
+
+func (u *RundownUsecase) SubscribeRundownState(
+	ctx context.Context,
+	rundownID string,
+) (<-chan *infrastructure.RundownState, error) {
+	return u.repo.SubscribeState(rundownID)
+}
+
+func (u *RundownUsecase) UnsubscribeRundownState(
+	ctx context.Context,
+	rundownID string,
+	ch <-chan *infrastructure.RundownState,
+) {
+	u.repo.UnsubscribeState(rundownID, ch)
+}
+
+// fire は selectCue が選んだキューを実行し、再生状態を更新します。
+// キューの実行は直列化され、タイムコードと手動操作が同じキューを二重に実行することはありません。
+// manual が true の場合、タイムコードモードの開始時刻を実行したキューのタイムコードに合わせます。
+func (u *RundownUsecase) fire(
+	ctx context.Context,
+	rundownID string,
+	manual bool,
+	selectCue cueSelector,
+) (*infrastructure.RundownState, error) {
+	u.fireMu.Lock()
+	defer u.fireMu.Unlock()
+
+	rundown, ok := u.repo.GetRundown(rundownID)
+	if !ok {
+		return nil, infrastructure.ErrRundownNotFound
+	}
+
+	state, ok := u.repo.GetState(rundownID)
+	if !ok {
+		return nil, infrastructure.ErrRundownNotFound
+	}
+
+	if state.Status != infrastructure.RundownStatusRunning {
+		return nil, ErrRundownNotRunning
+	}
+
+	index, err := selectCue(rundown, state)
+	if err != nil {
+		return nil, err
+	}
+
+	cue := rundown.Cues[index]
+	results := u.executeCue(ctx, cue)
+
+	return u.repo.UpdateState(rundownID, func(state *infrastructure.RundownState) error {
+		now := time.Now().UnixMilli()
+
+		if manual && state.Mode == infrastructure.RundownModeTimecode && cue.HasTimecode {
+			state.StartedAtMs = now - cue.TimecodeMs
+		}
+
+		state.CurrentIndex = index
+		state.FiredAtMs = now
+		state.LastResults = results
+
+		if index == len(rundown.Cues)-1 {
+			state.Status = infrastructure.RundownStatusFinished
+		}
+
+		return nil
+	})
+}
+
+func (u *RundownUsecase) executeCue(
+	ctx context.Context,
+	cue *infrastructure.RundownCue,
+) []*infrastructure.RundownActionResult {
+	results := make([]*infrastructure.RundownActionResult, 0, len(cue.Actions))
+
+	for _, action := range cue.Actions {
+		results = append(results, u.executeAction(ctx, action))
+	}
+
+	return results
+}
+
+func (u *RundownUsecase) executeAction(
+	ctx context.Context,
+	action *infrastructure.RundownAction,
+) *infrastructure.RundownActionResult {
+	result := &infrastructure.RundownActionResult{Action: "", Success: false, Error: "", TaskID: ""}
+
+	switch {
+	case action.Cinematography != nil:
+		result.Action = rundownActionCinematography
+
+		res, err := u.crUC.SendCinematographyInstruction(ctx, &protov1.SendCinematographyInstructionRequest{
+			Instruction: proto.CloneOf(action.Cinematography),
+		})
+		if err != nil {
+			result.Error = err.Error()
+
+			return result
+		}
+
+		result.Success = res.GetAccepted()
+		result.TaskID = res.GetInstructionId()
+	case action.PTZ != nil:
+		result.Action = rundownActionPTZ
+
+		res, err := u.ptzUC.SendPTZCommand(ctx, proto.CloneOf(action.PTZ))
+		if err != nil {
+			result.Error = err.Error()
+
+			return result
+		}
+
+		result.Success = res.GetAccepted()
+		result.TaskID = res.GetTaskId()
+		result.Error = res.GetErrorMessage()
+	case action.SwitchSource != nil:
+		result.Action = rundownActionSwitchSource
+
+		ok, err := u.mdUC.SwitchSource(
+			ctx,
+			action.SwitchSource.GetOutputId(),
+			action.SwitchSource.GetNewSourceCameraId(),
+		)
+		if err != nil {
+			result.Error = err.Error()
+
+			return result
+		}
+
+		result.Success = ok
+		if !ok {
+			result.Error = "video output not found"
+		}
+	case action.FocusPull != nil:
+		result.Action = rundownActionFocusPull
+
+		taskID, err := u.fdUC.EnqueueFocusMove(
+			ctx,
+			action.FocusPull.CameraID,
+			&infrastructure.FocusMove{Target: action.FocusPull.Focus, DurationMs: action.FocusPull.DurationMs},
+			protov1.CommandLayer_COMMAND_LAYER_CINEMATIC,
+		)
+		if err != nil {
+			result.Error = err.Error()
+
+			return result
+		}
+
+		result.Success = true
+		result.TaskID = taskID
+	}
+
+	return result
+}
+
+func (u *RundownUsecase) startPlayer(ctx context.Context, rundown *infrastructure.Rundown) {
+	// 再生はリクエストの終了後も継続する
+	playerCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+	player := &rundownPlayer{cancel: cancel, kick: make(chan struct{}, 1)}
+
+	u.playersMu.Lock()
+	u.players[rundown.ID] = player
+	u.playersMu.Unlock()
+
+	go u.runTimecode(playerCtx, rundown, player)
+}
+
+func (u *RundownUsecase) stopPlayer(rundownID string) {
+	u.playersMu.Lock()
+	defer u.playersMu.Unlock()
+
+	if player, ok := u.players[rundownID]; ok {
+		player.cancel()
+		delete(u.players, rundownID)
+	}
+}
+
+// kickPlayer は手動操作で再生位置が変わったことをタイムコード再生に通知します。
+func (u *RundownUsecase) kickPlayer(rundownID string) {
+	u.playersMu.Lock()
+	defer u.playersMu.Unlock()
+
+	if player, ok := u.players[rundownID]; ok {
+		select {
+		case player.kick <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (u *RundownUsecase) runTimecode(
+	ctx context.Context,
+	rundown *infrastructure.Rundown,
+	player *rundownPlayer,
+) {
+	for {
+		state, ok := u.repo.GetState(rundown.ID)
+		if !ok || state.Status != infrastructure.RundownStatusRunning {
+			return
+		}
+
+		expected := state.CurrentIndex + 1
+		if expected >= len(rundown.Cues) {
+			return
+		}
+
+		cue := rundown.Cues[expected]
+
+		// タイムコードのないキューは手動操作を待つ
+		if !cue.HasTimecode {
+			select {
+			case <-ctx.Done():
+				return
+			case <-player.kick:
+				continue
+			}
+		}
+
+		wait := time.Until(time.UnixMilli(state.StartedAtMs + cue.TimecodeMs))
+		timer := time.NewTimer(max(wait, 0))
+
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+
+			return
+		case <-player.kick:
+			timer.Stop()
+
+			continue
+		case <-timer.C:
+		}
+
+		_, err := u.fire(ctx, rundown.ID, false, selectExpectedCue(expected))
+		if err != nil && !errors.Is(err, ErrNoNextCue) {
+			return
+		}
+	}
+}
+
+// ParseRundown はYAMLまたはJSONの台本を解析します。JSONはYAMLとして解析できるため、
+// 形式の指定は不要です。
+func ParseRundown(data []byte) (*infrastructure.Rundown, error) {
+	var raw any
+	if err := yaml.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidRundown, err)
+	}
+
+	// 操作をprotojsonで解析するため、一度JSONへ変換する
+	jsonData, err := json.Marshal(raw)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidRundown, err)
+	}
+
+	var doc rundownDocument
+	if err := json.Unmarshal(jsonData, &doc); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidRundown, err)
+	}
+
+	if len(doc.Cues) == 0 {
+		return nil, fmt.Errorf("%w: at least one cue is required", ErrInvalidRundown)
+	}
+
+	rundown := &infrastructure.Rundown{
+		ID:          "",
+		Name:        doc.Name,
+		Cues:        make([]*infrastructure.RundownCue, 0, len(doc.Cues)),
+		CreatedAtMs: 0,
+	}
+
+	seen := make(map[string]bool, len(doc.Cues))
+	lastTimecodeMs := int64(-1)
+
+	for i, cueDoc := range doc.Cues {
+		cue, err := parseRundownCue(i, cueDoc)
+		if err != nil {
+			return nil, fmt.Errorf("%w: cue %d: %w", ErrInvalidRundown, i+1, err)
+		}
+
+		if seen[cue.ID] {
+			return nil, fmt.Errorf("%w: duplicate cue id %q", ErrInvalidRundown, cue.ID)
+		}
+
+		seen[cue.ID] = true
+
+		if cue.HasTimecode {
+			if cue.TimecodeMs < lastTimecodeMs {
+				return nil, fmt.Errorf("%w: cue %q timecode is earlier than the previous cue", ErrInvalidRundown, cue.ID)
+			}
+
+			lastTimecodeMs = cue.TimecodeMs
+		}
+
+		rundown.Cues = append(rundown.Cues, cue)
+	}
+
+	return rundown, nil
+}
+
+func parseRundownCue(index int, doc rundownCueDocument) (*infrastructure.RundownCue, error) {
+	cue := &infrastructure.RundownCue{
+		ID:          "",
+		Label:       doc.Label,
+		HasTimecode: false,
+		TimecodeMs:  0,
+		Actions:     make([]*infrastructure.RundownAction, 0, len(doc.Actions)),
+	}
+
+	switch id := doc.ID.(type) {
+	case nil:
+		cue.ID = strconv.Itoa(index + 1)
+	case float64:
+		cue.ID = strconv.FormatFloat(id, 'f', -1, 64)
+	default:
+		cue.ID = fmt.Sprint(id)
+	}
+
+	switch {
+	case doc.TimecodeMs != nil:
+		cue.HasTimecode = true
+		cue.TimecodeMs = *doc.TimecodeMs
+	case doc.Timecode != "":
+		timecodeMs, err := parseTimecode(doc.Timecode)
+		if err != nil {
+			return nil, err
+		}
+
+		cue.HasTimecode = true
+		cue.TimecodeMs = timecodeMs
+	}
+
+	if cue.TimecodeMs < 0 {
+		return nil, errors.New("timecode must not be negative")
+	}
+
+	if len(doc.Actions) == 0 {
+		return nil, errors.New("at least one action is required")
+	}
+
+	for j, actionDoc := range doc.Actions {
+		action, err := parseRundownAction(actionDoc)
+		if err != nil {
+			return nil, fmt.Errorf("action %d: %w", j+1, err)
+		}
+
+		cue.Actions = append(cue.Actions, action)
+	}
+
+	return cue, nil
+}
+
+func parseRundownAction(doc rundownActionDocument) (*infrastructure.RundownAction, error) {
+	action := &infrastructure.RundownAction{Cinematography: nil, PTZ: nil, SwitchSource: nil, FocusPull: nil}
+	count := 0
+
+	if isRawMessageSet(doc.Cinematography) {
+		count++
+
+		action.Cinematography = new(protov1.CinematographyInstruction)
+		if err := protojson.Unmarshal(doc.Cinematography, action.Cinematography); err != nil {
+			return nil, fmt.Errorf("cinematography: %w", err)
+		}
+
+		if action.Cinematography.GetCameraId() == "" {
+			return nil, fmt.Errorf("cinematography: %w", ErrCameraIDRequired)
+		}
+	}
+
+	if isRawMessageSet(doc.PTZ) {
+		count++
+
+		action.PTZ = new(protov1.SendPTZCommandRequest)
+		if err := protojson.Unmarshal(doc.PTZ, action.PTZ); err != nil {
+			return nil, fmt.Errorf("ptz: %w", err)
+		}
+
+		if action.PTZ.GetCameraId() == "" {
+			return nil, fmt.Errorf("ptz: %w", ErrCameraIDRequired)
+		}
+
+		if action.PTZ.GetCommand() == nil {
+			return nil, errors.New("ptz: command is required")
+		}
+	}
+
+	if isRawMessageSet(doc.SwitchSource) {
+		count++
+
+		action.SwitchSource = new(protov1.SwitchSourceRequest)
+		if err := protojson.Unmarshal(doc.SwitchSource, action.SwitchSource); err != nil {
+			return nil, fmt.Errorf("switch_source: %w", err)
+		}
+
+		if action.SwitchSource.GetOutputId() == "" || action.SwitchSource.GetNewSourceCameraId() == "" {
+			return nil, errors.New("switch_source: output_id and new_source_camera_id are required")
+		}
+	}
+
+	if isRawMessageSet(doc.FocusPull) {
+		count++
+
+		action.FocusPull = new(infrastructure.RundownFocusPull)
+		if err := json.Unmarshal(doc.FocusPull, action.FocusPull); err != nil {
+			return nil, fmt.Errorf("focus_pull: %w", err)
+		}
+
+		if action.FocusPull.CameraID == "" {
+			return nil, fmt.Errorf("focus_pull: %w", ErrCameraIDRequired)
+		}
+
+		move := infrastructure.FocusMove{Target: action.FocusPull.Focus, DurationMs: action.FocusPull.DurationMs}
+		if err := move.Validate(); err != nil {
+			return nil, fmt.Errorf("focus_pull: %w", err)
+		}
+	}
+
+	if count != 1 {
+		return nil, errors.New("exactly one of cinematography, ptz, switch_source or focus_pull is required")
+	}
+
+	return action, nil
+}
+
+func isRawMessageSet(raw json.RawMessage) bool {
+	return len(raw) > 0 && string(raw) != "null"
+}
+
+// parseTimecode は "HH:MM:SS"、"MM:SS"（いずれも小数秒可）形式のタイムコードをミリ秒に変換します。
+func parseTimecode(timecode string) (int64, error) {
+	parts := strings.Split(timecode, ":")
+	if len(parts) < 2 || len(parts) > 3 {
+		return 0, fmt.Errorf("invalid timecode %q", timecode)
+	}
+
+	seconds, err := strconv.ParseFloat(parts[len(parts)-1], 64)
+	if err != nil || seconds < 0 || seconds >= 60 {
+		return 0, fmt.Errorf("invalid timecode %q", timecode)
+	}
+
+	totalMs := int64(seconds * float64(time.Second/time.Millisecond))
+	multiplier := int64(time.Minute / time.Millisecond)
+
+	for i := len(parts) - 2; i >= 0; i-- {
+		value, err := strconv.ParseInt(parts[i], 10, 64)
+		if err != nil || value < 0 {
+			return 0, fmt.Errorf("invalid timecode %q", timecode)
+		}
+
+		totalMs += value * multiplier
+		multiplier *= 60
+	}
+
+	return totalMs, nil
+}
@@ -0,0 +1,43 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/anyfld/vistra-operation-control-room/pkg/transport/infrastructure"
+)
+
+type TelemetryInteractor interface {
+	QueryCameraTelemetry(
+		ctx context.Context,
+		query infrastructure.TelemetryQuery,
+	) ([]*infrastructure.TelemetrySample, error)
+}
+
+var ErrInvalidTelemetryQuery = errors.New("invalid telemetry query")
+
+type TelemetryUsecase struct {
+	repo *infrastructure.TelemetryRepo
+}
+
+func NewTelemetryUsecase(repo *infrastructure.TelemetryRepo) *TelemetryUsecase {
+	return &TelemetryUsecase{repo: repo}
+}
+
+// QueryCameraTelemetry はカメラの状態の時系列を古い順に返します。
+func (u *TelemetryUsecase) QueryCameraTelemetry(
+	ctx context.Context,
+	query infrastructure.TelemetryQuery,
+) ([]*infrastructure.TelemetrySample, error) {
+	switch {
+	case query.CameraID == "":
+		return nil, fmt.Errorf("%w: camera_id is required", ErrInvalidTelemetryQuery)
+	case query.UntilMs > 0 && query.UntilMs <= query.SinceMs:
+		return nil, fmt.Errorf("%w: until_ms must be greater than since_ms", ErrInvalidTelemetryQuery)
+	case query.IntervalMs < 0 || query.MaxPoints < 0:
+		return nil, fmt.Errorf("%w: interval_ms and max_points must not be negative", ErrInvalidTelemetryQuery)
+	}
+
+	return u.repo.QueryTelemetry(query), nil
+}
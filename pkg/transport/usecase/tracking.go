@@ -0,0 +1,257 @@
+package usecase
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	protov1 "github.com/anyfld/vistra-operation-control-room/gen/proto/v1"
+	"github.com/anyfld/vistra-operation-control-room/pkg/transport/infrastructure"
+)
+
+const minTrackingCheckInterval = 10 * time.Millisecond
+
+// StartTracking は被写体のパターンマッチングを開始し、検出結果に合わせてカメラを追従させます。
+// PTZ命令はシネマティック枠のタスクとしてPTZキューに追加するため、手動のPTZ命令（Layer 1）が届くと
+// 既存の中断の仕組みで破棄され、追尾は ManualHold の間停止します。
+func (u *FDUsecase) StartTracking(
+	ctx context.Context,
+	cameraID string,
+	subject *protov1.Subject,
+	intervalMs uint32,
+	config infrastructure.TrackingConfig,
+) (*infrastructure.TrackingSession, error) {
+	if cameraID == "" {
+		return nil, fmt.Errorf("%w: camera_id is required", infrastructure.ErrInvalidTrackingConfig)
+	}
+
+	if subject.GetId() == "" {
+		return nil, fmt.Errorf("%w: subject id is required", infrastructure.ErrInvalidSubject)
+	}
+
+	config = config.WithDefaults()
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+
+	sessionID, err := u.StartPatternMatching(ctx, &protov1.StartPatternMatchingRequest{
+		CameraId:       cameraID,
+		TargetSubjects: []*protov1.Subject{subject},
+		IntervalMs:     intervalMs,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	results, err := u.repo.SubscribePatternMatchResults(sessionID)
+	if err != nil {
+		return nil, err
+	}
+
+	session, err := u.repo.StartTracking(cameraID, sessionID, subject, config)
+	if err != nil {
+		u.repo.StopPatternMatching(sessionID)
+
+		return nil, err
+	}
+
+	go u.runTracking(session, results, u.events.Listen(infrastructure.EventTopicTask))
+
+	return session, nil
+}
+
+// StopTracking は追尾を終了します。連続移動中のカメラには停止命令を送ります。
+func (u *FDUsecase) StopTracking(
+	ctx context.Context,
+	trackingID string,
+) (bool, error) {
+	return u.repo.StopTracking(trackingID), nil
+}
+
+func (u *FDUsecase) GetTracking(
+	ctx context.Context,
+	trackingID string,
+) (*infrastructure.TrackingSession, error) {
+	session := u.repo.GetTrackingSession(trackingID)
+	if session == nil {
+		return nil, infrastructure.ErrTrackingNotFound
+	}
+
+	return session, nil
+}
+
+// trackingLoop は1つの追尾セッションの制御状態です。
+type trackingLoop struct {
+	uc         *FDUsecase
+	session    *infrastructure.TrackingSession
+	controller *infrastructure.TrackingController
+	lastSeenAt time.Time
+	lastStepAt time.Time
+	// overrideUntil まで手動のPTZ命令を優先し、追尾の命令を出しません。
+	overrideUntil time.Time
+}
+
+func (u *FDUsecase) runTracking(
+	session *infrastructure.TrackingSession,
+	results <-chan *protov1.StreamPatternMatchResultsResponse,
+	tasks *infrastructure.EventListener,
+) {
+	loop := &trackingLoop{
+		uc:      u,
+		session: session,
+		controller: infrastructure.NewTrackingController(
+			session.Config,
+			u.cameraRepo.GetOptics(session.CameraID),
+		),
+		lastSeenAt:    time.Time{},
+		lastStepAt:    time.Time{},
+		overrideUntil: time.Time{},
+	}
+
+	defer u.events.Unlisten(tasks)
+	defer u.repo.StopPatternMatching(session.SessionID)
+	defer u.repo.StopTracking(session.TrackingID)
+	defer loop.halt()
+
+	ticker := time.NewTicker(max(session.Config.LostTimeout/4, minTrackingCheckInterval)) //nolint:mnd
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-session.Done():
+			return
+		case result, ok := <-results:
+			if !ok {
+				// パターンマッチングが終了した（カメラのオフラインなど）
+				log.Printf("tracking ended: tracking_id=%s camera_id=%s", session.TrackingID, session.CameraID)
+
+				return
+			}
+
+			loop.handleResult(result)
+		case event, ok := <-tasks.Events():
+			if !ok {
+				return
+			}
+
+			loop.handleTaskEvent(event)
+		case <-ticker.C:
+			loop.checkLost()
+		}
+	}
+}
+
+func (l *trackingLoop) handleResult(result *protov1.StreamPatternMatchResultsResponse) {
+	now := time.Now()
+
+	var box *protov1.BoundingBox
+
+	for _, detected := range result.GetDetectedSubjects() {
+		if detected.GetSubject().GetId() == l.session.Subject.GetId() {
+			box = detected.GetDetectedBox()
+
+			break
+		}
+	}
+
+	if box == nil {
+		return
+	}
+
+	l.lastSeenAt = now
+	l.session.MarkSeen(now)
+
+	if l.overridden(now) {
+		return
+	}
+
+	elapsed := time.Duration(0)
+	if !l.lastStepAt.IsZero() {
+		elapsed = now.Sub(l.lastStepAt)
+	}
+
+	l.lastStepAt = now
+
+	command, state := l.controller.Step(box, l.currentPTZ(), elapsed)
+	l.session.SetState(state, l.enqueue(command))
+}
+
+// handleTaskEvent は手動のPTZ命令を検知して追尾を一時停止します。
+// 命令が届いた時点で追尾のタスクは中断されているため、停止命令は送りません。
+func (l *trackingLoop) handleTaskEvent(event *infrastructure.Event) {
+	if event.Type != infrastructure.EventTaskEnqueued || event.CameraID != l.session.CameraID {
+		return
+	}
+
+	task, ok := event.Payload.(*protov1.Task)
+	if !ok || task.GetLayer() != protov1.CommandLayer_COMMAND_LAYER_PTZ {
+		return
+	}
+
+	l.overrideUntil = time.Now().Add(l.session.Config.ManualHold)
+	l.controller.Reset()
+	l.lastStepAt = time.Time{}
+	l.session.SetState(infrastructure.TrackingStateOverridden, "")
+}
+
+// checkLost は LostTimeout の間被写体を検出できていなければカメラを止めます。
+func (l *trackingLoop) checkLost() {
+	now := time.Now()
+
+	if l.overridden(now) || l.lastSeenAt.IsZero() || now.Sub(l.lastSeenAt) < l.session.Config.LostTimeout {
+		return
+	}
+
+	if l.session.Status().State == infrastructure.TrackingStateLost {
+		return
+	}
+
+	taskID := l.enqueue(l.controller.Halt())
+	l.controller.Reset()
+	l.lastStepAt = time.Time{}
+	l.session.SetState(infrastructure.TrackingStateLost, taskID)
+}
+
+// overridden は手動のPTZ命令が優先されている間 true を返します。
+// 手動の命令がPTZ枠に残っている間は、ManualHold を過ぎていても追尾しません。
+func (l *trackingLoop) overridden(now time.Time) bool {
+	if now.Before(l.overrideUntil) || l.uc.ptzRepo.GetQueueStatus(l.session.CameraID).GetPtzQueueSize() > 0 {
+		l.session.SetState(infrastructure.TrackingStateOverridden, "")
+
+		return true
+	}
+
+	return false
+}
+
+func (l *trackingLoop) halt() {
+	l.enqueue(l.controller.Halt())
+}
+
+// enqueue は追尾の命令をカメラの制限で確認してキューに追加します。制限で拒否された命令は追加しません。
+func (l *trackingLoop) enqueue(command *protov1.PTZCommand) string {
+	if command == nil {
+		return ""
+	}
+
+	command, _, err := l.uc.limits.checkPTZCommand(l.session.CameraID, command)
+	if err != nil {
+		log.Printf("tracking command rejected: camera_id=%s err=%v", l.session.CameraID, err)
+
+		return ""
+	}
+
+	taskID, _ := l.uc.ptzRepo.EnqueueTrackingCommand(l.session.CameraID, command)
+
+	return taskID
+}
+
+// currentPTZ はカメラが最後に報告したPTZを返します。
+func (l *trackingLoop) currentPTZ() *protov1.PTZParameters {
+	if ptz := l.uc.cameraRepo.GetCamera(l.session.CameraID).GetCurrentPtz(); ptz != nil {
+		return ptz
+	}
+
+	return l.uc.repo.GetCameraState(l.session.CameraID).GetCurrentPtz()
+}
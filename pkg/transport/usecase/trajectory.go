@@ -0,0 +1,93 @@
+package usecase
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	protov1 "github.com/anyfld/vistra-operation-control-room/gen/proto/v1"
+	"github.com/anyfld/vistra-operation-control-room/pkg/transport/infrastructure"
+)
+
+// PlanPTZTrajectory はPTZの軌道をキーフレームに分割します。spec.From を省略すると、
+// カメラの推定のPTZ（推定できない場合は最後に報告されたPTZ）から開始します。
+// 全てのキーフレームをカメラの制限で確認し、補正が必要な位置を通る軌道は拒否します。
+func (u *FDUsecase) PlanPTZTrajectory(
+	ctx context.Context,
+	cameraID string,
+	spec *infrastructure.PTZTrajectorySpec,
+) (*infrastructure.PTZTrajectory, error) {
+	camera := u.cameraRepo.GetCamera(cameraID)
+	if camera == nil {
+		return nil, fmt.Errorf("%w: %s", ErrCameraNotFound, cameraID)
+	}
+
+	if spec.From == nil {
+		current := u.estimatedPTZ(camera, time.Now().UnixMilli())
+		if current == nil {
+			return nil, fmt.Errorf("%w: from is required until camera %s reports its ptz", ErrCameraPTZUnknown, cameraID)
+		}
+
+		planned := *spec
+		planned.From = current
+		spec = &planned
+	}
+
+	trajectory, err := u.cameraOptics(cameraID).PlanPTZTrajectory(spec)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := u.limits.checkTrajectory(cameraID, trajectory); err != nil {
+		return nil, err
+	}
+
+	return trajectory, nil
+}
+
+// EnqueuePTZTrajectory はPTZの軌道を計算し、PTZキューのタスクとして追加します。
+// layer の扱いは EnqueueArmMove と同じで、シネマティック枠に追加した軌道は前のシネマティック命令の後に実行されます。
+func (u *FDUsecase) EnqueuePTZTrajectory(
+	ctx context.Context,
+	cameraID string,
+	spec *infrastructure.PTZTrajectorySpec,
+	layer protov1.CommandLayer,
+) (string, *infrastructure.PTZTrajectory, error) {
+	trajectory, err := u.PlanPTZTrajectory(ctx, cameraID, spec)
+	if err != nil {
+		return "", nil, err
+	}
+
+	taskID, _ := u.ptzRepo.EnqueueTrajectoryCommand(cameraID, layer, trajectory)
+
+	return taskID, trajectory, nil
+}
+
+// StartTrajectoryDispatcher はPTZの軌道のタスクが実行対象になったときに、キーフレームを
+// 絶対移動または相対移動の制御コマンドとして順にFDへ配信します。ctx が終了するまで動作します。
+// 最後のキーフレームの到達予定時刻にタスクを完了させ、タスクが中断された場合は残りのキーフレームを破棄します。
+func (u *FDUsecase) StartTrajectoryDispatcher(ctx context.Context) {
+	u.startKeyframeDispatcher(ctx, keyframeDispatcher{
+		plan:         u.planTrajectoryKeyframes,
+		outlivesTask: false,
+		supersedes:   nil,
+	})
+}
+
+// planTrajectoryKeyframes は軌道のキーフレームを、直前のキーフレームへの到達予定時刻に送る制御コマンドにします。
+func (u *FDUsecase) planTrajectoryKeyframes(cameraID, taskID string, _ *protov1.Task) *keyframePlan {
+	trajectory := u.ptzRepo.GetPTZTrajectory(taskID)
+	if trajectory == nil {
+		return nil
+	}
+
+	keyframes := make([]keyframe, 0, len(trajectory.Keyframes))
+	for i, frame := range trajectory.Keyframes {
+		keyframes = append(keyframes, keyframe{
+			command: trajectory.ControlCommand(i, fmt.Sprintf("%s-%d", taskID, i+1), cameraID),
+			arrival: time.Duration(frame.OffsetMs) * time.Millisecond,
+		})
+	}
+
+	return &keyframePlan{keyframes: keyframes, finish: keyframeFinishAtArrival, interrupted: nil}
+}